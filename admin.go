@@ -0,0 +1,776 @@
+// admin.go
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/spf13/cobra"
+)
+
+// The users, their tokens and roles, and the namespace quotas that govern
+// 'quad-db serve' live in a system store of their own, a Badger instance
+// next to the namespaces, so that they are shared by every namespace and
+// never travel with a push or a clone:
+//
+//	user:<name>       JSON adminUser
+//	token:<sha256>    the name of the user the token belongs to
+//	quota:<namespace> JSON adminQuota ("" is the default namespace)
+//
+// Only a token's hash is stored; the token itself is shown once, when it
+// is created.
+var systemPath = filepath.Join(dbPath, "system")
+
+var sysDB *badger.DB
+
+// openSystemDB opens the system store, creating it if needed.
+func openSystemDB() (*badger.DB, error) {
+	if sysDB != nil {
+		return sysDB, nil
+	}
+	var err error
+	sysDB, err = badger.Open(badger.DefaultOptions(systemPath).WithLogger(nil))
+	if err != nil && strings.Contains(err.Error(), "Cannot acquire directory lock") {
+		return nil, errors.New("the system store is in use (is 'quad-db serve' running? use its /admin API instead)")
+	}
+	return sysDB, err
+}
+
+func closeSystemDB() {
+	if sysDB != nil {
+		sysDB.Close()
+		sysDB = nil
+	}
+}
+
+// The roles, each allowed everything the ones before it are: readers
+// fetch and read, writers also push and commit, and maintainers also
+// administer users, namespaces and quotas.
+const (
+	roleReader     = "reader"
+	roleWriter     = "writer"
+	roleMaintainer = "maintainer"
+)
+
+var roleRanks = map[string]int{roleReader: 1, roleWriter: 2, roleMaintainer: 3}
+
+func validRole(role string) error {
+	if roleRanks[role] == 0 {
+		return fmt.Errorf("unknown role %q (use reader, writer or maintainer)", role)
+	}
+	return nil
+}
+
+// An adminUser is an account allowed to use the server.
+type adminUser struct {
+	Name    string       `json:"name"`
+	Role    string       `json:"role"`
+	Tokens  []adminToken `json:"tokens,omitempty"`
+	Created time.Time    `json:"created"`
+}
+
+// An adminToken is one of a user's tokens. ID, a prefix of the hash,
+// names the token for revoking it.
+type adminToken struct {
+	ID      string    `json:"id"`
+	Hash    string    `json:"hash,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// An adminQuota limits the disk space a namespace may take before the
+// server refuses writes to it.
+type adminQuota struct {
+	Namespace string `json:"namespace"`
+	MaxBytes  int64  `json:"maxBytes"`
+}
+
+var errNoUser = errors.New("no such user")
+
+func validUserName(name string) error {
+	if name == "" || strings.ContainsAny(name, " \t\n:/") {
+		return fmt.Errorf("%q is not a valid user name", name)
+	}
+	return nil
+}
+
+func getUser(txn *badger.Txn, name string) (adminUser, error) {
+	item, err := txn.Get([]byte("user:" + name))
+	if err == badger.ErrKeyNotFound {
+		return adminUser{}, fmt.Errorf("%w: %s", errNoUser, name)
+	} else if err != nil {
+		return adminUser{}, err
+	}
+	var u adminUser
+	err = item.Value(func(val []byte) error {
+		return json.Unmarshal(val, &u)
+	})
+	return u, err
+}
+
+func putUser(txn *badger.Txn, u adminUser) error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	return txn.Set([]byte("user:"+u.Name), data)
+}
+
+// addUser creates a user with a role.
+func addUser(name, role string) error {
+	if err := validUserName(name); err != nil {
+		return err
+	}
+	if err := validRole(role); err != nil {
+		return err
+	}
+	return sysDB.Update(func(txn *badger.Txn) error {
+		if _, err := getUser(txn, name); err == nil {
+			return fmt.Errorf("user %q already exists", name)
+		} else if !errors.Is(err, errNoUser) {
+			return err
+		}
+		return putUser(txn, adminUser{Name: name, Role: role, Created: time.Now().UTC()})
+	})
+}
+
+// setUserRole changes a user's role.
+func setUserRole(name, role string) error {
+	if err := validRole(role); err != nil {
+		return err
+	}
+	return sysDB.Update(func(txn *badger.Txn) error {
+		u, err := getUser(txn, name)
+		if err != nil {
+			return err
+		}
+		u.Role = role
+		return putUser(txn, u)
+	})
+}
+
+// removeUser deletes a user and all of their tokens.
+func removeUser(name string) error {
+	return sysDB.Update(func(txn *badger.Txn) error {
+		u, err := getUser(txn, name)
+		if err != nil {
+			return err
+		}
+		for _, t := range u.Tokens {
+			if err := txn.Delete([]byte("token:" + t.Hash)); err != nil {
+				return err
+			}
+		}
+		return txn.Delete([]byte("user:" + name))
+	})
+}
+
+// listUsers returns every user, sorted by name.
+func listUsers() ([]adminUser, error) {
+	var users []adminUser
+	err := sysDB.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		prefix := []byte("user:")
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			var u adminUser
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &u)
+			})
+			if err != nil {
+				return err
+			}
+			users = append(users, u)
+		}
+		return nil
+	})
+	return users, err
+}
+
+// hasUsers reports whether any user exists; until one does, the server
+// does not ask for tokens.
+func hasUsers() (bool, error) {
+	found := false
+	err := sysDB.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		it.Seek([]byte("user:"))
+		found = it.ValidForPrefix([]byte("user:"))
+		return nil
+	})
+	return found, err
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// createToken issues a new token for a user and returns it with its ID.
+func createToken(name string) (token, id string, err error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	token = "qdb_" + hex.EncodeToString(secret)
+	hash := hashToken(token)
+	id = hash[:12]
+	err = sysDB.Update(func(txn *badger.Txn) error {
+		u, err := getUser(txn, name)
+		if err != nil {
+			return err
+		}
+		u.Tokens = append(u.Tokens, adminToken{ID: id, Hash: hash, Created: time.Now().UTC()})
+		if err := putUser(txn, u); err != nil {
+			return err
+		}
+		return txn.Set([]byte("token:"+hash), []byte(name))
+	})
+	return token, id, err
+}
+
+// revokeToken deletes one of a user's tokens by its ID.
+func revokeToken(name, id string) error {
+	return sysDB.Update(func(txn *badger.Txn) error {
+		u, err := getUser(txn, name)
+		if err != nil {
+			return err
+		}
+		for i, t := range u.Tokens {
+			if t.ID == id {
+				u.Tokens = append(u.Tokens[:i], u.Tokens[i+1:]...)
+				if err := txn.Delete([]byte("token:" + t.Hash)); err != nil {
+					return err
+				}
+				return putUser(txn, u)
+			}
+		}
+		return fmt.Errorf("user %s has no token %s", name, id)
+	})
+}
+
+// authenticate returns the user a token belongs to.
+func authenticate(token string) (adminUser, error) {
+	var u adminUser
+	err := sysDB.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte("token:" + hashToken(token)))
+		if err == badger.ErrKeyNotFound {
+			return errors.New("invalid token")
+		} else if err != nil {
+			return err
+		}
+		name, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		u, err = getUser(txn, string(name))
+		return err
+	})
+	return u, err
+}
+
+// getQuota returns the quota of a namespace; a zero MaxBytes means none.
+func getQuota(ns string) (adminQuota, error) {
+	q := adminQuota{Namespace: ns}
+	err := sysDB.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte("quota:" + ns))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &q)
+		})
+	})
+	return q, err
+}
+
+// setQuota limits a namespace to maxBytes, or lifts its quota if maxBytes
+// is zero.
+func setQuota(ns string, maxBytes int64) error {
+	return sysDB.Update(func(txn *badger.Txn) error {
+		key := []byte("quota:" + ns)
+		if maxBytes <= 0 {
+			return txn.Delete(key)
+		}
+		data, err := json.Marshal(adminQuota{Namespace: ns, MaxBytes: maxBytes})
+		if err != nil {
+			return err
+		}
+		return txn.Set(key, data)
+	})
+}
+
+// --- Serving ---
+
+type identityKey struct{}
+
+// requestIdentity returns the user a request was authenticated as, or ""
+// if the server does not require tokens.
+func requestIdentity(r *http.Request) string {
+	name, _ := r.Context().Value(identityKey{}).(string)
+	return name
+}
+
+// requiredRole returns the role a request needs: reading, fetching and
+// querying need reader, the admin API maintainer, and everything else,
+// which writes, writer.
+func requiredRole(r *http.Request) string {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/admin/"):
+		return roleMaintainer
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return roleReader
+	case r.Method == http.MethodPost && (r.URL.Path == "/fetch" || r.URL.Path == "/sparql"):
+		return roleReader
+	}
+	return roleWriter
+}
+
+// withAuth requires every request to carry a bearer token of a user whose
+// role allows it, once any user exists. Until then the admin API is
+// refused, so that the first user can only be created from the command
+// line and not by whoever reaches the server first. Writes are refused
+// with 507 Insufficient Storage while the namespace is over its quota.
+func withAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		guarded, err := hasUsers()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		role := requiredRole(r)
+		if !guarded && role == roleMaintainer {
+			http.Error(w, "the admin API is disabled until a user exists; create the first one with 'quad-db admin user add' and 'quad-db admin token create'", http.StatusForbidden)
+			return
+		}
+		if guarded {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="quad-db"`)
+				http.Error(w, "a bearer token is required", http.StatusUnauthorized)
+				return
+			}
+			u, err := authenticate(strings.TrimSpace(token))
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="quad-db", error="invalid_token"`)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			if roleRanks[u.Role] < roleRanks[role] {
+				http.Error(w, fmt.Sprintf("%s needs the %s role; %s is a %s", r.URL.Path, role, u.Name, u.Role), http.StatusForbidden)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), identityKey{}, u.Name))
+		}
+		if role == roleWriter {
+			if err := checkQuota(namespace); err != nil {
+				http.Error(w, err.Error(), http.StatusInsufficientStorage)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkQuota fails if a namespace takes at least its quota of disk space.
+func checkQuota(ns string) error {
+	q, err := getQuota(ns)
+	if err != nil || q.MaxBytes <= 0 {
+		return err
+	}
+	skip := ""
+	if ns == "" {
+		skip = namespacesPath
+	}
+	size, err := dirSize(namespaceDir(ns), skip)
+	if err != nil {
+		return err
+	}
+	if size >= q.MaxBytes {
+		return fmt.Errorf("namespace %s is over its quota: %s of %s", namespaceLabel(ns), formatBytes(size), formatBytes(q.MaxBytes))
+	}
+	return nil
+}
+
+func namespaceLabel(ns string) string {
+	if ns == "" {
+		return "(default)"
+	}
+	return ns
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// adminError answers with the status an admin operation's error calls for.
+func adminError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	if errors.Is(err, errNoUser) {
+		status = http.StatusNotFound
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// handleAdminUsers lists (GET), creates (POST ?name=&role=), changes the
+// role of (PUT ?name=&role=) and removes (DELETE ?name=) users.
+func handleAdminUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		users, err := listUsers()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if users == nil {
+			users = []adminUser{}
+		}
+		for _, u := range users {
+			for i := range u.Tokens {
+				u.Tokens[i].Hash = "" // Not secret, but of no use to anyone
+			}
+		}
+		writeAdminJSON(w, http.StatusOK, users)
+		return
+	case http.MethodPost:
+		err = addUser(q.Get("name"), q.Get("role"))
+	case http.MethodPut:
+		err = setUserRole(q.Get("name"), q.Get("role"))
+	case http.MethodDelete:
+		err = removeUser(q.Get("name"))
+	}
+	if err != nil {
+		adminError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAdminTokens issues (POST ?user=) and revokes (DELETE ?user=&id=)
+// tokens. A new token is in the response and nowhere else.
+func handleAdminTokens(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if r.Method == http.MethodPost {
+		token, id, err := createToken(q.Get("user"))
+		if err != nil {
+			adminError(w, err)
+			return
+		}
+		writeAdminJSON(w, http.StatusCreated, map[string]string{"user": q.Get("user"), "id": id, "token": token})
+		return
+	}
+	if err := revokeToken(q.Get("user"), q.Get("id")); err != nil {
+		adminError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAdminNamespaces lists namespaces with their size and quota (GET)
+// or creates one (POST ?name=).
+func handleAdminNamespaces(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		name := r.URL.Query().Get("name")
+		if err := validNamespaceName(name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := os.Stat(namespaceDir(name)); err == nil {
+			http.Error(w, fmt.Sprintf("namespace %q already exists", name), http.StatusConflict)
+			return
+		}
+		// The server holds the database of its own namespace open, so
+		// the new one is initialized by a child process of its own.
+		self, err := os.Executable()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if out, err := exec.Command(self, "namespace", "create", name).CombinedOutput(); err != nil {
+			http.Error(w, fmt.Sprintf("%v: %s", err, strings.TrimSpace(string(out))), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	names, err := namespaceNames()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	type entry struct {
+		Name     string `json:"name"`
+		Bytes    int64  `json:"bytes"`
+		MaxBytes int64  `json:"maxBytes,omitempty"`
+	}
+	entries := []entry{}
+	for _, name := range append([]string{""}, names...) {
+		size, err := dirSize(namespaceDir(name), namespacesPath)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		q, err := getQuota(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		entries = append(entries, entry{Name: name, Bytes: size, MaxBytes: q.MaxBytes})
+	}
+	writeAdminJSON(w, http.StatusOK, entries)
+}
+
+// handleAdminQuotas shows (GET), sets (PUT ?max=<size>) and lifts (DELETE)
+// the quota of the namespace given as ?namespace=, the default one if
+// omitted.
+func handleAdminQuotas(w http.ResponseWriter, r *http.Request) {
+	ns := r.URL.Query().Get("namespace")
+	if ns != "" {
+		if err := validNamespaceName(ns); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		q, err := getQuota(ns)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, q)
+		return
+	case http.MethodPut:
+		var max int64
+		if max, err = parseByteSize(r.URL.Query().Get("max")); err == nil {
+			err = setQuota(ns, max)
+		}
+	case http.MethodDelete:
+		err = setQuota(ns, 0)
+	}
+	if err != nil {
+		adminError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func registerAdminHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/admin/users", handleAdminUsers)
+	mux.HandleFunc("POST /admin/tokens", handleAdminTokens)
+	mux.HandleFunc("DELETE /admin/tokens", handleAdminTokens)
+	mux.HandleFunc("GET /admin/namespaces", handleAdminNamespaces)
+	mux.HandleFunc("POST /admin/namespaces", handleAdminNamespaces)
+	mux.HandleFunc("/admin/quotas", handleAdminQuotas)
+}
+
+// --- Commands ---
+
+var adminCmd = &cobra.Command{
+	Use:   "admin",
+	Short: "Manage the users, roles and quotas of 'quad-db serve'",
+	Long: `Manage who may use 'quad-db serve' and how much space its namespaces may
+take. These settings live in a system store in .quad-db/system, shared by
+all namespaces, and are never pushed or cloned.
+
+Each user has a role: a reader may fetch, read and query; a writer may
+also push and commit; a maintainer may also use the admin API. Users
+authenticate with bearer tokens, created by 'admin token create' and shown
+only then. As long as no user exists, the server asks for no token, but
+refuses the admin API and by default listens on localhost only: the first
+user and token must be created here, with the server stopped.
+
+While the server runs, it holds the system store, and the same operations
+are available to maintainers over HTTP:
+
+  GET|POST|PUT|DELETE /admin/users?name=<n>[&role=<r>]
+  POST|DELETE /admin/tokens?user=<n>[&id=<id>]
+  GET|POST /admin/namespaces[?name=<n>]
+  GET|PUT|DELETE /admin/quotas?namespace=<n>[&max=<size>]`,
+}
+
+var adminUserCmd = &cobra.Command{
+	Use:   "user",
+	Short: "Add, list and remove users and change their roles",
+}
+
+var adminUserAddCmd = &cobra.Command{
+	Use:   "add <name> --role <role>",
+	Short: "Add a user; create a token for them with 'admin token create'",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		role, _ := cmd.Flags().GetString("role")
+		if err := addUser(args[0], role); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Added %s as %s\n", args[0], role)
+	},
+}
+
+var adminUserListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List users with their roles and tokens",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		users, err := listUsers()
+		if err != nil {
+			log.Fatal(err)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, u := range users {
+			ids := make([]string, len(u.Tokens))
+			for i, t := range u.Tokens {
+				ids[i] = t.ID
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", u.Name, u.Role, strings.Join(ids, " "))
+		}
+		tw.Flush()
+	},
+}
+
+var adminUserRoleCmd = &cobra.Command{
+	Use:   "role <name> <role>",
+	Short: "Change a user's role",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := setUserRole(args[0], args[1]); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s is now a %s\n", args[0], args[1])
+	},
+}
+
+var adminUserRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Remove a user and revoke all their tokens",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := removeUser(args[0]); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Removed %s\n", args[0])
+	},
+}
+
+var adminTokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Create and revoke users' tokens",
+}
+
+var adminTokenCreateCmd = &cobra.Command{
+	Use:   "create <user>",
+	Short: "Create a token for a user and print it",
+	Long: `Create a token for a user and print it. The token is not stored, only a
+hash of it, so this is the only time it is shown. Clients send it as
+'Authorization: Bearer <token>'; 'quad-db' itself reads it from the
+QUAD_DB_TOKEN environment variable or the transfer.token option.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		token, id, err := createToken(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintf(os.Stderr, "Created token %s for %s; it will not be shown again.\n", id, args[0])
+		fmt.Println(token)
+	},
+}
+
+var adminTokenRevokeCmd = &cobra.Command{
+	Use:   "revoke <user> <id>",
+	Short: "Revoke one of a user's tokens",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := revokeToken(args[0], args[1]); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Revoked token %s of %s\n", args[1], args[0])
+	},
+}
+
+var adminQuotaCmd = &cobra.Command{
+	Use:   "quota",
+	Short: "Show and set the selected namespace's disk quota",
+	Long: `Show and set the disk quota of the selected namespace (see --namespace).
+While a namespace takes at least its quota, the server refuses pushes and
+commits to it with 507 Insufficient Storage; reads still work.`,
+}
+
+var adminQuotaShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show every namespace's size and quota",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		names, err := namespaceNames()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, name := range append([]string{""}, names...) {
+			size, err := dirSize(namespaceDir(name), namespacesPath)
+			if err != nil {
+				log.Fatal(err)
+			}
+			q, err := getQuota(name)
+			if err != nil {
+				log.Fatal(err)
+			}
+			limit := "no quota"
+			if q.MaxBytes > 0 {
+				limit = "of " + formatBytes(q.MaxBytes)
+			}
+			fmt.Printf("%-20s %s %s\n", namespaceLabel(name), formatBytes(size), limit)
+		}
+	},
+}
+
+var adminQuotaSetCmd = &cobra.Command{
+	Use:   "set <size>",
+	Short: "Limit the namespace to a size, such as 10GiB",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		max, err := parseByteSize(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		if max <= 0 {
+			log.Fatal("A quota must be positive; use 'admin quota unset' to lift it.")
+		}
+		if err := setQuota(namespace, max); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Namespace %s is limited to %s\n", namespaceLabel(namespace), formatBytes(max))
+	},
+}
+
+var adminQuotaUnsetCmd = &cobra.Command{
+	Use:   "unset",
+	Short: "Lift the namespace's quota",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := setQuota(namespace, 0); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Namespace %s has no quota\n", namespaceLabel(namespace))
+	},
+}
@@ -0,0 +1,70 @@
+// admin_test.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithAuth(t *testing.T) {
+	newTestRepo(t)
+	if _, err := openSystemDB(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(closeSystemDB)
+	h := withAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	do := func(method, path, token string) int {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Without users, nothing is authenticated, so the admin API is shut.
+	for _, tt := range []struct {
+		method, path string
+		want         int
+	}{
+		{"GET", "/refs", http.StatusOK},
+		{"POST", "/admin/users?name=evil&role=maintainer", http.StatusForbidden},
+		{"POST", "/admin/tokens?user=evil", http.StatusForbidden},
+	} {
+		if got := do(tt.method, tt.path, ""); got != tt.want {
+			t.Errorf("without users, %s %s = %d, want %d", tt.method, tt.path, got, tt.want)
+		}
+	}
+
+	if err := addUser("admin", roleMaintainer); err != nil {
+		t.Fatal(err)
+	}
+	if err := addUser("reader", roleReader); err != nil {
+		t.Fatal(err)
+	}
+	adminToken, _, err := createToken("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	readerToken, _, err := createToken("reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		method, path, token string
+		want                int
+	}{
+		{"GET", "/refs", "", http.StatusUnauthorized},
+		{"GET", "/refs", "qdb_forged", http.StatusUnauthorized},
+		{"GET", "/refs", readerToken, http.StatusOK},
+		{"POST", "/push", readerToken, http.StatusForbidden},
+		{"POST", "/admin/users?name=x&role=reader", readerToken, http.StatusForbidden},
+		{"POST", "/admin/users?name=x&role=reader", adminToken, http.StatusOK},
+	} {
+		if got := do(tt.method, tt.path, tt.token); got != tt.want {
+			t.Errorf("with users, %s %s = %d, want %d", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// alternate.go
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mannyrivera2010/go-quadgit/internal/transport"
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+)
+
+// core.alternate names a remote whose objects this repository can use as
+// its own: any object, commit, tree or blob, that is not stored locally is
+// fetched from it when first read and kept, so later reads are local. A
+// thin clone (clone --thin) starts with nothing but references and relies
+// on its alternate, typically a central server, for everything else.
+
+// alternate is the connection to the alternate remote.
+var alternate objectSource
+
+// alternateRemote returns the name of the remote missing objects are read
+// through to, or "" if there is none.
+func alternateRemote() string {
+	cfg, err := loadConfig()
+	if err != nil {
+		return ""
+	}
+	return cfg.Get("core.alternate")
+}
+
+// readThrough calls read, which reads the object hash; if the object is
+// missing and an alternate is configured, it is fetched from there and read
+// is called again.
+func readThrough(hash string, read func() error) error {
+	err := read()
+	if !errors.Is(err, quadstore.ErrNotFound) {
+		return err
+	}
+	name := alternateRemote()
+	if name == "" {
+		return err
+	}
+	if ferr := alternate.fetch(name, []string{hash}); ferr != nil {
+		return fmt.Errorf("%w (fetching it from alternate %s failed: %v)", err, name, ferr)
+	}
+	return read()
+}
+
+// shareAlternate makes reads through to the alternate use t, a connection a
+// fetch or push has open to the remote name, rather than a second one: over
+// ssh and to local paths, only one connection at a time can open the remote
+// repository. It returns a function that undoes this.
+func shareAlternate(name string, t transport.Transport) func() {
+	if name == "" || name != alternateRemote() {
+		return func() {}
+	}
+	alternate.close()
+	alternate.conn = t
+	return func() { alternate.conn = nil }
+}
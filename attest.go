@@ -0,0 +1,260 @@
+// attest.go
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/kmssign"
+	"github.com/spf13/cobra"
+)
+
+// Attestations are in-toto Statements (https://in-toto.io/Statement/v1)
+// whose predicate is SLSA provenance (https://slsa.dev/provenance/v1): the
+// subjects are the dataset a commit holds and each of its graphs, digested
+// over their canonical N-Quads, and the provenance records the commit as
+// the build that produced them, its parents and graph blobs as the
+// materials, and its signature.
+const (
+	inTotoStatementType  = "https://in-toto.io/Statement/v1"
+	slsaProvenanceType   = "https://slsa.dev/provenance/v1"
+	attestBuildType      = "https://github.com/mannyrivera2010/go-quadgit/commit/v1"
+	defaultAttestBuilder = "https://github.com/mannyrivera2010/go-quadgit"
+	inTotoPayloadType    = "application/vnd.in-toto+json"
+)
+
+type inTotoStatement struct {
+	Type          string             `json:"_type"`
+	Subject       []inTotoDescriptor `json:"subject"`
+	PredicateType string             `json:"predicateType"`
+	Predicate     slsaProvenance     `json:"predicate"`
+}
+
+// An inTotoDescriptor is an in-toto ResourceDescriptor.
+type inTotoDescriptor struct {
+	Name      string            `json:"name,omitempty"`
+	URI       string            `json:"uri,omitempty"`
+	Digest    map[string]string `json:"digest,omitempty"`
+	MediaType string            `json:"mediaType,omitempty"`
+	Content   []byte            `json:"content,omitempty"`
+
+	Annotations map[string]interface{} `json:"annotations,omitempty"`
+}
+
+type slsaProvenance struct {
+	BuildDefinition struct {
+		BuildType            string                 `json:"buildType"`
+		ExternalParameters   map[string]interface{} `json:"externalParameters"`
+		InternalParameters   map[string]interface{} `json:"internalParameters,omitempty"`
+		ResolvedDependencies []inTotoDescriptor     `json:"resolvedDependencies,omitempty"`
+	} `json:"buildDefinition"`
+	RunDetails struct {
+		Builder struct {
+			ID string `json:"id"`
+		} `json:"builder"`
+		Metadata struct {
+			InvocationID string    `json:"invocationId"`
+			StartedOn    time.Time `json:"startedOn"`
+			FinishedOn   time.Time `json:"finishedOn"`
+		} `json:"metadata"`
+		Byproducts []inTotoDescriptor `json:"byproducts,omitempty"`
+	} `json:"runDetails"`
+}
+
+// datasetDigests returns the SHA-256 of each graph's canonical N-Quads,
+// and of the whole dataset's: all its statements, sorted, one per line, as
+// 'export' writes them and 'sort | sha256sum' would digest them.
+func datasetDigests(state map[string]quadSet) (string, map[string]string) {
+	var all []string
+	digests := make(map[string]string, len(state))
+	for graph, set := range state {
+		if len(set) == 0 {
+			continue
+		}
+		h := sha256.New()
+		for _, line := range graphQuads(graph, set) {
+			io.WriteString(h, line+"\n")
+			all = append(all, line)
+		}
+		digests[graph] = hex.EncodeToString(h.Sum(nil))
+	}
+	sort.Strings(all)
+	whole := sha256.New()
+	for _, line := range all {
+		io.WriteString(whole, line+"\n")
+	}
+	return hex.EncodeToString(whole.Sum(nil)), digests
+}
+
+// buildAttestation describes a commit, reached through ref, as the build
+// of its dataset.
+func buildAttestation(ref, hash, builder string) (*inTotoStatement, error) {
+	commit, err := readCommit(hash)
+	if err != nil {
+		return nil, err
+	}
+	state, err := loadState(hash)
+	if err != nil {
+		return nil, err
+	}
+	graphs, err := readGraphs(commit.Tree)
+	if err != nil {
+		return nil, err
+	}
+
+	st := &inTotoStatement{Type: inTotoStatementType, PredicateType: slsaProvenanceType}
+	whole, digests := datasetDigests(state)
+	st.Subject = append(st.Subject, inTotoDescriptor{Name: "dataset", Digest: map[string]string{"sha256": whole}})
+	names := make([]string, 0, len(digests))
+	for graph := range digests {
+		names = append(names, graph)
+	}
+	sort.Strings(names)
+	for _, graph := range names {
+		st.Subject = append(st.Subject, inTotoDescriptor{Name: strings.Trim(graph, "<>"), Digest: map[string]string{"sha256": digests[graph]}})
+	}
+
+	p := &st.Predicate
+	p.BuildDefinition.BuildType = attestBuildType
+	p.BuildDefinition.ExternalParameters = map[string]interface{}{
+		"ref":     ref,
+		"commit":  hash,
+		"message": commit.Message,
+	}
+	internal := map[string]interface{}{"author": commit.Author, "tree": commit.Tree}
+	if len(commit.Metadata) > 0 {
+		internal["metadata"] = commit.Metadata
+	}
+	p.BuildDefinition.InternalParameters = internal
+	for _, parent := range commit.Parents {
+		p.BuildDefinition.ResolvedDependencies = append(p.BuildDefinition.ResolvedDependencies,
+			inTotoDescriptor{Name: "parent", URI: "quadgit:commit:" + parent, Digest: map[string]string{"sha1": parent}})
+	}
+	blobs := make([]string, 0, len(graphs))
+	for graph := range graphs {
+		blobs = append(blobs, graph)
+	}
+	sort.Strings(blobs)
+	for _, graph := range blobs {
+		p.BuildDefinition.ResolvedDependencies = append(p.BuildDefinition.ResolvedDependencies,
+			inTotoDescriptor{Name: strings.Trim(graph, "<>"), URI: "quadgit:blob:" + graphs[graph], Digest: map[string]string{"sha1": graphs[graph]}})
+	}
+
+	p.RunDetails.Builder.ID = builder
+	p.RunDetails.Metadata.InvocationID = "quadgit:commit:" + hash
+	p.RunDetails.Metadata.StartedOn = commit.Timestamp.UTC()
+	p.RunDetails.Metadata.FinishedOn = commit.Timestamp.UTC()
+	if commit.Signature != "" {
+		mediaType := "application/pgp-signature"
+		if kmssign.IsArmored(commit.Signature) {
+			mediaType = "application/vnd.quadgit.kms-signature"
+		}
+		p.RunDetails.Byproducts = append(p.RunDetails.Byproducts, inTotoDescriptor{
+			Name:        "commit-signature",
+			MediaType:   mediaType,
+			Content:     []byte(commit.Signature),
+			Annotations: map[string]interface{}{"verified": verifyCommit(commit) == nil},
+		})
+	}
+	return st, nil
+}
+
+// A dsseEnvelope is a signed attestation (https://github.com/secure-systems-lab/dsse).
+type dsseEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     []byte          `json:"payload"`
+	Signatures  []dsseSignature `json:"signatures"`
+}
+
+type dsseSignature struct {
+	KeyID string `json:"keyid,omitempty"`
+	Sig   []byte `json:"sig"`
+}
+
+// dssePAE is the DSSE pre-authentication encoding, what is actually signed.
+func dssePAE(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+var attestCmd = &cobra.Command{
+	Use:   "attest [<ref>]",
+	Short: "Describe a commit as an in-toto attestation for supply-chain tools",
+	Long: `Write an in-toto Statement about the commit <ref> (default HEAD) points
+at, with SLSA provenance as its predicate, so that a versioned dataset can
+be checked by the same supply-chain pipelines as software artifacts.
+
+The subjects are the dataset and each of its graphs, each with the SHA-256
+of its canonical N-Quads: every statement, with its graph, sorted. Anyone
+can recompute them from an export. The provenance names the builder
+(--builder-id, attest.builderId, or this project), the ref and commit as
+parameters, the parent commits and graph blobs as materials, and carries
+the commit's signature, if any, as a byproduct.
+
+With --sign, the statement is wrapped in a DSSE envelope signed with the
+user.signingBackend, gpg or a KMS key, as commits are.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ref := "HEAD"
+		if len(args) == 1 {
+			ref = args[0]
+		}
+		hash, err := resolveCommitish(ref)
+		if err != nil {
+			log.Fatalf("Could not resolve %s: %v", ref, err)
+		}
+		builder, _ := cmd.Flags().GetString("builder-id")
+		if builder == "" {
+			cfg, err := loadConfig()
+			if err != nil {
+				log.Fatalf("Failed to load config: %v", err)
+			}
+			if builder = cfg.Get("attest.builderId"); builder == "" {
+				builder = defaultAttestBuilder
+			}
+		}
+		st, err := buildAttestation(ref, hash, builder)
+		if err != nil {
+			log.Fatalf("Failed to describe %s: %v", ref, err)
+		}
+
+		var out interface{} = st
+		if sign, _ := cmd.Flags().GetBool("sign"); sign {
+			payload, err := json.Marshal(st)
+			if err != nil {
+				log.Fatal(err)
+			}
+			sig, err := signPayload(dssePAE(inTotoPayloadType, payload))
+			if err != nil {
+				log.Fatalf("Failed to sign the attestation: %v", err)
+			}
+			keyID := ""
+			if cfg, err := loadConfig(); err == nil {
+				keyID = cfg.Get("user.signingKey")
+			}
+			out = dsseEnvelope{PayloadType: inTotoPayloadType, Payload: payload, Signatures: []dsseSignature{{KeyID: keyID, Sig: []byte(sig)}}}
+		}
+
+		w := io.Writer(os.Stdout)
+		if path, _ := cmd.Flags().GetString("output"); path != "" && path != "-" {
+			f, err := os.Create(path)
+			if err != nil {
+				log.Fatalf("Failed to create %s: %v", path, err)
+			}
+			defer f.Close()
+			w = f
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(out); err != nil {
+			log.Fatalf("Failed to write the attestation: %v", err)
+		}
+	},
+}
@@ -0,0 +1,163 @@
+// authz.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+)
+
+// authorizer is consulted before every commit, merge and received push.
+// Embedders of the store supply their own through quadstore.OpenOptions;
+// the CLI enforces the access.* settings.
+var authorizer quadstore.Authorizer = configAuthorizer{}
+
+// configAuthorizer grants writes from the access.<identity>.branches and
+// access.<identity>.graphs settings, each a comma- or space-separated list
+// of branch names or graph IRI prefixes, or "*" for any. A missing setting
+// allows any branch or graph, but once any access.* setting exists, an
+// identity with none of its own may not write at all. Without access.*
+// settings every write is allowed.
+type configAuthorizer struct{}
+
+func (configAuthorizer) Authorize(ctx context.Context, req quadstore.AccessRequest) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	restricted, known := false, false
+	for _, key := range cfg.Keys() {
+		if strings.HasPrefix(key, "access.") {
+			restricted = true
+			known = known || strings.HasPrefix(key, "access."+req.Identity+".")
+		}
+	}
+	if !restricted {
+		return nil
+	}
+	if req.Identity == "" || !known {
+		return fmt.Errorf("%w: no access.* settings grant %q the right to %s (identities come from user.email, or for pushes from the user the server authenticated)", quadstore.ErrPermissionDenied, req.Identity, req.Action)
+	}
+	allowed := func(setting, value string, match func(pattern, value string) bool) bool {
+		patterns, ok := cfg.Lookup("access." + req.Identity + "." + setting)
+		if !ok {
+			return true
+		}
+		for _, p := range strings.FieldsFunc(patterns, func(r rune) bool { return r == ',' || r == ' ' }) {
+			if p == "*" || match(p, value) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, ref := range req.Refs {
+		branch, ok := strings.CutPrefix(ref, "refs/heads/")
+		if ok && !allowed("branches", branch, func(p, v string) bool { return p == v }) {
+			return fmt.Errorf("%w: %s may not write to branch %s", quadstore.ErrPermissionDenied, req.Identity, branch)
+		}
+	}
+	for _, graph := range req.Graphs {
+		iri := strings.Trim(graph, "<>")
+		if !allowed("graphs", iri, func(p, v string) bool { return strings.HasPrefix(v, strings.Trim(p, "<>")) }) {
+			return fmt.Errorf("%w: %s may not change graph %s", quadstore.ErrPermissionDenied, req.Identity, graph)
+		}
+	}
+	return nil
+}
+
+// publicRefName returns the name the public API uses for a stored
+// reference, e.g. "refs/heads/main" for "head:main".
+func publicRefName(ref string) string {
+	for prefix, public := range map[string]string{"head:": "refs/heads/", "tag:": "refs/tags/", "remote:": "refs/remotes/"} {
+		if name, ok := strings.CutPrefix(ref, prefix); ok {
+			return public + name
+		}
+	}
+	return ref
+}
+
+// commitGraphs returns the graph-name to blob-hash mapping of a commit, or
+// an empty one for an empty hash.
+func commitGraphs(hash string) (map[string]string, error) {
+	if hash == "" {
+		return map[string]string{}, nil
+	}
+	commit, err := readCommit(hash)
+	if err != nil {
+		return nil, err
+	}
+	return readGraphs(commit.Tree)
+}
+
+// changedGraphs returns the sorted names of the graphs whose content
+// differs between commits from and to, either of which may be empty.
+func changedGraphs(from, to string) ([]string, error) {
+	before, err := commitGraphs(from)
+	if err != nil {
+		return nil, err
+	}
+	after, err := commitGraphs(to)
+	if err != nil {
+		return nil, err
+	}
+	var graphs []string
+	for graph, blob := range after {
+		if before[graph] != blob {
+			graphs = append(graphs, graph)
+		}
+	}
+	for graph := range before {
+		if _, ok := after[graph]; !ok {
+			graphs = append(graphs, graph)
+		}
+	}
+	sort.Strings(graphs)
+	return graphs, nil
+}
+
+// actingIdentity is who the local user is for authorization: user.email.
+func actingIdentity() string {
+	cfg, err := loadConfig()
+	if err != nil {
+		return ""
+	}
+	return cfg.Get("user.email")
+}
+
+// authorizeWrite asks the authorizer whether identity may move ref from
+// commit from to commit to, and if ref is a protected branch, checks that
+// the owners of the graphs changed have approved (see owners.go). A new
+// commit cannot have been reviewed yet, so commits straight to a protected
+// branch may only change graphs without owners. The new commit must already be stored, which is harmless
+// while nothing refers to it, or staged (see Repository.stageObjects).
+func authorizeWrite(action quadstore.Action, identity, ref, from, to string) error {
+	graphs, err := changedGraphs(from, to)
+	if err != nil {
+		return err
+	}
+	if authorizer != nil {
+		err := authorizer.Authorize(context.Background(), quadstore.AccessRequest{
+			Identity: identity,
+			Action:   action,
+			Refs:     []string{publicRefName(ref)},
+			Graphs:   graphs,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return checkOwnerApproval(ref, from, to, graphs)
+}
+
+// checkApproval is the owner approval check of authorizeWrite alone, for
+// writes whose authorizer was asked as their changes came in.
+func checkApproval(ref, from, to string) error {
+	graphs, err := changedGraphs(from, to)
+	if err != nil {
+		return err
+	}
+	return checkOwnerApproval(ref, from, to, graphs)
+}
@@ -0,0 +1,91 @@
+// authz_test.go
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+)
+
+func TestProtectedBranchApproval(t *testing.T) {
+	newTestRepo(t)
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Set("policy.protected", "main")
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(ownersPath, []byte("<http://example.org/owned/*> alice@example.org\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	root, err := resolveCommitish("main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	owned := writeTestCommit(t, map[string][]string{
+		"<http://example.org/owned/g>": {`<http://example.org/s> <http://example.org/p> "o" <http://example.org/owned/g> .`},
+	}, root)
+	reviewed := writeTestCommit(t, map[string][]string{
+		"<http://example.org/owned/g>": {`<http://example.org/s> <http://example.org/p> "reviewed" <http://example.org/owned/g> .`},
+	}, root)
+	if err := recordReview(review{Commit: reviewed, Reviewer: "alice@example.org", State: reviewApproved, Time: clock.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	unowned := writeTestCommit(t, map[string][]string{
+		"<http://example.org/free>": {`<http://example.org/s> <http://example.org/p> "o" <http://example.org/free> .`},
+	}, root)
+
+	tests := []struct {
+		name    string
+		action  quadstore.Action
+		ref     string
+		to      string
+		wantErr bool
+	}{
+		{"commit of an owned graph to a protected branch", quadstore.ActionCommit, "head:main", owned, true},
+		{"commit of an unowned graph to a protected branch", quadstore.ActionCommit, "head:main", unowned, false},
+		{"commit of an owned graph to another branch", quadstore.ActionCommit, "head:topic", owned, false},
+		{"push of an unreviewed change", quadstore.ActionPush, "head:main", owned, true},
+		{"push of an approved change", quadstore.ActionPush, "head:main", reviewed, false},
+		{"merge of an approved change", quadstore.ActionMerge, "head:main", reviewed, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := authorizeWrite(tt.action, "bob@example.org", tt.ref, root, tt.to)
+			if (err != nil) != tt.wantErr || err != nil && !errors.Is(err, quadstore.ErrPermissionDenied) {
+				t.Errorf("authorizeWrite(%s, %s) = %v, want error: %v", tt.action, tt.ref, err, tt.wantErr)
+			}
+		})
+	}
+
+	for _, tt := range []struct {
+		name    string
+		branch  string
+		graphs  []string
+		wantErr bool
+	}{
+		{"owned graph on a protected branch", "main", []string{"<http://example.org/free>", "<http://example.org/owned/g>"}, true},
+		{"unowned graph on a protected branch", "main", []string{"<http://example.org/free>"}, false},
+		{"owned graph on another branch", "topic", []string{"<http://example.org/owned/g>"}, false},
+	} {
+		t.Run("change set: "+tt.name, func(t *testing.T) {
+			if err := checkUnreviewedChange(tt.branch, tt.graphs); (err != nil) != tt.wantErr {
+				t.Errorf("checkUnreviewedChange(%s, %v) = %v, want error: %v", tt.branch, tt.graphs, err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("commitReplacingGraphs", func(t *testing.T) {
+		graphs := map[string]quadSet{"<http://example.org/owned/g>": {`<http://example.org/s> <http://example.org/p> "direct" <http://example.org/owned/g> .`: true}}
+		if _, err := commitReplacingGraphs("main", "", graphs, "direct", nil, "alice@example.org"); !errors.Is(err, quadstore.ErrPermissionDenied) {
+			t.Errorf("commit straight to main: %v, want ErrPermissionDenied", err)
+		}
+		if head, _ := getReference("head:main"); head != root {
+			t.Errorf("main moved to %s", shortHash(head))
+		}
+	})
+}
@@ -0,0 +1,447 @@
+// batch.go
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+)
+
+// Writers that send many small updates, such as sensors, POST them to
+// /changes as change sets. Each is authorized and checked for secrets as it
+// arrives; with 'serve --batch-window', those for the same branch are then
+// held until the window has passed since the first of them and committed
+// together, each recorded as a Change-Set trailer of the one commit, so
+// that history grows by commits rather than by readings.
+
+// maxChangeSetAttempts bounds how often a batch is rebuilt on a branch head
+// that moved while it was being committed.
+const maxChangeSetAttempts = 5
+
+// A changeSet is one POST /changes: statements added and deleted, in
+// stored triple form, by graph.
+type changeSet struct {
+	id       string
+	identity string
+	received time.Time
+	added    map[string]quadSet
+	deleted  map[string]quadSet
+
+	done chan batchResult
+}
+
+// A batchResult is the outcome of the commit a change set went into: its
+// hash, or "" if the batch changed nothing, and the branch head then.
+type batchResult struct {
+	hash string
+	head string
+	err  error
+}
+
+// graphs returns the sorted names of the graphs a change set touches.
+func (cs *changeSet) graphs() []string {
+	var graphs []string
+	for graph := range cs.added {
+		graphs = append(graphs, graph)
+	}
+	for graph := range cs.deleted {
+		if _, ok := cs.added[graph]; !ok {
+			graphs = append(graphs, graph)
+		}
+	}
+	sort.Strings(graphs)
+	return graphs
+}
+
+func (cs *changeSet) count(sets map[string]quadSet) int {
+	n := 0
+	for _, set := range sets {
+		n += len(set)
+	}
+	return n
+}
+
+// trailer records a change set in the commit it went into.
+func (cs *changeSet) trailer() quadstore.Trailer {
+	value := fmt.Sprintf("%s +%d -%d at %s", cs.id, cs.count(cs.added), cs.count(cs.deleted), cs.received.UTC().Format(time.RFC3339Nano))
+	if cs.identity != "" {
+		value += " by " + cs.identity
+	}
+	return quadstore.Trailer{Key: "Change-Set", Value: value}
+}
+
+// parseChangeSet reads a change set in the form 'diff' prints: lines of
+// "+ <quad>" and "- <quad>". Graph headers, comments and blank lines are
+// skipped; quads without a graph term belong to the default graph.
+func parseChangeSet(r *http.Request) (*changeSet, error) {
+	cs := &changeSet{added: map[string]quadSet{}, deleted: map[string]quadSet{}}
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "graph ") {
+			continue
+		}
+		target := cs.added
+		switch line[0] {
+		case '+':
+		case '-':
+			target = cs.deleted
+		default:
+			return nil, fmt.Errorf("line %d: expected '+ <quad>' or '- <quad>'", n)
+		}
+		q, err := parseQuad(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		graph := graphKey(q)
+		q.Graph = ""
+		if target[graph] == nil {
+			target[graph] = quadSet{}
+		}
+		target[graph][formatQuad(q)] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(cs.added) == 0 && len(cs.deleted) == 0 {
+		return nil, errors.New("the change set is empty")
+	}
+	return cs, nil
+}
+
+// newChangeSetID returns an ID for a change set whose writer gave none.
+func newChangeSetID() string {
+	var b [6]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// A changeBatcher collects change sets per branch until they are
+// committed. With no window, each is committed on its own at once.
+type changeBatcher struct {
+	window time.Duration
+	max    int
+
+	mu      sync.Mutex
+	pending map[string][]*changeSet
+	timers  map[string]*time.Timer
+}
+
+// batcher is configured by 'serve --batch-window' and '--batch-max'.
+var batcher = &changeBatcher{}
+
+// submit queues a change set for branch and waits for the commit it goes
+// into.
+func (b *changeBatcher) submit(branch string, cs *changeSet) batchResult {
+	cs.done = make(chan batchResult, 1)
+	if b.window <= 0 {
+		commitChangeSets(branch, []*changeSet{cs})
+		return <-cs.done
+	}
+	b.mu.Lock()
+	if b.pending == nil {
+		b.pending, b.timers = map[string][]*changeSet{}, map[string]*time.Timer{}
+	}
+	b.pending[branch] = append(b.pending[branch], cs)
+	if b.max > 0 && len(b.pending[branch]) >= b.max {
+		batch := b.take(branch)
+		b.mu.Unlock()
+		go commitChangeSets(branch, batch)
+	} else {
+		if b.timers[branch] == nil {
+			b.timers[branch] = time.AfterFunc(b.window, func() { b.flush(branch) })
+		}
+		b.mu.Unlock()
+	}
+	return <-cs.done
+}
+
+// take removes and returns the change sets pending for branch. b.mu must
+// be held.
+func (b *changeBatcher) take(branch string) []*changeSet {
+	batch := b.pending[branch]
+	delete(b.pending, branch)
+	if t := b.timers[branch]; t != nil {
+		t.Stop()
+		delete(b.timers, branch)
+	}
+	return batch
+}
+
+// flush commits the change sets pending for branch once its window ends.
+func (b *changeBatcher) flush(branch string) {
+	b.mu.Lock()
+	batch := b.take(branch)
+	b.mu.Unlock()
+	if len(batch) > 0 {
+		commitChangeSets(branch, batch)
+	}
+}
+
+// commitChangeSets applies a batch of change sets, in the order they
+// arrived, to the graphs they touch on branch, commits the result, and
+// reports it to each of them. Change sets are applied to whatever the
+// branch holds when the batch is committed, so a batch is rebuilt if the
+// branch moves meanwhile.
+func commitChangeSets(branch string, batch []*changeSet) {
+	var result batchResult
+	for attempt := 1; ; attempt++ {
+		result = commitChangeSetsOnce(branch, batch)
+		if !errors.Is(result.err, quadstore.ErrStaleParent) || attempt == maxChangeSetAttempts {
+			break
+		}
+	}
+	for _, cs := range batch {
+		cs.done <- result
+	}
+}
+
+// applyChangeSets applies change sets in order to state, which is left
+// alone, and returns the new versions of the graphs they touch.
+func applyChangeSets(state map[string]quadSet, batch []*changeSet) map[string]quadSet {
+	graphs := map[string]quadSet{}
+	for _, cs := range batch {
+		for _, graph := range cs.graphs() {
+			set, ok := graphs[graph]
+			if !ok {
+				set = make(quadSet, len(state[graph]))
+				for line := range state[graph] {
+					set[line] = true
+				}
+				graphs[graph] = set
+			}
+			for line := range cs.deleted[graph] {
+				delete(set, line)
+			}
+			for line := range cs.added[graph] {
+				set[line] = true
+			}
+		}
+	}
+	return graphs
+}
+
+func commitChangeSetsOnce(branch string, batch []*changeSet) batchResult {
+	head, err := getReference("head:" + branch)
+	if err != nil {
+		return batchResult{err: fmt.Errorf("branch %s: %w", branch, err)}
+	}
+	state, err := loadState(head)
+	if err != nil {
+		return batchResult{err: err}
+	}
+	graphs := applyChangeSets(state, batch)
+	trailers := make([]quadstore.Trailer, 0, len(batch))
+	for _, cs := range batch {
+		trailers = append(trailers, cs.trailer())
+	}
+
+	message := "Apply change set over HTTP"
+	if len(batch) > 1 {
+		message = fmt.Sprintf("Apply %d change sets over HTTP", len(batch))
+	}
+	message = quadstore.AppendTrailers(message, trailers...)
+	metadata := map[string]string{"change-sets": strconv.Itoa(len(batch))}
+	hash, err := commitGraphsWith(branch, head, graphs, message, metadata, checkApproval)
+	return batchResult{hash: hash, head: head, err: err}
+}
+
+// handlePostChanges applies a change set to a branch (the current branch
+// by default): POST /changes[?branch=<name>][&id=<id>]. The body holds
+// "+ <quad>" and "- <quad>" lines; the change set is named by id or the
+// Change-Id header, or is given a random name. The response, once the
+// commit holding the change set is made, carries its hash.
+func handlePostChanges(w http.ResponseWriter, r *http.Request) {
+	branch := r.URL.Query().Get("branch")
+	if branch == "" {
+		branch = currentBranch()
+	}
+	if branch == "" {
+		http.Error(w, "HEAD is detached; give a branch parameter", http.StatusBadRequest)
+		return
+	}
+	if _, err := getReference("head:" + branch); err != nil {
+		http.Error(w, fmt.Sprintf("branch %s: %v", branch, err), http.StatusNotFound)
+		return
+	}
+	cs, err := parseChangeSet(r)
+	if err != nil {
+		http.Error(w, "invalid change set: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	cs.identity = requestIdentity(r)
+	cs.received = clock.Now()
+	if cs.id = r.URL.Query().Get("id"); cs.id == "" {
+		cs.id = r.Header.Get("Change-Id")
+	}
+	if cs.id = strings.Join(strings.Fields(cs.id), "-"); cs.id == "" {
+		cs.id = newChangeSetID()
+	}
+
+	if authorizer != nil {
+		err := authorizer.Authorize(context.Background(), quadstore.AccessRequest{
+			Identity: cs.identity,
+			Action:   quadstore.ActionCommit,
+			Refs:     []string{publicRefName("head:" + branch)},
+			Graphs:   cs.graphs(),
+		})
+		if err != nil {
+			http.Error(w, err.Error(), commitErrorStatus(err))
+			return
+		}
+	}
+	if err := checkUnreviewedChange(branch, cs.graphs()); err != nil {
+		http.Error(w, err.Error(), commitErrorStatus(err))
+		return
+	}
+	if err := checkSecrets(map[string]quadSet{}, cs.added); err != nil {
+		http.Error(w, err.Error(), commitErrorStatus(err))
+		return
+	}
+
+	result := batcher.submit(branch, cs)
+	if result.err != nil {
+		http.Error(w, result.err.Error(), commitErrorStatus(result.err))
+		return
+	}
+	w.Header().Set("Change-Id", cs.id)
+	if result.hash == "" {
+		w.Header().Set("ETag", etag(result.head))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("ETag", etag(result.hash))
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintln(w, result.hash)
+}
+
+// A commitRequest is the body of POST /commit: statements to add to and
+// delete from each graph, by graph name, and the commit they apply to.
+type commitRequest struct {
+	Parent   string                 `json:"parent"`
+	Message  string                 `json:"message"`
+	Metadata map[string]string      `json:"metadata,omitempty"`
+	Graphs   map[string]graphChange `json:"graphs"`
+}
+
+// A graphChange lists N-Quads (or N-Triples) statements; those with a graph
+// term must name the graph they are listed under.
+type graphChange struct {
+	Add    []string `json:"add,omitempty"`
+	Delete []string `json:"delete,omitempty"`
+}
+
+// changeSet turns a commit request into a change set, checking every
+// statement in it.
+func (req *commitRequest) changeSet() (*changeSet, error) {
+	cs := &changeSet{added: map[string]quadSet{}, deleted: map[string]quadSet{}}
+	for name, change := range req.Graphs {
+		graph := normalizeGraphName(name)
+		for _, part := range []struct {
+			lines  []string
+			target map[string]quadSet
+		}{{change.Add, cs.added}, {change.Delete, cs.deleted}} {
+			for i, line := range part.lines {
+				q, err := parseQuad(strings.TrimSpace(line))
+				if err != nil {
+					return nil, fmt.Errorf("graph %s, statement %d: %v", graph, i+1, err)
+				}
+				if q.Graph != "" && q.Graph != graph {
+					return nil, fmt.Errorf("graph %s, statement %d: in graph %s", graph, i+1, q.Graph)
+				}
+				q.Graph = ""
+				if part.target[graph] == nil {
+					part.target[graph] = quadSet{}
+				}
+				part.target[graph][formatQuad(q)] = true
+			}
+		}
+	}
+	if len(cs.added) == 0 && len(cs.deleted) == 0 {
+		return nil, errors.New("the commit changes no graph")
+	}
+	return cs, nil
+}
+
+// handlePostCommit applies the changes to several graphs in a JSON
+// commitRequest as one commit on a branch (the current branch by default):
+// POST /commit[?branch=<name>]. The commit is made on parent, and only if
+// the branch still points at it; otherwise nothing is written and the
+// response is 412, so a client either gets all its changes committed
+// together or none.
+func handlePostCommit(w http.ResponseWriter, r *http.Request) {
+	branch := r.URL.Query().Get("branch")
+	if branch == "" {
+		branch = currentBranch()
+	}
+	if branch == "" {
+		http.Error(w, "HEAD is detached; give a branch parameter", http.StatusBadRequest)
+		return
+	}
+	head, err := getReference("head:" + branch)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("branch %s: %v", branch, err), http.StatusNotFound)
+		return
+	}
+	var req commitRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, "invalid commit request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Parent == "" {
+		http.Error(w, "invalid commit request: no parent", http.StatusBadRequest)
+		return
+	}
+	cs, err := req.changeSet()
+	if err != nil {
+		http.Error(w, "invalid commit request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Parent != head {
+		preconditionFailed(w, head)
+		return
+	}
+	state, err := loadState(req.Parent)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	graphs := applyChangeSets(state, []*changeSet{cs})
+	message := req.Message
+	if message == "" {
+		message = fmt.Sprintf("Change %d graph(s) over HTTP", len(graphs))
+	}
+	hash, err := commitReplacingGraphs(branch, req.Parent, graphs, message, req.Metadata, requestIdentity(r))
+	if errors.Is(err, quadstore.ErrStaleParent) {
+		current, _ := getReference("head:" + branch)
+		preconditionFailed(w, current)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), commitErrorStatus(err))
+		return
+	}
+	if hash == "" {
+		w.Header().Set("ETag", etag(head))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("ETag", etag(hash))
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintln(w, hash)
+}
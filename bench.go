@@ -0,0 +1,287 @@
+// bench.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/mannyrivera2010/go-quadgit/internal/lru"
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+	"github.com/spf13/cobra"
+)
+
+// benchLatency summarizes the durations of repeated operations.
+type benchLatency struct {
+	Count  int     `json:"count"`
+	MeanMS float64 `json:"mean_ms"`
+	P50MS  float64 `json:"p50_ms"`
+	P95MS  float64 `json:"p95_ms"`
+	MaxMS  float64 `json:"max_ms"`
+}
+
+func summarize(durations []time.Duration) benchLatency {
+	if len(durations) == 0 {
+		return benchLatency{}
+	}
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+	ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+	return benchLatency{
+		Count:  len(sorted),
+		MeanMS: ms(total / time.Duration(len(sorted))),
+		P50MS:  ms(sorted[len(sorted)/2]),
+		P95MS:  ms(sorted[len(sorted)*95/100]),
+		MaxMS:  ms(sorted[len(sorted)-1]),
+	}
+}
+
+// benchReport is the JSON document bench prints.
+type benchReport struct {
+	Config struct {
+		Quads   int     `json:"quads"`
+		Graphs  int     `json:"graphs"`
+		Commits int     `json:"commits"`
+		Change  float64 `json:"change"`
+		Queries int     `json:"queries"`
+		Seed    int64   `json:"seed"`
+	} `json:"config"`
+	Environment struct {
+		Go   string `json:"go"`
+		OS   string `json:"os"`
+		Arch string `json:"arch"`
+		CPUs int    `json:"cpus"`
+	} `json:"environment"`
+	Ingest struct {
+		Quads       int     `json:"quads"`
+		Seconds     float64 `json:"seconds"`
+		QuadsPerSec float64 `json:"quads_per_sec"`
+	} `json:"ingest"`
+	InitialCommitSeconds float64      `json:"initial_commit_seconds"`
+	Commit               benchLatency `json:"commit"`
+	Diff                 struct {
+		benchLatency
+		ChangesPerSec float64 `json:"changes_per_sec"`
+	} `json:"diff"`
+	LoadStateSeconds float64      `json:"load_state_seconds"`
+	Query            benchLatency `json:"query"`
+	QueryMatches     int          `json:"query_matches"`
+	ObjectCache      lru.Stats    `json:"object_cache"`
+}
+
+// benchQuads writes n synthetic quads spread over graphs graphs.
+func benchQuads(rng *rand.Rand, n, graphs int) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&buf, "<http://bench.example/s%d> <http://bench.example/p%d> \"value %d\" <http://bench.example/g%d> .\n",
+			i/5, rng.Intn(20), rng.Int63(), i%graphs)
+	}
+	return buf.Bytes()
+}
+
+// commitBenchState records state on top of parent as the tip of main, the
+// way 'commit' does, and returns the new commit's hash.
+func commitBenchState(parent string, before, after map[string]quadSet, message string) (string, error) {
+	tree, err := writeState(after)
+	if err != nil {
+		return "", err
+	}
+	commit := Commit{Tree: tree, Parents: []string{parent}, Author: "bench", Message: message, Timestamp: time.Now()}
+	if commit.Stats, err = computeStats(before, after); err != nil {
+		return "", err
+	}
+	hash, err := writeObject(commit)
+	if err != nil {
+		return "", err
+	}
+	return hash, swapReference("head:main", parent, hash)
+}
+
+var benchCmd = &cobra.Command{
+	Use:   "bench",
+	Short: "Measure ingest, commit, diff and query performance on this machine",
+	Long: `Generate synthetic quads in a scratch repository and measure the ingest
+rate, commit latency, diff throughput and pattern-query latency, printing a
+JSON report suitable for tracking performance across versions, hardware
+and configurations. The current repository is not touched.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		var report benchReport
+		cfg := &report.Config
+		cfg.Quads, _ = cmd.Flags().GetInt("quads")
+		cfg.Graphs, _ = cmd.Flags().GetInt("graphs")
+		cfg.Commits, _ = cmd.Flags().GetInt("commits")
+		cfg.Change, _ = cmd.Flags().GetFloat64("change")
+		cfg.Queries, _ = cmd.Flags().GetInt("queries")
+		cfg.Seed, _ = cmd.Flags().GetInt64("seed")
+		if cfg.Quads < 1 || cfg.Graphs < 1 || cfg.Change <= 0 || cfg.Change > 1 {
+			log.Fatal("--quads and --graphs must be positive and --change in (0, 1].")
+		}
+		report.Environment.Go = runtime.Version()
+		report.Environment.OS = runtime.GOOS
+		report.Environment.Arch = runtime.GOARCH
+		report.Environment.CPUs = runtime.NumCPU()
+		rng := rand.New(rand.NewSource(cfg.Seed))
+
+		dir, err := os.MkdirTemp("", "quad-db-bench-")
+		if err != nil {
+			log.Fatalf("Failed to create scratch directory: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		defer closeDB()
+		if err := os.Chdir(dir); err != nil {
+			log.Fatal(err)
+		}
+		if err := initRepository(); err != nil {
+			log.Fatalf("Failed to create scratch repository: %v", err)
+		}
+		root, err := resolveHead()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintf(os.Stderr, "Benchmarking in %s...\n", dir)
+
+		// Ingest: parse and group generated N-Quads, as 'import' does
+		data := benchQuads(rng, cfg.Quads, cfg.Graphs)
+		start := time.Now()
+		runs, err := newDumpRuns(dumpRunSize)
+		if err != nil {
+			log.Fatalf("Ingest failed: %v", err)
+		}
+		n, _, err := readDump(cmd.Context(), bytes.NewReader(data), "bench", "", nil, nil, runs, nil)
+		var state map[string]quadSet
+		if err == nil {
+			state, err = runs.state()
+		}
+		runs.Close()
+		if err != nil {
+			log.Fatalf("Ingest failed: %v", err)
+		}
+		elapsed := time.Since(start)
+		report.Ingest.Quads = n
+		report.Ingest.Seconds = elapsed.Seconds()
+		report.Ingest.QuadsPerSec = float64(n) / elapsed.Seconds()
+
+		start = time.Now()
+		head, err := commitBenchState(root, map[string]quadSet{}, state, "bench: initial load")
+		if err != nil {
+			log.Fatalf("Initial commit failed: %v", err)
+		}
+		report.InitialCommitSeconds = time.Since(start).Seconds()
+
+		// Commits: each replaces a fraction of one graph's quads
+		graphs := make([]string, 0, len(state))
+		for g := range state {
+			graphs = append(graphs, g)
+		}
+		sort.Strings(graphs)
+		var commitTimes, diffTimes []time.Duration
+		changes := 0
+		hashes := []string{head}
+		for i := 0; i < cfg.Commits; i++ {
+			graph := graphs[i%len(graphs)]
+			old := state[graph]
+			lines := make([]string, 0, len(old))
+			for line := range old {
+				lines = append(lines, line)
+			}
+			sort.Strings(lines)
+			m := max(1, int(float64(len(lines))*cfg.Change))
+			updated := make(quadSet, len(old))
+			for line := range old {
+				updated[line] = true
+			}
+			for _, j := range rng.Perm(len(lines))[:min(m, len(lines))] {
+				delete(updated, lines[j])
+				q := quadstore.Quad{
+					Subject:   fmt.Sprintf("<http://bench.example/c%d-%d>", i, j),
+					Predicate: "<http://bench.example/changed>",
+					Object:    fmt.Sprintf("\"%d\"", rng.Int63()),
+				}
+				updated[formatQuad(q)] = true
+			}
+			next := make(map[string]quadSet, len(state))
+			for g, set := range state {
+				next[g] = set
+			}
+			next[graph] = updated
+
+			start = time.Now()
+			if head, err = commitBenchState(head, state, next, fmt.Sprintf("bench: change %d", i+1)); err != nil {
+				log.Fatalf("Commit failed: %v", err)
+			}
+			commitTimes = append(commitTimes, time.Since(start))
+			state = next
+			hashes = append(hashes, head)
+		}
+		report.Commit = summarize(commitTimes)
+
+		// Diff each commit against its parent
+		var diffTotal time.Duration
+		for _, h := range hashes[1:] {
+			commit, err := readCommit(h)
+			if err != nil {
+				log.Fatal(err)
+			}
+			start = time.Now()
+			diffs, err := commitDiffs(commit)
+			if err != nil {
+				log.Fatalf("Diff failed: %v", err)
+			}
+			d := time.Since(start)
+			diffTimes = append(diffTimes, d)
+			diffTotal += d
+			for _, gd := range diffs {
+				changes += len(gd.Added) + len(gd.Deleted)
+			}
+		}
+		report.Diff.benchLatency = summarize(diffTimes)
+		if diffTotal > 0 {
+			report.Diff.ChangesPerSec = float64(changes) / diffTotal.Seconds()
+		}
+
+		// Queries: match subject patterns against the state at the tip
+		start = time.Now()
+		tip, err := loadState(head)
+		if err != nil {
+			log.Fatalf("Failed to load state: %v", err)
+		}
+		report.LoadStateSeconds = time.Since(start).Seconds()
+		var queryTimes []time.Duration
+		for i := 0; i < cfg.Queries; i++ {
+			p, err := parsePattern(fmt.Sprintf("<http://bench.example/s%d> ?p ?o", rng.Intn(max(1, cfg.Quads/5))))
+			if err != nil {
+				log.Fatal(err)
+			}
+			start = time.Now()
+			var matched []string
+			for graph, set := range tip {
+				for line := range set {
+					if q, err := parseQuad(line); err == nil && p.matches(q, graph) {
+						matched = append(matched, line)
+					}
+				}
+			}
+			report.QueryMatches += len(matched)
+			queryTimes = append(queryTimes, time.Since(start))
+		}
+		report.Query = summarize(queryTimes)
+		report.ObjectCache = repo.cacheStats()
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
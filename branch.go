@@ -0,0 +1,558 @@
+// branch.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+	"github.com/spf13/cobra"
+)
+
+// Per-branch settings live in the config under branch.<name>.*:
+// description is free text, and remote and merge name the remote and the
+// branch on it that the branch tracks.
+
+// upstream returns the remote and remote branch that branch tracks, or
+// empty strings if it has no upstream.
+func upstream(branch string) (remote, merge string, err error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return "", "", err
+	}
+	remote, merge = cfg.Get("branch."+branch+".remote"), cfg.Get("branch."+branch+".merge")
+	if remote == "" || merge == "" {
+		return "", "", nil
+	}
+	return remote, merge, nil
+}
+
+// setUpstream makes branch track merge on remote; empty values remove the
+// upstream.
+func setUpstream(branch, remote, merge string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if remote == "" {
+		cfg.Unset("branch." + branch + ".remote")
+		cfg.Unset("branch." + branch + ".merge")
+	} else {
+		cfg.Set("branch."+branch+".remote", remote)
+		cfg.Set("branch."+branch+".merge", merge)
+	}
+	return cfg.Save()
+}
+
+// validBranchName rejects names that could not be used in revisions, such
+// as ones containing the ~ and ^ ancestry operators, or in refspecs.
+func validBranchName(name string) error {
+	switch {
+	case name == "" || name == "HEAD" || strings.HasPrefix(name, "-"),
+		strings.ContainsAny(name, " \t\n~^:?*[\\"),
+		strings.Contains(name, ".."), strings.Contains(name, "//"),
+		strings.HasPrefix(name, "/"), strings.HasSuffix(name, "/"), strings.HasSuffix(name, "."):
+		return fmt.Errorf("%q is not a valid branch name", name)
+	}
+	return nil
+}
+
+// trackingRef is the remote-tracking reference for a branch on a remote.
+func trackingRef(remote, branch string) string {
+	return "remote:" + remote + "/" + branch
+}
+
+// aheadBehind counts the commits reachable from local but not upstream, and
+// the reverse.
+func aheadBehind(local, upstream string) (ahead, behind int, err error) {
+	fromLocal, err := ancestors(local)
+	if err != nil {
+		return 0, 0, err
+	}
+	fromUpstream, err := ancestors(upstream)
+	if err != nil {
+		return 0, 0, err
+	}
+	for h := range fromLocal {
+		if !fromUpstream[h] {
+			ahead++
+		}
+	}
+	for h := range fromUpstream {
+		if !fromLocal[h] {
+			behind++
+		}
+	}
+	return ahead, behind, nil
+}
+
+// tracking describes how branch relates to its upstream. ok is false if the
+// branch has no upstream; gone is true if the remote-tracking ref does not
+// exist, e.g. because the remote was never fetched.
+type tracking struct {
+	ok, gone      bool
+	name          string // e.g. "origin/main"
+	ahead, behind int
+}
+
+func branchTracking(branch string) (tracking, error) {
+	remote, merge, err := upstream(branch)
+	if err != nil || remote == "" {
+		return tracking{}, err
+	}
+	t := tracking{ok: true, name: remote + "/" + merge}
+	local, err := getReference("head:" + branch)
+	if err != nil {
+		return tracking{}, err
+	}
+	theirs, err := getReference(trackingRef(remote, merge))
+	if err != nil {
+		t.gone = true
+		return t, nil
+	}
+	t.ahead, t.behind, err = aheadBehind(local, theirs)
+	return t, err
+}
+
+// short renders t as branch -vv does, e.g. "[origin/main: ahead 1, behind 2]".
+func (t tracking) short() string {
+	var counts []string
+	switch {
+	case t.gone:
+		counts = append(counts, "gone")
+	default:
+		if t.ahead > 0 {
+			counts = append(counts, fmt.Sprintf("ahead %d", t.ahead))
+		}
+		if t.behind > 0 {
+			counts = append(counts, fmt.Sprintf("behind %d", t.behind))
+		}
+	}
+	if len(counts) == 0 {
+		return "[" + t.name + "]"
+	}
+	return "[" + t.name + ": " + strings.Join(counts, ", ") + "]"
+}
+
+// long renders t as status does.
+func (t tracking) long() string {
+	plural := func(n int) string {
+		if n == 1 {
+			return "1 commit"
+		}
+		return fmt.Sprintf("%d commits", n)
+	}
+	switch {
+	case t.gone:
+		return fmt.Sprintf("Your branch is based on '%s', but it has not been fetched.", t.name)
+	case t.ahead > 0 && t.behind > 0:
+		return fmt.Sprintf("Your branch and '%s' have diverged,\nand have %d and %d different commits each, respectively.", t.name, t.ahead, t.behind)
+	case t.ahead > 0:
+		return fmt.Sprintf("Your branch is ahead of '%s' by %s.", t.name, plural(t.ahead))
+	case t.behind > 0:
+		return fmt.Sprintf("Your branch is behind '%s' by %s.", t.name, plural(t.behind))
+	}
+	return fmt.Sprintf("Your branch is up to date with '%s'.", t.name)
+}
+
+// createBranch points a new branch at a commit.
+func createBranch(name, hash string) error {
+	if err := validBranchName(name); err != nil {
+		return err
+	}
+	if err := swapReference("head:"+name, "", hash); errors.Is(err, quadstore.ErrStaleParent) {
+		return fmt.Errorf("a branch named %s already exists", name)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// deleteBranch removes a branch and its settings. Unless force is set, the
+// branch must be merged into HEAD, so that no commit is lost with it.
+func deleteBranch(name string, force bool) error {
+	if name == currentBranch() {
+		return fmt.Errorf("cannot delete %s, the branch HEAD is on", name)
+	}
+	if err := checkUnprotected(name); err != nil {
+		return err
+	}
+	tip, err := getReference("head:" + name)
+	if err != nil {
+		return fmt.Errorf("no branch named %s", name)
+	}
+	if !force {
+		head, err := resolveHead()
+		if err != nil {
+			return err
+		}
+		reachable, err := ancestors(head)
+		if err != nil {
+			return err
+		}
+		if !reachable[tip] {
+			return fmt.Errorf("branch %s is not merged into HEAD; use -D to delete it anyway", name)
+		}
+	}
+	if err := deleteReference("head:" + name); err != nil {
+		return err
+	}
+	return moveBranchConfig(name, "")
+}
+
+// renameBranch renames a branch, carrying its settings along, and keeps
+// HEAD on it if it was the current branch.
+func renameBranch(old, new string) error {
+	if err := validBranchName(new); err != nil {
+		return err
+	}
+	if err := checkUnprotected(old); err != nil {
+		return err
+	}
+	if _, err := getReference("head:" + old); err != nil {
+		return fmt.Errorf("no branch named %s", old)
+	}
+	if err := renameReference("head:"+old, "head:"+new); err != nil {
+		return err
+	}
+	return moveBranchConfig(old, new)
+}
+
+// checkUnprotected refuses to delete or rename a branch that
+// policy.protected covers.
+func checkUnprotected(name string) error {
+	protected, err := protectedBranch(name)
+	if err != nil {
+		return err
+	}
+	if protected {
+		return fmt.Errorf("branch %s is protected by policy.protected", name)
+	}
+	return nil
+}
+
+// moveBranchConfig moves the branch.<old>.* settings to branch.<new>.*, or
+// drops them if new is empty.
+func moveBranchConfig(old, new string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	prefix := "branch." + old + "."
+	for _, key := range cfg.Keys() {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if new != "" {
+			cfg.Set("branch."+new+"."+strings.TrimPrefix(key, prefix), cfg.Get(key))
+		}
+		cfg.Unset(key)
+	}
+	return cfg.Save()
+}
+
+var branchCmd = &cobra.Command{
+	Use:   "branch [<name> [<start>]]",
+	Short: "List, create, delete and rename branches",
+	Long: `List local branches, marking the current one with '*'. -v adds each
+branch's tip and subject, -vv also its upstream with ahead/behind counts
+(as of the last fetch) and its description.
+
+'branch <name> [<start>]' creates a branch at a commit (HEAD by default)
+without switching to it. -d <name> deletes a branch that is merged into
+HEAD, -D one whatever it holds. -m [<old>] <new> renames a branch, the
+current one by default; HEAD and the branch's settings follow it.
+Branches that policy.protected covers cannot be deleted or renamed.
+
+--set-upstream-to <remote>/<branch> makes a branch (the current one by
+default) track a remote branch; push, fetch and pull then need no
+arguments. --edit-description opens an editor on the branch's description.`,
+	Args: cobra.MaximumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		del, _ := cmd.Flags().GetBool("delete")
+		forceDel, _ := cmd.Flags().GetBool("force-delete")
+		if del || forceDel {
+			if len(args) == 0 {
+				log.Fatal("Name the branch to delete.")
+			}
+			for _, name := range args {
+				tip, _ := getReference("head:" + name)
+				if err := deleteBranch(name, forceDel); err != nil {
+					log.Fatalf("Failed to delete %s: %v", name, err)
+				}
+				fmt.Printf("Deleted branch %s (was %s).\n", name, shortHash(tip))
+			}
+			return
+		}
+		if move, _ := cmd.Flags().GetBool("move"); move {
+			old, new := currentBranch(), ""
+			switch len(args) {
+			case 1:
+				new = args[0]
+			case 2:
+				old, new = args[0], args[1]
+			default:
+				log.Fatal("Name the new branch name.")
+			}
+			if old == "" {
+				log.Fatal("HEAD is not on a branch; name the branch to rename.")
+			}
+			if err := renameBranch(old, new); err != nil {
+				log.Fatalf("Failed to rename %s: %v", old, err)
+			}
+			fmt.Printf("Renamed branch %s to %s.\n", old, new)
+			return
+		}
+		if len(args) == 2 {
+			start, err := resolveCommitish(args[1])
+			if err != nil {
+				log.Fatalf("Could not resolve %s: %v", args[1], err)
+			}
+			if err := createBranch(args[0], start); err != nil {
+				log.Fatalf("Failed to create branch: %v", err)
+			}
+			return
+		}
+
+		branch := currentBranch()
+		if len(args) == 1 {
+			branch = args[0]
+		}
+		if cmd.Flags().Changed("set-upstream-to") || cmd.Flags().Changed("unset-upstream") || cmd.Flags().Changed("edit-description") {
+			if branch == "" {
+				log.Fatal("HEAD is not on a branch; name one.")
+			}
+			if _, err := getReference("head:" + branch); err != nil {
+				log.Fatalf("No branch named %s.", branch)
+			}
+		}
+
+		if to, _ := cmd.Flags().GetString("set-upstream-to"); to != "" {
+			remote, merge, ok := strings.Cut(to, "/")
+			if !ok || remote == "" || merge == "" {
+				log.Fatalf("Invalid upstream %q (expected <remote>/<branch>).", to)
+			}
+			if _, err := remoteURL(remote); err != nil {
+				log.Fatal(err)
+			}
+			if err := setUpstream(branch, remote, merge); err != nil {
+				log.Fatalf("Failed to save config: %v", err)
+			}
+			fmt.Printf("Branch '%s' set up to track '%s'.\n", branch, to)
+			return
+		}
+		if unset, _ := cmd.Flags().GetBool("unset-upstream"); unset {
+			if err := setUpstream(branch, "", ""); err != nil {
+				log.Fatalf("Failed to save config: %v", err)
+			}
+			return
+		}
+		if edit, _ := cmd.Flags().GetBool("edit-description"); edit {
+			cfg, err := loadConfig()
+			if err != nil {
+				log.Fatalf("Failed to load config: %v", err)
+			}
+			key := "branch." + branch + ".description"
+			text, err := editMessage(cfg.Get(key) + "\n# Describe the branch " + branch + ".\n# Lines starting with '#' are ignored.\n")
+			if err != nil {
+				log.Fatalf("Failed to edit description: %v", err)
+			}
+			if text == "" {
+				cfg.Unset(key)
+			} else {
+				cfg.Set(key, text)
+			}
+			if err := cfg.Save(); err != nil {
+				log.Fatalf("Failed to save config: %v", err)
+			}
+			return
+		}
+		if len(args) == 1 {
+			head, err := resolveHead()
+			if err != nil {
+				log.Fatalf("Failed to resolve HEAD: %v", err)
+			}
+			if err := createBranch(args[0], head); err != nil {
+				log.Fatalf("Failed to create branch: %v", err)
+			}
+			return
+		}
+
+		heads, err := listReferences("head:")
+		if err != nil {
+			log.Fatalf("Failed to list branches: %v", err)
+		}
+		names := make([]string, 0, len(heads))
+		width := 0
+		for ref := range heads {
+			name := strings.TrimPrefix(ref, "head:")
+			names = append(names, name)
+			width = max(width, len(name))
+		}
+		sort.Strings(names)
+		verbose, _ := cmd.Flags().GetCount("verbose")
+		cfg, err := loadConfig()
+		if err != nil {
+			log.Fatalf("Failed to load config: %v", err)
+		}
+		for _, name := range names {
+			marker := " "
+			if name == branch {
+				marker = "*"
+			}
+			if verbose == 0 {
+				fmt.Printf("%s %s\n", marker, name)
+				continue
+			}
+			hash := heads["head:"+name]
+			line := fmt.Sprintf("%s %-*s %s", marker, width, name, shortHash(hash))
+			if verbose > 1 {
+				t, err := branchTracking(name)
+				if err != nil {
+					log.Fatalf("Failed to compare %s with its upstream: %v", name, err)
+				}
+				if t.ok {
+					line += " " + t.short()
+				}
+			}
+			if commit, err := readCommit(hash); err == nil {
+				line += " " + strings.SplitN(commit.Message, "\n", 2)[0]
+			}
+			fmt.Println(line)
+			if desc := cfg.Get("branch." + name + ".description"); verbose > 1 && desc != "" {
+				for _, l := range strings.Split(desc, "\n") {
+					fmt.Printf("  %-*s %s\n", width, "", l)
+				}
+			}
+		}
+	},
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the current branch, its upstream and what is staged",
+	Long: `Show the current branch, how it compares with its upstream, and what is
+staged: the quads each graph would gain and lose, and graphs that would be
+renamed, if the index were committed now. If a merge stopped at conflicts,
+say so and list the graphs in conflict, with how many of their conflicts
+are unresolved. If a working export exists (see export), also list the
+graphs in it that differ from HEAD. Graphs are compared by the checksums
+tree entries record, so no blob of HEAD is read for them.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		branch := currentBranch()
+		if branch == "" {
+			head, _ := resolveHead()
+			fmt.Printf("HEAD detached at %s\n", shortHash(head))
+		} else {
+			fmt.Printf("On branch %s\n", branch)
+			t, err := branchTracking(branch)
+			if err != nil {
+				log.Fatalf("Failed to compare %s with its upstream: %v", branch, err)
+			}
+			if t.ok {
+				fmt.Println(t.long())
+			}
+		}
+		printMergeStatus(os.Stdout)
+
+		staged, _ := os.ReadFile(indexPath)
+		renames, err := readStagedRenames()
+		if err != nil {
+			log.Fatalf("Failed to read staged renames: %v", err)
+		}
+		rules, err := loadIgnoreRules()
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", ignorePath, err)
+		}
+		lines, ignored := 0, 0
+		for _, line := range strings.Split(string(staged), "\n") {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			if rules.ignoresLine("", line) {
+				ignored++
+				continue
+			}
+			lines++
+		}
+		fmt.Println()
+		if lines == 0 && len(renames) == 0 {
+			fmt.Println("Nothing staged.")
+		} else {
+			fmt.Printf("Staged: %d quad line(s) and %d graph rename(s).\n", lines, len(renames))
+			printStagedChanges()
+		}
+		if ignored > 0 {
+			fmt.Printf("%d staged quad line(s) match %s but will still be committed.\n", ignored, ignorePath)
+		}
+
+		dir, err := exportDir()
+		if err != nil {
+			log.Fatalf("Failed to load config: %v", err)
+		}
+		head, err := resolveHead()
+		if _, statErr := os.Stat(dir); statErr != nil || err != nil || head == "" {
+			return
+		}
+		commit, err := readCommit(head)
+		if err != nil {
+			log.Fatalf("Failed to read HEAD: %v", err)
+		}
+		changes, err := exportChanges(commit.Tree, dir)
+		if err != nil {
+			log.Fatalf("Failed to compare %s with HEAD: %v", dir, err)
+		}
+		if len(changes) == 0 {
+			fmt.Printf("Working export %s matches HEAD.\n", dir)
+			return
+		}
+		graphs := make([]string, 0, len(changes))
+		for graph := range changes {
+			graphs = append(graphs, graph)
+		}
+		sort.Strings(graphs)
+		fmt.Printf("Working export %s differs from HEAD:\n", dir)
+		for _, graph := range graphs {
+			fmt.Printf("  %-9s %s\n", changes[graph]+":", graph)
+		}
+	},
+}
+
+// printStagedChanges lists, per graph, what committing the index would
+// change in HEAD's tree.
+func printStagedChanges() {
+	head, staged, err := indexState()
+	if err != nil {
+		log.Fatalf("Failed to read the index: %v", err)
+	}
+	diffs, err := diffStates(context.Background(), head, staged)
+	if err != nil {
+		log.Fatalf("Failed to compare the index with HEAD: %v", err)
+	}
+	if len(diffs) == 0 {
+		fmt.Println("The staged quads match HEAD; committing would change nothing.")
+		return
+	}
+	fmt.Println("Changes to be committed:")
+	added, deleted := 0, 0
+	for _, d := range diffs {
+		line := fmt.Sprintf("  +%d / -%d quads in %s", len(d.Added), len(d.Deleted), d.Graph)
+		if d.RenamedFrom != "" {
+			line += " (renamed from " + d.RenamedFrom + ")"
+		}
+		fmt.Println(line)
+		added += len(d.Added)
+		deleted += len(d.Deleted)
+	}
+	noun := "graphs"
+	if len(diffs) == 1 {
+		noun = "graph"
+	}
+	fmt.Printf("  %d %s changed, +%d / -%d quads\n", len(diffs), noun, added, deleted)
+}
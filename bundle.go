@@ -0,0 +1,233 @@
+// bundle.go
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/mannyrivera2010/go-quadgit/internal/rpc"
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+	"github.com/spf13/cobra"
+)
+
+const bundleFormat = "quad-db graph bundle v1"
+
+// graphBundle is the file graph-bundle export writes: the history of one
+// graph rewritten as a linear chain of commits whose trees hold only that
+// graph, together with every object the chain needs.
+type graphBundle struct {
+	Format string `json:"format"`
+	Graph  string `json:"graph"` // The graph's name at the tip
+	Tip    string `json:"tip"`
+	rpc.Packfile
+}
+
+// bundleGraph rewrites the first-parent history of graph up to tip into a
+// bundle. Each commit that changed the graph becomes one commit keeping its
+// author, message, timestamp and metadata, plus a bundle-source metadata
+// entry naming the original; renames of the graph are kept. Signatures and
+// stats are dropped, since they covered the whole original commit. Nothing
+// is written to the repository.
+func bundleGraph(tip, graph string) (*graphBundle, error) {
+	versions, err := followGraph(tip, graph)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("graph %s has no history at %s", graph, shortHash(tip))
+	}
+
+	b := &graphBundle{Format: bundleFormat, Graph: graph}
+	added := make(map[string]bool)
+	put := func(obj interface{}) (string, error) {
+		data, err := encodeObject(obj)
+		if err != nil {
+			return "", err
+		}
+		sum := sha1.Sum(data)
+		hash := hex.EncodeToString(sum[:])
+		if !added[hash] {
+			added[hash] = true
+			b.Objects = append(b.Objects, rpc.Object{Hash: hash, Data: data})
+		}
+		return hash, nil
+	}
+
+	parents, previousName := []string{}, ""
+	for i := len(versions) - 1; i >= 0; i-- {
+		v := versions[i]
+		original, err := readCommit(v.Commit)
+		if err != nil {
+			return nil, err
+		}
+		graphs := map[string]string{}
+		if v.Blob != "" {
+			graphs[v.Name] = v.Blob
+			if !added[v.Blob] {
+				var data []byte
+				err := readPromised(v.Blob, func() (err error) {
+					data, err = readRawObject(v.Blob)
+					return err
+				})
+				if err != nil {
+					return nil, err
+				}
+				added[v.Blob] = true
+				b.Objects = append(b.Objects, rpc.Object{Hash: v.Blob, Data: data})
+			}
+		}
+		treeHash, err := buildTree(graphs, put)
+		if err != nil {
+			return nil, err
+		}
+		commit := Commit{
+			Tree:      treeHash,
+			Parents:   parents,
+			Author:    original.Author,
+			Message:   original.Message,
+			Timestamp: original.Timestamp,
+			Metadata:  map[string]string{"bundle-source": v.Commit},
+		}
+		for key, value := range original.Metadata {
+			commit.Metadata[key] = value
+		}
+		if previousName != "" && previousName != v.Name {
+			commit.Renames = map[string]string{previousName: v.Name}
+		}
+		hash, err := put(commit)
+		if err != nil {
+			return nil, err
+		}
+		parents, previousName = []string{hash}, v.Name
+		b.Tip = hash
+	}
+	return b, nil
+}
+
+// importBundle stores the objects of a bundle and creates branch at its tip.
+// The branch must not exist yet.
+func importBundle(b *graphBundle, branch string) error {
+	if b.Format != bundleFormat {
+		return fmt.Errorf("not a graph bundle (format %q)", b.Format)
+	}
+	if err := b.Verify(); err != nil {
+		return err
+	}
+	if err := verifyPackGraphs(b.Objects); err != nil {
+		return err
+	}
+	if _, err := getReference("head:" + branch); err == nil {
+		return fmt.Errorf("branch %s already exists", branch)
+	}
+	for _, obj := range b.Objects {
+		if err := writeRawObject(obj.Hash, obj.Data); err != nil {
+			return err
+		}
+	}
+	if commit, err := readCommit(b.Tip); err != nil || commit.Tree == "" {
+		return fmt.Errorf("bundle tip %s is not a commit", shortHash(b.Tip))
+	}
+	err := swapReference("head:"+branch, "", b.Tip)
+	if errors.Is(err, quadstore.ErrStaleParent) {
+		return fmt.Errorf("branch %s already exists", branch)
+	}
+	return err
+}
+
+var graphBundleCmd = &cobra.Command{
+	Use:   "graph-bundle",
+	Short: "Share one graph's full history between repositories",
+	Long: `Export the history of a single named graph as a portable bundle file, or
+import such a bundle into another repository as a new branch. Every commit
+that changed the graph, following renames, becomes a commit holding only
+that graph, so one dataset can be shared out of a large repository. The
+imported branch has its own history; merge it to combine it with others.`,
+}
+
+var graphBundleExportCmd = &cobra.Command{
+	Use:   "export <graph-iri> [<commit>]",
+	Short: "Write the history of a graph up to a commit (default HEAD) to a bundle",
+	Args:  cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		rev := "HEAD"
+		if len(args) == 2 {
+			rev = args[1]
+		}
+		tip, err := resolveCommitish(rev)
+		if err != nil {
+			log.Fatalf("Could not resolve %s: %v", rev, err)
+		}
+		graph := normalizeGraphName(args[0])
+		guard, err := exportGuard(cmd)
+		if err != nil {
+			log.Fatalf("Failed to load config: %v", err)
+		}
+		if guard != nil {
+			versions, err := followGraph(tip, graph)
+			if err != nil {
+				log.Fatalf("Failed to read the history of %s: %v", graph, err)
+			}
+			for _, v := range versions {
+				if v.Blob == "" {
+					continue // Deleted here
+				}
+				blob, err := readBlob(v.Blob)
+				if err != nil {
+					log.Fatalf("Failed to read blob %s: %v", shortHash(v.Blob), err)
+				}
+				if err := guard.checkLines(v.Name, blob); err != nil {
+					log.Fatalf("Refusing to export: %v", err)
+				}
+			}
+		}
+		b, err := bundleGraph(tip, graph)
+		if err != nil {
+			log.Fatalf("Failed to bundle %s: %v", args[0], err)
+		}
+
+		w := io.Writer(os.Stdout)
+		if out, _ := cmd.Flags().GetString("output"); out != "" && out != "-" {
+			f, err := os.Create(out)
+			if err != nil {
+				log.Fatalf("Failed to create %s: %v", out, err)
+			}
+			defer f.Close()
+			w = f
+		}
+		if err := json.NewEncoder(w).Encode(b); err != nil {
+			log.Fatalf("Failed to write bundle: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "Bundled %s: %s, tip %s\n", b.Graph, packSummary(b.Objects), shortHash(b.Tip))
+	},
+}
+
+var graphBundleImportCmd = &cobra.Command{
+	Use:   "import <file> <branch>",
+	Short: "Create a branch from a graph bundle",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		r := io.Reader(os.Stdin)
+		if args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				log.Fatalf("Failed to open %s: %v", args[0], err)
+			}
+			defer f.Close()
+			r = f
+		}
+		var b graphBundle
+		if err := json.NewDecoder(r).Decode(&b); err != nil {
+			log.Fatalf("Failed to read bundle: %v", err)
+		}
+		if err := importBundle(&b, args[1]); err != nil {
+			log.Fatalf("Failed to import bundle: %v", err)
+		}
+		fmt.Printf("Imported the history of %s as branch %s (%s)\n", b.Graph, args[1], shortHash(b.Tip))
+	},
+}
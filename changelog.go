@@ -0,0 +1,261 @@
+// changelog.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// classTypes are the objects of rdf:type statements that declare a class.
+var classTypes = map[string]bool{
+	"<http://www.w3.org/2000/01/rdf-schema#Class>": true,
+	"<http://www.w3.org/2002/07/owl#Class>":        true,
+}
+
+// A changelog summarizes what changed between two revisions for readers of
+// a dataset rather than its maintainers.
+type changelog struct {
+	From    string            `json:"from"`
+	To      string            `json:"to"`
+	Commits []changelogCommit `json:"commits"`
+	// NewSubjects are subjects of statements in To but of none in From.
+	NewSubjects []string `json:"new_subjects"`
+	// RemovedClasses were declared an rdfs:Class or owl:Class in From but
+	// are not in To.
+	RemovedClasses []string          `json:"removed_classes"`
+	Predicates     []predicateChange `json:"predicates"`
+	Graphs         []graphStat       `json:"graphs"`
+}
+
+type changelogCommit struct {
+	Hash      string    `json:"hash"`
+	Author    string    `json:"author"`
+	Timestamp time.Time `json:"timestamp"`
+	Subject   string    `json:"subject"`
+}
+
+// A predicateChange counts the statements using a predicate that were
+// added and deleted.
+type predicateChange struct {
+	Predicate string `json:"predicate"`
+	Added     int    `json:"added"`
+	Deleted   int    `json:"deleted"`
+}
+
+// A graphStat counts the statements added to and deleted from a graph.
+type graphStat struct {
+	Graph       string `json:"graph"`
+	RenamedFrom string `json:"renamed_from,omitempty"`
+	Added       int    `json:"added"`
+	Deleted     int    `json:"deleted"`
+}
+
+// buildChangelog summarizes the changes from one commit to another, and
+// the commits on to's first-parent history that from does not contain,
+// newest first.
+func buildChangelog(ctx context.Context, fromName, from, toName, to string) (*changelog, error) {
+	cl := &changelog{From: fromName, To: toName}
+	done, err := ancestors(from)
+	if err != nil {
+		return nil, err
+	}
+	err = walkCommits(to, func(hash string, commit *Commit) error {
+		if done[hash] {
+			return errStopWalk
+		}
+		cl.Commits = append(cl.Commits, changelogCommit{
+			Hash:      hash,
+			Author:    commit.Author,
+			Timestamp: commit.Timestamp,
+			Subject:   strings.SplitN(commit.Message, "\n", 2)[0],
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	before, err := loadStateUnder(ctx, from, "")
+	if err != nil {
+		return nil, err
+	}
+	after, err := loadStateUnder(ctx, to, "")
+	if err != nil {
+		return nil, err
+	}
+	diffs, err := diffStates(ctx, before, after)
+	if err != nil {
+		return nil, err
+	}
+
+	subjectsBefore := make(map[string]bool)
+	for _, set := range before {
+		for line := range set {
+			if q, err := parseQuad(line); err == nil {
+				subjectsBefore[q.Subject] = true
+			}
+		}
+	}
+	classesAfter := make(map[string]bool)
+	for _, set := range after {
+		for line := range set {
+			if q, err := parseQuad(line); err == nil && q.Predicate == rdfType && classTypes[q.Object] {
+				classesAfter[q.Subject] = true
+			}
+		}
+	}
+
+	newSubjects := make(map[string]bool)
+	removedClasses := make(map[string]bool)
+	predicates := make(map[string]*predicateChange)
+	count := func(predicate string) *predicateChange {
+		if predicates[predicate] == nil {
+			predicates[predicate] = &predicateChange{Predicate: predicate}
+		}
+		return predicates[predicate]
+	}
+	for _, d := range diffs {
+		cl.Graphs = append(cl.Graphs, graphStat{Graph: d.Graph, RenamedFrom: d.RenamedFrom, Added: len(d.Added), Deleted: len(d.Deleted)})
+		for _, line := range d.Added {
+			q, err := parseQuad(line)
+			if err != nil {
+				continue
+			}
+			if !subjectsBefore[q.Subject] {
+				newSubjects[q.Subject] = true
+			}
+			count(q.Predicate).Added++
+		}
+		for _, line := range d.Deleted {
+			q, err := parseQuad(line)
+			if err != nil {
+				continue
+			}
+			if q.Predicate == rdfType && classTypes[q.Object] && !classesAfter[q.Subject] {
+				removedClasses[q.Subject] = true
+			}
+			count(q.Predicate).Deleted++
+		}
+	}
+	cl.NewSubjects = sortedKeys(newSubjects)
+	cl.RemovedClasses = sortedKeys(removedClasses)
+	for _, p := range predicates {
+		cl.Predicates = append(cl.Predicates, *p)
+	}
+	sort.Slice(cl.Predicates, func(i, j int) bool { return cl.Predicates[i].Predicate < cl.Predicates[j].Predicate })
+	return cl, nil
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// markdown renders the changelog as a Markdown document, leaving out
+// empty sections.
+func (cl *changelog) markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Changes from %s to %s\n", cl.From, cl.To)
+	if len(cl.Commits) > 0 {
+		b.WriteString("\n## Commits\n\n")
+		for _, c := range cl.Commits {
+			fmt.Fprintf(&b, "- %s (%s, %s)\n", c.Subject, shortHash(c.Hash), c.Author)
+		}
+	}
+	if len(cl.NewSubjects) > 0 {
+		b.WriteString("\n## New subjects\n\n")
+		for _, s := range cl.NewSubjects {
+			fmt.Fprintf(&b, "- `%s`\n", s)
+		}
+	}
+	if len(cl.RemovedClasses) > 0 {
+		b.WriteString("\n## Removed classes\n\n")
+		for _, c := range cl.RemovedClasses {
+			fmt.Fprintf(&b, "- `%s`\n", c)
+		}
+	}
+	if len(cl.Predicates) > 0 {
+		b.WriteString("\n## Changed predicates\n\n| Predicate | Added | Deleted |\n| --- | ---: | ---: |\n")
+		for _, p := range cl.Predicates {
+			fmt.Fprintf(&b, "| `%s` | %d | %d |\n", p.Predicate, p.Added, p.Deleted)
+		}
+	}
+	if len(cl.Graphs) > 0 {
+		b.WriteString("\n## Graphs\n\n| Graph | Added | Deleted |\n| --- | ---: | ---: |\n")
+		for _, g := range cl.Graphs {
+			name := "`" + g.Graph + "`"
+			if g.RenamedFrom != "" {
+				name += " (renamed from `" + g.RenamedFrom + "`)"
+			}
+			fmt.Fprintf(&b, "| %s | %d | %d |\n", name, g.Added, g.Deleted)
+		}
+	}
+	if len(cl.Commits) == 0 && len(cl.Graphs) == 0 {
+		b.WriteString("\nNo changes.\n")
+	}
+	return b.String()
+}
+
+var changelogCmd = &cobra.Command{
+	Use:   "changelog <from>..[<to>]",
+	Short: "Summarize the changes between two revisions for a changelog",
+	Long: `Summarize what changed from one revision to another (HEAD if <to> is
+left out), e.g. between two releases with 'changelog v1.0.0..v2.0.0': the
+subjects of the commits since <from>, newest first; the subjects that are
+new, having had no statements before; the classes no longer declared an
+rdfs:Class or owl:Class; the statements added and deleted per predicate;
+and the same per graph, with renames.
+
+The summary is printed as Markdown, or as JSON with --format json.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		format, _ := cmd.Flags().GetString("format")
+		if format != "markdown" && format != "json" {
+			log.Fatalf("Unknown format %q (expected markdown or json).", format)
+		}
+		fromName, toName, ok := strings.Cut(args[0], "..")
+		if !ok || fromName == "" {
+			log.Fatal("Usage: quad-db changelog <from>..[<to>]")
+		}
+		if toName == "" {
+			toName = "HEAD"
+		}
+		from, err := resolveCommitish(fromName)
+		if err != nil {
+			log.Fatalf("Could not resolve %s: %v", fromName, err)
+		}
+		to, err := resolveCommitish(toName)
+		if err != nil {
+			log.Fatalf("Could not resolve %s: %v", toName, err)
+		}
+
+		ctx := commandContext(cmd)
+		cl, err := buildChangelog(ctx, fromName, from, toName, to)
+		exitIfInterrupted(ctx, "the changelog was stopped")
+		if err != nil {
+			log.Fatalf("Failed to build the changelog: %v", err)
+		}
+		if format == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.SetEscapeHTML(false) // IRIs stay readable
+			if err := enc.Encode(cl); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+		fmt.Print(cl.markdown())
+	},
+}
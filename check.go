@@ -0,0 +1,184 @@
+// check.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+	"github.com/mannyrivera2010/go-quadgit/pkg/rdfio"
+	"github.com/spf13/cobra"
+)
+
+var (
+	integerLexical = regexp.MustCompile(`^[+-]?[0-9]+$`)
+	decimalLexical = regexp.MustCompile(`^[+-]?([0-9]+(\.[0-9]*)?|\.[0-9]+)$`)
+	doubleLexical  = regexp.MustCompile(`^[+-]?([0-9]+(\.[0-9]*)?|\.[0-9]+)([eE][+-]?[0-9]+)?$`)
+	gYearLexical   = regexp.MustCompile(`^-?[0-9]{4,}(Z|[+-][0-9]{2}:[0-9]{2})?$`)
+)
+
+// integerRanges bounds the XSD integer types; nil means unbounded.
+var integerRanges = map[string][2]*big.Int{
+	"integer":            {nil, nil},
+	"nonNegativeInteger": {big.NewInt(0), nil},
+	"positiveInteger":    {big.NewInt(1), nil},
+	"nonPositiveInteger": {nil, big.NewInt(0)},
+	"negativeInteger":    {nil, big.NewInt(-1)},
+	"long":               {big.NewInt(-1 << 63), big.NewInt(1<<63 - 1)},
+	"int":                {big.NewInt(-1 << 31), big.NewInt(1<<31 - 1)},
+	"short":              {big.NewInt(-1 << 15), big.NewInt(1<<15 - 1)},
+	"byte":               {big.NewInt(-1 << 7), big.NewInt(1<<7 - 1)},
+	"unsignedLong":       {big.NewInt(0), new(big.Int).SetUint64(1<<64 - 1)},
+	"unsignedInt":        {big.NewInt(0), big.NewInt(1<<32 - 1)},
+	"unsignedShort":      {big.NewInt(0), big.NewInt(1<<16 - 1)},
+	"unsignedByte":       {big.NewInt(0), big.NewInt(1<<8 - 1)},
+}
+
+// checkLexical reports whether a literal's lexical form is valid for its XSD
+// datatype. Datatypes it does not know are accepted.
+func checkLexical(lexical, datatype string) error {
+	local, ok := strings.CutPrefix(datatype, xsd)
+	if !ok {
+		return nil
+	}
+	valid := true
+	if bounds, ok := integerRanges[local]; ok {
+		n, isInt := new(big.Int).SetString(strings.TrimPrefix(lexical, "+"), 10)
+		valid = integerLexical.MatchString(lexical) && isInt &&
+			(bounds[0] == nil || n.Cmp(bounds[0]) >= 0) && (bounds[1] == nil || n.Cmp(bounds[1]) <= 0)
+	} else {
+		switch local {
+		case "decimal":
+			valid = decimalLexical.MatchString(lexical)
+		case "double", "float":
+			valid = doubleLexical.MatchString(lexical) || lexical == "INF" || lexical == "-INF" || lexical == "+INF" || lexical == "NaN"
+		case "boolean":
+			valid = lexical == "true" || lexical == "false" || lexical == "1" || lexical == "0"
+		case "date":
+			valid = parsesAs(lexical, "2006-01-02", "2006-01-02Z07:00")
+		case "dateTime":
+			valid = parsesAs(lexical, "2006-01-02T15:04:05.999999999", "2006-01-02T15:04:05.999999999Z07:00")
+		case "dateTimeStamp":
+			valid = parsesAs(lexical, "2006-01-02T15:04:05.999999999Z07:00")
+		case "time":
+			valid = parsesAs(lexical, "15:04:05.999999999", "15:04:05.999999999Z07:00")
+		case "gYear":
+			valid = gYearLexical.MatchString(lexical)
+		}
+	}
+	if !valid {
+		return fmt.Errorf("%q is not a valid xsd:%s", lexical, local)
+	}
+	return nil
+}
+
+func parsesAs(value string, layouts ...string) bool {
+	for _, layout := range layouts {
+		if _, err := time.Parse(layout, value); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// checkFile parses one file, calling report for every problem, and returns
+// the quads that parsed.
+func checkFile(path string, mode rdfio.Mode, report func(line int, format string, v ...interface{})) ([]quadstore.Quad, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	reader := rdfio.NewReader(r)
+	reader.Mode = mode
+	var quads []quadstore.Quad
+	for {
+		q, err := reader.Read()
+		if err == io.EOF {
+			return quads, nil
+		}
+		var perr *rdfio.ParseError
+		if errors.As(err, &perr) {
+			report(perr.Line, "%v", perr.Err)
+			continue
+		} else if err != nil {
+			return quads, err
+		}
+		if strings.HasPrefix(q.Object, `"`) {
+			lexical, _, datatype := literalParts(q.Object)
+			if err := checkLexical(lexical, datatype); err != nil {
+				report(reader.Line(), "%v", err)
+				continue
+			}
+		}
+		quads = append(quads, q)
+	}
+}
+
+var checkCmd = &cobra.Command{
+	Use:   "check <file>...",
+	Short: "Validate N-Quads/N-Triples files without touching the repository",
+	Long: `Parse and validate RDF files as a gate before they are staged: strict
+N-Quads syntax, absolute IRIs, and the lexical forms of XSD datatypes such
+as xsd:integer, xsd:boolean and xsd:dateTime. With --shapes, the quads of
+all files are also validated against SHACL shapes (given as N-Triples or
+N-Quads; see shacl.go for the supported constraints).
+
+Every problem is reported as file:line: message, and the command exits with
+status 1 if there are any. Use "-" to read standard input. No repository is
+needed.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		mode := rdfio.Strict
+		if lenient, _ := cmd.Flags().GetBool("lenient"); lenient {
+			mode = rdfio.Lenient
+		}
+		problems := 0
+		var data []quadstore.Quad
+		for _, path := range args {
+			quads, err := checkFile(path, mode, func(line int, format string, v ...interface{}) {
+				fmt.Printf("%s:%d: %s\n", path, line, fmt.Sprintf(format, v...))
+				problems++
+			})
+			if err != nil {
+				fmt.Printf("%s: %v\n", path, err)
+				problems++
+			}
+			data = append(data, quads...)
+		}
+
+		if shapesPath, _ := cmd.Flags().GetString("shapes"); shapesPath != "" {
+			shapeQuads, err := checkFile(shapesPath, rdfio.Lenient, func(line int, format string, v ...interface{}) {
+				log.Fatalf("%s:%d: %s", shapesPath, line, fmt.Sprintf(format, v...))
+			})
+			if err != nil {
+				log.Fatalf("Failed to read shapes: %v", err)
+			}
+			shapes, err := parseShapes(shapeQuads)
+			if err != nil {
+				log.Fatalf("Invalid shapes: %v", err)
+			}
+			for _, v := range validateShapes(shapes, data) {
+				fmt.Printf("shacl: %s\n", v)
+				problems++
+			}
+		}
+
+		if problems > 0 {
+			fmt.Printf("%d problem(s) found\n", problems)
+			os.Exit(1)
+		}
+		fmt.Printf("%d quad(s) in %d file(s) checked, no problems found\n", len(data), len(args))
+	},
+}
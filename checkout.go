@@ -0,0 +1,159 @@
+// checkout.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// startOrphanBranch creates branch at a new root commit with an empty tree
+// and points HEAD at it, so its history shares nothing with other branches.
+func startOrphanBranch(branch string) error {
+	if err := validBranchName(branch); err != nil {
+		return err
+	}
+	if _, err := getReference("head:" + branch); err == nil {
+		return fmt.Errorf("a branch named %s already exists", branch)
+	}
+	root, err := writeRootCommit()
+	if err != nil {
+		return err
+	}
+	if err := swapReference("head:"+branch, "", root); err != nil {
+		return err
+	}
+	return setReference("HEAD", "ref:head:"+branch)
+}
+
+// switchHead points HEAD at a branch, or at a commit directly (detached)
+// when branch is empty.
+func switchHead(branch, hash string) error {
+	if branch != "" {
+		return setReference("HEAD", "ref:head:"+branch)
+	}
+	return setReference("HEAD", hash)
+}
+
+// refreshExport replaces the working export in dir with the graphs of a
+// commit. Unless force is set, it refuses to if the export has changes
+// against from, the commit HEAD left, which would be lost.
+func refreshExport(dir, from, to string, force bool) error {
+	if _, err := os.Stat(dir); err == nil && !force {
+		commit, err := readCommit(from)
+		if err != nil {
+			return err
+		}
+		changes, err := exportChanges(commit.Tree, dir)
+		if err != nil {
+			return err
+		}
+		if len(changes) > 0 {
+			return fmt.Errorf("the working export %s has %d changed graph(s) that would be lost; commit them or use --force", dir, len(changes))
+		}
+	}
+	state, err := loadState(to)
+	if err != nil {
+		return err
+	}
+	return exportState(state, dir)
+}
+
+var checkoutCmd = &cobra.Command{
+	Use:   "checkout [-b <new-branch>] <branch|commit>",
+	Short: "Switch branches or detach HEAD at a commit",
+	Long: `Point HEAD at a branch, so that commits advance it, or, given anything
+else that names a commit (a hash, tag or expression such as main~2), at
+that commit alone: HEAD is then detached, and commits move only HEAD until
+a branch is checked out or created with 'branch <name>'. --detach detaches
+HEAD even at a branch's tip. -b <new-branch> creates a branch at the
+commit and switches to it. Staged changes are kept and go into the next
+commit.
+
+With --export, the working export (see export) is replaced by the graphs
+of the commit checked out, unless it has changes against the old HEAD;
+--force discards them. --dir picks another directory, and --save-dir
+makes it the working export from then on.
+
+With --orphan, create a branch whose history starts from a new, empty
+root commit instead of the current commit, and switch to it. Use it to keep
+unrelated datasets, or generated artifacts such as inference results, in
+the same repository. Staged changes are kept and go into the branch's first
+commit.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if orphan, _ := cmd.Flags().GetBool("orphan"); orphan {
+			if err := startOrphanBranch(args[0]); err != nil {
+				log.Fatalf("Failed to create branch: %v", err)
+			}
+			fmt.Printf("Switched to a new branch '%s' with no history\n", args[0])
+			return
+		}
+		if mergeInProgress() {
+			log.Fatal("A merge is in progress; finish it with 'merge --continue' or 'merge --abort' first.")
+		}
+		from, err := resolveHead()
+		if err != nil {
+			log.Fatalf("Failed to resolve HEAD: %v", err)
+		}
+
+		target := args[0]
+		branch := ""
+		if detach, _ := cmd.Flags().GetBool("detach"); !detach {
+			if _, err := getReference("head:" + target); err == nil {
+				branch = target
+			}
+		}
+		hash, err := resolveCommitish(target)
+		if err != nil {
+			log.Fatalf("Could not resolve %s: %v", target, err)
+		}
+		newBranch, _ := cmd.Flags().GetString("branch")
+		if newBranch != "" {
+			if err := createBranch(newBranch, hash); err != nil {
+				log.Fatalf("Failed to create branch: %v", err)
+			}
+			branch = newBranch
+		}
+
+		if export, _ := cmd.Flags().GetBool("export"); export {
+			dir, _ := cmd.Flags().GetString("dir")
+			if dir == "" {
+				if dir, err = exportDir(); err != nil {
+					log.Fatalf("Failed to load config: %v", err)
+				}
+			}
+			force, _ := cmd.Flags().GetBool("force")
+			if err := refreshExport(dir, from, hash, force); err != nil {
+				if newBranch != "" {
+					deleteReference("head:" + newBranch)
+				}
+				log.Fatalf("Failed to export: %v", err)
+			}
+			if save, _ := cmd.Flags().GetBool("save-dir"); save {
+				if err := saveExportDir(dir); err != nil {
+					log.Fatalf("Failed to save config: %v", err)
+				}
+			}
+		}
+		if err := switchHead(branch, hash); err != nil {
+			log.Fatalf("Failed to update HEAD: %v", err)
+		}
+
+		switch {
+		case newBranch != "":
+			fmt.Printf("Switched to a new branch '%s'\n", branch)
+		case branch != "":
+			fmt.Printf("Switched to branch '%s'\n", branch)
+		default:
+			subject := ""
+			if commit, err := readCommit(hash); err == nil {
+				subject = " " + strings.SplitN(commit.Message, "\n", 2)[0]
+			}
+			fmt.Printf("HEAD is now at %s%s (detached)\n", shortHash(hash), subject)
+		}
+	},
+}
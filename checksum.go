@@ -0,0 +1,293 @@
+// checksum.go
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/mannyrivera2010/go-quadgit/internal/rpc"
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+)
+
+// graphSummaryPrefix keys the summary of a graph blob. Like query
+// statistics, summaries of a blob never change once computed.
+const graphSummaryPrefix = "gsum:"
+
+// A graphSummary is what a tree entry records about its graph: the SHA-256
+// of the blob's canonical statements, one per line, and how many there are.
+// It depends only on content, so fsck, transports and status can check a
+// graph against its tree entry without trusting the blob's hash or
+// re-encoding it as an object.
+type graphSummary struct {
+	Checksum string
+	Quads    int
+}
+
+// summarizeLines summarizes a graph from its canonical statements.
+func summarizeLines(lines []string) graphSummary {
+	h := sha256.New()
+	n := 0
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		io.WriteString(h, line+"\n")
+		n++
+	}
+	return graphSummary{Checksum: hex.EncodeToString(h.Sum(nil)), Quads: n}
+}
+
+// summarizeSet summarizes a graph held in memory, such as one read from the
+// working export.
+func summarizeSet(set quadSet) (graphSummary, error) {
+	blob := make(Blob, 0, len(set))
+	for line := range set {
+		blob = append(blob, line)
+	}
+	lines, err := canonicalBlob(blob)
+	if err != nil {
+		return graphSummary{}, err
+	}
+	return summarizeLines(lines), nil
+}
+
+// summarizeBlobData summarizes a blob from its stored encoding.
+func summarizeBlobData(data []byte) (graphSummary, error) {
+	var blob Blob
+	if err := json.Unmarshal(data, &blob); err != nil {
+		return graphSummary{}, err
+	}
+	return summarizeLines(blob), nil
+}
+
+// matches reports whether a tree entry's recorded summary, if it has one,
+// agrees with s. Entries of trees written before summaries were recorded
+// match anything.
+func (s graphSummary) matches(entry TreeEntry) bool {
+	if entry.Checksum == "" {
+		return true
+	}
+	return entry.Checksum == s.Checksum && entry.Quads == s.Quads
+}
+
+// blobSummary returns the summary of a stored blob, computing and saving it
+// the first time.
+func blobSummary(hash string) (graphSummary, error) {
+	var sum graphSummary
+	var found bool
+	err := repo.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(graphSummaryPrefix + hash))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			checksum, quads, ok := strings.Cut(string(val), " ")
+			n, err := strconv.Atoi(quads)
+			if ok && err == nil {
+				sum, found = graphSummary{Checksum: checksum, Quads: n}, true
+			}
+			return nil
+		})
+	})
+	if err != nil || found {
+		return sum, err
+	}
+	blob, err := readBlob(hash)
+	if err != nil {
+		return sum, err
+	}
+	sum = summarizeLines(blob)
+	saveBlobSummary(hash, sum)
+	return sum, nil
+}
+
+// saveBlobSummary records the summary of a blob for blobSummary. It is only
+// a cache, so failures are ignored.
+func saveBlobSummary(hash string, sum graphSummary) {
+	repo.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(graphSummaryPrefix+hash), []byte(fmt.Sprintf("%s %d", sum.Checksum, sum.Quads)))
+	})
+}
+
+// checksumMismatch describes a graph whose blob disagrees with its tree
+// entry.
+type checksumMismatch struct {
+	Graph string
+	Blob  string
+	Entry TreeEntry
+	Found graphSummary
+}
+
+func (m checksumMismatch) String() string {
+	return fmt.Sprintf("graph %s: blob %s has %d quad(s) with checksum %s, its tree entry records %d with %s",
+		m.Graph, shortHash(m.Blob), m.Found.Quads, shortChecksum(m.Found.Checksum), m.Entry.Quads, shortChecksum(m.Entry.Checksum))
+}
+
+func shortChecksum(checksum string) string {
+	if len(checksum) > 12 {
+		return checksum[:12]
+	}
+	return checksum
+}
+
+// checkTreeSummaries re-reads every blob under a tree that has a recorded
+// summary and returns those that disagree with it. Blobs are summarized
+// afresh rather than from the cache, which is what is being checked. Trees
+// in seen are skipped, and those checked are added to it, so that subtrees
+// shared between commits are read once. Blobs a partial clone lacks are
+// skipped too.
+func checkTreeSummaries(treeHash string, path []string, seen map[string]bool) ([]checksumMismatch, error) {
+	if seen[treeHash] {
+		return nil, nil
+	}
+	seen[treeHash] = true
+	tree, err := readTree(treeHash)
+	if err != nil {
+		return nil, err
+	}
+	var mismatches []checksumMismatch
+	for segment, entry := range tree {
+		entryPath := append(append([]string{}, path...), segment)
+		if entry.Blob != "" && entry.Checksum != "" {
+			data, err := readRawObject(entry.Blob)
+			if errors.Is(err, quadstore.ErrNotFound) && promisorRemote() != "" {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			sum, err := summarizeBlobData(data)
+			if err != nil {
+				return nil, fmt.Errorf("blob %s: %v", entry.Blob, err)
+			}
+			if !sum.matches(entry) {
+				mismatches = append(mismatches, checksumMismatch{Graph: graphName(entryPath), Blob: entry.Blob, Entry: entry, Found: sum})
+			}
+		}
+		if entry.Tree != "" {
+			found, err := checkTreeSummaries(entry.Tree, entryPath, seen)
+			if err != nil {
+				return nil, err
+			}
+			mismatches = append(mismatches, found...)
+		}
+	}
+	return mismatches, nil
+}
+
+// verifyPackGraphs checks the blobs of a received pack against the tree
+// entries, also received, that name them, so that a transfer cannot pair a
+// graph with a tree describing other content. Trees are found from the
+// pack's commits; objects already stored were checked when they arrived.
+func verifyPackGraphs(objects []rpc.Object) error {
+	data := make(map[string][]byte, len(objects))
+	for _, obj := range objects {
+		data[obj.Hash] = obj.Data
+	}
+	var walk func(hash string, path []string) error
+	walk = func(hash string, path []string) error {
+		raw, ok := data[hash]
+		if !ok {
+			return nil
+		}
+		var tree Tree
+		if err := json.Unmarshal(raw, &tree); err != nil {
+			return fmt.Errorf("tree %s: %v", hash, err)
+		}
+		for segment, entry := range tree {
+			entryPath := append(append([]string{}, path...), segment)
+			if blob, ok := data[entry.Blob]; ok && entry.Checksum != "" {
+				sum, err := summarizeBlobData(blob)
+				if err != nil {
+					return fmt.Errorf("blob %s: %v", entry.Blob, err)
+				}
+				if !sum.matches(entry) {
+					return fmt.Errorf("checksum mismatch: %s", checksumMismatch{Graph: graphName(entryPath), Blob: entry.Blob, Entry: entry, Found: sum})
+				}
+			}
+			if entry.Tree != "" {
+				if err := walk(entry.Tree, entryPath); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	for _, obj := range objects {
+		var commit Commit
+		if json.Unmarshal(obj.Data, &commit) != nil || commit.Tree == "" || commit.Timestamp.IsZero() {
+			continue
+		}
+		if err := walk(commit.Tree, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// treeSummaries returns the tree entry of each graph under a tree, filling
+// in the summary of entries that do not record one.
+func treeSummaries(treeHash string) (map[string]TreeEntry, error) {
+	entries := make(map[string]TreeEntry)
+	err := walkTree(treeHash, func(path []string, entry TreeEntry) error {
+		if entry.Blob == "" {
+			return nil
+		}
+		if entry.Checksum == "" {
+			sum, err := blobSummary(entry.Blob)
+			if err != nil {
+				return err
+			}
+			entry.Checksum, entry.Quads = sum.Checksum, sum.Quads
+		}
+		entries[graphName(path)] = entry
+		return nil
+	})
+	return entries, err
+}
+
+// exportChanges compares the working export in dir with a tree by graph
+// summaries alone, returning how each graph that differs was changed:
+// "modified", "new" or "deleted".
+func exportChanges(treeHash, dir string) (map[string]string, error) {
+	entries, err := treeSummaries(treeHash)
+	if err != nil {
+		return nil, err
+	}
+	working, err := readWorkingExport(dir)
+	if err != nil {
+		return nil, err
+	}
+	changes := make(map[string]string)
+	for graph, set := range working {
+		if len(set) == 0 {
+			continue
+		}
+		entry, ok := entries[graph]
+		if !ok {
+			changes[graph] = "new"
+			continue
+		}
+		sum, err := summarizeSet(set)
+		if err != nil {
+			return nil, fmt.Errorf("graph %s: %v", graph, err)
+		}
+		if !sum.matches(entry) {
+			changes[graph] = "modified"
+		}
+	}
+	for graph := range entries {
+		if len(working[graph]) == 0 {
+			changes[graph] = "deleted"
+		}
+	}
+	return changes, nil
+}
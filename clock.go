@@ -0,0 +1,47 @@
+// clock.go
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+)
+
+// clock stamps every commit, merge and push certificate this process
+// creates.
+var clock quadstore.Clock = quadstore.SystemClock{}
+
+// deterministicAuthor is the author of commits made with --deterministic
+// and no --author.
+const deterministicAuthor = "quad-db"
+
+// deterministic is set by --deterministic.
+var deterministic bool
+
+// setDeterministic fixes the clock and the default author, so that the same
+// inputs produce byte-identical commits. The time is SOURCE_DATE_EPOCH, as
+// reproducible builds define it, or else the Unix epoch.
+func setDeterministic() error {
+	t := time.Unix(0, 0)
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		secs, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid SOURCE_DATE_EPOCH %q: %v", epoch, err)
+		}
+		t = time.Unix(secs, 0)
+	}
+	clock = quadstore.FixedClock(t.UTC())
+	deterministic = true
+	return nil
+}
+
+// commitAuthor is the author of new commits that are not given one.
+func commitAuthor() string {
+	if deterministic {
+		return deterministicAuthor
+	}
+	return "user@example.com" // Should be configurable
+}
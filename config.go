@@ -0,0 +1,53 @@
+// config.go
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/mannyrivera2010/go-quadgit/internal/config"
+	"github.com/spf13/cobra"
+)
+
+// loadConfig reads the configuration of the current repository.
+func loadConfig() (*config.Config, error) {
+	return config.Load(dbPath)
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config [<key> [<value>]]",
+	Short: "Get and set repository options",
+	Args:  cobra.MaximumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := loadConfig()
+		if err != nil {
+			log.Fatalf("Failed to load config: %v", err)
+		}
+		unset, _ := cmd.Flags().GetBool("unset")
+
+		switch {
+		case unset:
+			if len(args) != 1 {
+				log.Fatal("Usage: quad-db config --unset <key>")
+			}
+			cfg.Unset(args[0])
+		case len(args) == 0:
+			for _, key := range cfg.Keys() {
+				fmt.Printf("%s=%s\n", key, cfg.Get(key))
+			}
+			return
+		case len(args) == 1:
+			value, ok := cfg.Lookup(args[0])
+			if !ok {
+				log.Fatalf("Config key %s is not set.", args[0])
+			}
+			fmt.Println(value)
+			return
+		default:
+			cfg.Set(args[0], args[1])
+		}
+		if err := cfg.Save(); err != nil {
+			log.Fatalf("Failed to save config: %v", err)
+		}
+	},
+}
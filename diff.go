@@ -0,0 +1,647 @@
+// diff.go
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quaddiff"
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+	"github.com/spf13/cobra"
+)
+
+// graphDiff holds the changes to one graph between two states.
+type graphDiff struct {
+	Graph       string
+	RenamedFrom string // Set when the graph was renamed from another name.
+	Added       []string
+	Deleted     []string
+}
+
+// stateDigests returns, per graph, the hash its blob has (or would have).
+func stateDigests(state map[string]quadSet) (map[string]string, error) {
+	digests := make(map[string]string, len(state))
+	for graph, set := range state {
+		if len(set) == 0 {
+			continue
+		}
+		blob := make(Blob, 0, len(set))
+		for line := range set {
+			blob = append(blob, line)
+		}
+		sort.Strings(blob)
+		hash, err := hashObject(blob)
+		if err != nil {
+			return nil, err
+		}
+		digests[graph] = hash
+	}
+	return digests, nil
+}
+
+// sortedLines returns the quads in set in byte order.
+func sortedLines(set quadSet) []string {
+	lines := make([]string, 0, len(set))
+	for line := range set {
+		lines = append(lines, line)
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// diffStates compares two states graph by graph, pairing graphs that were
+// renamed with identical content. Graphs without changes are omitted. It
+// stops with ctx's error when ctx is canceled.
+func diffStates(ctx context.Context, before, after map[string]quadSet) ([]graphDiff, error) {
+	beforeDigests, err := stateDigests(before)
+	if err != nil {
+		return nil, err
+	}
+	afterDigests, err := stateDigests(after)
+	if err != nil {
+		return nil, err
+	}
+	renamedTo := detectRenames(beforeDigests, afterDigests)
+	renamedFrom := make(map[string]string, len(renamedTo))
+	for oldName, newName := range renamedTo {
+		renamedFrom[newName] = oldName
+	}
+
+	graphs := make(map[string]bool)
+	for graph := range before {
+		if _, renamed := renamedTo[graph]; !renamed {
+			graphs[graph] = true
+		}
+	}
+	for graph := range after {
+		graphs[graph] = true
+	}
+
+	var diffs []graphDiff
+	for graph := range graphs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		d := graphDiff{Graph: graph, RenamedFrom: renamedFrom[graph]}
+		old := before[graph]
+		if d.RenamedFrom != "" {
+			old = before[d.RenamedFrom]
+		}
+		err := quaddiff.Diff(quaddiff.Slice(sortedLines(old)), quaddiff.Slice(sortedLines(after[graph])), func(op quaddiff.Op, line string) error {
+			if op == quaddiff.Added {
+				d.Added = append(d.Added, line)
+			} else {
+				d.Deleted = append(d.Deleted, line)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(d.Added) == 0 && len(d.Deleted) == 0 && d.RenamedFrom == "" {
+			continue
+		}
+		diffs = append(diffs, d)
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Graph < diffs[j].Graph })
+	return diffs, nil
+}
+
+// commitDiffs returns the changes a commit made relative to its first
+// parent (or to an empty state for a root commit), reading only the graphs
+// whose blobs differ between the two.
+func commitDiffs(commit *Commit) ([]graphDiff, error) {
+	parentGraphs := map[string]string{}
+	if len(commit.Parents) > 0 {
+		parent, err := readCommit(commit.Parents[0])
+		if err != nil {
+			return nil, err
+		}
+		if parentGraphs, err = readGraphs(parent.Tree); err != nil {
+			return nil, err
+		}
+	}
+	graphs, err := readGraphs(commit.Tree)
+	if err != nil {
+		return nil, err
+	}
+	load := func(from, other map[string]string) (map[string]quadSet, error) {
+		state := make(map[string]quadSet)
+		for graph, blobHash := range from {
+			if other[graph] == blobHash {
+				continue
+			}
+			blob, err := readBlob(blobHash)
+			if err != nil {
+				return nil, err
+			}
+			set := make(quadSet, len(blob))
+			for _, line := range blob {
+				set[line] = true
+			}
+			state[graph] = set
+		}
+		return state, nil
+	}
+	before, err := load(parentGraphs, graphs)
+	if err != nil {
+		return nil, err
+	}
+	after, err := load(graphs, parentGraphs)
+	if err != nil {
+		return nil, err
+	}
+	return diffStates(context.Background(), before, after)
+}
+
+// normalizeTerm turns a user-supplied IRI argument into its N-Quads form,
+// accepting it with or without angle brackets.
+func normalizeTerm(arg string) string {
+	if arg == "" || strings.HasPrefix(arg, "<") || strings.HasPrefix(arg, "_:") || strings.HasPrefix(arg, `"`) {
+		return arg
+	}
+	return "<" + arg + ">"
+}
+
+// lineFilter returns whether a changed line has the subject and predicate
+// opts select.
+func lineFilter(opts quadstore.DiffOptions) func(line string) bool {
+	subject, predicate := normalizeTerm(opts.Subject), normalizeTerm(opts.Predicate)
+	return func(line string) bool {
+		if subject == "" && predicate == "" {
+			return true
+		}
+		q, err := parseQuad(line)
+		if err != nil {
+			return false
+		}
+		return (subject == "" || q.Subject == subject) && (predicate == "" || q.Predicate == predicate)
+	}
+}
+
+// blobStream returns the statements of a blob as a sorted stream, or an
+// empty one for no blob. Blobs are written sorted; those that are not are
+// sorted here.
+func blobStream(hash string) (quaddiff.Stream, error) {
+	if hash == "" {
+		return quaddiff.Slice(nil), nil
+	}
+	blob, err := readBlob(hash)
+	if err != nil {
+		return nil, err
+	}
+	if !sort.StringsAreSorted(blob) {
+		sort.Strings(blob)
+	}
+	return quaddiff.Slice(blob), nil
+}
+
+// streamCommitDiff reports the changes between two commits as it finds
+// them: graph by graph in name order, and within a graph statement by
+// statement, calling fn with each added or deleted line that opts select,
+// and with an empty line for a graph that was only renamed. Graphs whose
+// blobs are the same in both commits are skipped unread, and only the two
+// versions of one graph are held in memory at a time.
+func streamCommitDiff(ctx context.Context, from, to string, opts quadstore.DiffOptions, fn func(graph, renamedFrom string, op quaddiff.Op, line string) error) error {
+	prefix := opts.Graph
+	if prefix != "" && !strings.HasSuffix(prefix, "*") {
+		prefix = normalizeGraphName(prefix)
+	}
+	blobsOf := func(hash string) (map[string]string, error) {
+		commit, err := readCommit(hash)
+		if err != nil {
+			return nil, err
+		}
+		return readGraphsUnder(commit.Tree, prefix)
+	}
+	before, err := blobsOf(from)
+	if err != nil {
+		return err
+	}
+	after, err := blobsOf(to)
+	if err != nil {
+		return err
+	}
+	renamedTo := detectRenames(before, after)
+	renamedFrom := make(map[string]string, len(renamedTo))
+	for oldName, newName := range renamedTo {
+		renamedFrom[newName] = oldName
+	}
+	var graphs []string
+	for graph := range before {
+		if _, renamed := renamedTo[graph]; !renamed {
+			if _, ok := after[graph]; !ok {
+				graphs = append(graphs, graph)
+			}
+		}
+	}
+	for graph := range after {
+		graphs = append(graphs, graph)
+	}
+	sort.Strings(graphs)
+
+	keep := lineFilter(opts)
+	renameOnly := opts.Subject == "" && opts.Predicate == ""
+	for _, graph := range graphs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if opts.Graph != "" && !graphInScope(graph, opts.Graph) {
+			continue
+		}
+		oldName := renamedFrom[graph]
+		oldBlob := before[graph]
+		if oldName != "" {
+			oldBlob = before[oldName]
+		}
+		if oldBlob == after[graph] {
+			if oldName != "" && renameOnly {
+				if err := fn(graph, oldName, quaddiff.Added, ""); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		a, err := blobStream(oldBlob)
+		if err != nil {
+			return err
+		}
+		b, err := blobStream(after[graph])
+		if err != nil {
+			return err
+		}
+		err = quaddiff.Diff(a, b, func(op quaddiff.Op, line string) error {
+			if !keep(line) {
+				return nil
+			}
+			return fn(graph, oldName, op, line)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// filterDiffs narrows diffs to the graphs, subjects and predicates selected
+// by opts, dropping graphs left without changes.
+func filterDiffs(diffs []graphDiff, opts quadstore.DiffOptions) []graphDiff {
+	subject, predicate := normalizeTerm(opts.Subject), normalizeTerm(opts.Predicate)
+	keep := lineFilter(opts)
+
+	var filtered []graphDiff
+	for _, d := range diffs {
+		if opts.Graph != "" && !graphInScope(d.Graph, opts.Graph) {
+			continue
+		}
+		scoped := graphDiff{Graph: d.Graph, RenamedFrom: d.RenamedFrom}
+		for _, line := range d.Added {
+			if keep(line) {
+				scoped.Added = append(scoped.Added, line)
+			}
+		}
+		for _, line := range d.Deleted {
+			if keep(line) {
+				scoped.Deleted = append(scoped.Deleted, line)
+			}
+		}
+		if len(scoped.Added) > 0 || len(scoped.Deleted) > 0 || (scoped.RenamedFrom != "" && subject == "" && predicate == "") {
+			filtered = append(filtered, scoped)
+		}
+	}
+	return filtered
+}
+
+// indexState returns the state of HEAD and the state the next commit would
+// record, i.e. HEAD with staged renames and quads applied.
+func indexState() (head, staged map[string]quadSet, err error) {
+	headHash, err := resolveHead()
+	if err != nil {
+		return nil, nil, err
+	}
+	if head, err = loadState(headHash); err != nil {
+		return nil, nil, err
+	}
+	renames, err := readStagedRenames()
+	if err != nil {
+		return nil, nil, err
+	}
+	content, err := os.ReadFile(indexPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	staged, err = applyStaged(applyRenames(head, renames), lines)
+	return head, staged, err
+}
+
+// printDiff writes diffs as graph headers followed by +/- quad lines.
+func printDiff(diffs []graphDiff) {
+	for _, d := range diffs {
+		if d.RenamedFrom != "" {
+			fmt.Printf("rename %s -> %s\n", d.RenamedFrom, d.Graph)
+		} else {
+			fmt.Printf("graph %s\n", d.Graph)
+		}
+		for _, line := range d.Deleted {
+			fmt.Printf("- %s\n", line)
+		}
+		for _, line := range d.Added {
+			fmt.Printf("+ %s\n", line)
+		}
+	}
+}
+
+// rdfType is the predicate summarized as "types" in diff --summary.
+const rdfType = "<http://www.w3.org/1999/02/22-rdf-syntax-ns#type>"
+
+// localName shortens an IRI to the part after its last '#' or '/'.
+func localName(term string) string {
+	if !strings.HasPrefix(term, "<") {
+		return term
+	}
+	iri := strings.Trim(term, "<>")
+	if i := strings.LastIndexAny(iri, "#/"); i >= 0 && i < len(iri)-1 {
+		return iri[i+1:]
+	}
+	return iri
+}
+
+// displayValue renders an object term compactly: literals as 'value' and
+// IRIs by their local name.
+func displayValue(term string) string {
+	if strings.HasPrefix(term, `"`) {
+		if end := strings.LastIndex(term, `"`); end > 0 {
+			return "'" + term[1:end] + "'"
+		}
+	}
+	return localName(term)
+}
+
+// summarizeDiffs groups changes by subject and describes each subject's
+// changed properties in one line, e.g.
+// "<ex:x>: 2 properties changed (name: 'A'→'B', +2 types)".
+func summarizeDiffs(diffs []graphDiff) []string {
+	type change struct{ added, deleted []string }
+	bySubject := make(map[string]map[string]*change)
+	record := func(line string, added bool) {
+		q, err := parseQuad(line)
+		if err != nil {
+			return
+		}
+		if bySubject[q.Subject] == nil {
+			bySubject[q.Subject] = make(map[string]*change)
+		}
+		c := bySubject[q.Subject][q.Predicate]
+		if c == nil {
+			c = &change{}
+			bySubject[q.Subject][q.Predicate] = c
+		}
+		if added {
+			c.added = append(c.added, q.Object)
+		} else {
+			c.deleted = append(c.deleted, q.Object)
+		}
+	}
+	for _, d := range diffs {
+		for _, line := range d.Added {
+			record(line, true)
+		}
+		for _, line := range d.Deleted {
+			record(line, false)
+		}
+	}
+
+	subjects := make([]string, 0, len(bySubject))
+	for subject := range bySubject {
+		subjects = append(subjects, subject)
+	}
+	sort.Strings(subjects)
+	lines := make([]string, 0, len(subjects))
+	for _, subject := range subjects {
+		predicates := make([]string, 0, len(bySubject[subject]))
+		for predicate := range bySubject[subject] {
+			predicates = append(predicates, predicate)
+		}
+		sort.Strings(predicates)
+
+		var parts []string
+		for _, predicate := range predicates {
+			c := bySubject[subject][predicate]
+			name := localName(predicate)
+			if predicate == rdfType {
+				name = "types"
+			}
+			if len(c.added) == 1 && len(c.deleted) == 1 {
+				parts = append(parts, fmt.Sprintf("%s: %s→%s", name, displayValue(c.deleted[0]), displayValue(c.added[0])))
+				continue
+			}
+			if len(c.added) > 0 {
+				parts = append(parts, fmt.Sprintf("+%d %s", len(c.added), name))
+			}
+			if len(c.deleted) > 0 {
+				parts = append(parts, fmt.Sprintf("-%d %s", len(c.deleted), name))
+			}
+		}
+		noun := "properties"
+		if len(predicates) == 1 {
+			noun = "property"
+		}
+		lines = append(lines, fmt.Sprintf("%s: %d %s changed (%s)", subject, len(predicates), noun, strings.Join(parts, ", ")))
+	}
+	return lines
+}
+
+// printCommitDiff prints the changes between two commits as diff does,
+// streaming them (see streamCommitDiff), or their per-graph counts with
+// stat. Within a graph, statements are listed in order, additions and
+// deletions mixed. With labels, they are rendered by labelLine.
+func printCommitDiff(ctx context.Context, from, to string, opts quadstore.DiffOptions, stat bool, labels map[string]string) error {
+	stats := &quadstore.CommitStats{Graphs: make(map[string]quadstore.GraphStats)}
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	current := ""
+	err := streamCommitDiff(ctx, from, to, opts, func(graph, renamedFrom string, op quaddiff.Op, line string) error {
+		if stat {
+			g := stats.Graphs[graph]
+			if op == quaddiff.Added && line != "" {
+				g.Added++
+				stats.Added++
+			} else if line != "" {
+				g.Deleted++
+				stats.Deleted++
+			}
+			stats.Graphs[graph] = g
+			return nil
+		}
+		if graph != current {
+			current = graph
+			if renamedFrom != "" {
+				fmt.Fprintf(out, "rename %s -> %s\n", renamedFrom, graph)
+			} else {
+				fmt.Fprintf(out, "graph %s\n", graph)
+			}
+		}
+		if line == "" {
+			return nil
+		}
+		if labels != nil {
+			line = labelLine(line, labels)
+		}
+		_, err := fmt.Fprintf(out, "%s %s\n", op, line)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if stat {
+		out.Flush()
+		printStats(stats)
+	}
+	return nil
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff [--staged | <from> <to>]",
+	Short: "Show changes between commits, the index, and the working export",
+	Long: `Show quad-level changes.
+
+  diff              index vs. the working export (changes not yet staged)
+  diff --staged     HEAD vs. the index (what the next commit will contain)
+  diff <from> <to>  between two commits
+
+Comparisons with the index leave out quads matching .quadignore.
+
+Between two commits, changes are streamed as they are found: graphs whose
+blobs match are skipped unread, and only one graph's two versions are held
+in memory at a time, so commits of any size can be compared. Within a
+graph, statements are then listed in order, additions and deletions mixed.
+--stat counts them instead; --graph, --subject and --predicate narrow
+them.
+
+--use-labels shows predicates, and the classes of rdf:type statements, by
+their [rdfs:label] in the ontology graphs (ontology.graphs, or any graph if
+that is not set) of <to>, or of HEAD, rather than by IRI, for readers who
+know the domain better than its IRIs. Statements shown this way are no
+longer N-Quads.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		staged, _ := cmd.Flags().GetBool("staged")
+		var opts quadstore.DiffOptions
+		opts.Graph, _ = cmd.Flags().GetString("graph")
+		opts.Subject, _ = cmd.Flags().GetString("subject")
+		opts.Predicate, _ = cmd.Flags().GetString("predicate")
+		stat, _ := cmd.Flags().GetBool("stat")
+		var before, after map[string]quadSet
+		var err error
+		ctx := commandContext(cmd)
+		useLabels, _ := cmd.Flags().GetBool("use-labels")
+		var labels map[string]string
+		readLabels := func(hash string) {
+			if !useLabels {
+				return
+			}
+			if labels, err = termLabels(hash); err != nil {
+				log.Fatalf("Failed to read labels: %v", err)
+			}
+		}
+
+		switch {
+		case len(args) == 2 && !staged:
+			from, err := resolveCommitish(args[0])
+			if err != nil {
+				log.Fatalf("Could not resolve %s: %v", args[0], err)
+			}
+			to, err := resolveCommitish(args[1])
+			if err != nil {
+				log.Fatalf("Could not resolve %s: %v", args[1], err)
+			}
+			// A commit's stored stats already describe an unfiltered diff
+			// against its first parent
+			if stat && opts == (quadstore.DiffOptions{}) {
+				commit, err := readCommit(to)
+				if err != nil {
+					log.Fatalf("Failed to read %s: %v", args[1], err)
+				}
+				if commit.Stats != nil && commit.Stats.Graphs != nil && len(commit.Parents) > 0 && commit.Parents[0] == from {
+					printStats(commit.Stats)
+					return
+				}
+			}
+			if summary, _ := cmd.Flags().GetBool("summary"); !summary {
+				readLabels(to)
+				if err := printCommitDiff(ctx, from, to, opts, stat, labels); err != nil {
+					exitIfInterrupted(ctx, "the diff was stopped")
+					log.Fatalf("Failed to compute diff: %v", err)
+				}
+				return
+			}
+			// Only the subtree in scope needs to be read from either commit
+			prefix := opts.Graph
+			if prefix != "" && !strings.HasSuffix(prefix, "*") {
+				prefix = normalizeGraphName(prefix)
+			}
+			if before, err = loadStateUnder(ctx, from, prefix); err != nil {
+				log.Fatalf("Failed to read %s: %v", args[0], err)
+			}
+			if after, err = loadStateUnder(ctx, to, prefix); err != nil {
+				log.Fatalf("Failed to read %s: %v", args[1], err)
+			}
+		case len(args) == 0 && staged:
+			if before, after, err = indexState(); err != nil {
+				log.Fatalf("Failed to read the index: %v", err)
+			}
+		case len(args) == 0:
+			if _, before, err = indexState(); err != nil {
+				log.Fatalf("Failed to read the index: %v", err)
+			}
+			dir, err := exportDir()
+			if err != nil {
+				log.Fatalf("Failed to load config: %v", err)
+			}
+			if after, err = readWorkingExport(dir); err != nil {
+				log.Fatal(err)
+			}
+		default:
+			log.Fatal("Usage: quad-db diff [--staged | <from> <to>]")
+		}
+
+		exitIfInterrupted(ctx, "the diff was stopped")
+		diffs, err := diffStates(ctx, before, after)
+		exitIfInterrupted(ctx, "the diff was stopped")
+		if err != nil {
+			log.Fatalf("Failed to compute diff: %v", err)
+		}
+		diffs = filterDiffs(diffs, opts)
+		if len(args) == 0 {
+			rules, err := loadIgnoreRules()
+			if err != nil {
+				log.Fatalf("Failed to read %s: %v", ignorePath, err)
+			}
+			diffs = rules.filterDiffs(diffs)
+		}
+		if stat {
+			printStats(statsFromDiffs(diffs, after))
+			return
+		}
+		if summary, _ := cmd.Flags().GetBool("summary"); summary {
+			for _, line := range summarizeDiffs(diffs) {
+				fmt.Println(line)
+			}
+			return
+		}
+		if useLabels {
+			head, err := resolveHead()
+			if err != nil {
+				log.Fatalf("Failed to resolve HEAD: %v", err)
+			}
+			readLabels(head)
+			diffs = labelDiffs(diffs, labels)
+		}
+		printDiff(diffs)
+	},
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+// diskusage_other.go
+package main
+
+import "os"
+
+// diskFree is not implemented on this platform.
+func diskFree(path string) (free, total uint64, ok bool) {
+	return 0, 0, false
+}
+
+// allocatedSize returns the length of a file.
+func allocatedSize(info os.FileInfo) int64 {
+	return info.Size()
+}
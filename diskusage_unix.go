@@ -0,0 +1,28 @@
+//go:build unix
+
+// diskusage_unix.go
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// diskFree returns the bytes available to unprivileged users and the total
+// size of the filesystem holding path.
+func diskFree(path string) (free, total uint64, ok bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, false
+	}
+	return st.Bavail * uint64(st.Bsize), st.Blocks * uint64(st.Bsize), true
+}
+
+// allocatedSize returns the disk space a file occupies, which for sparse
+// files such as preallocated value logs is less than its length.
+func allocatedSize(info os.FileInfo) int64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return st.Blocks * 512
+	}
+	return info.Size()
+}
@@ -0,0 +1,283 @@
+// doctor.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+	"github.com/spf13/cobra"
+)
+
+// Severity of a doctor finding.
+const (
+	checkOK    = "ok"
+	checkWarn  = "warn"
+	checkError = "error"
+)
+
+const (
+	// minFreeBytes is the free disk space below which doctor warns; Badger
+	// needs room to write new tables and rewrite value logs.
+	minFreeBytes = 1 << 30
+	// vlogSlack is how much value-log space beyond twice the live values is
+	// tolerated before doctor suggests GC.
+	vlogSlack = 64 << 20
+)
+
+// finding is the outcome of one doctor check, with a suggested fix for
+// anything that is not ok.
+type finding struct {
+	Level, Check, Message, Fix string
+}
+
+// doctor collects findings as checks run.
+type doctor struct {
+	findings []finding
+}
+
+func (d *doctor) ok(check, format string, v ...interface{}) {
+	d.findings = append(d.findings, finding{checkOK, check, fmt.Sprintf(format, v...), ""})
+}
+
+func (d *doctor) warn(check, fix, format string, v ...interface{}) {
+	d.findings = append(d.findings, finding{checkWarn, check, fmt.Sprintf(format, v...), fix})
+}
+
+func (d *doctor) fail(check, fix, format string, v ...interface{}) {
+	d.findings = append(d.findings, finding{checkError, check, fmt.Sprintf(format, v...), fix})
+}
+
+// checkStore opens the database, reporting whether it is locked or
+// unreadable. It returns false if the remaining checks cannot run.
+func (d *doctor) checkStore() bool {
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		d.fail("repo", "Run 'quad-db init' or change to a repository's directory.", "no %s directory here", dbPath)
+		return false
+	}
+	if _, err := openDB(); errors.Is(err, quadstore.ErrRepoLocked) {
+		d.fail("lock", "Stop the other process (often 'quad-db serve') and run doctor again.", "another process has the repository open")
+		return false
+	} else if err != nil {
+		d.fail("format", "The store may be corrupt or written by an incompatible Badger version; restore it from a backup.", "cannot open the store: %v", err)
+		return false
+	}
+	d.ok("lock", "no other process has the repository open")
+	d.ok("format", "Badger v4 store opened cleanly")
+	return true
+}
+
+// checkRefs verifies that every reference points at a commit, or an
+// annotated tag of one, or, for symbolic references, at another reference.
+func (d *doctor) checkRefs() {
+	refs, err := listReferences("")
+	if err != nil {
+		d.fail("refs", "", "cannot list references: %v", err)
+		return
+	}
+	if _, ok := refs["HEAD"]; !ok {
+		d.fail("refs", "Point HEAD at a branch, e.g. with 'quad-db refs import'.", "HEAD is missing")
+	}
+	dangling := 0
+	for name, value := range refs {
+		if target, symbolic := strings.CutPrefix(value, "ref:"); symbolic {
+			if _, ok := refs[target]; !ok {
+				d.fail("refs", "Re-point it at an existing branch with 'quad-db refs import'.", "%s points to %s, which does not exist", name, target)
+				dangling++
+			}
+			continue
+		}
+		if _, err := peelTag(value); err != nil {
+			d.fail("refs", "Fetch the missing history from a remote, or delete the reference with 'quad-db refs import --prune'.", "%s points to %s, which is not in the repository", name, shortHash(value))
+			dangling++
+		}
+	}
+	if dangling == 0 {
+		d.ok("refs", "%d references, none dangling", len(refs))
+	}
+}
+
+// checkIndex verifies that the staged quads parse and that staged renames
+// refer to graphs that exist.
+func (d *doctor) checkIndex() {
+	data, err := os.ReadFile(indexPath)
+	if err != nil && !os.IsNotExist(err) {
+		d.fail("index", "", "cannot read %s: %v", indexPath, err)
+		return
+	}
+	bad, staged := 0, 0
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		staged++
+		if _, err := parseQuad(line); err != nil {
+			if bad < 5 {
+				d.fail("index", "", "line %d: %v", i+1, err)
+			}
+			bad++
+		}
+	}
+	if bad > 0 {
+		d.fail("index", fmt.Sprintf("Fix or remove the invalid lines in %s, or empty it to unstage everything.", indexPath), "%d of %d staged quads cannot be parsed", bad, staged)
+		return
+	}
+
+	renames, err := readStagedRenames()
+	if err != nil {
+		d.fail("index", fmt.Sprintf("Delete %s and stage the renames again with 'quad-db mv'.", renamesPath), "staged renames are unreadable: %v", err)
+		return
+	}
+	if len(renames) > 0 {
+		head, err := resolveHead()
+		if err == nil {
+			var graphs map[string]string
+			if commit, err := readCommit(head); err == nil {
+				graphs, _ = readGraphs(commit.Tree)
+			}
+			for oldName := range renames {
+				if _, ok := graphs[oldName]; !ok {
+					d.warn("index", fmt.Sprintf("Delete %s and stage the renames again with 'quad-db mv'.", renamesPath), "staged rename of %s, which is not in HEAD", oldName)
+					return
+				}
+			}
+		}
+	}
+	d.ok("index", "%d staged quads and %d staged renames are well-formed", staged, len(renames))
+}
+
+// checkHeadTree reports whether HEAD's root tree still uses the original
+// flat encoding, which is readable but superseded.
+func (d *doctor) checkHeadTree() {
+	head, err := resolveHead()
+	if err != nil {
+		return
+	}
+	commit, err := readCommit(head)
+	if err != nil {
+		return
+	}
+	data, err := readRawObject(commit.Tree)
+	if err != nil {
+		d.fail("objects", "Fetch the missing objects from a remote or restore from a backup.", "HEAD's tree %s is missing", shortHash(commit.Tree))
+		return
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		d.fail("objects", "Restore the repository from a backup.", "HEAD's tree %s is corrupt: %v", shortHash(commit.Tree), err)
+		return
+	}
+	for _, entry := range raw {
+		if strings.HasPrefix(string(entry), `"`) {
+			d.warn("objects", "No action needed; the next commit writes the current format.", "HEAD's tree uses the original flat encoding")
+			return
+		}
+	}
+	d.ok("objects", "HEAD's tree uses the current encoding")
+}
+
+// checkDisk warns when the filesystem holding the repository is nearly full.
+func (d *doctor) checkDisk() {
+	path := storePath
+	if _, err := os.Stat(path); err != nil {
+		path = "."
+	}
+	free, total, ok := diskFree(path)
+	if !ok {
+		d.ok("disk", "free space check not supported on this platform")
+		return
+	}
+	if free < minFreeBytes || free < total/20 {
+		d.warn("disk", "Free up space; Badger needs room for compaction and value-log GC.", "only %s free of %s", formatBytes(int64(free)), formatBytes(int64(total)))
+		return
+	}
+	d.ok("disk", "%s free", formatBytes(int64(free)))
+}
+
+// checkValueLog compares the space the value log occupies with the size of
+// the values still live, suggesting GC when most of it is garbage.
+func (d *doctor) checkValueLog() {
+	files, err := filepath.Glob(filepath.Join(storePath, "*.vlog"))
+	if err != nil {
+		return
+	}
+	var onDisk int64
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil {
+			onDisk += allocatedSize(info)
+		}
+	}
+	var live int64
+	err = repo.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			live += it.Item().ValueSize()
+		}
+		return nil
+	})
+	if err != nil {
+		d.fail("value log", "", "cannot scan keys: %v", err)
+		return
+	}
+	if onDisk > 2*live+vlogSlack {
+		d.warn("value log", "Run 'quad-db optimize', or 'quad-db serve --gc-interval 10m' for servers.", "%d file(s) occupy %s for %s of live values", len(files), formatBytes(onDisk), formatBytes(live))
+		return
+	}
+	d.ok("value log", "%d file(s), %s on disk", len(files), formatBytes(onDisk))
+}
+
+// formatBytes renders a byte count with a binary unit.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check the repository's health and suggest fixes",
+	Long: `Diagnose common problems: whether another process holds the repository
+lock, whether the store opens, dangling references, an unparsable index,
+low disk space and a value log bloated by garbage. Each problem comes with
+a suggested fix. Exits with status 1 if any check fails.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		var d doctor
+		if d.checkStore() {
+			d.checkRefs()
+			d.checkIndex()
+			d.checkHeadTree()
+			d.checkValueLog()
+		}
+		d.checkDisk()
+
+		failed := false
+		for _, f := range d.findings {
+			fmt.Printf("%-5s  %-9s  %s\n", f.Level, f.Check, f.Message)
+			if f.Fix != "" {
+				fmt.Printf("%-5s  %-9s  fix: %s\n", "", "", f.Fix)
+			}
+			failed = failed || f.Level == checkError
+		}
+		if failed {
+			closeDB()
+			os.Exit(1)
+		}
+	},
+}
@@ -0,0 +1,96 @@
+// encoding.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Objects are addressed by the SHA-1 of their encoding, so identical content
+// must always encode to identical bytes, whichever version or platform wrote
+// it. The canonical encodings are:
+//
+//   - Blob: a JSON array of its statements, each re-serialized from its
+//     parsed terms (single spaces, " ." terminator), sorted by byte value and
+//     without duplicates.
+//   - Tree: a JSON object whose keys are the entry names sorted by byte
+//     value, each mapping to {"blob":...,"tree":...,"checksum":...,"quads":...}
+//     with empty fields omitted.
+//   - Commit: the JSON encoding of Commit, fields in declaration order.
+//
+// JSON strings are escaped as encoding/json does, HTML-safe escapes
+// included. These are the bytes earlier versions wrote for sorted blobs, so
+// existing objects keep their hashes.
+
+// encodeObject returns the canonical encoding of a stored object.
+func encodeObject(obj interface{}) ([]byte, error) {
+	switch o := obj.(type) {
+	case Blob:
+		return encodeBlob(o)
+	case *Blob:
+		return encodeBlob(*o)
+	case Tree:
+		return encodeTree(o)
+	case *Tree:
+		return encodeTree(*o)
+	}
+	return json.Marshal(obj)
+}
+
+// canonicalBlob returns the statements of blob in canonical form.
+func canonicalBlob(blob Blob) (Blob, error) {
+	lines := make(Blob, 0, len(blob))
+	for _, line := range blob {
+		q, err := parseQuad(line)
+		if err != nil {
+			return nil, fmt.Errorf("cannot encode statement %q: %w", line, err)
+		}
+		lines = append(lines, formatQuad(q))
+	}
+	sort.Strings(lines)
+	unique := lines[:0]
+	for i, line := range lines {
+		if i == 0 || line != lines[i-1] {
+			unique = append(unique, line)
+		}
+	}
+	return unique, nil
+}
+
+func encodeBlob(blob Blob) ([]byte, error) {
+	lines, err := canonicalBlob(blob)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal([]string(lines))
+}
+
+func encodeTree(tree Tree) ([]byte, error) {
+	names := make([]string, 0, len(tree))
+	for name := range tree {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		entry, err := json.Marshal(tree[name])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(entry)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,102 @@
+// encoding_test.go
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestEncodeBlobStable(t *testing.T) {
+	canonical := Blob{
+		`<http://example.org/a> <http://example.org/p> "one" <http://example.org/g> .`,
+		`<http://example.org/b> <http://example.org/p> "two" <http://example.org/g> .`,
+	}
+	want, err := encodeBlob(canonical)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Earlier versions wrote sorted blobs with encoding/json; those objects
+	// must keep their hashes.
+	if legacy, _ := json.Marshal([]string(canonical)); string(want) != string(legacy) {
+		t.Errorf("canonical blob encodes as %s, want %s", want, legacy)
+	}
+	for _, tt := range []struct {
+		name string
+		blob Blob
+	}{
+		{"reordered", Blob{canonical[1], canonical[0]}},
+		{"duplicated", Blob{canonical[0], canonical[1], canonical[0]}},
+		{"whitespace", Blob{
+			`<http://example.org/b>   <http://example.org/p>	"two"  <http://example.org/g>  .`,
+			` <http://example.org/a> <http://example.org/p> "one" <http://example.org/g>.`,
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := encodeBlob(tt.blob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("encodeBlob = %s, want %s", got, want)
+			}
+			if h := mustHash(t, tt.blob); h != mustHash(t, canonical) {
+				t.Errorf("hash %s differs from the canonical blob's", h)
+			}
+		})
+	}
+	if _, err := encodeBlob(Blob{"not a statement"}); err == nil {
+		t.Error("encodeBlob accepted a malformed statement")
+	}
+}
+
+func TestEncodeTreeStable(t *testing.T) {
+	data := []byte(`{"b":{"blob":"2222","quads":2},"a":{"tree":"1111"},"c":{"blob":"3333","checksum":"ff","quads":1}}`)
+	var tree Tree
+	if err := json.Unmarshal(data, &tree); err != nil {
+		t.Fatal(err)
+	}
+	got, err := encodeTree(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"a":{"tree":"1111"},"b":{"blob":"2222","quads":2},"c":{"blob":"3333","checksum":"ff","quads":1}}`
+	if string(got) != want {
+		t.Errorf("encodeTree = %s, want %s", got, want)
+	}
+	// Maps iterate in random order; every encoding must come out the same.
+	for i := 0; i < 20; i++ {
+		again := Tree{}
+		for name, entry := range tree {
+			again[name] = entry
+		}
+		if h := mustHash(t, again); h != mustHash(t, tree) {
+			t.Fatalf("tree hashes differ: %s and %s", h, mustHash(t, tree))
+		}
+	}
+}
+
+func TestTreeEntryLegacyEncoding(t *testing.T) {
+	var tree Tree
+	if err := json.Unmarshal([]byte(`{"http:":"1111","g":{"blob":"2222","tree":"3333"}}`), &tree); err != nil {
+		t.Fatal(err)
+	}
+	if got := tree["http:"]; got != (TreeEntry{Blob: "1111"}) {
+		t.Errorf("legacy entry decoded as %+v, want only blob 1111", got)
+	}
+	if got := tree["g"]; got != (TreeEntry{Blob: "2222", Tree: "3333"}) {
+		t.Errorf("entry decoded as %+v, want blob 2222 and tree 3333", got)
+	}
+	var entry TreeEntry
+	if err := json.Unmarshal([]byte(`42`), &entry); err == nil {
+		t.Errorf("a number decoded as entry %+v", entry)
+	}
+}
+
+func mustHash(t *testing.T, obj interface{}) string {
+	t.Helper()
+	h, err := hashObject(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
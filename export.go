@@ -0,0 +1,349 @@
+// export.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+	"github.com/mannyrivera2010/go-quadgit/pkg/rdfio"
+	"github.com/spf13/cobra"
+)
+
+// defaultExportDir is where graphs are materialized when core.exportDir is
+// not configured.
+const defaultExportDir = "export"
+
+// exportDir returns the configured working export directory.
+func exportDir() (string, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return "", err
+	}
+	if dir := cfg.Get("core.exportDir"); dir != "" {
+		return dir, nil
+	}
+	return defaultExportDir, nil
+}
+
+// saveExportDir makes dir the working export, as core.exportDir.
+func saveExportDir(dir string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	cfg.Set("core.exportDir", dir)
+	return cfg.Save()
+}
+
+// graphFileName returns the file a graph is exported to: a readable form of
+// its name plus a short hash that keeps distinct graphs apart.
+func graphFileName(graph string) string {
+	readable := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, strings.Trim(graph, "<>"))
+	sum := sha1.Sum([]byte(graph))
+	return fmt.Sprintf("%s-%s.nq", strings.Trim(readable, "_"), hex.EncodeToString(sum[:4]))
+}
+
+// graphQuads returns the sorted N-Quads lines of a stored graph, restoring
+// the graph term that blobs omit.
+func graphQuads(graph string, set quadSet) []string {
+	lines := make([]string, 0, len(set))
+	for line := range set {
+		q, err := parseQuad(line)
+		if err != nil {
+			lines = append(lines, line)
+			continue
+		}
+		q.Graph = ""
+		if graph != defaultGraph {
+			q.Graph = graph
+		}
+		lines = append(lines, formatQuad(q))
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// streamBlobLines calls fn with the lines of a blob in order, decoding them
+// one at a time rather than into a Blob.
+func streamBlobLines(hash string, fn func(line string) error) error {
+	var data []byte
+	err := readPromised(hash, func() (err error) {
+		data, err = readRawObject(hash)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil {
+		return fmt.Errorf("blob %s: %v", hash, err)
+	} else if tok == nil {
+		return nil
+	} else if tok != json.Delim('[') {
+		return fmt.Errorf("blob %s: not a list of lines", hash)
+	}
+	for dec.More() {
+		var line string
+		if err := dec.Decode(&line); err != nil {
+			return fmt.Errorf("blob %s: %v", hash, err)
+		}
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storedGraph returns the hash of the blob holding a graph at a commit,
+// failing with ErrNotFound if the commit has no such graph.
+func storedGraph(hash, graph string) (string, error) {
+	commit, err := readCommit(hash)
+	if err != nil {
+		return "", err
+	}
+	blob, err := graphBlob(commit, graph)
+	if err == nil && blob == "" {
+		err = fmt.Errorf("graph %s at %s %w", graph, shortHash(hash), quadstore.ErrNotFound)
+	}
+	return blob, err
+}
+
+// exportGraph writes a graph at the commit ref resolves to to w as it reads
+// it, through a writer with a fixed-size buffer, so exporting a graph takes
+// no more memory than its stored encoding however large it is, and a slow
+// reader on the other end of w holds the export back.
+func exportGraph(ctx context.Context, ref, graph string, w io.Writer, format quadstore.Format) error {
+	if format != quadstore.FormatNQuads && format != quadstore.FormatNTriples {
+		return fmt.Errorf("cannot stream %s; only line-oriented formats can", format)
+	}
+	hash, err := resolveCommitish(ref)
+	if err != nil {
+		return err
+	}
+	graph = normalizeGraphName(graph)
+	blob, err := storedGraph(hash, graph)
+	if err != nil {
+		return err
+	}
+	out := rdfio.NewWriter(w)
+	n := 0
+	err = streamBlobLines(blob, func(line string) error {
+		if n++; n%1024 == 0 && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		q, err := parseQuad(line)
+		if err != nil {
+			return nil
+		}
+		q.Graph = ""
+		if format == quadstore.FormatNQuads && graph != defaultGraph {
+			q.Graph = graph
+		}
+		return out.Write(q)
+	})
+	if err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+// exportManifest is the file in a working export that lists the files
+// export wrote there, one name per line.
+const exportManifest = ".quad-db-export"
+
+// exportedFiles returns the names the manifest in dir lists, or none if dir
+// has no manifest. Names that are not plain file names are ignored, so
+// that an edited manifest cannot point outside dir.
+func exportedFiles(dir string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, exportManifest))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, name := range strings.Split(string(data), "\n") {
+		if name != "" && name != "." && name != ".." && name == filepath.Base(name) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// exportState replaces the files an earlier export wrote to dir, as its
+// manifest lists them, with one .nq file per graph. Other files in dir are
+// left alone.
+func exportState(state map[string]quadSet, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	stale, err := exportedFiles(dir)
+	if err != nil {
+		return err
+	}
+	for _, name := range stale {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	var written []string
+	for graph, set := range state {
+		if len(set) == 0 {
+			continue
+		}
+		name := graphFileName(graph)
+		content := strings.Join(graphQuads(graph, set), "\n") + "\n"
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			return err
+		}
+		written = append(written, name)
+	}
+	sort.Strings(written)
+	manifest := strings.Join(written, "\n") + "\n"
+	return os.WriteFile(filepath.Join(dir, exportManifest), []byte(manifest), 0644)
+}
+
+// readWorkingExport parses every .nq file under dir into a state, storing
+// quads in the same triple form the repository uses.
+func readWorkingExport(dir string) (map[string]quadSet, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("no working export at %s (run 'quad-db export'): %v", dir, err)
+	}
+	state := make(map[string]quadSet)
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(path) != ".nq" {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return readQuadsInto(state, f, path, "")
+	})
+	return state, err
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export [<commit>]",
+	Short: "Materialize a commit's graphs as N-Quads files",
+	Long: `Materialize a commit's graphs as files.
+
+The default format, nquads, writes one .nq file per graph into the working
+export directory that diff compares against, core.exportDir or ./export,
+replacing the files an earlier export wrote there and nothing else. --dir
+exports elsewhere, once; with --save-dir it becomes the working export. The hdt format writes one
+compact HDT file per graph for archival; 'add' stages such files back. The
+neptune-nquads and neptune-csv formats write gzipped files in the layout
+the AWS Neptune bulk loader expects (RDF N-Quads, or Gremlin vertex and
+edge CSV), split every --split-size rows.
+
+With --graph, write just that graph to standard output instead, as nquads
+or ntriples, streaming it so that graphs larger than memory can be
+exported.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		rev := "HEAD"
+		if len(args) == 1 {
+			rev = args[0]
+		}
+		hash, err := resolveCommitish(rev)
+		if err != nil {
+			log.Fatalf("Could not resolve %s: %v", rev, err)
+		}
+		format, _ := cmd.Flags().GetString("format")
+		dir, _ := cmd.Flags().GetString("dir")
+		guard, err := exportGuard(cmd)
+		if err != nil {
+			log.Fatalf("Failed to load config: %v", err)
+		}
+
+		if graph, _ := cmd.Flags().GetString("graph"); graph != "" {
+			var streamed quadstore.Format
+			switch format {
+			case "nquads":
+				streamed = quadstore.FormatNQuads
+			case "ntriples":
+				streamed = quadstore.FormatNTriples
+			default:
+				log.Fatalf("--graph writes nquads or ntriples, not %s", format)
+			}
+			graph = normalizeGraphName(graph)
+			blob, err := storedGraph(hash, graph)
+			if err != nil {
+				log.Fatalf("Failed to export: %v", err)
+			}
+			if err := guard.checkBlob(graph, blob); err != nil {
+				log.Fatalf("Refusing to export: %v", err)
+			}
+			if err := exportGraph(context.Background(), hash, graph, os.Stdout, streamed); err != nil {
+				log.Fatalf("Failed to export: %v", err)
+			}
+			return
+		}
+
+		state, err := loadState(hash)
+		if err != nil {
+			log.Fatalf("Failed to read commit: %v", err)
+		}
+		if err := guard.checkState(state); err != nil {
+			log.Fatalf("Refusing to export: %v", err)
+		}
+
+		if format != "nquads" {
+			if dir == "" {
+				log.Fatalf("--dir is required with --format %s", format)
+			}
+			splitSize, _ := cmd.Flags().GetInt("split-size")
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				log.Fatalf("Failed to export: %v", err)
+			}
+			switch format {
+			case "hdt":
+				err = exportHDT(state, dir)
+			case "neptune-nquads":
+				err = exportNeptuneNQuads(state, dir, splitSize)
+			case "neptune-csv":
+				err = exportNeptuneCSV(state, dir, splitSize)
+			default:
+				log.Fatalf("Unknown format %q (want nquads, hdt, neptune-nquads or neptune-csv)", format)
+			}
+			if err != nil {
+				log.Fatalf("Failed to export: %v", err)
+			}
+			fmt.Printf("Exported %d graph(s) from %s to %s as %s\n", len(state), hash[:7], dir, format)
+			return
+		}
+
+		if dir == "" {
+			if dir, err = exportDir(); err != nil {
+				log.Fatalf("Failed to load config: %v", err)
+			}
+		}
+		if err := exportState(state, dir); err != nil {
+			log.Fatalf("Failed to export: %v", err)
+		}
+		if save, _ := cmd.Flags().GetBool("save-dir"); save {
+			if err := saveExportDir(dir); err != nil {
+				log.Fatalf("Failed to save config: %v", err)
+			}
+		}
+		fmt.Printf("Exported %d graph(s) from %s to %s\n", len(state), hash[:7], dir)
+	},
+}
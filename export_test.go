@@ -0,0 +1,85 @@
+// export_test.go
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"testing"
+)
+
+func TestExportStateReplacesOnlyItsFiles(t *testing.T) {
+	const (
+		g1 = "<http://example.org/g1>"
+		g2 = "<http://example.org/g2>"
+	)
+	quad := `<http://example.org/s> <http://example.org/p> "o" .`
+	tests := []struct {
+		name     string
+		first    []string // Graphs exported first
+		second   []string // Graphs exported over them
+		manifest string   // Written between the two, if set
+		want     []string
+	}{
+		{"graph removed", []string{g1, g2}, []string{g1}, "", []string{graphFileName(g1)}},
+		{"graph added", []string{g1}, []string{g1, g2}, "", []string{graphFileName(g1), graphFileName(g2)}},
+		{"manifest pointing outside", []string{g1}, []string{g2}, "../outside.nq\n" + graphFileName(g1) + "\n", []string{graphFileName(g2)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parent := t.TempDir()
+			dir := filepath.Join(parent, "export")
+			own := []string{"notes.nq", "README"}
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range own {
+				if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			outside := filepath.Join(parent, "outside.nq")
+			if err := os.WriteFile(outside, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+			state := func(graphs []string) map[string]quadSet {
+				s := make(map[string]quadSet)
+				for _, g := range graphs {
+					s[g] = quadSet{quad: true}
+				}
+				return s
+			}
+			if err := exportState(state(tt.first), dir); err != nil {
+				t.Fatal(err)
+			}
+			if tt.manifest != "" {
+				if err := os.WriteFile(filepath.Join(dir, exportManifest), []byte(tt.manifest), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := exportState(state(tt.second), dir); err != nil {
+				t.Fatal(err)
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range entries {
+				if e.Name() != exportManifest {
+					got = append(got, e.Name())
+				}
+			}
+			want := append(append([]string(nil), own...), tt.want...)
+			sort.Strings(want)
+			if !slices.Equal(got, want) {
+				t.Errorf("export left %q, want %q", got, want)
+			}
+			if _, err := os.Stat(outside); err != nil {
+				t.Errorf("file outside the export was touched: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+// exportgit.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// topoOrder returns the commits reachable from tip, a commit or annotated
+// tag, parents before children.
+func topoOrder(tip string) ([]string, error) {
+	tip, err := peelTag(tip)
+	if err != nil {
+		return nil, err
+	}
+	var order []string
+	done := make(map[string]bool)
+	type frame struct {
+		hash    string
+		parents []string
+	}
+	var stack []frame
+	push := func(hash string) error {
+		commit, err := readCommit(hash)
+		if err != nil {
+			return err
+		}
+		done[hash] = true
+		stack = append(stack, frame{hash, commit.Parents})
+		return nil
+	}
+	if err := push(tip); err != nil {
+		return nil, err
+	}
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		if len(top.parents) == 0 {
+			order = append(order, top.hash)
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		next := top.parents[0]
+		top.parents = top.parents[1:]
+		if !done[next] {
+			if err := push(next); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return order, nil
+}
+
+// splitAuthor splits a commit author of the form "Name <email>" into its
+// parts. A bare author is used as both name and, if it looks like one, email.
+func splitAuthor(author string) (name, email string) {
+	if start := strings.LastIndex(author, "<"); start >= 0 && strings.HasSuffix(author, ">") {
+		name = strings.TrimSpace(author[:start])
+		email = author[start+1 : len(author)-1]
+	} else {
+		name = author
+		if strings.Contains(author, "@") {
+			email = author
+		}
+	}
+	if name == "" {
+		name = email
+	}
+	return name, email
+}
+
+var exportGitCmd = &cobra.Command{
+	Use:   "export-git <path>",
+	Short: "Write a branch's history to a git repository",
+	Long: `Recreate a branch's history in the git repository at <path>, creating it if
+needed. Each commit becomes a git commit holding one canonical, sorted .nq
+file per graph, with the original author, message and timestamp.
+
+Exports are deterministic: exporting the same history again produces the
+same git commits, so a growing branch can be re-exported and pushed for
+review with ordinary git tooling.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dir := args[0]
+		branch, _ := cmd.Flags().GetString("branch")
+		if branch == "" {
+			branch = currentBranch()
+		}
+		tip, err := resolveCommitish(branch)
+		if err != nil {
+			log.Fatalf("Could not resolve %s: %v", branch, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, ".git")); os.IsNotExist(err) {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				log.Fatalf("Failed to create %s: %v", dir, err)
+			}
+			if _, err := runGit(dir, "init", "-q"); err != nil {
+				log.Fatal(err)
+			}
+			if _, err := runGit(dir, "symbolic-ref", "HEAD", "refs/heads/"+branch); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		guard, err := exportGuard(cmd)
+		if err != nil {
+			log.Fatalf("Failed to load config: %v", err)
+		}
+		order, err := topoOrder(tip)
+		if err != nil {
+			log.Fatalf("Failed to walk history: %v", err)
+		}
+		gitBlobs := make(map[string]string)
+		gitCommits := make(map[string]string, len(order))
+		for _, hash := range order {
+			commit, err := readCommit(hash)
+			if err != nil {
+				log.Fatalf("Failed to read commit %s: %v", hash[:7], err)
+			}
+			graphs, err := readGraphs(commit.Tree)
+			if err != nil {
+				log.Fatalf("Failed to read tree of %s: %v", hash[:7], err)
+			}
+
+			// 1. One file per graph; identical blobs map to the same git blob
+			var entries []string
+			for graph, blobHash := range graphs {
+				gitBlob, ok := gitBlobs[blobHash]
+				if !ok {
+					blob, err := readBlob(blobHash)
+					if err != nil {
+						log.Fatalf("Failed to read blob %s: %v", blobHash[:7], err)
+					}
+					if err := guard.checkLines(graph, blob); err != nil {
+						log.Fatalf("Refusing to export: %v", err)
+					}
+					set := make(quadSet, len(blob))
+					for _, line := range blob {
+						set[line] = true
+					}
+					content := strings.Join(graphQuads(graph, set), "\n") + "\n"
+					out, err := runGitWith(dir, nil, []byte(content), "hash-object", "-w", "--stdin")
+					if err != nil {
+						log.Fatal(err)
+					}
+					gitBlob = strings.TrimSpace(string(out))
+					gitBlobs[blobHash] = gitBlob
+				}
+				entries = append(entries, fmt.Sprintf("100644 blob %s\t%s\n", gitBlob, graphFileName(graph)))
+			}
+			sort.Strings(entries)
+			out, err := runGitWith(dir, nil, []byte(strings.Join(entries, "")), "mktree")
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			// 2. The commit, authored and committed as the original
+			commitArgs := []string{"commit-tree", strings.TrimSpace(string(out)), "-F", "-"}
+			for _, parent := range commit.Parents {
+				commitArgs = append(commitArgs, "-p", gitCommits[parent])
+			}
+			name, email := splitAuthor(commit.Author)
+			date := commit.Timestamp.Format("2006-01-02T15:04:05-07:00")
+			env := []string{
+				"GIT_AUTHOR_NAME=" + name, "GIT_AUTHOR_EMAIL=" + email, "GIT_AUTHOR_DATE=" + date,
+				"GIT_COMMITTER_NAME=" + name, "GIT_COMMITTER_EMAIL=" + email, "GIT_COMMITTER_DATE=" + date,
+			}
+			if out, err = runGitWith(dir, env, []byte(commit.Message), commitArgs...); err != nil {
+				log.Fatal(err)
+			}
+			gitCommits[hash] = strings.TrimSpace(string(out))
+		}
+
+		head := gitCommits[tip]
+		if _, err := runGit(dir, "update-ref", "refs/heads/"+branch, head); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Exported %d commit(s) of %s to %s (%s)\n", len(order), branch, dir, head[:7])
+	},
+}
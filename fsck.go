@@ -0,0 +1,120 @@
+// fsck.go
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/spf13/cobra"
+)
+
+// corruptObjects re-hashes every stored object and returns the hashes of
+// those whose bytes no longer match, together with how many were checked.
+func corruptObjects() (corrupt []string, checked int, err error) {
+	err = repo.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte("obj:")
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			hash := strings.TrimPrefix(string(it.Item().Key()), "obj:")
+			err := it.Item().Value(func(val []byte) error {
+				data, err := objectEncoding(val)
+				if err != nil {
+					corrupt = append(corrupt, hash)
+				} else if sum := sha1.Sum(data); hex.EncodeToString(sum[:]) != hash {
+					corrupt = append(corrupt, hash)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			checked++
+		}
+		return nil
+	})
+	return corrupt, checked, err
+}
+
+// checksumMismatches checks the graph summaries of every tree reachable from
+// a ref (see checkTreeSummaries).
+func checksumMismatches() ([]checksumMismatch, error) {
+	defer suspendReplacements()()
+	refs, err := listReferences("")
+	if err != nil {
+		return nil, err
+	}
+	tips := make([]string, 0, len(refs))
+	for _, hash := range refs {
+		tips = append(tips, hash)
+	}
+	commits, err := reachableFrom(tips)
+	if err != nil {
+		return nil, err
+	}
+	var mismatches []checksumMismatch
+	seen := make(map[string]bool)
+	for hash := range commits {
+		commit, err := readCommit(hash)
+		if err != nil {
+			return nil, err
+		}
+		found, err := checkTreeSummaries(commit.Tree, nil, seen)
+		if err != nil {
+			return nil, fmt.Errorf("commit %s: %w", shortHash(hash), err)
+		}
+		mismatches = append(mismatches, found...)
+	}
+	sort.Slice(mismatches, func(i, j int) bool { return mismatches[i].Graph < mismatches[j].Graph })
+	return mismatches, nil
+}
+
+var fsckCmd = &cobra.Command{
+	Use:   "fsck",
+	Short: "Verify that every stored object matches its hash",
+	Long: `Re-hash every object in the repository and list those whose stored bytes
+no longer match their hash, e.g. after disk corruption. Exits with status 1
+if any are found; restore them by fetching from a remote that has them.
+
+It also re-reads the graphs of every commit reachable from a ref and
+compares each with the checksum and quad count its tree entry records,
+listing the graphs that disagree. Trees written before entries recorded
+them are not checked.
+
+Set core.verifyObjects to true to check each object as it is read instead,
+so that commands fail on corrupt data rather than use it.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		corrupt, checked, err := corruptObjects()
+		if err != nil {
+			log.Fatalf("Failed to read objects: %v", err)
+		}
+		for _, hash := range corrupt {
+			fmt.Printf("corrupt object %s\n", hash)
+		}
+		fmt.Printf("Checked %d object(s), %d corrupt\n", checked, len(corrupt))
+		if len(corrupt) > 0 {
+			closeDB()
+			os.Exit(1)
+		}
+		mismatches, err := checksumMismatches()
+		if err != nil {
+			log.Fatalf("Failed to check graph checksums: %v", err)
+		}
+		for _, m := range mismatches {
+			fmt.Printf("checksum mismatch %s\n", m)
+		}
+		if len(mismatches) > 0 {
+			fmt.Printf("%d graph(s) do not match their tree entries\n", len(mismatches))
+			closeDB()
+			os.Exit(1)
+		}
+	},
+}
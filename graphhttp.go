@@ -0,0 +1,513 @@
+// graphhttp.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+	"github.com/mannyrivera2010/go-quadgit/pkg/rdfio"
+)
+
+// A graphFormat is an RDF serialization the graph endpoints speak.
+type graphFormat struct {
+	mediaType string
+	// named reports whether the format can carry graph names; Turtle and
+	// N-Triples hold a single graph.
+	named bool
+	write func(w io.Writer, quads []quadstore.Quad) error
+	parse func(r io.Reader, base string) ([]quadstore.Quad, error)
+}
+
+// graphFormats are listed in order of preference, which breaks ties
+// between equally acceptable types.
+var graphFormats = []graphFormat{
+	{"application/n-quads", true, writeNQuads, parseNQuads},
+	{"application/trig", true, rdfio.WriteTriG, rdfio.ParseTriG},
+	{"application/ld+json", true, rdfio.WriteJSONLD, func(r io.Reader, _ string) ([]quadstore.Quad, error) { return rdfio.ParseJSONLD(r) }},
+	{"text/turtle", false, rdfio.WriteTurtle, rdfio.ParseTurtle},
+	{"application/n-triples", false, writeNQuads, parseNQuads},
+}
+
+// formatAliases are other media types clients send for the formats above.
+var formatAliases = map[string]string{
+	"text/plain":         "application/n-quads",
+	"text/x-nquads":      "application/n-quads",
+	"application/x-trig": "application/trig",
+	"application/json":   "application/ld+json",
+	"application/turtle": "text/turtle",
+}
+
+func writeNQuads(w io.Writer, quads []quadstore.Quad) error {
+	out := rdfio.NewWriter(w)
+	for _, q := range quads {
+		if err := out.Write(q); err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
+
+func parseNQuads(r io.Reader, _ string) ([]quadstore.Quad, error) {
+	var quads []quadstore.Quad
+	reader := rdfio.NewReader(r)
+	for {
+		q, err := reader.Read()
+		if err == io.EOF {
+			return quads, nil
+		} else if err != nil {
+			return nil, err
+		}
+		quads = append(quads, q)
+	}
+}
+
+// lookupFormat returns the format for a media type, or nil.
+func lookupFormat(mediaType string) *graphFormat {
+	mediaType = strings.ToLower(mediaType)
+	if alias, ok := formatAliases[mediaType]; ok {
+		mediaType = alias
+	}
+	for i := range graphFormats {
+		if graphFormats[i].mediaType == mediaType {
+			return &graphFormats[i]
+		}
+	}
+	return nil
+}
+
+// negotiateFormat picks the most acceptable format for an Accept header
+// among those allowed, honoring q-values and wildcards. An empty header
+// accepts anything; nil means nothing allowed is acceptable.
+func negotiateFormat(accept string, allowed func(*graphFormat) bool) *graphFormat {
+	if strings.TrimSpace(accept) == "" {
+		accept = "*/*"
+	}
+	var best *graphFormat
+	bestQ := 0.0
+	for i := range graphFormats {
+		f := &graphFormats[i]
+		if !allowed(f) {
+			continue
+		}
+		if q := acceptQuality(accept, f.mediaType); q > bestQ {
+			best, bestQ = f, q
+		}
+	}
+	return best
+}
+
+// acceptQuality returns the q-value an Accept header gives a media type,
+// taking the most specific matching range.
+func acceptQuality(accept, mediaType string) float64 {
+	return acceptQualityWith(accept, mediaType, formatAliases)
+}
+
+// acceptQualityWith is acceptQuality with the ranges in the header
+// translated by aliases first.
+func acceptQualityWith(accept, mediaType string, aliases map[string]string) float64 {
+	q, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		rng, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if alias, ok := aliases[rng]; ok {
+			rng = alias
+		}
+		s := -1
+		switch {
+		case rng == mediaType:
+			s = 2
+		case strings.HasSuffix(rng, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(rng, "*")):
+			s = 1
+		case rng == "*/*":
+			s = 0
+		}
+		if s <= specificity {
+			continue
+		}
+		specificity, q = s, 1.0
+		if v, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+	}
+	return q
+}
+
+// supportedTypes lists the media types of the allowed formats, for 406 and
+// 415 responses.
+func supportedTypes(allowed func(*graphFormat) bool) string {
+	var types []string
+	for i := range graphFormats {
+		if allowed(&graphFormats[i]) {
+			types = append(types, graphFormats[i].mediaType)
+		}
+	}
+	return strings.Join(types, ", ")
+}
+
+func anyFormat(*graphFormat) bool { return true }
+
+// isLineFormat reports whether a format writes a statement per line, so
+// that a graph can be streamed in it (see exportGraph).
+func isLineFormat(f *graphFormat) bool {
+	return f.mediaType == string(quadstore.FormatNQuads) || f.mediaType == string(quadstore.FormatNTriples)
+}
+
+// snapshotQuads returns the quads of the named graphs of a snapshot, in
+// graph order, with the default graph's quads carrying no graph term.
+func snapshotQuads(snap *snapshot, graphs []string) ([]quadstore.Quad, error) {
+	var quads []quadstore.Quad
+	for _, graph := range graphs {
+		blob, err := readBlob(snap.graphs[graph])
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range blob {
+			q, err := parseQuad(line)
+			if err != nil {
+				continue
+			}
+			if graph != defaultGraph {
+				q.Graph = graph
+			}
+			quads = append(quads, q)
+		}
+	}
+	return quads, nil
+}
+
+// graphParam reads the graph query parameter as a stored graph name.
+func graphParam(r *http.Request) (string, error) {
+	graph := r.URL.Query().Get("graph")
+	if graph == "" {
+		return "", fmt.Errorf("missing graph parameter")
+	}
+	return normalizeGraphName(graph), nil
+}
+
+// serveQuads writes quads in the format the request's Accept header
+// prefers among those allowed.
+func serveQuads(w http.ResponseWriter, r *http.Request, quads []quadstore.Quad, allowed func(*graphFormat) bool) {
+	format := negotiateFormat(r.Header.Get("Accept"), allowed)
+	if format == nil {
+		http.Error(w, "not acceptable; available: "+supportedTypes(allowed), http.StatusNotAcceptable)
+		return
+	}
+	w.Header().Set("Content-Type", format.mediaType)
+	w.Header().Add("Vary", "Accept")
+	if err := format.write(w, quads); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// handleGetGraph serves one graph of a revision (HEAD by default):
+// GET /graph?graph=<iri>[&rev=<rev>].
+func handleGetGraph(w http.ResponseWriter, r *http.Request) {
+	graph, err := graphParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	snap, err := openSnapshot(revParam(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if _, ok := snap.graphs[graph]; !ok {
+		http.Error(w, fmt.Sprintf("no graph %s at %s", graph, shortHash(snap.hash)), http.StatusNotFound)
+		return
+	}
+	setMementoHeaders(w, r, graph, snap)
+	if notModified(w, r, snap.hash) {
+		return
+	}
+	if format := negotiateFormat(r.Header.Get("Accept"), anyFormat); format != nil && isLineFormat(format) {
+		w.Header().Set("Content-Type", format.mediaType)
+		w.Header().Add("Vary", "Accept")
+		// The status line is gone once the first line is written, so a
+		// failure part way, usually a client that went away, can only cut
+		// the response short.
+		if err := exportGraph(r.Context(), snap.hash, graph, w, quadstore.Format(format.mediaType)); err != nil && r.Context().Err() == nil {
+			log.Printf("GET /graph %s: %v", graph, err)
+		}
+		return
+	}
+	quads, err := snapshotQuads(snap, []string{graph})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	serveQuads(w, r, quads, anyFormat)
+}
+
+// handleGetDataset serves every graph of a revision: GET /dataset[?rev=].
+// Formats without graph names are only offered for a dataset that has
+// nothing but a default graph.
+func handleGetDataset(w http.ResponseWriter, r *http.Request) {
+	snap, err := openSnapshot(revParam(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	graphs := snap.graphNames()
+	allowed := anyFormat
+	if len(graphs) > 1 || len(graphs) == 1 && graphs[0] != defaultGraph {
+		allowed = func(f *graphFormat) bool { return f.named }
+	}
+	if notModified(w, r, snap.hash) {
+		return
+	}
+	quads, err := snapshotQuads(snap, graphs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	serveQuads(w, r, quads, allowed)
+}
+
+func revParam(r *http.Request) string {
+	if rev := r.URL.Query().Get("rev"); rev != "" {
+		return rev
+	}
+	return "HEAD"
+}
+
+// handlePutGraph replaces one graph on a branch (the current branch by
+// default) with the request body, parsed according to its Content-Type,
+// and commits the result: PUT /graph?graph=<iri>[&branch=<name>].
+// Statements may name no graph or the target graph; relative IRIs in
+// Turtle and TriG resolve against the graph's IRI. With If-Match, the
+// write only succeeds while the branch is still at that commit.
+func handlePutGraph(w http.ResponseWriter, r *http.Request) {
+	graph, err := graphParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	format := lookupFormat(mediaType)
+	if err != nil || format == nil {
+		http.Error(w, "unsupported content type; use one of: "+supportedTypes(anyFormat), http.StatusUnsupportedMediaType)
+		return
+	}
+	branch := r.URL.Query().Get("branch")
+	if branch == "" {
+		branch = currentBranch()
+	}
+	if branch == "" {
+		http.Error(w, "HEAD is detached; give a branch parameter", http.StatusBadRequest)
+		return
+	}
+
+	head, err := getReference("head:" + branch)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("branch %s: %v", branch, err), http.StatusNotFound)
+		return
+	}
+	if !matchesETag(r.Header.Get("If-Match"), head) {
+		preconditionFailed(w, head)
+		return
+	}
+
+	base := ""
+	if strings.HasPrefix(graph, "<") {
+		base = strings.Trim(graph, "<>")
+	}
+	quads, err := format.parse(r.Body, base)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid %s: %v", format.mediaType, err), http.StatusBadRequest)
+		return
+	}
+	set := make(quadSet, len(quads))
+	for _, q := range quads {
+		if q.Graph != "" && q.Graph != graph {
+			http.Error(w, fmt.Sprintf("statement in graph %s, not %s", q.Graph, graph), http.StatusBadRequest)
+			return
+		}
+		q.Graph = ""
+		set[formatQuad(q)] = true
+	}
+
+	message := fmt.Sprintf("Replace %s over HTTP", graph)
+	hash, err := commitReplacingGraphs(branch, head, map[string]quadSet{graph: set}, message, nil, requestIdentity(r))
+	if errors.Is(err, quadstore.ErrStaleParent) {
+		current, _ := getReference("head:" + branch)
+		preconditionFailed(w, current)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), commitErrorStatus(err))
+		return
+	}
+	if hash == "" {
+		w.Header().Set("ETag", etag(head))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("ETag", etag(hash))
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintln(w, hash)
+}
+
+// handleGetBranch reports the commit a branch points at, which is also its
+// ETag: GET /branch?name=<name>.
+func handleGetBranch(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	hash, err := getReference("head:" + name)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("branch %s: %v", name, err), http.StatusNotFound)
+		return
+	}
+	if notModified(w, r, hash) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintln(w, hash)
+}
+
+// handlePutBranch moves a branch to the commit in the request body, as a
+// push of that one branch would: PUT /branch?name=<name>. The commit must
+// already be stored and be a fast-forward. If-Match makes the update
+// conditional on where the branch is now, and If-None-Match: * on it not
+// existing yet.
+func handlePutBranch(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if err := validBranchName(name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 256))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	hash := strings.TrimSpace(string(body))
+	if _, err := readCommit(hash); err != nil {
+		http.Error(w, fmt.Sprintf("no commit %q", hash), http.StatusBadRequest)
+		return
+	}
+	ref := "head:" + name
+	current, err := getReference(ref)
+	if err != nil && !errors.Is(err, quadstore.ErrRefNotFound) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !matchesETag(r.Header.Get("If-Match"), current) || r.Header.Get("If-None-Match") == "*" && current != "" {
+		preconditionFailed(w, current)
+		return
+	}
+	if current != "" {
+		reachable, err := ancestors(hash)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !reachable[current] {
+			http.Error(w, fmt.Sprintf("%s is not a fast-forward of %s", shortHash(hash), name), http.StatusConflict)
+			return
+		}
+	}
+	if err := authorizeWrite(quadstore.ActionPush, requestIdentity(r), ref, current, hash); err != nil {
+		http.Error(w, err.Error(), commitErrorStatus(err))
+		return
+	}
+	if err := swapReference(ref, current, hash); errors.Is(err, quadstore.ErrStaleParent) {
+		now, _ := getReference(ref)
+		preconditionFailed(w, now)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", etag(hash))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// etag renders a commit hash as a strong entity tag. A graph, dataset or
+// branch resource changes exactly when the commit it is read from does.
+func etag(hash string) string {
+	return `"` + hash + `"`
+}
+
+// matchesETag evaluates an If-Match header against the commit a resource
+// is at ("" if it does not exist). An absent header always matches.
+func matchesETag(header, hash string) bool {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" && hash != "" || hash != "" && tag == etag(hash) {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified sets the ETag of a resource read from commit hash and, if
+// the request's If-None-Match names it, answers 304 Not Modified.
+func notModified(w http.ResponseWriter, r *http.Request, hash string) bool {
+	w.Header().Set("ETag", etag(hash))
+	for _, tag := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag(hash) {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// preconditionFailed answers 412 for a write whose If-Match no longer
+// holds, with the resource's current ETag so the client can re-read.
+func preconditionFailed(w http.ResponseWriter, current string) {
+	if current != "" {
+		w.Header().Set("ETag", etag(current))
+	}
+	http.Error(w, "the branch has moved; re-read and retry", http.StatusPreconditionFailed)
+}
+
+// commitErrorStatus maps a failed commit to a response status.
+func commitErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, quadstore.ErrPermissionDenied):
+		return http.StatusForbidden
+	case errors.Is(err, quadstore.ErrRefNotFound):
+		return http.StatusNotFound
+	case errors.As(err, new(secretsError)):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+// registerGraphHandlers adds the graph read and write endpoints to mux,
+// routed for consistency when serving as a replica.
+func registerGraphHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("GET /graph", withConsistency(handleGetGraph))
+	mux.HandleFunc("PUT /graph", primaryOnly(handlePutGraph))
+	mux.HandleFunc("GET /dataset", withConsistency(handleGetDataset))
+	mux.HandleFunc("GET /branch", withConsistency(handleGetBranch))
+	mux.HandleFunc("PUT /branch", primaryOnly(handlePutBranch))
+	mux.HandleFunc("POST /changes", primaryOnly(handlePostChanges))
+	mux.HandleFunc("POST /commit", primaryOnly(handlePostCommit))
+	mux.HandleFunc("GET /reviews", withConsistency(handleGetReviews))
+	mux.HandleFunc("POST /reviews", primaryOnly(handlePostReview))
+	mux.HandleFunc("GET /proposals", withConsistency(handleProposals))
+	mux.HandleFunc("POST /proposals", primaryOnly(handleProposals))
+	mux.HandleFunc("GET /proposal", withConsistency(handleProposal))
+	mux.HandleFunc("POST /proposal", primaryOnly(handleProposal))
+	mux.HandleFunc("GET /timegate", withConsistency(handleTimeGate))
+	mux.HandleFunc("GET /timemap", withConsistency(handleTimeMap))
+	mux.HandleFunc("GET /sparql", withConsistency(handleSPARQL))
+	mux.HandleFunc("POST /sparql", withConsistency(handleSPARQL))
+}
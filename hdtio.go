@@ -0,0 +1,84 @@
+// hdtio.go
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mannyrivera2010/go-quadgit/internal/hdt"
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+)
+
+// sdName records, in an HDT header, the graph the file was exported from.
+const sdName = "<http://www.w3.org/ns/sparql-service-description#name>"
+
+// hdtDefaultGraphBase names HDT files exported from the default graph.
+const hdtDefaultGraphBase = "<urn:quad-db:default-graph>"
+
+// exportHDT replaces the .hdt files in dir with one HDT file per graph.
+func exportHDT(state map[string]quadSet, dir string) error {
+	stale, err := filepath.Glob(filepath.Join(dir, "*.hdt"))
+	if err != nil {
+		return err
+	}
+	for _, path := range stale {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	for graph, set := range state {
+		if len(set) == 0 {
+			continue
+		}
+		doc := &hdt.Document{BaseURI: hdtDefaultGraphBase}
+		if graph != defaultGraph {
+			doc.BaseURI = graph
+			doc.Header = []string{fmt.Sprintf("%s %s %s .", graph, sdName, graph)}
+		}
+		for line := range set {
+			q, err := parseQuad(line)
+			if err != nil {
+				return err
+			}
+			doc.Triples = append(doc.Triples, hdt.Triple{Subject: q.Subject, Predicate: q.Predicate, Object: q.Object})
+		}
+		var buf bytes.Buffer
+		if err := hdt.Encode(&buf, doc); err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(graphFileName(graph), ".nq") + ".hdt"
+		if err := os.WriteFile(filepath.Join(dir, name), buf.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readHDT decodes an HDT file into N-Quads lines. Triples are placed in
+// graph if given, else in the graph recorded by exportHDT, else in the
+// default graph.
+func readHDT(path, graph string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := hdt.Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if graph == "" {
+		for _, line := range doc.Header {
+			if fields := strings.Fields(line); len(fields) >= 3 && fields[0] == doc.BaseURI && fields[1] == sdName {
+				graph = fields[2]
+			}
+		}
+	}
+	lines := make([]string, 0, len(doc.Triples))
+	for _, t := range doc.Triples {
+		lines = append(lines, formatQuad(quadstore.Quad{Subject: t.Subject, Predicate: t.Predicate, Object: t.Object, Graph: graph}))
+	}
+	return lines, nil
+}
@@ -0,0 +1,344 @@
+// health.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+	"github.com/spf13/cobra"
+)
+
+// A lintRule is one check of 'lint'. Adding a rule to lintRules is all it
+// takes to run it; lint.<name> in the config sets its severity.
+type lintRule struct {
+	name     string
+	summary  string
+	severity string // error, warning or off, unless configured
+	check    func(d *lintData) []lintFinding
+}
+
+// A lintFinding is one problem a rule found.
+type lintFinding struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Graph    string `json:"graph,omitempty"`
+	Term     string `json:"term,omitempty"`
+	Message  string `json:"message"`
+}
+
+// lintData is the parsed dataset the rules check, graph by graph.
+type lintData struct {
+	graphs   []string // In name order
+	quads    map[string][]quadstore.Quad
+	subjects map[string]bool // Subjects in any graph
+}
+
+func newLintData(state map[string]quadSet) *lintData {
+	d := &lintData{quads: make(map[string][]quadstore.Quad, len(state)), subjects: make(map[string]bool)}
+	for graph, set := range state {
+		if len(set) == 0 {
+			continue
+		}
+		d.graphs = append(d.graphs, graph)
+		lines := make([]string, 0, len(set))
+		for line := range set {
+			lines = append(lines, line)
+		}
+		sort.Strings(lines)
+		for _, line := range lines {
+			q, err := parseQuad(line)
+			if err != nil {
+				continue
+			}
+			d.quads[graph] = append(d.quads[graph], q)
+			d.subjects[q.Subject] = true
+		}
+	}
+	sort.Strings(d.graphs)
+	return d
+}
+
+var lintRules = []lintRule{
+	{"dangling-iri", "object IRIs that no statement describes", "warning", lintDanglingIRIs},
+	{"untyped-subject", "subjects without an rdf:type", "warning", lintUntypedSubjects},
+	{"datatype-mismatch", "predicates whose literals mix datatypes", "error", lintDatatypeMismatches},
+	{"orphan-graph", "graphs sharing no IRI with any other graph", "warning", lintOrphanGraphs},
+}
+
+// lintDanglingIRIs finds IRIs used as objects but never as subjects, other
+// than classes and terms of the standard vocabularies; they are often typos
+// or links to data that was never loaded.
+func lintDanglingIRIs(d *lintData) []lintFinding {
+	var findings []lintFinding
+	reported := make(map[string]bool)
+	for _, graph := range d.graphs {
+		uses := make(map[string]int)
+		var order []string
+		for _, q := range d.quads[graph] {
+			if !strings.HasPrefix(q.Object, "<") || q.Predicate == rdfType || d.subjects[q.Object] || builtinTerm(q.Object) || reported[q.Object] {
+				continue
+			}
+			if uses[q.Object] == 0 {
+				order = append(order, q.Object)
+			}
+			uses[q.Object]++
+		}
+		for _, iri := range order {
+			reported[iri] = true
+			findings = append(findings, lintFinding{Graph: graph, Term: iri,
+				Message: fmt.Sprintf("%s is the object of %d statement(s) but the subject of none", iri, uses[iri])})
+		}
+	}
+	return findings
+}
+
+// lintUntypedSubjects finds subjects that no graph gives an rdf:type.
+func lintUntypedSubjects(d *lintData) []lintFinding {
+	typed := make(map[string]bool)
+	for _, graph := range d.graphs {
+		for _, q := range d.quads[graph] {
+			if q.Predicate == rdfType {
+				typed[q.Subject] = true
+			}
+		}
+	}
+	var findings []lintFinding
+	reported := make(map[string]bool)
+	for _, graph := range d.graphs {
+		for _, q := range d.quads[graph] {
+			if typed[q.Subject] || reported[q.Subject] {
+				continue
+			}
+			reported[q.Subject] = true
+			findings = append(findings, lintFinding{Graph: graph, Term: q.Subject,
+				Message: fmt.Sprintf("%s has no rdf:type", q.Subject)})
+		}
+	}
+	return findings
+}
+
+// lintDatatypeMismatches finds predicates whose literal objects have more
+// than one datatype, reporting each datatype other than the commonest.
+// Plain and language-tagged literals count as xsd:string, since mixing
+// them is usual.
+func lintDatatypeMismatches(d *lintData) []lintFinding {
+	counts := make(map[string]map[string]int) // predicate -> datatype -> uses
+	graphOf := make(map[string]string)        // predicate+datatype -> first graph
+	for _, graph := range d.graphs {
+		for _, q := range d.quads[graph] {
+			if !strings.HasPrefix(q.Object, `"`) {
+				continue
+			}
+			_, _, datatype := literalParts(q.Object)
+			if datatype == "" {
+				datatype = xsd + "string"
+			}
+			if counts[q.Predicate] == nil {
+				counts[q.Predicate] = make(map[string]int)
+			}
+			counts[q.Predicate][datatype]++
+			if _, ok := graphOf[q.Predicate+" "+datatype]; !ok {
+				graphOf[q.Predicate+" "+datatype] = graph
+			}
+		}
+	}
+	predicates := make([]string, 0, len(counts))
+	for predicate, types := range counts {
+		if len(types) > 1 {
+			predicates = append(predicates, predicate)
+		}
+	}
+	sort.Strings(predicates)
+	var findings []lintFinding
+	for _, predicate := range predicates {
+		types := make([]string, 0, len(counts[predicate]))
+		for datatype := range counts[predicate] {
+			types = append(types, datatype)
+		}
+		sort.Slice(types, func(i, j int) bool {
+			a, b := counts[predicate][types[i]], counts[predicate][types[j]]
+			return a > b || a == b && types[i] < types[j]
+		})
+		common := types[0]
+		for _, datatype := range types[1:] {
+			findings = append(findings, lintFinding{Graph: graphOf[predicate+" "+datatype], Term: predicate,
+				Message: fmt.Sprintf("%s has %d <%s> value(s) but %d <%s> ones", predicate,
+					counts[predicate][datatype], datatype, counts[predicate][common], common)})
+		}
+	}
+	return findings
+}
+
+// lintOrphanGraphs finds graphs that share no subject or object IRI with
+// another graph and are not named by one, when there is more than one.
+func lintOrphanGraphs(d *lintData) []lintFinding {
+	if len(d.graphs) < 2 {
+		return nil
+	}
+	graphsOf := make(map[string]map[string]bool) // IRI -> graphs using it
+	use := func(iri, graph string) {
+		if !strings.HasPrefix(iri, "<") || builtinTerm(iri) {
+			return
+		}
+		if graphsOf[iri] == nil {
+			graphsOf[iri] = make(map[string]bool)
+		}
+		graphsOf[iri][graph] = true
+	}
+	for _, graph := range d.graphs {
+		for _, q := range d.quads[graph] {
+			use(q.Subject, graph)
+			use(q.Object, graph)
+		}
+	}
+	var findings []lintFinding
+	for _, graph := range d.graphs {
+		connected := false
+		for g := range graphsOf[graph] {
+			connected = connected || g != graph
+		}
+		for _, q := range d.quads[graph] {
+			for _, iri := range []string{q.Subject, q.Object} {
+				connected = connected || len(graphsOf[iri]) > 1
+			}
+			if connected {
+				break
+			}
+		}
+		if !connected {
+			findings = append(findings, lintFinding{Graph: graph, Term: graph,
+				Message: fmt.Sprintf("%s shares no IRI with any other graph", graph)})
+		}
+	}
+	return findings
+}
+
+// lintSeverities returns the severity of each rule, as configured.
+func lintSeverities() (map[string]string, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	severities := make(map[string]string, len(lintRules))
+	for _, rule := range lintRules {
+		severity := rule.severity
+		if configured, ok := cfg.Lookup("lint." + rule.name); ok {
+			severity = configured
+		}
+		if severity != "error" && severity != "warning" && severity != "off" {
+			return nil, fmt.Errorf("invalid lint.%s %q (expected error, warning or off)", rule.name, severity)
+		}
+		severities[rule.name] = severity
+	}
+	return severities, nil
+}
+
+// runLint runs every rule not configured off over state.
+func runLint(state map[string]quadSet) ([]lintFinding, error) {
+	severities, err := lintSeverities()
+	if err != nil {
+		return nil, err
+	}
+	d := newLintData(state)
+	var findings []lintFinding
+	for _, rule := range lintRules {
+		severity := severities[rule.name]
+		if severity == "off" {
+			continue
+		}
+		for _, f := range rule.check(d) {
+			f.Rule, f.Severity = rule.name, severity
+			findings = append(findings, f)
+		}
+	}
+	return findings, nil
+}
+
+var lintCmd = &cobra.Command{
+	Use:   "lint [<commit>]",
+	Short: "Check the repository's data for common problems",
+	Long: `Check a commit's data (without one, the state the next commit would
+record: HEAD plus the index) against the health rules:
+
+  dangling-iri       object IRIs that no statement describes (warning)
+  untyped-subject    subjects without an rdf:type (warning)
+  datatype-mismatch  predicates whose literals mix datatypes (error)
+  orphan-graph       graphs sharing no IRI with any other graph (warning)
+
+lint.<rule> sets a rule's severity to error, warning or off, and
+--list-rules shows the rules with their severities. Findings are
+listed as text, or with --format json as a JSON array for CI, and lint
+exits with status 1 if any has severity error.
+
+'lint duplicates' looks for duplicate quads instead.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if list, _ := cmd.Flags().GetBool("list-rules"); list {
+			severities, err := lintSeverities()
+			if err != nil {
+				log.Fatal(err)
+			}
+			for _, rule := range lintRules {
+				fmt.Printf("%-17s %-7s %s\n", rule.name, severities[rule.name], rule.summary)
+			}
+			return
+		}
+		format, _ := cmd.Flags().GetString("format")
+		if format != "text" && format != "json" {
+			log.Fatalf("Unknown format %q (expected text or json).", format)
+		}
+		var state map[string]quadSet
+		var hash string
+		var err error
+		if len(args) == 1 {
+			hash, err = resolveCommitish(args[0])
+			if err != nil {
+				log.Fatalf("Could not resolve %s: %v", args[0], err)
+			}
+			state, err = loadState(hash)
+		} else {
+			_, state, err = indexState()
+		}
+		if err != nil {
+			log.Fatalf("Failed to read quads: %v", err)
+		}
+		findings, err := runLint(state)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		errors := 0
+		for _, f := range findings {
+			if f.Severity == "error" {
+				errors++
+			}
+		}
+		if format == "json" {
+			if findings == nil {
+				findings = []lintFinding{}
+			}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.SetEscapeHTML(false) // IRIs stay readable
+			if err := enc.Encode(findings); err != nil {
+				log.Fatal(err)
+			}
+		} else {
+			for _, f := range findings {
+				fmt.Printf("%-7s %-17s %s\n", f.Severity, f.Rule, f.Message)
+				if f.Graph != "" {
+					fmt.Printf("\tin %s\n", f.Graph)
+				}
+			}
+			fmt.Printf("%d error(s), %d warning(s)\n", errors, len(findings)-errors)
+		}
+		if errors > 0 {
+			os.Exit(1)
+		}
+	},
+}
@@ -0,0 +1,40 @@
+// helpers_test.go
+package main
+
+import "testing"
+
+// newTestRepo initializes a repository in a temporary directory, makes it
+// the working directory and opens it as repo for the rest of the test.
+// Tests using it share repo and the working directory, so they cannot run
+// in parallel; see openTestRepository for ones that can.
+func newTestRepo(t *testing.T) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	if err := initRepository(); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	t.Cleanup(closeDB)
+}
+
+// writeTestCommit writes a commit of graphs, name to N-Quads lines, on top of
+// parents, without signing it or moving any reference.
+func writeTestCommit(t *testing.T, graphs map[string][]string, parents ...string) string {
+	t.Helper()
+	blobs := make(map[string]string, len(graphs))
+	for graph, lines := range graphs {
+		hash, err := writeObject(Blob(lines))
+		if err != nil {
+			t.Fatalf("write blob: %v", err)
+		}
+		blobs[graph] = hash
+	}
+	tree, err := writeTree(blobs)
+	if err != nil {
+		t.Fatalf("write tree: %v", err)
+	}
+	hash, err := writeObject(Commit{Tree: tree, Parents: parents, Author: "Test", Message: "test", Timestamp: clock.Now()})
+	if err != nil {
+		t.Fatalf("write commit: %v", err)
+	}
+	return hash
+}
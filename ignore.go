@@ -0,0 +1,165 @@
+// ignore.go
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+)
+
+// ignorePath holds rules for quads that add and import leave out and that
+// diff and status do not report, such as volatile timestamps written by an
+// upstream exporter. Each line is a rule, "#" starts a comment:
+//
+//	graph <http://example.org/scratch>     a graph, or with a trailing *
+//	graph <http://example.org/tmp/*>       every graph under a prefix
+//	subject <http://example.org/session/*> subjects, exact or by prefix
+//	predicate <http://purl.org/dc/terms/modified>
+//
+// Angle brackets are optional. A quad matching any rule is ignored.
+const ignorePath = ".quadignore"
+
+type ignoreRule struct {
+	field   string // graph, subject or predicate
+	pattern string // Without angle brackets
+}
+
+type ignoreRules []ignoreRule
+
+// loadIgnoreRules reads .quadignore; a missing file means no rules.
+func loadIgnoreRules() (ignoreRules, error) {
+	f, err := os.Open(ignorePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules ignoreRules
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		field, pattern, _ := strings.Cut(line, " ")
+		pattern = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(pattern), "<"), ">")
+		switch {
+		case field != "graph" && field != "subject" && field != "predicate":
+			return nil, fmt.Errorf("%s:%d: unknown rule %q (expected graph, subject or predicate)", ignorePath, n, field)
+		case pattern == "":
+			return nil, fmt.Errorf("%s:%d: %s rule without a pattern", ignorePath, n, field)
+		}
+		rules = append(rules, ignoreRule{field, pattern})
+	}
+	return rules, scanner.Err()
+}
+
+// matchTerm reports whether an IRI term matches pattern: the same IRI or,
+// if pattern ends in "*", one starting with the rest.
+func matchTerm(term, pattern string) bool {
+	term = strings.TrimSuffix(strings.TrimPrefix(term, "<"), ">")
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(term, prefix)
+	}
+	return term == pattern
+}
+
+// ignores reports whether a quad stored under graph matches a rule.
+func (rules ignoreRules) ignores(graph string, q quadstore.Quad) bool {
+	for _, r := range rules {
+		switch r.field {
+		case "graph":
+			if graphInScope(graph, r.pattern) {
+				return true
+			}
+		case "subject":
+			if matchTerm(q.Subject, r.pattern) {
+				return true
+			}
+		case "predicate":
+			if matchTerm(q.Predicate, r.pattern) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ignoresLine is ignores for an N-Quads line; lines that do not parse are
+// never ignored, so that they are reported where they are used.
+func (rules ignoreRules) ignoresLine(graph, line string) bool {
+	if len(rules) == 0 {
+		return false
+	}
+	q, err := parseQuad(line)
+	if err != nil {
+		return false
+	}
+	if graph == "" {
+		graph = graphKey(q)
+	}
+	return rules.ignores(graph, q)
+}
+
+// filterLines returns the N-Quads lines that no rule ignores, and how many
+// were left out.
+func (rules ignoreRules) filterLines(lines []string) (kept []string, ignored int) {
+	for _, line := range lines {
+		if rules.ignoresLine("", line) {
+			ignored++
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return kept, ignored
+}
+
+// filterState removes ignored quads from state, in place, and returns how
+// many it removed. Graphs left empty are removed too, so that importing
+// them does not replace what the repository holds.
+func (rules ignoreRules) filterState(state map[string]quadSet) int {
+	ignored := 0
+	for graph, set := range state {
+		for line := range set {
+			if rules.ignoresLine(graph, line) {
+				delete(set, line)
+				ignored++
+			}
+		}
+		if len(set) == 0 {
+			delete(state, graph)
+		}
+	}
+	return ignored
+}
+
+// filterDiffs drops ignored quads from diffs, and graphs left without
+// changes unless they were renamed.
+func (rules ignoreRules) filterDiffs(diffs []graphDiff) []graphDiff {
+	if len(rules) == 0 {
+		return diffs
+	}
+	var filtered []graphDiff
+	for _, d := range diffs {
+		kept := graphDiff{Graph: d.Graph, RenamedFrom: d.RenamedFrom}
+		for _, line := range d.Added {
+			if !rules.ignoresLine(d.Graph, line) {
+				kept.Added = append(kept.Added, line)
+			}
+		}
+		for _, line := range d.Deleted {
+			if !rules.ignoresLine(d.Graph, line) {
+				kept.Deleted = append(kept.Deleted, line)
+			}
+		}
+		if len(kept.Added) > 0 || len(kept.Deleted) > 0 || kept.RenamedFrom != "" {
+			filtered = append(filtered, kept)
+		}
+	}
+	return filtered
+}
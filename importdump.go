@@ -0,0 +1,685 @@
+// importdump.go
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"container/heap"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quaddiff"
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+	"github.com/spf13/cobra"
+)
+
+// parallelDecompressors lists external tools that decompress using several
+// cores, by format, in order of preference.
+var parallelDecompressors = map[string][]string{
+	"bzip2": {"lbzip2", "pbzip2"},
+	"gzip":  {"pigz"},
+}
+
+// dumpBatchSize is the number of lines handed to a parser goroutine at once.
+const dumpBatchSize = 10000
+
+// A dumpReader streams the decompressed content of a dump.
+type dumpReader struct {
+	io.Reader
+	closers []func() error
+}
+
+func (r *dumpReader) Close() error {
+	var first error
+	for i := len(r.closers) - 1; i >= 0; i-- {
+		if err := r.closers[i](); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// openDump opens a dump file, or stdin for "-", decompressing bzip2 and gzip
+// content as it is read. Compression is detected from the content, not the
+// file name. Files are piped through a parallel decompressor when one is
+// installed; otherwise the standard library decoders are used.
+func openDump(path string) (*dumpReader, error) {
+	r := &dumpReader{}
+	var in io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		in = f
+		r.closers = append(r.closers, f.Close)
+	}
+	buffered := bufio.NewReaderSize(in, 1<<20)
+	magic, _ := buffered.Peek(3)
+	format := ""
+	switch {
+	case len(magic) >= 2 && magic[0] == 0x1f && magic[1] == 0x8b:
+		format = "gzip"
+	case string(magic) == "BZh":
+		format = "bzip2"
+	}
+
+	if format != "" && path != "-" {
+		for _, tool := range parallelDecompressors[format] {
+			if _, err := exec.LookPath(tool); err != nil {
+				continue
+			}
+			cmd := exec.Command(tool, "-d", "-c", path)
+			cmd.Stderr = os.Stderr
+			out, err := cmd.StdoutPipe()
+			if err != nil {
+				break
+			}
+			if err := cmd.Start(); err != nil {
+				break
+			}
+			// Closing the pipe first stops a decompressor that is still
+			// writing when the import is abandoned
+			r.Reader = out
+			r.closers = append(r.closers, cmd.Wait, out.Close)
+			return r, nil
+		}
+	}
+
+	switch format {
+	case "gzip":
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.Reader = gz
+		r.closers = append(r.closers, gz.Close)
+	case "bzip2":
+		r.Reader = bzip2.NewReader(buffered)
+	default:
+		r.Reader = buffered
+	}
+	return r, nil
+}
+
+// A dumpBatch is a run of consecutive lines from a dump.
+type dumpBatch struct {
+	first int // line number of lines[0]
+	lines []string
+}
+
+// dumpRunSize is the number of statements an import holds in memory before
+// it sorts them and spills them to a run file, which bounds the memory a
+// dump takes to read however large it is.
+var dumpRunSize = 1 << 20
+
+// dumpRuns collects the statements of a dump by graph, in the triple form
+// the repository stores, as sorted runs in temporary files.
+type dumpRuns struct {
+	dir     string
+	limit   int
+	pending map[string][]string // Graph -> statements not yet spilled
+	held    int
+	files   map[string][]string // Graph -> its run files
+}
+
+func newDumpRuns(limit int) (*dumpRuns, error) {
+	dir, err := os.MkdirTemp("", "quad-db-import-")
+	if err != nil {
+		return nil, err
+	}
+	return &dumpRuns{dir: dir, limit: limit, pending: make(map[string][]string), files: make(map[string][]string)}, nil
+}
+
+// add adds a statement to a graph, spilling once limit are held.
+func (d *dumpRuns) add(graph, line string) error {
+	d.pending[graph] = append(d.pending[graph], line)
+	if d.held++; d.held >= d.limit {
+		return d.spill()
+	}
+	return nil
+}
+
+// spill writes the statements held for each graph, sorted and without
+// duplicates, to a new run file of the graph.
+func (d *dumpRuns) spill() error {
+	for graph, lines := range d.pending {
+		sort.Strings(lines)
+		f, err := os.CreateTemp(d.dir, "run-")
+		if err != nil {
+			return err
+		}
+		w := bufio.NewWriterSize(f, 1<<20)
+		for i, line := range lines {
+			if i == 0 || line != lines[i-1] {
+				w.WriteString(line)
+				w.WriteByte('\n')
+			}
+		}
+		err = w.Flush()
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		d.files[graph] = append(d.files[graph], f.Name())
+	}
+	d.pending, d.held = make(map[string][]string), 0
+	return nil
+}
+
+// graphs returns the graphs that have statements, in name order.
+func (d *dumpRuns) graphs() []string {
+	seen := make(map[string]bool)
+	for graph := range d.pending {
+		seen[graph] = true
+	}
+	for graph := range d.files {
+		seen[graph] = true
+	}
+	return sortedKeys(seen)
+}
+
+// stream returns the statements of a graph in order and without
+// duplicates, merging its runs with those still held. The caller must call
+// the returned function to close the run files.
+func (d *dumpRuns) stream(graph string) (quaddiff.Stream, func(), error) {
+	held := d.pending[graph]
+	sort.Strings(held)
+	streams := []quaddiff.Stream{quaddiff.Slice(held)}
+	var files []*os.File
+	closeFiles := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+	for _, name := range d.files[graph] {
+		f, err := os.Open(name)
+		if err != nil {
+			closeFiles()
+			return nil, nil, err
+		}
+		files = append(files, f)
+		streams = append(streams, quaddiff.Lines(bufio.NewReaderSize(f, 1<<16)))
+	}
+	merged, err := mergeStreams(streams)
+	if err != nil {
+		closeFiles()
+		return nil, nil, err
+	}
+	return merged, closeFiles, nil
+}
+
+// state reads every graph back into memory, for callers that need the
+// statements whole.
+func (d *dumpRuns) state() (map[string]quadSet, error) {
+	state := make(map[string]quadSet)
+	for _, graph := range d.graphs() {
+		merged, closeRuns, err := d.stream(graph)
+		if err != nil {
+			return nil, err
+		}
+		set := make(quadSet)
+		for {
+			line, err := merged.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				closeRuns()
+				return nil, err
+			}
+			set[line] = true
+		}
+		closeRuns()
+		state[graph] = set
+	}
+	return state, nil
+}
+
+// Close removes the run files.
+func (d *dumpRuns) Close() error {
+	return os.RemoveAll(d.dir)
+}
+
+// A runHeap orders the current statements of sorted streams.
+type runHeap []runHead
+
+type runHead struct {
+	line   string
+	stream quaddiff.Stream
+}
+
+func (h runHeap) Len() int            { return len(h) }
+func (h runHeap) Less(i, j int) bool  { return h[i].line < h[j].line }
+func (h runHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(runHead)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	head := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return head
+}
+
+// mergedStream yields the statements of several sorted streams in order,
+// each once.
+type mergedStream struct {
+	heap runHeap
+	last string
+	any  bool
+}
+
+func mergeStreams(streams []quaddiff.Stream) (*mergedStream, error) {
+	m := &mergedStream{}
+	for _, s := range streams {
+		if err := m.push(s); err != nil {
+			return nil, err
+		}
+	}
+	heap.Init(&m.heap)
+	return m, nil
+}
+
+// push adds the next statement of s to the heap, if it has one.
+func (m *mergedStream) push(s quaddiff.Stream) error {
+	line, err := s.Next()
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	m.heap = append(m.heap, runHead{line, s})
+	return nil
+}
+
+func (m *mergedStream) Next() (string, error) {
+	for m.heap.Len() > 0 {
+		head := heap.Pop(&m.heap).(runHead)
+		line, err := head.stream.Next()
+		if err == nil {
+			heap.Push(&m.heap, runHead{line, head.stream})
+		} else if err != io.EOF {
+			return "", err
+		}
+		if m.any && head.line == m.last {
+			continue
+		}
+		m.last, m.any = head.line, true
+		return head.line, nil
+	}
+	return "", io.EOF
+}
+
+// readDump parses every statement in r using all available cores, runs it
+// through transform and, unless rules ignore it, adds it to runs in the
+// triple form the repository stores. Triples without a graph term go to
+// graph, or the default graph if it is empty. transform runs on batches of
+// dumpBatchSize lines, so a normalize.command sees each batch separately.
+// progress, if set, is called about once a second with the number of
+// statements read so far. readDump returns the number of statements added
+// and the number ignored. Reading stops with ctx's error when it is
+// canceled.
+func readDump(ctx context.Context, r io.Reader, name, graph string, transform *normalizePipeline, rules ignoreRules, runs *dumpRuns, progress func(int)) (int, int, error) {
+	type parsedBatch struct {
+		graphs  []string
+		lines   []string
+		ignored int
+	}
+	batches := make(chan dumpBatch, runtime.NumCPU())
+	results := make(chan parsedBatch, runtime.NumCPU())
+	errs := make(chan error, runtime.NumCPU()+1)
+	done := make(chan struct{})
+
+	// 1. Split the stream into batches of lines
+	go func() {
+		defer close(batches)
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		batch := dumpBatch{first: 1}
+		for n := 1; scanner.Scan(); n++ {
+			batch.lines = append(batch.lines, scanner.Text())
+			if len(batch.lines) == dumpBatchSize {
+				select {
+				case batches <- batch:
+				case <-done:
+					return
+				}
+				batch = dumpBatch{first: n + 1}
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			errs <- fmt.Errorf("%s: %v", name, err)
+			return
+		}
+		if len(batch.lines) > 0 {
+			select {
+			case batches <- batch:
+			case <-done:
+			}
+		}
+	}()
+
+	// 2. Parse, normalize and filter batches in parallel
+	var workers sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for batch := range batches {
+				quads := make([]string, 0, len(batch.lines))
+				for i, line := range batch.lines {
+					line = strings.TrimSpace(line)
+					if line == "" || strings.HasPrefix(line, "#") {
+						continue
+					}
+					q, err := parseQuad(line)
+					if err != nil {
+						errs <- fmt.Errorf("%s:%d: %v", name, batch.first+i, err)
+						return
+					}
+					if q.Graph == "" {
+						q.Graph = graph
+					}
+					quads = append(quads, formatQuad(q))
+				}
+				quads, err := transform.normalizeLines(quads)
+				if err != nil {
+					errs <- fmt.Errorf("%s: %v", name, err)
+					return
+				}
+				var parsed parsedBatch
+				for _, line := range quads {
+					q, err := parseQuad(line)
+					if err != nil {
+						errs <- fmt.Errorf("%s: normalized statement %q: %v", name, line, err)
+						return
+					}
+					key := graphKey(q)
+					q.Graph = ""
+					triple := formatQuad(q)
+					if rules.ignoresLine(key, triple) {
+						parsed.ignored++
+						continue
+					}
+					parsed.graphs = append(parsed.graphs, key)
+					parsed.lines = append(parsed.lines, triple)
+				}
+				results <- parsed
+			}
+		}()
+	}
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	// 3. Collect the statements into sorted runs, reporting progress
+	count, ignored := 0, 0
+	stop := func(err error) (int, int, error) {
+		close(done)
+		go func() {
+			for range results {
+			}
+		}()
+		return count, ignored, err
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case parsed, ok := <-results:
+			if !ok {
+				select {
+				case err := <-errs:
+					return count, ignored, err
+				default:
+				}
+				return count, ignored, nil
+			}
+			for i, line := range parsed.lines {
+				if err := runs.add(parsed.graphs[i], line); err != nil {
+					return stop(fmt.Errorf("spilling statements: %v", err))
+				}
+			}
+			count += len(parsed.lines)
+			ignored += parsed.ignored
+		case err := <-errs:
+			return stop(err)
+		case <-ctx.Done():
+			return stop(ctx.Err())
+		case <-ticker.C:
+			if progress != nil {
+				progress(count)
+			}
+		}
+	}
+}
+
+// A teeStream passes each statement of a stream to fn as it is read.
+type teeStream struct {
+	quaddiff.Stream
+	fn func(line string)
+}
+
+func (t teeStream) Next() (string, error) {
+	line, err := t.Stream.Next()
+	if err == nil {
+		t.fn(line)
+	}
+	return line, err
+}
+
+// writeImportedGraph stores the statements runs hold for graph as a blob,
+// encoding it as the runs are merged, and counts the changes from the
+// graph's old blob, if any. The blob's encoding is the one part of a graph
+// held in memory whole, as it is stored as a single object.
+func writeImportedGraph(runs *dumpRuns, graph, old string) (string, quadstore.GraphStats, error) {
+	var change quadstore.GraphStats
+	merged, closeRuns, err := runs.stream(graph)
+	if err != nil {
+		return "", change, err
+	}
+	defer closeRuns()
+	before, err := blobStream(old)
+	if err != nil {
+		return "", change, err
+	}
+
+	// The encoding is encodeBlob's: a JSON array of canonical statements
+	var data bytes.Buffer
+	data.WriteByte('[')
+	summary := sha256.New()
+	quads := 0
+	after := teeStream{merged, func(line string) {
+		if quads > 0 {
+			data.WriteByte(',')
+		}
+		encoded, _ := json.Marshal(line) // A string always encodes
+		data.Write(encoded)
+		io.WriteString(summary, line+"\n")
+		quads++
+	}}
+	counts, err := quaddiff.Count(before, after)
+	if err != nil {
+		return "", change, fmt.Errorf("graph %s: %v", graph, err)
+	}
+	data.WriteByte(']')
+
+	sum := sha1.Sum(data.Bytes())
+	hash := hex.EncodeToString(sum[:])
+	if err := writeRawObject(hash, data.Bytes()); err != nil {
+		return "", change, err
+	}
+	saveBlobSummary(hash, graphSummary{Checksum: hex.EncodeToString(summary.Sum(nil)), Quads: quads})
+	return hash, quadstore.GraphStats{Added: counts.Added, Deleted: counts.Deleted}, nil
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import <dump|->",
+	Short: "Stream a large, possibly compressed N-Triples/N-Quads dump into a commit",
+	Long: `Stream a dump of N-Triples or N-Quads, plain or compressed with gzip or
+bzip2, directly into a new commit on the current branch without staging it
+first. As with commit, every graph in the dump replaces that graph's
+content; other graphs are kept. Statements are normalized as add does,
+and those matching .quadignore are left out.
+
+Decompression uses lbzip2, pbzip2 or pigz when installed, and statements are
+parsed on all available cores. They are sorted in runs of a million, which
+are spilled to temporary files, so reading a dump takes bounded memory; each
+graph is then written as its runs are merged, holding only the stored
+encoding of one graph in memory at a time.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		path := args[0]
+		graph, _ := cmd.Flags().GetString("graph")
+		if graph == defaultGraph {
+			graph = ""
+		} else if graph != "" {
+			graph = normalizeGraphName(graph)
+		}
+		message, _ := cmd.Flags().GetString("message")
+		if message == "" {
+			message = "Import " + filepath.Base(path)
+		}
+		quiet, _ := cmd.Flags().GetBool("quiet")
+
+		parentHash, err := resolveHead()
+		if err != nil {
+			log.Fatalf("Could not resolve HEAD: %v", err)
+		}
+		dump, err := openDump(path)
+		if err != nil {
+			log.Fatalf("Failed to open %s: %v", path, err)
+		}
+		start := time.Now()
+		progress := func(n int) {
+			if !quiet {
+				rate := float64(n) / time.Since(start).Seconds()
+				fmt.Fprintf(os.Stderr, "\rRead %d statements (%.0f/s)", n, rate)
+			}
+		}
+		pipeline, err := loadNormalizePipeline()
+		if err != nil {
+			log.Fatalf("Failed to load normalization: %v", err)
+		}
+		rules, err := loadIgnoreRules()
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", ignorePath, err)
+		}
+		runs, err := newDumpRuns(dumpRunSize)
+		if err != nil {
+			log.Fatalf("Failed to import: %v", err)
+		}
+		fail := func(format string, args ...interface{}) {
+			runs.Close()
+			log.Fatalf(format, args...)
+		}
+		ctx := commandContext(cmd)
+		count, ignored, err := readDump(ctx, dump, path, graph, pipeline, rules, runs, progress)
+		if cerr := dump.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("decompressing %s: %v", path, cerr)
+		}
+		if !quiet {
+			progress(count)
+			fmt.Fprintln(os.Stderr)
+		}
+		if ctx.Err() != nil {
+			runs.Close()
+		}
+		exitIfInterrupted(ctx, "nothing was imported")
+		if err != nil {
+			fail("Import failed: %v", err)
+		}
+		if ignored > 0 && !quiet {
+			fmt.Fprintf(os.Stderr, "Ignored %d statement(s) matching %s\n", ignored, ignorePath)
+		}
+
+		// Imported graphs replace the parent's version; others are inherited
+		parent, err := readCommit(parentHash)
+		if err != nil {
+			fail("Failed to read parent commit: %v", err)
+		}
+		graphs, err := readGraphs(parent.Tree)
+		if err != nil {
+			fail("Failed to read parent commit: %v", err)
+		}
+		stats := &quadstore.CommitStats{Graphs: make(map[string]quadstore.GraphStats)}
+		imported := runs.graphs()
+		for _, g := range imported {
+			blobHash, change, err := writeImportedGraph(runs, g, graphs[g])
+			if err != nil {
+				fail("Failed to write graph: %v", err)
+			}
+			graphs[g] = blobHash
+			if change.Added > 0 || change.Deleted > 0 {
+				stats.Graphs[g] = change
+				stats.Added += change.Added
+				stats.Deleted += change.Deleted
+			}
+		}
+		runs.Close()
+		for _, blobHash := range graphs {
+			sum, err := blobSummary(blobHash)
+			if err != nil {
+				log.Fatalf("Failed to read graph: %v", err)
+			}
+			stats.TotalQuads += int64(sum.Quads)
+		}
+		treeHash, err := writeTree(graphs)
+		if err != nil {
+			log.Fatalf("Failed to create tree object: %v", err)
+		}
+		newCommit := Commit{
+			Tree:      treeHash,
+			Parents:   []string{parentHash},
+			Author:    commitAuthor(),
+			Message:   message,
+			Timestamp: clock.Now(),
+			Stats:     stats,
+		}
+		if sign, _ := cmd.Flags().GetBool("gpg-sign"); sign {
+			if err := signCommit(&newCommit); err != nil {
+				log.Fatalf("Failed to sign commit: %v", err)
+			}
+		}
+		if err := enforceSignaturePolicy(currentBranch(), &newCommit); err != nil {
+			log.Fatal(err)
+		}
+		// Objects written so far are unreachable until HEAD moves, so
+		// stopping here leaves the repository as it was
+		exitIfInterrupted(ctx, "nothing was imported")
+		commitHash, err := writeObject(newCommit)
+		if err != nil {
+			log.Fatalf("Failed to write commit object: %v", err)
+		}
+		target, err := headTarget()
+		if err != nil {
+			log.Fatalf("Failed to read HEAD: %v", err)
+		}
+		if err := authorizeWrite(quadstore.ActionCommit, actingIdentity(), target, parentHash, commitHash); err != nil {
+			log.Fatalf("Import rejected: %v", err)
+		}
+		if err := updateHead(commitHash); err != nil {
+			log.Fatalf("Failed to update branch reference: %v", err)
+		}
+		fmt.Printf("[%s] %s (%d statements in %d graph(s), %s)\n", commitHash[:7], message, count, len(imported), time.Since(start).Round(time.Second))
+		syncAfterCommit(currentBranch())
+	},
+}
@@ -0,0 +1,107 @@
+// importdump_test.go
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+)
+
+func TestImportDumpRuns(t *testing.T) {
+	newTestRepo(t)
+	dump := strings.Join([]string{
+		`<http://example.org/c> <http://example.org/p> "3" <http://example.org/g> .`,
+		`<http://example.org/a> <http://example.org/p> "1" <http://example.org/g> .`,
+		`# a comment`,
+		`<http://example.org/b> <http://example.org/p> "2" .`,
+		`<http://example.org/a> <http://example.org/p> "1" <http://example.org/g> .`,
+		``,
+		`<http://example.org/b> <http://example.org/p> "<&>" <http://example.org/g> .`,
+		`<http://example.org/a> <http://example.org/p> "1" <http://example.org/g> .`,
+	}, "\n")
+	old, err := writeObject(Blob{`<http://example.org/a> <http://example.org/p> "1" .`, `<http://example.org/z> <http://example.org/p> "0" .`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		runSize   int
+		graph     string // Graph of the triples
+		old       map[string]string
+		wantCount int
+		want      map[string][]string
+		wantStats map[string]quadstore.GraphStats
+	}{
+		{
+			name: "in memory", runSize: 1000, graph: "<http://example.org/default>",
+			wantCount: 6,
+			want: map[string][]string{
+				"<http://example.org/g>": {
+					`<http://example.org/a> <http://example.org/p> "1" .`,
+					`<http://example.org/b> <http://example.org/p> "<&>" .`,
+					`<http://example.org/c> <http://example.org/p> "3" .`,
+				},
+				"<http://example.org/default>": {`<http://example.org/b> <http://example.org/p> "2" .`},
+			},
+			wantStats: map[string]quadstore.GraphStats{"<http://example.org/g>": {Added: 3}, "<http://example.org/default>": {Added: 1}},
+		},
+		{
+			name: "spilled runs", runSize: 2, graph: "",
+			old:       map[string]string{"<http://example.org/g>": old},
+			wantCount: 6,
+			want: map[string][]string{
+				"<http://example.org/g>": {
+					`<http://example.org/a> <http://example.org/p> "1" .`,
+					`<http://example.org/b> <http://example.org/p> "<&>" .`,
+					`<http://example.org/c> <http://example.org/p> "3" .`,
+				},
+				defaultGraph: {`<http://example.org/b> <http://example.org/p> "2" .`},
+			},
+			wantStats: map[string]quadstore.GraphStats{"<http://example.org/g>": {Added: 2, Deleted: 1}, defaultGraph: {Added: 1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runs, err := newDumpRuns(tt.runSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer runs.Close()
+			count, _, err := readDump(context.Background(), strings.NewReader(dump), "dump", tt.graph, nil, nil, runs, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != tt.wantCount {
+				t.Errorf("readDump read %d statements, want %d", count, tt.wantCount)
+			}
+			graphs := runs.graphs()
+			if len(graphs) != len(tt.want) {
+				t.Fatalf("readDump found graphs %q, want %d", graphs, len(tt.want))
+			}
+			for _, g := range graphs {
+				hash, change, err := writeImportedGraph(runs, g, tt.old[g])
+				if err != nil {
+					t.Fatal(err)
+				}
+				want, err := hashObject(Blob(tt.want[g]))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if hash != want {
+					blob, _ := readBlob(hash)
+					t.Errorf("graph %s was stored as %q, want %q", g, blob, tt.want[g])
+				}
+				if change != tt.wantStats[g] {
+					t.Errorf("graph %s changes = %+v, want %+v", g, change, tt.wantStats[g])
+				}
+				sum, err := blobSummary(hash)
+				if err != nil || sum.Quads != len(tt.want[g]) {
+					t.Errorf("graph %s summary = %+v, %v; want %d quads", g, sum, err, len(tt.want[g]))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+// importgit.go
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// gitCommit is one commit of the source repository, as read by git log.
+type gitCommit struct {
+	Hash    string
+	Parents []string
+	Author  string
+	Time    time.Time
+	Message string
+}
+
+// runGit runs git against the repository at dir and returns its output.
+func runGit(dir string, args ...string) ([]byte, error) {
+	return runGitWith(dir, nil, nil, args...)
+}
+
+// runGitWith runs git with extra environment variables and input on stdin.
+func runGitWith(dir string, env []string, input []byte, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Stderr = &stderr
+	if env != nil {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	if input != nil {
+		cmd.Stdin = bytes.NewReader(input)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// gitHistory lists the commits reachable from rev, parents before children.
+func gitHistory(dir, rev string) ([]gitCommit, error) {
+	out, err := runGit(dir, "log", "--reverse", "--topo-order", "--format=%H%x00%P%x00%an <%ae>%x00%aI%x00%B%x1e", rev)
+	if err != nil {
+		return nil, err
+	}
+	var commits []gitCommit
+	for _, record := range strings.Split(string(out), "\x1e") {
+		record = strings.TrimLeft(record, "\n")
+		if record == "" {
+			continue
+		}
+		fields := strings.SplitN(record, "\x00", 5)
+		if len(fields) != 5 {
+			return nil, fmt.Errorf("unexpected git log output: %q", record)
+		}
+		ts, err := time.Parse(time.RFC3339, fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("commit %s: %v", fields[0], err)
+		}
+		commits = append(commits, gitCommit{
+			Hash:    fields[0],
+			Parents: strings.Fields(fields[1]),
+			Author:  fields[2],
+			Time:    ts,
+			Message: strings.TrimRight(fields[4], "\n"),
+		})
+	}
+	return commits, nil
+}
+
+// rdfFiles lists the N-Quads and N-Triples files in a git commit, path to
+// blob hash.
+func rdfFiles(dir, commit string) (map[string]string, error) {
+	out, err := runGit(dir, "ls-tree", "-r", "-z", commit)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]string)
+	for _, entry := range strings.Split(string(out), "\x00") {
+		meta, name, ok := strings.Cut(entry, "\t")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(meta)
+		if len(fields) != 3 || fields[1] != "blob" {
+			continue
+		}
+		switch path.Ext(name) {
+		case ".nq", ".nt":
+			files[name] = fields[2]
+		}
+	}
+	return files, nil
+}
+
+// gitFileGraph returns the graph that triples without a graph term in a
+// file are stored under: a graph named after the file when graphBase is set,
+// the default graph otherwise.
+func gitFileGraph(graphBase, name string) string {
+	if graphBase == "" {
+		return ""
+	}
+	return "<" + graphBase + name + ">"
+}
+
+// canImportInto reports whether a branch may receive imported history:
+// it must not exist yet, or hold only the empty commit created by init.
+func canImportInto(branch string) bool {
+	tip, err := getReference("head:" + branch)
+	if err != nil {
+		return true
+	}
+	commit, err := readCommit(tip)
+	if err != nil || len(commit.Parents) > 0 {
+		return false
+	}
+	graphs, err := readGraphs(commit.Tree)
+	return err == nil && len(graphs) == 0
+}
+
+var importGitCmd = &cobra.Command{
+	Use:   "import-git <path>",
+	Short: "Recreate the history of a git repository of RDF files",
+	Long: `Walk the history of a git repository and record one commit per git
+commit, preserving authors, messages, timestamps and merges.
+
+Every .nq and .nt file in a git commit contributes its statements to the
+recorded state. Triples without a graph term go to the default graph, or,
+with --graph-base, to a graph named by the base IRI plus the file's path.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dir := args[0]
+		rev, _ := cmd.Flags().GetString("rev")
+		graphBase, _ := cmd.Flags().GetString("graph-base")
+		branch, _ := cmd.Flags().GetString("branch")
+		if branch == "" {
+			branch = currentBranch()
+		}
+		if !canImportInto(branch) {
+			log.Fatalf("Branch %s already has history; choose another with --branch.", branch)
+		}
+
+		history, err := gitHistory(dir, rev)
+		if err != nil {
+			log.Fatalf("Failed to read git history: %v", err)
+		}
+		if len(history) == 0 {
+			log.Fatalf("No commits found at %s in %s.", rev, dir)
+		}
+
+		// Files are parsed once per distinct git blob, not once per commit
+		parsed := make(map[string]map[string]quadSet)
+		imported := make(map[string]string, len(history))
+		// A commit's state is kept until its last child has been imported
+		states := make(map[string]map[string]quadSet)
+		children := make(map[string]int)
+		for _, gc := range history {
+			if len(gc.Parents) > 0 {
+				children[gc.Parents[0]]++
+			}
+		}
+		var tip string
+		for _, gc := range history {
+			files, err := rdfFiles(dir, gc.Hash)
+			if err != nil {
+				log.Fatalf("Failed to list files of %s: %v", gc.Hash[:7], err)
+			}
+			after := make(map[string]quadSet)
+			for name, blob := range files {
+				fileState, ok := parsed[blob]
+				if !ok {
+					content, err := runGit(dir, "cat-file", "blob", blob)
+					if err != nil {
+						log.Fatalf("Failed to read %s: %v", name, err)
+					}
+					fileState = make(map[string]quadSet)
+					source := gc.Hash[:7] + ":" + name
+					if err := readQuadsInto(fileState, bytes.NewReader(content), source, gitFileGraph(graphBase, name)); err != nil {
+						log.Fatalf("Failed to parse %v", err)
+					}
+					parsed[blob] = fileState
+				}
+				for graph, set := range fileState {
+					if after[graph] == nil {
+						after[graph] = make(quadSet, len(set))
+					}
+					for line := range set {
+						after[graph][line] = true
+					}
+				}
+			}
+
+			treeHash, err := writeState(after)
+			if err != nil {
+				log.Fatalf("Failed to create tree object: %v", err)
+			}
+			commit := Commit{
+				Tree:      treeHash,
+				Parents:   []string{},
+				Author:    gc.Author,
+				Message:   gc.Message,
+				Timestamp: gc.Time,
+			}
+			for _, parent := range gc.Parents {
+				commit.Parents = append(commit.Parents, imported[parent])
+			}
+			before := map[string]quadSet{}
+			if len(gc.Parents) > 0 {
+				before = states[gc.Parents[0]]
+				if children[gc.Parents[0]]--; children[gc.Parents[0]] == 0 {
+					delete(states, gc.Parents[0])
+				}
+			}
+			if commit.Stats, err = computeStats(before, after); err != nil {
+				log.Fatalf("Failed to compute commit stats: %v", err)
+			}
+			if tip, err = writeObject(commit); err != nil {
+				log.Fatalf("Failed to write commit object: %v", err)
+			}
+			imported[gc.Hash] = tip
+			if children[gc.Hash] > 0 {
+				states[gc.Hash] = after
+			}
+		}
+
+		if err := setReference("head:"+branch, tip); err != nil {
+			log.Fatalf("Failed to update branch reference: %v", err)
+		}
+		fmt.Printf("Imported %d commit(s) from %s into %s (%s)\n", len(history), dir, branch, tip[:7])
+	},
+}
@@ -0,0 +1,119 @@
+// Package bitmap implements growable bitsets with a compact serialized form,
+// used to index which commits are reachable from which.
+package bitmap
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+// Bitmap is a set of non-negative integers. The zero value is empty.
+type Bitmap struct {
+	words []uint64
+}
+
+// Set adds i to the set.
+func (b *Bitmap) Set(i uint32) {
+	w := int(i / 64)
+	if w >= len(b.words) {
+		b.words = append(b.words, make([]uint64, w+1-len(b.words))...)
+	}
+	b.words[w] |= 1 << (i % 64)
+}
+
+// Has reports whether i is in the set.
+func (b *Bitmap) Has(i uint32) bool {
+	w := int(i / 64)
+	return w < len(b.words) && b.words[w]&(1<<(i%64)) != 0
+}
+
+// Or adds every member of other to b.
+func (b *Bitmap) Or(other *Bitmap) {
+	if len(other.words) > len(b.words) {
+		b.words = append(b.words, make([]uint64, len(other.words)-len(b.words))...)
+	}
+	for i, w := range other.words {
+		b.words[i] |= w
+	}
+}
+
+// AndNot removes every member of other from b.
+func (b *Bitmap) AndNot(other *Bitmap) {
+	for i := 0; i < len(b.words) && i < len(other.words); i++ {
+		b.words[i] &^= other.words[i]
+	}
+}
+
+// Count returns the number of members.
+func (b *Bitmap) Count() int {
+	n := 0
+	for _, w := range b.words {
+		n += bits.OnesCount64(w)
+	}
+	return n
+}
+
+// ForEach calls fn for every member in increasing order.
+func (b *Bitmap) ForEach(fn func(i uint32)) {
+	for wi, w := range b.words {
+		for w != 0 {
+			t := bits.TrailingZeros64(w)
+			fn(uint32(wi*64 + t))
+			w &^= 1 << t
+		}
+	}
+}
+
+// MarshalBinary encodes b as runs: a uvarint count of empty words, a uvarint
+// count of literal words, then those words as little-endian uint64s,
+// repeated. Sparse and dense histories both encode compactly.
+func (b *Bitmap) MarshalBinary() ([]byte, error) {
+	var out []byte
+	for i := 0; i < len(b.words); {
+		zeros := 0
+		for i < len(b.words) && b.words[i] == 0 {
+			zeros++
+			i++
+		}
+		start := i
+		for i < len(b.words) && b.words[i] != 0 {
+			i++
+		}
+		out = binary.AppendUvarint(out, uint64(zeros))
+		out = binary.AppendUvarint(out, uint64(i-start))
+		for _, w := range b.words[start:i] {
+			out = binary.LittleEndian.AppendUint64(out, w)
+		}
+	}
+	return out, nil
+}
+
+var errCorrupt = errors.New("bitmap: corrupt encoding")
+
+// maxWords is the number of words the largest uint32 member needs.
+const maxWords = 1 << 26
+
+// UnmarshalBinary decodes the output of MarshalBinary into b.
+func (b *Bitmap) UnmarshalBinary(data []byte) error {
+	b.words = b.words[:0]
+	for len(data) > 0 {
+		zeros, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errCorrupt
+		}
+		data = data[n:]
+		literals, n := binary.Uvarint(data)
+		room := maxWords - uint64(len(b.words))
+		if n <= 0 || literals > uint64(len(data)-n)/8 || literals > room || zeros > room-literals {
+			return errCorrupt
+		}
+		data = data[n:]
+		b.words = append(b.words, make([]uint64, zeros)...)
+		for ; literals > 0; literals-- {
+			b.words = append(b.words, binary.LittleEndian.Uint64(data))
+			data = data[8:]
+		}
+	}
+	return nil
+}
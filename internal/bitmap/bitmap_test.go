@@ -0,0 +1,106 @@
+package bitmap
+
+import (
+	"encoding/binary"
+	"slices"
+	"testing"
+)
+
+func bitmapOf(members ...uint32) *Bitmap {
+	var b Bitmap
+	for _, i := range members {
+		b.Set(i)
+	}
+	return &b
+}
+
+func membersOf(b *Bitmap) []uint32 {
+	var members []uint32
+	b.ForEach(func(i uint32) { members = append(members, i) })
+	return members
+}
+
+func TestBitmapOperations(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		got  *Bitmap
+		want []uint32
+	}{
+		{"set", bitmapOf(3, 1, 64, 1000, 1), []uint32{1, 3, 64, 1000}},
+		{"or", func() *Bitmap { b := bitmapOf(1, 2); b.Or(bitmapOf(2, 200)); return b }(), []uint32{1, 2, 200}},
+		{"or into a longer bitmap", func() *Bitmap { b := bitmapOf(1, 500); b.Or(bitmapOf(3)); return b }(), []uint32{1, 3, 500}},
+		{"and not", func() *Bitmap { b := bitmapOf(1, 2, 300); b.AndNot(bitmapOf(2, 300, 9000)); return b }(), []uint32{1}},
+		{"empty", &Bitmap{}, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := membersOf(tt.got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("members = %v, want %v", got, tt.want)
+			}
+			if tt.got.Count() != len(tt.want) {
+				t.Errorf("Count() = %d, want %d", tt.got.Count(), len(tt.want))
+			}
+			for _, i := range tt.want {
+				if !tt.got.Has(i) {
+					t.Errorf("Has(%d) = false", i)
+				}
+			}
+			if tt.got.Has(5) != slices.Contains(tt.want, 5) {
+				t.Errorf("Has(5) = %v", tt.got.Has(5))
+			}
+		})
+	}
+}
+
+func TestBitmapEncoding(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		members []uint32
+	}{
+		{"empty", nil},
+		{"dense", []uint32{0, 1, 2, 63, 64, 65, 127}},
+		{"sparse", []uint32{5, 100000, 4000000}},
+		{"far apart", []uint32{0, 1 << 24}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := bitmapOf(tt.members...).MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var b Bitmap
+			if err := b.UnmarshalBinary(data); err != nil {
+				t.Fatal(err)
+			}
+			if got := membersOf(&b); !slices.Equal(got, tt.members) {
+				t.Errorf("decoded %v, want %v", got, tt.members)
+			}
+		})
+	}
+}
+
+func TestBitmapCorruptEncoding(t *testing.T) {
+	uvarints := func(vs ...uint64) []byte {
+		var out []byte
+		for _, v := range vs {
+			out = binary.AppendUvarint(out, v)
+		}
+		return out
+	}
+	for _, tt := range []struct {
+		name string
+		data []byte
+	}{
+		{"truncated count", []byte{0x80}},
+		{"missing literal count", uvarints(1)},
+		{"literals past the end", append(uvarints(0, 2), make([]byte, 8)...)},
+		{"overflowing literal count", append(uvarints(0, 1<<61), make([]byte, 8)...)},
+		{"too many empty words", uvarints(1<<40, 0)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var b Bitmap
+			if err := b.UnmarshalBinary(tt.data); err == nil {
+				t.Errorf("UnmarshalBinary(%x) succeeded", tt.data)
+			}
+		})
+	}
+}
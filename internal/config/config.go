@@ -1 +1,77 @@
--e 
+// Package config loads and persists repository-level settings. Settings are
+// flat, git-style dotted keys (e.g. "merge.template") stored as a JSON object
+// in the repository's config file.
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// FileName is the name of the config file inside the repository directory.
+const FileName = "config"
+
+// Config holds the settings of one repository.
+type Config struct {
+	path   string
+	values map[string]string
+}
+
+// Load reads the config file of the repository at dir. A missing file yields
+// an empty configuration.
+func Load(dir string) (*Config, error) {
+	c := &Config{path: filepath.Join(dir, FileName), values: make(map[string]string)}
+	data, err := os.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.values); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Get returns the value of key, or an empty string if it is not set.
+func (c *Config) Get(key string) string {
+	return c.values[key]
+}
+
+// Lookup returns the value of key and whether it is set.
+func (c *Config) Lookup(key string) (string, bool) {
+	v, ok := c.values[key]
+	return v, ok
+}
+
+// Set assigns a value to key. Call Save to persist it.
+func (c *Config) Set(key, value string) {
+	c.values[key] = value
+}
+
+// Unset removes key. Call Save to persist the change.
+func (c *Config) Unset(key string) {
+	delete(c.values, key)
+}
+
+// Keys returns all configured keys in sorted order.
+func (c *Config) Keys() []string {
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Save writes the configuration back to disk.
+func (c *Config) Save() error {
+	data, err := json.MarshalIndent(c.values, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, append(data, '\n'), 0644)
+}
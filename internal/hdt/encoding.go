@@ -0,0 +1,457 @@
+package hdt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math/bits"
+	"strings"
+)
+
+// Checksums used by HDT: CRC8-CCITT over structure headers, CRC16-ANSI over
+// control information and CRC32C over data payloads. All are stored
+// little-endian directly after the bytes they cover.
+var (
+	crc8Table  [256]byte
+	crc16Table [256]uint16
+	castagnoli = crc32.MakeTable(crc32.Castagnoli)
+)
+
+func init() {
+	for i := 0; i < 256; i++ {
+		c8 := byte(i)
+		for j := 0; j < 8; j++ {
+			if c8&0x80 != 0 {
+				c8 = c8<<1 ^ 0x07
+			} else {
+				c8 <<= 1
+			}
+		}
+		crc8Table[i] = c8
+
+		c16 := uint16(i)
+		for j := 0; j < 8; j++ {
+			if c16&1 != 0 {
+				c16 = c16>>1 ^ 0xA001
+			} else {
+				c16 >>= 1
+			}
+		}
+		crc16Table[i] = c16
+	}
+}
+
+func crc8(data []byte) byte {
+	var crc byte
+	for _, b := range data {
+		crc = crc8Table[crc^b]
+	}
+	return crc
+}
+
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc = crc>>8 ^ crc16Table[byte(crc)^b]
+	}
+	return crc
+}
+
+// Structure type bytes.
+const (
+	typeGlobal     = 1
+	typeHeader     = 2
+	typeDictionary = 3
+	typeTriples    = 4
+
+	typeLogSequence = 1
+	typeBitmap      = 1
+	typePFC         = 2
+)
+
+// appendVByte appends v in HDT's variable-length encoding: 7 bits per byte,
+// least significant group first, with the high bit set on the last byte.
+func appendVByte(buf []byte, v uint64) []byte {
+	for v > 127 {
+		buf = append(buf, byte(v&127))
+		v >>= 7
+	}
+	return append(buf, byte(v)|0x80)
+}
+
+// An encoder accumulates an HDT document in memory.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) crc8(start int) {
+	e.buf = append(e.buf, crc8(e.buf[start:]))
+}
+
+func (e *encoder) crc16(start int) {
+	e.buf = binary.LittleEndian.AppendUint16(e.buf, crc16(e.buf[start:]))
+}
+
+func (e *encoder) crc32(start int) {
+	e.buf = binary.LittleEndian.AppendUint32(e.buf, crc32.Checksum(e.buf[start:], castagnoli))
+}
+
+// controlInfo writes a control information block; props are "key=value"
+// pairs.
+func (e *encoder) controlInfo(typ byte, format string, props ...string) {
+	start := len(e.buf)
+	e.buf = append(e.buf, "$HDT"...)
+	e.buf = append(e.buf, typ)
+	e.buf = append(e.buf, format...)
+	e.buf = append(e.buf, 0)
+	for _, p := range props {
+		e.buf = append(e.buf, p+";"...)
+	}
+	e.buf = append(e.buf, 0)
+	e.crc16(start)
+}
+
+// logSequence writes values as a log sequence: fixed-width integers just
+// wide enough for the largest value, packed least significant bit first.
+func (e *encoder) logSequence(values []uint64) {
+	var max uint64
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+	width := bits.Len64(max)
+	if width == 0 {
+		width = 1
+	}
+	start := len(e.buf)
+	e.buf = append(e.buf, typeLogSequence, byte(width))
+	e.buf = appendVByte(e.buf, uint64(len(values)))
+	e.crc8(start)
+
+	data := make([]byte, (width*len(values)+7)/8)
+	for i, v := range values {
+		for b := 0; b < width; b++ {
+			if v>>b&1 != 0 {
+				pos := i*width + b
+				data[pos/8] |= 1 << (pos % 8)
+			}
+		}
+	}
+	start = len(e.buf)
+	e.buf = append(e.buf, data...)
+	e.crc32(start)
+}
+
+// bitmap writes a plain bitmap, least significant bit first.
+func (e *encoder) bitmap(set []bool) {
+	start := len(e.buf)
+	e.buf = append(e.buf, typeBitmap)
+	e.buf = appendVByte(e.buf, uint64(len(set)))
+	e.crc8(start)
+
+	data := make([]byte, (len(set)+7)/8)
+	for i, bit := range set {
+		if bit {
+			data[i/8] |= 1 << (i % 8)
+		}
+	}
+	start = len(e.buf)
+	e.buf = append(e.buf, data...)
+	e.crc32(start)
+}
+
+// pfcBlockSize is the number of strings per Plain Front Coding block.
+const pfcBlockSize = 16
+
+// pfcSection writes sorted strings as a Plain Front Coding dictionary
+// section: each block starts with a complete string, and every following
+// string is stored as the length of the prefix it shares with its
+// predecessor plus the remaining suffix.
+func (e *encoder) pfcSection(strs []string) {
+	var text []byte
+	var blocks []uint64
+	for i, s := range strs {
+		if i%pfcBlockSize == 0 {
+			blocks = append(blocks, uint64(len(text)))
+			text = append(text, s...)
+		} else {
+			prev, n := strs[i-1], 0
+			for n < len(prev) && n < len(s) && prev[n] == s[n] {
+				n++
+			}
+			text = appendVByte(text, uint64(n))
+			text = append(text, s[n:]...)
+		}
+		text = append(text, 0)
+	}
+	blocks = append(blocks, uint64(len(text)))
+
+	start := len(e.buf)
+	e.buf = append(e.buf, typePFC)
+	e.buf = appendVByte(e.buf, uint64(len(strs)))
+	e.buf = appendVByte(e.buf, uint64(len(text)))
+	e.buf = appendVByte(e.buf, pfcBlockSize)
+	e.crc8(start)
+	e.logSequence(blocks)
+	start = len(e.buf)
+	e.buf = append(e.buf, text...)
+	e.crc32(start)
+}
+
+// A decoder reads an HDT document from memory, verifying checksums.
+type decoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *decoder) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("hdt: offset %d: %s", d.pos, fmt.Sprintf(format, args...))
+}
+
+func (d *decoder) next(n int) ([]byte, error) {
+	if n < 0 || d.pos+n > len(d.data) {
+		return nil, d.errorf("unexpected end of file")
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *decoder) byte() (byte, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (d *decoder) vbyte() (uint64, error) {
+	var v uint64
+	for shift := 0; shift < 64; shift += 7 {
+		b, err := d.byte()
+		if err != nil {
+			return 0, err
+		}
+		v |= uint64(b&127) << shift
+		if b&0x80 != 0 {
+			return v, nil
+		}
+	}
+	return 0, d.errorf("variable-length integer overflows 64 bits")
+}
+
+func (d *decoder) cstring() (string, error) {
+	end := d.pos
+	for end < len(d.data) && d.data[end] != 0 {
+		end++
+	}
+	if end == len(d.data) {
+		return "", d.errorf("unterminated string")
+	}
+	s := string(d.data[d.pos:end])
+	d.pos = end + 1
+	return s, nil
+}
+
+func (d *decoder) checkCRC8(start int) error {
+	want := crc8(d.data[start:d.pos])
+	got, err := d.byte()
+	if err == nil && got != want {
+		err = d.errorf("header checksum mismatch")
+	}
+	return err
+}
+
+func (d *decoder) checkCRC16(start int) error {
+	want := crc16(d.data[start:d.pos])
+	got, err := d.next(2)
+	if err == nil && binary.LittleEndian.Uint16(got) != want {
+		err = d.errorf("control information checksum mismatch")
+	}
+	return err
+}
+
+func (d *decoder) checkCRC32(start int) error {
+	want := crc32.Checksum(d.data[start:d.pos], castagnoli)
+	got, err := d.next(4)
+	if err == nil && binary.LittleEndian.Uint32(got) != want {
+		err = d.errorf("data checksum mismatch")
+	}
+	return err
+}
+
+// controlInfo reads a control information block of the expected type.
+func (d *decoder) controlInfo(typ byte) (format string, props map[string]string, err error) {
+	start := d.pos
+	magic, err := d.next(4)
+	if err != nil {
+		return "", nil, err
+	}
+	if string(magic) != "$HDT" {
+		return "", nil, d.errorf("missing $HDT control information")
+	}
+	got, err := d.byte()
+	if err != nil {
+		return "", nil, err
+	}
+	if got != typ {
+		return "", nil, d.errorf("expected control information of type %d, found %d", typ, got)
+	}
+	if format, err = d.cstring(); err != nil {
+		return "", nil, err
+	}
+	raw, err := d.cstring()
+	if err != nil {
+		return "", nil, err
+	}
+	props = make(map[string]string)
+	for _, p := range strings.Split(raw, ";") {
+		if key, value, ok := strings.Cut(p, "="); ok {
+			props[key] = value
+		}
+	}
+	return format, props, d.checkCRC16(start)
+}
+
+func (d *decoder) logSequence() ([]uint64, error) {
+	start := d.pos
+	typ, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+	if typ != typeLogSequence {
+		return nil, d.errorf("unsupported sequence type %d", typ)
+	}
+	width, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+	if width > 64 {
+		return nil, d.errorf("invalid sequence width %d", width)
+	}
+	count, err := d.vbyte()
+	if err != nil {
+		return nil, err
+	}
+	if err := d.checkCRC8(start); err != nil {
+		return nil, err
+	}
+	if count > uint64(len(d.data))*8 {
+		return nil, d.errorf("sequence length %d exceeds file size", count)
+	}
+
+	start = d.pos
+	data, err := d.next((int(width)*int(count) + 7) / 8)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]uint64, count)
+	for i := range values {
+		for b := 0; b < int(width); b++ {
+			pos := i*int(width) + b
+			if data[pos/8]>>(pos%8)&1 != 0 {
+				values[i] |= 1 << b
+			}
+		}
+	}
+	return values, d.checkCRC32(start)
+}
+
+func (d *decoder) bitmap() ([]bool, error) {
+	start := d.pos
+	typ, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+	if typ != typeBitmap {
+		return nil, d.errorf("unsupported bitmap type %d", typ)
+	}
+	count, err := d.vbyte()
+	if err != nil {
+		return nil, err
+	}
+	if err := d.checkCRC8(start); err != nil {
+		return nil, err
+	}
+	if count > uint64(len(d.data))*8 {
+		return nil, d.errorf("bitmap length %d exceeds file size", count)
+	}
+
+	start = d.pos
+	data, err := d.next((int(count) + 7) / 8)
+	if err != nil {
+		return nil, err
+	}
+	set := make([]bool, count)
+	for i := range set {
+		set[i] = data[i/8]>>(i%8)&1 != 0
+	}
+	return set, d.checkCRC32(start)
+}
+
+func (d *decoder) pfcSection() ([]string, error) {
+	start := d.pos
+	typ, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+	if typ != typePFC {
+		return nil, d.errorf("unsupported dictionary section type %d", typ)
+	}
+	count, err := d.vbyte()
+	if err != nil {
+		return nil, err
+	}
+	size, err := d.vbyte()
+	if err != nil {
+		return nil, err
+	}
+	blockSize, err := d.vbyte()
+	if err != nil {
+		return nil, err
+	}
+	if err := d.checkCRC8(start); err != nil {
+		return nil, err
+	}
+	if blockSize == 0 {
+		return nil, d.errorf("invalid block size 0")
+	}
+	if _, err := d.logSequence(); err != nil {
+		return nil, err
+	}
+
+	start = d.pos
+	text, err := d.next(int(size))
+	if err != nil {
+		return nil, err
+	}
+	if err := d.checkCRC32(start); err != nil {
+		return nil, err
+	}
+	if count > size {
+		return nil, d.errorf("section holds %d strings in %d bytes", count, size)
+	}
+	strs := make([]string, 0, count)
+	t := &decoder{data: text}
+	for i := uint64(0); i < count; i++ {
+		var prefix string
+		if i%blockSize != 0 {
+			n, err := t.vbyte()
+			if err != nil {
+				return nil, err
+			}
+			prev := strs[i-1]
+			if n > uint64(len(prev)) {
+				return nil, t.errorf("shared prefix longer than previous string")
+			}
+			prefix = prev[:n]
+		}
+		suffix, err := t.cstring()
+		if err != nil {
+			return nil, err
+		}
+		strs = append(strs, prefix+suffix)
+	}
+	return strs, nil
+}
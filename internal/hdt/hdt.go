@@ -0,0 +1,309 @@
+// Package hdt reads and writes HDT (Header-Dictionary-Triples) files, the
+// compact binary RDF format described at https://www.rdfhdt.org/. It supports
+// the layout produced by the reference tools: a four-section dictionary of
+// Plain Front Coding sections and bitmap triples in SPO order.
+//
+// Terms cross this package in their N-Triples surface form, e.g.
+// `<http://ex.org/a>`, `_:b0` or `"chat"@fr`.
+package hdt
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Vocabulary used in control information and the header.
+const (
+	formatHDT        = "<http://purl.org/HDT/hdt#HDTv1>"
+	formatDictionary = "<http://purl.org/HDT/hdt#dictionaryFour>"
+	formatTriples    = "<http://purl.org/HDT/hdt#triplesBitmap>"
+	formatHeader     = "ntriples"
+
+	orderSPO = "1"
+
+	rdfType    = "<http://www.w3.org/1999/02/22-rdf-syntax-ns#type>"
+	hdtDataset = "<http://purl.org/HDT/hdt#Dataset>"
+	void       = "http://rdfs.org/ns/void#"
+)
+
+// A Triple is one RDF statement.
+type Triple struct {
+	Subject, Predicate, Object string
+}
+
+// A Document is the content of an HDT file.
+type Document struct {
+	// BaseURI names the dataset; it is the subject of the header statements.
+	BaseURI string
+	// Header holds N-Triples statements describing the dataset. When
+	// encoding, the standard statistics are generated and these are added.
+	Header []string
+	// Triples holds the dataset's statements.
+	Triples []Triple
+}
+
+// dictString converts an N-Triples term to the string HDT stores: IRIs lose
+// their angle brackets, literals and blank nodes are kept as written.
+func dictString(term string) string {
+	if strings.HasPrefix(term, "<") && strings.HasSuffix(term, ">") {
+		return term[1 : len(term)-1]
+	}
+	return term
+}
+
+// termString is the inverse of dictString.
+func termString(s string) string {
+	if strings.HasPrefix(s, `"`) || strings.HasPrefix(s, "_:") {
+		return s
+	}
+	return "<" + s + ">"
+}
+
+// Encode writes doc to w in HDT format.
+func Encode(w io.Writer, doc *Document) error {
+	// 1. Dictionary: terms used as both subject and object are shared and
+	// numbered first; subject-only and object-only terms follow them.
+	subjects := make(map[string]bool)
+	predicates := make(map[string]bool)
+	objects := make(map[string]bool)
+	for _, t := range doc.Triples {
+		subjects[dictString(t.Subject)] = true
+		predicates[dictString(t.Predicate)] = true
+		objects[dictString(t.Object)] = true
+	}
+	var shared, subjectOnly, objectOnly, predicateList []string
+	for s := range subjects {
+		if objects[s] {
+			shared = append(shared, s)
+		} else {
+			subjectOnly = append(subjectOnly, s)
+		}
+	}
+	for o := range objects {
+		if !subjects[o] {
+			objectOnly = append(objectOnly, o)
+		}
+	}
+	for p := range predicates {
+		predicateList = append(predicateList, p)
+	}
+	for _, section := range [][]string{shared, subjectOnly, objectOnly, predicateList} {
+		sort.Strings(section)
+	}
+	number := func(sections ...[]string) map[string]uint64 {
+		ids := make(map[string]uint64)
+		for _, section := range sections {
+			for _, s := range section {
+				ids[s] = uint64(len(ids) + 1)
+			}
+		}
+		return ids
+	}
+	subjectIDs := number(shared, subjectOnly)
+	objectIDs := number(shared, objectOnly)
+	predicateIDs := number(predicateList)
+
+	// 2. Triples, sorted by ID in SPO order and deduplicated
+	type idTriple struct{ s, p, o uint64 }
+	ids := make([]idTriple, 0, len(doc.Triples))
+	for _, t := range doc.Triples {
+		ids = append(ids, idTriple{subjectIDs[dictString(t.Subject)], predicateIDs[dictString(t.Predicate)], objectIDs[dictString(t.Object)]})
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := ids[i], ids[j]
+		if a.s != b.s {
+			return a.s < b.s
+		}
+		if a.p != b.p {
+			return a.p < b.p
+		}
+		return a.o < b.o
+	})
+	var seqY, seqZ []uint64
+	var bitY, bitZ []bool
+	for i, t := range ids {
+		if i > 0 && t == ids[i-1] {
+			continue
+		}
+		if i == 0 || t.s != ids[i-1].s || t.p != ids[i-1].p {
+			if len(bitZ) > 0 {
+				bitZ[len(bitZ)-1] = true
+				if t.s != ids[i-1].s {
+					bitY[len(bitY)-1] = true
+				}
+			}
+			seqY = append(seqY, t.p)
+			bitY = append(bitY, false)
+		}
+		seqZ = append(seqZ, t.o)
+		bitZ = append(bitZ, false)
+	}
+	if len(bitZ) > 0 {
+		bitY[len(bitY)-1] = true
+		bitZ[len(bitZ)-1] = true
+	}
+
+	// 3. Header
+	base := doc.BaseURI
+	header := []string{
+		fmt.Sprintf("%s %s %s .", base, rdfType, hdtDataset),
+		fmt.Sprintf("%s %s <%sDataset> .", base, rdfType, void),
+		fmt.Sprintf(`%s <%striples> "%d" .`, base, void, len(seqZ)),
+		fmt.Sprintf(`%s <%sproperties> "%d" .`, base, void, len(predicateList)),
+		fmt.Sprintf(`%s <%sdistinctSubjects> "%d" .`, base, void, len(shared)+len(subjectOnly)),
+		fmt.Sprintf(`%s <%sdistinctObjects> "%d" .`, base, void, len(shared)+len(objectOnly)),
+	}
+	header = append(header, doc.Header...)
+	headerText := strings.Join(header, "\n") + "\n"
+
+	e := &encoder{}
+	e.controlInfo(typeGlobal, formatHDT)
+	e.controlInfo(typeHeader, formatHeader, "length="+strconv.Itoa(len(headerText)))
+	e.buf = append(e.buf, headerText...)
+	stringBytes := 0
+	for _, section := range [][]string{shared, subjectOnly, objectOnly, predicateList} {
+		for _, s := range section {
+			stringBytes += len(s)
+		}
+	}
+	elements := len(shared) + len(subjectOnly) + len(objectOnly) + len(predicateList)
+	e.controlInfo(typeDictionary, formatDictionary, "mapping=1", "sizeStrings="+strconv.Itoa(stringBytes), "elements="+strconv.Itoa(elements))
+	e.pfcSection(shared)
+	e.pfcSection(subjectOnly)
+	e.pfcSection(predicateList)
+	e.pfcSection(objectOnly)
+	e.controlInfo(typeTriples, formatTriples, "order="+orderSPO)
+	e.bitmap(bitY)
+	e.bitmap(bitZ)
+	e.logSequence(seqY)
+	e.logSequence(seqZ)
+
+	_, err := w.Write(e.buf)
+	return err
+}
+
+// Decode parses an HDT file.
+func Decode(data []byte) (*Document, error) {
+	d := &decoder{data: data}
+	if format, _, err := d.controlInfo(typeGlobal); err != nil {
+		return nil, err
+	} else if format != formatHDT {
+		return nil, fmt.Errorf("hdt: unsupported format %s", format)
+	}
+
+	// 1. Header
+	format, props, err := d.controlInfo(typeHeader)
+	if err != nil {
+		return nil, err
+	}
+	if format != formatHeader {
+		return nil, fmt.Errorf("hdt: unsupported header format %s", format)
+	}
+	length, err := strconv.Atoi(props["length"])
+	if err != nil {
+		return nil, fmt.Errorf("hdt: invalid header length %q", props["length"])
+	}
+	headerText, err := d.next(length)
+	if err != nil {
+		return nil, err
+	}
+	doc := &Document{}
+	for _, line := range strings.Split(string(headerText), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		doc.Header = append(doc.Header, line)
+		if fields := strings.Fields(line); len(fields) >= 3 && fields[1] == rdfType && fields[2] == hdtDataset {
+			doc.BaseURI = fields[0]
+		}
+	}
+
+	// 2. Dictionary
+	if format, _, err = d.controlInfo(typeDictionary); err != nil {
+		return nil, err
+	}
+	if format != formatDictionary {
+		return nil, fmt.Errorf("hdt: unsupported dictionary %s", format)
+	}
+	var sections [4][]string // shared, subjects, predicates, objects
+	for i := range sections {
+		if sections[i], err = d.pfcSection(); err != nil {
+			return nil, err
+		}
+	}
+	shared, subjectOnly, predicateList, objectOnly := sections[0], sections[1], sections[2], sections[3]
+	lookup := func(id uint64, first, second []string) (string, error) {
+		switch {
+		case id >= 1 && id <= uint64(len(first)):
+			return termString(first[id-1]), nil
+		case id > uint64(len(first)) && id <= uint64(len(first)+len(second)):
+			return termString(second[id-uint64(len(first))-1]), nil
+		}
+		return "", fmt.Errorf("hdt: term id %d out of range", id)
+	}
+
+	// 3. Triples
+	if format, props, err = d.controlInfo(typeTriples); err != nil {
+		return nil, err
+	}
+	if format != formatTriples {
+		return nil, fmt.Errorf("hdt: unsupported triples encoding %s", format)
+	}
+	if order := props["order"]; order != orderSPO {
+		return nil, fmt.Errorf("hdt: unsupported triple order %s", order)
+	}
+	bitY, err := d.bitmap()
+	if err != nil {
+		return nil, err
+	}
+	bitZ, err := d.bitmap()
+	if err != nil {
+		return nil, err
+	}
+	seqY, err := d.logSequence()
+	if err != nil {
+		return nil, err
+	}
+	seqZ, err := d.logSequence()
+	if err != nil {
+		return nil, err
+	}
+	if len(bitY) != len(seqY) || len(bitZ) != len(seqZ) {
+		return nil, fmt.Errorf("hdt: triple bitmaps do not match their sequences")
+	}
+
+	subject, z := uint64(1), 0
+	for y, p := range seqY {
+		s, err := lookup(subject, shared, subjectOnly)
+		if err != nil {
+			return nil, err
+		}
+		pred, err := lookup(p, predicateList, nil)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			if z >= len(seqZ) {
+				return nil, fmt.Errorf("hdt: triple objects end early")
+			}
+			o, err := lookup(seqZ[z], shared, objectOnly)
+			if err != nil {
+				return nil, err
+			}
+			doc.Triples = append(doc.Triples, Triple{s, pred, o})
+			last := bitZ[z]
+			z++
+			if last {
+				break
+			}
+		}
+		if bitY[y] {
+			subject++
+		}
+	}
+	return doc, nil
+}
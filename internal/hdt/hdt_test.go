@@ -0,0 +1,157 @@
+package hdt
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const base = "<http://example.org/dataset>"
+
+func testDocument() *Document {
+	var triples []Triple
+	// Enough subjects to fill more than one Plain Front Coding block.
+	for i := 0; i < 40; i++ {
+		s := "<http://example.org/item/" + string(rune('a'+i%26)) + strings.Repeat("x", i/26) + ">"
+		triples = append(triples,
+			Triple{s, "<http://example.org/label>", `"item ` + s[len(s)-3:len(s)-1] + `"@en`},
+			Triple{s, "<http://example.org/next>", "<http://example.org/item/a>"},
+		)
+	}
+	triples = append(triples,
+		Triple{"_:b0", "<http://example.org/count>", `"42"^^<http://www.w3.org/2001/XMLSchema#integer>`},
+		Triple{"_:b0", "<http://example.org/count>", `"42"^^<http://www.w3.org/2001/XMLSchema#integer>`},
+		Triple{"<http://example.org/item/a>", "<http://example.org/owner>", "_:b0"},
+	)
+	return &Document{
+		BaseURI: base,
+		Header:  []string{base + ` <http://purl.org/dc/terms/title> "Test data" .`},
+		Triples: triples,
+	}
+}
+
+func encode(t *testing.T, doc *Document) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := Encode(&buf, doc); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// sortedTriples returns the distinct triples in a stable order.
+func sortedTriples(triples []Triple) []Triple {
+	seen := make(map[Triple]bool)
+	var out []Triple
+	for _, t := range triples {
+		if !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		return a.Subject+"\x00"+a.Predicate+"\x00"+a.Object < b.Subject+"\x00"+b.Predicate+"\x00"+b.Object
+	})
+	return out
+}
+
+func TestRoundTrip(t *testing.T) {
+	doc := testDocument()
+	got, err := Decode(encode(t, doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.BaseURI != base {
+		t.Errorf("BaseURI = %q, want %q", got.BaseURI, base)
+	}
+	if want := sortedTriples(doc.Triples); !reflect.DeepEqual(sortedTriples(got.Triples), want) {
+		t.Errorf("decoded %d triples, want the %d encoded", len(got.Triples), len(want))
+	}
+	if len(got.Triples) != len(doc.Triples)-1 {
+		t.Errorf("decoded %d triples, want %d without the duplicate", len(got.Triples), len(doc.Triples)-1)
+	}
+	header := strings.Join(got.Header, "\n")
+	for _, want := range []string{doc.Header[0], `<http://rdfs.org/ns/void#triples> "82"`} {
+		if !strings.Contains(header, want) {
+			t.Errorf("header %q lacks %q", header, want)
+		}
+	}
+
+	empty, err := Decode(encode(t, &Document{BaseURI: base}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(empty.Triples) != 0 {
+		t.Errorf("empty document decoded with triples %v", empty.Triples)
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	data := encode(t, testDocument())
+	for n := 0; n < len(data); n++ {
+		if _, err := Decode(data[:n]); err == nil {
+			t.Fatalf("Decode of the first %d of %d bytes succeeded", n, len(data))
+		}
+	}
+}
+
+func TestDecodeCorrupt(t *testing.T) {
+	data := encode(t, testDocument())
+	// The header text is the only part no checksum covers.
+	headerStart := bytes.Index(data, []byte(base))
+	headerEnd := bytes.Index(data, []byte("$HDT\x03"))
+	for i := range data {
+		corrupt := bytes.Clone(data)
+		corrupt[i] ^= 0xff
+		_, err := Decode(corrupt)
+		if err == nil && (i < headerStart || i >= headerEnd) {
+			t.Errorf("Decode succeeded with byte %d of %d corrupted", i, len(data))
+		}
+	}
+}
+
+// TestDecodeInconsistent decodes files whose checksums are right but whose
+// triples may not fit their dictionary.
+func TestDecodeInconsistent(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		bitY, bitZ []bool
+		seqY, seqZ []uint64
+		ok         bool
+	}{
+		{"consistent", []bool{true}, []bool{true}, []uint64{1}, []uint64{1}, true},
+		{"object out of range", []bool{true}, []bool{true}, []uint64{1}, []uint64{9}, false},
+		{"predicate out of range", []bool{true}, []bool{true}, []uint64{5}, []uint64{1}, false},
+		{"subject out of range", []bool{true, true}, []bool{true, true}, []uint64{1, 1}, []uint64{1, 1}, false},
+		{"objects end early", []bool{true}, []bool{false}, []uint64{1}, []uint64{1}, false},
+		{"bitmap shorter than sequence", []bool{true}, []bool{true}, []uint64{1, 1}, []uint64{1}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &encoder{}
+			header := base + " " + rdfType + " " + hdtDataset + " .\n"
+			e.controlInfo(typeGlobal, formatHDT)
+			e.controlInfo(typeHeader, formatHeader, "length="+strconv.Itoa(len(header)))
+			e.buf = append(e.buf, header...)
+			e.controlInfo(typeDictionary, formatDictionary, "mapping=1")
+			e.pfcSection(nil)
+			e.pfcSection([]string{"http://example.org/s"})
+			e.pfcSection([]string{"http://example.org/p"})
+			e.pfcSection([]string{"http://example.org/o"})
+			e.controlInfo(typeTriples, formatTriples, "order="+orderSPO)
+			e.bitmap(tt.bitY)
+			e.bitmap(tt.bitZ)
+			e.logSequence(tt.seqY)
+			e.logSequence(tt.seqZ)
+			doc, err := Decode(e.buf)
+			if tt.ok && err != nil {
+				t.Errorf("Decode: %v", err)
+			} else if !tt.ok && err == nil {
+				t.Errorf("Decode = %+v, want an error", doc.Triples)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+// Package lru provides a size-bounded, least-recently-used cache that is
+// safe for concurrent use.
+package lru
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Stats reports how a cache has performed since it was created.
+type Stats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
+	Cost      int64  `json:"cost"`
+	MaxCost   int64  `json:"max_cost"`
+}
+
+// Cache holds values up to a total cost, evicting the least recently used
+// ones to make room. The cost of a value is chosen by the caller, usually
+// its approximate size in bytes. A nil *Cache is valid and caches nothing.
+type Cache[K comparable, V any] struct {
+	mu      sync.Mutex
+	maxCost int64
+	cost    int64
+	order   *list.List // Front is most recently used
+	items   map[K]*list.Element
+	stats   Stats
+}
+
+type entry[K comparable, V any] struct {
+	key   K
+	value V
+	cost  int64
+}
+
+// New returns a cache holding values up to maxCost in total, or nil if
+// maxCost is not positive.
+func New[K comparable, V any](maxCost int64) *Cache[K, V] {
+	if maxCost <= 0 {
+		return nil
+	}
+	return &Cache[K, V]{maxCost: maxCost, order: list.New(), items: make(map[K]*list.Element)}
+}
+
+// Get returns the value cached for key and marks it recently used.
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	var zero V
+	if c == nil {
+		return zero, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		c.stats.Misses++
+		return zero, false
+	}
+	c.stats.Hits++
+	c.order.MoveToFront(el)
+	return el.Value.(*entry[K, V]).value, true
+}
+
+// Add caches value under key. Values costing more than the whole cache are
+// not cached.
+func (c *Cache[K, V]) Add(key K, value V, cost int64) {
+	if c == nil || cost > c.maxCost {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*entry[K, V])
+		c.cost += cost - e.cost
+		e.value, e.cost = value, cost
+		c.order.MoveToFront(el)
+	} else {
+		c.items[key] = c.order.PushFront(&entry[K, V]{key, value, cost})
+		c.cost += cost
+	}
+	for c.cost > c.maxCost {
+		el := c.order.Back()
+		e := el.Value.(*entry[K, V])
+		c.order.Remove(el)
+		delete(c.items, e.key)
+		c.cost -= e.cost
+		c.stats.Evictions++
+	}
+}
+
+// Stats returns the cache's counters and current size.
+func (c *Cache[K, V]) Stats() Stats {
+	if c == nil {
+		return Stats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Entries, s.Cost, s.MaxCost = len(c.items), c.cost, c.maxCost
+	return s
+}
@@ -0,0 +1,99 @@
+package lru
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestCache(t *testing.T) {
+	type op struct {
+		add  string // Key to add, or "" to get
+		cost int64
+		get  string
+		hit  bool
+	}
+	for _, tt := range []struct {
+		name    string
+		maxCost int64
+		ops     []op
+		want    Stats
+	}{
+		{
+			name:    "hit and miss",
+			maxCost: 10,
+			ops:     []op{{add: "a", cost: 1}, {get: "a", hit: true}, {get: "b"}},
+			want:    Stats{Hits: 1, Misses: 1, Entries: 1, Cost: 1, MaxCost: 10},
+		},
+		{
+			name:    "evicts least recently used",
+			maxCost: 3,
+			ops: []op{
+				{add: "a", cost: 1}, {add: "b", cost: 1}, {add: "c", cost: 1},
+				{get: "a", hit: true}, // b is now the oldest
+				{add: "d", cost: 1},
+				{get: "b"}, {get: "a", hit: true}, {get: "c", hit: true}, {get: "d", hit: true},
+			},
+			want: Stats{Hits: 4, Misses: 1, Evictions: 1, Entries: 3, Cost: 3, MaxCost: 3},
+		},
+		{
+			name:    "evicts until the cost fits",
+			maxCost: 4,
+			ops:     []op{{add: "a", cost: 2}, {add: "b", cost: 2}, {add: "c", cost: 3}, {get: "a"}, {get: "b"}, {get: "c", hit: true}},
+			want:    Stats{Hits: 1, Misses: 2, Evictions: 2, Entries: 1, Cost: 3, MaxCost: 4},
+		},
+		{
+			name:    "replacing a value updates its cost",
+			maxCost: 4,
+			ops:     []op{{add: "a", cost: 1}, {add: "a", cost: 3}, {get: "a", hit: true}},
+			want:    Stats{Hits: 1, Entries: 1, Cost: 3, MaxCost: 4},
+		},
+		{
+			name:    "value costing more than the cache",
+			maxCost: 2,
+			ops:     []op{{add: "a", cost: 3}, {get: "a"}},
+			want:    Stats{Misses: 1, MaxCost: 2},
+		},
+		{
+			name:    "disabled",
+			maxCost: 0,
+			ops:     []op{{add: "a", cost: 1}, {get: "a"}},
+			want:    Stats{},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New[string, string](tt.maxCost)
+			for i, o := range tt.ops {
+				if o.add != "" {
+					c.Add(o.add, "value of "+o.add, o.cost)
+					continue
+				}
+				v, ok := c.Get(o.get)
+				if ok != o.hit || ok && v != "value of "+o.get {
+					t.Errorf("op %d: Get(%q) = %q, %v; want hit %v", i, o.get, v, ok, o.hit)
+				}
+			}
+			if got := c.Stats(); got != tt.want {
+				t.Errorf("Stats() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheConcurrent(t *testing.T) {
+	c := New[int, int](100)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				c.Add((g*1000+i)%150, i, 1)
+				c.Get(i % 150)
+			}
+		}(g)
+	}
+	wg.Wait()
+	if s := c.Stats(); s.Cost > s.MaxCost || s.Entries != int(s.Cost) {
+		t.Errorf("after concurrent use: %+v", s)
+	}
+}
@@ -0,0 +1,127 @@
+package rpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// A delta rebuilds a target object from a base object the receiver already
+// has. It starts with the base and target lengths as uvarints, followed by
+// instructions: opCopy offset length copies a range of the base, opInsert
+// length bytes appends literal data.
+const (
+	opInsert = 0
+	opCopy   = 1
+
+	// deltaBlock is the length of the base substrings indexed for matching.
+	// Shorter matches are sent as literal data.
+	deltaBlock = 16
+)
+
+var errCorruptDelta = errors.New("corrupt delta")
+
+// MakeDelta encodes target as a delta against base.
+func MakeDelta(base, target []byte) []byte {
+	index := make(map[string]int, len(base)/deltaBlock)
+	for i := 0; i+deltaBlock <= len(base); i += deltaBlock {
+		if _, ok := index[string(base[i:i+deltaBlock])]; !ok {
+			index[string(base[i:i+deltaBlock])] = i
+		}
+	}
+
+	out := binary.AppendUvarint(nil, uint64(len(base)))
+	out = binary.AppendUvarint(out, uint64(len(target)))
+	literal := 0 // start of target bytes not yet encoded
+	flush := func(end int) {
+		if end > literal {
+			out = append(out, opInsert)
+			out = binary.AppendUvarint(out, uint64(end-literal))
+			out = append(out, target[literal:end]...)
+		}
+	}
+	for i := 0; i+deltaBlock <= len(target); {
+		offset, ok := index[string(target[i:i+deltaBlock])]
+		if !ok {
+			i++
+			continue
+		}
+		// Extend the match backwards into pending literal data and forwards
+		// as far as base and target agree.
+		start := i
+		for start > literal && offset > 0 && base[offset-1] == target[start-1] {
+			start--
+			offset--
+		}
+		end := i + deltaBlock
+		for end < len(target) && offset+end-start < len(base) && base[offset+end-start] == target[end] {
+			end++
+		}
+		flush(start)
+		out = append(out, opCopy)
+		out = binary.AppendUvarint(out, uint64(offset))
+		out = binary.AppendUvarint(out, uint64(end-start))
+		literal, i = end, end
+	}
+	flush(len(target))
+	return out
+}
+
+// ApplyDelta rebuilds the target object from base and a delta made by
+// MakeDelta.
+func ApplyDelta(base, delta []byte) ([]byte, error) {
+	next := func() (int, error) {
+		v, n := binary.Uvarint(delta)
+		if n <= 0 || v > math.MaxInt32 {
+			return 0, errCorruptDelta
+		}
+		delta = delta[n:]
+		return int(v), nil
+	}
+	baseLen, err := next()
+	if err != nil {
+		return nil, err
+	}
+	if baseLen != len(base) {
+		return nil, fmt.Errorf("delta expects a %d-byte base, got %d bytes", baseLen, len(base))
+	}
+	targetLen, err := next()
+	if err != nil {
+		return nil, err
+	}
+	// The target length is untrusted, so it only bounds the initial capacity.
+	out := make([]byte, 0, min(targetLen, len(base)+len(delta)))
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		switch op {
+		case opInsert:
+			n, err := next()
+			if err != nil || n > len(delta) {
+				return nil, errCorruptDelta
+			}
+			out = append(out, delta[:n]...)
+			delta = delta[n:]
+		case opCopy:
+			offset, err := next()
+			if err != nil {
+				return nil, err
+			}
+			n, err := next()
+			if err != nil || offset+n > len(base) {
+				return nil, errCorruptDelta
+			}
+			out = append(out, base[offset:offset+n]...)
+		default:
+			return nil, errCorruptDelta
+		}
+		if len(out) > targetLen {
+			return nil, errCorruptDelta
+		}
+	}
+	if len(out) != targetLen {
+		return nil, errCorruptDelta
+	}
+	return out, nil
+}
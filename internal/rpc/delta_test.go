@@ -0,0 +1,107 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDeltaRoundTrip(t *testing.T) {
+	base := []byte(strings.Repeat("<http://example.org/s> <http://example.org/p> \"o\" .\n", 20))
+	tests := []struct {
+		name        string
+		target      []byte
+		smallerThan int // The delta must be shorter than this, if set
+	}{
+		{"identical", base, len(base) / 10},
+		{"appended", append(append([]byte(nil), base...), "<http://example.org/t> <http://example.org/p> \"new\" .\n"...), len(base) / 5},
+		{"prefixed", append([]byte("# header\n"), base...), len(base) / 5},
+		{"edited middle", bytes.Replace(base, []byte(`"o"`), []byte(`"changed"`), 1), len(base) / 5},
+		{"unrelated", []byte("nothing in common"), 0},
+		{"empty target", nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delta := MakeDelta(base, tt.target)
+			if tt.smallerThan > 0 && len(delta) >= tt.smallerThan {
+				t.Errorf("delta is %d bytes, want fewer than %d", len(delta), tt.smallerThan)
+			}
+			got, err := ApplyDelta(base, delta)
+			if err != nil {
+				t.Fatalf("ApplyDelta: %v", err)
+			}
+			if !bytes.Equal(got, tt.target) {
+				t.Errorf("ApplyDelta = %q, want %q", got, tt.target)
+			}
+		})
+	}
+}
+
+func TestApplyCorruptDelta(t *testing.T) {
+	base := []byte("0123456789abcdef0123456789abcdef")
+	header := func(baseLen, targetLen uint64) []byte {
+		return binary.AppendUvarint(binary.AppendUvarint(nil, baseLen), targetLen)
+	}
+	tests := []struct {
+		name  string
+		delta []byte
+	}{
+		{"empty", nil},
+		{"wrong base length", header(5, 0)},
+		{"copy past the base", append(header(32, 8), opCopy, 30, 8)},
+		{"insert past the delta", append(header(32, 8), opInsert, 8, 'x')},
+		{"unknown op", append(header(32, 1), 7)},
+		{"longer than declared", append(header(32, 1), opInsert, 2, 'x', 'y')},
+		{"shorter than declared", append(header(32, 3), opInsert, 1, 'x')},
+		{"huge target length", append(header(32, 1<<40), opCopy, 0, 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := ApplyDelta(base, tt.delta); err == nil {
+				t.Errorf("ApplyDelta accepted a corrupt delta, giving %q", got)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	have := object(strings.Repeat("a statement the receiver already has\n", 4))
+	next := object(string(have.Data) + "and one more\n")
+	last := object(string(next.Data) + "and another\n")
+	lookup := func(hash string) ([]byte, error) {
+		if hash == have.Hash {
+			return have.Data, nil
+		}
+		return nil, fmt.Errorf("object %s not found", hash)
+	}
+
+	// A thin pack: next is a delta against an object only the receiver
+	// has, and last a delta against next.
+	pack := Packfile{Objects: []Object{
+		{Hash: last.Hash, Base: next.Hash, Data: MakeDelta(next.Data, last.Data)},
+		{Hash: next.Hash, Base: have.Hash, Data: MakeDelta(have.Data, next.Data)},
+	}}
+	if err := pack.Resolve(lookup); err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if err := pack.Verify(); err != nil {
+		t.Errorf("resolved pack does not verify: %v", err)
+	}
+
+	missing := Packfile{Objects: []Object{{Hash: next.Hash, Base: object("gone").Hash, Data: MakeDelta(have.Data, next.Data)}}}
+	if err := missing.Resolve(lookup); err == nil {
+		t.Error("Resolve accepted a delta against a missing base")
+	}
+	cycle := Packfile{Objects: []Object{
+		{Hash: next.Hash, Base: last.Hash, Data: MakeDelta(last.Data, next.Data)},
+		{Hash: last.Hash, Base: next.Hash, Data: MakeDelta(next.Data, last.Data)},
+	}}
+	if err := cycle.Resolve(lookup); err == nil {
+		t.Error("Resolve accepted a cycle of deltas")
+	}
+	if err := (&Packfile{Objects: []Object{{Hash: next.Hash, Base: have.Hash, Data: []byte("x")}}}).Verify(); err == nil {
+		t.Error("Verify accepted an unresolved delta")
+	}
+}
@@ -1 +1,342 @@
--e 
+package rpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrStaleRef is returned by Repository.UpdateRefs when a ref no longer
+// points at the Old hash the client expected.
+var ErrStaleRef = errors.New("ref has moved since it was advertised")
+
+// ErrNotFastForward is returned by Handler.Receive when an update would
+// discard commits the ref currently points to.
+var ErrNotFastForward = errors.New("update is not a fast-forward")
+
+// ErrInvalidPush is returned by Handler.Receive for a malformed push, such as
+// one whose objects do not match their hashes.
+var ErrInvalidPush = errors.New("invalid push")
+
+// ErrForbidden is returned by Handler.Receive when Handler.Authorize rejects
+// a ref update.
+var ErrForbidden = errors.New("push not authorized")
+
+// RefUpdate requests that Ref move from Old to New. An empty Old means the
+// ref must not exist yet.
+type RefUpdate struct {
+	Ref string `json:"ref"`
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// PushCertificate is the pusher's signed statement of the ref updates they
+// requested, kept by the server as proof of who pushed what.
+type PushCertificate struct {
+	Pusher    string      `json:"pusher"`
+	Pushee    string      `json:"pushee"` // The URL the client pushed to.
+	Timestamp time.Time   `json:"timestamp"`
+	Updates   []RefUpdate `json:"updates"`
+	Signature string      `json:"signature,omitempty"`
+}
+
+// Payload returns the bytes covered by the certificate signature.
+func (c PushCertificate) Payload() ([]byte, error) {
+	c.Signature = ""
+	return json.Marshal(c)
+}
+
+// Advertisement lists the refs a repository currently holds.
+type Advertisement struct {
+	Refs map[string]string `json:"refs"`
+}
+
+// PushRequest carries the objects and ref updates of a single push.
+type PushRequest struct {
+	Updates     []RefUpdate      `json:"updates"`
+	Pack        Packfile         `json:"pack"`
+	Certificate *PushCertificate `json:"certificate,omitempty"`
+}
+
+// FetchRequest asks for the objects reachable from Wants, omitting those
+// reachable from Haves, which the client already holds. With Filter
+// FilterBlobNone, graph blobs are left out, as for a partial clone; such a
+// client later asks for the blobs it needs by hash in Objects, which are
+// sent as they are.
+type FetchRequest struct {
+	Wants   []string `json:"wants"`
+	Haves   []string `json:"haves,omitempty"`
+	Filter  string   `json:"filter,omitempty"`
+	Objects []string `json:"objects,omitempty"`
+}
+
+// FilterBlobNone is the FetchRequest filter that omits every blob.
+const FilterBlobNone = "blob:none"
+
+// AuditEntry records one accepted push.
+type AuditEntry struct {
+	Timestamp  time.Time `json:"timestamp"`
+	RemoteAddr string    `json:"remote_addr"`
+	// Pusher is the identity the transport authenticated, or empty.
+	Pusher      string           `json:"pusher,omitempty"`
+	Updates     []RefUpdate      `json:"updates"`
+	Certificate *PushCertificate `json:"certificate,omitempty"`
+	// CertificateStatus is "verified", "unverified", or the reason
+	// verification failed. It is empty when no certificate was sent.
+	CertificateStatus string `json:"certificate_status,omitempty"`
+}
+
+// Repository is the storage a server needs to answer protocol requests.
+type Repository interface {
+	// ListRefs returns every ref name and the hash it points to.
+	ListRefs() (map[string]string, error)
+	// ReadObject returns the stored encoding of an object, used as the base
+	// of deltas a client sends.
+	ReadObject(hash string) ([]byte, error)
+	// StageObjects makes objects received from a client readable, as if
+	// stored, until release is called, so that a push can be checked
+	// before anything of it is written.
+	StageObjects(objects []Object) (release func())
+	// WriteObject stores an object received from a client.
+	WriteObject(obj Object) error
+	// CollectObjects returns the commits reachable from wants but not from
+	// any of haves, with the trees and blobs they reference; with
+	// omitBlobs, only the trees. Haves the repository does not know are
+	// ignored.
+	CollectObjects(wants, haves []string, omitBlobs bool) ([]Object, error)
+	// IsAncestor reports whether ancestor is reachable from descendant.
+	IsAncestor(ancestor, descendant string) (bool, error)
+	// UpdateRefs applies all updates atomically, returning ErrStaleRef if
+	// any ref does not currently point at its update's Old hash.
+	UpdateRefs(updates []RefUpdate) error
+	// AppendAudit adds an entry to the repository's audit log.
+	AppendAudit(entry AuditEntry) error
+	// ReadAudit returns the audit log, oldest entry first.
+	ReadAudit() ([]AuditEntry, error)
+}
+
+// Handler serves the synchronization protocol over HTTP.
+type Handler struct {
+	Repo Repository
+	// VerifySignature checks a detached signature over payload and
+	// returns who made it, such as the email address of a PGP key. If nil,
+	// push certificates are stored but marked unverified.
+	VerifySignature func(payload []byte, signature string) (signer string, err error)
+	// URLs are the URLs clients push to this server by. A certificate is
+	// only verified if its signer is its pusher and its pushee is one of
+	// these, so that one made for a push elsewhere cannot be replayed here.
+	URLs []string
+	// Identify, if set, returns the identity the server authenticated an
+	// HTTP request as, or "".
+	Identify func(r *http.Request) string
+	// Authorize, if set, is asked about each ref update of a push once its
+	// objects are staged but before any is stored, and rejects the whole
+	// push by returning an error. identity is the pusher the transport
+	// authenticated, or empty; a push certificate names a pusher but is
+	// only a record, since anyone can sign one with a key of their own.
+	Authorize func(identity string, update RefUpdate) error
+	// CheckPack, if set, inspects the resolved objects of a push before
+	// any is stored, and rejects the push as invalid by returning an error.
+	CheckPack func(objects []Object) error
+
+	// mu serializes pushes, so that the objects staged for one are not
+	// taken for stored by another.
+	mu sync.Mutex
+}
+
+// Register installs the protocol endpoints on mux.
+func (h *Handler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /refs", h.handleRefs)
+	mux.HandleFunc("POST /fetch", h.handleFetch)
+	mux.HandleFunc("POST /push", h.handlePush)
+	mux.HandleFunc("GET /audit", h.handleAudit)
+}
+
+func (h *Handler) handleRefs(w http.ResponseWriter, r *http.Request) {
+	refs, err := h.Repo.ListRefs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, Advertisement{Refs: refs})
+}
+
+func (h *Handler) handleFetch(w http.ResponseWriter, r *http.Request) {
+	var req FetchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "malformed fetch request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	pack, err := h.Fetch(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, pack)
+}
+
+// Fetch answers a fetch request. It is shared by every transport that
+// serves fetches.
+func (h *Handler) Fetch(req FetchRequest) (*Packfile, error) {
+	if req.Filter != "" && req.Filter != FilterBlobNone {
+		return nil, fmt.Errorf("unsupported filter %q", req.Filter)
+	}
+	var pack Packfile
+	for _, hash := range req.Objects {
+		data, err := h.Repo.ReadObject(hash)
+		if err != nil {
+			return nil, err
+		}
+		pack.Objects = append(pack.Objects, Object{Hash: hash, Data: data})
+	}
+	if len(req.Wants) > 0 {
+		objects, err := h.Repo.CollectObjects(req.Wants, req.Haves, req.Filter == FilterBlobNone)
+		if err != nil {
+			return nil, err
+		}
+		pack.Objects = append(pack.Objects, objects...)
+	}
+	return &pack, nil
+}
+
+func (h *Handler) handlePush(w http.ResponseWriter, r *http.Request) {
+	var req PushRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "malformed push request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	identity := ""
+	if h.Identify != nil {
+		identity = h.Identify(r)
+	}
+	entry, err := h.Receive(req, identity, r.RemoteAddr)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, ErrInvalidPush):
+			status = http.StatusBadRequest
+		case errors.Is(err, ErrStaleRef), errors.Is(err, ErrNotFastForward):
+			status = http.StatusConflict
+		case errors.Is(err, ErrForbidden):
+			status = http.StatusForbidden
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	writeJSON(w, entry)
+}
+
+// Receive checks a push from identity, as authenticated by the transport,
+// then stores its objects, applies its ref updates and records it in the
+// audit log. Nothing is stored unless every check passes. It is shared by
+// every transport that accepts pushes.
+func (h *Handler) Receive(req PushRequest, identity, remoteAddr string) (*AuditEntry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := req.Pack.Resolve(h.Repo.ReadObject); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPush, err)
+	}
+	if err := req.Pack.Verify(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPush, err)
+	}
+	if h.CheckPack != nil {
+		if err := h.CheckPack(req.Pack.Objects); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPush, err)
+		}
+	}
+
+	entry := AuditEntry{Timestamp: time.Now(), RemoteAddr: remoteAddr, Pusher: identity, Updates: req.Updates, Certificate: req.Certificate}
+	if req.Certificate != nil {
+		if !reflect.DeepEqual(req.Certificate.Updates, req.Updates) {
+			return nil, fmt.Errorf("%w: push certificate does not match the requested ref updates", ErrInvalidPush)
+		}
+		entry.CertificateStatus = h.verifyCertificate(req.Certificate)
+	}
+
+	if err := h.check(req, identity); err != nil {
+		return nil, err
+	}
+	for _, obj := range req.Pack.Objects {
+		if err := h.Repo.WriteObject(obj); err != nil {
+			return nil, err
+		}
+	}
+	if err := h.Repo.UpdateRefs(req.Updates); err != nil {
+		return nil, err
+	}
+	if err := h.Repo.AppendAudit(entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// check reports whether every update of a push is a fast-forward that
+// identity may make, reading the pushed objects from where they are
+// staged.
+func (h *Handler) check(req PushRequest, identity string) error {
+	release := h.Repo.StageObjects(req.Pack.Objects)
+	defer release()
+	for _, u := range req.Updates {
+		if u.Old == "" || u.New == "" {
+			continue
+		}
+		ok, err := h.Repo.IsAncestor(u.Old, u.New)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrNotFastForward, u.Ref)
+		}
+	}
+	if h.Authorize != nil {
+		for _, u := range req.Updates {
+			if err := h.Authorize(identity, u); err != nil {
+				return fmt.Errorf("%w: %v", ErrForbidden, err)
+			}
+		}
+	}
+	return nil
+}
+
+// verifyCertificate returns the audit status of a push certificate.
+func (h *Handler) verifyCertificate(cert *PushCertificate) string {
+	if h.VerifySignature == nil || cert.Signature == "" {
+		return "unverified"
+	}
+	payload, err := cert.Payload()
+	if err != nil {
+		return err.Error()
+	}
+	signer, err := h.VerifySignature(payload, cert.Signature)
+	if err != nil {
+		return err.Error()
+	}
+	if !strings.EqualFold(signer, cert.Pusher) {
+		return fmt.Sprintf("signed by %s, not the pusher %s", signer, cert.Pusher)
+	}
+	for _, url := range h.URLs {
+		if strings.TrimSuffix(url, "/") == strings.TrimSuffix(cert.Pushee, "/") {
+			return "verified"
+		}
+	}
+	return fmt.Sprintf("made for a push to %s, not to this server", cert.Pushee)
+}
+
+func (h *Handler) handleAudit(w http.ResponseWriter, r *http.Request) {
+	entries, err := h.Repo.ReadAudit()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, entries)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
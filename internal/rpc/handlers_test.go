@@ -0,0 +1,329 @@
+package rpc
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// memRepo is a Repository in memory. Its objects are fake commits whose
+// data is "parent <hash>" or "root".
+type memRepo struct {
+	objects map[string][]byte
+	staged  map[string][]byte
+	refs    map[string]string
+	audit   []AuditEntry
+	omitted []bool // The omitBlobs of each CollectObjects call
+}
+
+func newMemRepo() *memRepo {
+	return &memRepo{objects: map[string][]byte{}, staged: map[string][]byte{}, refs: map[string]string{}}
+}
+
+func (m *memRepo) ListRefs() (map[string]string, error) { return m.refs, nil }
+
+func (m *memRepo) ReadObject(hash string) ([]byte, error) {
+	if data, ok := m.staged[hash]; ok {
+		return data, nil
+	}
+	if data, ok := m.objects[hash]; ok {
+		return data, nil
+	}
+	return nil, fmt.Errorf("object %s not found", hash)
+}
+
+func (m *memRepo) StageObjects(objects []Object) func() {
+	for _, obj := range objects {
+		m.staged[obj.Hash] = obj.Data
+	}
+	return func() {
+		for _, obj := range objects {
+			delete(m.staged, obj.Hash)
+		}
+	}
+}
+
+func (m *memRepo) WriteObject(obj Object) error {
+	m.objects[obj.Hash] = obj.Data
+	return nil
+}
+
+func (m *memRepo) CollectObjects(wants, haves []string, omitBlobs bool) ([]Object, error) {
+	m.omitted = append(m.omitted, omitBlobs)
+	return nil, nil
+}
+
+func (m *memRepo) IsAncestor(ancestor, descendant string) (bool, error) {
+	for hash := descendant; ; {
+		if hash == ancestor {
+			return true, nil
+		}
+		data, err := m.ReadObject(hash)
+		if err != nil {
+			return false, err
+		}
+		parent, ok := strings.CutPrefix(string(data), "parent ")
+		if !ok {
+			return false, nil
+		}
+		hash = parent
+	}
+}
+
+func (m *memRepo) UpdateRefs(updates []RefUpdate) error {
+	for _, u := range updates {
+		if m.refs[u.Ref] != u.Old {
+			return ErrStaleRef
+		}
+		m.refs[u.Ref] = u.New
+	}
+	return nil
+}
+
+func (m *memRepo) AppendAudit(entry AuditEntry) error {
+	m.audit = append(m.audit, entry)
+	return nil
+}
+
+func (m *memRepo) ReadAudit() ([]AuditEntry, error) { return m.audit, nil }
+
+func object(data string) Object {
+	sum := sha1.Sum([]byte(data))
+	return Object{Hash: hex.EncodeToString(sum[:]), Data: []byte(data)}
+}
+
+func TestReceive(t *testing.T) {
+	root := object("root")
+	child := object("parent " + root.Hash)
+	other := object("root, again")
+
+	allowOnly := func(name string) func(string, RefUpdate) error {
+		return func(identity string, u RefUpdate) error {
+			if identity != name {
+				return fmt.Errorf("%q may not push", identity)
+			}
+			return nil
+		}
+	}
+	for _, tt := range []struct {
+		name      string
+		identity  string
+		objects   []Object
+		update    RefUpdate
+		cert      *PushCertificate
+		authorize func(string, RefUpdate) error
+		wantErr   error
+	}{
+		{
+			name:      "fast-forward",
+			identity:  "alice",
+			objects:   []Object{child},
+			update:    RefUpdate{Ref: "head:main", Old: root.Hash, New: child.Hash},
+			authorize: allowOnly("alice"),
+		},
+		{
+			name:      "new branch",
+			identity:  "alice",
+			objects:   []Object{other},
+			update:    RefUpdate{Ref: "head:topic", New: other.Hash},
+			authorize: allowOnly("alice"),
+		},
+		{
+			name:      "rejected",
+			identity:  "bob",
+			objects:   []Object{child},
+			update:    RefUpdate{Ref: "head:main", Old: root.Hash, New: child.Hash},
+			authorize: allowOnly("alice"),
+			wantErr:   ErrForbidden,
+		},
+		{
+			name:      "certificate does not grant identity",
+			identity:  "bob",
+			objects:   []Object{child},
+			update:    RefUpdate{Ref: "head:main", Old: root.Hash, New: child.Hash},
+			cert:      &PushCertificate{Pusher: "alice", Updates: []RefUpdate{{Ref: "head:main", Old: root.Hash, New: child.Hash}}},
+			authorize: allowOnly("alice"),
+			wantErr:   ErrForbidden,
+		},
+		{
+			name:     "not a fast-forward",
+			identity: "alice",
+			objects:  []Object{other},
+			update:   RefUpdate{Ref: "head:main", Old: root.Hash, New: other.Hash},
+			wantErr:  ErrNotFastForward,
+		},
+		{
+			name:     "object not matching its hash",
+			identity: "alice",
+			objects:  []Object{{Hash: child.Hash, Data: []byte("forged")}},
+			update:   RefUpdate{Ref: "head:main", Old: root.Hash, New: child.Hash},
+			wantErr:  ErrInvalidPush,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newMemRepo()
+			repo.objects[root.Hash] = root.Data
+			repo.refs["head:main"] = root.Hash
+			var authorized []string
+			h := &Handler{Repo: repo}
+			if tt.authorize != nil {
+				h.Authorize = func(identity string, u RefUpdate) error {
+					// The pushed objects must be readable, but not stored.
+					if _, err := repo.ReadObject(u.New); err != nil {
+						t.Errorf("authorizing: %v", err)
+					}
+					if _, ok := repo.objects[u.New]; ok {
+						t.Errorf("%s stored before the push was authorized", u.New)
+					}
+					authorized = append(authorized, identity)
+					return tt.authorize(identity, u)
+				}
+			}
+
+			req := PushRequest{Updates: []RefUpdate{tt.update}, Pack: Packfile{Objects: tt.objects}, Certificate: tt.cert}
+			entry, err := h.Receive(req, tt.identity, "test")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Receive: %v, want %v", err, tt.wantErr)
+			}
+			if tt.authorize != nil && tt.wantErr != ErrNotFastForward && tt.wantErr != ErrInvalidPush {
+				if len(authorized) != 1 || authorized[0] != tt.identity {
+					t.Errorf("authorized as %q, want %q", authorized, tt.identity)
+				}
+			}
+			if len(repo.staged) > 0 {
+				t.Errorf("%d object(s) still staged", len(repo.staged))
+			}
+			_, stored := repo.objects[tt.update.New]
+			if tt.wantErr != nil {
+				if stored || repo.refs[tt.update.Ref] == tt.update.New || len(repo.audit) > 0 {
+					t.Errorf("rejected push left objects, refs or audit entries behind")
+				}
+				return
+			}
+			if !stored || repo.refs[tt.update.Ref] != tt.update.New {
+				t.Errorf("accepted push not applied: stored %v, %s = %s", stored, tt.update.Ref, repo.refs[tt.update.Ref])
+			}
+			if entry.Pusher != tt.identity || len(repo.audit) != 1 {
+				t.Errorf("audit entry pusher %q (%d entries), want %q", entry.Pusher, len(repo.audit), tt.identity)
+			}
+		})
+	}
+}
+
+func TestHandlePushIdentity(t *testing.T) {
+	root := object("root")
+	for _, tt := range []struct {
+		name     string
+		identify func(*http.Request) string
+		want     string
+	}{
+		{"authenticated", func(r *http.Request) string { return r.Header.Get("X-Test-User") }, "alice"},
+		{"no authentication", nil, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newMemRepo()
+			var got string
+			h := &Handler{Repo: repo, Identify: tt.identify, Authorize: func(identity string, u RefUpdate) error {
+				got = identity
+				return nil
+			}}
+			mux := http.NewServeMux()
+			h.Register(mux)
+
+			body, err := json.Marshal(PushRequest{
+				Updates:     []RefUpdate{{Ref: "head:main", New: root.Hash}},
+				Pack:        Packfile{Objects: []Object{root}},
+				Certificate: &PushCertificate{Pusher: "mallory", Updates: []RefUpdate{{Ref: "head:main", New: root.Hash}}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest("POST", "/push", bytes.NewReader(body))
+			req.Header.Set("X-Test-User", "alice")
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("push: %d %s", rec.Code, rec.Body)
+			}
+			if got != tt.want {
+				t.Errorf("authorized as %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetch(t *testing.T) {
+	blob := object("root")
+	for _, tt := range []struct {
+		name    string
+		req     FetchRequest
+		omitted []bool
+		sent    int
+		wantErr bool
+	}{
+		{name: "full", req: FetchRequest{Wants: []string{blob.Hash}}, omitted: []bool{false}},
+		{name: "blob:none", req: FetchRequest{Wants: []string{blob.Hash}, Filter: FilterBlobNone}, omitted: []bool{true}},
+		{name: "unsupported filter", req: FetchRequest{Wants: []string{blob.Hash}, Filter: "tree:0"}, wantErr: true},
+		{name: "objects by hash", req: FetchRequest{Objects: []string{blob.Hash}}, sent: 1},
+		{name: "unknown object", req: FetchRequest{Objects: []string{object("missing").Hash}}, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newMemRepo()
+			repo.objects[blob.Hash] = blob.Data
+			h := &Handler{Repo: repo}
+			pack, err := h.Fetch(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Fetch: %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if fmt.Sprint(repo.omitted) != fmt.Sprint(tt.omitted) {
+				t.Errorf("collected with omitBlobs %v, want %v", repo.omitted, tt.omitted)
+			}
+			if len(pack.Objects) != tt.sent {
+				t.Errorf("sent %d objects, want %d", len(pack.Objects), tt.sent)
+			}
+			if err := pack.Verify(); err != nil {
+				t.Errorf("pack does not verify: %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyCertificate(t *testing.T) {
+	const url = "https://data.example.org/repo"
+	verify := func(payload []byte, signature string) (string, error) {
+		if signature != "good" {
+			return "", errors.New("bad signature")
+		}
+		return "alice@example.org", nil
+	}
+	for _, tt := range []struct {
+		name   string
+		verify func([]byte, string) (string, error)
+		cert   PushCertificate
+		want   string
+	}{
+		{"verified", verify, PushCertificate{Pusher: "Alice@example.org", Pushee: url + "/", Signature: "good"}, "verified"},
+		{"no verifier", nil, PushCertificate{Pusher: "alice@example.org", Pushee: url, Signature: "good"}, "unverified"},
+		{"unsigned", verify, PushCertificate{Pusher: "alice@example.org", Pushee: url}, "unverified"},
+		{"bad signature", verify, PushCertificate{Pusher: "alice@example.org", Pushee: url, Signature: "forged"}, "bad signature"},
+		{"claims another pusher", verify, PushCertificate{Pusher: "bob@example.org", Pushee: url, Signature: "good"}, "signed by alice@example.org, not the pusher bob@example.org"},
+		{"replayed from another server", verify, PushCertificate{Pusher: "alice@example.org", Pushee: "https://elsewhere.example.org/repo", Signature: "good"}, "made for a push to https://elsewhere.example.org/repo, not to this server"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Handler{Repo: newMemRepo(), VerifySignature: tt.verify, URLs: []string{url}}
+			if got := h.verifyCertificate(&tt.cert); got != tt.want {
+				t.Errorf("status %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
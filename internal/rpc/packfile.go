@@ -1 +1,84 @@
--e 
+// Package rpc implements the Git-style synchronization protocol used by
+// push and pull. Objects travel in a Packfile; ref changes are described by
+// RefUpdates and may be vouched for by a signed PushCertificate.
+package rpc
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+)
+
+// Object is a single content-addressed object in transit. Data is the exact
+// stored encoding, so its SHA-1 must equal Hash, unless Base is set: then
+// Data is a delta (see MakeDelta) against the object named by Base, which is
+// either earlier or later in the same pack or already held by the receiver.
+type Object struct {
+	Hash string `json:"hash"`
+	Base string `json:"base,omitempty"`
+	Data []byte `json:"data"`
+}
+
+// Packfile is the set of objects transferred by one push or fetch.
+type Packfile struct {
+	Objects []Object `json:"objects"`
+}
+
+// Resolve replaces every delta in the pack with the object it encodes.
+// Bases missing from the pack, as in a thin pack, are read with lookup.
+func (p *Packfile) Resolve(lookup func(hash string) ([]byte, error)) error {
+	index := make(map[string]int, len(p.Objects))
+	for i, obj := range p.Objects {
+		index[obj.Hash] = i
+	}
+	resolving := make(map[string]bool)
+	var resolve func(i int) error
+	resolve = func(i int) error {
+		obj := &p.Objects[i]
+		if obj.Base == "" {
+			return nil
+		}
+		if resolving[obj.Hash] {
+			return fmt.Errorf("delta chain of %s refers back to itself", obj.Hash)
+		}
+		resolving[obj.Hash] = true
+		var base []byte
+		if j, ok := index[obj.Base]; ok {
+			if err := resolve(j); err != nil {
+				return err
+			}
+			base = p.Objects[j].Data
+		} else {
+			var err error
+			if base, err = lookup(obj.Base); err != nil {
+				return fmt.Errorf("delta base %s of %s: %v", obj.Base, obj.Hash, err)
+			}
+		}
+		data, err := ApplyDelta(base, obj.Data)
+		if err != nil {
+			return fmt.Errorf("object %s: %v", obj.Hash, err)
+		}
+		obj.Data, obj.Base = data, ""
+		return nil
+	}
+	for i := range p.Objects {
+		if err := resolve(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Verify checks that every object's content hashes to its declared name.
+func (p *Packfile) Verify() error {
+	for _, obj := range p.Objects {
+		if obj.Base != "" {
+			return fmt.Errorf("object %s is an unresolved delta", obj.Hash)
+		}
+		sum := sha1.Sum(obj.Data)
+		if hex.EncodeToString(sum[:]) != obj.Hash {
+			return fmt.Errorf("object %s does not match its content", obj.Hash)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+// Package sparql parses and evaluates a subset of SPARQL 1.1 queries over
+// any Source of quads: SELECT, CONSTRUCT and ASK, with basic graph
+// patterns, GRAPH, OPTIONAL, UNION, FILTER and BIND, grouping and
+// aggregates, and solution modifiers.
+//
+// Terms are kept in their N-Quads surface form, as in package rdfio.
+// Variables are written "?name" in patterns. Patterns outside a GRAPH
+// block match the union of all graphs.
+package sparql
+
+// Query is a parsed query.
+type Query struct {
+	Form     string // "SELECT", "CONSTRUCT" or "ASK"
+	Distinct bool
+
+	// Projection lists the SELECT clause; empty means SELECT *.
+	Projection []Projection
+	// Template is the CONSTRUCT template.
+	Template []TriplePattern
+
+	Where   *Group
+	GroupBy []string // Variable names, without '?'
+	OrderBy []OrderKey
+	Limit   int // -1: none
+	Offset  int
+}
+
+// A Projection is a variable of the SELECT clause, computed by Expr if it
+// is not nil.
+type Projection struct {
+	Var  string
+	Expr Expr
+}
+
+// An OrderKey is one ORDER BY condition.
+type OrderKey struct {
+	Expr       Expr
+	Descending bool
+}
+
+// A TriplePattern matches quads. Each position holds a term or a variable;
+// Graph is empty outside GRAPH blocks, matching any graph.
+type TriplePattern struct {
+	Subject, Predicate, Object, Graph string
+}
+
+// A Group is a group graph pattern: its elements are joined in order,
+// except that filters apply to the group as a whole.
+type Group struct {
+	Elements []Element
+	Filters  []Expr
+}
+
+// An Element is one part of a group: a *BGP, *Optional, *Union, *Graph,
+// *Service, *Bind or a nested *Group.
+type Element interface{ element() }
+
+// A BGP is a block of triple patterns, joined in the order the planner
+// chooses.
+type BGP struct {
+	Patterns []TriplePattern
+}
+
+// Optional left-joins its group to the solutions so far.
+type Optional struct {
+	Group *Group
+}
+
+// Union concatenates the solutions of its alternatives.
+type Union struct {
+	Alternatives []*Group
+}
+
+// Graph evaluates its group against the named graph Name, a term or a
+// variable that ranges over the named graphs.
+type Graph struct {
+	Name  string
+	Group *Group
+}
+
+// Service evaluates its group at the remote SPARQL endpoint Endpoint, an
+// IRI term, and joins the answers to the solutions so far. With Silent, an
+// endpoint that fails contributes nothing instead of failing the query.
+type Service struct {
+	Endpoint string
+	Silent   bool
+	Group    *Group
+}
+
+// Bind extends each solution with the value of an expression.
+type Bind struct {
+	Expr Expr
+	Var  string
+}
+
+func (*BGP) element()      {}
+func (*Optional) element() {}
+func (*Union) element()    {}
+func (*Graph) element()    {}
+func (*Service) element()  {}
+func (*Bind) element()     {}
+func (*Group) element()    {}
+
+// IsVar reports whether a pattern position holds a variable.
+func IsVar(term string) bool {
+	return len(term) > 1 && term[0] == '?'
+}
@@ -0,0 +1,703 @@
+package sparql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+)
+
+// A Source supplies the quads a query runs over.
+type Source interface {
+	// Match calls fn for each quad that matches s, p, o and graph, where
+	// an empty position matches anything. A graph of "" matches every
+	// graph, including the default graph, whose quads have an empty
+	// Graph. An error from fn stops the scan and is returned.
+	Match(ctx context.Context, s, p, o, graph string, fn func(q quadstore.Quad) error) error
+
+	// Estimate describes how Match would find the quads matching a
+	// pattern, and about how many there are. A position holding Bound is
+	// bound at run time to a value not yet known.
+	Estimate(s, p, o, graph string) Access
+}
+
+// Bound stands for a pattern position bound at run time, in Estimate.
+const Bound = "?"
+
+// Access describes how a Source reads the quads matching a pattern.
+type Access struct {
+	// Method names the access path, e.g. the index used or "scan".
+	Method string `json:"method"`
+	// Rows estimates how many quads match.
+	Rows float64 `json:"rows"`
+}
+
+// Options adjust evaluation.
+type Options struct {
+	// Functions are extension functions, keyed by IRI term.
+	Functions map[string]Function
+	// Bindings fix variables before evaluation, e.g. a saved query's
+	// parameters.
+	Bindings Binding
+	// Service answers SERVICE clauses; without it they fail.
+	Service ServiceFunc
+	// Limits bound the evaluation's resources. A context deadline is
+	// reported like an expired Limits.Timeout, as a *LimitError.
+	Limits Limits
+}
+
+// A ServiceFunc runs a SELECT query at a SERVICE endpoint, an IRI term,
+// and returns its solutions.
+type ServiceFunc func(ctx context.Context, endpoint, query string) ([]Binding, error)
+
+// Result holds the answer to a query.
+type Result struct {
+	Form string
+	// Vars and Solutions answer a SELECT; unbound variables are absent
+	// from a solution.
+	Vars      []string
+	Solutions []Binding
+	// Quads answer a CONSTRUCT.
+	Quads []quadstore.Quad
+	// Boolean answers an ASK.
+	Boolean bool
+}
+
+// Evaluate runs a query against src, stopping with a *LimitError if it
+// exceeds opts.Limits.
+func Evaluate(ctx context.Context, q *Query, src Source, opts Options) (*Result, error) {
+	if err := prepare(q, opts); err != nil {
+		return nil, err
+	}
+	if opts.Limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Limits.Timeout)
+		defer cancel()
+	}
+	e := &evaluator{ctx: ctx, src: src, service: opts.Service, usage: &usage{limits: opts.Limits, start: time.Now()}}
+	initial, bound := opts.Bindings.extend("", ""), map[string]bool{}
+	for v := range initial {
+		bound[v] = true
+	}
+	sols, err := e.group(q.Where, []Binding{initial}, bound)
+	if err == nil {
+		err = e.usage.check(ctx)
+	}
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = e.usage.exceeded("timeout")
+		}
+		return nil, err
+	}
+	res := &Result{Form: q.Form}
+	switch q.Form {
+	case "ASK":
+		res.Boolean = len(sols) > 0
+		return res, nil
+	case "CONSTRUCT":
+		sols = slice(order(sols, q.OrderBy), q.Offset, q.Limit)
+		res.Quads = construct(q.Template, sols)
+		return res, nil
+	}
+	if aggregated(q) {
+		if sols, err = group(q, sols); err != nil {
+			return nil, err
+		}
+	}
+	for _, proj := range q.Projection {
+		if proj.Expr == nil {
+			continue
+		}
+		for i, s := range sols {
+			if v, err := proj.Expr.Eval(s); err == nil {
+				sols[i] = s.extend(proj.Var, v)
+			}
+		}
+	}
+	sols = order(sols, q.OrderBy)
+	res.Vars = projectedVars(q)
+	sols = project(sols, res.Vars)
+	if q.Distinct {
+		sols = distinct(sols, res.Vars)
+	}
+	res.Solutions = slice(sols, q.Offset, q.Limit)
+	return res, nil
+}
+
+// prepare resolves extension functions and names the aggregates.
+func prepare(q *Query, opts Options) error {
+	var err error
+	n := 0
+	visit := func(e Expr) {
+		switch e := e.(type) {
+		case *callExpr:
+			if strings.HasPrefix(e.name, "<") && e.fn == nil {
+				if e.fn = opts.Functions[e.name]; e.fn == nil && err == nil {
+					err = fmt.Errorf("unknown function %s", e.name)
+				}
+			}
+		case *aggregateExpr:
+			e.key = fmt.Sprintf(" agg%d", n) // Not a valid variable name
+			n++
+		}
+	}
+	for _, proj := range q.Projection {
+		if proj.Expr != nil {
+			walkExpr(proj.Expr, visit)
+		}
+	}
+	for _, key := range q.OrderBy {
+		walkExpr(key.Expr, visit)
+	}
+	walkGroup(q.Where, func(g *Group) {
+		for _, f := range g.Filters {
+			walkExpr(f, visit)
+		}
+		for _, el := range g.Elements {
+			if b, ok := el.(*Bind); ok {
+				walkExpr(b.Expr, visit)
+			}
+		}
+	})
+	return err
+}
+
+// walkGroup calls fn on g and every group nested in it that is evaluated
+// locally, which leaves out SERVICE groups.
+func walkGroup(g *Group, fn func(*Group)) {
+	fn(g)
+	for _, el := range g.Elements {
+		switch el := el.(type) {
+		case *Optional:
+			walkGroup(el.Group, fn)
+		case *Union:
+			for _, alt := range el.Alternatives {
+				walkGroup(alt, fn)
+			}
+		case *Graph:
+			walkGroup(el.Group, fn)
+		case *Group:
+			walkGroup(el, fn)
+		}
+	}
+}
+
+type evaluator struct {
+	ctx     context.Context
+	src     Source
+	service ServiceFunc
+	usage   *usage
+}
+
+// group evaluates g for each input solution. bound holds the variables
+// every input solution binds, for planning.
+func (e *evaluator) group(g *Group, input []Binding, bound map[string]bool) ([]Binding, error) {
+	sols := input
+	bound = copySet(bound)
+	var err error
+	for _, el := range g.Elements {
+		if err := e.usage.check(e.ctx); err != nil {
+			return nil, err
+		}
+		switch el := el.(type) {
+		case *BGP:
+			steps := planBGP(el.Patterns, bound, e.src)
+			for _, step := range steps {
+				if sols, err = e.join(sols, step.pattern); err != nil {
+					return nil, err
+				}
+				addVars(bound, step.pattern)
+			}
+		case *Optional:
+			var out []Binding
+			for _, s := range sols {
+				got, err := e.group(el.Group, []Binding{s}, bound)
+				if err != nil {
+					return nil, err
+				}
+				if len(got) == 0 {
+					got = []Binding{s}
+				}
+				out = append(out, got...)
+			}
+			sols = out
+		case *Union:
+			var out []Binding
+			for _, alt := range el.Alternatives {
+				got, err := e.group(alt, sols, bound)
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, got...)
+			}
+			sols = out
+		case *Graph:
+			if sols, err = e.group(el.Group, sols, bound); err != nil {
+				return nil, err
+			}
+			if IsVar(el.Name) {
+				sols = boundOnly(sols, el.Name[1:])
+				bound[el.Name[1:]] = true
+			}
+		case *Group:
+			if sols, err = e.group(el, sols, bound); err != nil {
+				return nil, err
+			}
+		case *Service:
+			if sols, err = e.remote(el, sols); err != nil {
+				return nil, err
+			}
+		case *Bind:
+			out := make([]Binding, len(sols))
+			for i, s := range sols {
+				out[i] = s
+				if v, err := el.Expr.Eval(s); err == nil {
+					out[i] = s.extend(el.Var, v)
+					if err := e.usage.charge(e.ctx, out[i]); err != nil {
+						return nil, err
+					}
+				}
+			}
+			sols = out
+		}
+	}
+	for _, f := range g.Filters {
+		kept := sols[:0:0]
+		for _, s := range sols {
+			if ok, err := ebv(f, s); err == nil && ok {
+				kept = append(kept, s)
+			}
+		}
+		sols = kept
+	}
+	return sols, nil
+}
+
+// remote evaluates a SERVICE group at its endpoint and joins the answers
+// to sols.
+func (e *evaluator) remote(s *Service, sols []Binding) ([]Binding, error) {
+	if e.service == nil {
+		return nil, fmt.Errorf("SERVICE %s: federated queries are not enabled", s.Endpoint)
+	}
+	answers, err := e.service(e.ctx, s.Endpoint, "SELECT * WHERE "+FormatGroup(s.Group))
+	if err != nil {
+		if s.Silent && e.ctx.Err() == nil {
+			return sols, nil
+		}
+		return nil, fmt.Errorf("SERVICE %s: %w", s.Endpoint, err)
+	}
+	var out []Binding
+	for _, l := range sols {
+		for _, r := range answers {
+			m, ok := l.merge(r)
+			if !ok {
+				continue
+			}
+			out = append(out, m)
+			if err := e.usage.charge(e.ctx, m); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return out, nil
+}
+
+// merge joins two solutions, or reports that they disagree on a variable.
+func (s Binding) merge(o Binding) (Binding, bool) {
+	m := s.extend("", "")
+	for k, v := range o {
+		if old, ok := m[k]; ok && old != v {
+			return nil, false
+		}
+		m[k] = v
+	}
+	return m, true
+}
+
+// boundOnly keeps the solutions that bind name. A GRAPH ?g block binds
+// ?g through its triple patterns; one without any matches no graph.
+func boundOnly(sols []Binding, name string) []Binding {
+	var out []Binding
+	for _, s := range sols {
+		if _, ok := s[name]; ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// join extends each solution with the matches of one triple pattern.
+func (e *evaluator) join(sols []Binding, tp TriplePattern) ([]Binding, error) {
+	var out []Binding
+	for _, s := range sols {
+		sub := func(term string) string {
+			if !IsVar(term) {
+				return term
+			}
+			return s[term[1:]]
+		}
+		graph := sub(tp.Graph)
+		namedOnly := IsVar(tp.Graph) && graph == ""
+		err := e.src.Match(e.ctx, sub(tp.Subject), sub(tp.Predicate), sub(tp.Object), graph, func(q quadstore.Quad) error {
+			if namedOnly && q.Graph == "" {
+				return nil
+			}
+			ext, ok := s.match(tp, q)
+			if !ok {
+				return nil
+			}
+			out = append(out, ext)
+			return e.usage.charge(e.ctx, ext)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// match extends s with the variables of tp bound by q, or reports that q
+// conflicts with s.
+func (s Binding) match(tp TriplePattern, q quadstore.Quad) (Binding, bool) {
+	ext := s
+	copied := false
+	for _, pair := range [][2]string{{tp.Subject, q.Subject}, {tp.Predicate, q.Predicate}, {tp.Object, q.Object}, {tp.Graph, q.Graph}} {
+		term, value := pair[0], pair[1]
+		if !IsVar(term) {
+			continue
+		}
+		name := term[1:]
+		if old, ok := ext[name]; ok {
+			if old != value {
+				return nil, false
+			}
+			continue
+		}
+		if !copied {
+			ext = s.extend("", "")
+			copied = true
+		}
+		ext[name] = value
+	}
+	return ext, true
+}
+
+// extend returns a copy of s with name bound to value; an empty name
+// just copies.
+func (s Binding) extend(name, value string) Binding {
+	c := make(Binding, len(s)+1)
+	for k, v := range s {
+		c[k] = v
+	}
+	if name != "" {
+		c[name] = value
+	}
+	return c
+}
+
+func copySet(m map[string]bool) map[string]bool {
+	c := make(map[string]bool, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+func addVars(bound map[string]bool, tp TriplePattern) {
+	for _, t := range []string{tp.Subject, tp.Predicate, tp.Object, tp.Graph} {
+		if IsVar(t) {
+			bound[t[1:]] = true
+		}
+	}
+}
+
+func aggregated(q *Query) bool {
+	if len(q.GroupBy) > 0 {
+		return true
+	}
+	for _, proj := range q.Projection {
+		if proj.Expr != nil && hasAggregate(proj.Expr) {
+			return true
+		}
+	}
+	return false
+}
+
+// group collapses solutions into one per GROUP BY key, with the query's
+// aggregates computed for each.
+func group(q *Query, sols []Binding) ([]Binding, error) {
+	var aggs []*aggregateExpr
+	collect := func(e Expr) {
+		if a, ok := e.(*aggregateExpr); ok {
+			aggs = append(aggs, a)
+		}
+	}
+	for _, proj := range q.Projection {
+		if proj.Expr != nil {
+			walkExpr(proj.Expr, collect)
+		}
+	}
+	for _, key := range q.OrderBy {
+		walkExpr(key.Expr, collect)
+	}
+
+	groups := make(map[string][]Binding)
+	var keys []string
+	for _, s := range sols {
+		var key strings.Builder
+		for _, v := range q.GroupBy {
+			key.WriteString(s[v])
+			key.WriteByte(0)
+		}
+		k := key.String()
+		if _, ok := groups[k]; !ok {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], s)
+	}
+	if len(keys) == 0 && len(q.GroupBy) == 0 {
+		keys = []string{""} // Aggregates over no solutions still give one row
+	}
+	out := make([]Binding, 0, len(keys))
+	for _, k := range keys {
+		members := groups[k]
+		row := Binding{}
+		if len(members) > 0 {
+			for _, v := range q.GroupBy {
+				if t, ok := members[0][v]; ok {
+					row[v] = t
+				}
+			}
+		}
+		for _, a := range aggs {
+			if v, err := a.compute(members); err == nil {
+				row[a.key] = v
+			}
+		}
+		out = append(out, row)
+	}
+	return out, nil
+}
+
+// compute evaluates an aggregate over the solutions of one group.
+func (a *aggregateExpr) compute(members []Binding) (string, error) {
+	var values []string
+	seen := make(map[string]bool)
+	for _, s := range members {
+		if a.arg == nil {
+			values = append(values, "")
+			continue
+		}
+		v, err := a.arg.Eval(s)
+		if err != nil {
+			continue
+		}
+		if a.distinct {
+			if seen[v] {
+				continue
+			}
+			seen[v] = true
+		}
+		values = append(values, v)
+	}
+	switch a.name {
+	case "COUNT":
+		return formatNumber(float64(len(values)), "integer"), nil
+	case "SAMPLE":
+		if len(values) == 0 {
+			return "", errUnbound
+		}
+		return values[0], nil
+	case "GROUP_CONCAT":
+		parts := make([]string, len(values))
+		for i, v := range values {
+			l, err := lexical(v)
+			if err != nil {
+				l = strings.Trim(v, "<>")
+			}
+			parts[i] = l
+		}
+		return quoteLiteral(strings.Join(parts, a.sep)), nil
+	case "MIN", "MAX":
+		if len(values) == 0 {
+			return "", errUnbound
+		}
+		best := values[0]
+		for _, v := range values[1:] {
+			c := orderTerms(v, best, true, true)
+			if a.name == "MIN" && c < 0 || a.name == "MAX" && c > 0 {
+				best = v
+			}
+		}
+		return best, nil
+	}
+	sum, kind := 0.0, "integer"
+	for _, v := range values {
+		n, k, ok := numeric(v)
+		if !ok {
+			return "", fmt.Errorf("%s of a non-number", a.name)
+		}
+		sum += n
+		kind = widerKind(kind, k)
+	}
+	if a.name == "AVG" {
+		if len(values) == 0 {
+			return formatNumber(0, "integer"), nil
+		}
+		if kind == "integer" {
+			kind = "decimal"
+		}
+		return formatNumber(sum/float64(len(values)), kind), nil
+	}
+	return formatNumber(sum, kind), nil
+}
+
+// order sorts solutions by the ORDER BY keys, stably.
+func order(sols []Binding, keys []OrderKey) []Binding {
+	if len(keys) == 0 {
+		return sols
+	}
+	sort.SliceStable(sols, func(i, j int) bool {
+		for _, k := range keys {
+			a, aerr := k.Expr.Eval(sols[i])
+			b, berr := k.Expr.Eval(sols[j])
+			c := orderTerms(a, b, aerr == nil, berr == nil)
+			if k.Descending {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+	return sols
+}
+
+// projectedVars lists the SELECT variables, or for SELECT * every
+// variable of the pattern in order of appearance.
+func projectedVars(q *Query) []string {
+	if len(q.Projection) > 0 {
+		vars := make([]string, len(q.Projection))
+		for i, proj := range q.Projection {
+			vars[i] = proj.Var
+		}
+		return vars
+	}
+	return q.PatternVars()
+}
+
+// PatternVars lists the variables of the query's pattern in order of
+// appearance, without those standing for blank nodes.
+func (q *Query) PatternVars() []string {
+	var vars []string
+	seen := make(map[string]bool)
+	add := func(term string) {
+		if IsVar(term) && !strings.HasPrefix(term, blankVarPrefix) && !seen[term] {
+			seen[term] = true
+			vars = append(vars, term[1:])
+		}
+	}
+	var visit func(g *Group)
+	visit = func(g *Group) {
+		for _, el := range g.Elements {
+			switch el := el.(type) {
+			case *BGP:
+				for _, tp := range el.Patterns {
+					add(tp.Subject)
+					add(tp.Predicate)
+					add(tp.Object)
+					add(tp.Graph)
+				}
+			case *Graph:
+				add(el.Name)
+			case *Service:
+				walkGroup(el.Group, visit)
+			case *Bind:
+				add("?" + el.Var)
+			}
+		}
+	}
+	walkGroup(q.Where, visit)
+	return vars
+}
+
+func project(sols []Binding, vars []string) []Binding {
+	out := make([]Binding, len(sols))
+	for i, s := range sols {
+		p := make(Binding, len(vars))
+		for _, v := range vars {
+			if t, ok := s[v]; ok {
+				p[v] = t
+			}
+		}
+		out[i] = p
+	}
+	return out
+}
+
+func distinct(sols []Binding, vars []string) []Binding {
+	seen := make(map[string]bool)
+	var out []Binding
+	for _, s := range sols {
+		var key strings.Builder
+		for _, v := range vars {
+			key.WriteString(s[v])
+			key.WriteByte(0)
+		}
+		if !seen[key.String()] {
+			seen[key.String()] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func slice(sols []Binding, offset, limit int) []Binding {
+	if offset >= len(sols) {
+		return nil
+	}
+	sols = sols[offset:]
+	if limit >= 0 && limit < len(sols) {
+		sols = sols[:limit]
+	}
+	return sols
+}
+
+// construct instantiates the template for each solution, giving template
+// blank nodes fresh labels per solution and skipping triples that would be
+// incomplete or ill-formed.
+func construct(template []TriplePattern, sols []Binding) []quadstore.Quad {
+	var quads []quadstore.Quad
+	seen := make(map[quadstore.Quad]bool)
+	for i, s := range sols {
+		inst := func(term string) (string, bool) {
+			if strings.HasPrefix(term, blankVarPrefix) {
+				return fmt.Sprintf("_:%s_%d", term[len(blankVarPrefix):], i), true
+			}
+			if !IsVar(term) {
+				return term, true
+			}
+			v, ok := s[term[1:]]
+			return v, ok
+		}
+		for _, tp := range template {
+			subj, ok1 := inst(tp.Subject)
+			pred, ok2 := inst(tp.Predicate)
+			obj, ok3 := inst(tp.Object)
+			if !ok1 || !ok2 || !ok3 || strings.HasPrefix(subj, `"`) || !strings.HasPrefix(pred, "<") {
+				continue
+			}
+			q := quadstore.Quad{Subject: subj, Predicate: pred, Object: obj}
+			if !seen[q] {
+				seen[q] = true
+				quads = append(quads, q)
+			}
+		}
+	}
+	return quads
+}
@@ -0,0 +1,740 @@
+package sparql
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/rdfio"
+)
+
+// A Binding maps variable names (without '?') to terms.
+type Binding map[string]string
+
+// An Expr is a FILTER, BIND, SELECT or ORDER BY expression.
+type Expr interface {
+	// Eval computes the expression's value as a term. An error is a
+	// SPARQL type error: FILTER treats it as false and BIND as unbound.
+	Eval(b Binding) (string, error)
+	// String renders the expression in SPARQL syntax, for plans.
+	String() string
+}
+
+type varExpr string
+
+type constExpr string
+
+type unaryExpr struct {
+	op string
+	x  Expr
+}
+
+type binaryExpr struct {
+	op   string
+	l, r Expr
+}
+
+type callExpr struct {
+	name string // Upper-case builtin name, or an IRI term
+	args []Expr
+	fn   Function
+}
+
+// aggregateExpr is evaluated once per group; its value is stored in the
+// group's binding under key.
+type aggregateExpr struct {
+	name     string
+	distinct bool
+	arg      Expr // nil for COUNT(*)
+	sep      string
+	key      string
+}
+
+// A Function is an extension function, called by IRI.
+type Function func(args []string) (string, error)
+
+// errUnbound is the type error of reading an unbound variable.
+var errUnbound = fmt.Errorf("unbound variable")
+
+func (v varExpr) Eval(b Binding) (string, error) {
+	if t, ok := b[string(v)]; ok {
+		return t, nil
+	}
+	return "", errUnbound
+}
+
+func (v varExpr) String() string { return "?" + string(v) }
+
+func (c constExpr) Eval(Binding) (string, error) { return string(c), nil }
+func (c constExpr) String() string               { return string(c) }
+
+func (a *aggregateExpr) Eval(b Binding) (string, error) {
+	if t, ok := b[a.key]; ok {
+		return t, nil
+	}
+	return "", errUnbound
+}
+
+func (a *aggregateExpr) String() string {
+	arg := "*"
+	if a.arg != nil {
+		arg = a.arg.String()
+	}
+	if a.distinct {
+		arg = "DISTINCT " + arg
+	}
+	return a.name + "(" + arg + ")"
+}
+
+func (u *unaryExpr) String() string { return u.op + u.x.String() }
+
+func (u *unaryExpr) Eval(b Binding) (string, error) {
+	if u.op == "!" {
+		v, err := ebv(u.x, b)
+		if err != nil {
+			return "", err
+		}
+		return boolean(!v), nil
+	}
+	t, err := u.x.Eval(b)
+	if err != nil {
+		return "", err
+	}
+	n, kind, ok := numeric(t)
+	if !ok {
+		return "", fmt.Errorf("%s is not a number", t)
+	}
+	if u.op == "-" {
+		n = -n
+	}
+	return formatNumber(n, kind), nil
+}
+
+func (e *binaryExpr) String() string {
+	return "(" + e.l.String() + " " + e.op + " " + e.r.String() + ")"
+}
+
+func (e *binaryExpr) Eval(b Binding) (string, error) {
+	switch e.op {
+	case "||":
+		l, lerr := ebv(e.l, b)
+		if lerr == nil && l {
+			return boolean(true), nil
+		}
+		r, rerr := ebv(e.r, b)
+		if rerr == nil && r {
+			return boolean(true), nil
+		}
+		if lerr != nil {
+			return "", lerr
+		}
+		return boolean(false), rerr
+	case "&&":
+		l, lerr := ebv(e.l, b)
+		if lerr == nil && !l {
+			return boolean(false), nil
+		}
+		r, rerr := ebv(e.r, b)
+		if rerr == nil && !r {
+			return boolean(false), nil
+		}
+		if lerr != nil {
+			return "", lerr
+		}
+		return boolean(true), rerr
+	}
+	l, err := e.l.Eval(b)
+	if err != nil {
+		return "", err
+	}
+	r, err := e.r.Eval(b)
+	if err != nil {
+		return "", err
+	}
+	switch e.op {
+	case "=", "!=":
+		eq, err := equal(l, r)
+		if err != nil {
+			return "", err
+		}
+		return boolean(eq == (e.op == "=")), nil
+	case "<", ">", "<=", ">=":
+		c, err := compare(l, r)
+		if err != nil {
+			return "", err
+		}
+		switch e.op {
+		case "<":
+			return boolean(c < 0), nil
+		case ">":
+			return boolean(c > 0), nil
+		case "<=":
+			return boolean(c <= 0), nil
+		}
+		return boolean(c >= 0), nil
+	}
+	ln, lkind, lok := numeric(l)
+	rn, rkind, rok := numeric(r)
+	if !lok || !rok {
+		return "", fmt.Errorf("%s %s %s: not numbers", l, e.op, r)
+	}
+	kind := widerKind(lkind, rkind)
+	switch e.op {
+	case "+":
+		return formatNumber(ln+rn, kind), nil
+	case "-":
+		return formatNumber(ln-rn, kind), nil
+	case "*":
+		return formatNumber(ln*rn, kind), nil
+	}
+	if rn == 0 {
+		return "", fmt.Errorf("division by zero")
+	}
+	if kind == "integer" {
+		kind = "decimal"
+	}
+	return formatNumber(ln/rn, kind), nil
+}
+
+func (c *callExpr) String() string {
+	args := make([]string, len(c.args))
+	for i, a := range c.args {
+		args[i] = a.String()
+	}
+	return c.name + "(" + strings.Join(args, ", ") + ")"
+}
+
+func (c *callExpr) Eval(b Binding) (string, error) {
+	switch c.name {
+	case "BOUND":
+		v, ok := c.args[0].(varExpr)
+		if !ok {
+			return "", fmt.Errorf("BOUND takes a variable")
+		}
+		_, bound := b[string(v)]
+		return boolean(bound), nil
+	case "IF":
+		cond, err := ebv(c.args[0], b)
+		if err != nil {
+			return "", err
+		}
+		if cond {
+			return c.args[1].Eval(b)
+		}
+		return c.args[2].Eval(b)
+	case "COALESCE":
+		for _, a := range c.args {
+			if t, err := a.Eval(b); err == nil {
+				return t, nil
+			}
+		}
+		return "", errUnbound
+	}
+	args := make([]string, len(c.args))
+	for i, a := range c.args {
+		t, err := a.Eval(b)
+		if err != nil {
+			return "", err
+		}
+		args[i] = t
+	}
+	if c.fn != nil {
+		return c.fn(args)
+	}
+	return builtin(c.name, args)
+}
+
+// builtins maps the builtin functions to their number of arguments; -1
+// means any number.
+var builtins = map[string][2]int{
+	"BOUND": {1, 1}, "IF": {3, 3}, "COALESCE": {1, -1},
+	"STR": {1, 1}, "LANG": {1, 1}, "DATATYPE": {1, 1},
+	"ISIRI": {1, 1}, "ISURI": {1, 1}, "ISBLANK": {1, 1}, "ISLITERAL": {1, 1}, "ISNUMERIC": {1, 1},
+	"REGEX": {2, 3}, "CONTAINS": {2, 2}, "STRSTARTS": {2, 2}, "STRENDS": {2, 2},
+	"LCASE": {1, 1}, "UCASE": {1, 1}, "STRLEN": {1, 1}, "CONCAT": {0, -1},
+	"LANGMATCHES": {2, 2}, "SAMETERM": {2, 2},
+}
+
+var aggregates = map[string]bool{"COUNT": true, "SUM": true, "MIN": true, "MAX": true, "AVG": true, "SAMPLE": true, "GROUP_CONCAT": true}
+
+func builtin(name string, args []string) (string, error) {
+	switch name {
+	case "STR":
+		if strings.HasPrefix(args[0], "<") {
+			return quoteLiteral(strings.Trim(args[0], "<>")), nil
+		}
+		s, err := lexical(args[0])
+		return quoteLiteral(s), err
+	case "LANG":
+		_, lang, _, err := rdfio.LiteralParts(args[0])
+		return quoteLiteral(lang), err
+	case "DATATYPE":
+		_, lang, dt, err := rdfio.LiteralParts(args[0])
+		switch {
+		case err != nil:
+			return "", err
+		case lang != "":
+			return "<http://www.w3.org/1999/02/22-rdf-syntax-ns#langString>", nil
+		case dt == "":
+			return "<" + xsdNS + "string>", nil
+		}
+		return "<" + dt + ">", nil
+	case "ISIRI", "ISURI":
+		return boolean(strings.HasPrefix(args[0], "<")), nil
+	case "ISBLANK":
+		return boolean(strings.HasPrefix(args[0], "_:")), nil
+	case "ISLITERAL":
+		return boolean(strings.HasPrefix(args[0], `"`)), nil
+	case "ISNUMERIC":
+		_, _, ok := numeric(args[0])
+		return boolean(ok), nil
+	case "SAMETERM":
+		return boolean(args[0] == args[1]), nil
+	case "CONCAT":
+		var s strings.Builder
+		for _, a := range args {
+			l, err := lexical(a)
+			if err != nil {
+				return "", err
+			}
+			s.WriteString(l)
+		}
+		return quoteLiteral(s.String()), nil
+	case "LANGMATCHES":
+		tag, err := lexical(args[0])
+		if err != nil {
+			return "", err
+		}
+		rng, err := lexical(args[1])
+		if err != nil {
+			return "", err
+		}
+		tag, rng = strings.ToLower(tag), strings.ToLower(rng)
+		return boolean(rng == "*" && tag != "" || tag == rng || strings.HasPrefix(tag, rng+"-")), nil
+	}
+	s, err := lexical(args[0])
+	if err != nil {
+		return "", err
+	}
+	switch name {
+	case "LCASE":
+		return withLiteralForm(args[0], strings.ToLower(s)), nil
+	case "UCASE":
+		return withLiteralForm(args[0], strings.ToUpper(s)), nil
+	case "STRLEN":
+		return formatNumber(float64(len([]rune(s))), "integer"), nil
+	}
+	arg, err := lexical(args[1])
+	if err != nil {
+		return "", err
+	}
+	switch name {
+	case "CONTAINS":
+		return boolean(strings.Contains(s, arg)), nil
+	case "STRSTARTS":
+		return boolean(strings.HasPrefix(s, arg)), nil
+	case "STRENDS":
+		return boolean(strings.HasSuffix(s, arg)), nil
+	case "REGEX":
+		flags := ""
+		if len(args) == 3 {
+			if flags, err = lexical(args[2]); err != nil {
+				return "", err
+			}
+		}
+		re, err := compileRegex(arg, flags)
+		if err != nil {
+			return "", err
+		}
+		return boolean(re.MatchString(s)), nil
+	}
+	return "", fmt.Errorf("unknown function %s", name)
+}
+
+// compileRegex compiles a REGEX pattern with XPath flags.
+func compileRegex(pattern, flags string) (*regexp.Regexp, error) {
+	prefix := ""
+	for _, f := range flags {
+		switch f {
+		case 'i', 'm', 's':
+			prefix += string(f)
+		case 'x':
+			pattern = regexp.MustCompile(`\s+`).ReplaceAllString(pattern, "")
+		default:
+			return nil, fmt.Errorf("unsupported regex flag %q", f)
+		}
+	}
+	if prefix != "" {
+		pattern = "(?" + prefix + ")" + pattern
+	}
+	return regexp.Compile(pattern)
+}
+
+// withLiteralForm gives s the language tag of the string literal orig.
+func withLiteralForm(orig, s string) string {
+	_, lang, _, _ := rdfio.LiteralParts(orig)
+	if lang != "" {
+		return quoteLiteral(s) + "@" + lang
+	}
+	return quoteLiteral(s)
+}
+
+// lexical returns the lexical form of a literal.
+func lexical(term string) (string, error) {
+	if !strings.HasPrefix(term, `"`) {
+		return "", fmt.Errorf("%s is not a literal", term)
+	}
+	s, _, _, err := rdfio.LiteralParts(term)
+	return s, err
+}
+
+func boolean(v bool) string {
+	return `"` + strconv.FormatBool(v) + `"^^<` + xsdNS + `boolean>`
+}
+
+// numericTypes maps numeric datatypes to the kind arithmetic treats them as.
+var numericTypes = map[string]string{
+	"integer": "integer", "int": "integer", "long": "integer", "short": "integer", "byte": "integer",
+	"nonNegativeInteger": "integer", "positiveInteger": "integer", "negativeInteger": "integer",
+	"nonPositiveInteger": "integer", "unsignedInt": "integer", "unsignedLong": "integer",
+	"decimal": "decimal", "float": "double", "double": "double",
+}
+
+// numeric returns the value of a numeric literal and its kind.
+func numeric(term string) (float64, string, bool) {
+	if !strings.HasPrefix(term, `"`) {
+		return 0, "", false
+	}
+	s, _, dt, err := rdfio.LiteralParts(term)
+	if err != nil || !strings.HasPrefix(dt, xsdNS) {
+		return 0, "", false
+	}
+	kind, ok := numericTypes[strings.TrimPrefix(dt, xsdNS)]
+	if !ok {
+		return 0, "", false
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, "", false
+	}
+	return n, kind, true
+}
+
+func widerKind(a, b string) string {
+	if a == "double" || b == "double" {
+		return "double"
+	}
+	if a == "decimal" || b == "decimal" {
+		return "decimal"
+	}
+	return "integer"
+}
+
+func formatNumber(n float64, kind string) string {
+	var s string
+	switch {
+	case kind == "integer" && n == math.Trunc(n) && math.Abs(n) < 1e18:
+		s = strconv.FormatInt(int64(n), 10)
+	case kind == "double":
+		s = strconv.FormatFloat(n, 'E', -1, 64)
+	default:
+		kind = "decimal"
+		s = strconv.FormatFloat(n, 'f', -1, 64)
+		if !strings.Contains(s, ".") {
+			s += ".0"
+		}
+	}
+	return `"` + s + `"^^<` + xsdNS + kind + `>`
+}
+
+// ebv computes the effective boolean value of an expression.
+func ebv(e Expr, b Binding) (bool, error) {
+	t, err := e.Eval(b)
+	if err != nil {
+		return false, err
+	}
+	return effectiveBoolean(t)
+}
+
+func effectiveBoolean(t string) (bool, error) {
+	if n, _, ok := numeric(t); ok {
+		return n != 0 && !math.IsNaN(n), nil
+	}
+	s, _, dt, err := rdfio.LiteralParts(t)
+	if err != nil {
+		return false, fmt.Errorf("%s has no boolean value", t)
+	}
+	switch dt {
+	case xsdNS + "boolean":
+		return s == "true" || s == "1", nil
+	case "", xsdNS + "string":
+		return s != "", nil
+	}
+	return false, fmt.Errorf("%s has no boolean value", t)
+}
+
+// equal compares terms for '=': numerically for numbers, by value for
+// literals of the same kind, and otherwise as terms.
+func equal(a, b string) (bool, error) {
+	if an, _, ok := numeric(a); ok {
+		if bn, _, ok := numeric(b); ok {
+			return an == bn, nil
+		}
+	}
+	return a == b, nil
+}
+
+// compare orders two terms for '<' and friends: numbers numerically,
+// literals of the same datatype and language by lexical form.
+func compare(a, b string) (int, error) {
+	if an, _, ok := numeric(a); ok {
+		if bn, _, ok := numeric(b); ok {
+			switch {
+			case an < bn:
+				return -1, nil
+			case an > bn:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	as, alang, adt, aerr := rdfio.LiteralParts(a)
+	bs, blang, bdt, berr := rdfio.LiteralParts(b)
+	if aerr != nil || berr != nil || alang != blang || normalDatatype(adt) != normalDatatype(bdt) {
+		return 0, fmt.Errorf("cannot compare %s and %s", a, b)
+	}
+	return strings.Compare(as, bs), nil
+}
+
+func normalDatatype(dt string) string {
+	if dt == xsdNS+"string" {
+		return ""
+	}
+	return dt
+}
+
+// orderTerms is the total order of ORDER BY: unbound, blank nodes, IRIs,
+// then literals, which compare by value where they can.
+func orderTerms(a, b string, aok, bok bool) int {
+	rank := func(t string, ok bool) int {
+		switch {
+		case !ok:
+			return 0
+		case strings.HasPrefix(t, "_:"):
+			return 1
+		case strings.HasPrefix(t, "<"):
+			return 2
+		}
+		return 3
+	}
+	if ra, rb := rank(a, aok), rank(b, bok); ra != rb {
+		return ra - rb
+	}
+	if c, err := compare(a, b); err == nil {
+		return c
+	}
+	return strings.Compare(a, b)
+}
+
+// hasAggregate reports whether an expression contains an aggregate.
+func hasAggregate(e Expr) bool {
+	found := false
+	walkExpr(e, func(e Expr) {
+		if _, ok := e.(*aggregateExpr); ok {
+			found = true
+		}
+	})
+	return found
+}
+
+// walkExpr calls fn on e and every expression within it.
+func walkExpr(e Expr, fn func(Expr)) {
+	fn(e)
+	switch e := e.(type) {
+	case *unaryExpr:
+		walkExpr(e.x, fn)
+	case *binaryExpr:
+		walkExpr(e.l, fn)
+		walkExpr(e.r, fn)
+	case *callExpr:
+		for _, a := range e.args {
+			walkExpr(a, fn)
+		}
+	case *aggregateExpr:
+		if e.arg != nil {
+			walkExpr(e.arg, fn)
+		}
+	}
+}
+
+// Expression grammar, from loosest to tightest binding.
+
+func (p *parser) expr() (Expr, error) {
+	return p.binary(0)
+}
+
+var precedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"=", "!=", "<", ">", "<=", ">="},
+	{"+", "-"},
+	{"*", "/"},
+}
+
+func (p *parser) binary(level int) (Expr, error) {
+	if level == len(precedence) {
+		return p.unary()
+	}
+	l, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		matched := ""
+		for _, op := range precedence[level] {
+			if t.kind == tokPunct && t.text == op {
+				matched = op
+			}
+		}
+		if matched == "" {
+			return l, nil
+		}
+		p.next()
+		r, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryExpr{op: matched, l: l, r: r}
+		if level == 2 {
+			return l, nil // Relational operators do not chain
+		}
+	}
+}
+
+func (p *parser) unary() (Expr, error) {
+	for _, op := range []string{"!", "-", "+"} {
+		if t := p.peek(); t.kind == tokPunct && t.text == op {
+			p.next()
+			if op != "!" && p.peek().kind == tokNumber {
+				p.pos -= 1
+				lit, err := p.literal()
+				return constExpr(lit), err
+			}
+			x, err := p.unary()
+			if err != nil {
+				return nil, err
+			}
+			if op == "+" {
+				return x, nil
+			}
+			return &unaryExpr{op: op, x: x}, nil
+		}
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (Expr, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokPunct:
+		if t.text == "(" {
+			p.next()
+			e, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			return e, p.expect(")")
+		}
+	case tokVar:
+		p.next()
+		return varExpr(t.text[1:]), nil
+	case tokString, tokNumber:
+		lit, err := p.literal()
+		return constExpr(lit), err
+	case tokIRI, tokPName:
+		iri, err := p.iri()
+		if err != nil {
+			return nil, err
+		}
+		if next := p.peek(); next.kind == tokPunct && next.text == "(" {
+			args, err := p.args()
+			if err != nil {
+				return nil, err
+			}
+			return &callExpr{name: iri, args: args}, nil
+		}
+		return constExpr(iri), nil
+	case tokWord:
+		name := strings.ToUpper(t.text)
+		if name == "TRUE" || name == "FALSE" {
+			lit, err := p.literal()
+			return constExpr(lit), err
+		}
+		p.next()
+		if aggregates[name] {
+			return p.aggregate(name)
+		}
+		arity, ok := builtins[name]
+		if !ok {
+			p.pos--
+			return nil, p.errorf("unknown function %s", t.text)
+		}
+		args, err := p.args()
+		if err != nil {
+			return nil, err
+		}
+		if len(args) < arity[0] || arity[1] >= 0 && len(args) > arity[1] {
+			return nil, &SyntaxError{t.line, fmt.Sprintf("wrong number of arguments to %s", name)}
+		}
+		return &callExpr{name: name, args: args}, nil
+	}
+	return nil, p.errorf("expected an expression, found %s", p.describe())
+}
+
+func (p *parser) args() ([]Expr, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var args []Expr
+	if p.punct(")") {
+		return args, nil
+	}
+	for {
+		a, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, a)
+		if p.punct(")") {
+			return args, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) aggregate(name string) (Expr, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	a := &aggregateExpr{name: name, distinct: p.keyword("DISTINCT"), sep: " "}
+	if name == "COUNT" && p.punct("*") {
+		return a, p.expect(")")
+	}
+	arg, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	a.arg = arg
+	if name == "GROUP_CONCAT" && p.punct(";") {
+		if !p.keyword("SEPARATOR") || !p.punct("=") || p.peek().kind != tokString {
+			return nil, p.errorf("expected SEPARATOR = \"...\"")
+		}
+		a.sep = p.next().text
+	}
+	return a, p.expect(")")
+}
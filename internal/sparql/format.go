@@ -0,0 +1,60 @@
+package sparql
+
+import "strings"
+
+// FormatGroup writes g back as SPARQL, with every IRI in full, e.g. to
+// send it to a SERVICE endpoint.
+func FormatGroup(g *Group) string {
+	var b strings.Builder
+	formatGroup(&b, g)
+	return b.String()
+}
+
+func formatGroup(b *strings.Builder, g *Group) {
+	b.WriteString("{ ")
+	for _, el := range g.Elements {
+		switch el := el.(type) {
+		case *BGP:
+			for _, tp := range el.Patterns {
+				b.WriteString(formatTerm(tp.Subject) + " " + formatTerm(tp.Predicate) + " " + formatTerm(tp.Object) + " . ")
+			}
+		case *Optional:
+			b.WriteString("OPTIONAL ")
+			formatGroup(b, el.Group)
+		case *Union:
+			for i, alt := range el.Alternatives {
+				if i > 0 {
+					b.WriteString("UNION ")
+				}
+				formatGroup(b, alt)
+			}
+		case *Graph:
+			b.WriteString("GRAPH " + el.Name + " ")
+			formatGroup(b, el.Group)
+		case *Service:
+			b.WriteString("SERVICE ")
+			if el.Silent {
+				b.WriteString("SILENT ")
+			}
+			b.WriteString(el.Endpoint + " ")
+			formatGroup(b, el.Group)
+		case *Bind:
+			b.WriteString("BIND(" + el.Expr.String() + " AS ?" + el.Var + ") ")
+		case *Group:
+			formatGroup(b, el)
+		}
+	}
+	for _, f := range g.Filters {
+		b.WriteString("FILTER(" + f.String() + ") ")
+	}
+	b.WriteString("} ")
+}
+
+// formatTerm writes a pattern term, turning the variables that stand for
+// blank nodes back into blank nodes.
+func formatTerm(term string) string {
+	if strings.HasPrefix(term, blankVarPrefix) {
+		return term[1:]
+	}
+	return term
+}
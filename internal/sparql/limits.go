@@ -0,0 +1,85 @@
+package sparql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Limits bound the resources one evaluation may use; zero leaves a
+// resource unbounded.
+type Limits struct {
+	// Timeout bounds the wall-clock time of the evaluation.
+	Timeout time.Duration
+	// MaxRows bounds the intermediate solutions the evaluation produces,
+	// counted across every join, so that a query cannot run away even
+	// when its final answer would be small.
+	MaxRows int
+	// MaxMemory bounds the approximate bytes held by those solutions.
+	MaxMemory int64
+}
+
+// A LimitError reports that an evaluation was stopped by one of its
+// Limits, and how far it had got; any results are incomplete and are not
+// returned.
+type LimitError struct {
+	// Limit names the limit hit: "timeout", "rows" or "memory".
+	Limit string
+	// Rows and Memory are the intermediate solutions produced and the
+	// bytes they held when the evaluation stopped.
+	Rows    int
+	Memory  int64
+	Elapsed time.Duration
+}
+
+func (e *LimitError) Error() string {
+	what := map[string]string{"timeout": "time limit", "rows": "row limit", "memory": "memory limit"}[e.Limit]
+	return fmt.Sprintf("query stopped by its %s after %s, %d intermediate rows, about %d bytes; results are incomplete", what, e.Elapsed.Round(time.Millisecond), e.Rows, e.Memory)
+}
+
+// usage tracks what an evaluation has consumed against its limits.
+type usage struct {
+	limits Limits
+	start  time.Time
+	rows   int
+	memory int64
+}
+
+// charge accounts for a new intermediate solution.
+func (u *usage) charge(ctx context.Context, b Binding) error {
+	u.rows++
+	u.memory += bindingSize(b)
+	switch {
+	case u.limits.MaxRows > 0 && u.rows > u.limits.MaxRows:
+		return u.exceeded("rows")
+	case u.limits.MaxMemory > 0 && u.memory > u.limits.MaxMemory:
+		return u.exceeded("memory")
+	case u.rows%1024 == 0:
+		return u.check(ctx)
+	}
+	return nil
+}
+
+// check reports a cancelled or expired context, the latter as a timeout.
+func (u *usage) check(ctx context.Context) error {
+	err := ctx.Err()
+	if errors.Is(err, context.DeadlineExceeded) {
+		return u.exceeded("timeout")
+	}
+	return err
+}
+
+func (u *usage) exceeded(limit string) error {
+	return &LimitError{Limit: limit, Rows: u.rows, Memory: u.memory, Elapsed: time.Since(u.start)}
+}
+
+// bindingSize approximates the heap bytes held by a solution: the map
+// header and, for each entry, its strings and bucket slot.
+func bindingSize(b Binding) int64 {
+	n := int64(48)
+	for k, v := range b {
+		n += int64(len(k)+len(v)) + 40
+	}
+	return n
+}
@@ -0,0 +1,71 @@
+package sparql
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+)
+
+// blockingSource matches nothing until its context ends.
+type blockingSource struct{ memSource }
+
+func (blockingSource) Match(ctx context.Context, s, p, o, graph string, fn func(q quadstore.Quad) error) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestLimits(t *testing.T) {
+	// The cross product of the three names makes 3 + 9 intermediate rows.
+	const cross = `SELECT ?a ?b WHERE { ?a <name> ?x . ?b <name> ?y }`
+	tests := []struct {
+		name   string
+		src    Source
+		limits Limits
+		want   string // The Limit hit, or "" for none
+	}{
+		{"unlimited", people, Limits{}, ""},
+		{"within row limit", people, Limits{MaxRows: 12}, ""},
+		{"row limit", people, Limits{MaxRows: 11}, "rows"},
+		{"memory limit", people, Limits{MaxMemory: 100}, "memory"},
+		{"timeout", blockingSource{}, Limits{Timeout: 10 * time.Millisecond}, "timeout"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := Parse(cross)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := Evaluate(context.Background(), q, tt.src, Options{Limits: tt.limits})
+			var limit *LimitError
+			switch {
+			case tt.want == "" && err != nil:
+				t.Fatalf("Evaluate: %v", err)
+			case tt.want == "" && len(res.Solutions) != 9:
+				t.Fatalf("got %d solutions, want 9", len(res.Solutions))
+			case tt.want != "" && !errors.As(err, &limit):
+				t.Fatalf("Evaluate = %v, want a *LimitError", err)
+			case tt.want != "" && limit.Limit != tt.want:
+				t.Errorf("stopped by the %s limit, want %s", limit.Limit, tt.want)
+			case tt.want != "" && res != nil:
+				t.Errorf("incomplete results were returned")
+			}
+		})
+	}
+}
+
+func TestCancelIsNotALimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	q, err := Parse(`SELECT ?s WHERE { ?s ?p ?o }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Evaluate(ctx, q, blockingSource{}, Options{})
+	var limit *LimitError
+	if !errors.Is(err, context.Canceled) || errors.As(err, &limit) {
+		t.Errorf("Evaluate = %v, want context.Canceled", err)
+	}
+}
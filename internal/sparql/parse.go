@@ -0,0 +1,837 @@
+package sparql
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	rdfType = "<http://www.w3.org/1999/02/22-rdf-syntax-ns#type>"
+	xsdNS   = "http://www.w3.org/2001/XMLSchema#"
+)
+
+// blankVarPrefix names the variables that stand for blank nodes in query
+// patterns; SELECT * does not project them.
+const blankVarPrefix = "?_:"
+
+// A SyntaxError reports where a query could not be parsed.
+type SyntaxError struct {
+	Line int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string { return fmt.Sprintf("line %d: %s", e.Line, e.Msg) }
+
+type tokenKind int
+
+const (
+	tokEOF     tokenKind = iota
+	tokIRI               // <...>, resolved
+	tokPName             // prefix:local, unexpanded
+	tokVar               // ?name
+	tokBlank             // _:label
+	tokString            // lexical form, unescaped
+	tokLangTag           // @en
+	tokNumber
+	tokWord // keywords and function names
+	tokPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	line int
+}
+
+var (
+	iriRef    = regexp.MustCompile(`^<[^<>"{}|^` + "`" + `\\\x00-\x20]*>`)
+	numberTok = regexp.MustCompile(`^[0-9]*\.?[0-9]+([eE][+-]?[0-9]+)?`)
+	punct     = []string{"^^", "&&", "||", "!=", "<=", ">=", "{", "}", "(", ")", ".", ";", ",", "*", "=", "<", ">", "!", "+", "-", "/"}
+)
+
+// lex splits a query into tokens.
+func lex(src string) ([]token, error) {
+	var toks []token
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+			continue
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+			continue
+		case c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+			continue
+		}
+		rest := src[i:]
+		tok := token{line: line}
+		n := 0
+		switch {
+		case c == '<' && iriRef.MatchString(rest):
+			m := iriRef.FindString(rest)
+			tok.kind, tok.text, n = tokIRI, m[1:len(m)-1], len(m)
+		case c == '?' || c == '$':
+			n = 1 + nameLen(rest[1:])
+			if n == 1 {
+				return nil, &SyntaxError{line, "empty variable name"}
+			}
+			tok.kind, tok.text = tokVar, "?"+rest[1:n]
+		case strings.HasPrefix(rest, "_:"):
+			n = 2 + nameLen(rest[2:])
+			tok.kind, tok.text = tokBlank, rest[:n]
+		case c == '"' || c == '\'':
+			s, size, lines, err := lexString(rest)
+			if err != nil {
+				return nil, &SyntaxError{line, err.Error()}
+			}
+			tok.kind, tok.text, n = tokString, s, size
+			line += lines
+		case c == '@':
+			n = 1
+			for n < len(rest) && (isAlnum(rest[n]) || rest[n] == '-') {
+				n++
+			}
+			tok.kind, tok.text = tokLangTag, rest[1:n]
+		case c >= '0' && c <= '9' || c == '.' && len(rest) > 1 && rest[1] >= '0' && rest[1] <= '9':
+			m := numberTok.FindString(rest)
+			tok.kind, tok.text, n = tokNumber, m, len(m)
+		case isNameStart(c) || c == ':':
+			n = nameLen(rest)
+			if n < len(rest) && rest[n] == ':' {
+				n++
+				n += localLen(rest[n:])
+				tok.kind = tokPName
+			} else {
+				tok.kind = tokWord
+			}
+			tok.text = rest[:n]
+		default:
+			for _, p := range punct {
+				if strings.HasPrefix(rest, p) {
+					tok.kind, tok.text, n = tokPunct, p, len(p)
+					break
+				}
+			}
+			if n == 0 {
+				return nil, &SyntaxError{line, fmt.Sprintf("unexpected %q", rest[:1])}
+			}
+		}
+		toks = append(toks, tok)
+		i += n
+	}
+	return append(toks, token{kind: tokEOF, line: line}), nil
+}
+
+func isAlnum(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func isNameStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c >= 0x80
+}
+
+// nameLen returns the length of the name at the start of s.
+func nameLen(s string) int {
+	n := 0
+	for n < len(s) {
+		r, size := utf8.DecodeRuneInString(s[n:])
+		if !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-') {
+			break
+		}
+		n += size
+	}
+	return n
+}
+
+// localLen returns the length of the local part of a prefixed name, which
+// may contain dots but not end with one.
+func localLen(s string) int {
+	n := 0
+	for n < len(s) {
+		r, size := utf8.DecodeRuneInString(s[n:])
+		if !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.' || r == ':') {
+			break
+		}
+		n += size
+	}
+	for n > 0 && s[n-1] == '.' {
+		n--
+	}
+	return n
+}
+
+// lexString reads a quoted string and returns its unescaped value, the
+// bytes consumed and the newlines crossed.
+func lexString(s string) (string, int, int, error) {
+	delim := s[:1]
+	if strings.HasPrefix(s, strings.Repeat(delim, 3)) {
+		delim = strings.Repeat(delim, 3)
+	}
+	var b strings.Builder
+	lines := 0
+	for i := len(delim); i < len(s); i++ {
+		if strings.HasPrefix(s[i:], delim) {
+			return b.String(), i + len(delim), lines, nil
+		}
+		c := s[i]
+		if c == '\n' {
+			if len(delim) == 1 {
+				break
+			}
+			lines++
+		}
+		if c != '\\' || i+1 == len(s) {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		switch e := s[i]; e {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'u', 'U':
+			size := 4
+			if e == 'U' {
+				size = 8
+			}
+			if i+size >= len(s) {
+				return "", 0, 0, fmt.Errorf("truncated escape")
+			}
+			code, err := strconv.ParseUint(s[i+1:i+1+size], 16, 32)
+			if err != nil {
+				return "", 0, 0, fmt.Errorf("invalid escape \\%c%s", e, s[i+1:i+1+size])
+			}
+			b.WriteRune(rune(code))
+			i += size
+		default:
+			b.WriteByte(e)
+		}
+	}
+	return "", 0, 0, fmt.Errorf("unterminated string")
+}
+
+// Parse parses a query.
+func Parse(src string) (*Query, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks, prefixes: map[string]string{}}
+	q, err := p.query()
+	if err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+type parser struct {
+	toks     []token
+	pos      int
+	base     string
+	prefixes map[string]string
+}
+
+func (p *parser) peek() token { return p.toks[p.pos] }
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{p.peek().line, fmt.Sprintf(format, args...)}
+}
+
+// describe renders the next token for error messages.
+func (p *parser) describe() string {
+	t := p.peek()
+	switch t.kind {
+	case tokEOF:
+		return "end of query"
+	case tokIRI:
+		return "<" + t.text + ">"
+	case tokString:
+		return strconv.Quote(t.text)
+	}
+	return strconv.Quote(t.text)
+}
+
+// keyword consumes the case-insensitive keyword kw if it is next.
+func (p *parser) keyword(kw string) bool {
+	if t := p.peek(); t.kind == tokWord && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) isKeyword(kw string) bool {
+	t := p.peek()
+	return t.kind == tokWord && strings.EqualFold(t.text, kw)
+}
+
+// punct consumes the punctuation s if it is next.
+func (p *parser) punct(s string) bool {
+	if t := p.peek(); t.kind == tokPunct && t.text == s {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(s string) error {
+	if !p.punct(s) {
+		return p.errorf("expected %q, found %s", s, p.describe())
+	}
+	return nil
+}
+
+func (p *parser) query() (*Query, error) {
+	for {
+		switch {
+		case p.keyword("PREFIX"):
+			t := p.next()
+			if t.kind != tokPName || !strings.HasSuffix(t.text, ":") {
+				return nil, p.errorf("expected a prefix name such as ex:")
+			}
+			iri := p.next()
+			if iri.kind != tokIRI {
+				return nil, p.errorf("expected an IRI for prefix %s", t.text)
+			}
+			p.prefixes[strings.TrimSuffix(t.text, ":")] = p.resolve(iri.text)
+			continue
+		case p.keyword("BASE"):
+			iri := p.next()
+			if iri.kind != tokIRI {
+				return nil, p.errorf("expected an IRI after BASE")
+			}
+			p.base = iri.text
+			continue
+		}
+		break
+	}
+	q := &Query{Limit: -1}
+	var err error
+	switch {
+	case p.keyword("SELECT"):
+		q.Form = "SELECT"
+		err = p.selectClause(q)
+	case p.keyword("CONSTRUCT"):
+		q.Form = "CONSTRUCT"
+		if err = p.expect("{"); err == nil {
+			q.Template, err = p.triplesUntil("}", "")
+		}
+	case p.keyword("ASK"):
+		q.Form = "ASK"
+	default:
+		return nil, p.errorf("expected SELECT, CONSTRUCT or ASK, found %s", p.describe())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.isKeyword("FROM") {
+		return nil, p.errorf("FROM is not supported; use GRAPH in the WHERE clause")
+	}
+	p.keyword("WHERE")
+	if q.Where, err = p.group(); err != nil {
+		return nil, err
+	}
+	if err := p.modifiers(q); err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokEOF {
+		return nil, p.errorf("unexpected %s after the query", p.describe())
+	}
+	return q, p.checkGrouping(q)
+}
+
+func (p *parser) selectClause(q *Query) error {
+	q.Distinct = p.keyword("DISTINCT")
+	if !q.Distinct {
+		p.keyword("REDUCED")
+	}
+	if p.punct("*") {
+		return nil
+	}
+	for {
+		switch t := p.peek(); {
+		case t.kind == tokVar:
+			p.next()
+			q.Projection = append(q.Projection, Projection{Var: t.text[1:]})
+			continue
+		case t.kind == tokPunct && t.text == "(":
+			p.next()
+			expr, err := p.expr()
+			if err != nil {
+				return err
+			}
+			if !p.keyword("AS") {
+				return p.errorf("expected AS in a SELECT expression")
+			}
+			v := p.next()
+			if v.kind != tokVar {
+				return p.errorf("expected a variable after AS")
+			}
+			if err := p.expect(")"); err != nil {
+				return err
+			}
+			q.Projection = append(q.Projection, Projection{Var: v.text[1:], Expr: expr})
+			continue
+		}
+		break
+	}
+	if len(q.Projection) == 0 {
+		return p.errorf("expected variables or * after SELECT, found %s", p.describe())
+	}
+	return nil
+}
+
+// checkGrouping rejects projections that aggregate queries cannot produce.
+func (p *parser) checkGrouping(q *Query) error {
+	aggregated := len(q.GroupBy) > 0
+	for _, proj := range q.Projection {
+		if proj.Expr != nil && hasAggregate(proj.Expr) {
+			aggregated = true
+		}
+	}
+	if !aggregated {
+		return nil
+	}
+	grouped := make(map[string]bool)
+	for _, v := range q.GroupBy {
+		grouped[v] = true
+	}
+	if len(q.Projection) == 0 {
+		return &SyntaxError{1, "SELECT * cannot be used with GROUP BY or aggregates"}
+	}
+	for _, proj := range q.Projection {
+		if proj.Expr == nil && !grouped[proj.Var] {
+			return &SyntaxError{1, fmt.Sprintf("?%s is neither grouped nor aggregated", proj.Var)}
+		}
+	}
+	return nil
+}
+
+func (p *parser) modifiers(q *Query) error {
+	if p.keyword("GROUP") {
+		if !p.keyword("BY") {
+			return p.errorf("expected BY after GROUP")
+		}
+		for p.peek().kind == tokVar {
+			q.GroupBy = append(q.GroupBy, p.next().text[1:])
+		}
+		if len(q.GroupBy) == 0 {
+			return p.errorf("GROUP BY supports variables only")
+		}
+	}
+	if p.keyword("ORDER") {
+		if !p.keyword("BY") {
+			return p.errorf("expected BY after ORDER")
+		}
+		for {
+			var key OrderKey
+			switch t := p.peek(); {
+			case t.kind == tokVar:
+				p.next()
+				key.Expr = varExpr(t.text[1:])
+			case p.isKeyword("ASC") || p.isKeyword("DESC"):
+				key.Descending = p.keyword("DESC")
+				if !key.Descending {
+					p.next()
+				}
+				if err := p.expect("("); err != nil {
+					return err
+				}
+				expr, err := p.expr()
+				if err != nil {
+					return err
+				}
+				if err := p.expect(")"); err != nil {
+					return err
+				}
+				key.Expr = expr
+			case t.kind == tokPunct && t.text == "(":
+				expr, err := p.primary()
+				if err != nil {
+					return err
+				}
+				key.Expr = expr
+			default:
+				if len(q.OrderBy) == 0 {
+					return p.errorf("expected an ORDER BY condition, found %s", p.describe())
+				}
+				goto limits
+			}
+			q.OrderBy = append(q.OrderBy, key)
+		}
+	}
+limits:
+	for {
+		switch {
+		case p.keyword("LIMIT"):
+			n, err := p.integer()
+			if err != nil {
+				return err
+			}
+			q.Limit = n
+		case p.keyword("OFFSET"):
+			n, err := p.integer()
+			if err != nil {
+				return err
+			}
+			q.Offset = n
+		default:
+			return nil
+		}
+	}
+}
+
+func (p *parser) integer() (int, error) {
+	t := p.next()
+	n, err := strconv.Atoi(t.text)
+	if t.kind != tokNumber || err != nil || n < 0 {
+		return 0, p.errorf("expected a non-negative integer")
+	}
+	return n, nil
+}
+
+// group parses a group graph pattern: '{' ... '}'.
+func (p *parser) group() (*Group, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	return p.groupBody("")
+}
+
+// groupBody parses the elements of a group up to its closing brace. graph
+// is the enclosing GRAPH block's name, recorded in triple patterns.
+func (p *parser) groupBody(graph string) (*Group, error) {
+	g := &Group{}
+	for !p.punct("}") {
+		var err error
+		switch t := p.peek(); {
+		case t.kind == tokEOF:
+			return nil, p.errorf("unterminated group: expected '}'")
+		case p.punct("."):
+		case p.keyword("FILTER"):
+			var expr Expr
+			if expr, err = p.constraint(); err == nil {
+				g.Filters = append(g.Filters, expr)
+			}
+		case p.keyword("OPTIONAL"):
+			var inner *Group
+			if inner, err = p.nestedGroup(graph); err == nil {
+				g.Elements = append(g.Elements, &Optional{Group: inner})
+			}
+		case p.keyword("GRAPH"):
+			var name string
+			if name, err = p.varOrIRI(); err != nil {
+				break
+			}
+			if err = p.expect("{"); err != nil {
+				break
+			}
+			var inner *Group
+			if inner, err = p.groupBody(name); err == nil {
+				g.Elements = append(g.Elements, &Graph{Name: name, Group: inner})
+			}
+		case p.keyword("SERVICE"):
+			err = p.service(g)
+		case p.keyword("BIND"):
+			err = p.bind(g)
+		case t.kind == tokPunct && t.text == "{":
+			err = p.unionOrGroup(g, graph)
+		default:
+			var patterns []TriplePattern
+			if patterns, err = p.triplesUntil("", graph); err == nil {
+				if last, ok := lastBGP(g); ok {
+					last.Patterns = append(last.Patterns, patterns...)
+				} else {
+					g.Elements = append(g.Elements, &BGP{Patterns: patterns})
+				}
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// lastBGP returns the group's final element if it is a BGP, so adjacent
+// triples separated by filters form one block.
+func lastBGP(g *Group) (*BGP, bool) {
+	if len(g.Elements) == 0 {
+		return nil, false
+	}
+	bgp, ok := g.Elements[len(g.Elements)-1].(*BGP)
+	return bgp, ok
+}
+
+func (p *parser) nestedGroup(graph string) (*Group, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	return p.groupBody(graph)
+}
+
+func (p *parser) unionOrGroup(g *Group, graph string) error {
+	first, err := p.nestedGroup(graph)
+	if err != nil {
+		return err
+	}
+	if !p.isKeyword("UNION") {
+		g.Elements = append(g.Elements, first)
+		return nil
+	}
+	u := &Union{Alternatives: []*Group{first}}
+	for p.keyword("UNION") {
+		alt, err := p.nestedGroup(graph)
+		if err != nil {
+			return err
+		}
+		u.Alternatives = append(u.Alternatives, alt)
+	}
+	g.Elements = append(g.Elements, u)
+	return nil
+}
+
+// service parses SERVICE [SILENT] <endpoint> { ... }. Its group belongs to
+// the endpoint, so an enclosing GRAPH does not apply inside it.
+func (p *parser) service(g *Group) error {
+	silent := p.keyword("SILENT")
+	if p.peek().kind == tokVar {
+		return p.errorf("SERVICE needs an endpoint IRI, not a variable")
+	}
+	endpoint, err := p.iri()
+	if err != nil {
+		return err
+	}
+	inner, err := p.nestedGroup("")
+	if err != nil {
+		return err
+	}
+	g.Elements = append(g.Elements, &Service{Endpoint: endpoint, Silent: silent, Group: inner})
+	return nil
+}
+
+func (p *parser) bind(g *Group) error {
+	if err := p.expect("("); err != nil {
+		return err
+	}
+	expr, err := p.expr()
+	if err != nil {
+		return err
+	}
+	if !p.keyword("AS") {
+		return p.errorf("expected AS in BIND")
+	}
+	v := p.next()
+	if v.kind != tokVar {
+		return p.errorf("expected a variable after AS")
+	}
+	g.Elements = append(g.Elements, &Bind{Expr: expr, Var: v.text[1:]})
+	return p.expect(")")
+}
+
+// constraint parses a FILTER condition: a bracketted expression or a
+// function call.
+func (p *parser) constraint() (Expr, error) {
+	if t := p.peek(); t.kind == tokPunct && t.text == "(" {
+		p.next()
+		expr, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return expr, p.expect(")")
+	}
+	return p.primary()
+}
+
+// triplesUntil parses triples with ';' and ',' abbreviations. It stops at
+// the punctuation end, which it consumes, or, with end empty, at anything
+// that cannot continue a triples block.
+func (p *parser) triplesUntil(end, graph string) ([]TriplePattern, error) {
+	var patterns []TriplePattern
+	for {
+		if end != "" && p.punct(end) {
+			return patterns, nil
+		}
+		subject, err := p.patternTerm(false)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			var pred string
+			if p.keyword("a") {
+				pred = rdfType
+			} else if pred, err = p.patternTerm(false); err != nil {
+				return nil, err
+			}
+			for {
+				obj, err := p.patternTerm(true)
+				if err != nil {
+					return nil, err
+				}
+				patterns = append(patterns, TriplePattern{Subject: subject, Predicate: pred, Object: obj, Graph: graph})
+				if !p.punct(",") {
+					break
+				}
+			}
+			if !p.punct(";") {
+				break
+			}
+			if t := p.peek(); t.kind == tokPunct && (t.text == "." || t.text == "}") {
+				break
+			}
+		}
+		if !p.punct(".") {
+			if end != "" {
+				return patterns, p.expect(end)
+			}
+			return patterns, nil
+		}
+		if t := p.peek(); end == "" && !(t.kind == tokVar || t.kind == tokIRI || t.kind == tokPName || t.kind == tokBlank) {
+			return patterns, nil
+		}
+	}
+}
+
+// patternTerm parses a variable, IRI, blank node or, as an object, a
+// literal.
+func (p *parser) patternTerm(object bool) (string, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokVar:
+		p.next()
+		return t.text, nil
+	case tokBlank:
+		p.next()
+		return blankVarPrefix + t.text[2:], nil
+	case tokIRI, tokPName:
+		return p.iri()
+	case tokString, tokNumber:
+		if object {
+			return p.literal()
+		}
+	case tokWord:
+		if object && (strings.EqualFold(t.text, "true") || strings.EqualFold(t.text, "false")) {
+			return p.literal()
+		}
+	case tokPunct:
+		if object && (t.text == "-" || t.text == "+") {
+			return p.literal()
+		}
+	}
+	return "", p.errorf("expected a term, found %s", p.describe())
+}
+
+func (p *parser) varOrIRI() (string, error) {
+	if t := p.peek(); t.kind == tokVar {
+		p.next()
+		return t.text, nil
+	}
+	return p.iri()
+}
+
+// iri parses an IRI or prefixed name into an N-Quads IRI term.
+func (p *parser) iri() (string, error) {
+	t := p.next()
+	switch t.kind {
+	case tokIRI:
+		return "<" + p.resolve(t.text) + ">", nil
+	case tokPName:
+		i := strings.Index(t.text, ":")
+		ns, ok := p.prefixes[t.text[:i]]
+		if !ok {
+			return "", &SyntaxError{t.line, fmt.Sprintf("undefined prefix %q", t.text[:i])}
+		}
+		return "<" + ns + t.text[i+1:] + ">", nil
+	}
+	p.pos--
+	return "", p.errorf("expected an IRI, found %s", p.describe())
+}
+
+func (p *parser) resolve(iri string) string {
+	if p.base == "" {
+		return iri
+	}
+	ref, err := url.Parse(iri)
+	if err != nil || ref.IsAbs() {
+		return iri
+	}
+	base, err := url.Parse(p.base)
+	if err != nil {
+		return iri
+	}
+	return base.ResolveReference(ref).String()
+}
+
+// literal parses a string, numeric or boolean literal.
+func (p *parser) literal() (string, error) {
+	t := p.next()
+	switch t.kind {
+	case tokString:
+		term := quoteLiteral(t.text)
+		if p.peek().kind == tokLangTag {
+			return term + "@" + p.next().text, nil
+		}
+		if p.punct("^^") {
+			dt, err := p.iri()
+			if err != nil {
+				return "", err
+			}
+			return term + "^^" + dt, nil
+		}
+		return term, nil
+	case tokNumber:
+		return numericLiteral(t.text), nil
+	case tokWord:
+		return `"` + strings.ToLower(t.text) + `"^^<` + xsdNS + `boolean>`, nil
+	case tokPunct:
+		n := p.next()
+		if n.kind != tokNumber {
+			return "", p.errorf("expected a number after %s", t.text)
+		}
+		return numericLiteral(strings.TrimPrefix(t.text, "+") + n.text), nil
+	}
+	return "", p.errorf("expected a literal")
+}
+
+// numericLiteral types a number token as SPARQL does.
+func numericLiteral(s string) string {
+	kind := "integer"
+	if strings.ContainsAny(s, "eE") {
+		kind = "double"
+	} else if strings.Contains(s, ".") {
+		kind = "decimal"
+	}
+	return `"` + s + `"^^<` + xsdNS + kind + `>`
+}
+
+// quoteLiteral renders a lexical form as an N-Quads string literal.
+func quoteLiteral(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`)
+	return `"` + r.Replace(s) + `"`
+}
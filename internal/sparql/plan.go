@@ -0,0 +1,215 @@
+package sparql
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// A planStep is one triple pattern of a BGP in evaluation order, with how
+// it is expected to be read.
+type planStep struct {
+	pattern TriplePattern
+	access  Access
+}
+
+// planBGP orders a block's patterns greedily: next is always the cheapest
+// pattern given the variables bound so far, preferring patterns that share
+// a variable with those before them so that the join never becomes a
+// cross product when it can be avoided.
+func planBGP(patterns []TriplePattern, bound map[string]bool, src Source) []planStep {
+	bound = copySet(bound)
+	remaining := append([]TriplePattern(nil), patterns...)
+	var steps []planStep
+	for len(remaining) > 0 {
+		best, bestConnected := -1, false
+		var bestAccess Access
+		for i, tp := range remaining {
+			access := estimate(tp, bound, src)
+			connected := len(bound) == 0 || sharesVar(tp, bound)
+			if best < 0 || connected && !bestConnected || connected == bestConnected && access.Rows < bestAccess.Rows {
+				best, bestConnected, bestAccess = i, connected, access
+			}
+		}
+		steps = append(steps, planStep{pattern: remaining[best], access: bestAccess})
+		addVars(bound, remaining[best])
+		remaining = append(remaining[:best], remaining[best+1:]...)
+	}
+	return steps
+}
+
+// estimate asks the source about a pattern with its bound variables shown
+// as Bound.
+func estimate(tp TriplePattern, bound map[string]bool, src Source) Access {
+	pos := func(term string) string {
+		if !IsVar(term) {
+			return term
+		}
+		if bound[term[1:]] {
+			return Bound
+		}
+		return ""
+	}
+	return src.Estimate(pos(tp.Subject), pos(tp.Predicate), pos(tp.Object), pos(tp.Graph))
+}
+
+func sharesVar(tp TriplePattern, bound map[string]bool) bool {
+	for _, t := range []string{tp.Subject, tp.Predicate, tp.Object, tp.Graph} {
+		if IsVar(t) && bound[t[1:]] {
+			return true
+		}
+	}
+	return false
+}
+
+// A PlanNode is one operator of a query plan.
+type PlanNode struct {
+	// Op names the operator: "scan", "optional", "union", "graph",
+	// "service", "bind", "filter", "group", "aggregate", "order",
+	// "distinct", "slice", "project", "construct" or "ask".
+	Op string `json:"op"`
+	// Detail describes the operator's arguments, e.g. a triple pattern.
+	Detail string `json:"detail,omitempty"`
+	// Access and Rows describe scans: how the source reads the pattern,
+	// and how many solutions are estimated to flow out of the step.
+	Access   *Access     `json:"access,omitempty"`
+	Rows     float64     `json:"rows,omitempty"`
+	Children []*PlanNode `json:"children,omitempty"`
+}
+
+// Explain returns the plan Evaluate follows for q over src, with the
+// source's cardinality estimates. The query is not run.
+func Explain(q *Query, src Source) *PlanNode {
+	root := &PlanNode{Op: strings.ToLower(q.Form)}
+	where, _ := explainGroup(q.Where, map[string]bool{}, 1, src)
+	root.Children = append(root.Children, where)
+	if aggregated(q) {
+		detail := "all solutions"
+		if len(q.GroupBy) > 0 {
+			detail = "by ?" + strings.Join(q.GroupBy, " ?")
+		}
+		root.Children = append(root.Children, &PlanNode{Op: "aggregate", Detail: detail})
+	}
+	if len(q.OrderBy) > 0 {
+		keys := make([]string, len(q.OrderBy))
+		for i, k := range q.OrderBy {
+			keys[i] = k.Expr.String()
+			if k.Descending {
+				keys[i] = "DESC(" + keys[i] + ")"
+			}
+		}
+		root.Children = append(root.Children, &PlanNode{Op: "order", Detail: strings.Join(keys, ", ")})
+	}
+	switch q.Form {
+	case "SELECT":
+		root.Children = append(root.Children, &PlanNode{Op: "project", Detail: "?" + strings.Join(projectedVars(q), " ?")})
+		if q.Distinct {
+			root.Children = append(root.Children, &PlanNode{Op: "distinct"})
+		}
+	case "CONSTRUCT":
+		root.Children = append(root.Children, &PlanNode{Op: "construct", Detail: fmt.Sprintf("%d template triple(s)", len(q.Template))})
+	}
+	if q.Offset > 0 || q.Limit >= 0 {
+		detail := fmt.Sprintf("offset %d", q.Offset)
+		if q.Limit >= 0 {
+			detail += fmt.Sprintf(", limit %d", q.Limit)
+		}
+		root.Children = append(root.Children, &PlanNode{Op: "slice", Detail: detail})
+	}
+	return root
+}
+
+// explainGroup plans a group for rows input solutions and returns the plan
+// with the estimated number of output solutions.
+func explainGroup(g *Group, bound map[string]bool, rows float64, src Source) (*PlanNode, float64) {
+	node := &PlanNode{Op: "group"}
+	bound = copySet(bound)
+	for _, el := range g.Elements {
+		switch el := el.(type) {
+		case *BGP:
+			for _, step := range planBGP(el.Patterns, bound, src) {
+				access := step.access
+				rows = rows * math.Max(access.Rows, 0)
+				node.Children = append(node.Children, &PlanNode{Op: "scan", Detail: formatPattern(step.pattern), Access: &access, Rows: rows})
+				addVars(bound, step.pattern)
+			}
+		case *Optional:
+			inner, out := explainGroup(el.Group, bound, rows, src)
+			rows = math.Max(rows, out)
+			node.Children = append(node.Children, &PlanNode{Op: "optional", Rows: rows, Children: []*PlanNode{inner}})
+		case *Union:
+			u := &PlanNode{Op: "union"}
+			total := 0.0
+			for _, alt := range el.Alternatives {
+				inner, out := explainGroup(alt, bound, rows, src)
+				u.Children = append(u.Children, inner)
+				total += out
+			}
+			rows, u.Rows = total, total
+			node.Children = append(node.Children, u)
+		case *Graph:
+			inner, out := explainGroup(el.Group, bound, rows, src)
+			rows = out
+			node.Children = append(node.Children, &PlanNode{Op: "graph", Detail: el.Name, Rows: rows, Children: []*PlanNode{inner}})
+			if IsVar(el.Name) {
+				bound[el.Name[1:]] = true
+			}
+		case *Group:
+			inner, out := explainGroup(el, bound, rows, src)
+			rows = out
+			node.Children = append(node.Children, inner)
+		case *Service:
+			detail := el.Endpoint
+			if el.Silent {
+				detail = "SILENT " + detail
+			}
+			node.Children = append(node.Children, &PlanNode{Op: "service", Detail: detail + " " + strings.TrimSpace(FormatGroup(el.Group))})
+		case *Bind:
+			node.Children = append(node.Children, &PlanNode{Op: "bind", Detail: el.Expr.String() + " AS ?" + el.Var})
+		}
+	}
+	for _, f := range g.Filters {
+		node.Children = append(node.Children, &PlanNode{Op: "filter", Detail: f.String()})
+	}
+	node.Rows = rows
+	return node, rows
+}
+
+func formatPattern(tp TriplePattern) string {
+	s := tp.Subject + " " + tp.Predicate + " " + tp.Object
+	if tp.Graph != "" {
+		s += " " + tp.Graph
+	}
+	return s
+}
+
+// String renders the plan as an indented tree.
+func (n *PlanNode) String() string {
+	var b strings.Builder
+	n.write(&b, "")
+	return b.String()
+}
+
+func (n *PlanNode) write(b *strings.Builder, indent string) {
+	b.WriteString(indent + n.Op)
+	if n.Detail != "" {
+		b.WriteString(" " + n.Detail)
+	}
+	if n.Access != nil {
+		fmt.Fprintf(b, "  [%s, ~%s per probe]", n.Access.Method, formatRows(n.Access.Rows))
+	}
+	if n.Rows > 0 || n.Op == "scan" || n.Op == "group" {
+		fmt.Fprintf(b, "  (~%s rows)", formatRows(n.Rows))
+	}
+	b.WriteString("\n")
+	for _, c := range n.Children {
+		c.write(b, indent+"  ")
+	}
+}
+
+func formatRows(n float64) string {
+	if n < 10 && n != math.Trunc(n) {
+		return fmt.Sprintf("%.1f", n)
+	}
+	return fmt.Sprintf("%.0f", n)
+}
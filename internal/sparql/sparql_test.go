@@ -0,0 +1,184 @@
+package sparql
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+)
+
+// memSource is a Source over quads in memory.
+type memSource []quadstore.Quad
+
+func (m memSource) Match(ctx context.Context, s, p, o, graph string, fn func(q quadstore.Quad) error) error {
+	for _, q := range m {
+		if (s == "" || q.Subject == s) && (p == "" || q.Predicate == p) && (o == "" || q.Object == o) && (graph == "" || q.Graph == graph) {
+			if err := fn(q); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m memSource) Estimate(s, p, o, graph string) Access {
+	return Access{Method: "scan", Rows: float64(len(m))}
+}
+
+// testData parses N-Quads lines of the form "s p o [g]".
+func testData(lines ...string) memSource {
+	var src memSource
+	for _, line := range lines {
+		f := strings.Fields(line)
+		q := quadstore.Quad{Subject: f[0], Predicate: f[1], Object: f[2]}
+		if len(f) > 3 {
+			q.Graph = f[3]
+		}
+		src = append(src, q)
+	}
+	return src
+}
+
+var people = testData(
+	`<ada> <name> "Ada"`,
+	`<ada> <age> "36"^^<http://www.w3.org/2001/XMLSchema#integer>`,
+	`<ada> <knows> <bob>`,
+	`<bob> <name> "Bob"`,
+	`<bob> <age> "29"^^<http://www.w3.org/2001/XMLSchema#integer>`,
+	`<cy> <name> "Cy" <g>`,
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name, query string
+		line        int
+	}{
+		{"no form", `DESCRIBE <ada>`, 1},
+		{"empty variable", "SELECT ? WHERE { ?s ?p ?o }", 1},
+		{"unclosed group", "SELECT ?s\nWHERE { ?s ?p ?o", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.query)
+			var syntax *SyntaxError
+			if !errors.As(err, &syntax) {
+				t.Fatalf("Parse(%q) = %v, want a *SyntaxError", tt.query, err)
+			}
+			if syntax.Line != tt.line {
+				t.Errorf("error on line %d, want %d: %v", syntax.Line, tt.line, err)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		name, query string
+		want        []string // Solutions, each as its values in Vars order
+	}{
+		{"basic pattern", `SELECT ?s WHERE { ?s <name> "Ada" }`, []string{"<ada>"}},
+		{"join", `SELECT ?n WHERE { <ada> <knows> ?f . ?f <name> ?n }`, []string{`"Bob"`}},
+		{"filter", `SELECT ?s WHERE { ?s <age> ?a FILTER(?a > 30) }`, []string{"<ada>"}},
+		{"optional", `SELECT ?s ?f WHERE { ?s <age> ?a OPTIONAL { ?s <knows> ?f } } ORDER BY ?s`,
+			[]string{"<ada> <bob>", "<bob> "}},
+		{"union", `SELECT ?s WHERE { { ?s <knows> ?x } UNION { ?x <knows> ?s } } ORDER BY ?s`,
+			[]string{"<ada>", "<bob>"}},
+		{"named graph", `SELECT ?n WHERE { GRAPH <g> { ?s <name> ?n } }`, []string{`"Cy"`}},
+		{"order and limit", `SELECT ?n WHERE { ?s <name> ?n } ORDER BY DESC(?n) LIMIT 2`,
+			[]string{`"Cy"`, `"Bob"`}},
+		{"offset", `SELECT ?n WHERE { ?s <name> ?n } ORDER BY ?n OFFSET 2`, []string{`"Cy"`}},
+		{"bind", `SELECT ?x WHERE { <bob> <name> ?n BIND(?n AS ?x) }`, []string{`"Bob"`}},
+		{"count", `SELECT (COUNT(?s) AS ?n) WHERE { ?s <name> ?x }`,
+			[]string{`"3"^^<http://www.w3.org/2001/XMLSchema#integer>`}},
+		{"no match", `SELECT ?s WHERE { ?s <name> "Dee" }`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := Parse(tt.query)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			res, err := Evaluate(context.Background(), q, people, Options{})
+			if err != nil {
+				t.Fatalf("Evaluate: %v", err)
+			}
+			var got []string
+			for _, b := range res.Solutions {
+				values := make([]string, len(res.Vars))
+				for i, v := range res.Vars {
+					values[i] = b[v]
+				}
+				got = append(got, strings.Join(values, " "))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateForms(t *testing.T) {
+	ask := func(query string) bool {
+		t.Helper()
+		q, err := Parse(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := Evaluate(context.Background(), q, people, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.Boolean
+	}
+	if !ask(`ASK { <ada> <knows> <bob> }`) {
+		t.Error("ASK of a present triple is false")
+	}
+	if ask(`ASK { <bob> <knows> <ada> }`) {
+		t.Error("ASK of an absent triple is true")
+	}
+
+	q, err := Parse(`CONSTRUCT { ?f <knownBy> ?s } WHERE { ?s <knows> ?f }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := Evaluate(context.Background(), q, people, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []quadstore.Quad{{Subject: "<bob>", Predicate: "<knownBy>", Object: "<ada>"}}
+	if !reflect.DeepEqual(res.Quads, want) {
+		t.Errorf("CONSTRUCT gave %v, want %v", res.Quads, want)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	q, err := Parse(`SELECT ?n WHERE { ?s <name> ?n } ORDER BY ?n LIMIT 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := Explain(q, people)
+	var ops []string
+	var walk func(n *PlanNode)
+	walk = func(n *PlanNode) {
+		ops = append(ops, n.Op)
+		for _, c := range n.Children {
+			walk(c)
+		}
+	}
+	walk(plan)
+	if plan.Op != "select" {
+		t.Errorf("root is %q, want select", plan.Op)
+	}
+	for _, op := range []string{"scan", "order", "slice"} {
+		found := false
+		for _, got := range ops {
+			found = found || got == op
+		}
+		if !found {
+			t.Errorf("plan %v has no %s step", ops, op)
+		}
+	}
+}
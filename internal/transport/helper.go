@@ -0,0 +1,202 @@
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/mannyrivera2010/go-quadgit/internal/rpc"
+)
+
+// The helper protocol
+//
+// A helper is started as `quad-db-remote-<scheme> <url>` with the
+// repository's working directory as its own. It exchanges one JSON value
+// per line: first it writes a HelperResponse carrying Version and
+// Capabilities, then it answers every HelperRequest read from stdin with
+// exactly one HelperResponse on stdout, until stdin is closed. Diagnostics
+// go to stderr, which is shown to the user.
+//
+// Requests use the operations "list", "fetch" (with Fetch set) and "push"
+// (with Push set). A failed operation is answered with Error set; Stale
+// additionally marks a push rejected because a ref moved.
+
+// HelperVersion is the protocol version spoken by this package.
+const HelperVersion = 1
+
+// HelperRequest is one operation sent to a helper.
+type HelperRequest struct {
+	Op    string            `json:"op"`
+	Fetch *rpc.FetchRequest `json:"fetch,omitempty"`
+	Push  *rpc.PushRequest  `json:"push,omitempty"`
+}
+
+// HelperResponse is a helper's greeting or its answer to one request.
+type HelperResponse struct {
+	Version      int                `json:"version,omitempty"`
+	Capabilities []string           `json:"capabilities,omitempty"`
+	Error        string             `json:"error,omitempty"`
+	Stale        bool               `json:"stale,omitempty"`
+	Refs         *rpc.Advertisement `json:"refs,omitempty"`
+	Pack         *rpc.Packfile      `json:"pack,omitempty"`
+}
+
+// helperTransport runs operations through a helper process.
+type helperTransport struct {
+	program      string
+	cmd          *exec.Cmd
+	stdin        io.WriteCloser
+	enc          *json.Encoder
+	dec          *json.Decoder
+	capabilities map[string]bool
+}
+
+// startHelper runs cmd and reads its greeting. program names it in errors.
+func startHelper(program string, cmd *exec.Cmd, opts Options) (*helperTransport, error) {
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	in, out := io.Writer(stdin), io.Reader(stdout)
+	if l := newLimiter(opts.LimitRate); l != nil {
+		in, out = limitedWriter{in, l}, limitedReader{out, l}
+	}
+	t := &helperTransport{
+		program:      program,
+		cmd:          cmd,
+		stdin:        stdin,
+		enc:          json.NewEncoder(in),
+		dec:          json.NewDecoder(out),
+		capabilities: make(map[string]bool),
+	}
+	var hello HelperResponse
+	if err := t.dec.Decode(&hello); err != nil {
+		t.Close()
+		return nil, fmt.Errorf("%s: no greeting: %v", program, err)
+	}
+	if hello.Version != HelperVersion {
+		t.Close()
+		return nil, fmt.Errorf("%s speaks protocol version %d, want %d", program, hello.Version, HelperVersion)
+	}
+	for _, c := range hello.Capabilities {
+		t.capabilities[c] = true
+	}
+	return t, nil
+}
+
+func (t *helperTransport) call(req HelperRequest) (*HelperResponse, error) {
+	if !t.capabilities[req.Op] {
+		return nil, permanent(fmt.Errorf("%s does not support %s", t.program, req.Op))
+	}
+	if err := t.enc.Encode(req); err != nil {
+		return nil, fmt.Errorf("%s: %v", t.program, err)
+	}
+	var resp HelperResponse
+	if err := t.dec.Decode(&resp); err != nil {
+		return nil, fmt.Errorf("%s: %v", t.program, err)
+	}
+	if resp.Error != "" {
+		if resp.Stale {
+			return nil, permanent(fmt.Errorf("%w: %s", rpc.ErrStaleRef, resp.Error))
+		}
+		return nil, permanent(errors.New(resp.Error))
+	}
+	return &resp, nil
+}
+
+func (t *helperTransport) List() (*rpc.Advertisement, error) {
+	resp, err := t.call(HelperRequest{Op: "list"})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Refs == nil {
+		return &rpc.Advertisement{Refs: map[string]string{}}, nil
+	}
+	return resp.Refs, nil
+}
+
+func (t *helperTransport) Fetch(req rpc.FetchRequest) (*rpc.Packfile, error) {
+	resp, err := t.call(HelperRequest{Op: "fetch", Fetch: &req})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Pack == nil {
+		return &rpc.Packfile{}, nil
+	}
+	return resp.Pack, nil
+}
+
+func (t *helperTransport) Push(req rpc.PushRequest) error {
+	_, err := t.call(HelperRequest{Op: "push", Push: &req})
+	return err
+}
+
+func (t *helperTransport) Abort() {
+	t.cmd.Process.Kill()
+}
+
+func (t *helperTransport) Close() error {
+	t.stdin.Close()
+	return t.cmd.Wait()
+}
+
+// ServeHelper implements the helper side of the protocol on top of t,
+// reading requests from r and writing responses to w until r is exhausted.
+// Only the operations listed in capabilities are offered; with none, all of
+// "list", "fetch" and "push" are. A helper written in Go only needs to
+// implement Transport for its scheme and call ServeHelper(os.Stdin,
+// os.Stdout, t).
+func ServeHelper(r io.Reader, w io.Writer, t Transport, capabilities ...string) error {
+	if len(capabilities) == 0 {
+		capabilities = []string{"list", "fetch", "push"}
+	}
+	offered := make(map[string]bool)
+	for _, c := range capabilities {
+		offered[c] = true
+	}
+	enc := json.NewEncoder(w)
+	dec := json.NewDecoder(r)
+	hello := HelperResponse{Version: HelperVersion, Capabilities: capabilities}
+	if err := enc.Encode(hello); err != nil {
+		return err
+	}
+	for {
+		var req HelperRequest
+		if err := dec.Decode(&req); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		var resp HelperResponse
+		var err error
+		switch {
+		case !offered[req.Op]:
+			err = fmt.Errorf("unsupported request %q", req.Op)
+		case req.Op == "list":
+			resp.Refs, err = t.List()
+		case req.Op == "fetch" && req.Fetch != nil:
+			resp.Pack, err = t.Fetch(*req.Fetch)
+		case req.Op == "push" && req.Push != nil:
+			err = t.Push(*req.Push)
+		default:
+			err = fmt.Errorf("unsupported request %q", req.Op)
+		}
+		if err != nil {
+			resp = HelperResponse{Error: err.Error(), Stale: errors.Is(err, rpc.ErrStaleRef)}
+		}
+		if err := enc.Encode(resp); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/mannyrivera2010/go-quadgit/internal/rpc"
+)
+
+// httpTransport talks to a repository served by 'quad-db serve'.
+type httpTransport struct {
+	url     string
+	client  *http.Client
+	limiter *limiter
+	token   string
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+func openHTTP(url string, opts Options) (Transport, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &httpTransport{
+		url:     strings.TrimSuffix(url, "/"),
+		client:  http.DefaultClient,
+		limiter: newLimiter(opts.LimitRate),
+		token:   opts.Token,
+		ctx:     ctx,
+		cancel:  cancel,
+	}, nil
+}
+
+// do sends a request and decodes a JSON response into out, if non-nil.
+func (t *httpTransport) do(method, path string, body, out interface{}) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+		if t.limiter != nil {
+			r = limitedReader{r, t.limiter}
+		}
+	}
+	req, err := http.NewRequestWithContext(t.ctx, method, t.url+path, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if t.token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.token)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		err := fmt.Errorf("remote returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+		if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return permanent(err)
+		}
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	in := io.Reader(resp.Body)
+	if t.limiter != nil {
+		in = limitedReader{in, t.limiter}
+	}
+	return json.NewDecoder(in).Decode(out)
+}
+
+func (t *httpTransport) List() (*rpc.Advertisement, error) {
+	var adv rpc.Advertisement
+	if err := t.do("GET", "/refs", nil, &adv); err != nil {
+		return nil, err
+	}
+	return &adv, nil
+}
+
+func (t *httpTransport) Fetch(req rpc.FetchRequest) (*rpc.Packfile, error) {
+	var pack rpc.Packfile
+	if err := t.do("POST", "/fetch", req, &pack); err != nil {
+		return nil, err
+	}
+	return &pack, nil
+}
+
+func (t *httpTransport) Push(req rpc.PushRequest) error {
+	return t.do("POST", "/push", req, nil)
+}
+
+func (t *httpTransport) Abort() {
+	t.cancel()
+}
+
+func (t *httpTransport) Close() error {
+	t.cancel()
+	return nil
+}
@@ -0,0 +1,39 @@
+package transport
+
+import "github.com/mannyrivera2010/go-quadgit/internal/rpc"
+
+// localTransport serves a repository in this process, applying pushes the
+// same way the HTTP server does.
+type localTransport struct {
+	handler    *rpc.Handler
+	identity   string
+	remoteAddr string
+}
+
+// Local returns a Transport backed directly by h's repository. Pushes are
+// made as identity, which the caller must have authenticated, and
+// remoteAddr identifies the client in the audit log of accepted pushes.
+func Local(h *rpc.Handler, identity, remoteAddr string) Transport {
+	return &localTransport{handler: h, identity: identity, remoteAddr: remoteAddr}
+}
+
+func (t *localTransport) List() (*rpc.Advertisement, error) {
+	refs, err := t.handler.Repo.ListRefs()
+	if err != nil {
+		return nil, err
+	}
+	return &rpc.Advertisement{Refs: refs}, nil
+}
+
+func (t *localTransport) Fetch(req rpc.FetchRequest) (*rpc.Packfile, error) {
+	return t.handler.Fetch(req)
+}
+
+func (t *localTransport) Push(req rpc.PushRequest) error {
+	_, err := t.handler.Receive(req, t.identity, t.remoteAddr)
+	return err
+}
+
+func (t *localTransport) Close() error {
+	return nil
+}
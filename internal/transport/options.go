@@ -0,0 +1,274 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mannyrivera2010/go-quadgit/internal/rpc"
+)
+
+// Options tune how a transport behaves on slow or unreliable networks. The
+// zero value disables retries, timeouts and rate limiting.
+type Options struct {
+	// Retries is how many times an operation that failed for a transient
+	// reason, such as a dropped connection, is attempted again.
+	Retries int
+	// Timeout bounds each operation; zero means no limit.
+	Timeout time.Duration
+	// LimitRate caps the bytes per second sent and received; zero means no
+	// limit.
+	LimitRate int64
+	// Notify, if set, is told about each retry.
+	Notify func(msg string)
+	// Token, if set, is sent to HTTP remotes as a bearer token.
+	Token string
+	// Context, if set, interrupts the operation in progress when it is
+	// canceled, and prevents further retries.
+	Context context.Context
+}
+
+// ErrTimeout is returned when an operation exceeds Options.Timeout.
+var ErrTimeout = errors.New("operation timed out")
+
+// permanentError marks a failure reported by the remote itself, such as a
+// rejected push, which retrying cannot fix.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// permanent wraps err, if non-nil, so that it is not retried.
+func permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+// aborter is implemented by transports that can interrupt an operation in
+// progress, leaving the transport unusable.
+type aborter interface {
+	Abort()
+}
+
+// retrying runs operations on a transport with timeouts and retries,
+// reconnecting after each transient failure.
+type retrying struct {
+	open  func() (Transport, error)
+	opts  Options
+	inner Transport
+}
+
+// retry runs fn on t's transport, retrying it as t's options allow.
+func retry[T any](t *retrying, op string, fn func(Transport) (T, error)) (T, error) {
+	ctx := t.context()
+	delay := time.Second
+	for tries := 0; ; tries++ {
+		v, err := attempt(t, ctx, fn)
+		var perm permanentError
+		if err == nil || errors.As(err, &perm) || tries >= t.opts.Retries || ctx.Err() != nil {
+			return v, err
+		}
+		if t.inner != nil {
+			t.inner.Close()
+			t.inner = nil
+		}
+		wait := delay + time.Duration(rand.Int63n(int64(delay)/2))
+		if t.opts.Notify != nil {
+			t.opts.Notify(fmt.Sprintf("%s failed (%v); retrying in %s", op, err, wait.Round(100*time.Millisecond)))
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			var zero T
+			return zero, ctx.Err()
+		}
+		if delay < 30*time.Second {
+			delay *= 2
+		}
+	}
+}
+
+func (t *retrying) context() context.Context {
+	if t.opts.Context != nil {
+		return t.opts.Context
+	}
+	return context.Background()
+}
+
+// attempt runs fn once, connecting first if need be. An attempt that times
+// out or is canceled is abandoned, and its result, if it ever comes, is
+// dropped.
+func attempt[T any](t *retrying, ctx context.Context, fn func(Transport) (T, error)) (T, error) {
+	var zero T
+	if t.inner == nil {
+		inner, err := t.open()
+		if err != nil {
+			return zero, err
+		}
+		t.inner = inner
+	}
+	if t.opts.Timeout <= 0 && ctx.Done() == nil {
+		return fn(t.inner)
+	}
+	type result struct {
+		v   T
+		err error
+	}
+	done := make(chan result, 1)
+	inner := t.inner
+	go func() {
+		v, err := fn(inner)
+		done <- result{v, err}
+	}()
+	var timeout <-chan time.Time
+	if t.opts.Timeout > 0 {
+		timer := time.NewTimer(t.opts.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	abort := func() {
+		if a, ok := inner.(aborter); ok {
+			a.Abort()
+		}
+		go inner.Close()
+		t.inner = nil
+	}
+	select {
+	case r := <-done:
+		return r.v, r.err
+	case <-timeout:
+		abort()
+		return zero, fmt.Errorf("%w after %s", ErrTimeout, t.opts.Timeout)
+	case <-ctx.Done():
+		abort()
+		return zero, ctx.Err()
+	}
+}
+
+func (t *retrying) List() (*rpc.Advertisement, error) {
+	return retry(t, "list", Transport.List)
+}
+
+func (t *retrying) Fetch(req rpc.FetchRequest) (*rpc.Packfile, error) {
+	return retry(t, "fetch", func(inner Transport) (*rpc.Packfile, error) {
+		return inner.Fetch(req)
+	})
+}
+
+// Push is safe to retry: objects are content-addressed, and ref updates are
+// compare-and-swap, so a push that did land is rejected as stale rather than
+// applied twice.
+func (t *retrying) Push(req rpc.PushRequest) error {
+	_, err := retry(t, "push", func(inner Transport) (struct{}, error) {
+		return struct{}{}, inner.Push(req)
+	})
+	return err
+}
+
+func (t *retrying) Close() error {
+	if t.inner == nil {
+		return nil
+	}
+	return t.inner.Close()
+}
+
+// limiter spreads transfers out so that they average at most rate bytes per
+// second.
+type limiter struct {
+	rate  int64
+	mu    sync.Mutex
+	start time.Time
+	bytes int64
+}
+
+func newLimiter(rate int64) *limiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &limiter{rate: rate, start: time.Now()}
+}
+
+// wait accounts for n bytes, sleeping until they fit within the rate.
+func (l *limiter) wait(n int) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.bytes += int64(n)
+	due := l.start.Add(time.Duration(float64(l.bytes) / float64(l.rate) * float64(time.Second)))
+	l.mu.Unlock()
+	if d := time.Until(due); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// chunk bounds single reads and writes through a limiter, keeping the
+// transfer smooth instead of bursting whole buffers.
+func (l *limiter) chunk() int {
+	return int(max(l.rate/10, 512))
+}
+
+type limitedReader struct {
+	r io.Reader
+	l *limiter
+}
+
+func (r limitedReader) Read(p []byte) (int, error) {
+	if len(p) > r.l.chunk() {
+		p = p[:r.l.chunk()]
+	}
+	n, err := r.r.Read(p)
+	r.l.wait(n)
+	return n, err
+}
+
+type limitedWriter struct {
+	w io.Writer
+	l *limiter
+}
+
+func (w limitedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), w.l.chunk())
+		w.l.wait(n)
+		m, err := w.w.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// ParseRate parses a transfer rate in bytes per second, with an optional
+// k, m or g suffix for multiples of 1024, as in "500k".
+func ParseRate(rate string) (int64, error) {
+	s := strings.ToLower(strings.TrimSpace(rate))
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(s, "k"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(s, "m"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(s, "g"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid rate %q (use e.g. 500k or 2m)", rate)
+	}
+	return int64(n * float64(multiplier)), nil
+}
@@ -0,0 +1,114 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mannyrivera2010/go-quadgit/internal/rpc"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		rate    string
+		want    int64
+		wantErr bool
+	}{
+		{rate: "100", want: 100},
+		{rate: "500k", want: 500 << 10},
+		{rate: "2M", want: 2 << 20},
+		{rate: "1.5g", want: 3 << 29},
+		{rate: " 0 ", want: 0},
+		{rate: "", wantErr: true},
+		{rate: "k", wantErr: true},
+		{rate: "-1k", wantErr: true},
+		{rate: "fast", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRate(tt.rate)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseRate(%q) = %d, %v, want %d (error %v)", tt.rate, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// flakyTransport fails its first failures operations with err, or hangs
+// for hang first.
+type flakyTransport struct {
+	failures int
+	err      error
+	hang     time.Duration
+	calls    *atomic.Int32
+}
+
+func (f *flakyTransport) List() (*rpc.Advertisement, error) {
+	call := f.calls.Add(1)
+	time.Sleep(f.hang)
+	if int(call) <= f.failures {
+		return nil, f.err
+	}
+	return &rpc.Advertisement{}, nil
+}
+
+func (f *flakyTransport) Fetch(req rpc.FetchRequest) (*rpc.Packfile, error) { return nil, nil }
+func (f *flakyTransport) Push(req rpc.PushRequest) error                    { return nil }
+func (f *flakyTransport) Close() error                                      { return nil }
+
+func TestRetrying(t *testing.T) {
+	transient := errors.New("connection reset")
+	tests := []struct {
+		name     string
+		flaky    flakyTransport
+		opts     Options
+		wantErr  error
+		calls    int
+		notified int
+	}{
+		{name: "no retries", flaky: flakyTransport{failures: 1, err: transient}, wantErr: transient, calls: 1},
+		{name: "transient failure retried", flaky: flakyTransport{failures: 1, err: transient}, opts: Options{Retries: 2}, calls: 2, notified: 1},
+		{name: "permanent failure", flaky: flakyTransport{failures: 1, err: permanent(transient)}, opts: Options{Retries: 2}, wantErr: transient, calls: 1},
+		{name: "timeout", flaky: flakyTransport{hang: time.Second}, opts: Options{Timeout: 10 * time.Millisecond}, wantErr: ErrTimeout, calls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			opened, notified := 0, 0
+			tt.opts.Notify = func(string) { notified++ }
+			r := &retrying{opts: tt.opts, open: func() (Transport, error) {
+				opened++
+				f := tt.flaky
+				f.calls = &calls
+				return &f, nil
+			}}
+			_, err := r.List()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("List: %v, want %v", err, tt.wantErr)
+			}
+			if int(calls.Load()) != tt.calls || notified != tt.notified {
+				t.Errorf("%d attempt(s), %d notification(s), want %d and %d", calls.Load(), notified, tt.calls, tt.notified)
+			}
+			if opened != tt.calls {
+				t.Errorf("opened %d connection(s), want one per attempt", opened)
+			}
+		})
+	}
+}
+
+func TestLimitRate(t *testing.T) {
+	var buf bytes.Buffer
+	l := newLimiter(10 << 10)
+	start := time.Now()
+	n, err := limitedWriter{w: &buf, l: l}.Write(make([]byte, 3<<10))
+	if err != nil || n != 3<<10 {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	// 3 KiB at 10 KiB/s takes about 300ms.
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("3 KiB written in %s at 10 KiB/s", elapsed)
+	}
+	if newLimiter(0) != nil {
+		t.Error("a zero rate is limited")
+	}
+}
@@ -0,0 +1,147 @@
+package transport
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/mannyrivera2010/go-quadgit/internal/rpc"
+)
+
+// The ssh transport logs in to the remote host and runs
+// `quad-db upload-pack <path>` to list and fetch, or
+// `quad-db receive-pack <path>` to push. Both speak the helper protocol over
+// the session's stdin and stdout, so the remote side needs nothing but a
+// quad-db binary on its PATH. QUAD_DB_SSH replaces the ssh command, e.g. to
+// pass an identity file.
+type sshTransport struct {
+	argv     []string // ssh command, "--" and destination, without the remote command
+	path     string
+	opts     Options
+	mu       sync.Mutex // guards sessions against Abort
+	sessions map[string]*helperTransport
+}
+
+func openSSH(rawURL string, opts Options) (Transport, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, permanent(err)
+	}
+	if u.Hostname() == "" || u.Path == "" {
+		return nil, permanent(fmt.Errorf("%s: expected ssh://[user@]host[:port]/path", rawURL))
+	}
+	argv := []string{"ssh"}
+	if custom := strings.Fields(os.Getenv("QUAD_DB_SSH")); len(custom) > 0 {
+		argv = custom
+	}
+	if port := u.Port(); port != "" {
+		argv = append(argv, "-p", port)
+	}
+	// ssh would take a host or user starting with "-" for an option, such
+	// as -oProxyCommand=..., and run whatever it names.
+	host := u.Hostname()
+	if strings.HasPrefix(host, "-") {
+		return nil, permanent(fmt.Errorf("%s: invalid host %q", rawURL, host))
+	}
+	if u.User != nil {
+		if strings.HasPrefix(u.User.Username(), "-") {
+			return nil, permanent(fmt.Errorf("%s: invalid user %q", rawURL, u.User.Username()))
+		}
+		host = u.User.Username() + "@" + host
+	}
+	argv = append(argv, "--", host)
+	// A leading "/~" names a path relative to the remote home directory.
+	path := strings.TrimPrefix(u.Path, "/~/")
+	return &sshTransport{argv: argv, path: path, opts: opts, sessions: make(map[string]*helperTransport)}, nil
+}
+
+// session returns a connection to the named remote service, starting it on
+// first use. Other sessions are closed first: each holds the remote
+// repository open, which excludes any other process.
+func (t *sshTransport) session(service string) (*helperTransport, error) {
+	t.mu.Lock()
+	if s := t.sessions[service]; s != nil {
+		t.mu.Unlock()
+		return s, nil
+	}
+	var others []*helperTransport
+	for name, s := range t.sessions {
+		others = append(others, s)
+		delete(t.sessions, name)
+	}
+	t.mu.Unlock()
+	for _, s := range others {
+		if err := s.Close(); err != nil {
+			return nil, err
+		}
+	}
+	remote := "quad-db " + service + " " + shellQuote(t.path)
+	args := append(append([]string(nil), t.argv[1:]...), remote)
+	s, err := startHelper("ssh "+service, exec.Command(t.argv[0], args...), t.opts)
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	t.sessions[service] = s
+	t.mu.Unlock()
+	return s, nil
+}
+
+func (t *sshTransport) List() (*rpc.Advertisement, error) {
+	// Both services advertise refs; prefer one that is already connected.
+	service := "upload-pack"
+	t.mu.Lock()
+	if t.sessions["receive-pack"] != nil {
+		service = "receive-pack"
+	}
+	t.mu.Unlock()
+	s, err := t.session(service)
+	if err != nil {
+		return nil, err
+	}
+	return s.List()
+}
+
+func (t *sshTransport) Fetch(req rpc.FetchRequest) (*rpc.Packfile, error) {
+	s, err := t.session("upload-pack")
+	if err != nil {
+		return nil, err
+	}
+	return s.Fetch(req)
+}
+
+func (t *sshTransport) Push(req rpc.PushRequest) error {
+	s, err := t.session("receive-pack")
+	if err != nil {
+		return err
+	}
+	return s.Push(req)
+}
+
+func (t *sshTransport) Abort() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, s := range t.sessions {
+		s.Abort()
+	}
+}
+
+func (t *sshTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var first error
+	for _, s := range t.sessions {
+		if err := s.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// shellQuote quotes s for the remote login shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
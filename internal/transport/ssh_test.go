@@ -0,0 +1,40 @@
+package transport
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOpenSSH(t *testing.T) {
+	t.Setenv("QUAD_DB_SSH", "")
+	tests := []struct {
+		url      string
+		wantArgv []string
+		wantPath string
+		wantErr  bool
+	}{
+		{"ssh://example.org/srv/data", []string{"ssh", "--", "example.org"}, "/srv/data", false},
+		{"ssh://ada@example.org:2222/~/data", []string{"ssh", "-p", "2222", "--", "ada@example.org"}, "data", false},
+		{"ssh://-oProxyCommand=x/data", nil, "", true},
+		{"ssh://-oProxyCommand=x@example.org/data", nil, "", true},
+		{"ssh://example.org", nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			tr, err := openSSH(tt.url, Options{})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("openSSH(%q) = %v, want an error", tt.url, tr.(*sshTransport).argv)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("openSSH(%q): %v", tt.url, err)
+			}
+			st := tr.(*sshTransport)
+			if !reflect.DeepEqual(st.argv, tt.wantArgv) || st.path != tt.wantPath {
+				t.Errorf("openSSH(%q) = %q %q, want %q %q", tt.url, st.argv, st.path, tt.wantArgv, tt.wantPath)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+// Package transport moves refs and objects between repositories for clone,
+// fetch and push. http://, https:// and ssh:// remotes are handled
+// natively. Any other scheme is delegated to a helper program named
+// quad-db-remote-<scheme> found on PATH, which speaks the protocol
+// described in helper.go; this lets third parties add schemes such as s3://
+// or ipfs:// without changes to quad-db itself.
+package transport
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/mannyrivera2010/go-quadgit/internal/rpc"
+)
+
+// Transport is a connection to one remote repository.
+type Transport interface {
+	// List returns the refs the remote holds.
+	List() (*rpc.Advertisement, error)
+	// Fetch returns the objects reachable from req.Wants that are not
+	// reachable from req.Haves.
+	Fetch(req rpc.FetchRequest) (*rpc.Packfile, error)
+	// Push sends objects and applies ref updates on the remote.
+	Push(req rpc.PushRequest) error
+	// Close releases the connection.
+	Close() error
+}
+
+// An Opener connects to the remote repository at url. Openers apply
+// opts.LimitRate themselves; retries and timeouts are handled by Open.
+type Opener func(url string, opts Options) (Transport, error)
+
+var (
+	mu      sync.Mutex
+	openers = map[string]Opener{
+		"http":  openHTTP,
+		"https": openHTTP,
+		"ssh":   openSSH,
+	}
+)
+
+// Register makes a built-in transport available for a URL scheme, taking
+// precedence over helper programs.
+func Register(scheme string, open Opener) {
+	mu.Lock()
+	defer mu.Unlock()
+	openers[scheme] = open
+}
+
+// HelperProgram returns the name of the helper program for a scheme.
+func HelperProgram(scheme string) string {
+	return "quad-db-remote-" + scheme
+}
+
+// Open returns a transport for the remote repository at url, chosen by its
+// scheme. The connection is made by the first operation.
+func Open(url string, opts Options) (Transport, error) {
+	scheme, _, ok := strings.Cut(url, "://")
+	if !ok {
+		return nil, fmt.Errorf("%s is not a remote URL", url)
+	}
+	mu.Lock()
+	open := openers[scheme]
+	mu.Unlock()
+	if open == nil {
+		program := HelperProgram(scheme)
+		if _, err := exec.LookPath(program); err != nil {
+			return nil, fmt.Errorf("no transport for %s:// remotes (install %s on PATH)", scheme, program)
+		}
+		open = func(url string, opts Options) (Transport, error) {
+			t, err := startHelper(program, exec.Command(program, url), opts)
+			if err != nil {
+				return nil, err
+			}
+			return t, nil
+		}
+	}
+	return &retrying{open: func() (Transport, error) { return open(url, opts) }, opts: opts}, nil
+}
@@ -0,0 +1,65 @@
+// interrupt.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/spf13/cobra"
+)
+
+// Every command runs with a context that the first SIGINT or SIGTERM
+// cancels. Commands that can stop part way, such as clone, import and
+// diff, call commandContext to say they watch it: they roll back what they
+// had started, close the database so its lock is released, and exit with
+// interruptedStatus. Any other command is ended at once, as without a
+// handler, since it has nothing to clean up or finishes quickly. A second
+// signal always ends the process at once.
+
+// interruptedStatus is the exit status of a command stopped by a signal,
+// as shells report SIGINT.
+const interruptedStatus = 130
+
+// cancelable is set once the running command watches its context.
+var cancelable atomic.Bool
+
+// signalContext returns the context commands run with.
+func signalContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		if !cancelable.Load() {
+			os.Exit(interruptedStatus)
+		}
+		fmt.Fprintln(os.Stderr, "\nInterrupted; stopping (interrupt again to stop at once)")
+		cancel()
+		<-sigs
+		os.Exit(interruptedStatus)
+	}()
+	return ctx
+}
+
+// commandContext returns the context of cmd, marking the command as one
+// that stops cleanly when it is canceled.
+func commandContext(cmd *cobra.Command) context.Context {
+	cancelable.Store(true)
+	return cmd.Context()
+}
+
+// exitIfInterrupted ends a command whose context was canceled, after
+// closing the database, and otherwise does nothing. what describes the
+// state the command leaves behind.
+func exitIfInterrupted(ctx context.Context, what string) {
+	if ctx.Err() == nil {
+		return
+	}
+	closeDB()
+	fmt.Fprintf(os.Stderr, "Interrupted; %s.\n", what)
+	os.Exit(interruptedStatus)
+}
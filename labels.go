@@ -0,0 +1,109 @@
+// labels.go
+package main
+
+import (
+	"strings"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/rdfio"
+)
+
+const rdfsLabel = "<http://www.w3.org/2000/01/rdf-schema#label>"
+
+// termLabels reads the rdfs:label of each term the ontology graphs of a
+// commit label (see ontologyScopes), or that any graph labels if none are
+// configured. A label without a language tag, or in English, is preferred
+// over others; among equals the one sorting first wins, so that the choice
+// is stable.
+func termLabels(commitHash string) (map[string]string, error) {
+	scopes, err := ontologyScopes()
+	if err != nil {
+		return nil, err
+	}
+	commit, err := readCommit(commitHash)
+	if err != nil {
+		return nil, err
+	}
+	graphs, err := readGraphsUnder(commit.Tree, "")
+	if err != nil {
+		return nil, err
+	}
+	labels := make(map[string]string)
+	preferred := make(map[string]bool)
+	for graph, blobHash := range graphs {
+		inScope := len(scopes) == 0
+		for _, scope := range scopes {
+			inScope = inScope || graphInScope(graph, scope)
+		}
+		if !inScope {
+			continue
+		}
+		blob, err := readBlob(blobHash)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range blob {
+			if !strings.Contains(line, rdfsLabel) {
+				continue
+			}
+			q, err := parseQuad(line)
+			if err != nil || q.Predicate != rdfsLabel {
+				continue
+			}
+			label, lang, _, err := rdfio.LiteralParts(q.Object)
+			if err != nil || label == "" {
+				continue
+			}
+			better := lang == "" || strings.EqualFold(lang, "en")
+			current, ok := labels[q.Subject]
+			if !ok || better && !preferred[q.Subject] || better == preferred[q.Subject] && label < current {
+				labels[q.Subject] = label
+				preferred[q.Subject] = better
+			}
+		}
+	}
+	return labels, nil
+}
+
+// labelLine renders a changed statement for diff --use-labels, putting the
+// labels of its predicate and, for rdf:type statements, its class in place
+// of their IRIs, in brackets to set them apart from IRIs and literals, as in
+// "<http://example.org/x> [Name] "Ada" .". Lines without labelled terms are
+// left as they are.
+func labelLine(line string, labels map[string]string) string {
+	q, err := parseQuad(line)
+	if err != nil {
+		return line
+	}
+	predicate, object := q.Predicate, q.Object
+	if label, ok := labels[predicate]; ok {
+		predicate = "[" + label + "]"
+	}
+	if q.Predicate == rdfType {
+		if label, ok := labels[object]; ok {
+			object = "[" + label + "]"
+		}
+	}
+	if predicate == q.Predicate && object == q.Object {
+		return line
+	}
+	parts := []string{q.Subject, predicate, object}
+	if q.Graph != "" {
+		parts = append(parts, q.Graph)
+	}
+	return strings.Join(parts, " ") + " ."
+}
+
+// labelDiffs returns diffs with each line rendered by labelLine.
+func labelDiffs(diffs []graphDiff, labels map[string]string) []graphDiff {
+	labelled := make([]graphDiff, len(diffs))
+	for i, d := range diffs {
+		labelled[i] = graphDiff{Graph: d.Graph, RenamedFrom: d.RenamedFrom}
+		for _, line := range d.Added {
+			labelled[i].Added = append(labelled[i].Added, labelLine(line, labels))
+		}
+		for _, line := range d.Deleted {
+			labelled[i].Deleted = append(labelled[i].Deleted, labelLine(line, labels))
+		}
+	}
+	return labelled
+}
@@ -0,0 +1,259 @@
+// lint.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+const xsd = "http://www.w3.org/2001/XMLSchema#"
+
+// numericTypes are the XSD datatypes compared by value in near-duplicate
+// detection.
+var numericTypes = map[string]bool{
+	"integer": true, "decimal": true, "double": true, "float": true,
+	"int": true, "long": true, "short": true, "byte": true,
+	"nonNegativeInteger": true, "positiveInteger": true,
+	"nonPositiveInteger": true, "negativeInteger": true,
+	"unsignedInt": true, "unsignedLong": true, "unsignedShort": true, "unsignedByte": true,
+}
+
+// literalParts splits a literal term into its lexical form (unescaped),
+// language tag and datatype IRI.
+func literalParts(term string) (lexical, lang, datatype string) {
+	end := strings.LastIndex(term, `"`)
+	if end <= 0 {
+		return term, "", ""
+	}
+	lexical = literalValue(term)
+	rest := term[end+1:]
+	switch {
+	case strings.HasPrefix(rest, "@"):
+		lang = rest[1:]
+	case strings.HasPrefix(rest, "^^"):
+		datatype = strings.Trim(rest[2:], "<>")
+	}
+	return lexical, lang, datatype
+}
+
+// nearKey returns the form of an object term that near-duplicates share:
+// literals compare with whitespace collapsed and case folded, language tags
+// case-insensitively, xsd:string as a plain literal, and numbers and
+// booleans by value. Other terms are returned unchanged.
+func nearKey(term string) string {
+	if !strings.HasPrefix(term, `"`) {
+		return term
+	}
+	lexical, lang, datatype := literalParts(term)
+	lexical = strings.Join(strings.Fields(lexical), " ")
+	if datatype == xsd+"string" {
+		datatype = ""
+	}
+	if local := strings.TrimPrefix(datatype, xsd); local != datatype {
+		if numericTypes[local] {
+			if f, err := strconv.ParseFloat(lexical, 64); err == nil {
+				return "number:" + strconv.FormatFloat(f, 'g', -1, 64)
+			}
+		}
+		if local == "boolean" {
+			if b, err := strconv.ParseBool(lexical); err == nil {
+				return "boolean:" + strconv.FormatBool(b)
+			}
+		}
+	}
+	return fmt.Sprintf("%q@%s^^%s", strings.ToLower(lexical), strings.ToLower(lang), datatype)
+}
+
+// A nearDuplicate is a set of quads in one graph that differ only in the
+// form of their object.
+type nearDuplicate struct {
+	Graph string
+	Lines []string // sorted; Lines[Keep] is the variant a cleanup keeps
+	Keep  int
+}
+
+// findDuplicates reports, for a state, the triples stored in more than one
+// graph (triple line to sorted graphs) and the near-duplicate groups within
+// each graph.
+func findDuplicates(state map[string]quadSet) (map[string][]string, []nearDuplicate) {
+	exact := make(map[string][]string)
+	var near []nearDuplicate
+	graphs := make([]string, 0, len(state))
+	for graph := range state {
+		graphs = append(graphs, graph)
+	}
+	sort.Strings(graphs)
+	for _, graph := range graphs {
+		groups := make(map[string][]string)
+		for line := range state[graph] {
+			exact[line] = append(exact[line], graph)
+			q, err := parseQuad(line)
+			if err != nil {
+				continue
+			}
+			key := q.Subject + " " + q.Predicate + " " + nearKey(q.Object)
+			groups[key] = append(groups[key], line)
+		}
+		for _, lines := range groups {
+			if len(lines) < 2 {
+				continue
+			}
+			sort.Strings(lines)
+			near = append(near, nearDuplicate{Graph: graph, Lines: lines, Keep: preferredVariant(lines)})
+		}
+	}
+	for line, in := range exact {
+		if len(in) < 2 {
+			delete(exact, line)
+		}
+	}
+	sort.Slice(near, func(i, j int) bool {
+		if near[i].Graph != near[j].Graph {
+			return near[i].Graph < near[j].Graph
+		}
+		return near[i].Lines[0] < near[j].Lines[0]
+	})
+	return exact, near
+}
+
+// preferredVariant picks the variant a cleanup keeps: one without stray
+// whitespace if possible, then the shortest, then the first.
+func preferredVariant(lines []string) int {
+	best, bestScore := 0, -1
+	for i, line := range lines {
+		q, err := parseQuad(line)
+		if err != nil {
+			continue
+		}
+		lexical, _, _ := literalParts(q.Object)
+		score := 1 << 20
+		if lexical != strings.Join(strings.Fields(lexical), " ") {
+			score = 0
+		}
+		score -= len(line)
+		if score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
+
+// stageGraphs replaces whatever the index holds for the given graphs with
+// their complete content, leaving staged lines for other graphs untouched.
+func stageGraphs(graphs map[string]quadSet) error {
+	content, err := os.ReadFile(indexPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var lines []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if q, err := parseQuad(line); err == nil {
+			if _, replaced := graphs[graphKey(q)]; replaced {
+				continue
+			}
+		}
+		lines = append(lines, line)
+	}
+	names := make([]string, 0, len(graphs))
+	for graph := range graphs {
+		names = append(names, graph)
+	}
+	sort.Strings(names)
+	for _, graph := range names {
+		lines = append(lines, graphQuads(graph, graphs[graph])...)
+	}
+	return os.WriteFile(indexPath, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+var lintDuplicatesCmd = &cobra.Command{
+	Use:   "duplicates [<commit>]",
+	Short: "Report duplicate and near-duplicate quads",
+	Long: `Report triples stored in more than one graph, and quads within a graph
+whose objects differ only in literal whitespace, case, or datatype form
+(e.g. "Foo" and "foo ", or "1"^^xsd:integer and "1.0"^^xsd:decimal).
+
+Without a commit, the state the next commit would record (HEAD plus the
+index) is checked. With --stage, each near-duplicate group is reduced to a
+single variant and the affected graphs are staged; triples repeated across
+graphs are only reported, since that is often intended.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		stage, _ := cmd.Flags().GetBool("stage")
+		var state map[string]quadSet
+		var hash string
+		var err error
+		if len(args) == 1 {
+			hash, err = resolveCommitish(args[0])
+			if err != nil {
+				log.Fatalf("Could not resolve %s: %v", args[0], err)
+			}
+			if stage {
+				log.Fatal("--stage only applies to the index; omit the commit.")
+			}
+			state, err = loadState(hash)
+		} else {
+			_, state, err = indexState()
+		}
+		if err != nil {
+			log.Fatalf("Failed to read quads: %v", err)
+		}
+
+		exact, near := findDuplicates(state)
+		lines := make([]string, 0, len(exact))
+		for line := range exact {
+			lines = append(lines, line)
+		}
+		sort.Strings(lines)
+		for _, line := range lines {
+			fmt.Printf("duplicate %s\n", line)
+			for _, graph := range exact[line] {
+				fmt.Printf("\tin %s\n", graph)
+			}
+		}
+		for _, n := range near {
+			fmt.Printf("near-duplicates in %s\n", n.Graph)
+			for i, line := range n.Lines {
+				mark := " "
+				if i == n.Keep {
+					mark = "*"
+				}
+				fmt.Printf("\t%s %s\n", mark, line)
+			}
+		}
+		fmt.Printf("%d duplicate triple(s) across graphs, %d near-duplicate group(s)\n", len(exact), len(near))
+
+		if stage && len(near) > 0 {
+			cleaned := make(map[string]quadSet)
+			for _, n := range near {
+				if cleaned[n.Graph] == nil {
+					cleaned[n.Graph] = make(quadSet, len(state[n.Graph]))
+					for line := range state[n.Graph] {
+						cleaned[n.Graph][line] = true
+					}
+				}
+				for i, line := range n.Lines {
+					if i != n.Keep {
+						delete(cleaned[n.Graph], line)
+					}
+				}
+			}
+			if err := stageGraphs(cleaned); err != nil {
+				log.Fatalf("Failed to stage cleanup: %v", err)
+			}
+			fmt.Printf("Staged cleanup of %d graph(s); review with 'quad-db diff --staged'.\n", len(cleaned))
+			return
+		}
+		if len(exact) > 0 || len(near) > 0 {
+			os.Exit(1)
+		}
+	},
+}
@@ -2,19 +2,21 @@
 package main
 
 import (
+	"context"
 	"crypto/sha1"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 
-	"github.com/dgraph-io/badger/v4"
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
 	"github.com/spf13/cobra"
 )
 
@@ -27,10 +29,59 @@ type Commit struct {
 	Author    string    `json:"author"`
 	Message   string    `json:"message"`
 	Timestamp time.Time `json:"timestamp"`
+	Signature string    `json:"signature,omitempty"` // Detached, ASCII-armored PGP signature
+
+	// Renames records graphs moved by this commit, old name to new name, so
+	// history can follow a graph across renames.
+	Renames map[string]string `json:"renames,omitempty"`
+
+	// Stats holds change counts against the first parent, computed when the
+	// commit is created so log --stat need not re-diff history.
+	Stats *quadstore.CommitStats `json:"stats,omitempty"`
+
+	// Metadata holds arbitrary key/value pairs, such as a pipeline run ID,
+	// indexed so that commits can be found by them (see metadata.go).
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// A Tag is an annotated tag: a message about an object, usually a commit,
+// stored as an object of its own so that its hash covers the tagger,
+// the time and the message as well as what it tags.
+type Tag struct {
+	Object    string    `json:"object"` // SHA-1 hash of the tagged object
+	Type      string    `json:"type"`   // "commit", or "tag" for a tag of a tag
+	Name      string    `json:"tag"`
+	Tagger    string    `json:"tagger"`
+	Message   string    `json:"message"`
+	Timestamp time.Time `json:"timestamp"`
+	Signature string    `json:"signature,omitempty"` // Detached, ASCII-armored PGP signature
 }
 
-// A Tree is simplified to map a graph name to a blob hash containing its quads.
-type Tree map[string]string
+// A Tree maps one segment of a graph name to its entry. Graph IRIs are split
+// on '/', so graphs sharing a path prefix share a subtree (see tree.go).
+type Tree map[string]TreeEntry
+
+// A TreeEntry holds the blob of the graph named by the path so far, a nested
+// tree of longer graph names, or both. Entries with a blob also record its
+// summary (see graphSummary); trees written before that have none.
+type TreeEntry struct {
+	Blob     string `json:"blob,omitempty"`     // SHA-1 hash of the graph's blob
+	Tree     string `json:"tree,omitempty"`     // SHA-1 hash of a child tree
+	Checksum string `json:"checksum,omitempty"` // SHA-256 of the blob's statements
+	Quads    int    `json:"quads,omitempty"`    // Number of statements in the blob
+}
+
+// UnmarshalJSON also accepts the original flat encoding, where an entry was
+// just the blob hash of a whole graph.
+func (e *TreeEntry) UnmarshalJSON(data []byte) error {
+	var blob string
+	if err := json.Unmarshal(data, &blob); err == nil {
+		*e = TreeEntry{Blob: blob}
+		return nil
+	}
+	type entry TreeEntry
+	return json.Unmarshal(data, (*entry)(e))
+}
 
 // A Blob is simply the content; in our case, a list of quad strings.
 type Blob []string
@@ -38,93 +89,161 @@ type Blob []string
 // --- 2. STORE ---
 // Manages all interaction with the BadgerDB database.
 
-const (
-	dbPath    = ".quad-db"
+const dbPath = ".quad-db"
+
+// storePath is the Badger directory of the selected namespace, and
+// indexPath and renamesPath its staging area; see selectNamespace.
+var (
+	storePath = dbPath
 	indexPath = ".quad-db/index"
-)
 
-var db *badger.DB
+	// renamesPath holds graph renames staged by mv, as a JSON object.
+	renamesPath = ".quad-db/index.renames"
 
-// openDB opens the BadgerDB database in the .quad-db directory.
-func openDB() (*badger.DB, error) {
-	if db != nil {
-		return db, nil
+	// mergeStatePath and mergeConflictsPath hold a merge stopped at
+	// conflicts; see mergeconflicts.go.
+	mergeStatePath     = ".quad-db/MERGE_STATE"
+	mergeConflictsPath = ".quad-db/MERGE_CONFLICTS"
+)
+
+// openDB opens the repository of the selected namespace as repo.
+func openDB() (*Repository, error) {
+	if repo != nil {
+		return repo, nil
+	}
+	r, err := openRepository(storePath)
+	if err != nil {
+		return nil, err
 	}
-	opts := badger.DefaultOptions(dbPath).WithLogger(nil) // Suppress Badger logger
-	var err error
-	db, err = badger.Open(opts)
-	return db, err
+	repo = r
+	return repo, nil
 }
 
 // closeDB closes the database connection.
 func closeDB() {
-	if db != nil {
-		db.Close()
+	promisor.close()
+	alternate.close()
+	if repo != nil {
+		repo.Close()
+		repo = nil
 	}
 }
 
-// writeObject serializes an object (Commit, Tree), computes its hash,
-// and saves it to the database.
+// writeObject stores an object in repo; see Repository.writeObject.
 func writeObject(obj interface{}) (string, error) {
-	data, err := json.Marshal(obj)
+	return repo.writeObject(obj)
+}
+
+// hashObject computes the hash an object would be stored under, without
+// writing it.
+func hashObject(obj interface{}) (string, error) {
+	data, err := encodeObject(obj)
 	if err != nil {
 		return "", err
 	}
-
 	hashBytes := sha1.Sum(data)
-	hash := hex.EncodeToString(hashBytes[:])
-	key := []byte("obj:" + hash)
+	return hex.EncodeToString(hashBytes[:]), nil
+}
+
+// readCommit reads and deserializes a commit object from its hash, or the
+// commit that replaces it.
+func readCommit(hash string) (*Commit, error) {
+	if replacement := replacementFor(hash); replacement != "" {
+		hash = replacement // See replace.go
+	}
+	var commit *Commit
+	err := readThrough(hash, func() (err error) {
+		commit, err = repo.readCommit(hash)
+		return err
+	})
+	return commit, err
+}
 
-	err = db.Update(func(txn *badger.Txn) error {
-		_, err := txn.Get(key)
-		if err == nil {
-			return nil // Object already exists
+// readObject reads and deserializes any object by its hash.
+func readObject(hash string, v interface{}) error {
+	return readThrough(hash, func() error { return repo.readObject(hash, v) })
+}
+
+// errStopWalk is returned by a walkCommits callback to end the walk early
+// without an error.
+var errStopWalk = errors.New("stop walking history")
+
+// walkCommits calls fn for start and each of its first-parent ancestors,
+// newest first, reading one commit at a time so that long histories are
+// never held in memory. It stops at the root commit, at the first error from
+// fn, or without error when fn returns errStopWalk.
+func walkCommits(start string, fn func(hash string, commit *Commit) error) error {
+	for hash := start; hash != ""; {
+		commit, err := readCommit(hash)
+		if err != nil {
+			return err
 		}
-		if err != badger.ErrKeyNotFound {
+		if err := fn(hash, commit); err == errStopWalk {
+			return nil
+		} else if err != nil {
 			return err
 		}
-		return txn.Set(key, data)
+		hash = ""
+		if len(commit.Parents) > 0 {
+			hash = commit.Parents[0]
+		}
+	}
+	return nil
+}
+
+// readRawObject returns the stored encoding of an object.
+func readRawObject(hash string) ([]byte, error) {
+	var data []byte
+	err := readThrough(hash, func() (err error) {
+		data, err = repo.readRawObject(hash)
+		return err
 	})
-	return hash, err
+	return data, err
 }
 
-// readCommit reads and deserializes a commit object from its hash.
-func readCommit(hash string) (*Commit, error) {
-	var commit Commit
-	key := []byte("obj:" + hash)
-	err := db.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(key)
-		if err != nil {
-			return fmt.Errorf("commit with hash %s not found", hash)
-		}
-		return item.Value(func(val []byte) error {
-			return json.Unmarshal(val, &commit)
-		})
+// writeRawObject stores an already-encoded object in repo; see
+// Repository.writeRawObject.
+func writeRawObject(hash string, data []byte) error {
+	return repo.writeRawObject(hash, data)
+}
+
+// readTree reads the tree object referenced by a commit.
+// The tree may be shared with other callers and must not be modified.
+func readTree(hash string) (Tree, error) {
+	var tree Tree
+	err := readThrough(hash, func() (err error) {
+		tree, err = repo.readTree(hash)
+		return err
 	})
-	return &commit, err
+	return tree, err
+}
+
+// readBlob reads a blob of quad lines by its hash.
+func readBlob(hash string) (Blob, error) {
+	var blob Blob
+	err := readPromised(hash, func() error { return readObject(hash, &blob) })
+	return blob, err
 }
 
 // setReference points a reference (like a branch or HEAD) to a commit hash.
 func setReference(ref, hash string) error {
-	return db.Update(func(txn *badger.Txn) error {
-		return txn.Set([]byte("ref:"+ref), []byte(hash))
-	})
+	return repo.setReference(ref, hash)
+}
+
+// deleteReference removes a reference.
+func deleteReference(ref string) error {
+	return repo.deleteReference(ref)
 }
 
 // getReference resolves a reference to a commit hash.
 func getReference(ref string) (string, error) {
-	var hash string
-	err := db.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("ref:" + ref))
-		if err != nil {
-			return fmt.Errorf("reference %s not found", ref)
-		}
-		return item.Value(func(val []byte) error {
-			hash = string(val)
-			return nil
-		})
-	})
-	return hash, err
+	return repo.getReference(ref)
+}
+
+// listReferences returns every reference whose name starts with prefix,
+// keyed by name (without the "ref:" key prefix).
+func listReferences(prefix string) (map[string]string, error) {
+	return repo.listReferences(prefix)
 }
 
 // resolveHead gets the commit hash that HEAD points to.
@@ -133,167 +252,558 @@ func resolveHead() (string, error) {
 	if err != nil {
 		return "", err
 	}
-	// HEAD points to a branch ref, e.g., "ref:head:main"
+	// HEAD points to a branch ref, e.g., "ref:head:main", or, when it is
+	// detached, holds a commit hash itself
+	if !strings.HasPrefix(headVal, "ref:") {
+		return headVal, nil
+	}
 	return getReference(strings.TrimPrefix(headVal, "ref:"))
 }
 
+// headTarget returns the reference a commit on HEAD moves: the branch HEAD
+// is on, or HEAD itself when it is detached.
+func headTarget() (string, error) {
+	headVal, err := getReference("HEAD")
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(headVal, "ref:") {
+		return "HEAD", nil
+	}
+	return strings.TrimPrefix(headVal, "ref:"), nil
+}
+
+// swapReference moves ref from old to new; see Repository.swapReference.
+func swapReference(ref, old, new string) error {
+	return repo.swapReference(ref, old, new)
+}
+
+// renameReference renames a reference; see Repository.renameReference.
+func renameReference(old, new string) error {
+	return repo.renameReference(old, new)
+}
+
+// updateHead moves the branch HEAD points to onto a new commit, or HEAD
+// itself if it is detached.
+func updateHead(hash string) error {
+	target, err := headTarget()
+	if err != nil {
+		return err
+	}
+	return setReference(target, hash)
+}
+
 // --- 3. CLI COMMANDS ---
 
 var rootCmd = &cobra.Command{
 	Use:   "quad-db",
 	Short: "A git-like quad store CLI using BadgerDB",
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-		// Don't open DB for 'init' command if the directory doesn't exist yet
-		if cmd.Name() == "init" {
+		// Don't open DB for 'init' or 'clone' if the directory doesn't exist
+		// yet, for 'doctor', which diagnoses failures to open it, for
+		// 'bench', which works in a scratch repository, or for 'check',
+		// which only reads files
+		if noReplace, _ := cmd.Flags().GetBool("no-replace-objects"); noReplace {
+			replaceObjects = false
+		}
+		if fixed, _ := cmd.Flags().GetBool("deterministic"); fixed {
+			if err := setDeterministic(); err != nil {
+				return err
+			}
+		}
+		name, _ := cmd.Flags().GetString("namespace")
+		if name == "" {
+			name = os.Getenv("QUAD_DB_NAMESPACE")
+		}
+		if err := selectNamespace(name); err != nil {
+			return err
+		}
+		switch cmd.Name() {
+		case "init", "clone":
+			if namespace != "" {
+				return fmt.Errorf("%s creates the default namespace; add others with 'quad-db namespace create'", cmd.Name())
+			}
+			return nil
+		case "doctor", "bench", "check":
+			return nil
+		}
+		if cmd.Parent() == namespaceCmd {
+			// The namespace commands manage the directories themselves
 			return nil
 		}
 		if _, err := os.Stat(dbPath); os.IsNotExist(err) {
-			return errors.New("repository not initialized, run 'quad-db init'")
+			return fmt.Errorf("%w, run 'quad-db init'", quadstore.ErrNotRepository)
+		}
+		if cmd.Parent() != nil && cmd.Parent().Parent() == adminCmd {
+			// The admin commands only need the system store
+			_, err := openSystemDB()
+			return err
+		}
+		if _, err := os.Stat(storePath); os.IsNotExist(err) {
+			return fmt.Errorf("no namespace %q, run 'quad-db namespace create %s'", namespace, namespace)
 		}
 		_, err := openDB()
 		return err
 	},
 	PersistentPostRun: func(cmd *cobra.Command, args []string) {
 		closeDB()
+		closeSystemDB()
 	},
 }
 
+// writeRootCommit stores a commit with no parents and an empty tree, the
+// start of a new line of history.
+func writeRootCommit() (string, error) {
+	treeHash, err := writeTree(map[string]string{})
+	if err != nil {
+		return "", fmt.Errorf("failed to create initial tree: %v", err)
+	}
+	rootCommit := Commit{
+		Tree:      treeHash,
+		Parents:   []string{}, // No parents
+		Author:    "System",
+		Message:   "Initial commit",
+		Timestamp: clock.Now(),
+	}
+	commitHash, err := writeObject(rootCommit)
+	if err != nil {
+		return "", fmt.Errorf("failed to create root commit: %v", err)
+	}
+	return commitHash, nil
+}
+
+// initRepository creates the database in the current directory with an
+// empty root commit on 'main', and points HEAD at it.
+func initRepository() error {
+	if err := os.Mkdir(dbPath, 0755); err != nil {
+		return err
+	}
+	return initDatabase()
+}
+
+// initDatabase fills the empty database at storePath with a root commit on
+// 'main' and points HEAD at it.
+func initDatabase() error {
+	if _, err := openDB(); err != nil {
+		return fmt.Errorf("failed to open database: %v", err)
+	}
+
+	// 1. Create the root commit, with an empty tree
+	commitHash, err := writeRootCommit()
+	if err != nil {
+		return err
+	}
+
+	// 2. Create the 'main' branch and point HEAD to it
+	if err := setReference("head:main", commitHash); err != nil {
+		return fmt.Errorf("failed to create main branch: %v", err)
+	}
+	if err := setReference("HEAD", "ref:head:main"); err != nil {
+		return fmt.Errorf("failed to set HEAD: %v", err)
+	}
+	return nil
+}
+
 var initCmd = &cobra.Command{
 	Use:   "init",
 	Short: "Initialize a new quad-db repository",
+	Long: `Initialize a new quad-db repository, with a root commit on main.
+
+--template <name|path|url> seeds it from a repository template: a directory
+holding a template.yaml, or the URL of one. A bare name is looked up in
+$QUAD_DB_TEMPLATES (default ~/.quad-db/templates). template.yaml may give
+
+  description      what the template is for
+  message          the seed commit's message
+  prefixes         prefix: namespace pairs, registered in the graph
+                   <quadgit://prefixes> as SHACL sh:declare entries
+  graphs           - graph: <iri>, file: <N-Quads, N-Triples or .ttl file>
+                   for SHACL shapes, ontologies and other graphs every
+                   repository starts with
+  config           settings, applied after the seed commit
+  files            working files to copy, e.g. .quadignore, .quadowners
+  default_branch   the branch to start on instead of main
+  branches         further branches, created at the seed commit
+
+Files are named relative to template.yaml.`,
+	Args: cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
 		if _, err := os.Stat(dbPath); !os.IsNotExist(err) {
 			log.Fatal("Repository already initialized.")
 		}
-		os.Mkdir(dbPath, 0755)
-
-		var err error
-		db, err = openDB()
-		if err != nil {
-			log.Fatalf("Failed to open database: %v", err)
+		var tmpl *repoTemplate
+		if ref, _ := cmd.Flags().GetString("template"); ref != "" {
+			var err error
+			if tmpl, err = loadTemplate(ref); err != nil {
+				log.Fatalf("Failed to load template: %v", err)
+			}
 		}
-
-		// 1. Create an empty tree
-		emptyTree := make(Tree)
-		treeHash, err := writeObject(emptyTree)
-		if err != nil {
-			log.Fatalf("Failed to create initial tree: %v", err)
+		if err := initRepository(); err != nil {
+			log.Fatalf("Failed to initialize repository: %v", err)
 		}
-
-		// 2. Create the root commit
-		rootCommit := Commit{
-			Tree:      treeHash,
-			Parents:   []string{}, // No parents
-			Author:    "System",
-			Message:   "Initial commit",
-			Timestamp: time.Now(),
+		if tmpl == nil {
+			fmt.Printf("Initialized empty quad-db repository in %s\n", dbPath)
+			return
 		}
-		commitHash, err := writeObject(rootCommit)
-		if err != nil {
-			log.Fatalf("Failed to create root commit: %v", err)
+		if err := tmpl.apply(); err != nil {
+			log.Fatalf("Failed to apply template %s: %v", tmpl.name, err)
 		}
+		fmt.Printf("Initialized quad-db repository in %s from template %s\n", dbPath, tmpl.name)
+	},
+}
 
-		// 3. Create the 'main' branch and point HEAD to it
-		if err := setReference("head:main", commitHash); err != nil {
-			log.Fatalf("Failed to create main branch: %v", err)
-		}
-		if err := setReference("HEAD", "ref:head:main"); err != nil {
-			log.Fatalf("Failed to set HEAD: %v", err)
-		}
+// stageLines normalizes N-Quads lines as configured, leaves out those
+// matching a rule in .quadignore and appends the rest to the index. It
+// returns how many were left out. Nothing is staged if ctx is canceled
+// before the lines are written, or if writing them fails part way.
+func stageLines(ctx context.Context, lines []string) (int, error) {
+	pipeline, err := loadNormalizePipeline()
+	if err != nil {
+		return 0, fmt.Errorf("failed to load normalization: %v", err)
+	}
+	rules, err := loadIgnoreRules()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %v", ignorePath, err)
+	}
+	if lines, err = pipeline.normalizeLines(lines); err != nil {
+		return 0, fmt.Errorf("failed to normalize: %v", err)
+	}
+	lines, ignored := rules.filterLines(lines)
+	if len(lines) == 0 {
+		return ignored, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
 
-		fmt.Printf("Initialized empty quad-db repository in %s\n", dbPath)
-	},
+	// Simple staging: append to an index file.
+	f, err := os.OpenFile(indexPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open index: %v", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to open index: %v", err)
+	}
+	if _, err := f.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		f.Truncate(info.Size()) // Leave no partial line behind
+		return 0, fmt.Errorf("failed to write to index: %v", err)
+	}
+	return ignored, nil
 }
 
 var addCmd = &cobra.Command{
-	Use:   "add <file.nq>",
+	Use:   "add <file.nq|file.hdt>",
 	Short: "Add quads from a file to the staging area",
-	Args:  cobra.ExactArgs(1),
+	Long: `Stage the quads in an N-Quads or HDT file. They are first normalized as
+configured by normalize.steps (iri-case, datatypes, lang-tags) and
+normalize.command, an external filter from N-Quads to N-Quads; quads
+matching a rule in .quadignore are then left out.`,
+	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		content, err := os.ReadFile(args[0])
 		if err != nil {
 			log.Fatalf("Failed to read file %s: %v", args[0], err)
 		}
-
-		// Simple staging: append to an index file.
-		f, err := os.OpenFile(indexPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-		if err != nil {
-			log.Fatalf("Failed to open index: %v", err)
+		if filepath.Ext(args[0]) == ".hdt" {
+			graph, _ := cmd.Flags().GetString("graph")
+			if graph == defaultGraph {
+				graph = ""
+			} else if graph != "" {
+				graph = normalizeGraphName(graph)
+			}
+			lines, err := readHDT(args[0], graph)
+			if err != nil {
+				log.Fatalf("Failed to read HDT file: %v", err)
+			}
+			content = []byte(strings.Join(lines, "\n") + "\n")
 		}
-		defer f.Close()
 
-		if _, err := f.WriteString(string(content)); err != nil {
-			log.Fatalf("Failed to write to index: %v", err)
+		ctx := commandContext(cmd)
+		ignored, err := stageLines(ctx, strings.Split(strings.TrimRight(string(content), "\n"), "\n"))
+		exitIfInterrupted(ctx, "nothing was staged")
+		if err != nil {
+			log.Fatalf("Failed to stage %s: %v", args[0], err)
 		}
 		fmt.Printf("Staged changes from %s\n", args[0])
+		if ignored > 0 {
+			fmt.Printf("Ignored %d quad(s) matching %s\n", ignored, ignorePath)
+		}
 	},
 }
 
 var commitCmd = &cobra.Command{
-	Use:   "commit -m <message>",
+	Use:   "commit [-m <message>]",
 	Short: "Record staged changes to the repository",
+	Long: `Record the staged changes as a new commit on the current branch.
+
+Without -m, $VISUAL or $EDITOR is opened on the commit template followed by
+a commented summary of the staged changes. The message is then passed to
+the executable .quad-db/hooks/commit-msg, if present, as a file it may
+rewrite; a non-zero exit aborts the commit (--no-verify skips the hook).
+
+Before that, the literals the commit adds are scanned for credentials such
+as API keys, tokens and private keys, which history could never forget; a
+commit that adds any is aborted (--no-verify skips this too). Set
+secrets.pattern.<name> to a regular expression to flag more,
+secrets.emails to true to flag email addresses, secrets.allow.<name> to a
+regular expression for literals never to flag, such as documented example
+keys, or secrets.scan to false to stop scanning.
+
+With --amend, the commit at the tip of the branch is replaced instead: the
+staged changes are applied on top of it, and its message is kept unless -m
+is given. --allow-empty records a commit even when nothing is staged.
+
+--meta attaches key=value metadata, such as a pipeline run ID, which is
+indexed so that 'log --meta' finds the commit quickly. --trailer appends
+"Key: value" trailers such as Reviewed-by or Ticket to the
+message; 'log --trailer' finds commits by them.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		message, _ := cmd.Flags().GetString("message")
-		if message == "" {
-			log.Fatal("Commit message is required. Use -m.")
-		}
+		amend, _ := cmd.Flags().GetBool("amend")
+		allowEmpty, _ := cmd.Flags().GetBool("allow-empty")
 
-		// 1. Read staged quads from index
-		stagedQuads, err := os.ReadFile(indexPath)
-		if err != nil || len(stagedQuads) == 0 {
+		// 1. Read staged quads from index, and staged graph renames
+		stagedQuads, _ := os.ReadFile(indexPath)
+		renames, err := readStagedRenames()
+		if err != nil {
+			log.Fatalf("Failed to read staged renames: %v", err)
+		}
+		if len(stagedQuads) == 0 && len(renames) == 0 && !amend && !allowEmpty {
 			log.Fatal("Nothing to commit. Stage changes with 'add' first.")
 		}
+		var quads []string
+		if len(stagedQuads) > 0 {
+			quads = strings.Split(strings.TrimSpace(string(stagedQuads)), "\n")
+		}
 
-		// 2. Create a blob from the staged quads
-		quads := strings.Split(strings.TrimSpace(string(stagedQuads)), "\n")
-		blobHash, err := writeObject(quads)
+		// 2. Get parent commit. When amending, the tip is replaced, so its
+		// parents become the new commit's parents
+		headHash, err := resolveHead()
 		if err != nil {
-			log.Fatalf("Failed to create blob object: %v", err)
+			log.Fatalf("Could not resolve HEAD: %v", err)
+		}
+		parents := []string{headHash}
+		stagedRenames := renames
+		var amended *Commit
+		if amend {
+			if amended, err = readCommit(headHash); err != nil {
+				log.Fatalf("Failed to read commit to amend: %v", err)
+			}
+			if len(amended.Parents) == 0 {
+				log.Fatal("Cannot amend the root commit.")
+			}
+			parents = amended.Parents
+			renames = chainRenames(amended.Renames, renames)
+			if message == "" {
+				message = amended.Message
+			}
 		}
 
-		// 3. Create a new tree
-		// Simplified: This commit will only contain the new blob.
-		// A real implementation would merge with the parent's tree.
-		newTree := Tree{
-			"default": blobHash, // Using a default graph name
+		// 3. Staged graphs replace the parent's version; others are inherited
+		before, err := loadState(parents[0])
+		if err != nil {
+			log.Fatalf("Failed to read parent commit: %v", err)
 		}
-		treeHash, err := writeObject(newTree)
+		base := before
+		if amend {
+			if base, err = loadState(headHash); err != nil {
+				log.Fatalf("Failed to read commit to amend: %v", err)
+			}
+		}
+		after, err := applyStaged(applyRenames(base, stagedRenames), quads)
 		if err != nil {
-			log.Fatalf("Failed to create tree object: %v", err)
+			log.Fatalf("Invalid staged quads: %v", err)
 		}
 
-		// 4. Get parent commit
-		parentHash, err := resolveHead()
+		// Refuse to let credentials into history, which cannot forget them
+		noVerify, _ := cmd.Flags().GetBool("no-verify")
+		if !noVerify {
+			if err := checkSecrets(base, after); err != nil {
+				log.Fatalf("Aborting commit: %v", err)
+			}
+		}
+
+		// Without -m, compose the message in an editor, seeded from the
+		// template and a summary of the changes; then let the commit-msg
+		// hook check or rewrite it
+		if message == "" {
+			message, err = composeCommitMessage(cmd, before, after)
+			if err != nil {
+				log.Fatalf("Failed to compose commit message: %v", err)
+			}
+		}
+		if !noVerify {
+			if message, err = runCommitMsgHook(message); err != nil {
+				log.Fatalf("Aborting commit: %v", err)
+			}
+		}
+		if strings.TrimSpace(message) == "" {
+			log.Fatal("Aborting commit due to empty commit message.")
+		}
+
+		trailers, err := trailerFlags(cmd)
 		if err != nil {
-			log.Fatalf("Could not resolve HEAD: %v", err)
+			log.Fatal(err)
+		}
+		message = quadstore.AppendTrailers(message, trailers...)
+
+		// 4. Write the blobs and the new tree
+		treeHash, err := writeState(after)
+		if err != nil {
+			log.Fatalf("Failed to create tree object: %v", err)
+		}
+
+		if !allowEmpty && !amend {
+			if parent, err := readCommit(parents[0]); err == nil && parent.Tree == treeHash {
+				log.Fatal("The staged changes leave every graph as it was; nothing to commit (use --allow-empty to commit anyway).")
+			}
 		}
 
 		// 5. Create the new commit object
 		newCommit := Commit{
 			Tree:      treeHash,
-			Parents:   []string{parentHash},
-			Author:    "user@example.com", // Should be configurable
+			Parents:   parents,
+			Author:    commitAuthor(),
 			Message:   message,
-			Timestamp: time.Now(),
+			Timestamp: clock.Now(),
+		}
+		if author, _ := cmd.Flags().GetString("author"); author != "" {
+			newCommit.Author = author
+		}
+		if len(renames) > 0 {
+			newCommit.Renames = renames
+		}
+		if newCommit.Metadata, err = metadataFlags(cmd); err != nil {
+			log.Fatal(err)
+		}
+		if noStats, _ := cmd.Flags().GetBool("no-stats"); !noStats {
+			if newCommit.Stats, err = computeStats(before, after); err != nil {
+				log.Fatalf("Failed to compute commit stats: %v", err)
+			}
+		}
+		if sign, _ := cmd.Flags().GetBool("gpg-sign"); sign {
+			if err := signCommit(&newCommit); err != nil {
+				log.Fatalf("Failed to sign commit: %v", err)
+			}
+		}
+		if err := enforceSignaturePolicy(currentBranch(), &newCommit); err != nil {
+			log.Fatal(err)
 		}
 		commitHash, err := writeObject(newCommit)
 		if err != nil {
 			log.Fatalf("Failed to write commit object: %v", err)
 		}
+		target, err := headTarget()
+		if err != nil {
+			log.Fatalf("Failed to read HEAD: %v", err)
+		}
+		parent := ""
+		if len(parents) > 0 {
+			parent = parents[0]
+		}
+		if err := authorizeWrite(quadstore.ActionCommit, actingIdentity(), target, parent, commitHash); err != nil {
+			log.Fatalf("Commit rejected: %v", err)
+		}
 
-		// 6. Update the branch reference
-		headRef, _ := getReference("HEAD")
-		if err := setReference(strings.TrimPrefix(headRef, "ref:"), commitHash); err != nil {
+		// 6. Update the branch reference, unless it moved while the commit
+		// was being prepared
+		if err := swapReference(target, headHash, commitHash); err != nil {
 			log.Fatalf("Failed to update branch reference: %v", err)
 		}
 
 		// 7. Clear the index
 		os.Truncate(indexPath, 0)
+		os.Remove(renamesPath)
 
-		fmt.Printf("[%s] %s\n", commitHash[:7], message)
+		fmt.Printf("[%s] %s\n", commitHash[:7], strings.SplitN(message, "\n", 2)[0])
+		syncAfterCommit(currentBranch())
 	},
 }
 
+// applyStaged returns the state produced by committing staged quad lines on
+// top of before: every graph that appears in the staged lines is replaced by
+// exactly the staged quads for that graph. Quads are stored as triples, since
+// the tree already records which graph a blob belongs to; this keeps a
+// graph's blob unchanged when the graph is renamed.
+func applyStaged(before map[string]quadSet, lines []string) (map[string]quadSet, error) {
+	after := make(map[string]quadSet, len(before))
+	for graph, set := range before {
+		after[graph] = set
+	}
+	staged := make(map[string]quadSet)
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		q, err := parseQuad(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		graph := graphKey(q)
+		if staged[graph] == nil {
+			staged[graph] = make(quadSet)
+		}
+		q.Graph = ""
+		staged[graph][formatQuad(q)] = true
+	}
+	for graph, set := range staged {
+		after[graph] = set
+	}
+	return after, nil
+}
+
+// composeCommitMessage renders the commit template (from --template or the
+// commit.template setting), adds a commented summary of the changes, and
+// lets the user edit it.
+func composeCommitMessage(cmd *cobra.Command, before, after map[string]quadSet) (string, error) {
+	templatePath, _ := cmd.Flags().GetString("template")
+	if templatePath == "" {
+		cfg, err := loadConfig()
+		if err != nil {
+			return "", err
+		}
+		templatePath = cfg.Get("commit.template")
+	}
+
+	var initial string
+	if templatePath != "" {
+		text, err := os.ReadFile(templatePath)
+		if err != nil {
+			return "", err
+		}
+		data := messageData{Branch: currentBranch()}
+		data.Added, data.Deleted = countChanges(before, after)
+		if initial, err = renderMessage(string(text), data); err != nil {
+			return "", err
+		}
+	}
+	initial += "\n# Please enter the commit message for your changes. Lines starting\n# with '#' will be ignored, and an empty message aborts the commit.\n"
+	if branch := currentBranch(); branch != "" {
+		initial += "#\n# On branch " + branch + "\n"
+	}
+	stats, err := computeStats(before, after)
+	if err != nil {
+		return "", err
+	}
+	initial += "# Changes to be committed:\n"
+	graphs := make([]string, 0, len(stats.Graphs))
+	for graph := range stats.Graphs {
+		graphs = append(graphs, graph)
+	}
+	sort.Strings(graphs)
+	for _, graph := range graphs {
+		g := stats.Graphs[graph]
+		initial += fmt.Sprintf("#\t+%d / -%d quads in %s\n", g.Added, g.Deleted, graph)
+	}
+	if len(graphs) == 0 {
+		initial += "#\tno quad changes\n"
+	}
+	return editMessage(initial)
+}
+
 var logCmd = &cobra.Command{
 	Use:   "log",
 	Short: "Show commit history",
@@ -303,35 +813,457 @@ var logCmd = &cobra.Command{
 			log.Fatalf("Could not resolve HEAD: %v", err)
 		}
 
-		for {
-			commit, err := readCommit(hash)
+		stat, _ := cmd.Flags().GetBool("stat")
+		subject, _ := cmd.Flags().GetString("subject")
+		var pattern *quadPattern
+		if s, _ := cmd.Flags().GetString("pickaxe"); s != "" {
+			p, err := parsePattern(s)
 			if err != nil {
-				log.Fatalf("Failed to read commit history: %v", err)
+				log.Fatalf("Invalid -S pattern: %v", err)
+			}
+			pattern = &p
+		}
+		var re *regexp.Regexp
+		if g, _ := cmd.Flags().GetString("pickaxe-regex"); g != "" {
+			if re, err = regexp.Compile(g); err != nil {
+				log.Fatalf("Invalid -G expression: %v", err)
 			}
+		}
 
-			fmt.Printf("commit %s\n", hash)
-			fmt.Printf("Author: %s\n", commit.Author)
-			fmt.Printf("Date:   %s\n", commit.Timestamp.Format(time.RFC1123Z))
-			fmt.Printf("\n\t%s\n\n", commit.Message)
+		maxCount, _ := cmd.Flags().GetInt("max-count")
+		trailers, err := trailerFlags(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		metadata, err := metadataFlags(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var withMetadata map[string]bool
+		for key, value := range metadata {
+			hashes, err := commitsWithMetadata(key, value)
+			if err != nil {
+				log.Fatalf("Failed to read metadata index: %v", err)
+			}
+			if withMetadata != nil {
+				for h := range withMetadata {
+					if !hashes[h] {
+						delete(withMetadata, h)
+					}
+				}
+			} else {
+				withMetadata = hashes
+			}
+		}
 
-			if len(commit.Parents) == 0 {
-				break
+		// Filtering and computing changes is done in parallel, showing
+		// commits is done in order
+		type logEntry struct {
+			match   bool
+			changes []graphDiff // With --subject, the changes about it
+			stats   *quadstore.CommitStats
+		}
+		match := func(h string, commit *Commit) (logEntry, error) {
+			if withMetadata != nil && !withMetadata[h] {
+				return logEntry{}, nil
 			}
-			hash = commit.Parents[0] // Follow the first parent
+			if len(trailers) > 0 {
+				found := quadstore.ParseTrailers(commit.Message)
+				for _, t := range trailers {
+					if !found.Has(t.Key, t.Value) {
+						return logEntry{}, nil
+					}
+				}
+			}
+			// With --subject, only commits that changed quads about the
+			// subject are shown, together with those changes
+			var changes []graphDiff
+			if subject != "" || pattern != nil || re != nil {
+				diffs, err := commitDiffs(commit)
+				if err != nil {
+					return logEntry{}, fmt.Errorf("failed to compute changes of %s: %v", h[:7], err)
+				}
+				if pattern != nil && !pickaxeCount(diffs, *pattern) {
+					return logEntry{}, nil
+				}
+				if re != nil && !pickaxeRegexp(diffs, re) {
+					return logEntry{}, nil
+				}
+				if subject != "" {
+					if changes = filterDiffs(diffs, quadstore.DiffOptions{Subject: subject}); len(changes) == 0 {
+						return logEntry{}, nil
+					}
+				}
+			}
+			entry := logEntry{match: true, changes: changes}
+			if stat {
+				var err error
+				if entry.stats, err = commitStats(commit); err != nil {
+					return logEntry{}, fmt.Errorf("failed to compute stats of %s: %v", h[:7], err)
+				}
+			}
+			return entry, nil
+		}
+		shown := 0
+		show := func(h string, commit *Commit, entry logEntry) error {
+			if maxCount > 0 && shown == maxCount {
+				return errStopWalk
+			}
+			if !entry.match {
+				return nil
+			}
+			shown++
+			printCommit(h, commit)
+			if entry.stats != nil {
+				printStats(entry.stats)
+				fmt.Println()
+			}
+			if entry.changes != nil {
+				printDiff(entry.changes)
+				fmt.Println()
+			}
+			return nil
+		}
+
+		// Show first-parent history, or the commits that touched a graph
+		// when following one
+		walk := firstParentWalk(hash)
+		if graph, _ := cmd.Flags().GetString("follow"); graph != "" {
+			versions, err := followGraph(hash, normalizeGraphName(graph))
+			if err != nil {
+				log.Fatalf("Failed to read commit history: %v", err)
+			}
+			walk = func(visit func(hash string, commit *Commit) error) error {
+				for _, v := range versions {
+					commit, err := readCommit(v.Commit)
+					if err != nil {
+						return err
+					}
+					if err := visit(v.Commit, commit); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+		}
+		if err := processCommits(walk, match, show); err != nil {
+			log.Fatalf("Failed to read commit history: %v", err)
 		}
 	},
 }
 
+// trailerFlags parses the --trailer flags of cmd, each "Key=value",
+// "Key: value" or, to match any value, just "Key".
+func trailerFlags(cmd *cobra.Command) ([]quadstore.Trailer, error) {
+	args, _ := cmd.Flags().GetStringArray("trailer")
+	var trailers []quadstore.Trailer
+	for _, arg := range args {
+		key, value, _ := strings.Cut(arg, "=")
+		if k, v, ok := strings.Cut(arg, ":"); ok && (len(k) < len(key) || key == arg) {
+			key, value = k, v
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("invalid trailer %q (expected Key=value)", arg)
+		}
+		trailers = append(trailers, quadstore.Trailer{Key: key, Value: value})
+	}
+	return trailers, nil
+}
+
+// printCommit writes a commit in the log format.
+func printCommit(hash string, commit *Commit) {
+	fmt.Printf("commit %s\n", hash)
+	fmt.Printf("Author: %s\n", commit.Author)
+	fmt.Printf("Date:   %s\n", commit.Timestamp.Format(time.RFC1123Z))
+	renames := make([]string, 0, len(commit.Renames))
+	for oldName, newName := range commit.Renames {
+		renames = append(renames, fmt.Sprintf("Rename: %s -> %s", oldName, newName))
+	}
+	sort.Strings(renames)
+	for _, r := range renames {
+		fmt.Println(r)
+	}
+	keys := make([]string, 0, len(commit.Metadata))
+	for key := range commit.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Printf("Meta:   %s=%s\n", key, commit.Metadata[key])
+	}
+	fmt.Printf("\n\t%s\n\n", commit.Message)
+}
+
 func main() {
 	// Add commands to root
+	addCmd.Flags().String("graph", "", "Graph to stage an HDT file's triples into (default: the graph it was exported from)")
+	initCmd.Flags().String("template", "", "Seed the repository from a template: a name, a directory or a URL")
 	rootCmd.AddCommand(initCmd, addCmd, logCmd)
 
 	// Add flags
 	commitCmd.Flags().StringP("message", "m", "", "Commit message")
+	commitCmd.Flags().StringP("template", "t", "", "Template file used to seed the commit message editor")
+	commitCmd.Flags().BoolP("gpg-sign", "S", false, "Sign the commit, with gpg or the user.signingBackend")
+	commitCmd.Flags().Bool("amend", false, "Replace the tip of the current branch instead of adding a commit")
+	commitCmd.Flags().Bool("allow-empty", false, "Record a commit even if nothing is staged")
+	commitCmd.Flags().String("author", "", "Override the commit author, e.g. 'Jane Doe <jane@example.org>'")
+	commitCmd.Flags().StringArray("meta", nil, "Attach indexed metadata to the commit, e.g. 'run-id=1234' (repeatable)")
+	commitCmd.Flags().StringArray("trailer", nil, "Append a trailer to the message, e.g. 'Ticket=ABC-123' (repeatable)")
+	commitCmd.Flags().Bool("no-verify", false, "Bypass the secrets scan and the commit-msg hook")
+	commitCmd.Flags().Bool("no-stats", false, "Do not precompute change statistics (log --stat computes them on demand)")
 	rootCmd.AddCommand(commitCmd)
 
+	mergeCmd.Flags().String("strategy", "three-way", "Merge strategy: three-way or crdt")
+	mergeCmd.Flags().BoolP("gpg-sign", "S", false, "Sign the merge commit, with gpg or the user.signingBackend")
+	mergeCmd.Flags().Bool("continue", false, "Commit a merge stopped at conflicts, once they are resolved")
+	mergeCmd.Flags().Bool("abort", false, "Give up a merge stopped at conflicts")
+	rootCmd.AddCommand(mergeCmd)
+	mergeBaseCmd.Flags().Bool("all", false, "Print every best common ancestor, not just one")
+	mergeBaseCmd.Flags().Bool("is-ancestor", false, "Exit with status 0 if the first commit is an ancestor of the second, 1 otherwise")
+	rootCmd.AddCommand(mergeBaseCmd)
+	revertCmd.Flags().Bool("no-verify", false, "Revert even if later changes conflict with undoing the commit")
+	revertCmd.Flags().Int("mainline", 0, "For a merge commit, the parent (1 or more) to revert against")
+	revertCmd.Flags().StringP("message", "m", "", "Message of the revert commit")
+	rootCmd.AddCommand(revertCmd)
+
+	configCmd.Flags().Bool("unset", false, "Remove the given key")
+	rootCmd.AddCommand(configCmd)
+
+	verifyHistoryCmd.Flags().String("since", "", "Only verify commits not reachable from this commit")
+	rootCmd.AddCommand(verifyHistoryCmd)
+
+	serveCmd.Flags().String("addr", ":8080", "Address to listen on; on localhost only, unless given, while no users exist")
+	serveCmd.Flags().Duration("gc-interval", 0, "Run value-log GC in the background at this interval, e.g. 10m (0: never)")
+	serveCmd.Flags().Duration("batch-window", 0, "Commit change sets POSTed to /changes together, once this long after the first, e.g. 5s (0: each on its own)")
+	serveCmd.Flags().Int("batch-max", 1000, "Commit a batch of change sets early once it holds this many")
+	serveCmd.Flags().String("replica-of", "", "Serve as a read replica of this remote (name or URL)")
+	serveCmd.Flags().Duration("replica-interval", 10*time.Second, "How often a replica fetches from its primary")
+	pushCmd.Flags().Bool("signed", false, "Sign a push certificate for the ref updates, with gpg or the user.signingBackend")
+	rootCmd.AddCommand(serveCmd, remoteCmd, pushCmd, auditLogCmd)
+	for _, cmd := range []*cobra.Command{pushCmd, fetchCmd, pullCmd, cloneCmd} {
+		addTransferFlags(cmd)
+	}
+	cloneCmd.Flags().String("filter", "", "Partial clone: blob:none fetches graphs only when they are first read")
+	cloneCmd.Flags().Bool("thin", false, "Fetch only references; read all objects through from origin as they are needed")
+	receivePackCmd.Flags().String("user", "", "Authorize the push as this identity rather than the account running it")
+	rootCmd.AddCommand(fetchCmd, cloneCmd, uploadPackCmd, receivePackCmd)
+	pushCmd.Flags().BoolP("set-upstream", "u", false, "Make the remote branch the upstream of the pushed branch")
+	pullCmd.Flags().String("strategy", "three-way", "Merge strategy: three-way or crdt")
+	pullCmd.Flags().BoolP("gpg-sign", "S", false, "Sign the merge commit, with gpg or the user.signingBackend")
+	branchCmd.Flags().CountP("verbose", "v", "Show each branch's tip; twice, also its upstream and description")
+	branchCmd.Flags().StringP("set-upstream-to", "u", "", "Make the branch track <remote>/<branch>")
+	branchCmd.Flags().Bool("unset-upstream", false, "Remove the branch's upstream")
+	branchCmd.Flags().Bool("edit-description", false, "Edit the branch's description in an editor")
+	branchCmd.Flags().BoolP("delete", "d", false, "Delete a branch that is merged into HEAD")
+	branchCmd.Flags().BoolP("force-delete", "D", false, "Delete a branch even if it is not merged")
+	branchCmd.Flags().BoolP("move", "m", false, "Rename a branch (the current one if only the new name is given)")
+	rootCmd.AddCommand(pullCmd, branchCmd, statusCmd)
+
+	rootCmd.PersistentFlags().Bool("no-replace-objects", false, "Ignore replace references and show the real history")
+	rootCmd.PersistentFlags().Bool("deterministic", false, "Stamp new commits with SOURCE_DATE_EPOCH (or the Unix epoch) and a fixed author, for reproducible hashes")
+	rootCmd.PersistentFlags().String("namespace", "", "Namespace to operate on (default $QUAD_DB_NAMESPACE, else the default namespace)")
+	replaceCmd.Flags().BoolP("delete", "d", false, "Delete the replacements of the given commits")
+	replaceCmd.Flags().Bool("graft", false, "Replace a commit with a copy having the given parents")
+	rootCmd.AddCommand(replaceCmd)
+
+	checkoutCmd.Flags().Bool("orphan", false, "Create the branch from an empty root commit")
+	checkoutCmd.Flags().StringP("branch", "b", "", "Create this branch at the commit and switch to it")
+	checkoutCmd.Flags().Bool("detach", false, "Detach HEAD even when given a branch")
+	checkoutCmd.Flags().Bool("export", false, "Also replace the working export with the commit's graphs")
+	checkoutCmd.Flags().String("dir", "", "Export into this directory instead (default: core.exportDir or ./export)")
+	checkoutCmd.Flags().Bool("save-dir", false, "Also make --dir the working export (core.exportDir)")
+	checkoutCmd.Flags().BoolP("force", "f", false, "With --export, discard changes in the working export")
+	rootCmd.AddCommand(checkoutCmd)
+
+	releaseCmd.Flags().StringP("message", "m", "", "Text to put before the release notes")
+	releaseCmd.Flags().Bool("dry-run", false, "Print the version and release notes without tagging")
+	releaseCmd.Flags().Bool("force", false, "Allow a smaller bump than the changes call for")
+	rootCmd.AddCommand(releaseCmd)
+	tagCmd.Flags().BoolP("annotate", "a", false, "Make an annotated tag")
+	tagCmd.Flags().StringP("message", "m", "", "Message of an annotated tag")
+	tagCmd.Flags().BoolP("sign", "s", false, "Make a signed annotated tag, with gpg or the user.signingBackend")
+	tagCmd.Flags().BoolP("force", "f", false, "Replace an existing tag")
+	tagCmd.Flags().BoolP("delete", "d", false, "Delete the named tags")
+	tagCmd.Flags().BoolP("verify", "v", false, "Verify the signatures of the named tags")
+	tagCmd.Flags().StringP("list", "l", "", "List only tags matching a shell pattern")
+	tagCmd.Flags().BoolP("subjects", "n", false, "List each tag with the first line of its message")
+	rootCmd.AddCommand(tagCmd)
+	changelogCmd.Flags().String("format", "markdown", "Output format: markdown or json")
+	rootCmd.AddCommand(changelogCmd)
+	statsCmd.Flags().Bool("history", false, "Emit a per-commit time series of the branch's history")
+	statsCmd.Flags().Bool("storage", false, "Show the storage engine's health instead")
+	statsCmd.Flags().String("format", "csv", "Output format of --history: csv or json (--storage: json)")
+	rootCmd.AddCommand(statsCmd)
+
+	shortlogCmd.Flags().BoolP("summary", "s", false, "Only print the number of commits per author")
+	shortlogCmd.Flags().BoolP("numbered", "n", false, "Sort authors by number of commits")
+	shortlogCmd.Flags().Bool("stat", false, "Also count the quads each author added and deleted")
+	rootCmd.AddCommand(shortlogCmd)
+	checkCmd.Flags().String("shapes", "", "Also validate against the SHACL shapes in this N-Triples/N-Quads file")
+	checkCmd.Flags().Bool("lenient", false, "Accept the relaxed syntax 'add' accepts, e.g. relative IRIs")
+	rootCmd.AddCommand(checkCmd)
+
+	lsTreeCmd.Flags().String("prefix", "", "Only list graphs under this IRI prefix, e.g. http://example.org/datasets/*")
+	rootCmd.AddCommand(lsTreeCmd)
+
+	logCmd.Flags().String("follow", "", "Only show commits that changed this graph, following renames")
+	logCmd.Flags().Bool("stat", false, "Show per-graph change counts for each commit")
+	logCmd.Flags().StringP("pickaxe", "S", "", "Only show commits that changed the number of quads matching a pattern, e.g. '<s> <p> ?o'")
+	logCmd.Flags().StringP("pickaxe-regex", "G", "", "Only show commits that added or removed a quad matching this regular expression")
+	logCmd.Flags().StringArray("meta", nil, "Only show commits with this metadata, e.g. 'run-id=1234' (repeatable; all must match)")
+	logCmd.Flags().StringArray("trailer", nil, "Only show commits with this message trailer, e.g. 'Ticket=ABC-123' or just 'Reviewed-by' (repeatable; all must match)")
+	logCmd.Flags().IntP("max-count", "n", 0, "Show at most this many commits (0: all)")
+	logCmd.Flags().String("subject", "", "Only show commits that added or removed quads about this subject, with those changes")
+	rootCmd.AddCommand(mvCmd, blameCmd)
+
+	exportCmd.Flags().String("dir", "", "Directory to export into (default: core.exportDir or ./export)")
+	exportCmd.Flags().Bool("save-dir", false, "Also make --dir the working export (core.exportDir)")
+	exportCmd.Flags().String("format", "nquads", "Output format: nquads, hdt, neptune-nquads or neptune-csv (nquads or ntriples with --graph)")
+	exportCmd.Flags().String("graph", "", "Write only this graph, streamed to standard output")
+	exportCmd.Flags().Int("split-size", 0, "With a neptune format, start a new file every N rows (0: no limit)")
+	exportCmd.Flags().Bool("include-sensitive", false, "Export values of the pii.predicates too")
+	diffCmd.Flags().Bool("staged", false, "Compare HEAD with the index")
+	diffCmd.Flags().String("graph", "", "Only show changes to this graph, or to graphs under a prefix ending in '*'")
+	diffCmd.Flags().String("subject", "", "Only show changes to quads with this subject")
+	diffCmd.Flags().String("predicate", "", "Only show changes to quads with this predicate")
+	diffCmd.Flags().Bool("summary", false, "Group changes by subject instead of listing quads")
+	diffCmd.Flags().Bool("stat", false, "Show per-graph change counts instead of listing quads")
+	diffCmd.Flags().Bool("use-labels", false, "Show predicates and classes by their rdfs:label in the ontology graphs")
+	rootCmd.AddCommand(exportCmd, diffCmd)
+
+	importGitCmd.Flags().String("rev", "HEAD", "Git revision whose history is imported")
+	importGitCmd.Flags().String("branch", "", "Branch to create (default: the current branch, if it has no history yet)")
+	importGitCmd.Flags().String("graph-base", "", "Store each file's triples in a graph named by this IRI prefix plus the file's path")
+	exportGitCmd.Flags().String("branch", "", "Branch to export (default: the current branch)")
+	exportGitCmd.Flags().Bool("include-sensitive", false, "Export values of the pii.predicates too")
+	rootCmd.AddCommand(importGitCmd, exportGitCmd)
+
+	redactCmd.Flags().String("mode", "hash", "Replace values by a keyed hash (hash) or drop their statements (remove)")
+	redactCmd.Flags().StringArray("predicate", nil, "Also redact this predicate's values (repeatable)")
+	redactCmd.Flags().Bool("dry-run", false, "Report what would be redacted without rewriting anything")
+	rootCmd.AddCommand(redactCmd)
+
+	retentionApplyCmd.Flags().Int("days", 0, "Squash commits older than this many days (default retention.days)")
+	retentionApplyCmd.Flags().String("period", "month", "Roll up old commits by day, week, month or year (default retention.period, or month)")
+	retentionApplyCmd.Flags().StringArray("branch", nil, "Only rewrite this branch (repeatable)")
+	retentionApplyCmd.Flags().Bool("dry-run", false, "Report what would be squashed without rewriting anything")
+	retentionCmd.AddCommand(retentionApplyCmd)
+	rootCmd.AddCommand(retentionCmd)
+
+	for _, cmd := range []*cobra.Command{reviewApproveCmd, reviewRequestChangesCmd} {
+		cmd.Flags().StringP("message", "m", "", "Comment on the review")
+	}
+	reviewStatusCmd.Flags().String("into", "", "Branch the commit would be merged into (default: the current branch)")
+	reviewCmd.AddCommand(reviewApproveCmd, reviewRequestChangesCmd, reviewStatusCmd)
+	rootCmd.AddCommand(reviewCmd)
+
+	proposalCreateCmd.Flags().StringP("title", "t", "", "Title of the proposal")
+	proposalCreateCmd.Flags().StringP("message", "m", "", "Description of the proposal")
+	proposalCreateCmd.Flags().String("into", "", "Branch to merge into (default: the current branch)")
+	proposalListCmd.Flags().String("state", proposalOpen, "List proposals in this state: open, merged, closed or all")
+	for _, cmd := range []*cobra.Command{proposalCommentCmd, proposalApproveCmd, proposalRequestChangesCmd} {
+		cmd.Flags().StringP("message", "m", "", "Text of the comment")
+	}
+	proposalCmd.AddCommand(proposalCreateCmd, proposalListCmd, proposalShowCmd, proposalCommentCmd, proposalApproveCmd, proposalRequestChangesCmd, proposalMergeCmd, proposalCloseCmd)
+	rootCmd.AddCommand(proposalCmd)
+
+	attestCmd.Flags().String("builder-id", "", "Builder to name in the provenance (default: attest.builderId, or this project)")
+	attestCmd.Flags().Bool("sign", false, "Wrap the statement in a DSSE envelope signed like commits are")
+	attestCmd.Flags().StringP("output", "o", "", "Write the attestation to this file instead of stdout")
+	rootCmd.AddCommand(attestCmd)
+
+	syncCmd.Flags().String("branch", "", "Branch the new target follows (default: the current branch)")
+	rootCmd.AddCommand(syncCmd)
+
+	importCmd.Flags().StringP("message", "m", "", "Commit message (default: \"Import <file>\")")
+	importCmd.Flags().String("graph", "", "Graph for statements without a graph term (default: the default graph)")
+	importCmd.Flags().BoolP("quiet", "q", false, "Do not report progress")
+	importCmd.Flags().BoolP("gpg-sign", "S", false, "Sign the commit, with gpg or the user.signingBackend")
+	rootCmd.AddCommand(importCmd)
+
+	lintDuplicatesCmd.Flags().Bool("stage", false, "Stage a change set that keeps one variant of each near-duplicate group")
+	lintCmd.Flags().String("format", "text", "Output format: text or json")
+	lintCmd.Flags().Bool("list-rules", false, "List the rules and their severities")
+	lintCmd.AddCommand(lintDuplicatesCmd)
+	rootCmd.AddCommand(lintCmd)
+	reportVocabularyCmd.Flags().String("at", "HEAD", "Commit to report on")
+	reportVocabularyCmd.Flags().Bool("undeclared", false, "List only terms the ontology graphs do not declare")
+	reportCmd.AddCommand(reportVocabularyCmd)
+	rootCmd.AddCommand(reportCmd)
+
+	optimizeCmd.Flags().Float64("discard-ratio", defaultDiscardRatio, "Rewrite value-log files with at least this fraction of garbage")
+	rootCmd.AddCommand(optimizeCmd)
+
+	repackCmd.Flags().Bool("recompress", false, "Rewrite every stored object")
+	repackCmd.Flags().String("codec", "", "Codec to store objects with: none, zstd or zstd-<level> (saved as core.objectCodec)")
+	rootCmd.AddCommand(repackCmd)
+
+	revListCmd.Flags().Bool("count", false, "Print only the number of commits")
+	rootCmd.AddCommand(revListCmd)
+
+	refsImportCmd.Flags().Bool("allow-missing", false, "Accept references to commits that are not present")
+	refsImportCmd.Flags().Bool("prune", false, "Delete local references that are not in the export")
+	refsImportCmd.Flags().BoolP("dry-run", "n", false, "Only show what would change")
+	refsCmd.AddCommand(refsExportCmd, refsImportCmd)
+	rootCmd.AddCommand(refsCmd)
+
+	rootCmd.AddCommand(doctorCmd, fsckCmd)
+
+	harvestCmd.Flags().String("query", "", "File holding the CONSTRUCT query")
+	harvestCmd.Flags().String("graph", "", "Graph for triples (default: the default graph)")
+	harvestCmd.Flags().Int("page-size", 10000, "Solutions per request (0: send the query unpaged)")
+	harvestCmd.Flags().Duration("timeout", 5*time.Minute, "Time limit for each request")
+	rootCmd.AddCommand(harvestCmd)
+
+	pipelineRunCmd.Flags().Bool("once", false, "Run once even if the pipeline sets every")
+	pipelineRunCmd.Flags().Duration("timeout", 5*time.Minute, "Time limit for each HTTP request")
+	pipelineCmd.AddCommand(pipelineRunCmd)
+	rootCmd.AddCommand(pipelineCmd)
+
+	matchCmd.Flags().BoolP("count", "c", false, "Print only the number of matching quads")
+	rootCmd.AddCommand(matchCmd)
+
+	namespaceDropCmd.Flags().BoolP("force", "f", false, "Drop the namespace and all its history")
+	namespaceCmd.AddCommand(namespaceCreateCmd, namespaceListCmd, namespaceDropCmd)
+	rootCmd.AddCommand(namespaceCmd)
+
+	adminUserAddCmd.Flags().String("role", roleReader, "The user's role: reader, writer or maintainer")
+	adminUserCmd.AddCommand(adminUserAddCmd, adminUserListCmd, adminUserRoleCmd, adminUserRemoveCmd)
+	adminTokenCmd.AddCommand(adminTokenCreateCmd, adminTokenRevokeCmd)
+	adminQuotaCmd.AddCommand(adminQuotaShowCmd, adminQuotaSetCmd, adminQuotaUnsetCmd)
+	adminCmd.AddCommand(adminUserCmd, adminTokenCmd, adminQuotaCmd)
+	rootCmd.AddCommand(adminCmd)
+
+	graphBundleExportCmd.Flags().StringP("output", "o", "", "Write the bundle to this file instead of stdout")
+	graphBundleExportCmd.Flags().Bool("include-sensitive", false, "Export values of the pii.predicates too")
+	graphBundleCmd.AddCommand(graphBundleExportCmd, graphBundleImportCmd)
+	rootCmd.AddCommand(graphBundleCmd)
+
+	benchCmd.Flags().Int("quads", 100000, "Number of synthetic quads to load")
+	benchCmd.Flags().Int("graphs", 10, "Number of graphs to spread them over")
+	benchCmd.Flags().Int("commits", 20, "Number of change commits to time")
+	benchCmd.Flags().Float64("change", 0.01, "Fraction of a graph's quads each change commit replaces")
+	benchCmd.Flags().Int("queries", 20, "Number of pattern queries to time")
+	benchCmd.Flags().Int64("seed", 1, "Random seed, for repeatable data")
+	rootCmd.AddCommand(benchCmd)
+
+	queryCmd.Flags().StringP("file", "f", "", "Read the query from this file (- for stdin)")
+	queryCmd.Flags().String("at", "HEAD", "Commit, branch or tag to query")
+	queryCmd.Flags().Bool("explain", false, "Print the execution plan and estimates instead of running the query")
+	queryCmd.Flags().String("format", "", "Result format: tsv, csv, json or xml; for CONSTRUCT, nquads, ntriples, turtle, trig or jsonld")
+	queryCmd.Flags().String("saved", "", "Run the query saved under this name at --at")
+	queryCmd.Flags().StringArray("param", nil, "Bind a variable before running, as name=value (repeatable)")
+	queryCmd.Flags().String("save", "", "Stage the query under this name instead of running it")
+	queryCmd.Flags().String("comment", "", "Describe the query being saved")
+	queryCmd.Flags().Bool("list-saved", false, "List the queries saved at --at")
+	queryCmd.Flags().String("timeout", "", "Stop the query after this long, e.g. 30s (default query.timeout)")
+	queryCmd.Flags().String("max-rows", "", "Stop the query after this many intermediate solutions (default query.maxRows)")
+	queryCmd.Flags().String("max-memory", "", "Stop the query when its solutions hold about this many bytes, e.g. 256M (default query.maxMemory)")
+	rootCmd.AddCommand(queryCmd)
+
 	// Execute the CLI
-	if err := rootCmd.Execute(); err != nil {
+	if err := rootCmd.ExecuteContext(signalContext()); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
@@ -0,0 +1,185 @@
+// memento.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A memento is a version of a graph: the commit that introduced it, when,
+// and its blob, which is empty if the commit removed the graph.
+type memento struct {
+	hash string
+	time time.Time
+	blob string
+}
+
+// graphMementos returns the versions of graph along the first-parent
+// history of branch, oldest first. Commits that leave the graph as it was
+// are skipped, so each entry starts a new version.
+func graphMementos(branch, graph string) ([]memento, error) {
+	tip, err := getReference("head:" + branch)
+	if err != nil {
+		return nil, err
+	}
+	var history []memento
+	err = walkCommits(tip, func(hash string, commit *Commit) error {
+		graphs, err := readGraphs(commit.Tree)
+		if err != nil {
+			return err
+		}
+		history = append(history, memento{hash: hash, time: commit.Timestamp, blob: graphs[graph]})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var versions []memento
+	for i := len(history) - 1; i >= 0; i-- {
+		m := history[i]
+		if len(versions) == 0 && m.blob == "" {
+			continue // Not created yet
+		}
+		if len(versions) > 0 && versions[len(versions)-1].blob == m.blob {
+			continue
+		}
+		versions = append(versions, m)
+	}
+	return versions, nil
+}
+
+// selectMemento picks the version current at t: the last one that started
+// no later than t, or the first if t is before all of them.
+func selectMemento(versions []memento, t time.Time) memento {
+	i := sort.Search(len(versions), func(i int) bool { return versions[i].time.After(t) })
+	if i == 0 {
+		return versions[0]
+	}
+	return versions[i-1]
+}
+
+// mementoURLs are the Memento protocol's URLs for a graph on a branch.
+type mementoURLs struct {
+	graph, branch string
+}
+
+func (u mementoURLs) query(extra ...string) string {
+	v := url.Values{"graph": {strings.Trim(u.graph, "<>")}}
+	if u.branch != "" {
+		v.Set("branch", u.branch)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		v.Set(extra[i], extra[i+1])
+	}
+	return v.Encode()
+}
+
+func (u mementoURLs) original() string { return "/graph?" + u.query() }
+func (u mementoURLs) timegate() string { return "/timegate?" + u.query() }
+func (u mementoURLs) timemap() string  { return "/timemap?" + u.query() }
+func (u mementoURLs) memento(hash string) string {
+	return "/graph?" + u.query("rev", hash)
+}
+
+// links renders the Link header that ties the resources together.
+func (u mementoURLs) links(originalRel string) string {
+	return fmt.Sprintf(`<%s>; rel="%s", <%s>; rel="timegate", <%s>; rel="timemap"; type="application/link-format"`,
+		u.original(), originalRel, u.timegate(), u.timemap())
+}
+
+// mementoRequest reads the graph and branch of a TimeGate or TimeMap
+// request and the graph's versions, answering the request itself on error.
+func mementoRequest(w http.ResponseWriter, r *http.Request) (mementoURLs, []memento, bool) {
+	graph, err := graphParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return mementoURLs{}, nil, false
+	}
+	urls := mementoURLs{graph: graph, branch: r.URL.Query().Get("branch")}
+	branch := urls.branch
+	if branch == "" {
+		branch = currentBranch()
+	}
+	versions, err := graphMementos(branch, graph)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("branch %s: %v", branch, err), http.StatusNotFound)
+		return urls, nil, false
+	}
+	if len(versions) == 0 {
+		http.Error(w, fmt.Sprintf("graph %s has no history on %s", graph, branch), http.StatusNotFound)
+		return urls, nil, false
+	}
+	return urls, versions, true
+}
+
+// handleTimeGate redirects to the version of a graph that was current at
+// the request's Accept-Datetime, or the latest without one:
+// GET /timegate?graph=<iri>[&branch=<name>].
+func handleTimeGate(w http.ResponseWriter, r *http.Request) {
+	urls, versions, ok := mementoRequest(w, r)
+	if !ok {
+		return
+	}
+	chosen := versions[len(versions)-1]
+	if header := r.Header.Get("Accept-Datetime"); header != "" {
+		t, err := http.ParseTime(header)
+		if err != nil {
+			http.Error(w, "invalid Accept-Datetime; use the HTTP date format", http.StatusBadRequest)
+			return
+		}
+		chosen = selectMemento(versions, t)
+	}
+	w.Header().Set("Vary", "accept-datetime")
+	w.Header().Set("Link", urls.links("original"))
+	if chosen.blob == "" {
+		http.Error(w, fmt.Sprintf("graph %s did not exist at that time", urls.graph), http.StatusNotFound)
+		return
+	}
+	http.Redirect(w, r, urls.memento(chosen.hash), http.StatusFound)
+}
+
+// handleTimeMap lists a graph's versions in the link format:
+// GET /timemap?graph=<iri>[&branch=<name>].
+func handleTimeMap(w http.ResponseWriter, r *http.Request) {
+	urls, versions, ok := mementoRequest(w, r)
+	if !ok {
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "<%s>; rel=\"original\",\n<%s>; rel=\"timegate\",\n<%s>; rel=\"self\"; type=\"application/link-format\"",
+		urls.original(), urls.timegate(), urls.timemap())
+	var present []memento
+	for _, m := range versions {
+		if m.blob != "" {
+			present = append(present, m)
+		}
+	}
+	for i, m := range present {
+		rel := "memento"
+		if i == 0 {
+			rel = "first " + rel
+		}
+		if i == len(present)-1 {
+			rel = "last " + rel
+		}
+		fmt.Fprintf(&b, ",\n<%s>; rel=\"%s\"; datetime=\"%s\"", urls.memento(m.hash), rel, m.time.UTC().Format(http.TimeFormat))
+	}
+	w.Header().Set("Content-Type", "application/link-format")
+	fmt.Fprintln(w, b.String())
+}
+
+// setMementoHeaders marks a graph response as a Memento when it was read
+// at an explicit revision, and otherwise points to its TimeGate.
+func setMementoHeaders(w http.ResponseWriter, r *http.Request, graph string, snap *snapshot) {
+	urls := mementoURLs{graph: graph, branch: r.URL.Query().Get("branch")}
+	if r.URL.Query().Get("rev") == "" {
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="timegate"`, urls.timegate()))
+		return
+	}
+	w.Header().Set("Memento-Datetime", snap.commit.Timestamp.UTC().Format(http.TimeFormat))
+	w.Header().Set("Link", urls.links("original"))
+}
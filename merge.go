@@ -0,0 +1,585 @@
+// merge.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+	"github.com/spf13/cobra"
+)
+
+// quadSet is the set of quad lines stored for a single graph.
+type quadSet map[string]bool
+
+// loadState reads every graph of a commit into memory, keyed by graph name.
+func loadState(commitHash string) (map[string]quadSet, error) {
+	return loadStateUnder(context.Background(), commitHash, "")
+}
+
+// loadStateUnder is like loadState but only reads the graphs at or below a
+// graph prefix (see readGraphsUnder).
+func loadStateUnder(ctx context.Context, commitHash, prefix string) (map[string]quadSet, error) {
+	commit, err := readCommit(commitHash)
+	if err != nil {
+		return nil, err
+	}
+	return loadTreeState(ctx, commit.Tree, prefix)
+}
+
+// loadTreeState reads the graphs of a tree at or below a graph prefix,
+// stopping with ctx's error when ctx is canceled.
+func loadTreeState(ctx context.Context, treeHash, prefix string) (map[string]quadSet, error) {
+	graphs, err := readGraphsUnder(treeHash, prefix)
+	if err != nil {
+		return nil, err
+	}
+	blobs := make([]string, 0, len(graphs))
+	for _, blobHash := range graphs {
+		blobs = append(blobs, blobHash)
+	}
+	if err := prefetchBlobs(blobs); err != nil {
+		return nil, err
+	}
+	state := make(map[string]quadSet, len(graphs))
+	for graph, blobHash := range graphs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		blob, err := readBlob(blobHash)
+		if err != nil {
+			return nil, err
+		}
+		set := make(quadSet, len(blob))
+		for _, line := range blob {
+			if line != "" {
+				set[line] = true
+			}
+		}
+		state[graph] = set
+	}
+	return state, nil
+}
+
+// writeState stores each non-empty graph as a sorted blob and returns the
+// hash of the resulting tree.
+func writeState(state map[string]quadSet) (string, error) {
+	graphs := make(map[string]string)
+	for graph, set := range state {
+		if len(set) == 0 {
+			continue
+		}
+		blob := make(Blob, 0, len(set))
+		for line := range set {
+			blob = append(blob, line)
+		}
+		sort.Strings(blob)
+		blobHash, err := writeObject(blob)
+		if err != nil {
+			return "", err
+		}
+		graphs[graph] = blobHash
+	}
+	return writeTree(graphs)
+}
+
+// ancestors returns the hashes of a commit and every commit reachable from it.
+func ancestors(hash string) (map[string]bool, error) {
+	if reachIndexed() {
+		reach, unindexed, err := reachBitmap([]string{hash})
+		if err != nil {
+			return nil, err
+		}
+		seen, err := reachHashes(reach)
+		for h := range unindexed {
+			seen[h] = true
+		}
+		return seen, err
+	}
+	seen := make(map[string]bool)
+	queue := []string{hash}
+	for len(queue) > 0 {
+		h := queue[0]
+		queue = queue[1:]
+		if seen[h] {
+			continue
+		}
+		seen[h] = true
+		commit, err := readCommit(h)
+		if err != nil {
+			return nil, err
+		}
+		queue = append(queue, commit.Parents...)
+	}
+	return seen, nil
+}
+
+// findMergeBase returns the best common ancestor of a and b (see
+// mergeBases), the one of highest generation if there are several.
+func findMergeBase(a, b string) (string, error) {
+	bases, err := mergeBases(context.Background(), a, b)
+	if err != nil {
+		return "", err
+	}
+	if len(bases) == 0 {
+		return "", fmt.Errorf("commits %s and %s have no common ancestor", a[:7], b[:7])
+	}
+	return bases[0], nil
+}
+
+// lastWrites walks the commits reachable from tip that are not in stop and
+// records, per graph and quad, the timestamp of the latest commit that added
+// or removed it. Each commit is compared against its first parent.
+func lastWrites(tip string, stop map[string]bool) (map[string]map[string]time.Time, error) {
+	writes := make(map[string]map[string]time.Time)
+	record := func(graph, line string, ts time.Time) {
+		if writes[graph] == nil {
+			writes[graph] = make(map[string]time.Time)
+		}
+		if ts.After(writes[graph][line]) {
+			writes[graph][line] = ts
+		}
+	}
+
+	seen := make(map[string]bool)
+	queue := []string{tip}
+	for len(queue) > 0 {
+		h := queue[0]
+		queue = queue[1:]
+		if seen[h] || stop[h] {
+			continue
+		}
+		seen[h] = true
+		commit, err := readCommit(h)
+		if err != nil {
+			return nil, err
+		}
+		queue = append(queue, commit.Parents...)
+
+		after, err := loadState(h)
+		if err != nil {
+			return nil, err
+		}
+		before := make(map[string]quadSet)
+		if len(commit.Parents) > 0 {
+			if before, err = loadState(commit.Parents[0]); err != nil {
+				return nil, err
+			}
+		}
+		for graph, set := range after {
+			for line := range set {
+				if !before[graph][line] {
+					record(graph, line, commit.Timestamp)
+				}
+			}
+		}
+		for graph, set := range before {
+			for line := range set {
+				if !after[graph][line] {
+					record(graph, line, commit.Timestamp)
+				}
+			}
+		}
+	}
+	return writes, nil
+}
+
+// mergeStates combines the target ("ours") and source ("theirs") states
+// relative to their common ancestor.
+//
+// In three-way mode a quad takes the value of whichever side changed it.
+// Both sides adding different objects for the same subject, predicate and
+// graph is reported as a conflict.
+//
+// In CRDT mode each graph is treated as an observed-remove set: when the
+// sides disagree about a quad, the side whose latest write to that quad is
+// newer wins, and concurrent add/remove ties resolve in favour of the add.
+// Conflicting values are still reported but both are kept, so callers need
+// not stop the merge.
+func mergeStates(base, ours, theirs map[string]quadSet, oursWrites, theirsWrites map[string]map[string]time.Time, crdt bool) (map[string]quadSet, []quadstore.Conflict) {
+	graphs := make(map[string]bool)
+	for _, state := range []map[string]quadSet{base, ours, theirs} {
+		for graph := range state {
+			graphs[graph] = true
+		}
+	}
+
+	merged := make(map[string]quadSet)
+	var conflicts []quadstore.Conflict
+	for graph := range graphs {
+		lines := make(map[string]bool)
+		for _, state := range []map[string]quadSet{base, ours, theirs} {
+			for line := range state[graph] {
+				lines[line] = true
+			}
+		}
+
+		result := make(quadSet)
+		oursAdded := make(map[string][]string)
+		theirsAdded := make(map[string][]string)
+		for line := range lines {
+			inBase, inOurs, inTheirs := base[graph][line], ours[graph][line], theirs[graph][line]
+			keep := inOurs
+			if inOurs != inTheirs {
+				if crdt {
+					oursTs, theirsTs := oursWrites[graph][line], theirsWrites[graph][line]
+					switch {
+					case oursTs.After(theirsTs):
+						keep = inOurs
+					case theirsTs.After(oursTs):
+						keep = inTheirs
+					default:
+						keep = true
+					}
+				} else if inOurs == inBase {
+					keep = inTheirs
+				}
+			}
+			if keep {
+				result[line] = true
+			}
+
+			if inBase || inOurs == inTheirs {
+				continue
+			}
+			q, err := parseQuad(line)
+			if err != nil {
+				continue
+			}
+			key := q.Subject + " " + q.Predicate
+			if inOurs {
+				oursAdded[key] = append(oursAdded[key], line)
+			} else {
+				theirsAdded[key] = append(theirsAdded[key], line)
+			}
+		}
+		merged[graph] = result
+
+		for key, oursLines := range oursAdded {
+			theirsLines, ok := theirsAdded[key]
+			if !ok {
+				continue
+			}
+			conflicts = append(conflicts, quadstore.Conflict{
+				Type:        "CONFLICTING_VALUES",
+				Description: fmt.Sprintf("Both branches set different values for %s in graph %s", key, graph),
+				Graph:       graph,
+				Conflicting: append(append([]string{}, oursLines...), theirsLines...),
+			})
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Description < conflicts[j].Description })
+	return merged, conflicts
+}
+
+// printConflicts lists conflicts as merge and revert report them, each
+// with the quads involved.
+func printConflicts(w io.Writer, conflicts []quadstore.Conflict) {
+	for _, c := range conflicts {
+		fmt.Fprintf(w, "CONFLICT (%s): %s\n", c.Type, c.Description)
+		for _, line := range c.Conflicting {
+			fmt.Fprintf(w, "\t%s\n", line)
+		}
+	}
+}
+
+// independentSources drops sources that are already contained in HEAD or
+// in another source, along with duplicates, keeping the order of the rest.
+func independentSources(head string, hashes, names []string) ([]string, []string, error) {
+	contained, err := ancestors(head)
+	if err != nil {
+		return nil, nil, err
+	}
+	reach := make([]map[string]bool, len(hashes))
+	for i, h := range hashes {
+		if reach[i], err = ancestors(h); err != nil {
+			return nil, nil, err
+		}
+	}
+	var keptHashes, keptNames []string
+	for i, h := range hashes {
+		redundant := contained[h]
+		for j, other := range hashes {
+			if j != i && reach[j][h] && (other != h || j < i) {
+				redundant = true
+			}
+		}
+		if !redundant {
+			keptHashes = append(keptHashes, h)
+			keptNames = append(keptNames, names[i])
+		}
+	}
+	return keptHashes, keptNames, nil
+}
+
+// combineWrites merges two lastWrites results, keeping the later timestamp
+// for each quad.
+func combineWrites(a, b map[string]map[string]time.Time) map[string]map[string]time.Time {
+	for graph, lines := range b {
+		if a[graph] == nil {
+			a[graph] = make(map[string]time.Time, len(lines))
+		}
+		for line, ts := range lines {
+			if ts.After(a[graph][line]) {
+				a[graph][line] = ts
+			}
+		}
+	}
+	return a
+}
+
+var mergeCmd = &cobra.Command{
+	Use:   "merge <branch>...",
+	Short: "Merge other branches into the current branch",
+	Long: `Merge one or more branches into the current branch.
+
+Given several branches, merge performs an octopus merge: each branch is
+merged in turn and a single commit is recorded with HEAD and every branch
+as parents. All of them must merge cleanly; if any conflicts, nothing is
+committed and the branches can be merged one at a time instead. Branches
+already contained in HEAD or in another of the branches are skipped. On a
+detached HEAD, the merge moves HEAD alone.
+
+When the merge of a single branch conflicts, nothing is committed either,
+but the conflicts are written to .quad-db/MERGE_CONFLICTS: each with the
+statements both sides added, between conflict markers in a TriG-like text
+form, or as JSON with merge.conflictStyle=json, for scripts to fill in
+each conflict's "resolution". Edit it to keep the statements the merge
+should have, then run 'merge --continue' to commit the merge, or 'merge
+--abort' to give it up.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		cont, _ := cmd.Flags().GetBool("continue")
+		abort, _ := cmd.Flags().GetBool("abort")
+		if cont || abort {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.MinimumNArgs(1)(cmd, args)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		if abort, _ := cmd.Flags().GetBool("abort"); abort {
+			if !mergeInProgress() {
+				log.Fatal("There is no merge to abort.")
+			}
+			clearMergeConflicts()
+			fmt.Println("Merge aborted.")
+			return
+		}
+		if cont, _ := cmd.Flags().GetBool("continue"); cont {
+			continueMerge()
+			return
+		}
+		if mergeInProgress() {
+			log.Fatal("A merge stopped at conflicts; resolve them and run 'quad-db merge --continue', or run 'quad-db merge --abort'.")
+		}
+		strategy, _ := cmd.Flags().GetString("strategy")
+		if strategy != "three-way" && strategy != "crdt" {
+			log.Fatalf("Unknown merge strategy %q (expected three-way or crdt).", strategy)
+		}
+		sign, _ := cmd.Flags().GetBool("gpg-sign")
+		mergeBranches(args, strategy == "crdt", sign)
+	},
+}
+
+// mergeBranches merges the named branches or commits into the current
+// branch, fast-forwarding when a single one contains HEAD. It exits on
+// failure, leaving the branch unchanged.
+func mergeBranches(args []string, crdt, sign bool) {
+	oursHash, err := resolveHead()
+	if err != nil {
+		log.Fatalf("Could not resolve HEAD: %v", err)
+	}
+	var sources []string
+	for _, arg := range args {
+		theirsHash, err := resolveCommitish(arg)
+		if err != nil {
+			log.Fatalf("Could not resolve %s: %v", arg, err)
+		}
+		sources = append(sources, theirsHash)
+	}
+	sources, names, err := independentSources(oursHash, sources, args)
+	if err != nil {
+		log.Fatalf("Failed to walk history: %v", err)
+	}
+	if len(sources) == 0 {
+		fmt.Println("Already up to date.")
+		return
+	}
+	if len(sources) == 1 {
+		baseHash, err := findMergeBase(oursHash, sources[0])
+		if err != nil {
+			log.Fatalf("Failed to find merge base: %v", err)
+		}
+		if baseHash == oursHash {
+			fastForward(oursHash, sources[0])
+			return
+		}
+	}
+
+	ours, err := loadState(oursHash)
+	if err != nil {
+		log.Fatalf("Failed to read HEAD: %v", err)
+	}
+	merged := ours
+	var oursWrites map[string]map[string]time.Time
+	var allConflicts []quadstore.Conflict
+	failed := 0
+	for i, theirsHash := range sources {
+		baseHash, err := findMergeBase(oursHash, theirsHash)
+		if err != nil {
+			log.Fatalf("Failed to find merge base with %s: %v", names[i], err)
+		}
+		base, err := loadState(baseHash)
+		if err != nil {
+			log.Fatalf("Failed to read merge base: %v", err)
+		}
+		theirs, err := loadState(theirsHash)
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", names[i], err)
+		}
+
+		// With CRDT, "ours" is HEAD plus every branch merged so far.
+		var theirsWrites map[string]map[string]time.Time
+		if crdt {
+			stop, err := ancestors(baseHash)
+			if err != nil {
+				log.Fatalf("Failed to walk history: %v", err)
+			}
+			if i == 0 {
+				if oursWrites, err = lastWrites(oursHash, stop); err != nil {
+					log.Fatalf("Failed to walk history: %v", err)
+				}
+			}
+			if theirsWrites, err = lastWrites(theirsHash, stop); err != nil {
+				log.Fatalf("Failed to walk history: %v", err)
+			}
+		}
+
+		var conflicts []quadstore.Conflict
+		merged, conflicts = mergeStates(base, merged, theirs, oursWrites, theirsWrites, crdt)
+		allConflicts = append(allConflicts, conflicts...)
+		if crdt {
+			oursWrites = combineWrites(oursWrites, theirsWrites)
+		}
+		if len(conflicts) > 0 && !crdt {
+			if len(sources) > 1 {
+				fmt.Printf("Merging %s:\n", names[i])
+			}
+			printConflicts(os.Stdout, conflicts)
+			failed++
+		}
+	}
+	if failed > 0 {
+		if len(sources) > 1 {
+			log.Fatalf("Octopus merge failed: %d of %d branches conflict; nothing was committed. Merge them one at a time to resolve the conflicts.", failed, len(sources))
+		}
+		if err := saveMergeConflicts(oursHash, sources[0], names[0], ours, merged, allConflicts, sign); err != nil {
+			log.Fatalf("Automatic merge failed with %d conflict(s), and they could not be saved: %v", len(allConflicts), err)
+		}
+		log.Fatalf("Automatic merge failed with %d conflict(s); nothing was committed. Resolve them in %s and run 'quad-db merge --continue', or run 'quad-db merge --abort'.", len(allConflicts), mergeConflictsPath)
+	}
+	commitMerge(oursHash, sources, names, ours, merged, allConflicts, sign)
+}
+
+// commitMerge commits the merge of sources, named by names, into the
+// current branch at oursHash, with merged as its content.
+func commitMerge(oursHash string, sources, names []string, ours, merged map[string]quadSet, conflicts []quadstore.Conflict, sign bool) {
+	treeHash, err := writeState(merged)
+	if err != nil {
+		log.Fatalf("Failed to write merged tree: %v", err)
+	}
+	message, err := mergeMessage(names, ours, merged, conflicts)
+	if err != nil {
+		log.Fatalf("Failed to render merge message: %v", err)
+	}
+	mergeCommit := Commit{
+		Tree:      treeHash,
+		Parents:   append([]string{oursHash}, sources...),
+		Author:    commitAuthor(),
+		Message:   message,
+		Timestamp: clock.Now(),
+	}
+	if mergeCommit.Stats, err = computeStats(ours, merged); err != nil {
+		log.Fatalf("Failed to compute merge stats: %v", err)
+	}
+	if sign {
+		if err := signCommit(&mergeCommit); err != nil {
+			log.Fatalf("Failed to sign merge commit: %v", err)
+		}
+	}
+	if err := enforceSignaturePolicy(currentBranch(), &mergeCommit); err != nil {
+		log.Fatal(err)
+	}
+	commitHash, err := writeObject(mergeCommit)
+	if err != nil {
+		log.Fatalf("Failed to write merge commit: %v", err)
+	}
+	if err := authorizeMerge(oursHash, commitHash); err != nil {
+		log.Fatalf("Merge rejected: %v", err)
+	}
+	if err := updateHead(commitHash); err != nil {
+		log.Fatalf("Failed to update branch reference: %v", err)
+	}
+	fmt.Printf("[%s] %s\n", commitHash[:7], strings.SplitN(message, "\n", 2)[0])
+	syncAfterCommit(currentBranch())
+}
+
+// authorizeMerge checks that the acting identity may move HEAD's branch, or
+// a detached HEAD itself, from oursHash to newHash by a merge.
+func authorizeMerge(oursHash, newHash string) error {
+	target, err := headTarget()
+	if err != nil {
+		return err
+	}
+	return authorizeWrite(quadstore.ActionMerge, actingIdentity(), target, oursHash, newHash)
+}
+
+// fastForward moves the current branch from oursHash to its descendant
+// theirsHash, enforcing the branch's signing policy on the new commits.
+func fastForward(oursHash, theirsHash string) {
+	if required, err := requiresSignedCommits(currentBranch()); err != nil {
+		log.Fatal(err)
+	} else if required {
+		stop, err := ancestors(oursHash)
+		if err != nil {
+			log.Fatalf("Failed to walk history: %v", err)
+		}
+		if bad, err := verifyRange(theirsHash, stop); bad != "" {
+			log.Fatalf("Refusing to fast-forward %s: commit %s is not verifiable: %v", currentBranch(), bad[:7], err)
+		}
+	}
+	if err := authorizeMerge(oursHash, theirsHash); err != nil {
+		log.Fatalf("Merge rejected: %v", err)
+	}
+	if err := updateHead(theirsHash); err != nil {
+		log.Fatalf("Failed to update branch reference: %v", err)
+	}
+	fmt.Printf("Fast-forward to %s\n", theirsHash[:7])
+	syncAfterCommit(currentBranch())
+}
+
+// mergeMessage renders the merge.template setting (or the default template)
+// for a merge of sources into the current branch.
+func mergeMessage(sources []string, ours, merged map[string]quadSet, conflicts []quadstore.Conflict) (string, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return "", err
+	}
+	text := cfg.Get("merge.template")
+	if text == "" && len(sources) > 1 {
+		text = defaultOctopusTemplate
+	} else if text == "" {
+		text = defaultMergeTemplate
+	}
+	data := messageData{Branch: currentBranch(), Source: strings.Join(sources, ", "), Sources: sources}
+	data.Added, data.Deleted = countChanges(ours, merged)
+	for _, c := range conflicts {
+		data.Conflicts = append(data.Conflicts, c.Description)
+	}
+	return renderMessage(text, data)
+}
@@ -0,0 +1,134 @@
+// merge_test.go
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+)
+
+func TestMergeStatesConflicts(t *testing.T) {
+	const (
+		g      = "<http://example.org/g>"
+		name   = `<http://example.org/s> <http://example.org/name> "Ada" .`
+		ours   = `<http://example.org/s> <http://example.org/name> "Ada Lovelace" .`
+		theirs = `<http://example.org/s> <http://example.org/name> "Augusta Ada" .`
+		other  = `<http://example.org/t> <http://example.org/name> "Other" .`
+	)
+	state := func(lines ...string) map[string]quadSet {
+		set := make(quadSet)
+		for _, line := range lines {
+			set[line] = true
+		}
+		return map[string]quadSet{g: set}
+	}
+	tests := []struct {
+		name       string
+		base       map[string]quadSet
+		ours       map[string]quadSet
+		theirs     map[string]quadSet
+		wantMerged []string
+		wantOutput string
+	}{
+		{
+			name:       "clean",
+			base:       state(name),
+			ours:       state(ours),
+			theirs:     state(name, other),
+			wantMerged: []string{ours, other},
+		},
+		{
+			name:       "conflicting values",
+			base:       state(name),
+			ours:       state(ours),
+			theirs:     state(theirs),
+			wantMerged: []string{ours, theirs},
+			wantOutput: "CONFLICT (CONFLICTING_VALUES): Both branches set different values for <http://example.org/s> <http://example.org/name> in graph <http://example.org/g>\n" +
+				"\t" + ours + "\n\t" + theirs + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, conflicts := mergeStates(tt.base, tt.ours, tt.theirs, nil, nil, false)
+			if len(merged[g]) != len(tt.wantMerged) {
+				t.Errorf("merged %d quad(s), want %d", len(merged[g]), len(tt.wantMerged))
+			}
+			for _, line := range tt.wantMerged {
+				if !merged[g][line] {
+					t.Errorf("merged state lacks %s", line)
+				}
+			}
+			var out bytes.Buffer
+			printConflicts(&out, conflicts)
+			if out.String() != tt.wantOutput {
+				t.Errorf("printed conflicts:\n%s\nwant:\n%s", out.String(), tt.wantOutput)
+			}
+		})
+	}
+}
+
+func TestPrintConflicts(t *testing.T) {
+	conflicts := []quadstore.Conflict{
+		{Type: "GRAPH_CHANGED", Description: "<http://example.org/g> has been deleted or renamed since"},
+		{Type: "ALREADY_REMOVED", Description: "1 quad(s) the commit added to <http://example.org/h> have been removed since", Conflicting: []string{"<a> <b> <c> ."}},
+	}
+	var out bytes.Buffer
+	printConflicts(&out, conflicts)
+	want := strings.Join([]string{
+		"CONFLICT (GRAPH_CHANGED): <http://example.org/g> has been deleted or renamed since",
+		"CONFLICT (ALREADY_REMOVED): 1 quad(s) the commit added to <http://example.org/h> have been removed since",
+		"\t<a> <b> <c> .",
+		"",
+	}, "\n")
+	if out.String() != want {
+		t.Errorf("printConflicts:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestPrintMergeStatus(t *testing.T) {
+	newTestRepo(t)
+	var out bytes.Buffer
+	printMergeStatus(&out)
+	if out.Len() != 0 {
+		t.Errorf("status without a merge printed %q", out.String())
+	}
+
+	const (
+		g      = "<http://example.org/g>"
+		h      = "<http://example.org/h>"
+		base   = `<http://example.org/s> <http://example.org/name> "Ada" .`
+		ours   = `<http://example.org/s> <http://example.org/name> "Ada Lovelace" .`
+		theirs = `<http://example.org/s> <http://example.org/name> "Augusta Ada" .`
+	)
+	state := func(line string) map[string]quadSet {
+		return map[string]quadSet{g: {line: true}, h: {line: true}}
+	}
+	root, err := resolveCommitish("main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oursHash := writeTestCommit(t, map[string][]string{g: {ours}, h: {ours}}, root)
+	theirsHash := writeTestCommit(t, map[string][]string{g: {theirs}, h: {theirs}}, root)
+	merged, conflicts := mergeStates(state(base), state(ours), state(theirs), nil, nil, false)
+	if len(conflicts) != 2 {
+		t.Fatalf("merge found %d conflict(s), want 2", len(conflicts))
+	}
+	if err := saveMergeConflicts(oursHash, theirsHash, "feature", state(ours), merged, conflicts, false); err != nil {
+		t.Fatal(err)
+	}
+
+	printMergeStatus(&out)
+	for _, want := range []string{
+		"You are merging feature (" + shortHash(theirsHash) + "); 2 of 2 conflict(s) unresolved.",
+		"'quad-db merge --continue'",
+		"'quad-db merge --abort'",
+		"  " + g + ": 1 unresolved\n",
+		"  " + h + ": 1 unresolved\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("status:\n%s\nlacks %q", out.String(), want)
+		}
+	}
+}
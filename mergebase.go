@@ -0,0 +1,249 @@
+// mergebase.go
+package main
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// generations computes the generation of commits: 1 for a root commit, and
+// one more than its highest parent's for any other. A commit's ancestors
+// all have lower generations, which lets a walk looking for one commit stop
+// at the generation it is at.
+type generations struct {
+	gen     map[string]int
+	parents map[string][]string
+}
+
+func newGenerations() *generations {
+	return &generations{gen: make(map[string]int), parents: make(map[string][]string)}
+}
+
+// of returns the generation of hash, computing those of its ancestors on
+// the way without recursing.
+func (g *generations) of(ctx context.Context, hash string) (int, error) {
+	stack := []string{hash}
+	for len(stack) > 0 {
+		h := stack[len(stack)-1]
+		if _, ok := g.gen[h]; ok {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		parents, ok := g.parents[h]
+		if !ok {
+			if err := ctx.Err(); err != nil {
+				return 0, err
+			}
+			commit, err := readCommit(h)
+			if err != nil {
+				return 0, err
+			}
+			parents = commit.Parents
+			g.parents[h] = parents
+		}
+		highest, pending := 0, false
+		for _, p := range parents {
+			if gp, ok := g.gen[p]; ok {
+				highest = max(highest, gp)
+			} else {
+				stack = append(stack, p)
+				pending = true
+			}
+		}
+		if !pending {
+			g.gen[h] = highest + 1
+			stack = stack[:len(stack)-1]
+		}
+	}
+	return g.gen[hash], nil
+}
+
+// commitQueue yields commits from the highest generation down.
+type commitQueue struct {
+	hashes []string
+	gen    map[string]int
+}
+
+func (q *commitQueue) Len() int { return len(q.hashes) }
+func (q *commitQueue) Less(i, j int) bool {
+	a, b := q.hashes[i], q.hashes[j]
+	if q.gen[a] != q.gen[b] {
+		return q.gen[a] > q.gen[b]
+	}
+	return a < b
+}
+func (q *commitQueue) Swap(i, j int) { q.hashes[i], q.hashes[j] = q.hashes[j], q.hashes[i] }
+func (q *commitQueue) Push(x any)    { q.hashes = append(q.hashes, x.(string)) }
+func (q *commitQueue) Pop() any {
+	h := q.hashes[len(q.hashes)-1]
+	q.hashes = q.hashes[:len(q.hashes)-1]
+	return h
+}
+
+// Paint flags of mergeBases' walk.
+const (
+	paintA uint8 = 1 << iota
+	paintB
+	paintStale // reached from a common ancestor already found
+)
+
+// mergeBases returns the best common ancestors of a and b; see
+// quadstore.Store.MergeBase. Both histories are walked together from the
+// highest generation down, marking each commit with the sides it is
+// reachable from; a commit reached from both is a candidate, and the walk
+// below it marks commits stale instead. The walk ends once every queued
+// commit is stale, rather than at the roots.
+func mergeBases(ctx context.Context, a, b string) ([]string, error) {
+	if a == b {
+		return []string{a}, nil
+	}
+	gens := newGenerations()
+	for _, h := range []string{a, b} {
+		if _, err := gens.of(ctx, h); err != nil {
+			return nil, err
+		}
+	}
+	flags := map[string]uint8{a: paintA, b: paintB}
+	queue := &commitQueue{hashes: []string{a, b}, gen: gens.gen}
+	heap.Init(queue)
+	var candidates []string
+	found := make(map[string]bool)
+	for anyLive(queue, flags) {
+		h := heap.Pop(queue).(string)
+		f := flags[h]
+		if f == paintA|paintB {
+			if !found[h] {
+				found[h] = true
+				candidates = append(candidates, h)
+			}
+			f |= paintStale
+		}
+		for _, p := range gens.parents[h] {
+			if flags[p]&f == f {
+				continue
+			}
+			flags[p] |= f
+			heap.Push(queue, p)
+		}
+	}
+
+	// A candidate the walk from another one reached is not a best common
+	// ancestor, and neither is one reachable from another some longer way.
+	var bases []string
+	for _, h := range candidates {
+		if flags[h]&paintStale == 0 {
+			bases = append(bases, h)
+		}
+	}
+	var best []string
+	for i, h := range bases {
+		redundant := false
+		for j, other := range bases {
+			if i == j {
+				continue
+			}
+			var err error
+			if redundant, err = isAncestor(ctx, gens, h, other); err != nil {
+				return nil, err
+			} else if redundant {
+				break
+			}
+		}
+		if !redundant {
+			best = append(best, h)
+		}
+	}
+	return best, nil
+}
+
+// anyLive reports whether any queued commit is not yet stale.
+func anyLive(queue *commitQueue, flags map[string]uint8) bool {
+	for _, h := range queue.hashes {
+		if flags[h]&paintStale == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// isAncestor reports whether anc is reachable from desc, walking no lower
+// than anc's generation.
+func isAncestor(ctx context.Context, gens *generations, anc, desc string) (bool, error) {
+	floor, err := gens.of(ctx, anc)
+	if err != nil {
+		return false, err
+	}
+	if _, err := gens.of(ctx, desc); err != nil {
+		return false, err
+	}
+	seen := make(map[string]bool)
+	stack := []string{desc}
+	for len(stack) > 0 {
+		h := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if h == anc {
+			return true, nil
+		}
+		if seen[h] || gens.gen[h] <= floor {
+			continue
+		}
+		seen[h] = true
+		stack = append(stack, gens.parents[h]...)
+	}
+	return false, nil
+}
+
+var mergeBaseCmd = &cobra.Command{
+	Use:   "merge-base <commit> <commit>",
+	Short: "Find the best common ancestor of two commits",
+	Long: `Print the best common ancestor of two commits, the one a merge of them
+would start from: a commit both can reach that is not an ancestor of another
+such commit. After criss-cross merges there can be several; --all prints
+them all, from the highest generation down. Exits with status 1, printing
+nothing, if the commits have no common ancestor.
+
+With --is-ancestor, print nothing and exit with status 0 if the first commit
+is an ancestor of (or the same as) the second, and 1 otherwise.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		var hashes [2]string
+		for i, arg := range args {
+			hash, err := resolveCommitish(arg)
+			if err != nil {
+				log.Fatalf("Could not resolve %s: %v", arg, err)
+			}
+			hashes[i] = hash
+		}
+		ctx := commandContext(cmd)
+		if check, _ := cmd.Flags().GetBool("is-ancestor"); check {
+			ok, err := isAncestor(ctx, newGenerations(), hashes[0], hashes[1])
+			exitIfInterrupted(ctx, "the walk was stopped")
+			if err != nil {
+				log.Fatalf("Failed to walk history: %v", err)
+			}
+			if !ok {
+				os.Exit(1)
+			}
+			return
+		}
+		bases, err := mergeBases(ctx, hashes[0], hashes[1])
+		exitIfInterrupted(ctx, "the walk was stopped")
+		if err != nil {
+			log.Fatalf("Failed to walk history: %v", err)
+		}
+		if len(bases) == 0 {
+			os.Exit(1)
+		}
+		if all, _ := cmd.Flags().GetBool("all"); !all {
+			bases = bases[:1]
+		}
+		for _, base := range bases {
+			fmt.Println(base)
+		}
+	},
+}
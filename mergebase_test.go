@@ -0,0 +1,68 @@
+// mergebase_test.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestMergeBases(t *testing.T) {
+	newTestRepo(t)
+	n := 0
+	commit := func(parents ...string) string {
+		n++
+		line := fmt.Sprintf(`<http://example.org/s> <http://example.org/p> "%d" <http://example.org/g> .`, n)
+		return writeTestCommit(t, map[string][]string{"<http://example.org/g>": {line}}, parents...)
+	}
+	root := commit()
+	a1 := commit(root)
+	a2 := commit(a1)
+	b1 := commit(root)
+	// A criss-cross: each side merges the other's first commit.
+	x := commit(a1, b1)
+	y := commit(b1, a1)
+	other := commit()
+
+	tests := []struct {
+		name string
+		a, b string
+		want []string
+	}{
+		{"same commit", a2, a2, []string{a2}},
+		{"ancestor", a1, a2, []string{a1}},
+		{"fork", a2, b1, []string{root}},
+		{"criss-cross", x, y, []string{a1, b1}},
+		{"unrelated", a2, other, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mergeBases(context.Background(), tt.a, tt.b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			slices.Sort(got)
+			want := slices.Clone(tt.want)
+			slices.Sort(want)
+			if !slices.Equal(got, want) {
+				t.Errorf("mergeBases = %v, want %v", got, want)
+			}
+		})
+	}
+
+	for _, tt := range []struct {
+		anc, desc string
+		want      bool
+	}{
+		{root, a2, true},
+		{a1, x, true},
+		{a2, x, false},
+		{other, a2, false},
+	} {
+		got, err := isAncestor(context.Background(), newGenerations(), tt.anc, tt.desc)
+		if err != nil || got != tt.want {
+			t.Errorf("isAncestor(%s, %s) = %v, %v; want %v", shortHash(tt.anc), shortHash(tt.desc), got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,399 @@
+// mergeconflicts.go
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+)
+
+// A merge of one branch that stops at conflicts leaves two files behind:
+// mergeStatePath, which records what was being merged, and
+// mergeConflictsPath, the conflicts document. The document lists each
+// conflict with the statements both sides added; resolving a conflict
+// means saying which statements the merge keeps, by hand or with a script,
+// after which 'merge --continue' commits the merge. The document is
+// written as text (merge.conflictStyle=text, the default), a TriG-like
+// form with conflict markers, or as JSON (merge.conflictStyle=json), and
+// either form is read back.
+
+// conflictsHeader starts the text form of a conflicts document.
+const conflictsHeader = "# quad-db merge conflicts v1"
+
+// A mergeStop is what mergeStatePath records about a merge stopped at
+// conflicts.
+type mergeStop struct {
+	Branch string `json:"branch"`
+	Ours   string `json:"ours"`
+	Theirs string `json:"theirs"`
+	Name   string `json:"name"`
+	// Tree is the merged content, holding the statements of both sides
+	// where they conflict.
+	Tree      string               `json:"tree"`
+	Sign      bool                 `json:"sign,omitempty"`
+	Conflicts []quadstore.Conflict `json:"conflicts"`
+}
+
+// A conflictsDocument is the JSON form of the conflicts document.
+type conflictsDocument struct {
+	Version   int             `json:"version"`
+	Merging   string          `json:"merging"`
+	Conflicts []conflictEntry `json:"conflicts"`
+}
+
+// A conflictEntry is one conflict and, once resolved, its resolution: the
+// statements of the graph, in place of those either side added, that the
+// merge keeps. A nil resolution is unresolved; an empty one keeps none.
+type conflictEntry struct {
+	ID          int      `json:"id"`
+	Type        string   `json:"type"`
+	Description string   `json:"description"`
+	Graph       string   `json:"graph"`
+	Ours        []string `json:"ours"`
+	Theirs      []string `json:"theirs"`
+	Resolution  []string `json:"resolution"`
+}
+
+// conflictEntries returns the unresolved entries of conflicts, splitting
+// the statements of each by the side that added them.
+func conflictEntries(conflicts []quadstore.Conflict, ours map[string]quadSet) []conflictEntry {
+	entries := make([]conflictEntry, 0, len(conflicts))
+	for i, c := range conflicts {
+		entry := conflictEntry{ID: i + 1, Type: c.Type, Description: c.Description, Graph: c.Graph}
+		for _, line := range c.Conflicting {
+			if ours[c.Graph][line] {
+				entry.Ours = append(entry.Ours, line)
+			} else {
+				entry.Theirs = append(entry.Theirs, line)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// conflictStyle returns the merge.conflictStyle setting: text or json.
+func conflictStyle() (string, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return "", err
+	}
+	switch style := cfg.Get("merge.conflictStyle"); style {
+	case "", "text":
+		return "text", nil
+	case "json":
+		return style, nil
+	default:
+		return "", fmt.Errorf("invalid merge.conflictStyle %q (use text or json)", style)
+	}
+}
+
+// mergeInProgress reports whether a merge stopped at conflicts.
+func mergeInProgress() bool {
+	_, err := os.Stat(mergeStatePath)
+	return err == nil
+}
+
+// saveMergeConflicts records a merge of theirsHash, named name, into
+// oursHash that stopped at conflicts, and writes its conflicts document.
+func saveMergeConflicts(oursHash, theirsHash, name string, ours, merged map[string]quadSet, conflicts []quadstore.Conflict, sign bool) error {
+	style, err := conflictStyle()
+	if err != nil {
+		return err
+	}
+	tree, err := writeState(merged)
+	if err != nil {
+		return err
+	}
+	stop := mergeStop{Branch: currentBranch(), Ours: oursHash, Theirs: theirsHash, Name: name, Tree: tree, Sign: sign, Conflicts: conflicts}
+	doc := conflictsDocument{
+		Version:   1,
+		Merging:   fmt.Sprintf("%s (%s) into %s (%s)", name, shortHash(theirsHash), stop.Branch, shortHash(oursHash)),
+		Conflicts: conflictEntries(conflicts, ours),
+	}
+
+	var buf bytes.Buffer
+	if style == "json" {
+		enc := json.NewEncoder(&buf)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false) // IRIs stay readable
+		err = enc.Encode(doc)
+	} else {
+		err = writeConflictsText(&buf, &doc, stop.Branch)
+	}
+	if err != nil {
+		return err
+	}
+	state, err := json.MarshalIndent(stop, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(mergeConflictsPath, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(mergeStatePath, state, 0644)
+}
+
+// graphBlockName is how the text form opens a graph: by name, or for the
+// default graph, as TriG does, without one.
+func graphBlockName(graph string) string {
+	if graph == defaultGraph {
+		return ""
+	}
+	return graph + " "
+}
+
+// writeConflictsText writes the text form of a conflicts document.
+func writeConflictsText(w io.Writer, doc *conflictsDocument, branch string) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, conflictsHeader)
+	fmt.Fprintf(bw, "# Merging %s.\n", doc.Merging)
+	fmt.Fprintln(bw, "#")
+	fmt.Fprintln(bw, "# Each conflict lists the statements both sides added between markers.")
+	fmt.Fprintln(bw, "# Leave in its block the statements the merge should keep, removing the")
+	fmt.Fprintln(bw, "# others and the markers, then run 'quad-db merge --continue'. Blocks")
+	fmt.Fprintln(bw, "# may also be left empty, or given statements of their own.")
+	for _, c := range doc.Conflicts {
+		fmt.Fprintf(bw, "\n# conflict %d: %s\n# %s\n", c.ID, c.Type, c.Description)
+		fmt.Fprintf(bw, "%s{\n", graphBlockName(c.Graph))
+		if c.Resolution != nil {
+			for _, line := range c.Resolution {
+				fmt.Fprintln(bw, line)
+			}
+		} else {
+			fmt.Fprintf(bw, "<<<<<<< ours (%s)\n", branch)
+			for _, line := range c.Ours {
+				fmt.Fprintln(bw, line)
+			}
+			fmt.Fprintln(bw, "=======")
+			for _, line := range c.Theirs {
+				fmt.Fprintln(bw, line)
+			}
+			fmt.Fprintln(bw, ">>>>>>> theirs")
+		}
+		fmt.Fprintln(bw, "}")
+	}
+	return bw.Flush()
+}
+
+// readConflictsText reads the resolutions from the text form of a
+// conflicts document into the entries it was written from. A block still
+// holding markers is unresolved.
+func readConflictsText(r io.Reader, entries []conflictEntry) error {
+	byID := make(map[int]*conflictEntry, len(entries))
+	for i := range entries {
+		byID[entries[i].ID] = &entries[i]
+	}
+	var current *conflictEntry
+	inBlock, marked := false, false
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "# conflict "):
+			if inBlock {
+				return fmt.Errorf("line %d: conflict starts before the previous block is closed", n)
+			}
+			idText, _, _ := strings.Cut(strings.TrimPrefix(line, "# conflict "), ":")
+			id, err := strconv.Atoi(strings.TrimSpace(idText))
+			if err != nil || byID[id] == nil {
+				return fmt.Errorf("line %d: unknown conflict %q", n, idText)
+			}
+			current = byID[id]
+		case strings.HasPrefix(line, "#"):
+		case strings.HasSuffix(line, "{") && !inBlock:
+			if current == nil {
+				return fmt.Errorf("line %d: graph block outside a conflict", n)
+			}
+			inBlock, marked, lines = true, false, []string{}
+		case line == "}" && inBlock:
+			if !marked {
+				current.Resolution = lines
+			}
+			inBlock, current = false, nil
+		case !inBlock:
+			return fmt.Errorf("line %d: statement outside a graph block", n)
+		case strings.HasPrefix(line, "<<<<<<<"), line == "=======", strings.HasPrefix(line, ">>>>>>>"):
+			marked = true
+		default:
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if inBlock {
+		return fmt.Errorf("the block of conflict %d is not closed", current.ID)
+	}
+	return nil
+}
+
+// loadMergeConflicts reads the stopped merge and its conflicts document,
+// in whichever form it is now.
+func loadMergeConflicts() (*mergeStop, []conflictEntry, error) {
+	data, err := os.ReadFile(mergeStatePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	var stop mergeStop
+	if err := json.Unmarshal(data, &stop); err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", mergeStatePath, err)
+	}
+	ours, err := loadState(stop.Ours)
+	if err != nil {
+		return nil, nil, err
+	}
+	entries := conflictEntries(stop.Conflicts, ours)
+
+	data, err = os.ReadFile(mergeConflictsPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var doc conflictsDocument
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", mergeConflictsPath, err)
+		}
+		for _, c := range doc.Conflicts {
+			if c.ID < 1 || c.ID > len(entries) {
+				return nil, nil, fmt.Errorf("%s: unknown conflict %d", mergeConflictsPath, c.ID)
+			}
+			entries[c.ID-1].Resolution = c.Resolution
+		}
+	} else if err := readConflictsText(bytes.NewReader(data), entries); err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", mergeConflictsPath, err)
+	}
+	return &stop, entries, nil
+}
+
+// printMergeStatus tells, for status, that a merge stopped at conflicts,
+// how to finish or abort it, and which graphs conflict. It prints nothing
+// when no merge is in progress.
+func printMergeStatus(w io.Writer) {
+	if !mergeInProgress() {
+		return
+	}
+	stop, entries, err := loadMergeConflicts()
+	if err != nil {
+		fmt.Fprintf(w, "You are in the middle of a merge, but its conflicts cannot be read: %v\n", err)
+		fmt.Fprintf(w, "  (fix %s and run 'quad-db merge --continue')\n", mergeConflictsPath)
+		fmt.Fprintln(w, "  (use 'quad-db merge --abort' to abort the merge)")
+		return
+	}
+	unresolved := make(map[string]int)
+	var graphs []string
+	total := 0
+	for _, c := range entries {
+		if _, ok := unresolved[c.Graph]; !ok {
+			unresolved[c.Graph] = 0
+			graphs = append(graphs, c.Graph)
+		}
+		if c.Resolution == nil {
+			unresolved[c.Graph]++
+			total++
+		}
+	}
+	sort.Strings(graphs)
+	fmt.Fprintf(w, "You are merging %s (%s); %d of %d conflict(s) unresolved.\n", stop.Name, shortHash(stop.Theirs), total, len(entries))
+	if total > 0 {
+		fmt.Fprintf(w, "  (resolve them in %s and run 'quad-db merge --continue')\n", mergeConflictsPath)
+	} else {
+		fmt.Fprintln(w, "  (all conflicts resolved: run 'quad-db merge --continue' to commit the merge)")
+	}
+	fmt.Fprintln(w, "  (use 'quad-db merge --abort' to abort the merge)")
+	fmt.Fprintln(w, "Conflicted graphs:")
+	for _, graph := range graphs {
+		name := graph
+		if graph == defaultGraph {
+			name = "(default graph)"
+		}
+		if n := unresolved[graph]; n > 0 {
+			fmt.Fprintf(w, "  %s: %d unresolved\n", name, n)
+		} else {
+			fmt.Fprintf(w, "  %s: resolved\n", name)
+		}
+	}
+}
+
+// continueMerge commits a merge stopped at conflicts once every conflict
+// is resolved.
+func continueMerge() {
+	if !mergeInProgress() {
+		log.Fatal("There is no merge to continue.")
+	}
+	stop, entries, err := loadMergeConflicts()
+	if err != nil {
+		log.Fatalf("Failed to read the merge conflicts: %v", err)
+	}
+	head, err := resolveHead()
+	if err != nil {
+		log.Fatalf("Could not resolve HEAD: %v", err)
+	}
+	if currentBranch() != stop.Branch || head != stop.Ours {
+		log.Fatalf("HEAD has moved since the merge stopped; run 'quad-db merge --abort' and merge again.")
+	}
+	unresolved := 0
+	for _, c := range entries {
+		if c.Resolution == nil {
+			fmt.Printf("unresolved conflict %d: %s\n", c.ID, c.Description)
+			unresolved++
+		}
+	}
+	if unresolved > 0 {
+		log.Fatalf("%d conflict(s) left to resolve in %s.", unresolved, mergeConflictsPath)
+	}
+
+	merged, err := loadTreeState(context.Background(), stop.Tree, "")
+	if err != nil {
+		log.Fatalf("Failed to read the merged content: %v", err)
+	}
+	for _, c := range entries {
+		set := merged[c.Graph]
+		if set == nil {
+			set = make(quadSet)
+			merged[c.Graph] = set
+		}
+		for _, line := range append(append([]string{}, c.Ours...), c.Theirs...) {
+			delete(set, line)
+		}
+		for _, line := range c.Resolution {
+			q, err := parseQuad(line)
+			if err != nil {
+				log.Fatalf("Conflict %d: %v", c.ID, err)
+			}
+			if q.Graph != "" && graphKey(q) != c.Graph {
+				log.Fatalf("Conflict %d: statement in graph %s, not %s", c.ID, q.Graph, c.Graph)
+			}
+			q.Graph = ""
+			set[formatQuad(q)] = true
+		}
+	}
+	ours, err := loadState(stop.Ours)
+	if err != nil {
+		log.Fatalf("Failed to read HEAD: %v", err)
+	}
+	commitMerge(stop.Ours, []string{stop.Theirs}, []string{stop.Name}, ours, merged, stop.Conflicts, stop.Sign)
+	clearMergeConflicts()
+}
+
+// clearMergeConflicts forgets a stopped merge.
+func clearMergeConflicts() {
+	for _, path := range []string{mergeConflictsPath, mergeStatePath} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Fatalf("Failed to remove %s: %v", path, err)
+		}
+	}
+}
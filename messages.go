@@ -0,0 +1,142 @@
+// messages.go
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// defaultMergeTemplate is used when merge.template is not configured.
+const defaultMergeTemplate = "Merge branch '{{.Source}}'"
+
+// defaultOctopusTemplate is used instead for merges of several branches.
+const defaultOctopusTemplate = "Merge branches {{range $i, $s := .Sources}}{{if $i}}, {{end}}'{{$s}}'{{end}}"
+
+// messageData holds the placeholders available to commit and merge message
+// templates, e.g. "Merge {{.Source}} into {{.Branch}} (+{{.Added}}/-{{.Deleted}})".
+type messageData struct {
+	Branch    string   // The branch receiving the commit.
+	Source    string   // The branch being merged, or a comma-separated list (merges only).
+	Sources   []string // Each branch being merged (merges only).
+	Added     int      // Quads added by the commit.
+	Deleted   int      // Quads deleted by the commit.
+	Conflicts []string // Descriptions of conflicting changes, if any.
+}
+
+// renderMessage expands a message template with the given data.
+func renderMessage(text string, data messageData) (string, error) {
+	tmpl, err := template.New("message").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// editMessage opens $VISUAL or $EDITOR (falling back to vi) on a file
+// pre-filled with initial and returns the saved text with comment lines
+// removed.
+func editMessage(initial string) (string, error) {
+	path := filepath.Join(dbPath, "COMMIT_EDITMSG")
+	if err := os.WriteFile(path, []byte(initial), 0644); err != nil {
+		return "", err
+	}
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "editor", path)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+	edited, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return stripComments(string(edited)), nil
+}
+
+// commitMsgHook is run on every commit message, unless commit --no-verify
+// is given, with the path of a file holding the message. It may rewrite the
+// file; exiting non-zero rejects the commit.
+var commitMsgHook = filepath.Join(dbPath, "hooks", "commit-msg")
+
+// runCommitMsgHook passes message through the commit-msg hook, if one is
+// installed and executable, and returns the message it leaves behind with
+// comment lines removed.
+func runCommitMsgHook(message string) (string, error) {
+	info, err := os.Stat(commitMsgHook)
+	if os.IsNotExist(err) || (err == nil && info.Mode()&0111 == 0) {
+		return message, nil
+	} else if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dbPath, "COMMIT_EDITMSG")
+	if err := os.WriteFile(path, []byte(message+"\n"), 0644); err != nil {
+		return "", err
+	}
+	cmd := exec.Command(commitMsgHook, path)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stderr, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("the commit-msg hook rejected the message (%v)", err)
+	}
+	rewritten, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return stripComments(string(rewritten)), nil
+}
+
+// stripComments drops lines starting with '#' and trims surrounding blank
+// space from a message.
+func stripComments(msg string) string {
+	var kept []string
+	for _, line := range strings.Split(msg, "\n") {
+		if !strings.HasPrefix(line, "#") {
+			kept = append(kept, strings.TrimRight(line, " \t\r"))
+		}
+	}
+	return strings.TrimSpace(strings.Join(kept, "\n"))
+}
+
+// currentBranch returns the name of the branch HEAD points to, or an empty
+// string if it cannot be determined.
+func currentBranch() string {
+	headRef, err := getReference("HEAD")
+	if err != nil || !strings.HasPrefix(headRef, "ref:head:") {
+		return ""
+	}
+	return strings.TrimPrefix(headRef, "ref:head:")
+}
+
+// countChanges returns how many quads were added and deleted going from
+// one state to another.
+func countChanges(before, after map[string]quadSet) (added, deleted int) {
+	for graph, set := range after {
+		for line := range set {
+			if !before[graph][line] {
+				added++
+			}
+		}
+	}
+	for graph, set := range before {
+		for line := range set {
+			if !after[graph][line] {
+				deleted++
+			}
+		}
+	}
+	return added, deleted
+}
@@ -0,0 +1,81 @@
+// metadata.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/spf13/cobra"
+)
+
+// metaPrefix starts the index keys that find commits by metadata. Each
+// key is "meta:<key>=<value>\x00<commit hash>" with an empty value, so the
+// commits carrying a pair are found with one prefix scan.
+const metaPrefix = "meta:"
+
+func metaIndexPrefix(key, value string) []byte {
+	return []byte(metaPrefix + key + "=" + value + "\x00")
+}
+
+// indexMetadata records the metadata of a commit in the index within txn.
+func indexMetadata(txn *badger.Txn, hash string, metadata map[string]string) error {
+	for key, value := range metadata {
+		if err := txn.Set(append(metaIndexPrefix(key, value), hash...), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexRawMetadata indexes data if it is the encoding of a commit with
+// metadata; other objects are ignored.
+func indexRawMetadata(txn *badger.Txn, hash string, data []byte) error {
+	if len(data) == 0 || data[0] != '{' {
+		return nil
+	}
+	var commit struct {
+		Metadata map[string]string `json:"metadata"`
+	}
+	if json.Unmarshal(data, &commit) != nil {
+		return nil // A tree, whose entries are not strings
+	}
+	return indexMetadata(txn, hash, commit.Metadata)
+}
+
+// commitsWithMetadata returns the hashes of every stored commit whose
+// metadata maps key to value, whether or not it is reachable from a branch.
+func commitsWithMetadata(key, value string) (map[string]bool, error) {
+	hashes := make(map[string]bool)
+	prefix := metaIndexPrefix(key, value)
+	err := repo.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			hashes[string(it.Item().Key()[len(prefix):])] = true
+		}
+		return nil
+	})
+	return hashes, err
+}
+
+// metadataFlags parses the --meta flags of cmd, each "key=value".
+func metadataFlags(cmd *cobra.Command) (map[string]string, error) {
+	args, _ := cmd.Flags().GetStringArray("meta")
+	if len(args) == 0 {
+		return nil, nil
+	}
+	metadata := make(map[string]string, len(args))
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || key == "" || strings.ContainsRune(key+value, 0) {
+			return nil, fmt.Errorf("invalid metadata %q (expected key=value)", arg)
+		}
+		metadata[key] = value
+	}
+	return metadata, nil
+}
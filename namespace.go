@@ -0,0 +1,209 @@
+// namespace.go
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+	"github.com/spf13/cobra"
+)
+
+// Each namespace other than the default one is a Badger instance of its own
+// under .quad-db/namespaces, with its own refs, objects and staging area, so
+// it can be backed up, size-limited (e.g. by a filesystem quota) or dropped
+// without touching the others. The config and hooks are shared.
+var namespacesPath = filepath.Join(dbPath, "namespaces")
+
+// namespace is the selected namespace, from --namespace or
+// QUAD_DB_NAMESPACE; "" is the default namespace in .quad-db itself.
+var namespace string
+
+// validNamespaceName reports whether name can be used as a namespace, which
+// is also a directory name.
+func validNamespaceName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.HasPrefix(name, "-") || strings.ContainsAny(name, "/\\ \t\n:") {
+		return fmt.Errorf("%q is not a valid namespace name", name)
+	}
+	return nil
+}
+
+// namespaceDir returns the Badger directory of a namespace.
+func namespaceDir(name string) string {
+	if name == "" {
+		return dbPath
+	}
+	return filepath.Join(namespacesPath, name)
+}
+
+// selectNamespace makes the commands that follow work on a namespace.
+func selectNamespace(name string) error {
+	if name != "" {
+		if err := validNamespaceName(name); err != nil {
+			return err
+		}
+	}
+	namespace = name
+	storePath = namespaceDir(name)
+	indexPath = filepath.Join(storePath, "index")
+	renamesPath = filepath.Join(storePath, "index.renames")
+	mergeStatePath = filepath.Join(storePath, "MERGE_STATE")
+	mergeConflictsPath = filepath.Join(storePath, "MERGE_CONFLICTS")
+	return nil
+}
+
+// namespaceNames lists the namespaces other than the default one.
+func namespaceNames() ([]string, error) {
+	entries, err := os.ReadDir(namespacesPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// dirSize returns the disk space taken by the files under dir, skipping the
+// directory skip and everything in it.
+func dirSize(dir, skip string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && path == skip {
+			return filepath.SkipDir
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += allocatedSize(info)
+		}
+		return nil
+	})
+	return size, err
+}
+
+var namespaceCmd = &cobra.Command{
+	Use:   "namespace",
+	Short: "Create, list and drop namespaces",
+	Long: `A namespace is an independent repository inside this one: its own
+branches, history and staging area, stored as a separate database under
+.quad-db/namespaces/<name>. Select one for any command with --namespace or
+the QUAD_DB_NAMESPACE environment variable; without either, commands use
+the default namespace in .quad-db itself. The config and hooks are shared
+by all namespaces.
+
+Because each namespace is a directory of its own, it can be backed up,
+size-limited or dropped without affecting the others.`,
+}
+
+var namespaceCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Create an empty namespace",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		if err := validNamespaceName(name); err != nil {
+			log.Fatal(err)
+		}
+		if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+			log.Fatalf("%v, run 'quad-db init'", quadstore.ErrNotRepository)
+		}
+		if err := selectNamespace(name); err != nil {
+			log.Fatal(err)
+		}
+		if _, err := os.Stat(storePath); !os.IsNotExist(err) {
+			log.Fatalf("Namespace %q already exists.", name)
+		}
+		if err := os.MkdirAll(storePath, 0755); err != nil {
+			log.Fatalf("Failed to create namespace: %v", err)
+		}
+		if err := initDatabase(); err != nil {
+			closeDB()
+			os.RemoveAll(storePath)
+			log.Fatalf("Failed to create namespace: %v", err)
+		}
+		fmt.Printf("Created namespace %s in %s\n", name, storePath)
+	},
+}
+
+var namespaceListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List namespaces and the disk space each takes",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+			log.Fatalf("%v, run 'quad-db init'", quadstore.ErrNotRepository)
+		}
+		names, err := namespaceNames()
+		if err != nil {
+			log.Fatalf("Failed to list namespaces: %v", err)
+		}
+		for _, name := range append([]string{""}, names...) {
+			size, err := dirSize(namespaceDir(name), namespacesPath)
+			if err != nil {
+				log.Fatalf("Failed to measure namespace: %v", err)
+			}
+			marker, label := " ", name
+			if name == namespace {
+				marker = "*"
+			}
+			if name == "" {
+				label = "(default)"
+			}
+			fmt.Printf("%s %-20s %s\n", marker, label, formatBytes(size))
+		}
+	},
+}
+
+var namespaceDropCmd = &cobra.Command{
+	Use:   "drop <name> --force",
+	Short: "Delete a namespace and all its history",
+	Long: `Delete the namespace's database directory, with every branch, commit and
+staged change in it. This cannot be undone, so --force is required. The
+default namespace cannot be dropped.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		if err := validNamespaceName(name); err != nil {
+			log.Fatal(err)
+		}
+		dir := namespaceDir(name)
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			log.Fatalf("No namespace %q.", name)
+		}
+		if force, _ := cmd.Flags().GetBool("force"); !force {
+			log.Fatalf("Refusing to drop namespace %q without --force; it deletes all its history.", name)
+		}
+		// Opening the database takes its lock, so a namespace in use, e.g.
+		// by 'quad-db serve', is not deleted from under it.
+		ndb, err := badger.Open(badger.DefaultOptions(dir).WithLogger(nil))
+		if err != nil {
+			if strings.Contains(err.Error(), "Cannot acquire directory lock") {
+				log.Fatalf("Namespace %q is in use: %v", name, quadstore.ErrRepoLocked)
+			}
+			log.Fatalf("Failed to open namespace %q: %v", name, err)
+		}
+		ndb.Close()
+		if err := os.RemoveAll(dir); err != nil {
+			log.Fatalf("Failed to drop namespace: %v", err)
+		}
+		fmt.Printf("Dropped namespace %s\n", name)
+	},
+}
@@ -0,0 +1,271 @@
+// neptune.go
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// chunkWriter writes rows to numbered, gzip-compressed files named
+// <prefix>-00000.<ext>.gz, starting a new file every limit rows (0 means no
+// limit). header, if set, is repeated at the top of every file.
+type chunkWriter struct {
+	dir, prefix, ext string
+	header           string
+	limit, rows      int
+	files            int
+	file             *os.File
+	gz               *gzip.Writer
+}
+
+func (w *chunkWriter) writeRow(row string) error {
+	if w.gz == nil || (w.limit > 0 && w.rows >= w.limit) {
+		if err := w.Close(); err != nil {
+			return err
+		}
+		name := fmt.Sprintf("%s-%05d.%s.gz", w.prefix, w.files, w.ext)
+		f, err := os.Create(filepath.Join(w.dir, name))
+		if err != nil {
+			return err
+		}
+		w.file, w.gz, w.rows = f, gzip.NewWriter(f), 0
+		w.files++
+		if w.header != "" {
+			if _, err := w.gz.Write([]byte(w.header)); err != nil {
+				return err
+			}
+		}
+	}
+	w.rows++
+	_, err := w.gz.Write([]byte(row))
+	return err
+}
+
+// Close finishes the current file, if any.
+func (w *chunkWriter) Close() error {
+	if w.gz == nil {
+		return nil
+	}
+	err := w.gz.Close()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	w.file, w.gz = nil, nil
+	return err
+}
+
+// removeChunks deletes the output of a previous export with the same prefix.
+func removeChunks(dir, prefix string) error {
+	stale, err := filepath.Glob(filepath.Join(dir, prefix+"-*.gz"))
+	if err != nil {
+		return err
+	}
+	for _, path := range stale {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportNeptuneNQuads writes a state as gzipped N-Quads chunks for the
+// Neptune bulk loader. Quads in the default graph are written as triples,
+// which Neptune loads into its default named graph.
+func exportNeptuneNQuads(state map[string]quadSet, dir string, splitSize int) error {
+	if err := removeChunks(dir, "quads"); err != nil {
+		return err
+	}
+	graphs := make([]string, 0, len(state))
+	for graph := range state {
+		graphs = append(graphs, graph)
+	}
+	sort.Strings(graphs)
+	w := &chunkWriter{dir: dir, prefix: "quads", ext: "nq", limit: splitSize}
+	for _, graph := range graphs {
+		for _, line := range graphQuads(graph, state[graph]) {
+			if err := w.writeRow(line + "\n"); err != nil {
+				w.Close()
+				return err
+			}
+		}
+	}
+	return w.Close()
+}
+
+// literalValue returns the lexical form of a literal term, unescaped.
+func literalValue(term string) string {
+	end := strings.LastIndex(term, `"`)
+	if end <= 0 {
+		return term
+	}
+	if value, err := strconv.Unquote(term[:end+1]); err == nil {
+		return value
+	}
+	return term[1:end]
+}
+
+// vertexID returns the Neptune vertex id of an IRI or blank node term.
+func vertexID(term string) string {
+	return strings.Trim(term, "<>")
+}
+
+// csvRow renders one record in the CSV dialect the bulk loader reads.
+func csvRow(fields ...string) string {
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	w.Write(fields)
+	w.Flush()
+	return b.String()
+}
+
+// neptuneNames names each class and predicate IRI of terms for the Gremlin
+// CSV format, which has no namespaces: by its local name, unless another
+// term shares it, as with http://schema.org/name and foaf:name. Those get
+// the first 6 hex digits of the SHA-1 of their namespace appended, as in
+// name_3f2a1c, so that distinct terms never share a column or label.
+func neptuneNames(terms map[string]bool) map[string]string {
+	byLocal := make(map[string][]string)
+	for term := range terms {
+		local := localName(term)
+		byLocal[local] = append(byLocal[local], term)
+	}
+	names := make(map[string]string, len(terms))
+	for local, shared := range byLocal {
+		if len(shared) == 1 {
+			names[shared[0]] = local
+			continue
+		}
+		for _, term := range shared {
+			names[term] = local + "_" + namespaceSuffix(strings.TrimSuffix(strings.Trim(term, "<>"), local))
+		}
+	}
+	return names
+}
+
+// namespaceSuffix returns the hash neptuneNames tells terms of namespace
+// apart by.
+func namespaceSuffix(namespace string) string {
+	sum := sha1.Sum([]byte(namespace))
+	return hex.EncodeToString(sum[:3])
+}
+
+// exportNeptuneCSV writes a state in Neptune's Gremlin CSV format: IRIs and
+// blank nodes become vertices, rdf:type values their labels, literal-valued
+// predicates multi-valued String properties, and the remaining triples
+// edges labelled with the predicate. Classes and predicates are named by
+// their local names, suffixed with a hash of their namespace where two
+// would share one (see neptuneNames). Graph names are not represented in
+// this format.
+func exportNeptuneCSV(state map[string]quadSet, dir string, splitSize int) error {
+	for _, prefix := range []string{"vertices", "edges"} {
+		if err := removeChunks(dir, prefix); err != nil {
+			return err
+		}
+	}
+
+	type vertex struct {
+		labels     []string
+		properties map[string][]string
+	}
+	vertices := make(map[string]*vertex)
+	getVertex := func(term string) *vertex {
+		v := vertices[term]
+		if v == nil {
+			v = &vertex{properties: make(map[string][]string)}
+			vertices[term] = v
+		}
+		return v
+	}
+	columns := make(map[string]bool) // Predicates with literal values
+	edges := make(map[string]bool)
+	terms := make(map[string]bool) // Classes and predicates to name
+	for _, set := range state {
+		for line := range set {
+			q, err := parseQuad(line)
+			if err != nil {
+				return err
+			}
+			v := getVertex(q.Subject)
+			switch {
+			case q.Predicate == rdfType && !strings.HasPrefix(q.Object, `"`):
+				v.labels = append(v.labels, q.Object)
+				terms[q.Object] = true
+			case strings.HasPrefix(q.Object, `"`):
+				v.properties[q.Predicate] = append(v.properties[q.Predicate], literalValue(q.Object))
+				columns[q.Predicate] = true
+				terms[q.Predicate] = true
+			default:
+				getVertex(q.Object)
+				edges[formatQuad(q)] = true
+				terms[q.Predicate] = true
+			}
+		}
+	}
+	name := neptuneNames(terms)
+
+	predicates := make([]string, 0, len(columns))
+	for predicate := range columns {
+		predicates = append(predicates, predicate)
+	}
+	sort.Slice(predicates, func(i, j int) bool { return name[predicates[i]] < name[predicates[j]] })
+	header := []string{"~id", "~label"}
+	for _, predicate := range predicates {
+		header = append(header, name[predicate]+":String[]")
+	}
+	ids := make([]string, 0, len(vertices))
+	for id := range vertices {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	escape := strings.NewReplacer(`\`, `\\`, ";", `\;`)
+	vw := &chunkWriter{dir: dir, prefix: "vertices", ext: "csv", header: csvRow(header...), limit: splitSize}
+	for _, id := range ids {
+		v := vertices[id]
+		labels := make([]string, len(v.labels))
+		for i, class := range v.labels {
+			labels[i] = name[class]
+		}
+		sort.Strings(labels)
+		row := []string{vertexID(id), strings.Join(labels, ";")}
+		for _, predicate := range predicates {
+			values := v.properties[predicate]
+			sort.Strings(values)
+			for i := range values {
+				values[i] = escape.Replace(values[i])
+			}
+			row = append(row, strings.Join(values, ";"))
+		}
+		if err := vw.writeRow(csvRow(row...)); err != nil {
+			vw.Close()
+			return err
+		}
+	}
+	if err := vw.Close(); err != nil {
+		return err
+	}
+
+	lines := make([]string, 0, len(edges))
+	for line := range edges {
+		lines = append(lines, line)
+	}
+	sort.Strings(lines)
+	ew := &chunkWriter{dir: dir, prefix: "edges", ext: "csv", header: csvRow("~id", "~from", "~to", "~label"), limit: splitSize}
+	for _, line := range lines {
+		q, _ := parseQuad(line)
+		sum := sha1.Sum([]byte(line))
+		row := csvRow(hex.EncodeToString(sum[:8]), vertexID(q.Subject), vertexID(q.Object), name[q.Predicate])
+		if err := ew.writeRow(row); err != nil {
+			ew.Close()
+			return err
+		}
+	}
+	return ew.Close()
+}
@@ -0,0 +1,41 @@
+// neptune_test.go
+package main
+
+import "testing"
+
+func TestNeptuneNames(t *testing.T) {
+	tests := []struct {
+		name  string
+		terms []string
+		want  map[string]string
+	}{
+		{
+			"distinct local names",
+			[]string{"<http://schema.org/name>", "<http://xmlns.com/foaf/0.1/age>"},
+			map[string]string{"<http://schema.org/name>": "name", "<http://xmlns.com/foaf/0.1/age>": "age"},
+		},
+		{
+			"shared local name",
+			[]string{"<http://schema.org/name>", "<http://xmlns.com/foaf/0.1/name>", "<http://example.org/vocab#Person>"},
+			map[string]string{
+				"<http://schema.org/name>":          "name_" + namespaceSuffix("http://schema.org/"),
+				"<http://xmlns.com/foaf/0.1/name>":  "name_" + namespaceSuffix("http://xmlns.com/foaf/0.1/"),
+				"<http://example.org/vocab#Person>": "Person",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			terms := make(map[string]bool)
+			for _, term := range tt.terms {
+				terms[term] = true
+			}
+			got := neptuneNames(terms)
+			for term, want := range tt.want {
+				if got[term] != want {
+					t.Errorf("neptuneNames()[%s] = %q, want %q", term, got[term], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+// normalize.go
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+)
+
+// A normalizer rewrites a quad into a canonical form, so that sources which
+// spell the same statement differently do not produce spurious changes.
+type normalizer func(q quadstore.Quad) quadstore.Quad
+
+// normalizers are the steps normalize.steps can name, in the order given
+// there. Builds that need their own transformations add them here from an
+// init function.
+var normalizers = map[string]normalizer{
+	"iri-case":  normalizeIRICase,
+	"datatypes": normalizeDatatypes,
+	"lang-tags": normalizeLangTags,
+}
+
+// normalizePipeline is the normalization configured for ingest:
+// normalize.steps, a comma-separated list of normalizers, then
+// normalize.command, a shell command that reads N-Quads on stdin and writes
+// the normalized N-Quads to stdout.
+type normalizePipeline struct {
+	steps   []normalizer
+	command string
+}
+
+// loadNormalizePipeline reads the pipeline from the config; nil means
+// nothing is configured.
+func loadNormalizePipeline() (*normalizePipeline, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	p := &normalizePipeline{command: cfg.Get("normalize.command")}
+	for _, name := range strings.Split(cfg.Get("normalize.steps"), ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		step, ok := normalizers[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown normalize.steps entry %q", name)
+		}
+		p.steps = append(p.steps, step)
+	}
+	if len(p.steps) == 0 && p.command == "" {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// normalizeLines runs N-Quads lines through the pipeline. Blank lines,
+// comments and statements that do not parse are passed through for the
+// later stages to report.
+func (p *normalizePipeline) normalizeLines(lines []string) ([]string, error) {
+	if p == nil {
+		return lines, nil
+	}
+	out := make([]string, 0, len(lines))
+	for _, line := range lines {
+		q, err := parseQuad(strings.TrimSpace(line))
+		if err != nil {
+			out = append(out, line)
+			continue
+		}
+		for _, step := range p.steps {
+			q = step(q)
+		}
+		out = append(out, formatQuad(q))
+	}
+	if p.command == "" {
+		return out, nil
+	}
+
+	cmd := exec.Command("sh", "-c", p.command)
+	cmd.Stdin = strings.NewReader(strings.Join(out, "\n") + "\n")
+	var stdout bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("normalize.command: %v", err)
+	}
+	return strings.Split(strings.TrimRight(stdout.String(), "\n"), "\n"), nil
+}
+
+// normalizeState runs the quads of state through the pipeline. Graphs may
+// be renamed by it, e.g. by iri-case.
+func (p *normalizePipeline) normalizeState(state map[string]quadSet) (map[string]quadSet, error) {
+	if p == nil {
+		return state, nil
+	}
+	var lines []string
+	for graph, set := range state {
+		lines = append(lines, graphQuads(graph, set)...)
+	}
+	lines, err := p.normalizeLines(lines)
+	if err != nil {
+		return nil, err
+	}
+	normalized := make(map[string]quadSet, len(state))
+	for _, line := range lines {
+		if err := addQuadLine(normalized, line, ""); err != nil {
+			return nil, err
+		}
+	}
+	return normalized, nil
+}
+
+// normalizeIRICase lowercases the scheme and host of every IRI, which are
+// case-insensitive.
+func normalizeIRICase(q quadstore.Quad) quadstore.Quad {
+	lower := func(term string) string {
+		if !strings.HasPrefix(term, "<") {
+			return term
+		}
+		iri := term[1 : len(term)-1]
+		scheme, rest, ok := strings.Cut(iri, ":")
+		if !ok {
+			return term
+		}
+		scheme = strings.ToLower(scheme)
+		if authority, ok := strings.CutPrefix(rest, "//"); ok {
+			end := strings.IndexAny(authority, "/?#")
+			if end < 0 {
+				end = len(authority)
+			}
+			host := authority[:end]
+			userinfo := ""
+			if at := strings.LastIndex(host, "@"); at >= 0 {
+				userinfo, host = host[:at+1], host[at+1:]
+			}
+			rest = "//" + userinfo + strings.ToLower(host) + authority[end:]
+		}
+		return "<" + scheme + ":" + rest + ">"
+	}
+	q.Subject, q.Predicate, q.Object, q.Graph = lower(q.Subject), lower(q.Predicate), lower(q.Object), lower(q.Graph)
+	return q
+}
+
+// normalizeDatatypes rewrites typed literals to their canonical forms:
+// integers and decimals without signs or redundant zeros ("01" becomes
+// "1"), booleans as true or false, and xsd:string literals as plain ones.
+// Lexical forms that are not valid for their datatype are left alone.
+func normalizeDatatypes(q quadstore.Quad) quadstore.Quad {
+	if !strings.HasPrefix(q.Object, `"`) {
+		return q
+	}
+	lexical, _, datatype := literalParts(q.Object)
+	local, ok := strings.CutPrefix(datatype, xsd)
+	if !ok {
+		return q
+	}
+	typed := func(value string) string { return `"` + value + `"^^<` + datatype + ">" }
+	switch {
+	case local == "string":
+		q.Object = q.Object[:strings.LastIndex(q.Object, `"`)+1]
+	case local == "boolean":
+		switch strings.TrimSpace(lexical) {
+		case "1", "true":
+			q.Object = typed("true")
+		case "0", "false":
+			q.Object = typed("false")
+		}
+	case local == "decimal":
+		if value, ok := canonicalDecimal(strings.TrimSpace(lexical)); ok {
+			q.Object = typed(value)
+		}
+	case numericTypes[local] && local != "double" && local != "float":
+		if value, ok := canonicalDecimal(strings.TrimSpace(lexical)); ok && !strings.Contains(lexical, ".") {
+			q.Object = typed(value)
+		}
+	}
+	return q
+}
+
+// canonicalDecimal returns the canonical form of a decimal number: no "+"
+// sign, no leading zeros in the integer part, no trailing zeros in the
+// fraction, and no fraction at all for whole numbers.
+func canonicalDecimal(s string) (string, bool) {
+	sign := ""
+	if rest, ok := strings.CutPrefix(s, "-"); ok {
+		sign, s = "-", rest
+	} else {
+		s = strings.TrimPrefix(s, "+")
+	}
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" {
+		return "", false
+	}
+	for _, r := range whole + frac {
+		if r < '0' || r > '9' {
+			return "", false
+		}
+	}
+	whole = strings.TrimLeft(whole, "0")
+	frac = strings.TrimRight(frac, "0")
+	if whole == "" {
+		whole = "0"
+	}
+	if whole == "0" && frac == "" {
+		sign = ""
+	}
+	if frac != "" {
+		return sign + whole + "." + frac, true
+	}
+	return sign + whole, true
+}
+
+// normalizeLangTags lowercases language tags, which are case-insensitive.
+func normalizeLangTags(q quadstore.Quad) quadstore.Quad {
+	if !strings.HasPrefix(q.Object, `"`) {
+		return q
+	}
+	end := strings.LastIndex(q.Object, `"`)
+	if tag, ok := strings.CutPrefix(q.Object[end+1:], "@"); ok {
+		q.Object = q.Object[:end+1] + "@" + strings.ToLower(tag)
+	}
+	return q
+}
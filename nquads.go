@@ -0,0 +1,114 @@
+// nquads.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+	"github.com/mannyrivera2010/go-quadgit/pkg/rdfio"
+)
+
+// defaultGraph is the tree entry used for quads that carry no graph term.
+const defaultGraph = "default"
+
+// parseQuad parses a single N-Quads (or N-Triples) statement leniently;
+// see rdfio.ParseQuad.
+func parseQuad(line string) (quadstore.Quad, error) {
+	return rdfio.ParseQuad(line, rdfio.Lenient)
+}
+
+// scanTerm reads one RDF term from the start of s and returns it together
+// with the number of bytes consumed.
+func scanTerm(s string) (string, int, error) {
+	return rdfio.ScanTerm(s)
+}
+
+// formatQuad renders a quad as a single N-Quads statement.
+func formatQuad(q quadstore.Quad) string {
+	return rdfio.FormatQuad(q)
+}
+
+// graphKey returns the tree entry name a quad is stored under.
+func graphKey(q quadstore.Quad) string {
+	if q.Graph == "" {
+		return defaultGraph
+	}
+	return q.Graph
+}
+
+// readQuadsInto parses N-Quads (or N-Triples) from r into state, storing
+// quads in triple form under their graph. Statements without a graph term
+// go to graph, or the default graph if graph is empty. name is used in
+// error messages.
+func readQuadsInto(state map[string]quadSet, r io.Reader, name, graph string) error {
+	reader := rdfio.NewReader(r)
+	for {
+		q, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		var perr *rdfio.ParseError
+		if errors.As(err, &perr) {
+			return fmt.Errorf("%s:%d: %v", name, perr.Line, perr.Err)
+		} else if err != nil {
+			return err
+		}
+		addQuad(state, q, graph)
+	}
+}
+
+// readQuadLines parses N-Quads (or N-Triples) from r and returns them as
+// N-Quads lines, with statements that have no graph term placed in graph
+// ("" for the default graph). name is used in error messages.
+func readQuadLines(r io.Reader, name, graph string) ([]string, error) {
+	var lines []string
+	reader := rdfio.NewReader(r)
+	for {
+		q, err := reader.Read()
+		if err == io.EOF {
+			return lines, nil
+		}
+		var perr *rdfio.ParseError
+		if errors.As(err, &perr) {
+			return nil, fmt.Errorf("%s:%d: %v", name, perr.Line, perr.Err)
+		} else if err != nil {
+			return nil, err
+		}
+		if q.Graph == "" {
+			q.Graph = graph
+		}
+		lines = append(lines, formatQuad(q))
+	}
+}
+
+// addQuadLine adds one N-Quads line to state as readQuadsInto does, skipping
+// blank lines and comments.
+func addQuadLine(state map[string]quadSet, line, graph string) error {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+	q, err := parseQuad(line)
+	if err != nil {
+		return err
+	}
+	addQuad(state, q, graph)
+	return nil
+}
+
+// addQuad stores q in state in triple form under its graph, or under graph
+// if it has none.
+func addQuad(state map[string]quadSet, q quadstore.Quad, graph string) {
+	if q.Graph == "" {
+		q.Graph = graph
+	}
+	key := graphKey(q)
+	q.Graph = ""
+	if state[key] == nil {
+		state[key] = make(quadSet)
+	}
+	state[key][formatQuad(q)] = true
+}
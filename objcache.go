@@ -0,0 +1,55 @@
+// objcache.go
+package main
+
+import (
+	"fmt"
+
+	"github.com/mannyrivera2010/go-quadgit/internal/config"
+	"github.com/mannyrivera2010/go-quadgit/internal/lru"
+	"github.com/mannyrivera2010/go-quadgit/internal/transport"
+)
+
+// defaultObjectCacheSize bounds the decoded commits and trees kept in memory
+// unless core.objectCacheSize says otherwise.
+const defaultObjectCacheSize = 64 << 20
+
+// History walks, diffs and merges read the same commits and trees over and
+// over; objects are immutable, so their decoded forms are cached by hash.
+// Each repository has caches of its own, filled only from objects it has
+// read (and, with core.verifyObjects, verified) itself. The cost of an entry
+// is the length of its stored encoding. Cached values are shared and must
+// not be modified.
+
+// objectCacheSize returns the budget of the object caches from
+// core.objectCacheSize, e.g. "256m"; 0 disables them.
+func objectCacheSize(cfg *config.Config) (int64, error) {
+	v, ok := cfg.Lookup("core.objectCacheSize")
+	if !ok {
+		return defaultObjectCacheSize, nil
+	}
+	size, err := transport.ParseRate(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid core.objectCacheSize %q (use e.g. 64m, or 0 to disable)", v)
+	}
+	return size, nil
+}
+
+// newObjectCaches sizes r's object caches, splitting size between commits
+// and trees.
+func (r *Repository) newObjectCaches(size int64) {
+	r.commits = lru.New[string, Commit](size / 2)
+	r.trees = lru.New[string, Tree](size - size/2)
+}
+
+// cacheStats reports the combined counters of r's object caches.
+func (r *Repository) cacheStats() lru.Stats {
+	c, t := r.commits.Stats(), r.trees.Stats()
+	return lru.Stats{
+		Hits:      c.Hits + t.Hits,
+		Misses:    c.Misses + t.Misses,
+		Evictions: c.Evictions + t.Evictions,
+		Entries:   c.Entries + t.Entries,
+		Cost:      c.Cost + t.Cost,
+		MaxCost:   c.MaxCost + t.MaxCost,
+	}
+}
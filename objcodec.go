@@ -0,0 +1,77 @@
+// objcodec.go
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Objects may be stored compressed. The hash of an object always covers its
+// canonical encoding, so compression is a local storage detail: other
+// repositories, and every caller of readRawObject, see the same bytes. A
+// compressed value starts with a codec byte, which no canonical encoding
+// (always JSON) starts with; values written before compression existed are
+// read as they are.
+const codecZstd byte = 0x01
+
+// defaultZstdLevel is the level of core.objectCodec "zstd".
+const defaultZstdLevel = 3
+
+// parseObjectCodec parses a codec name: none, zstd or zstd-<level> with a
+// level from 1 to 22.
+func parseObjectCodec(name string) (int, error) {
+	switch name {
+	case "", "none":
+		return 0, nil
+	case "zstd":
+		return defaultZstdLevel, nil
+	}
+	if level, err := strconv.Atoi(strings.TrimPrefix(name, "zstd-")); err == nil && strings.HasPrefix(name, "zstd-") && level >= 1 && level <= 22 {
+		return level, nil
+	}
+	return 0, fmt.Errorf("unknown object codec %q (use none, zstd or zstd-1 to zstd-22)", name)
+}
+
+var zstdEncoders sync.Map // level to *zstd.Encoder
+
+var zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) {
+	return zstd.NewReader(nil)
+})
+
+// compressObject returns the stored form of an object's encoding at a zstd
+// level, or data itself if compression would not make it smaller.
+func compressObject(data []byte, level int) []byte {
+	if level == 0 {
+		return data
+	}
+	enc, ok := zstdEncoders.Load(level)
+	if !ok {
+		e, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		if err != nil {
+			return data
+		}
+		enc, _ = zstdEncoders.LoadOrStore(level, e)
+	}
+	compressed := enc.(*zstd.Encoder).EncodeAll(data, []byte{codecZstd})
+	if len(compressed) >= len(data) {
+		return data
+	}
+	return compressed
+}
+
+// objectEncoding returns the encoding of an object from its stored form.
+// The result may share memory with stored.
+func objectEncoding(stored []byte) ([]byte, error) {
+	if len(stored) == 0 || stored[0] != codecZstd {
+		return stored, nil
+	}
+	dec, err := zstdDecoder()
+	if err != nil {
+		return nil, err
+	}
+	return dec.DecodeAll(stored[1:], nil)
+}
@@ -0,0 +1,70 @@
+// optimize.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/spf13/cobra"
+)
+
+// defaultDiscardRatio is the fraction of a value-log file that must be
+// garbage before GC rewrites it.
+const defaultDiscardRatio = 0.5
+
+// runValueLogGC rewrites value-log files until Badger finds none with at
+// least ratio garbage, returning how many were rewritten.
+func runValueLogGC(ratio float64) (int, error) {
+	rewritten := 0
+	for {
+		err := repo.db.RunValueLogGC(ratio)
+		if errors.Is(err, badger.ErrNoRewrite) || errors.Is(err, badger.ErrRejected) {
+			return rewritten, nil
+		}
+		if err != nil {
+			return rewritten, err
+		}
+		rewritten++
+	}
+}
+
+// backgroundGC runs value-log GC every interval until the process exits.
+func backgroundGC(interval time.Duration) {
+	for range time.Tick(interval) {
+		if n, err := runValueLogGC(defaultDiscardRatio); err != nil {
+			log.Printf("Value-log GC failed: %v", err)
+		} else if n > 0 {
+			log.Printf("Value-log GC rewrote %d file(s)", n)
+		}
+	}
+}
+
+var optimizeCmd = &cobra.Command{
+	Use:   "optimize",
+	Short: "Compact the database and reclaim space held by obsolete data",
+	Long: `Update the reachability index (see rev-list), compact the LSM tree,
+dropping superseded versions of keys such as rewritten refs, then
+garbage-collect the value log so the space they held is returned to the
+filesystem.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ratio, _ := cmd.Flags().GetFloat64("discard-ratio")
+		commits, bitmaps, err := updateReachabilityIndex()
+		if err != nil {
+			log.Fatalf("Failed to update the reachability index: %v", err)
+		}
+		if err := repo.db.Flatten(runtime.NumCPU()); err != nil {
+			log.Fatalf("Compaction failed: %v", err)
+		}
+		rewritten, err := runValueLogGC(ratio)
+		if err != nil {
+			log.Fatalf("Value-log GC failed: %v", err)
+		}
+
+		fmt.Printf("Indexed %d new commit(s) with %d bitmap(s)\n", commits, bitmaps)
+		fmt.Printf("Compacted the LSM tree; value-log GC rewrote %d file(s)\n", rewritten)
+	},
+}
@@ -0,0 +1,461 @@
+// owners.go
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+	"github.com/spf13/cobra"
+)
+
+// ownersPath maps graphs to the people who must approve changes to them
+// before they reach a protected branch, as CODEOWNERS does for files. Each
+// line is a graph, or with a trailing * every graph under a prefix,
+// followed by its owners' identities; "#" starts a comment:
+//
+//	<http://example.org/*>           data-team@example.org
+//	<http://example.org/ontology>    alice@example.org bob@example.org
+//	<http://example.org/scratch/*>
+//
+// The last rule matching a graph wins, and one without owners leaves the
+// graph unowned. Any one owner's approval suffices.
+const ownersPath = ".quadowners"
+
+// reviewPrefix keys reviews by commit and reviewer.
+const reviewPrefix = "review:"
+
+// Review states.
+const (
+	reviewApproved         = "approved"
+	reviewChangesRequested = "changes-requested"
+)
+
+type ownerRule struct {
+	pattern string // Graph or scope, as graphInScope takes it, without angle brackets
+	owners  []string
+}
+
+type ownerRules []ownerRule
+
+// loadOwnerRules reads .quadowners; a missing file means no graph has
+// owners.
+func loadOwnerRules() (ownerRules, error) {
+	f, err := os.Open(ownersPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules ownerRules
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		pattern := strings.TrimSuffix(strings.TrimPrefix(fields[0], "<"), ">")
+		rules = append(rules, ownerRule{pattern: pattern, owners: fields[1:]})
+	}
+	return rules, scanner.Err()
+}
+
+// ownersOf returns the owners of a graph, or none if it is unowned.
+func (rules ownerRules) ownersOf(graph string) []string {
+	var owners []string
+	for _, r := range rules {
+		if graphInScope(graph, r.pattern) {
+			owners = r.owners
+		}
+	}
+	return owners
+}
+
+// A review is one reviewer's verdict on a commit. Only the latest review of
+// each reviewer counts.
+type review struct {
+	Commit   string    `json:"commit"`
+	Reviewer string    `json:"reviewer"`
+	State    string    `json:"state"`
+	Comment  string    `json:"comment,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// recordReview stores a review, replacing the reviewer's earlier one of the
+// same commit.
+func recordReview(rv review) error {
+	if rv.Reviewer == "" {
+		return fmt.Errorf("%w: reviews need an identity (set user.email, or authenticate)", quadstore.ErrPermissionDenied)
+	}
+	if rv.State != reviewApproved && rv.State != reviewChangesRequested {
+		return fmt.Errorf("unknown review state %q (want %s or %s)", rv.State, reviewApproved, reviewChangesRequested)
+	}
+	data, err := json.Marshal(rv)
+	if err != nil {
+		return err
+	}
+	return repo.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(reviewPrefix+rv.Commit+":"+rv.Reviewer), data)
+	})
+}
+
+// commitReviews returns the reviews of a commit, by reviewer.
+func commitReviews(hash string) (map[string]review, error) {
+	reviews := make(map[string]review)
+	err := repo.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		prefix := []byte(reviewPrefix + hash + ":")
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			var rv review
+			if err := it.Item().Value(func(val []byte) error { return json.Unmarshal(val, &rv) }); err != nil {
+				return err
+			}
+			reviews[rv.Reviewer] = rv
+		}
+		return nil
+	})
+	return reviews, err
+}
+
+// protectedBranch reports whether the policy.protected setting (a
+// comma-separated list of branch name patterns) covers a branch.
+func protectedBranch(branch string) (bool, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return false, err
+	}
+	for _, pattern := range strings.Split(cfg.Get("policy.protected"), ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if ok, err := path.Match(pattern, branch); err != nil {
+			return false, fmt.Errorf("invalid policy.protected pattern %q: %v", pattern, err)
+		} else if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// A graphApproval is where one owned graph of a change stands.
+type graphApproval struct {
+	Graph            string   `json:"graph"`
+	Owners           []string `json:"owners"`
+	ApprovedBy       []string `json:"approvedBy,omitempty"`
+	ChangesRequested []string `json:"changesRequestedBy,omitempty"`
+}
+
+// Approved reports whether an owner approved the graph's change and none
+// asked for changes.
+func (a graphApproval) Approved() bool {
+	return len(a.ApprovedBy) > 0 && len(a.ChangesRequested) == 0
+}
+
+// reviewedCommits returns the commits whose reviews count for moving a
+// branch from one commit to another: the new commit itself and, for a
+// merge on top of the old one, the commits it merges.
+func reviewedCommits(from, to string) ([]string, error) {
+	reviewed := []string{to}
+	commit, err := readCommit(to)
+	if err != nil {
+		return nil, err
+	}
+	if len(commit.Parents) > 1 && commit.Parents[0] == from {
+		reviewed = append(reviewed, commit.Parents[1:]...)
+	}
+	return reviewed, nil
+}
+
+// ownerApprovals returns where each owned graph among graphs stands for a
+// change reviewed on the given commits.
+func ownerApprovals(graphs, reviewed []string) ([]graphApproval, error) {
+	rules, err := loadOwnerRules()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", ownersPath, err)
+	}
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	reviews := make(map[string]review)
+	for _, hash := range reviewed {
+		found, err := commitReviews(hash)
+		if err != nil {
+			return nil, err
+		}
+		for reviewer, rv := range found {
+			if prev, ok := reviews[reviewer]; !ok || rv.Time.After(prev.Time) {
+				reviews[reviewer] = rv
+			}
+		}
+	}
+	var approvals []graphApproval
+	for _, graph := range graphs {
+		owners := rules.ownersOf(graph)
+		if len(owners) == 0 {
+			continue
+		}
+		a := graphApproval{Graph: graph, Owners: owners}
+		for _, owner := range owners {
+			switch reviews[owner].State {
+			case reviewApproved:
+				a.ApprovedBy = append(a.ApprovedBy, owner)
+			case reviewChangesRequested:
+				a.ChangesRequested = append(a.ChangesRequested, owner)
+			}
+		}
+		approvals = append(approvals, a)
+	}
+	return approvals, nil
+}
+
+// checkOwnerApproval fails with quadstore.ErrPermissionDenied if ref is a
+// protected branch and moving it from one commit to another changes graphs
+// whose owners have not approved.
+func checkOwnerApproval(ref, from, to string, graphs []string) error {
+	branch, ok := strings.CutPrefix(ref, "head:")
+	if !ok || to == "" {
+		return nil
+	}
+	if protected, err := protectedBranch(branch); err != nil || !protected {
+		return err
+	}
+	reviewed, err := reviewedCommits(from, to)
+	if err != nil {
+		return err
+	}
+	return checkApprovals(branch, graphs, reviewed)
+}
+
+// checkUnreviewedChange fails like checkOwnerApproval if branch is
+// protected and a change no commit holds yet, and so no one can have
+// reviewed, would change owned graphs.
+func checkUnreviewedChange(branch string, graphs []string) error {
+	if protected, err := protectedBranch(branch); err != nil || !protected {
+		return err
+	}
+	return checkApprovals(branch, graphs, nil)
+}
+
+// checkApprovals fails with quadstore.ErrPermissionDenied unless an owner
+// of each owned graph among graphs approved one of the reviewed commits
+// and none asked for changes.
+func checkApprovals(branch string, graphs, reviewed []string) error {
+	approvals, err := ownerApprovals(graphs, reviewed)
+	if err != nil {
+		return err
+	}
+	for _, a := range approvals {
+		if len(a.ChangesRequested) > 0 {
+			return fmt.Errorf("%w: %s is protected and %s asked for changes to graph %s", quadstore.ErrPermissionDenied, branch, strings.Join(a.ChangesRequested, ", "), a.Graph)
+		}
+		if !a.Approved() {
+			return fmt.Errorf("%w: %s is protected and graph %s needs the approval of %s (see 'quad-db review')", quadstore.ErrPermissionDenied, branch, a.Graph, strings.Join(a.Owners, " or "))
+		}
+	}
+	return nil
+}
+
+// A reviewStatus is what 'review status' and GET /reviews report about
+// merging a commit into a branch.
+type reviewStatus struct {
+	Commit    string          `json:"commit"`
+	Into      string          `json:"into"`
+	Base      string          `json:"base,omitempty"`
+	Protected bool            `json:"protected"`
+	Approved  bool            `json:"approved"`
+	Graphs    []graphApproval `json:"graphs"`
+	Reviews   []review        `json:"reviews"`
+}
+
+// buildReviewStatus reports on merging commit into branch: the graphs it
+// changes since their merge base, their owners and the reviews so far.
+func buildReviewStatus(hash, branch string) (*reviewStatus, error) {
+	head, err := getReference("head:" + branch)
+	if err != nil {
+		return nil, fmt.Errorf("branch %s: %w", branch, err)
+	}
+	st := &reviewStatus{Commit: hash, Into: branch, Graphs: []graphApproval{}, Reviews: []review{}}
+	if st.Protected, err = protectedBranch(branch); err != nil {
+		return nil, err
+	}
+	if st.Base, err = findMergeBase(head, hash); err != nil {
+		return nil, err
+	}
+	graphs, err := changedGraphs(st.Base, hash)
+	if err != nil {
+		return nil, err
+	}
+	approvals, err := ownerApprovals(graphs, []string{hash})
+	if err != nil {
+		return nil, err
+	}
+	st.Approved = true
+	for _, a := range approvals {
+		st.Graphs = append(st.Graphs, a)
+		st.Approved = st.Approved && a.Approved()
+	}
+	reviews, err := commitReviews(hash)
+	if err != nil {
+		return nil, err
+	}
+	for _, rv := range reviews {
+		st.Reviews = append(st.Reviews, rv)
+	}
+	sort.Slice(st.Reviews, func(i, j int) bool { return st.Reviews[i].Time.Before(st.Reviews[j].Time) })
+	return st, nil
+}
+
+// handleGetReviews reports on merging a commit into a branch (the current
+// branch by default): GET /reviews?rev=<rev>[&into=<branch>].
+func handleGetReviews(w http.ResponseWriter, r *http.Request) {
+	hash, err := resolveCommitish(r.URL.Query().Get("rev"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	into := r.URL.Query().Get("into")
+	if into == "" {
+		into = currentBranch()
+	}
+	st, err := buildReviewStatus(hash, into)
+	if errors.Is(err, quadstore.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, st)
+}
+
+// handlePostReview records the authenticated user's review of a commit:
+// POST /reviews?rev=<rev>&state=approved|changes-requested, with an
+// optional comment as the body.
+func handlePostReview(w http.ResponseWriter, r *http.Request) {
+	hash, err := resolveCommitish(r.URL.Query().Get("rev"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	comment, err := io.ReadAll(io.LimitReader(r.Body, 64*1024))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rv := review{Commit: hash, Reviewer: requestIdentity(r), State: r.URL.Query().Get("state"), Comment: strings.TrimSpace(string(comment)), Time: clock.Now()}
+	if err := recordReview(rv); errors.Is(err, quadstore.ErrPermissionDenied) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeAdminJSON(w, http.StatusCreated, rv)
+}
+
+var reviewCmd = &cobra.Command{
+	Use:   "review",
+	Short: "Approve changes to owned graphs before they reach protected branches",
+	Long: `Record and inspect reviews of commits. Graphs listed in .quadowners have
+owners, and changes to them may only be merged, fast-forwarded or pushed
+into a branch that the policy.protected setting (a comma-separated list of
+branch name patterns) covers once one of each changed graph's owners has
+approved the commit being merged, and none has asked for changes. Since a
+new commit cannot have been reviewed, they cannot be committed straight to
+such a branch, by commit, import or over HTTP; commit them to another
+branch and merge it once approved.
+
+Reviewers are identified by user.email, or over HTTP by the user they
+authenticate as: GET /reviews?rev=<rev>[&into=<branch>] reports the status
+of a commit, and POST /reviews?rev=<rev>&state=approved|changes-requested
+records a review, with the body as its comment.`,
+}
+
+// reviewCommand returns a subcommand recording a review in state.
+func reviewCommand(use, short, state string) *cobra.Command {
+	return &cobra.Command{
+		Use:   use + " <rev>",
+		Short: short,
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			hash, err := resolveCommitish(args[0])
+			if err != nil {
+				log.Fatalf("Could not resolve %s: %v", args[0], err)
+			}
+			comment, _ := cmd.Flags().GetString("message")
+			rv := review{Commit: hash, Reviewer: actingIdentity(), State: state, Comment: comment, Time: clock.Now()}
+			if err := recordReview(rv); err != nil {
+				log.Fatalf("Failed to record the review: %v", err)
+			}
+			fmt.Printf("Recorded %s review of %s by %s\n", state, shortHash(hash), rv.Reviewer)
+		},
+	}
+}
+
+var reviewApproveCmd = reviewCommand("approve", "Approve a commit's changes to the graphs you own", reviewApproved)
+
+var reviewRequestChangesCmd = reviewCommand("request-changes", "Block a commit from protected branches until it is fixed", reviewChangesRequested)
+
+var reviewStatusCmd = &cobra.Command{
+	Use:   "status <rev> [--into <branch>]",
+	Short: "Show which owners must still approve merging a commit",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		hash, err := resolveCommitish(args[0])
+		if err != nil {
+			log.Fatalf("Could not resolve %s: %v", args[0], err)
+		}
+		into, _ := cmd.Flags().GetString("into")
+		if into == "" {
+			into = currentBranch()
+		}
+		st, err := buildReviewStatus(hash, into)
+		if err != nil {
+			log.Fatal(err)
+		}
+		protection := "not protected"
+		if st.Protected {
+			protection = "protected"
+		}
+		fmt.Printf("Merging %s into %s (%s)\n", shortHash(hash), into, protection)
+		if len(st.Graphs) == 0 {
+			fmt.Println("No owned graph changes.")
+		}
+		for _, a := range st.Graphs {
+			switch {
+			case len(a.ChangesRequested) > 0:
+				fmt.Printf("  changes requested  %s by %s\n", a.Graph, strings.Join(a.ChangesRequested, ", "))
+			case a.Approved():
+				fmt.Printf("  approved           %s by %s\n", a.Graph, strings.Join(a.ApprovedBy, ", "))
+			default:
+				fmt.Printf("  awaiting review    %s from %s\n", a.Graph, strings.Join(a.Owners, " or "))
+			}
+		}
+		for _, rv := range st.Reviews {
+			fmt.Printf("%s %s %s", rv.Time.Format("2006-01-02 15:04"), rv.Reviewer, rv.State)
+			if rv.Comment != "" {
+				fmt.Printf(": %s", rv.Comment)
+			}
+			fmt.Println()
+		}
+		if st.Protected && !st.Approved {
+			os.Exit(1)
+		}
+	},
+}
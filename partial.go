@@ -0,0 +1,110 @@
+// partial.go
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/mannyrivera2010/go-quadgit/internal/rpc"
+	"github.com/mannyrivera2010/go-quadgit/internal/transport"
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+)
+
+// A partial clone (clone --filter=blob:none) holds every commit and tree but
+// no graph blobs. core.promisorRemote names the remote that promised to
+// supply them, and blobs are fetched from it the first time they are read.
+// remote.<name>.filter keeps later fetches from that remote partial too.
+
+// An objectSource fetches objects by hash from a remote, over a connection
+// opened on first use and closed by closeDB.
+type objectSource struct {
+	conn transport.Transport
+}
+
+// promisor is the connection to the promisor remote.
+var promisor objectSource
+
+// promisorRemote returns the name of the remote missing blobs are fetched
+// from, or "" if the repository is complete.
+func promisorRemote() string {
+	cfg, err := loadConfig()
+	if err != nil {
+		return ""
+	}
+	return cfg.Get("core.promisorRemote")
+}
+
+// fetch fetches objects from the remote name and stores them.
+func (s *objectSource) fetch(name string, hashes []string) error {
+	if s.conn == nil {
+		url, err := remoteURL(name)
+		if err != nil {
+			return err
+		}
+		opts, err := transferOptions(nil)
+		if err != nil {
+			return err
+		}
+		if s.conn, err = transport.Open(url, opts); err != nil {
+			return err
+		}
+	}
+	pack, err := s.conn.Fetch(rpc.FetchRequest{Objects: hashes})
+	if err != nil {
+		return err
+	}
+	if err := pack.Verify(); err != nil {
+		return err
+	}
+	for _, obj := range pack.Objects {
+		if err := writeRawObject(obj.Hash, obj.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close closes the connection, if open.
+func (s *objectSource) close() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// readPromised calls read, which reads the object hash; in a partial clone,
+// if the object is missing, it is fetched and read is called again.
+func readPromised(hash string, read func() error) error {
+	err := read()
+	if !errors.Is(err, quadstore.ErrNotFound) || promisorRemote() == "" {
+		return err
+	}
+	if ferr := promisor.fetch(promisorRemote(), []string{hash}); ferr != nil {
+		return fmt.Errorf("%w (fetching it from %s failed: %v)", err, promisorRemote(), ferr)
+	}
+	return read()
+}
+
+// prefetchBlobs fetches, in a partial clone, those of hashes that are
+// missing in one request rather than one at a time.
+func prefetchBlobs(hashes []string) error {
+	if promisorRemote() == "" {
+		return nil
+	}
+	var missing []string
+	err := repo.db.View(func(txn *badger.Txn) error {
+		for _, hash := range hashes {
+			if _, err := txn.Get([]byte("obj:" + hash)); err == badger.ErrKeyNotFound {
+				missing = append(missing, hash)
+			} else if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil || len(missing) == 0 {
+		return err
+	}
+	return promisor.fetch(promisorRemote(), missing)
+}
@@ -0,0 +1,160 @@
+// partial_test.go
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/mannyrivera2010/go-quadgit/internal/rpc"
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+)
+
+// fakeRemote is a transport serving the objects it holds by hash, and
+// recording the hashes asked for.
+type fakeRemote struct {
+	objects map[string][]byte
+	asked   [][]string
+}
+
+func (f *fakeRemote) List() (*rpc.Advertisement, error) { return &rpc.Advertisement{}, nil }
+
+func (f *fakeRemote) Fetch(req rpc.FetchRequest) (*rpc.Packfile, error) {
+	f.asked = append(f.asked, req.Objects)
+	var pack rpc.Packfile
+	for _, hash := range req.Objects {
+		pack.Objects = append(pack.Objects, rpc.Object{Hash: hash, Data: f.objects[hash]})
+	}
+	return &pack, nil
+}
+
+func (f *fakeRemote) Push(req rpc.PushRequest) error { return errors.New("read-only") }
+func (f *fakeRemote) Close() error                   { return nil }
+
+// partialCommit writes a commit of one graph and removes its blob, as a
+// partial clone would have it, returning the blob's hash and data.
+func partialCommit(t *testing.T) (commit, blob string, data []byte) {
+	t.Helper()
+	graph := "<http://example.org/g>"
+	commit = writeTestCommit(t, map[string][]string{
+		graph: {`<http://example.org/s> <http://example.org/p> "o" <http://example.org/g> .`},
+	})
+	c, err := readCommit(commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	graphs, err := readGraphs(c.Tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob = graphs[graph]
+	if data, err = readRawObject(blob); err != nil {
+		t.Fatal(err)
+	}
+	err = repo.db.Update(func(txn *badger.Txn) error { return txn.Delete([]byte("obj:" + blob)) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return commit, blob, data
+}
+
+func TestCollectObjectsOmitBlobs(t *testing.T) {
+	newTestRepo(t)
+	commit := writeTestCommit(t, map[string][]string{
+		"<http://example.org/g>": {`<http://example.org/s> <http://example.org/p> "o" <http://example.org/g> .`},
+	})
+	c, err := readCommit(commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	graphs, err := readGraphs(c.Tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob := graphs["<http://example.org/g>"]
+	for _, omitBlobs := range []bool{false, true} {
+		objects, err := collectObjects(nil, omitBlobs, commit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sent := make(map[string]bool)
+		for _, obj := range objects {
+			sent[obj.Hash] = true
+		}
+		if !sent[commit] || !sent[c.Tree] {
+			t.Errorf("omitBlobs %v: the commit or its tree was not collected", omitBlobs)
+		}
+		if sent[blob] == omitBlobs {
+			t.Errorf("omitBlobs %v: blob collected is %v", omitBlobs, sent[blob])
+		}
+	}
+}
+
+func TestReadPromisedBlob(t *testing.T) {
+	newTestRepo(t)
+	_, blob, data := partialCommit(t)
+	remote := &fakeRemote{objects: map[string][]byte{blob: data}}
+	promisor.conn = remote
+	t.Cleanup(promisor.close)
+
+	if _, err := readBlob(blob); !errors.Is(err, quadstore.ErrNotFound) {
+		t.Fatalf("readBlob without a promisor remote = %v, want ErrNotFound", err)
+	}
+	if len(remote.asked) != 0 {
+		t.Fatalf("a complete repository fetched %v", remote.asked)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Set("core.promisorRemote", "origin")
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+	lines, err := readBlob(blob)
+	if err != nil {
+		t.Fatalf("readBlob: %v", err)
+	}
+	if len(lines) != 1 {
+		t.Errorf("fetched blob has %d lines, want 1", len(lines))
+	}
+	if _, err := readBlob(blob); err != nil {
+		t.Fatalf("second readBlob: %v", err)
+	}
+	if want := [][]string{{blob}}; !reflect.DeepEqual(remote.asked, want) {
+		t.Errorf("asked the remote for %v, want %v: the blob should be kept once fetched", remote.asked, want)
+	}
+}
+
+func TestPrefetchBlobs(t *testing.T) {
+	newTestRepo(t)
+	commit, blob, data := partialCommit(t)
+	c, err := readCommit(commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := &fakeRemote{objects: map[string][]byte{blob: data}}
+	promisor.conn = remote
+	t.Cleanup(promisor.close)
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Set("core.promisorRemote", "origin")
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the missing blob is asked for, not the tree the repository has.
+	if err := prefetchBlobs([]string{c.Tree, blob}); err != nil {
+		t.Fatal(err)
+	}
+	if err := prefetchBlobs([]string{blob}); err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]string{{blob}}; !reflect.DeepEqual(remote.asked, want) {
+		t.Errorf("asked the remote for %v, want %v", remote.asked, want)
+	}
+}
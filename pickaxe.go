@@ -0,0 +1,108 @@
+// pickaxe.go
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+)
+
+// A quadPattern matches quads term by term; an empty term matches anything.
+type quadPattern struct {
+	Subject, Predicate, Object, Graph string
+}
+
+// parsePattern parses a pattern such as `<s> ?p "o"` or `<s> * * <g>`.
+// Variables (?name) and * are wildcards; IRIs may omit their angle
+// brackets. A trailing '.' is ignored.
+func parsePattern(s string) (quadPattern, error) {
+	var terms []string
+	rest := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), "."))
+	for rest != "" {
+		var term string
+		var n int
+		switch {
+		case rest[0] == '?' || rest[0] == '*':
+			n = strings.IndexFunc(rest, unicode.IsSpace)
+			if n < 0 {
+				n = len(rest)
+			}
+		case rest[0] == '<' || rest[0] == '"' || strings.HasPrefix(rest, "_:"):
+			var err error
+			if term, n, err = scanTerm(rest + " "); err != nil {
+				return quadPattern{}, err
+			}
+		default:
+			n = strings.IndexFunc(rest, unicode.IsSpace)
+			if n < 0 {
+				n = len(rest)
+			}
+			term = normalizeTerm(rest[:n])
+		}
+		terms = append(terms, term)
+		rest = strings.TrimSpace(rest[n:])
+	}
+	if len(terms) < 3 || len(terms) > 4 {
+		return quadPattern{}, fmt.Errorf("pattern %q must have 3 or 4 terms", s)
+	}
+	p := quadPattern{Subject: terms[0], Predicate: terms[1], Object: terms[2]}
+	if len(terms) == 4 {
+		p.Graph = terms[3]
+	}
+	return p, nil
+}
+
+// matches reports whether a quad stored in graph matches the pattern.
+func (p quadPattern) matches(q quadstore.Quad, graph string) bool {
+	return (p.Subject == "" || p.Subject == q.Subject) &&
+		(p.Predicate == "" || p.Predicate == q.Predicate) &&
+		(p.Object == "" || p.Object == q.Object) &&
+		(p.Graph == "" || p.Graph == graph)
+}
+
+// pickaxeCount reports whether diffs change the number of quads matching
+// the pattern, i.e. whether matching quads were added and removed in
+// different numbers.
+func pickaxeCount(diffs []graphDiff, p quadPattern) bool {
+	count := func(graph string, lines []string) int {
+		n := 0
+		for _, line := range lines {
+			if q, err := parseQuad(line); err == nil && p.matches(q, graph) {
+				n++
+			}
+		}
+		return n
+	}
+	delta := 0
+	for _, d := range diffs {
+		delta += count(d.Graph, d.Added) - count(d.Graph, d.Deleted)
+	}
+	return delta != 0
+}
+
+// pickaxeRegexp reports whether any quad added or removed by diffs, written
+// in N-Quads form, matches re.
+func pickaxeRegexp(diffs []graphDiff, re *regexp.Regexp) bool {
+	for _, d := range diffs {
+		for _, lines := range [][]string{d.Added, d.Deleted} {
+			for _, line := range graphQuads(d.Graph, toSet(lines)) {
+				if re.MatchString(line) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// toSet returns the lines as a quadSet.
+func toSet(lines []string) quadSet {
+	set := make(quadSet, len(lines))
+	for _, line := range lines {
+		set[line] = true
+	}
+	return set
+}
@@ -0,0 +1,379 @@
+// pipeline.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+// A pipelineSpec describes a recurring dataset refresh: where the data
+// comes from, how it is transformed, which graphs it replaces, and how the
+// results are committed.
+type pipelineSpec struct {
+	Name string `yaml:"name"`
+	// Branch receives the commits; the current branch if empty.
+	Branch  string `yaml:"branch"`
+	Message string `yaml:"message"`
+	// Commit is the cadence: "run" (the default) for one commit per run,
+	// or "source" for one per source.
+	Commit string `yaml:"commit"`
+	// Every repeats the run at this interval, e.g. "6h"; empty runs once.
+	Every   string           `yaml:"every"`
+	Sources []pipelineSource `yaml:"sources"`
+}
+
+// A pipelineSource is one input of a pipeline. Exactly one of File, URL and
+// Endpoint is set.
+type pipelineSource struct {
+	Name string `yaml:"name"`
+	// File is an N-Quads or N-Triples file, relative to the pipeline file.
+	File string `yaml:"file"`
+	// URL is fetched with GET, as N-Quads or N-Triples.
+	URL string `yaml:"url"`
+	// Endpoint is a SPARQL endpoint queried with the CONSTRUCT query in
+	// the file Query, in pages of PageSize solutions as harvest does.
+	Endpoint string `yaml:"endpoint"`
+	Query    string `yaml:"query"`
+	PageSize *int   `yaml:"page_size"`
+	// Graph holds the source's triples; the default graph if empty.
+	Graph string `yaml:"graph"`
+	// Steps and Command transform the source's quads before the
+	// repository's own normalization, as normalize.steps and
+	// normalize.command do.
+	Steps   []string `yaml:"steps"`
+	Command string   `yaml:"command"`
+}
+
+// loadPipeline reads and checks a pipeline file. Relative paths in it are
+// made relative to the file's directory.
+func loadPipeline(path string) (*pipelineSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var spec pipelineSpec
+	if err := yaml.UnmarshalStrict(data, &spec); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if spec.Name == "" {
+		spec.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	switch spec.Commit {
+	case "":
+		spec.Commit = "run"
+	case "run", "source":
+	default:
+		return nil, fmt.Errorf("%s: commit must be run or source, not %q", path, spec.Commit)
+	}
+	if spec.Every != "" {
+		if d, err := time.ParseDuration(spec.Every); err != nil || d <= 0 {
+			return nil, fmt.Errorf("%s: invalid every %q", path, spec.Every)
+		}
+	}
+	if len(spec.Sources) == 0 {
+		return nil, fmt.Errorf("%s: no sources", path)
+	}
+	dir := filepath.Dir(path)
+	for i := range spec.Sources {
+		src := &spec.Sources[i]
+		kinds := 0
+		for _, v := range []string{src.File, src.URL, src.Endpoint} {
+			if v != "" {
+				kinds++
+			}
+		}
+		if src.Name == "" {
+			src.Name = fmt.Sprintf("source %d", i+1)
+		}
+		if kinds != 1 {
+			return nil, fmt.Errorf("%s: %s must have exactly one of file, url and endpoint", path, src.Name)
+		}
+		if src.Endpoint != "" && src.Query == "" {
+			return nil, fmt.Errorf("%s: %s has an endpoint but no query", path, src.Name)
+		}
+		for _, name := range src.Steps {
+			if _, ok := normalizers[name]; !ok {
+				return nil, fmt.Errorf("%s: %s: unknown step %q", path, src.Name, name)
+			}
+		}
+		for _, p := range []*string{&src.File, &src.Query} {
+			if *p != "" && !filepath.IsAbs(*p) {
+				*p = filepath.Join(dir, *p)
+			}
+		}
+		if src.Graph == defaultGraph {
+			src.Graph = ""
+		} else if src.Graph != "" {
+			src.Graph = normalizeGraphName(src.Graph)
+		}
+	}
+	return &spec, nil
+}
+
+// read returns the source's quads as N-Quads lines, transformed by its own
+// steps and command.
+func (src *pipelineSource) read(client *http.Client) ([]string, error) {
+	var lines []string
+	var err error
+	switch {
+	case src.File != "":
+		f, ferr := os.Open(src.File)
+		if ferr != nil {
+			return nil, ferr
+		}
+		lines, err = readQuadLines(f, src.File, src.Graph)
+		f.Close()
+	case src.URL != "":
+		req, rerr := http.NewRequest(http.MethodGet, src.URL, nil)
+		if rerr != nil {
+			return nil, rerr
+		}
+		lines, err = readQuadResponse(client, req, src.Graph)
+	default:
+		query, qerr := os.ReadFile(src.Query)
+		if qerr != nil {
+			return nil, qerr
+		}
+		pageSize := 10000
+		if src.PageSize != nil {
+			pageSize = *src.PageSize
+		}
+		if err := checkHarvestQuery(string(query), pageSize); err != nil {
+			return nil, err
+		}
+		lines, err = harvestQuery(client, src.Endpoint, strings.TrimSpace(string(query)), src.Graph, pageSize, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	transform := &normalizePipeline{command: src.Command}
+	for _, name := range src.Steps {
+		transform.steps = append(transform.steps, normalizers[name])
+	}
+	return transform.normalizeLines(lines)
+}
+
+// commitPipelineState commits graphs, replacing their versions on the tip
+// of branch, unless that changes nothing. It returns the new commit's hash,
+// or "" if there was nothing to commit.
+func commitPipelineState(spec *pipelineSpec, branch string, graphs map[string]quadSet, sources []string) (string, error) {
+	message := spec.Message
+	if message == "" {
+		message = fmt.Sprintf("Refresh %s from %s", spec.Name, strings.Join(sources, ", "))
+	}
+	return commitReplacingGraphs(branch, "", graphs, message, map[string]string{"pipeline": spec.Name}, actingIdentity())
+}
+
+// commitReplacingGraphs commits graphs on top of branch, replacing their
+// versions there, without going through the staging area. identity is
+// authorized for the commit. If expected is not empty, the branch must
+// still point at it, and the commit fails with quadstore.ErrStaleParent
+// otherwise. A commit that would change nothing is skipped and "" returned.
+func commitReplacingGraphs(branch, expected string, graphs map[string]quadSet, message string, metadata map[string]string, identity string) (string, error) {
+	return commitGraphsWith(branch, expected, graphs, message, metadata, func(ref, parent, hash string) error {
+		return authorizeWrite(quadstore.ActionCommit, identity, ref, parent, hash)
+	})
+}
+
+// commitGraphsWith is commitReplacingGraphs with the authorization of the
+// new commit left to authorize, e.g. checkApproval alone if the changes
+// were authorized as they came in.
+func commitGraphsWith(branch, expected string, graphs map[string]quadSet, message string, metadata map[string]string, authorize func(ref, parent, hash string) error) (string, error) {
+	ref := "head:" + branch
+	parent, err := getReference(ref)
+	if err != nil {
+		return "", fmt.Errorf("branch %s: %w", branch, err)
+	}
+	if expected != "" && parent != expected {
+		return "", fmt.Errorf("branch %s: %w", branch, quadstore.ErrStaleParent)
+	}
+	before, err := loadState(parent)
+	if err != nil {
+		return "", err
+	}
+	after := make(map[string]quadSet, len(before)+len(graphs))
+	for g, set := range before {
+		after[g] = set
+	}
+	for g, set := range graphs {
+		after[g] = set
+	}
+	if err := checkSecrets(before, after); err != nil {
+		return "", err
+	}
+	treeHash, err := writeState(after)
+	if err != nil {
+		return "", err
+	}
+	if commit, err := readCommit(parent); err == nil && commit.Tree == treeHash {
+		return "", nil
+	}
+
+	commit := Commit{
+		Tree:      treeHash,
+		Parents:   []string{parent},
+		Author:    commitAuthor(),
+		Message:   message,
+		Timestamp: clock.Now(),
+		Metadata:  metadata,
+	}
+	if commit.Stats, err = computeStats(before, after); err != nil {
+		return "", err
+	}
+	if err := enforceSignaturePolicy(branch, &commit); err != nil {
+		return "", err
+	}
+	hash, err := writeObject(commit)
+	if err != nil {
+		return "", err
+	}
+	if authorize != nil {
+		if err := authorize(ref, parent, hash); err != nil {
+			return "", err
+		}
+	}
+	if err := swapReference(ref, parent, hash); err != nil {
+		return "", err
+	}
+	syncAfterCommit(branch)
+	return hash, nil
+}
+
+// runPipeline reads every source and commits the results at the pipeline's
+// cadence.
+func runPipeline(spec *pipelineSpec, branch string, client *http.Client) error {
+	pipeline, err := loadNormalizePipeline()
+	if err != nil {
+		return fmt.Errorf("failed to load normalization: %v", err)
+	}
+	rules, err := loadIgnoreRules()
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", ignorePath, err)
+	}
+
+	graphs := make(map[string]quadSet)
+	var names []string
+	commit := func() error {
+		hash, err := commitPipelineState(spec, branch, graphs, names)
+		if err != nil {
+			return fmt.Errorf("commit failed: %v", err)
+		}
+		if hash == "" {
+			fmt.Printf("%s: no changes from %s\n", spec.Name, strings.Join(names, ", "))
+		} else {
+			fmt.Printf("[%s %s] %s: %s\n", branch, shortHash(hash), spec.Name, strings.Join(names, ", "))
+		}
+		graphs, names = make(map[string]quadSet), nil
+		return nil
+	}
+	for i := range spec.Sources {
+		src := &spec.Sources[i]
+		lines, err := src.read(client)
+		if err != nil {
+			return fmt.Errorf("%s: %v", src.Name, err)
+		}
+		if lines, err = pipeline.normalizeLines(lines); err != nil {
+			return fmt.Errorf("%s: %v", src.Name, err)
+		}
+		lines, ignored := rules.filterLines(lines)
+		fmt.Printf("%s: %d statement(s)", src.Name, len(lines))
+		if ignored > 0 {
+			fmt.Printf(", %d ignored", ignored)
+		}
+		fmt.Println()
+		for _, line := range lines {
+			if err := addQuadLine(graphs, line, ""); err != nil {
+				return fmt.Errorf("%s: %v", src.Name, err)
+			}
+		}
+		names = append(names, src.Name)
+		if spec.Commit == "source" {
+			if err := commit(); err != nil {
+				return err
+			}
+		}
+	}
+	if spec.Commit == "run" {
+		return commit()
+	}
+	return nil
+}
+
+var pipelineCmd = &cobra.Command{
+	Use:   "pipeline",
+	Short: "Run recurring dataset refreshes described in a file",
+}
+
+var pipelineRunCmd = &cobra.Command{
+	Use:   "run <pipeline.yaml>",
+	Short: "Read a pipeline's sources and commit the refreshed graphs",
+	Long: `Run the pipeline described by a YAML file:
+
+  name: registry
+  branch: main            # default: the current branch
+  commit: run             # one commit per run, or "source" for one per source
+  every: 6h               # repeat at this interval; omit to run once
+  sources:
+    - name: people
+      file: data/people.nt              # relative to the pipeline file
+      graph: http://example.org/people
+    - name: orgs
+      url: https://example.org/orgs.nq  # fetched as N-Quads or N-Triples
+      steps: [iri-case, datatypes]      # as in normalize.steps
+    - name: places
+      endpoint: https://example.org/sparql
+      query: places.rq                  # a CONSTRUCT query, paged as harvest does
+      page_size: 5000
+      graph: http://example.org/places
+      command: "grep -v example.com"    # a shell filter from N-Quads to N-Quads
+
+Each source's graphs replace their versions on the branch, after the
+source's own steps and command, the repository's normalization and
+.quadignore. A commit that would change nothing is skipped, so a pipeline
+can run as often as the data might change. Commits carry the metadata
+pipeline=<name>, so 'quad-db log --meta pipeline=<name>' lists them. The
+staging area is not touched.
+
+With every set, run keeps repeating, reporting failed runs and carrying on;
+--once runs a single time regardless.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		spec, err := loadPipeline(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		branch := spec.Branch
+		if branch == "" {
+			branch = currentBranch()
+		}
+		if branch == "" {
+			log.Fatal("HEAD is detached; set branch in the pipeline.")
+		}
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		client := &http.Client{Timeout: timeout}
+
+		once, _ := cmd.Flags().GetBool("once")
+		if spec.Every == "" || once {
+			if err := runPipeline(spec, branch, client); err != nil {
+				log.Fatalf("Pipeline %s failed: %v", spec.Name, err)
+			}
+			return
+		}
+		every, _ := time.ParseDuration(spec.Every)
+		for {
+			if err := runPipeline(spec, branch, client); err != nil {
+				fmt.Fprintf(os.Stderr, "Pipeline %s failed: %v\n", spec.Name, err)
+			}
+			time.Sleep(every)
+		}
+	},
+}
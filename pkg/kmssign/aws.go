@@ -0,0 +1,179 @@
+package kmssign
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AWSCredentials authenticate requests to AWS.
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// AWSCredentialsFromEnv reads the standard AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN variables.
+func AWSCredentialsFromEnv() AWSCredentials {
+	return AWSCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+}
+
+// AWS signs with an asymmetric AWS KMS key. Data is hashed locally with
+// SHA-256 and only the digest is sent, so the algorithm must be one of the
+// *_SHA_256 ones.
+type AWS struct {
+	// KeyID is the key's ID, ARN or alias.
+	KeyID  string
+	Region string
+	// Algorithm is the signing algorithm; ECDSA_SHA_256 if empty.
+	Algorithm   string
+	Credentials AWSCredentials
+	// Endpoint overrides https://kms.<region>.amazonaws.com.
+	Endpoint string
+	Client   *http.Client
+}
+
+func (a *AWS) algorithm() string {
+	if a.Algorithm != "" {
+		return a.Algorithm
+	}
+	return "ECDSA_SHA_256"
+}
+
+func (a *AWS) Sign(data []byte) (string, error) {
+	if !strings.HasSuffix(a.algorithm(), "_SHA_256") {
+		return "", fmt.Errorf("unsupported AWS KMS algorithm %s (use a *_SHA_256 one)", a.algorithm())
+	}
+	digest := sha256.Sum256(data)
+	var out struct {
+		Signature []byte
+	}
+	err := a.call("Sign", map[string]interface{}{
+		"KeyId":            a.KeyID,
+		"Message":          digest[:],
+		"MessageType":      "DIGEST",
+		"SigningAlgorithm": a.algorithm(),
+	}, &out)
+	if err != nil {
+		return "", fmt.Errorf("AWS KMS: %w", err)
+	}
+	return Signature{Provider: ProviderAWS, Key: a.KeyID, Algorithm: a.algorithm(), Value: out.Signature}.Armor(), nil
+}
+
+func (a *AWS) Verify(data []byte, signature string) error {
+	s, err := parseFor(signature, ProviderAWS, a.KeyID)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(data)
+	var out struct {
+		SignatureValid bool
+	}
+	err = a.call("Verify", map[string]interface{}{
+		"KeyId":            a.KeyID,
+		"Message":          digest[:],
+		"MessageType":      "DIGEST",
+		"Signature":        s.Value,
+		"SigningAlgorithm": s.Algorithm,
+	}, &out)
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && strings.Contains(apiErr.Body, "KMSInvalidSignatureException") {
+		return ErrBadSignature
+	} else if err != nil {
+		return fmt.Errorf("AWS KMS: %w", err)
+	}
+	if !out.SignatureValid {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// call invokes a KMS API action. Byte slices in body are sent as base64,
+// as the API expects.
+func (a *AWS) call(action string, body, out interface{}) error {
+	if a.Region == "" {
+		return errors.New("no region")
+	}
+	if a.Credentials.AccessKeyID == "" || a.Credentials.SecretAccessKey == "" {
+		return errors.New("no credentials")
+	}
+	endpoint := a.Endpoint
+	if endpoint == "" {
+		endpoint = "https://kms." + a.Region + ".amazonaws.com"
+	}
+	req, payload, err := jsonRequest(strings.TrimSuffix(endpoint, "/")+"/", body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+	signV4(req, payload, a.Credentials, a.Region, "kms", time.Now().UTC())
+	return do(a.Client, req, out)
+}
+
+// signV4 adds an AWS Signature Version 4 to a request without a query
+// string.
+func signV4(req *http.Request, payload []byte, creds AWSCredentials, region, service string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method, path, req.URL.RawQuery, canonicalHeaders.String(), signedHeaders, hexSHA256(payload),
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hexSHA256([]byte(canonicalRequest))
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
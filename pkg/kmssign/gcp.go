@@ -0,0 +1,177 @@
+package kmssign
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// GCP signs with an asymmetric Google Cloud KMS key version. Cloud KMS
+// has no verify call, so signatures are verified locally against the
+// version's public key, fetched once.
+type GCP struct {
+	// KeyVersion is the key version's resource name, projects/<p>/
+	// locations/<l>/keyRings/<r>/cryptoKeys/<k>/cryptoKeyVersions/<v>.
+	KeyVersion string
+	// Token returns an OAuth access token; GCloudToken if nil.
+	Token func() (string, error)
+	// Endpoint overrides https://cloudkms.googleapis.com.
+	Endpoint string
+	Client   *http.Client
+
+	once      sync.Once
+	publicKey crypto.PublicKey
+	algorithm string
+	keyErr    error
+}
+
+// GCloudToken returns the GOOGLE_OAUTH_ACCESS_TOKEN variable, or else the
+// token of the account gcloud is logged in as.
+func GCloudToken() (string, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+	out, err := exec.Command("gcloud", "auth", "print-access-token").Output()
+	if err != nil {
+		return "", fmt.Errorf("no GOOGLE_OAUTH_ACCESS_TOKEN, and gcloud failed: %v", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (g *GCP) url(suffix string) string {
+	endpoint := g.Endpoint
+	if endpoint == "" {
+		endpoint = "https://cloudkms.googleapis.com"
+	}
+	return strings.TrimSuffix(endpoint, "/") + "/v1/" + g.KeyVersion + suffix
+}
+
+func (g *GCP) authorize(req *http.Request) error {
+	tokenFunc := g.Token
+	if tokenFunc == nil {
+		tokenFunc = GCloudToken
+	}
+	token, err := tokenFunc()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// loadPublicKey fetches the key version's public key and algorithm.
+func (g *GCP) loadPublicKey() error {
+	g.once.Do(func() {
+		req, err := http.NewRequest(http.MethodGet, g.url("/publicKey"), nil)
+		if err != nil {
+			g.keyErr = err
+			return
+		}
+		if g.keyErr = g.authorize(req); g.keyErr != nil {
+			return
+		}
+		var out struct {
+			PEM       string `json:"pem"`
+			Algorithm string `json:"algorithm"`
+		}
+		if g.keyErr = do(g.Client, req, &out); g.keyErr != nil {
+			return
+		}
+		block, _ := pem.Decode([]byte(out.PEM))
+		if block == nil {
+			g.keyErr = errors.New("public key is not PEM")
+			return
+		}
+		g.publicKey, g.keyErr = x509.ParsePKIXPublicKey(block.Bytes)
+		g.algorithm = out.Algorithm
+	})
+	return g.keyErr
+}
+
+// digest hashes data with the hash an algorithm such as
+// EC_SIGN_P256_SHA256 names.
+func digest(algorithm string, data []byte) (crypto.Hash, map[string][]byte, error) {
+	switch {
+	case strings.HasSuffix(algorithm, "_SHA256"):
+		sum := sha256.Sum256(data)
+		return crypto.SHA256, map[string][]byte{"sha256": sum[:]}, nil
+	case strings.HasSuffix(algorithm, "_SHA384"):
+		sum := sha512.Sum384(data)
+		return crypto.SHA384, map[string][]byte{"sha384": sum[:]}, nil
+	case strings.HasSuffix(algorithm, "_SHA512"):
+		sum := sha512.Sum512(data)
+		return crypto.SHA512, map[string][]byte{"sha512": sum[:]}, nil
+	}
+	return 0, nil, fmt.Errorf("unsupported Cloud KMS algorithm %s", algorithm)
+}
+
+func (g *GCP) Sign(data []byte) (string, error) {
+	if err := g.loadPublicKey(); err != nil {
+		return "", fmt.Errorf("Cloud KMS: %w", err)
+	}
+	_, d, err := digest(g.algorithm, data)
+	if err != nil {
+		return "", err
+	}
+	req, _, err := jsonRequest(g.url(":asymmetricSign"), map[string]interface{}{"digest": d})
+	if err != nil {
+		return "", err
+	}
+	if err := g.authorize(req); err != nil {
+		return "", fmt.Errorf("Cloud KMS: %w", err)
+	}
+	var out struct {
+		Signature []byte `json:"signature"`
+	}
+	if err := do(g.Client, req, &out); err != nil {
+		return "", fmt.Errorf("Cloud KMS: %w", err)
+	}
+	return Signature{Provider: ProviderGCP, Key: g.KeyVersion, Algorithm: g.algorithm, Value: out.Signature}.Armor(), nil
+}
+
+func (g *GCP) Verify(data []byte, signature string) error {
+	s, err := parseFor(signature, ProviderGCP, g.KeyVersion)
+	if err != nil {
+		return err
+	}
+	if err := g.loadPublicKey(); err != nil {
+		return fmt.Errorf("Cloud KMS: %w", err)
+	}
+	hash, d, err := digest(g.algorithm, data)
+	if err != nil {
+		return err
+	}
+	var sum []byte
+	for _, v := range d {
+		sum = v
+	}
+	switch key := g.publicKey.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, sum, s.Value) {
+			return ErrBadSignature
+		}
+	case *rsa.PublicKey:
+		if strings.HasPrefix(g.algorithm, "RSA_SIGN_PSS_") {
+			err = rsa.VerifyPSS(key, hash, sum, s.Value, nil)
+		} else {
+			err = rsa.VerifyPKCS1v15(key, hash, sum, s.Value)
+		}
+		if err != nil {
+			return ErrBadSignature
+		}
+	default:
+		return fmt.Errorf("unsupported Cloud KMS key type %T", g.publicKey)
+	}
+	return nil
+}
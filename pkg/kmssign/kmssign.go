@@ -0,0 +1,186 @@
+// Package kmssign signs commits with keys held in a key-management system,
+// so that the private key never leaves it: AWS KMS, Google Cloud KMS, or
+// the transit engine of HashiCorp Vault. The Sign method of each signer
+// fits quadstore.CommitOptions.Sign.
+//
+// Signatures are ASCII-armored like PGP signatures, but in a block of their
+// own that names the provider and key that made them, so a verifier knows
+// which service to ask:
+//
+//	-----BEGIN QUADGIT KMS SIGNATURE-----
+//	Provider: aws-kms
+//	Key: arn:aws:kms:eu-west-1:111122223333:key/...
+//	Algorithm: ECDSA_SHA_256
+//
+//	MEUCIQD...
+//	-----END QUADGIT KMS SIGNATURE-----
+package kmssign
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Signer signs data with a key held by a key-management system and checks
+// signatures made with it.
+type Signer interface {
+	// Sign returns an armored signature of data.
+	Sign(data []byte) (string, error)
+	// Verify checks an armored signature of data made by the same key,
+	// failing with ErrBadSignature if it does not match.
+	Verify(data []byte, signature string) error
+}
+
+// ErrBadSignature reports a signature that does not match its data or key.
+var ErrBadSignature = errors.New("bad signature")
+
+// Providers, as named in the armor.
+const (
+	ProviderAWS   = "aws-kms"
+	ProviderGCP   = "gcp-kms"
+	ProviderVault = "vault"
+)
+
+const (
+	armorBegin = "-----BEGIN QUADGIT KMS SIGNATURE-----"
+	armorEnd   = "-----END QUADGIT KMS SIGNATURE-----"
+)
+
+// Signature is a parsed armored signature.
+type Signature struct {
+	Provider  string
+	Key       string
+	Algorithm string
+	Value     []byte
+}
+
+// Armor encodes the signature as an armored block.
+func (s Signature) Armor() string {
+	var b strings.Builder
+	b.WriteString(armorBegin + "\n")
+	fmt.Fprintf(&b, "Provider: %s\nKey: %s\n", s.Provider, s.Key)
+	if s.Algorithm != "" {
+		fmt.Fprintf(&b, "Algorithm: %s\n", s.Algorithm)
+	}
+	b.WriteString("\n")
+	enc := base64.StdEncoding.EncodeToString(s.Value)
+	for len(enc) > 64 {
+		b.WriteString(enc[:64] + "\n")
+		enc = enc[64:]
+	}
+	b.WriteString(enc + "\n" + armorEnd + "\n")
+	return b.String()
+}
+
+// IsArmored reports whether a signature is a KMS signature rather than,
+// say, a PGP one.
+func IsArmored(signature string) bool {
+	return strings.HasPrefix(strings.TrimSpace(signature), armorBegin)
+}
+
+// Parse decodes an armored signature.
+func Parse(armored string) (*Signature, error) {
+	body, ok := strings.CutPrefix(strings.TrimSpace(armored), armorBegin)
+	if !ok {
+		return nil, errors.New("not a KMS signature")
+	}
+	body, ok = strings.CutSuffix(body, armorEnd)
+	if !ok {
+		return nil, errors.New("unterminated KMS signature")
+	}
+	headers, data, ok := strings.Cut(strings.TrimLeft(body, "\r\n"), "\n\n")
+	if !ok {
+		return nil, errors.New("malformed KMS signature")
+	}
+	s := &Signature{}
+	for _, line := range strings.Split(headers, "\n") {
+		name, value, _ := strings.Cut(line, ":")
+		switch strings.TrimSpace(name) {
+		case "Provider":
+			s.Provider = strings.TrimSpace(value)
+		case "Key":
+			s.Key = strings.TrimSpace(value)
+		case "Algorithm":
+			s.Algorithm = strings.TrimSpace(value)
+		}
+	}
+	value, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(data), ""))
+	if err != nil {
+		return nil, fmt.Errorf("malformed KMS signature: %v", err)
+	}
+	s.Value = value
+	if s.Provider == "" || s.Key == "" {
+		return nil, errors.New("KMS signature names no provider or key")
+	}
+	return s, nil
+}
+
+// parseFor parses a signature and checks that it was made by key.
+func parseFor(armored, provider, key string) (*Signature, error) {
+	s, err := Parse(armored)
+	if err != nil {
+		return nil, err
+	}
+	if s.Provider != provider || s.Key != key {
+		return nil, fmt.Errorf("%w: made by %s key %s, not %s key %s", ErrBadSignature, s.Provider, s.Key, provider, key)
+	}
+	return s, nil
+}
+
+// defaultClient is used by signers without a Client of their own.
+var defaultClient = &http.Client{Timeout: 30 * time.Second}
+
+func client(c *http.Client) *http.Client {
+	if c != nil {
+		return c
+	}
+	return defaultClient
+}
+
+// An apiError is an error response from a provider's API.
+type apiError struct {
+	Status int
+	Body   string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, http.StatusText(e.Status), e.Body)
+}
+
+// do sends req and decodes its JSON response into out.
+func do(c *http.Client, req *http.Request, out interface{}) error {
+	resp, err := client(c).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return &apiError{resp.StatusCode, strings.TrimSpace(string(body))}
+	}
+	return json.Unmarshal(body, out)
+}
+
+// jsonRequest returns a POST request with a JSON body.
+func jsonRequest(url string, body interface{}) (*http.Request, []byte, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, payload, nil
+}
@@ -0,0 +1,194 @@
+package kmssign
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeKMS serves the signing calls of all three providers, with an ECDSA
+// P-256 key made for each key name on first use.
+type fakeKMS struct {
+	mu   sync.Mutex
+	keys map[string]*ecdsa.PrivateKey
+}
+
+func (f *fakeKMS) key(name string) *ecdsa.PrivateKey {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.keys[name] == nil {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			panic(err)
+		}
+		f.keys[name] = key
+	}
+	return f.keys[name]
+}
+
+func newFakeKMS(t *testing.T) *httptest.Server {
+	f := &fakeKMS{keys: map[string]*ecdsa.PrivateKey{}}
+	reply := func(w http.ResponseWriter, v interface{}) {
+		json.NewEncoder(w).Encode(v)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		b64 := func(name string) []byte {
+			s, _ := body[name].(string)
+			data, _ := base64.StdEncoding.DecodeString(s)
+			return data
+		}
+		switch path := r.URL.Path; {
+		case r.Header.Get("X-Amz-Target") != "":
+			if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+				http.Error(w, "unsigned request", http.StatusForbidden)
+				return
+			}
+			key := f.key(body["KeyId"].(string))
+			switch r.Header.Get("X-Amz-Target") {
+			case "TrentService.Sign":
+				sig, _ := ecdsa.SignASN1(rand.Reader, key, b64("Message"))
+				reply(w, map[string][]byte{"Signature": sig})
+			case "TrentService.Verify":
+				if !ecdsa.VerifyASN1(&key.PublicKey, b64("Message"), b64("Signature")) {
+					http.Error(w, `{"__type":"KMSInvalidSignatureException"}`, http.StatusBadRequest)
+					return
+				}
+				reply(w, map[string]bool{"SignatureValid": true})
+			}
+		case strings.HasPrefix(path, "/v1/projects/"):
+			if r.Header.Get("Authorization") != "Bearer token" {
+				http.Error(w, "no token", http.StatusUnauthorized)
+				return
+			}
+			name, action, _ := strings.Cut(strings.TrimPrefix(path, "/v1/"), ":")
+			if name, ok := strings.CutSuffix(name, "/publicKey"); ok {
+				der, _ := x509.MarshalPKIXPublicKey(&f.key(name).PublicKey)
+				reply(w, map[string]string{
+					"pem":       string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+					"algorithm": "EC_SIGN_P256_SHA256",
+				})
+			} else if action == "asymmetricSign" {
+				digest, _ := base64.StdEncoding.DecodeString(body["digest"].(map[string]interface{})["sha256"].(string))
+				sig, _ := ecdsa.SignASN1(rand.Reader, f.key(name), digest)
+				reply(w, map[string][]byte{"signature": sig})
+			}
+		case strings.HasPrefix(path, "/v1/transit/"):
+			parts := strings.Split(path, "/") // "", v1, transit, action, key, sha2-256
+			key := f.key(parts[4])
+			digest := sha256.Sum256(b64("input"))
+			switch parts[3] {
+			case "sign":
+				sig, _ := ecdsa.SignASN1(rand.Reader, key, digest[:])
+				reply(w, map[string]interface{}{"data": map[string]string{
+					"signature": "vault:v1:" + base64.StdEncoding.EncodeToString(sig),
+				}})
+			case "verify":
+				sig, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(body["signature"].(string), "vault:v1:"))
+				reply(w, map[string]interface{}{"data": map[string]bool{
+					"valid": ecdsa.VerifyASN1(&key.PublicKey, digest[:], sig),
+				}})
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestSignVerify(t *testing.T) {
+	srv := newFakeKMS(t)
+	token := func() (string, error) { return "token", nil }
+	for _, tt := range []struct {
+		provider string
+		signer   func(key string) Signer
+	}{
+		{ProviderAWS, func(key string) Signer {
+			return &AWS{KeyID: key, Region: "eu-west-1", Endpoint: srv.URL, Credentials: AWSCredentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}}
+		}},
+		{ProviderGCP, func(key string) Signer {
+			return &GCP{KeyVersion: "projects/p/locations/l/keyRings/r/cryptoKeys/" + key + "/cryptoKeyVersions/1", Token: token, Endpoint: srv.URL}
+		}},
+		{ProviderVault, func(key string) Signer {
+			return &Vault{Addr: srv.URL, Token: "token", Key: key}
+		}},
+	} {
+		t.Run(tt.provider, func(t *testing.T) {
+			payload := []byte("tree 1234\nauthor alice\n\ncommit message\n")
+			signer := tt.signer("release")
+			signature, err := signer.Sign(payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, err := Parse(signature)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !IsArmored(signature) || s.Provider != tt.provider {
+				t.Errorf("signature %q is not an armored %s signature", signature, tt.provider)
+			}
+			if err := signer.Verify(payload, signature); err != nil {
+				t.Errorf("Verify of its own signature: %v", err)
+			}
+
+			tampered := append([]byte(nil), payload...)
+			tampered[len(tampered)-2] = '!'
+			if err := signer.Verify(tampered, signature); !errors.Is(err, ErrBadSignature) {
+				t.Errorf("Verify of a tampered payload returned %v, want ErrBadSignature", err)
+			}
+
+			other := tt.signer("other")
+			if err := other.Verify(payload, signature); !errors.Is(err, ErrBadSignature) {
+				t.Errorf("Verify with another key returned %v, want ErrBadSignature", err)
+			}
+			// A signature relabelled as the other key's must still fail.
+			s.Key = strings.ReplaceAll(s.Key, "release", "other")
+			if err := other.Verify(payload, s.Armor()); !errors.Is(err, ErrBadSignature) {
+				t.Errorf("Verify of a relabelled signature returned %v, want ErrBadSignature", err)
+			}
+		})
+	}
+}
+
+func TestArmor(t *testing.T) {
+	s := Signature{Provider: ProviderAWS, Key: "alias/release", Algorithm: "ECDSA_SHA_256", Value: []byte(strings.Repeat("signature", 20))}
+	armored := s.Armor()
+	got, err := Parse(armored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*got, s) {
+		t.Errorf("Parse(Armor()) = %+v, want %+v", *got, s)
+	}
+	for _, line := range strings.Split(armored, "\n") {
+		if len(line) > 64 && !strings.HasPrefix(line, "-----") {
+			t.Errorf("armor line %q is longer than 64 characters", line)
+		}
+	}
+
+	for _, bad := range []string{
+		"-----BEGIN PGP SIGNATURE-----\n\nabc\n-----END PGP SIGNATURE-----\n",
+		armorBegin + "\nProvider: vault\nKey: k\n\nc2ln\n",
+		armorBegin + "\nProvider: vault\nKey: k\nc2ln\n" + armorEnd,
+		armorBegin + "\nProvider: vault\nKey: k\n\n!!!\n" + armorEnd,
+		armorBegin + "\nProvider: vault\n\nc2ln\n" + armorEnd,
+	} {
+		if s, err := Parse(bad); err == nil {
+			t.Errorf("Parse(%q) = %+v, want an error", bad, s)
+		}
+	}
+}
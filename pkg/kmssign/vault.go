@@ -0,0 +1,87 @@
+package kmssign
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Vault signs with a key of HashiCorp Vault's transit secrets engine. The
+// signature is Vault's own "vault:v<version>:..." string, which records
+// the key version that made it.
+type Vault struct {
+	// Addr is Vault's address; VAULT_ADDR if empty.
+	Addr string
+	// Token authenticates to Vault; VAULT_TOKEN if empty.
+	Token string
+	// Mount is where the transit engine is mounted; "transit" if empty.
+	Mount string
+	Key   string
+	// Namespace is the Vault Enterprise namespace, if any.
+	Namespace string
+	Client    *http.Client
+}
+
+func (v *Vault) post(action string, body map[string]interface{}) (map[string]interface{}, error) {
+	addr, token, mount := v.Addr, v.Token, v.Mount
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	if mount == "" {
+		mount = "transit"
+	}
+	if addr == "" || token == "" {
+		return nil, fmt.Errorf("Vault: no address or token (set VAULT_ADDR and VAULT_TOKEN)")
+	}
+	url := strings.TrimSuffix(addr, "/") + "/v1/" + strings.Trim(mount, "/") + "/" + action + "/" + v.Key + "/sha2-256"
+	req, _, err := jsonRequest(url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+	var out struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := do(v.Client, req, &out); err != nil {
+		return nil, fmt.Errorf("Vault: %w", err)
+	}
+	return out.Data, nil
+}
+
+func (v *Vault) Sign(data []byte) (string, error) {
+	out, err := v.post("sign", map[string]interface{}{"input": base64.StdEncoding.EncodeToString(data)})
+	if err != nil {
+		return "", err
+	}
+	sig, _ := out["signature"].(string)
+	if sig == "" {
+		return "", fmt.Errorf("Vault: no signature in response")
+	}
+	return Signature{Provider: ProviderVault, Key: v.Key, Value: []byte(sig)}.Armor(), nil
+}
+
+func (v *Vault) Verify(data []byte, signature string) error {
+	s, err := parseFor(signature, ProviderVault, v.Key)
+	if err != nil {
+		return err
+	}
+	out, err := v.post("verify", map[string]interface{}{
+		"input":     base64.StdEncoding.EncodeToString(data),
+		"signature": string(s.Value),
+	})
+	if err != nil {
+		return err
+	}
+	if valid, _ := out["valid"].(bool); !valid {
+		return ErrBadSignature
+	}
+	return nil
+}
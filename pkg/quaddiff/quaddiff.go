@@ -0,0 +1,170 @@
+// Package quaddiff computes the difference between two sets of RDF
+// statements given as sorted streams. It holds only the current statement
+// of each stream in memory, so datasets of any size can be compared without
+// a repository.
+//
+// Statements are compared as strings, so both streams must use the same
+// serialization, such as the N-Quads form produced by rdfio.FormatQuad, and
+// be sorted in byte order (as with `LC_ALL=C sort`). Duplicates are ignored.
+package quaddiff
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Op says whether a statement was added or deleted.
+type Op int
+
+const (
+	Deleted Op = iota
+	Added
+)
+
+// String returns "-" or "+", as diff output uses them.
+func (op Op) String() string {
+	if op == Added {
+		return "+"
+	}
+	return "-"
+}
+
+// ErrUnsorted is returned when a stream yields a statement that sorts before
+// the previous one.
+var ErrUnsorted = errors.New("statements are not sorted")
+
+// Stream yields statements in ascending byte order.
+type Stream interface {
+	// Next returns the next statement, or io.EOF at the end.
+	Next() (string, error)
+}
+
+// Slice returns a Stream over lines, which must already be sorted.
+func Slice(lines []string) Stream {
+	return &sliceStream{lines: lines}
+}
+
+type sliceStream struct {
+	lines []string
+}
+
+func (s *sliceStream) Next() (string, error) {
+	if len(s.lines) == 0 {
+		return "", io.EOF
+	}
+	line := s.lines[0]
+	s.lines = s.lines[1:]
+	return line, nil
+}
+
+// Lines returns a Stream over the lines of r, trimmed of surrounding
+// whitespace. Blank lines and comments are skipped.
+func Lines(r io.Reader) Stream {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return lineStream{scanner}
+}
+
+type lineStream struct {
+	scanner *bufio.Scanner
+}
+
+func (s lineStream) Next() (string, error) {
+	for s.scanner.Scan() {
+		line := strings.TrimSpace(s.scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			return line, nil
+		}
+	}
+	if err := s.scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", io.EOF
+}
+
+// sorted reads a Stream, dropping duplicates and checking the order.
+type sorted struct {
+	s       Stream
+	name    string
+	current string
+	done    bool
+	started bool
+}
+
+func (c *sorted) advance() error {
+	for {
+		line, err := c.s.Next()
+		if err == io.EOF {
+			c.done = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if c.started && line <= c.current {
+			if line == c.current {
+				continue
+			}
+			return fmt.Errorf("%s: %w: %q follows %q", c.name, ErrUnsorted, line, c.current)
+		}
+		c.current, c.started = line, true
+		return nil
+	}
+}
+
+// Diff calls fn, in ascending order, for every statement in after but not
+// in before (Added) and every statement in before but not in after
+// (Deleted). It stops at the first error from a stream or from fn.
+func Diff(before, after Stream, fn func(op Op, statement string) error) error {
+	a := &sorted{s: before, name: "before"}
+	b := &sorted{s: after, name: "after"}
+	if err := a.advance(); err != nil {
+		return err
+	}
+	if err := b.advance(); err != nil {
+		return err
+	}
+	for !a.done || !b.done {
+		var err error
+		switch {
+		case b.done || !a.done && a.current < b.current:
+			if err = fn(Deleted, a.current); err == nil {
+				err = a.advance()
+			}
+		case a.done || b.current < a.current:
+			if err = fn(Added, b.current); err == nil {
+				err = b.advance()
+			}
+		default:
+			if err = a.advance(); err == nil {
+				err = b.advance()
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stats counts the statements Diff reports.
+type Stats struct {
+	Added, Deleted int
+}
+
+// Count diffs two streams and returns only the number of changes.
+func Count(before, after Stream) (Stats, error) {
+	var stats Stats
+	err := Diff(before, after, func(op Op, _ string) error {
+		if op == Added {
+			stats.Added++
+		} else {
+			stats.Deleted++
+		}
+		return nil
+	})
+	return stats, err
+}
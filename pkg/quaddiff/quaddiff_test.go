@@ -0,0 +1,100 @@
+package quaddiff
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	a  = `<http://example.org/a> <http://example.org/p> "1" .`
+	b  = `<http://example.org/b> <http://example.org/p> "2" .`
+	c  = `<http://example.org/c> <http://example.org/p> "3" .`
+	g1 = `<http://example.org/a> <http://example.org/p> "1" <http://example.org/g1> .`
+	g2 = `<http://example.org/a> <http://example.org/p> "1" <http://example.org/g2> .`
+)
+
+// collect returns the changes Diff reports, as "+ statement" or
+// "- statement".
+func collect(t *testing.T, before, after Stream) []string {
+	t.Helper()
+	var changes []string
+	err := Diff(before, after, func(op Op, statement string) error {
+		changes = append(changes, op.String()+" "+statement)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return changes
+}
+
+func TestDiff(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		before, after []string
+		want          []string
+	}{
+		{"unchanged", []string{a, b, c}, []string{a, b, c}, nil},
+		{"added", []string{a, c}, []string{a, b, c}, []string{"+ " + b}},
+		{"removed", []string{a, b, c}, []string{a, c}, []string{"- " + b}},
+		{"added and removed", []string{a, b}, []string{b, c}, []string{"- " + a, "+ " + c}},
+		{"from empty", nil, []string{a, b}, []string{"+ " + a, "+ " + b}},
+		{"to empty", []string{a, b}, nil, []string{"- " + a, "- " + b}},
+		{"duplicates ignored", []string{a, a, b}, []string{a, b, b}, nil},
+		// The same triple in another graph is another statement.
+		{"moved between graphs", []string{g1}, []string{g2}, []string{"- " + g1, "+ " + g2}},
+		{"graph added beside default", []string{a}, []string{a, g1}, []string{"+ " + g1}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := collect(t, Slice(tt.before), Slice(tt.after))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff = %q, want %q", got, tt.want)
+			}
+			stats, err := Count(Slice(tt.before), Slice(tt.after))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want Stats
+			for _, change := range tt.want {
+				if strings.HasPrefix(change, "+") {
+					want.Added++
+				} else {
+					want.Deleted++
+				}
+			}
+			if stats != want {
+				t.Errorf("Count = %+v, want %+v", stats, want)
+			}
+		})
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	before := Lines(strings.NewReader("# before\n" + a + "\n\n  " + b + "  \n"))
+	after := Lines(strings.NewReader(b + "\n# a comment\n" + c + "\n"))
+	want := []string{"- " + a, "+ " + c}
+	if got := collect(t, before, after); !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff = %q, want %q", got, want)
+	}
+}
+
+func TestDiffUnsorted(t *testing.T) {
+	err := Diff(Slice([]string{a}), Slice([]string{c, b}), func(Op, string) error { return nil })
+	if !errors.Is(err, ErrUnsorted) {
+		t.Errorf("Diff of an unsorted stream returned %v, want ErrUnsorted", err)
+	}
+}
+
+func TestDiffStopsAtError(t *testing.T) {
+	stop := errors.New("stop")
+	calls := 0
+	err := Diff(Slice(nil), Slice([]string{a, b, c}), func(Op, string) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("Diff returned %v after %d calls, want stop after 1", err, calls)
+	}
+}
@@ -5,6 +5,7 @@ package quadstore
 
 import (
 	"context"
+	"errors"
 	"io"
 )
 
@@ -14,29 +15,46 @@ type OpenOptions struct {
 	Path string
 	// The namespace to operate on. If empty, uses a default namespace.
 	Namespace string
+	// IsolateNamespaces stores each namespace other than the default one
+	// as a Badger instance of its own in Path/namespaces/<Namespace>,
+	// instead of under a key prefix in the shared instance at Path, so
+	// that it can be backed up, size-limited or dropped on its own. This
+	// is the layout the quad-db command uses.
+	IsolateNamespaces bool
+	// CacheSize bounds, in bytes of encoded objects, the in-process cache of
+	// decoded commits and trees. Zero selects the default of 64 MiB; a
+	// negative value disables the cache.
+	CacheSize int64
+	// Paranoid makes writes of an object whose hash is already stored
+	// compare the two byte for byte and fail with ErrObjectMismatch if they
+	// differ, instead of assuming content-addressed equality.
+	Paranoid bool
+	// Authorizer, if set, is consulted before every commit, merge and
+	// received push, and can reject it (see Authorizer). Nil allows all
+	// writes.
+	Authorizer Authorizer
+	// Clock stamps new commits. Nil uses the system clock; tests and
+	// reproducible pipelines pass a FixedClock.
+	Clock Clock
 }
 
+// SkipAll may be returned by a WalkCommits callback to stop the walk early
+// without reporting an error.
+var SkipAll = errors.New("skip all remaining commits")
+
 // Store defines the public API for interacting with a versioned quad store repository.
 // All implementations of this interface must be safe for concurrent use from multiple goroutines.
 type Store interface {
 	// --- Core Object Read/Write ---
 
 	// ReadCommit retrieves a complete commit object by its SHA-1 hash.
+	// It returns an error matching ErrNotFound if there is no such commit.
 	ReadCommit(ctx context.Context, hash string) (*Commit, error)
 
-	// Commit creates a new commit object in the repository. It is an atomic operation.
-	//   - parentHash: The hash of the commit this new commit will be on top of.
-	//   - author: The author metadata for the commit.
-	//   - message: The commit message.
-	//   - graphData: A map where keys are named graph IRIs and values are the complete
-	//     set of quads for that graph in the new state. Providing an empty slice for a
-	//     graph IRI will delete that graph. Graphs not included in the map will be
-	//     inherited from the parent commit.
-	//   - sign: An optional callback function to perform GPG signing. If nil, the
-	//     commit will be unsigned. The function receives the canonical commit data
-	//     and should return an ASCII-armored signature.
-	// It returns the hash of the newly created commit.
-	Commit(ctx context.Context, parentHash string, author Author, message string, graphData map[string][]Quad, sign func(data []byte) (string, error)) (string, error)
+	// Commit creates a new commit object in the repository as described by opts. It is
+	// an atomic operation. See CommitOptions for amending, empty commits, signing and
+	// metadata. It returns the hash of the newly created commit.
+	Commit(ctx context.Context, opts CommitOptions) (string, error)
 
 	// --- Reference Management ---
 
@@ -45,14 +63,25 @@ type Store interface {
 	SetReference(ctx context.Context, name string, hash string) error
 
 	// GetReference retrieves the commit hash a specific full reference name points to.
+	// It returns an error matching ErrRefNotFound if the reference does not exist.
 	GetReference(ctx context.Context, name string) (string, error)
 
 	// ResolveRef resolves a user-friendly name (e.g., "main", "v1.0", "HEAD", "a1b2c3d") to a full commit hash.
+	// Names may be followed by ancestry operators: "~N" (Nth first-parent ancestor), "^N" (Nth parent, so
+	// "^2" is a merge's second parent) and "^{commit}" (the commit an annotated tag points to), as in "HEAD~2^2".
+	// It returns an error matching ErrRefNotFound if the name matches nothing.
 	ResolveRef(ctx context.Context, name string) (string, error)
 
 	// ListReferences returns a list of all references matching a given prefix (e.g., "refs/heads/").
 	ListReferences(ctx context.Context, prefix string) ([]Reference, error)
 
+	// UpdateReference moves a reference from oldHash to newHash atomically. It returns
+	// an error matching ErrStaleParent, and changes nothing, if the reference no
+	// longer points to oldHash; an empty oldHash requires that it does not exist yet.
+	// Use it instead of SetReference to advance a branch after Commit when other
+	// writers may be committing to the same branch.
+	UpdateReference(ctx context.Context, name string, oldHash, newHash string) error
+
 	// DeleteReference removes a reference from the repository.
 	DeleteReference(ctx context.Context, name string) error
 
@@ -61,6 +90,18 @@ type Store interface {
 	// Log retrieves a slice of commits by walking the history backwards from a starting hash.
 	Log(ctx context.Context, startHash string, limit int) ([]*Commit, error)
 
+	// WalkCommits calls fn for each commit in the first-parent history of startHash,
+	// newest first, reading commits one at a time so that long histories are never
+	// materialized. The walk ends at the root commit, when ctx is canceled (returning
+	// ctx.Err()), when fn returns an error (which WalkCommits returns), or when fn
+	// returns SkipAll (in which case WalkCommits returns nil).
+	WalkCommits(ctx context.Context, startHash string, fn func(*Commit) error) error
+
+	// FindCommits returns the hashes of the commits whose Metadata maps key to value,
+	// using an index rather than walking history. It includes commits that are no
+	// longer reachable from any reference.
+	FindCommits(ctx context.Context, key, value string) ([]string, error)
+
 	// Blame annotates each quad in a named graph at a specific commit with the commit that last introduced it.
 	// It returns a read-only channel from which the caller can stream the results. This is a
 	// memory-efficient way to handle potentially large graphs. The channel will be closed when the operation is complete.
@@ -68,16 +109,39 @@ type Store interface {
 
 	// Diff generates the changes (additions/deletions) between the states of two commits.
 	// It returns a read-only channel for streaming results to handle large diffs efficiently.
-	// The channel will be closed when the operation is complete.
-	Diff(ctx context.Context, fromCommitHash, toCommitHash string) (<-chan Change, error)
+	// The channel will be closed when the operation is complete. The zero DiffOptions
+	// value selects every change; see DiffOptions for narrowing the result.
+	Diff(ctx context.Context, fromCommitHash, toCommitHash string, opts DiffOptions) (<-chan Change, error)
+
+	// Snapshot returns a read-only view pinned to the commit ref resolves to now. Every
+	// call on the snapshot reads that commit's state, even while new commits land on
+	// ref, so multi-step reads are consistent with each other. It returns an error
+	// matching ErrRefNotFound if ref matches nothing.
+	Snapshot(ctx context.Context, ref string) (Snapshot, error)
+
+	// ExportGraph writes the quads of a named graph at the commit ref resolves to
+	// to w in format, as it reads them. Only a small, fixed-size buffer of output is
+	// held in memory, so graphs of any size can be exported, and a slow w slows the
+	// export down rather than growing the buffer. It returns an error matching
+	// ErrRefNotFound if ref matches nothing, ErrNotFound if the commit has no such
+	// graph, and ctx.Err() if ctx is canceled part way.
+	ExportGraph(ctx context.Context, ref, graphIRI string, w io.Writer, format Format) error
 
 	// --- Advanced Operations ---
 
+	// MergeBase returns the best common ancestors of two commits: the commits
+	// reachable from both that are not ancestors of another such commit. There
+	// is usually one, but criss-cross merges can leave several, ordered from the
+	// highest generation (the longest path to a root commit) down. It returns an
+	// empty slice if the histories are unrelated.
+	MergeBase(ctx context.Context, hashA, hashB string) ([]string, error)
+
 	// Merge attempts to perform a three-way merge.
 	// It takes the commit hashes for the target branch head, the source branch head,
 	// and their calculated common ancestor. If the merge is clean, it returns an empty
 	// slice of conflicts and no error. If conflicts are detected, it returns a slice
-	// of Conflict objects and no error, indicating a manual resolution is required.
+	// of Conflict objects together with an error matching ErrConflict, indicating a
+	// manual resolution is required.
 	Merge(ctx context.Context, baseCommitHash, targetCommitHash, sourceCommitHash string) ([]Conflict, error)
 	
 	// Revert creates a new commit on top of a given branch head that is the inverse of a specified commit.
@@ -98,8 +162,31 @@ type Store interface {
 	Close() error
 }
 
+// Snapshot is a read-only view of a repository pinned to one commit, returned by
+// Store.Snapshot. It is safe for concurrent use.
+type Snapshot interface {
+	// Commit returns the commit the snapshot is pinned to.
+	Commit() *Commit
+
+	// Graphs returns the names of the graphs in the snapshot, sorted.
+	Graphs(ctx context.Context) ([]string, error)
+
+	// Match streams the quads that match pattern. The channel is closed when all have
+	// been sent or ctx is canceled.
+	Match(ctx context.Context, pattern QuadPattern) (<-chan Quad, error)
+
+	// Diff streams the changes from fromCommitHash to the snapshot's commit, as
+	// Store.Diff does.
+	Diff(ctx context.Context, fromCommitHash string, opts DiffOptions) (<-chan Change, error)
+
+	// Close releases the snapshot. Calls made after Close fail.
+	Close() error
+}
+
 // Open is the main entry point to the quadstore library.
 // It initializes and returns a Store instance for a given repository path and namespace.
+// It returns an error matching ErrNotRepository if the path holds no repository, or
+// ErrRepoLocked if another process has it open.
 // The concrete implementation is in the internal/datastore package and is not exposed publicly.
 func Open(ctx context.Context, opts OpenOptions) (Store, error) {
 	// This function's body will be implemented in a separate, internal package.
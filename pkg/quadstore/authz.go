@@ -0,0 +1,45 @@
+package quadstore
+
+import (
+	"context"
+)
+
+// Action names the kind of write an Authorizer is asked to allow.
+type Action string
+
+const (
+	ActionCommit Action = "commit" // A new commit on a branch
+	ActionMerge  Action = "merge"  // A merge or fast-forward of a branch
+	ActionPush   Action = "push"   // Reference updates received from a remote client
+)
+
+// AccessRequest describes a write before it is applied.
+type AccessRequest struct {
+	// Identity is who is acting, e.g. the committer's email address or the
+	// user the server authenticated a push from. It is empty when unknown.
+	Identity string `json:"identity"`
+	Action   Action `json:"action"`
+	// Refs are the references the write moves, e.g. "refs/heads/main".
+	Refs []string `json:"refs"`
+	// Graphs are the names of the graphs whose content the write changes,
+	// sorted, as N-Quads terms such as "<http://example.org/g>", or
+	// "default" for the default graph. Renaming a graph touches both names.
+	Graphs []string `json:"graphs"`
+}
+
+// Authorizer decides whether a write may proceed. It is consulted on every
+// commit, merge and received push, after the new content is known but
+// before any reference moves, so embedders can enforce per-graph or
+// per-branch write permissions. Authorize returns nil to allow the write,
+// or an error, which should match ErrPermissionDenied, to reject it.
+type Authorizer interface {
+	Authorize(ctx context.Context, req AccessRequest) error
+}
+
+// AuthorizerFunc adapts an ordinary function to the Authorizer interface.
+type AuthorizerFunc func(ctx context.Context, req AccessRequest) error
+
+// Authorize calls f(ctx, req).
+func (f AuthorizerFunc) Authorize(ctx context.Context, req AccessRequest) error {
+	return f(ctx, req)
+}
@@ -0,0 +1,22 @@
+package quadstore
+
+import "time"
+
+// Clock tells the time that new commits, merges and push certificates are
+// stamped with. Injecting a fixed clock makes commits reproducible: the
+// same inputs then produce byte-identical objects, and so the same hashes.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the Clock that reads the system time.
+type SystemClock struct{}
+
+// Now returns the current time.
+func (SystemClock) Now() time.Time { return time.Now() }
+
+// FixedClock is a Clock that always returns the same time.
+type FixedClock time.Time
+
+// Now returns the fixed time.
+func (c FixedClock) Now() time.Time { return time.Time(c) }
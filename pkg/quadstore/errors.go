@@ -0,0 +1,52 @@
+package quadstore
+
+import "errors"
+
+// Errors returned by Store implementations. They are usually wrapped with
+// details such as the hash or reference name involved, so callers should
+// compare with errors.Is rather than ==.
+var (
+	// ErrNotFound reports that a commit, tree or blob does not exist. Every
+	// more specific not-found error below also matches ErrNotFound.
+	ErrNotFound = errors.New("not found")
+
+	// ErrRefNotFound reports that a reference does not exist.
+	ErrRefNotFound error = kindNotFound("reference")
+
+	// ErrStaleParent reports that a commit was built on a parent that is no
+	// longer the head of its branch because another writer moved it first.
+	// Rebuild the commit on the new head and retry.
+	ErrStaleParent = errors.New("parent is no longer the branch head")
+
+	// ErrConflict reports that a merge cannot complete automatically. The
+	// conflicts themselves are returned alongside it.
+	ErrConflict = errors.New("merge conflict")
+
+	// ErrRepoLocked reports that another process has the repository open.
+	ErrRepoLocked = errors.New("repository is locked by another process")
+
+	// ErrNotRepository reports that a path holds no initialized repository.
+	ErrNotRepository = errors.New("repository not initialized")
+
+	// ErrObjectMismatch reports that an object being written differs from the
+	// one already stored under the same hash: either the stored copy is
+	// corrupt or two objects collide. It is only detected in paranoid mode.
+	ErrObjectMismatch = errors.New("stored object differs from the one being written")
+
+	// ErrCorruptObject reports that a stored object no longer hashes to the
+	// hash it is stored under, e.g. after bit rot on disk. It is only
+	// detected when objects are verified on read.
+	ErrCorruptObject = errors.New("stored object is corrupt")
+
+	// ErrPermissionDenied reports that an Authorizer rejected a write.
+	ErrPermissionDenied = errors.New("permission denied")
+)
+
+// kindNotFound is the not-found error for one kind of entity. It reads like
+// ErrNotFound, so messages such as "reference main not found" stay natural,
+// and matches it under errors.Is.
+type kindNotFound string
+
+func (e kindNotFound) Error() string { return "not found" }
+
+func (e kindNotFound) Is(target error) bool { return target == ErrNotFound }
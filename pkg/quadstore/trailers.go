@@ -0,0 +1,93 @@
+package quadstore
+
+import (
+	"strings"
+)
+
+// Trailer is a "Key: value" line at the end of a commit message, such as
+// "Reviewed-by: Jane Doe <jane@example.org>" or "Ticket: ABC-123".
+type Trailer struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Trailers is the ordered list of trailers of a commit message. A key may
+// appear more than once.
+type Trailers []Trailer
+
+// Get returns the values of every trailer with the given key, compared
+// case-insensitively, in message order.
+func (t Trailers) Get(key string) []string {
+	var values []string
+	for _, trailer := range t {
+		if strings.EqualFold(trailer.Key, key) {
+			values = append(values, trailer.Value)
+		}
+	}
+	return values
+}
+
+// Has reports whether a trailer with the given key has the given value. An
+// empty value matches any trailer with the key.
+func (t Trailers) Has(key, value string) bool {
+	for _, v := range t.Get(key) {
+		if value == "" || v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTrailers returns the trailers of a commit message: the lines of its
+// last paragraph, if the message has a paragraph before it and every line
+// of the last one is a trailer. Keys consist of letters, digits and '-';
+// lines starting with whitespace continue the previous trailer's value.
+func ParseTrailers(message string) Trailers {
+	message = strings.TrimRight(strings.ReplaceAll(message, "\r\n", "\n"), "\n \t")
+	split := strings.LastIndex(message, "\n\n")
+	if split < 0 || strings.TrimSpace(message[:split]) == "" {
+		return nil
+	}
+	var trailers Trailers
+	for _, line := range strings.Split(message[split+2:], "\n") {
+		if line != "" && (line[0] == ' ' || line[0] == '\t') && len(trailers) > 0 {
+			last := &trailers[len(trailers)-1]
+			last.Value += " " + strings.TrimSpace(line)
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || !isTrailerKey(key) {
+			return nil
+		}
+		trailers = append(trailers, Trailer{Key: key, Value: strings.TrimSpace(value)})
+	}
+	return trailers
+}
+
+func isTrailerKey(key string) bool {
+	if key == "" || key[0] == '-' {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// AppendTrailers adds trailers to the end of a commit message, starting a
+// trailer paragraph if the message does not already end with one.
+func AppendTrailers(message string, trailers ...Trailer) string {
+	if len(trailers) == 0 {
+		return message
+	}
+	message = strings.TrimRight(message, "\n \t")
+	if ParseTrailers(message) == nil {
+		message += "\n"
+	}
+	for _, t := range trailers {
+		message += "\n" + t.Key + ": " + t.Value
+	}
+	return message
+}
@@ -28,6 +28,16 @@ type CommitStats struct {
 	TotalQuads int64 `json:"total_quads"`
 	Added      int   `json:"added"`
 	Deleted    int   `json:"deleted"`
+
+	// Graphs breaks Added and Deleted down per named graph. Only graphs
+	// changed by the commit are present.
+	Graphs map[string]GraphStats `json:"graphs,omitempty"`
+}
+
+// GraphStats counts the quads a commit added to and deleted from one graph.
+type GraphStats struct {
+	Added   int `json:"added"`
+	Deleted int `json:"deleted"`
 }
 
 // Commit represents a single, versioned point in the repository's history.
@@ -48,6 +58,51 @@ type Commit struct {
 	// Stats contains pre-computed metrics about the state of the graph
 	// at the time of this commit.
 	Stats CommitStats `json:"stats"`
+
+	// Trailers holds the "Key: value" lines ending the message, such as
+	// Reviewed-by or Ticket, as parsed by ParseTrailers.
+	Trailers Trailers `json:"trailers,omitempty"`
+
+	// Metadata holds arbitrary key/value pairs recorded with the commit,
+	// such as a pipeline run ID. It is indexed; see Store.FindCommits.
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// CommitOptions describes a commit to create. New capabilities are added as
+// fields, so callers setting only what they need keep compiling.
+type CommitOptions struct {
+	// ParentHash is the commit the new commit is on top of. When Amend is
+	// set, it is the commit being replaced instead, and the new commit takes
+	// over its parents.
+	ParentHash string
+	// Author is who made the change.
+	Author Author
+	// Committer is who recorded the commit, if not the author (e.g. a
+	// pipeline committing on someone's behalf). Nil means the author.
+	Committer *Author
+	// Message is the commit message. When amending, an empty message keeps
+	// the message of the replaced commit.
+	Message string
+	// GraphData maps named graph IRIs to the complete set of quads for that
+	// graph in the new state. An empty slice deletes the graph; graphs not in
+	// the map are inherited from the parent.
+	GraphData map[string][]Quad
+	// Amend replaces ParentHash rather than building on top of it.
+	Amend bool
+	// AllowEmpty permits a commit whose state equals its parent's. Without
+	// it, such a commit is rejected.
+	AllowEmpty bool
+	// Sign, if set, receives the canonical commit data and returns an
+	// ASCII-armored signature for it.
+	Sign func(data []byte) (string, error)
+	// Trailers are appended to Message as a trailer paragraph.
+	Trailers Trailers
+	// Metadata holds extra key/value pairs stored with the commit and
+	// indexed for Store.FindCommits.
+	Metadata map[string]string
+	// SkipStats leaves CommitStats to be computed on demand instead of when
+	// the commit is created, which speeds up bulk loads.
+	SkipStats bool
 }
 
 // Reference is a named, mutable pointer to a commit. It represents a branch or a tag.
@@ -71,6 +126,39 @@ type Change struct {
 	Type     ChangeType `json:"type"`
 }
 
+// DiffOptions narrows a diff to the slice of a change set a reviewer cares about.
+// Empty fields match everything; set fields are combined with AND.
+type DiffOptions struct {
+	// Graph selects a single named graph IRI, or every graph under an IRI prefix
+	// when it ends in "*" (e.g., "http://example.org/datasets/*").
+	Graph string `json:"graph,omitempty"`
+	// Subject selects changes to quads with this subject IRI or blank node.
+	Subject string `json:"subject,omitempty"`
+	// Predicate selects changes to quads with this predicate IRI.
+	Predicate string `json:"predicate,omitempty"`
+}
+
+// QuadPattern selects quads term by term. An empty term matches anything;
+// the others must equal the quad's term exactly, in N-Quads syntax.
+type QuadPattern struct {
+	Subject   string `json:"subject,omitempty"`
+	Predicate string `json:"predicate,omitempty"`
+	Object    string `json:"object,omitempty"`
+	Graph     string `json:"graph,omitempty"`
+}
+
+// Format is a line-oriented serialization Store.ExportGraph writes, named
+// by its media type.
+type Format string
+
+const (
+	// FormatNQuads writes one quad per line, with the graph term of every
+	// graph but the default one.
+	FormatNQuads Format = "application/n-quads"
+	// FormatNTriples writes one triple per line, without graph terms.
+	FormatNTriples Format = "application/n-triples"
+)
+
 // BlameResult associates a single quad with the commit that last introduced it.
 // This is used for streaming the results of a blame operation.
 type BlameResult struct {
@@ -83,6 +171,7 @@ type BlameResult struct {
 type Conflict struct {
 	Type        string   `json:"type"`        // e.g., "SEMANTIC_CONFLICT_FUNCTIONAL_PROPERTY"
 	Description string   `json:"description"` // A human-readable explanation of the conflict.
+	Graph       string   `json:"graph,omitempty"` // The graph the conflicting quads are in.
 	Conflicting []string `json:"conflicting_quads"` // The string representations of the conflicting quads.
 }
 
@@ -0,0 +1,445 @@
+package rdfio
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+)
+
+// WriteJSONLD writes quads as a JSON-LD document in expanded form: an array
+// of node objects, with each named graph's nodes wrapped in a graph object.
+func WriteJSONLD(w io.Writer, quads []quadstore.Quad) error {
+	byGraph := make(map[string][]quadstore.Quad)
+	for _, q := range quads {
+		byGraph[q.Graph] = append(byGraph[q.Graph], q)
+	}
+	graphs := make([]string, 0, len(byGraph))
+	for g := range byGraph {
+		graphs = append(graphs, g)
+	}
+	sort.Strings(graphs)
+	doc := []interface{}{}
+	for _, g := range graphs {
+		nodes, err := jsonldNodes(byGraph[g])
+		if err != nil {
+			return err
+		}
+		if g == "" {
+			doc = append(doc, nodes...)
+			continue
+		}
+		doc = append(doc, map[string]interface{}{"@id": jsonldID(g), "@graph": nodes})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(doc)
+}
+
+// jsonldNodes groups triples into node objects, one per subject.
+func jsonldNodes(quads []quadstore.Quad) ([]interface{}, error) {
+	nodes := make(map[string]map[string]interface{})
+	var order []string
+	for _, q := range quads {
+		node, ok := nodes[q.Subject]
+		if !ok {
+			node = map[string]interface{}{"@id": jsonldID(q.Subject)}
+			nodes[q.Subject] = node
+			order = append(order, q.Subject)
+		}
+		if q.Predicate == rdfType && !strings.HasPrefix(q.Object, `"`) {
+			types, _ := node["@type"].([]string)
+			node["@type"] = append(types, jsonldID(q.Object))
+			continue
+		}
+		value, err := jsonldValue(q.Object)
+		if err != nil {
+			return nil, err
+		}
+		key := jsonldID(q.Predicate)
+		values, _ := node[key].([]interface{})
+		node[key] = append(values, value)
+	}
+	sort.Strings(order)
+	out := make([]interface{}, len(order))
+	for i, s := range order {
+		out[i] = nodes[s]
+	}
+	return out, nil
+}
+
+// jsonldID renders an IRI or blank node term as a JSON-LD identifier.
+func jsonldID(term string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(term, "<"), ">")
+}
+
+func jsonldValue(term string) (interface{}, error) {
+	if !strings.HasPrefix(term, `"`) {
+		return map[string]interface{}{"@id": jsonldID(term)}, nil
+	}
+	lexical, lang, datatype, err := LiteralParts(term)
+	if err != nil {
+		return nil, err
+	}
+	value := map[string]interface{}{"@value": lexical}
+	if lang != "" {
+		value["@language"] = lang
+	} else if datatype != "" && datatype != xsdNS+"string" {
+		value["@type"] = datatype
+	}
+	return value, nil
+}
+
+// ParseJSONLD reads a JSON-LD document into quads. It handles documents in
+// expanded form, and compacted documents whose @context is inline and maps
+// terms to IRIs, optionally with @vocab and "@type" coercion. Remote
+// contexts, @reverse, containers and framing are not supported and are
+// reported as errors rather than dropped.
+func ParseJSONLD(r io.Reader) ([]quadstore.Quad, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	p := &jsonldParser{}
+	if err := p.top(doc, jsonldContext{}); err != nil {
+		return nil, err
+	}
+	return p.quads, nil
+}
+
+// A jsonldTerm is one entry of an inline context.
+type jsonldTerm struct {
+	iri    string
+	coerce string // "@id", a datatype IRI, or ""
+}
+
+type jsonldContext struct {
+	vocab string
+	terms map[string]jsonldTerm
+}
+
+type jsonldParser struct {
+	graph  string
+	blanks int
+	quads  []quadstore.Quad
+}
+
+func (p *jsonldParser) top(doc interface{}, ctx jsonldContext) error {
+	switch v := doc.(type) {
+	case []interface{}:
+		for _, item := range v {
+			if err := p.top(item, ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		ctx, err := ctx.extend(v["@context"])
+		if err != nil {
+			return err
+		}
+		graph, hasGraph := v["@graph"]
+		if !hasGraph {
+			_, err := p.node(v, ctx)
+			return err
+		}
+		if id, ok := v["@id"]; ok {
+			name, err := ctx.id(id)
+			if err != nil {
+				return err
+			}
+			outer := p.graph
+			p.graph = name
+			defer func() { p.graph = outer }()
+		}
+		items, ok := graph.([]interface{})
+		if !ok {
+			items = []interface{}{graph}
+		}
+		for _, item := range items {
+			obj, ok := item.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("@graph must hold node objects")
+			}
+			if _, err := p.node(obj, ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("a JSON-LD document must be an object or an array")
+}
+
+// node emits the triples of a node object and returns its identifier.
+func (p *jsonldParser) node(obj map[string]interface{}, ctx jsonldContext) (string, error) {
+	ctx, err := ctx.extend(obj["@context"])
+	if err != nil {
+		return "", err
+	}
+	subject := ""
+	if id, ok := obj["@id"]; ok {
+		if subject, err = ctx.id(id); err != nil {
+			return "", err
+		}
+	} else {
+		p.blanks++
+		subject = fmt.Sprintf("_:b%d", p.blanks)
+	}
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := obj[key]
+		switch key {
+		case "@id", "@context":
+			continue
+		case "@type":
+			types, ok := value.([]interface{})
+			if !ok {
+				types = []interface{}{value}
+			}
+			for _, t := range types {
+				typ, err := ctx.vocabIRI(t)
+				if err != nil {
+					return "", err
+				}
+				p.emit(subject, rdfType, typ)
+			}
+			continue
+		}
+		if strings.HasPrefix(key, "@") {
+			return "", fmt.Errorf("unsupported keyword %s", key)
+		}
+		pred, term, err := ctx.property(key)
+		if err != nil {
+			return "", err
+		}
+		values, ok := value.([]interface{})
+		if !ok {
+			values = []interface{}{value}
+		}
+		for _, v := range values {
+			obj, err := p.value(v, ctx, term.coerce)
+			if err != nil {
+				return "", fmt.Errorf("%s: %v", key, err)
+			}
+			if obj != "" {
+				p.emit(subject, pred, obj)
+			}
+		}
+	}
+	return subject, nil
+}
+
+// value converts a property value to a term; null yields "".
+func (p *jsonldParser) value(v interface{}, ctx jsonldContext, coerce string) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		switch coerce {
+		case "@id":
+			return ctx.id(v)
+		case "":
+			return QuoteString(v), nil
+		}
+		return QuoteString(v) + "^^<" + coerce + ">", nil
+	case bool:
+		return `"` + strconv.FormatBool(v) + `"^^<` + xsdNS + `boolean>`, nil
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return `"` + v.String() + `"^^<` + xsdNS + `integer>`, nil
+		}
+		f, _ := v.Float64()
+		return `"` + strconv.FormatFloat(f, 'E', -1, 64) + `"^^<` + xsdNS + `double>`, nil
+	case map[string]interface{}:
+		if lit, ok := v["@value"]; ok {
+			return p.literal(v, lit, ctx)
+		}
+		if list, ok := v["@list"]; ok {
+			items, _ := list.([]interface{})
+			return p.list(items, ctx, coerce)
+		}
+		if _, ok := v["@set"]; ok {
+			return "", fmt.Errorf("@set is not supported")
+		}
+		return p.node(v, ctx)
+	}
+	return "", fmt.Errorf("unsupported value %v", v)
+}
+
+func (p *jsonldParser) literal(obj map[string]interface{}, lit interface{}, ctx jsonldContext) (string, error) {
+	var lexical string
+	switch l := lit.(type) {
+	case string:
+		lexical = l
+	case json.Number:
+		lexical = l.String()
+	case bool:
+		lexical = strconv.FormatBool(l)
+	default:
+		return "", fmt.Errorf("invalid @value %v", lit)
+	}
+	term := QuoteString(lexical)
+	if lang, ok := obj["@language"].(string); ok {
+		return term + "@" + lang, nil
+	}
+	if t, ok := obj["@type"]; ok {
+		dt, err := ctx.vocabIRI(t)
+		if err != nil {
+			return "", err
+		}
+		return term + "^^" + dt, nil
+	}
+	return term, nil
+}
+
+// list emits an RDF collection and returns its head.
+func (p *jsonldParser) list(items []interface{}, ctx jsonldContext, coerce string) (string, error) {
+	head, prev := rdfNil, ""
+	for _, item := range items {
+		obj, err := p.value(item, ctx, coerce)
+		if err != nil {
+			return "", err
+		}
+		p.blanks++
+		node := fmt.Sprintf("_:b%d", p.blanks)
+		if prev == "" {
+			head = node
+		} else {
+			p.emit(prev, rdfRest, node)
+		}
+		p.emit(node, rdfFirst, obj)
+		prev = node
+	}
+	if prev != "" {
+		p.emit(prev, rdfRest, rdfNil)
+	}
+	return head, nil
+}
+
+func (p *jsonldParser) emit(s, pred, o string) {
+	p.quads = append(p.quads, quadstore.Quad{Subject: s, Predicate: pred, Object: o, Graph: p.graph})
+}
+
+// extend returns the context with an inline @context applied.
+func (c jsonldContext) extend(raw interface{}) (jsonldContext, error) {
+	if raw == nil {
+		return c, nil
+	}
+	defs, ok := raw.(map[string]interface{})
+	if !ok {
+		return c, fmt.Errorf("only inline @context objects are supported")
+	}
+	out := jsonldContext{vocab: c.vocab, terms: make(map[string]jsonldTerm, len(c.terms)+len(defs))}
+	for k, v := range c.terms {
+		out.terms[k] = v
+	}
+	for key, def := range defs {
+		switch d := def.(type) {
+		case string:
+			if key == "@vocab" {
+				out.vocab = d
+				continue
+			}
+			if strings.HasPrefix(key, "@") {
+				return c, fmt.Errorf("unsupported context keyword %s", key)
+			}
+			out.terms[key] = jsonldTerm{iri: d}
+		case map[string]interface{}:
+			var term jsonldTerm
+			for k, v := range d {
+				s, ok := v.(string)
+				if !ok {
+					return c, fmt.Errorf("context entry %s: %s must be a string", key, k)
+				}
+				switch k {
+				case "@id":
+					term.iri = s
+				case "@type":
+					term.coerce = s
+				default:
+					return c, fmt.Errorf("context entry %s: %s is not supported", key, k)
+				}
+			}
+			if term.iri == "" {
+				term.iri = key
+			}
+			out.terms[key] = term
+		default:
+			return c, fmt.Errorf("context entry %s is not supported", key)
+		}
+	}
+	// Expand compact IRIs within definitions now that all prefixes are known.
+	for key, term := range out.terms {
+		term.iri = out.expand(term.iri)
+		if term.coerce != "" && term.coerce != "@id" {
+			term.coerce = out.expand(term.coerce)
+		}
+		out.terms[key] = term
+	}
+	return out, nil
+}
+
+// expand resolves a term or compact IRI against the context.
+func (c jsonldContext) expand(s string) string {
+	if term, ok := c.terms[s]; ok && term.iri != s {
+		return term.iri
+	}
+	if i := strings.Index(s, ":"); i > 0 && !strings.HasPrefix(s[i:], "://") {
+		if term, ok := c.terms[s[:i]]; ok {
+			return term.iri + s[i+1:]
+		}
+	}
+	return s
+}
+
+// property returns the predicate term for a key, and its definition.
+func (c jsonldContext) property(key string) (string, jsonldTerm, error) {
+	term := c.terms[key]
+	iri := c.expand(key)
+	if !strings.Contains(iri, ":") {
+		if c.vocab == "" {
+			return "", term, fmt.Errorf("property %q is not defined by the @context", key)
+		}
+		iri = c.vocab + iri
+	}
+	return "<" + iri + ">", term, nil
+}
+
+// id converts an @id value to an IRI or blank node term.
+func (c jsonldContext) id(v interface{}) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("@id must be a string")
+	}
+	if strings.HasPrefix(s, "_:") {
+		return s, nil
+	}
+	return "<" + c.expand(s) + ">", nil
+}
+
+// vocabIRI converts a @type value, which may be a vocabulary term.
+func (c jsonldContext) vocabIRI(v interface{}) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("@type must be a string")
+	}
+	if strings.HasPrefix(s, "_:") {
+		return s, nil
+	}
+	iri := c.expand(s)
+	if !strings.Contains(iri, ":") && c.vocab != "" {
+		iri = c.vocab + iri
+	}
+	return "<" + iri + ">", nil
+}
@@ -0,0 +1,324 @@
+// Package rdfio parses and serializes N-Quads and N-Triples with the same
+// semantics as the quad-db command line, so that programs embedding
+// go-quadgit read and write statements exactly as the CLI does. It also
+// converts to and from Turtle, TriG and JSON-LD, for clients that need
+// those serializations.
+//
+// Terms are kept in their N-Quads surface form, e.g. `<http://ex.org/a>`,
+// `_:b0` or `"chat"@fr`. A statement without a graph term yields a Quad
+// with an empty Graph.
+package rdfio
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+)
+
+// Mode selects how closely input must follow the N-Quads grammar.
+type Mode int
+
+const (
+	// Lenient accepts anything that splits unambiguously into terms, as the
+	// CLI does: IRIs may be relative or contain spaces, escapes are not
+	// checked, and any kind of term may appear in any position.
+	Lenient Mode = iota
+	// Strict additionally enforces the W3C N-Quads grammar: absolute IRIs
+	// without forbidden characters, well-formed blank node labels, escapes
+	// and language tags, and the term kinds allowed in each position.
+	Strict
+)
+
+// String returns "lenient" or "strict".
+func (m Mode) String() string {
+	if m == Strict {
+		return "strict"
+	}
+	return "lenient"
+}
+
+// ParseQuad parses a single N-Quads (or N-Triples) statement. The line must
+// not be blank or a comment; Reader skips those.
+func ParseQuad(line string, mode Mode) (quadstore.Quad, error) {
+	var terms []string
+	rest := strings.TrimSpace(line)
+	for rest != "" {
+		if rest[0] == '.' {
+			rest = strings.TrimSpace(rest[1:])
+			if rest != "" && !strings.HasPrefix(rest, "#") {
+				return quadstore.Quad{}, fmt.Errorf("unexpected content after '.': %q", rest)
+			}
+			if len(terms) < 3 || len(terms) > 4 {
+				return quadstore.Quad{}, fmt.Errorf("expected 3 or 4 terms, found %d", len(terms))
+			}
+			q := quadstore.Quad{Subject: terms[0], Predicate: terms[1], Object: terms[2]}
+			if len(terms) == 4 {
+				q.Graph = terms[3]
+			}
+			if mode == Strict {
+				if err := Validate(q); err != nil {
+					return quadstore.Quad{}, err
+				}
+			}
+			return q, nil
+		}
+		term, n, err := ScanTerm(rest)
+		if err != nil {
+			return quadstore.Quad{}, err
+		}
+		terms = append(terms, term)
+		rest = strings.TrimSpace(rest[n:])
+	}
+	return quadstore.Quad{}, fmt.Errorf("statement is not terminated with '.'")
+}
+
+// ScanTerm reads one RDF term from the start of s and returns it together
+// with the number of bytes consumed. A blank node label must be followed by
+// whitespace.
+func ScanTerm(s string) (string, int, error) {
+	switch {
+	case s == "":
+		return "", 0, fmt.Errorf("missing term")
+	case s[0] == '<':
+		end := strings.IndexByte(s, '>')
+		if end < 0 {
+			return "", 0, fmt.Errorf("unterminated IRI: %q", s)
+		}
+		return s[:end+1], end + 1, nil
+	case strings.HasPrefix(s, "_:"):
+		end := strings.IndexFunc(s, unicode.IsSpace)
+		if end < 0 {
+			return "", 0, fmt.Errorf("blank node is not followed by whitespace: %q", s)
+		}
+		return s[:end], end, nil
+	case s[0] == '"':
+		end := 1
+		for ; end < len(s); end++ {
+			if s[end] == '\\' {
+				end++
+				continue
+			}
+			if s[end] == '"' {
+				break
+			}
+		}
+		if end >= len(s) {
+			return "", 0, fmt.Errorf("unterminated literal: %q", s)
+		}
+		end++
+		if strings.HasPrefix(s[end:], "^^") {
+			if !strings.HasPrefix(s[end+2:], "<") {
+				return "", 0, fmt.Errorf("datatype must be an IRI: %q", s)
+			}
+			dt, n, err := ScanTerm(s[end+2:])
+			if err != nil {
+				return "", 0, err
+			}
+			return s[:end+2] + dt, end + 2 + n, nil
+		}
+		if end < len(s) && s[end] == '@' {
+			tag := end + 1
+			for tag < len(s) && (s[tag] == '-' || unicode.IsLetter(rune(s[tag])) || unicode.IsDigit(rune(s[tag]))) {
+				tag++
+			}
+			if tag == end+1 {
+				return "", 0, fmt.Errorf("empty language tag: %q", s)
+			}
+			return s[:tag], tag, nil
+		}
+		return s[:end], end, nil
+	}
+	return "", 0, fmt.Errorf("unexpected term: %q", s)
+}
+
+// FormatQuad renders a quad as a single N-Quads statement, without a
+// trailing newline.
+func FormatQuad(q quadstore.Quad) string {
+	if q.Graph == "" {
+		return fmt.Sprintf("%s %s %s .", q.Subject, q.Predicate, q.Object)
+	}
+	return fmt.Sprintf("%s %s %s %s .", q.Subject, q.Predicate, q.Object, q.Graph)
+}
+
+// Validate reports whether q satisfies the N-Quads grammar, as Strict mode
+// requires: the subject is an IRI or blank node, the predicate an IRI, the
+// object any term and the graph, if present, an IRI or blank node.
+func Validate(q quadstore.Quad) error {
+	check := func(position, term string, literal bool) error {
+		var err error
+		switch {
+		case strings.HasPrefix(term, "<"):
+			err = validIRI(term)
+		case strings.HasPrefix(term, "_:"):
+			if position == "predicate" {
+				return fmt.Errorf("predicate must be an IRI: %s", term)
+			}
+			err = validBlankNode(term)
+		case strings.HasPrefix(term, `"`) && literal:
+			err = validLiteral(term)
+		default:
+			return fmt.Errorf("%s cannot be %s", position, describe(term))
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", position, err)
+		}
+		return nil
+	}
+	if err := check("subject", q.Subject, false); err != nil {
+		return err
+	}
+	if err := check("predicate", q.Predicate, false); err != nil {
+		return err
+	}
+	if err := check("object", q.Object, true); err != nil {
+		return err
+	}
+	if q.Graph != "" {
+		return check("graph", q.Graph, false)
+	}
+	return nil
+}
+
+func describe(term string) string {
+	if strings.HasPrefix(term, `"`) {
+		return "a literal: " + term
+	}
+	return fmt.Sprintf("%q", term)
+}
+
+// validIRI checks an IRIREF: an absolute IRI without spaces or the
+// characters <>"{}|^`\ other than in \u escapes.
+func validIRI(term string) error {
+	iri := strings.TrimSuffix(strings.TrimPrefix(term, "<"), ">")
+	if len(iri) != len(term)-2 {
+		return fmt.Errorf("malformed IRI %s", term)
+	}
+	for i := 0; i < len(iri); i++ {
+		c := iri[i]
+		if c == '\\' {
+			n, err := unicodeEscape(iri[i:])
+			if err != nil {
+				return fmt.Errorf("IRI %s: %v", term, err)
+			}
+			i += n - 1
+			continue
+		}
+		if c <= 0x20 || strings.IndexByte("<>\"{}|^`", c) >= 0 {
+			return fmt.Errorf("IRI %s contains %q", term, c)
+		}
+	}
+	colon := strings.IndexByte(iri, ':')
+	if colon < 1 || !isScheme(iri[:colon]) {
+		return fmt.Errorf("IRI %s is not absolute", term)
+	}
+	return nil
+}
+
+func isScheme(s string) bool {
+	for i, r := range s {
+		letter := r < unicode.MaxASCII && unicode.IsLetter(r)
+		if !letter && (i == 0 || !(r >= '0' && r <= '9' || r == '+' || r == '-' || r == '.')) {
+			return false
+		}
+	}
+	return true
+}
+
+// validBlankNode checks a BLANK_NODE_LABEL: letters, digits, '_', '-' and
+// '.', not ending with '.' and not starting with '-' or '.'.
+func validBlankNode(term string) error {
+	label := strings.TrimPrefix(term, "_:")
+	if label == "" {
+		return fmt.Errorf("empty blank node label")
+	}
+	for i, r := range label {
+		ok := unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+		if i > 0 {
+			ok = ok || r == '-' || r == '.'
+		}
+		if !ok {
+			return fmt.Errorf("blank node %s contains %q", term, r)
+		}
+	}
+	if strings.HasSuffix(label, ".") {
+		return fmt.Errorf("blank node %s ends with '.'", term)
+	}
+	return nil
+}
+
+// validLiteral checks a literal's escapes and its datatype IRI or language
+// tag.
+func validLiteral(term string) error {
+	end := 1
+	for ; end < len(term) && term[end] != '"'; end++ {
+		switch term[end] {
+		case '\\':
+			if end+1 < len(term) && strings.IndexByte(`tbnrf"'\`, term[end+1]) >= 0 {
+				end++
+				continue
+			}
+			n, err := unicodeEscape(term[end:])
+			if err != nil {
+				return fmt.Errorf("literal %s: %v", term, err)
+			}
+			end += n - 1
+		case '\n', '\r':
+			return fmt.Errorf("literal %s contains an unescaped line break", term)
+		}
+	}
+	if end >= len(term) {
+		return fmt.Errorf("unterminated literal %s", term)
+	}
+	suffix := term[end+1:]
+	switch {
+	case suffix == "":
+		return nil
+	case strings.HasPrefix(suffix, "^^"):
+		return validIRI(suffix[2:])
+	case strings.HasPrefix(suffix, "@"):
+		return validLangTag(suffix[1:])
+	}
+	return fmt.Errorf("malformed literal %s", term)
+}
+
+// validLangTag checks a LANGTAG: letters, then '-'-separated letters and
+// digits.
+func validLangTag(tag string) error {
+	for i, part := range strings.Split(tag, "-") {
+		if part == "" {
+			return fmt.Errorf("malformed language tag %q", tag)
+		}
+		for _, r := range part {
+			letter := r < unicode.MaxASCII && unicode.IsLetter(r)
+			if !letter && (i == 0 || r < '0' || r > '9') {
+				return fmt.Errorf("malformed language tag %q", tag)
+			}
+		}
+	}
+	return nil
+}
+
+// unicodeEscape checks a \uXXXX or \UXXXXXXXX escape at the start of s and
+// returns its length.
+func unicodeEscape(s string) (int, error) {
+	n := 0
+	switch {
+	case strings.HasPrefix(s, `\u`):
+		n = 6
+	case strings.HasPrefix(s, `\U`):
+		n = 10
+	default:
+		return 0, fmt.Errorf("invalid escape %q", s[:min(len(s), 2)])
+	}
+	if len(s) < n {
+		return 0, fmt.Errorf("truncated escape %q", s)
+	}
+	for _, c := range s[2:n] {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return 0, fmt.Errorf("invalid escape %q", s[:n])
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,149 @@
+package rdfio
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+)
+
+func TestParseQuad(t *testing.T) {
+	tests := []struct {
+		name, line string
+		want       quadstore.Quad
+		lenientErr bool // Rejected in both modes
+		strictErr  bool // Rejected in Strict mode only
+	}{
+		{name: "triple", line: `<http://ex.org/s> <http://ex.org/p> "o" .`,
+			want: quadstore.Quad{Subject: "<http://ex.org/s>", Predicate: "<http://ex.org/p>", Object: `"o"`}},
+		{name: "quad", line: `_:b0 <http://ex.org/p> "chat"@fr <http://ex.org/g> .`,
+			want: quadstore.Quad{Subject: "_:b0", Predicate: "<http://ex.org/p>", Object: `"chat"@fr`, Graph: "<http://ex.org/g>"}},
+		{name: "typed literal", line: `<http://ex.org/s> <http://ex.org/p> "1"^^<http://www.w3.org/2001/XMLSchema#integer> .`,
+			want: quadstore.Quad{Subject: "<http://ex.org/s>", Predicate: "<http://ex.org/p>", Object: `"1"^^<http://www.w3.org/2001/XMLSchema#integer>`}},
+		{name: "escaped quote", line: `<http://ex.org/s> <http://ex.org/p> "a \"b\"" . # comment`,
+			want: quadstore.Quad{Subject: "<http://ex.org/s>", Predicate: "<http://ex.org/p>", Object: `"a \"b\""`}},
+		{name: "relative IRI", line: `<s> <p> <o> .`,
+			want: quadstore.Quad{Subject: "<s>", Predicate: "<p>", Object: "<o>"}, strictErr: true},
+		{name: "literal subject", line: `"s" <http://ex.org/p> "o" .`,
+			want: quadstore.Quad{Subject: `"s"`, Predicate: "<http://ex.org/p>", Object: `"o"`}, strictErr: true},
+		{name: "blank predicate", line: `<http://ex.org/s> _:p "o" .`,
+			want: quadstore.Quad{Subject: "<http://ex.org/s>", Predicate: "_:p", Object: `"o"`}, strictErr: true},
+		{name: "space in IRI", line: `<http://ex.org/a b> <http://ex.org/p> "o" .`,
+			want: quadstore.Quad{Subject: "<http://ex.org/a b>", Predicate: "<http://ex.org/p>", Object: `"o"`}, strictErr: true},
+		{name: "unterminated", line: `<http://ex.org/s> <http://ex.org/p> "o"`, lenientErr: true},
+		{name: "too few terms", line: `<http://ex.org/s> <http://ex.org/p> .`, lenientErr: true},
+		{name: "unterminated literal", line: `<http://ex.org/s> <http://ex.org/p> "o .`, lenientErr: true},
+		{name: "trailing content", line: `<http://ex.org/s> <http://ex.org/p> "o" . <x>`, lenientErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, mode := range []Mode{Lenient, Strict} {
+				q, err := ParseQuad(tt.line, mode)
+				wantErr := tt.lenientErr || tt.strictErr && mode == Strict
+				if (err != nil) != wantErr {
+					t.Fatalf("%v: ParseQuad = %v, want error %v", mode, err, wantErr)
+				}
+				if err == nil && q != tt.want {
+					t.Errorf("%v: ParseQuad = %+v, want %+v", mode, q, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestReader(t *testing.T) {
+	input := `# a comment
+<http://ex.org/s> <http://ex.org/p> "one" .
+
+<http://ex.org/s> <http://ex.org/p> "two" <http://ex.org/g> .
+not a statement
+<s> <p> "three" .
+`
+	for _, tt := range []struct {
+		mode    Mode
+		objects []string
+		errors  []int // Lines of parse errors
+	}{
+		{Lenient, []string{`"one"`, `"two"`, `"three"`}, []int{5}},
+		{Strict, []string{`"one"`, `"two"`}, []int{5, 6}},
+	} {
+		t.Run(tt.mode.String(), func(t *testing.T) {
+			r := NewReader(strings.NewReader(input))
+			r.Mode = tt.mode
+			r.DefaultGraph = "<http://ex.org/default>"
+			var objects []string
+			var lines []int
+			for {
+				q, err := r.Read()
+				if err == io.EOF {
+					break
+				}
+				var perr *ParseError
+				if errors.As(err, &perr) {
+					lines = append(lines, perr.Line)
+					continue
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				objects = append(objects, q.Object)
+				want := r.DefaultGraph
+				if q.Object == `"two"` {
+					want = "<http://ex.org/g>"
+				}
+				if q.Graph != want {
+					t.Errorf("%s is in graph %s, want %s", q.Object, q.Graph, want)
+				}
+			}
+			if strings.Join(objects, " ") != strings.Join(tt.objects, " ") {
+				t.Errorf("read %v, want %v", objects, tt.objects)
+			}
+			if fmt.Sprint(lines) != fmt.Sprint(tt.errors) {
+				t.Errorf("parse errors on lines %v, want %v", lines, tt.errors)
+			}
+		})
+	}
+}
+
+func TestWriter(t *testing.T) {
+	quads := []quadstore.Quad{
+		{Subject: "<http://ex.org/s>", Predicate: "<http://ex.org/p>", Object: `"o"@en`},
+		{Subject: "_:b0", Predicate: "<http://ex.org/p>", Object: "<http://ex.org/o>", Graph: "<http://ex.org/g>"},
+	}
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Mode = Strict
+	for _, q := range quads {
+		if err := w.Write(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Write(quadstore.Quad{Subject: `"s"`, Predicate: "<http://ex.org/p>", Object: `"o"`}); err == nil {
+		t.Error("strict Writer wrote a literal subject")
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewReader(&buf)
+	r.Mode = Strict
+	for i := 0; ; i++ {
+		q, err := r.Read()
+		if err == io.EOF {
+			if i != len(quads) {
+				t.Errorf("read back %d quads, want %d", i, len(quads))
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i >= len(quads) || q != quads[i] {
+			t.Errorf("quad %d read back as %+v", i, q)
+		}
+	}
+}
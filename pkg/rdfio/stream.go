@@ -0,0 +1,106 @@
+package rdfio
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+)
+
+// MaxLineLength is the longest statement a Reader accepts.
+const MaxLineLength = 16 * 1024 * 1024
+
+// ParseError reports a statement that could not be parsed, with its
+// 1-based line number.
+type ParseError struct {
+	Line int
+	Err  error
+}
+
+func (e *ParseError) Error() string { return fmt.Sprintf("line %d: %v", e.Line, e.Err) }
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// Reader reads quads from an N-Quads or N-Triples stream one statement at a
+// time, skipping blank lines and comments.
+type Reader struct {
+	// Mode selects lenient or strict parsing. The default is Lenient.
+	Mode Mode
+	// DefaultGraph, if set, is assigned to statements without a graph term.
+	DefaultGraph string
+
+	scanner *bufio.Scanner
+	line    int
+}
+
+// NewReader returns a Reader that parses r in Lenient mode.
+func NewReader(r io.Reader) *Reader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), MaxLineLength)
+	return &Reader{scanner: scanner}
+}
+
+// Read returns the next quad. At the end of the input it returns io.EOF;
+// statements that do not parse are reported as a *ParseError, after which
+// reading may continue with the next line.
+func (r *Reader) Read() (quadstore.Quad, error) {
+	for r.scanner.Scan() {
+		r.line++
+		line := strings.TrimSpace(r.scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		q, err := ParseQuad(line, r.Mode)
+		if err != nil {
+			return quadstore.Quad{}, &ParseError{Line: r.line, Err: err}
+		}
+		if q.Graph == "" {
+			q.Graph = r.DefaultGraph
+		}
+		return q, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return quadstore.Quad{}, err
+	}
+	return quadstore.Quad{}, io.EOF
+}
+
+// Line returns the line number of the statement last read.
+func (r *Reader) Line() int {
+	return r.line
+}
+
+// Writer writes quads as N-Quads, one statement per line. Output is
+// buffered; call Flush when done.
+type Writer struct {
+	// Mode selects whether quads are validated before they are written. In
+	// Strict mode, Write rejects quads that a strict Reader would not accept.
+	Mode Mode
+
+	w *bufio.Writer
+}
+
+// NewWriter returns a Writer that writes to w in Lenient mode.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// Write writes one quad.
+func (w *Writer) Write(q quadstore.Quad) error {
+	if w.Mode == Strict {
+		if err := Validate(q); err != nil {
+			return err
+		}
+	}
+	if _, err := w.w.WriteString(FormatQuad(q)); err != nil {
+		return err
+	}
+	return w.w.WriteByte('\n')
+}
+
+// Flush writes any buffered data to the underlying writer.
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
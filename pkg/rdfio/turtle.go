@@ -0,0 +1,676 @@
+package rdfio
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+)
+
+const (
+	rdfType  = "<http://www.w3.org/1999/02/22-rdf-syntax-ns#type>"
+	rdfFirst = "<http://www.w3.org/1999/02/22-rdf-syntax-ns#first>"
+	rdfRest  = "<http://www.w3.org/1999/02/22-rdf-syntax-ns#rest>"
+	rdfNil   = "<http://www.w3.org/1999/02/22-rdf-syntax-ns#nil>"
+	xsdNS    = "http://www.w3.org/2001/XMLSchema#"
+)
+
+// WriteTurtle writes the triples of quads as Turtle, grouped by subject and
+// predicate. Graph terms are ignored.
+func WriteTurtle(w io.Writer, quads []quadstore.Quad) error {
+	bw := bufio.NewWriter(w)
+	writeTriples(bw, quads, "")
+	return bw.Flush()
+}
+
+// WriteTriG writes quads as TriG: the default graph's triples first, then
+// one block per named graph, in graph order.
+func WriteTriG(w io.Writer, quads []quadstore.Quad) error {
+	byGraph := make(map[string][]quadstore.Quad)
+	for _, q := range quads {
+		byGraph[q.Graph] = append(byGraph[q.Graph], q)
+	}
+	graphs := make([]string, 0, len(byGraph))
+	for g := range byGraph {
+		graphs = append(graphs, g)
+	}
+	sort.Strings(graphs) // "" sorts first
+	bw := bufio.NewWriter(w)
+	for i, g := range graphs {
+		if i > 0 {
+			bw.WriteString("\n")
+		}
+		if g == "" {
+			writeTriples(bw, byGraph[g], "")
+			continue
+		}
+		fmt.Fprintf(bw, "%s {\n", g)
+		writeTriples(bw, byGraph[g], "    ")
+		bw.WriteString("}\n")
+	}
+	return bw.Flush()
+}
+
+// writeTriples writes triples with shared subjects and predicates folded
+// into ';' and ',' lists.
+func writeTriples(w *bufio.Writer, quads []quadstore.Quad, indent string) {
+	sorted := append([]quadstore.Quad(nil), quads...)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Subject != b.Subject {
+			return a.Subject < b.Subject
+		}
+		if a.Predicate != b.Predicate {
+			return a.Predicate < b.Predicate
+		}
+		return a.Object < b.Object
+	})
+	for i, q := range sorted {
+		pred := q.Predicate
+		if pred == rdfType {
+			pred = "a"
+		}
+		switch {
+		case i == 0 || q.Subject != sorted[i-1].Subject:
+			fmt.Fprintf(w, "%s%s %s %s", indent, q.Subject, pred, q.Object)
+		case q.Predicate != sorted[i-1].Predicate:
+			fmt.Fprintf(w, " ;\n%s    %s %s", indent, pred, q.Object)
+		default:
+			fmt.Fprintf(w, ", %s", q.Object)
+		}
+		if i == len(sorted)-1 || sorted[i+1].Subject != q.Subject {
+			w.WriteString(" .\n")
+		}
+	}
+}
+
+// ParseTurtle parses a Turtle document into triples, resolving relative
+// IRIs against base, which may be empty. Blank nodes written as [] or in
+// collections get fresh labels.
+func ParseTurtle(r io.Reader, base string) ([]quadstore.Quad, error) {
+	return parseTurtle(r, base, false)
+}
+
+// ParseTriG parses a TriG document into quads; triples outside a graph
+// block are in the default graph.
+func ParseTriG(r io.Reader, base string) ([]quadstore.Quad, error) {
+	return parseTurtle(r, base, true)
+}
+
+func parseTurtle(r io.Reader, base string, trig bool) ([]quadstore.Quad, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &turtleParser{src: string(data), line: 1, base: base, prefixes: map[string]string{}, trig: trig}
+	if err := p.document(); err != nil {
+		return nil, &ParseError{Line: p.line, Err: err}
+	}
+	return p.quads, nil
+}
+
+type turtleParser struct {
+	src      string
+	pos      int
+	line     int
+	base     string
+	prefixes map[string]string
+	trig     bool
+	graph    string
+	blanks   int
+	quads    []quadstore.Quad
+}
+
+// skip moves past whitespace and comments.
+func (p *turtleParser) skip() {
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; {
+		case c == '\n':
+			p.line++
+			p.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *turtleParser) peek() byte {
+	p.skip()
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *turtleParser) expect(c byte) error {
+	if p.peek() != c {
+		return fmt.Errorf("expected %q, found %s", c, p.context())
+	}
+	p.pos++
+	return nil
+}
+
+// context describes the input at the current position for error messages.
+func (p *turtleParser) context() string {
+	if p.pos >= len(p.src) {
+		return "end of input"
+	}
+	end := p.pos + 20
+	if end > len(p.src) {
+		end = len(p.src)
+	}
+	return strconv.Quote(p.src[p.pos:end])
+}
+
+// keyword reports whether the input continues with the case-insensitive
+// word kw followed by a non-name character, and consumes it if so.
+func (p *turtleParser) keyword(kw string) bool {
+	p.skip()
+	end := p.pos + len(kw)
+	if end > len(p.src) || !strings.EqualFold(p.src[p.pos:end], kw) {
+		return false
+	}
+	if end < len(p.src) && isNameChar(rune(p.src[end])) {
+		return false
+	}
+	p.pos = end
+	return true
+}
+
+func (p *turtleParser) document() error {
+	for p.peek() != 0 {
+		if err := p.statement(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *turtleParser) statement() error {
+	switch {
+	case p.keyword("@prefix"):
+		return p.prefix(true)
+	case p.keyword("PREFIX"):
+		return p.prefix(false)
+	case p.keyword("@base"):
+		return p.baseIRI(true)
+	case p.keyword("BASE"):
+		return p.baseIRI(false)
+	}
+	if p.trig {
+		if p.keyword("GRAPH") {
+			label, err := p.term()
+			if err != nil {
+				return err
+			}
+			return p.graphBlock(label)
+		}
+		if p.peek() == '{' {
+			return p.graphBlock("")
+		}
+	}
+	subject, err := p.subject()
+	if err != nil {
+		return err
+	}
+	if p.trig && p.peek() == '{' {
+		return p.graphBlock(subject)
+	}
+	if err := p.predicateObjects(subject); err != nil {
+		return err
+	}
+	return p.expect('.')
+}
+
+func (p *turtleParser) prefix(directive bool) error {
+	p.skip()
+	start := p.pos
+	for p.pos < len(p.src) && p.src[p.pos] != ':' && isNameChar(rune(p.src[p.pos])) {
+		p.pos++
+	}
+	name := p.src[start:p.pos]
+	if err := p.expect(':'); err != nil {
+		return err
+	}
+	p.skip()
+	iri, err := p.iriRef()
+	if err != nil {
+		return err
+	}
+	p.prefixes[name] = iri
+	if directive {
+		return p.expect('.')
+	}
+	return nil
+}
+
+func (p *turtleParser) baseIRI(directive bool) error {
+	p.skip()
+	iri, err := p.iriRef()
+	if err != nil {
+		return err
+	}
+	p.base = iri
+	if directive {
+		return p.expect('.')
+	}
+	return nil
+}
+
+func (p *turtleParser) graphBlock(label string) error {
+	if err := p.expect('{'); err != nil {
+		return err
+	}
+	p.graph = label
+	defer func() { p.graph = "" }()
+	for p.peek() != '}' {
+		if p.peek() == 0 {
+			return fmt.Errorf("unterminated graph block")
+		}
+		subject, err := p.subject()
+		if err != nil {
+			return err
+		}
+		if err := p.predicateObjects(subject); err != nil {
+			return err
+		}
+		if p.peek() == '.' {
+			p.pos++
+		} else if p.peek() != '}' {
+			return fmt.Errorf("expected '.' or '}', found %s", p.context())
+		}
+	}
+	p.pos++
+	return nil
+}
+
+func (p *turtleParser) emit(s, pred, o string) {
+	p.quads = append(p.quads, quadstore.Quad{Subject: s, Predicate: pred, Object: o, Graph: p.graph})
+}
+
+func (p *turtleParser) freshBlank() string {
+	p.blanks++
+	return fmt.Sprintf("_:genid%d", p.blanks)
+}
+
+func (p *turtleParser) subject() (string, error) {
+	switch p.peek() {
+	case '[':
+		return p.blankNodeList()
+	case '(':
+		return p.collection()
+	}
+	return p.term()
+}
+
+// predicateObjects parses predicate-object lists separated by ';'.
+func (p *turtleParser) predicateObjects(subject string) error {
+	for {
+		var pred string
+		if p.peek() == 'a' && p.pos+1 < len(p.src) && !isNameChar(rune(p.src[p.pos+1])) && p.src[p.pos+1] != ':' {
+			p.pos++
+			pred = rdfType
+		} else {
+			var err error
+			if pred, err = p.term(); err != nil {
+				return err
+			}
+		}
+		for {
+			obj, err := p.object()
+			if err != nil {
+				return err
+			}
+			p.emit(subject, pred, obj)
+			if p.peek() != ',' {
+				break
+			}
+			p.pos++
+		}
+		if p.peek() != ';' {
+			return nil
+		}
+		for p.peek() == ';' {
+			p.pos++
+		}
+		if c := p.peek(); c == '.' || c == ']' || c == '}' || c == 0 {
+			return nil
+		}
+	}
+}
+
+func (p *turtleParser) object() (string, error) {
+	switch c := p.peek(); {
+	case c == '[':
+		return p.blankNodeList()
+	case c == '(':
+		return p.collection()
+	case c == '"' || c == '\'':
+		return p.literal()
+	case c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9'):
+		return p.number()
+	}
+	if p.keyword("true") {
+		return `"true"^^<` + xsdNS + `boolean>`, nil
+	}
+	if p.keyword("false") {
+		return `"false"^^<` + xsdNS + `boolean>`, nil
+	}
+	return p.term()
+}
+
+func (p *turtleParser) blankNodeList() (string, error) {
+	p.pos++ // '['
+	node := p.freshBlank()
+	if p.peek() == ']' {
+		p.pos++
+		return node, nil
+	}
+	if err := p.predicateObjects(node); err != nil {
+		return "", err
+	}
+	return node, p.expect(']')
+}
+
+func (p *turtleParser) collection() (string, error) {
+	p.pos++ // '('
+	head, prev := rdfNil, ""
+	for p.peek() != ')' {
+		if p.peek() == 0 {
+			return "", fmt.Errorf("unterminated collection")
+		}
+		item, err := p.object()
+		if err != nil {
+			return "", err
+		}
+		node := p.freshBlank()
+		if prev == "" {
+			head = node
+		} else {
+			p.emit(prev, rdfRest, node)
+		}
+		p.emit(node, rdfFirst, item)
+		prev = node
+	}
+	p.pos++
+	if prev != "" {
+		p.emit(prev, rdfRest, rdfNil)
+	}
+	return head, nil
+}
+
+// term parses an IRI, prefixed name or blank node label.
+func (p *turtleParser) term() (string, error) {
+	switch c := p.peek(); {
+	case c == '<':
+		iri, err := p.iriRef()
+		if err != nil {
+			return "", err
+		}
+		return "<" + iri + ">", nil
+	case c == '_' && strings.HasPrefix(p.src[p.pos:], "_:"):
+		p.pos += 2
+		start := p.pos
+		for p.pos < len(p.src) && isNameChar(rune(p.src[p.pos])) {
+			p.pos++
+		}
+		for p.pos > start && p.src[p.pos-1] == '.' {
+			p.pos--
+		}
+		if p.pos == start {
+			return "", fmt.Errorf("empty blank node label")
+		}
+		return "_:" + p.src[start:p.pos], nil
+	}
+	return p.prefixedName()
+}
+
+// iriRef parses <...>, unescaping \u escapes and resolving it against the
+// base IRI.
+func (p *turtleParser) iriRef() (string, error) {
+	if p.pos >= len(p.src) || p.src[p.pos] != '<' {
+		return "", fmt.Errorf("expected IRI, found %s", p.context())
+	}
+	end := strings.IndexByte(p.src[p.pos:], '>')
+	if end < 0 {
+		return "", fmt.Errorf("unterminated IRI")
+	}
+	raw := p.src[p.pos+1 : p.pos+end]
+	p.pos += end + 1
+	iri, err := unescapeString(raw)
+	if err != nil {
+		return "", err
+	}
+	return p.resolve(iri), nil
+}
+
+func (p *turtleParser) resolve(iri string) string {
+	if p.base == "" {
+		return iri
+	}
+	ref, err := url.Parse(iri)
+	if err != nil || ref.IsAbs() {
+		return iri
+	}
+	base, err := url.Parse(p.base)
+	if err != nil {
+		return iri
+	}
+	return base.ResolveReference(ref).String()
+}
+
+func (p *turtleParser) prefixedName() (string, error) {
+	start := p.pos
+	for p.pos < len(p.src) && p.src[p.pos] != ':' && isNameChar(rune(p.src[p.pos])) {
+		p.pos++
+	}
+	if p.pos >= len(p.src) || p.src[p.pos] != ':' {
+		p.pos = start
+		return "", fmt.Errorf("expected a term, found %s", p.context())
+	}
+	prefix := p.src[start:p.pos]
+	ns, ok := p.prefixes[prefix]
+	if !ok {
+		return "", fmt.Errorf("undefined prefix %q", prefix)
+	}
+	p.pos++
+	var local strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '\\' && p.pos+1 < len(p.src) {
+			local.WriteByte(p.src[p.pos+1])
+			p.pos += 2
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+		if !isNameChar(r) && c != ':' && c != '%' {
+			break
+		}
+		local.WriteString(p.src[p.pos : p.pos+size])
+		p.pos += size
+	}
+	name := local.String()
+	for strings.HasSuffix(name, ".") { // A statement's final '.'
+		name = name[:len(name)-1]
+		p.pos--
+	}
+	return "<" + ns + name + ">", nil
+}
+
+func (p *turtleParser) literal() (string, error) {
+	quote := p.src[p.pos]
+	delim := string(quote)
+	if strings.HasPrefix(p.src[p.pos:], strings.Repeat(delim, 3)) {
+		delim = strings.Repeat(delim, 3)
+	}
+	p.pos += len(delim)
+	start := p.pos
+	for {
+		if p.pos >= len(p.src) {
+			return "", fmt.Errorf("unterminated string")
+		}
+		c := p.src[p.pos]
+		if c == '\\' {
+			p.pos += 2
+			continue
+		}
+		if strings.HasPrefix(p.src[p.pos:], delim) {
+			break
+		}
+		if c == '\n' {
+			if len(delim) == 1 {
+				return "", fmt.Errorf("newline in string")
+			}
+			p.line++
+		}
+		p.pos++
+	}
+	lexical, err := unescapeString(p.src[start:p.pos])
+	if err != nil {
+		return "", err
+	}
+	p.pos += len(delim)
+	term := QuoteString(lexical)
+	switch {
+	case p.pos < len(p.src) && p.src[p.pos] == '@':
+		end := p.pos + 1
+		for end < len(p.src) && (isAlnum(p.src[end]) || p.src[end] == '-') {
+			end++
+		}
+		term += p.src[p.pos:end]
+		p.pos = end
+	case strings.HasPrefix(p.src[p.pos:], "^^"):
+		p.pos += 2
+		dt, err := p.term()
+		if err != nil {
+			return "", err
+		}
+		term += "^^" + dt
+	}
+	return term, nil
+}
+
+func (p *turtleParser) number() (string, error) {
+	start := p.pos
+	if c := p.src[p.pos]; c == '+' || c == '-' {
+		p.pos++
+	}
+	digits := func() {
+		for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+			p.pos++
+		}
+	}
+	digits()
+	kind := "integer"
+	if p.pos+1 < len(p.src) && p.src[p.pos] == '.' && p.src[p.pos+1] >= '0' && p.src[p.pos+1] <= '9' {
+		p.pos++
+		digits()
+		kind = "decimal"
+	}
+	if p.pos < len(p.src) && (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') {
+		p.pos++
+		if p.pos < len(p.src) && (p.src[p.pos] == '+' || p.src[p.pos] == '-') {
+			p.pos++
+		}
+		digits()
+		kind = "double"
+	}
+	lexical := p.src[start:p.pos]
+	if strings.Trim(lexical, "+-.eE") == "" {
+		return "", fmt.Errorf("invalid number %q", lexical)
+	}
+	return `"` + lexical + `"^^<` + xsdNS + kind + `>`, nil
+}
+
+func isAlnum(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// isNameChar reports whether r may appear in a prefix or local name.
+func isNameChar(r rune) bool {
+	return r == '_' || r == '-' || r == '.' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r > 0x7f
+}
+
+// unescapeString resolves the escapes of a Turtle or N-Quads string.
+func unescapeString(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch c := s[i]; c {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'u', 'U':
+			n := 4
+			if c == 'U' {
+				n = 8
+			}
+			if i+n >= len(s) {
+				return "", fmt.Errorf("truncated escape \\%c", c)
+			}
+			code, err := strconv.ParseUint(s[i+1:i+1+n], 16, 32)
+			if err != nil {
+				return "", fmt.Errorf("invalid escape \\%c%s", c, s[i+1:i+1+n])
+			}
+			b.WriteRune(rune(code))
+			i += n
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), nil
+}
+
+// QuoteString renders a lexical form as an N-Quads string literal, with
+// the characters N-Quads requires escaped.
+func QuoteString(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// LiteralParts splits a literal term into its unescaped lexical form and
+// its language tag or datatype IRI (without angle brackets); at most one
+// of the two is set.
+func LiteralParts(term string) (lexical, lang, datatype string, err error) {
+	end := strings.LastIndex(term, `"`)
+	if !strings.HasPrefix(term, `"`) || end <= 0 {
+		return "", "", "", fmt.Errorf("%q is not a literal", term)
+	}
+	if lexical, err = unescapeString(term[1:end]); err != nil {
+		return "", "", "", err
+	}
+	rest := term[end+1:]
+	switch {
+	case strings.HasPrefix(rest, "@"):
+		lang = rest[1:]
+	case strings.HasPrefix(rest, "^^"):
+		datatype = strings.Trim(rest[2:], "<>")
+	}
+	return lexical, lang, datatype, nil
+}
@@ -0,0 +1,105 @@
+package rdfio
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+)
+
+func sortQuads(quads []quadstore.Quad) []quadstore.Quad {
+	sorted := append([]quadstore.Quad(nil), quads...)
+	sort.Slice(sorted, func(i, j int) bool { return FormatQuad(sorted[i]) < FormatQuad(sorted[j]) })
+	return sorted
+}
+
+func TestSerializationsRoundTrip(t *testing.T) {
+	quads := []quadstore.Quad{
+		{Subject: "<http://ex.org/s>", Predicate: "<http://ex.org/name>", Object: `"Ada \"the first\""@en`},
+		{Subject: "<http://ex.org/s>", Predicate: "<http://ex.org/name>", Object: `"Ada"`},
+		{Subject: "<http://ex.org/s>", Predicate: "<http://ex.org/age>", Object: `"36"^^<http://www.w3.org/2001/XMLSchema#integer>`},
+		{Subject: "<http://ex.org/s>", Predicate: "<http://ex.org/knows>", Object: "<http://ex.org/t>", Graph: "<http://ex.org/g>"},
+	}
+	triples := make([]quadstore.Quad, len(quads))
+	for i, q := range quads {
+		q.Graph = ""
+		triples[i] = q
+	}
+	for _, tt := range []struct {
+		name  string
+		write func(io.Writer, []quadstore.Quad) error
+		parse func(io.Reader) ([]quadstore.Quad, error)
+		want  []quadstore.Quad
+	}{
+		{"Turtle", WriteTurtle, func(r io.Reader) ([]quadstore.Quad, error) { return ParseTurtle(r, "") }, triples},
+		{"TriG", WriteTriG, func(r io.Reader) ([]quadstore.Quad, error) { return ParseTriG(r, "") }, quads},
+		{"JSON-LD", WriteJSONLD, ParseJSONLD, quads},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tt.write(&buf, quads); err != nil {
+				t.Fatal(err)
+			}
+			got, err := tt.parse(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("parsing %s: %v\n%s", tt.name, err, buf.String())
+			}
+			if !reflect.DeepEqual(sortQuads(got), sortQuads(tt.want)) {
+				t.Errorf("read back\n%v\nwant\n%v\nfrom\n%s", sortQuads(got), sortQuads(tt.want), buf.String())
+			}
+		})
+	}
+}
+
+func TestParseTurtle(t *testing.T) {
+	doc := `@prefix ex: <http://ex.org/> .
+@base <http://base.org/> .
+ex:s a ex:Person ;
+    ex:name "Ada", "Augusta" ;
+    ex:rel <rel> .
+`
+	got, err := ParseTurtle(strings.NewReader(doc), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []quadstore.Quad{
+		{Subject: "<http://ex.org/s>", Predicate: "<http://www.w3.org/1999/02/22-rdf-syntax-ns#type>", Object: "<http://ex.org/Person>"},
+		{Subject: "<http://ex.org/s>", Predicate: "<http://ex.org/name>", Object: `"Ada"`},
+		{Subject: "<http://ex.org/s>", Predicate: "<http://ex.org/name>", Object: `"Augusta"`},
+		{Subject: "<http://ex.org/s>", Predicate: "<http://ex.org/rel>", Object: "<http://base.org/rel>"},
+	}
+	if !reflect.DeepEqual(sortQuads(got), sortQuads(want)) {
+		t.Errorf("ParseTurtle = %v, want %v", got, want)
+	}
+
+	if _, err := ParseTurtle(strings.NewReader(`ex:s ex:p "o" .`), ""); err == nil {
+		t.Error("an undeclared prefix was accepted")
+	}
+}
+
+func TestLiteralParts(t *testing.T) {
+	tests := []struct {
+		term, lexical, lang, datatype string
+		wantErr                       bool
+	}{
+		{term: `"plain"`, lexical: "plain"},
+		{term: `"chat"@fr`, lexical: "chat", lang: "fr"},
+		{term: `"1"^^<http://www.w3.org/2001/XMLSchema#integer>`, lexical: "1", datatype: "http://www.w3.org/2001/XMLSchema#integer"},
+		{term: `"a \"q\"\nb"`, lexical: "a \"q\"\nb"},
+		{term: `<http://ex.org/s>`, wantErr: true},
+	}
+	for _, tt := range tests {
+		lexical, lang, datatype, err := LiteralParts(tt.term)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("LiteralParts(%s): %v, want error %v", tt.term, err, tt.wantErr)
+			continue
+		}
+		if lexical != tt.lexical || lang != tt.lang || datatype != tt.datatype {
+			t.Errorf("LiteralParts(%s) = %q, %q, %q, want %q, %q, %q", tt.term, lexical, lang, datatype, tt.lexical, tt.lang, tt.datatype)
+		}
+	}
+}
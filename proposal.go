@@ -0,0 +1,635 @@
+// proposal.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+	"github.com/spf13/cobra"
+)
+
+// proposalPrefix keys proposals by number, zero-padded so that they list in
+// order. Unlike commits, proposals change as their discussion grows, so
+// they are records rather than content-addressed objects.
+const proposalPrefix = "proposal:"
+
+// Proposal states.
+const (
+	proposalOpen   = "open"
+	proposalMerged = "merged"
+	proposalClosed = "closed"
+)
+
+// errNoProposal is returned for a proposal number that does not exist.
+var errNoProposal = errors.New("no such proposal")
+
+// A proposal asks for a source branch to be merged into a target branch,
+// and records the discussion and reviews that lead up to it.
+type proposal struct {
+	ID          int               `json:"id"`
+	Title       string            `json:"title"`
+	Description string            `json:"description,omitempty"`
+	Source      string            `json:"source"`
+	Target      string            `json:"target"`
+	Author      string            `json:"author"`
+	Created     time.Time         `json:"created"`
+	State       string            `json:"state"`
+	Comments    []proposalComment `json:"comments,omitempty"`
+	// Reviews are every review given through the proposal, each of the
+	// source's tip at the time; see 'review'.
+	Reviews  []review `json:"reviews,omitempty"`
+	MergedAs string   `json:"mergedAs,omitempty"`
+}
+
+type proposalComment struct {
+	Author string    `json:"author"`
+	Text   string    `json:"text"`
+	Time   time.Time `json:"time"`
+}
+
+func proposalKey(id int) []byte {
+	return []byte(fmt.Sprintf("%s%08d", proposalPrefix, id))
+}
+
+// createProposal stores a new open proposal and assigns its number.
+func createProposal(p *proposal) error {
+	if p.Title = strings.TrimSpace(p.Title); p.Title == "" {
+		return errors.New("a proposal needs a title")
+	}
+	if p.Source == p.Target {
+		return fmt.Errorf("cannot propose merging %s into itself", p.Source)
+	}
+	for _, branch := range []string{p.Source, p.Target} {
+		if _, err := getReference("head:" + branch); err != nil {
+			return fmt.Errorf("branch %s: %w", branch, err)
+		}
+	}
+	p.State, p.Created = proposalOpen, clock.Now()
+	return repo.db.Update(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Reverse: true})
+		defer it.Close()
+		p.ID = 1
+		it.Seek(append([]byte(proposalPrefix), 0xff))
+		if it.ValidForPrefix([]byte(proposalPrefix)) {
+			last, err := strconv.Atoi(strings.TrimPrefix(string(it.Item().Key()), proposalPrefix))
+			if err != nil {
+				return err
+			}
+			p.ID = last + 1
+		}
+		data, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		return txn.Set(proposalKey(p.ID), data)
+	})
+}
+
+// readProposal returns proposal number id.
+func readProposal(id int) (*proposal, error) {
+	var p proposal
+	err := repo.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(proposalKey(id))
+		if err == badger.ErrKeyNotFound {
+			return fmt.Errorf("#%d: %w", id, errNoProposal)
+		} else if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error { return json.Unmarshal(val, &p) })
+	})
+	return &p, err
+}
+
+// updateProposal applies fn to proposal number id and stores the result,
+// unless fn fails.
+func updateProposal(id int, fn func(p *proposal) error) (*proposal, error) {
+	var p proposal
+	err := repo.db.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get(proposalKey(id))
+		if err == badger.ErrKeyNotFound {
+			return fmt.Errorf("#%d: %w", id, errNoProposal)
+		} else if err != nil {
+			return err
+		}
+		if err := item.Value(func(val []byte) error { return json.Unmarshal(val, &p) }); err != nil {
+			return err
+		}
+		if err := fn(&p); err != nil {
+			return err
+		}
+		data, err := json.Marshal(&p)
+		if err != nil {
+			return err
+		}
+		return txn.Set(proposalKey(id), data)
+	})
+	return &p, err
+}
+
+// listProposals returns the proposals in state, or all if state is empty,
+// in the order they were made.
+func listProposals(state string) ([]*proposal, error) {
+	var proposals []*proposal
+	err := repo.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		prefix := []byte(proposalPrefix)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			var p proposal
+			if err := it.Item().Value(func(val []byte) error { return json.Unmarshal(val, &p) }); err != nil {
+				return err
+			}
+			if state == "" || p.State == state {
+				proposals = append(proposals, &p)
+			}
+		}
+		return nil
+	})
+	return proposals, err
+}
+
+// commentOnProposal adds a comment to a proposal, whatever its state.
+func commentOnProposal(id int, author, text string) (*proposal, error) {
+	if text = strings.TrimSpace(text); text == "" {
+		return nil, errors.New("the comment is empty")
+	}
+	return updateProposal(id, func(p *proposal) error {
+		p.Comments = append(p.Comments, proposalComment{Author: author, Text: text, Time: clock.Now()})
+		return nil
+	})
+}
+
+// reviewProposal records a review of the source's tip, both as a review of
+// that commit, which merges into protected branches check, and in the
+// proposal.
+func reviewProposal(id int, reviewer, state, comment string) (*proposal, error) {
+	p, err := readProposal(id)
+	if err != nil {
+		return nil, err
+	}
+	if p.State != proposalOpen {
+		return nil, fmt.Errorf("proposal #%d is %s", id, p.State)
+	}
+	tip, err := getReference("head:" + p.Source)
+	if err != nil {
+		return nil, fmt.Errorf("branch %s: %w", p.Source, err)
+	}
+	rv := review{Commit: tip, Reviewer: reviewer, State: state, Comment: strings.TrimSpace(comment), Time: clock.Now()}
+	if err := recordReview(rv); err != nil {
+		return nil, err
+	}
+	return updateProposal(id, func(p *proposal) error {
+		p.Reviews = append(p.Reviews, rv)
+		return nil
+	})
+}
+
+// closeProposal closes an open proposal without merging it.
+func closeProposal(id int) (*proposal, error) {
+	return updateProposal(id, func(p *proposal) error {
+		if p.State != proposalOpen {
+			return fmt.Errorf("proposal #%d is %s", id, p.State)
+		}
+		p.State = proposalClosed
+		return nil
+	})
+}
+
+// A mergeConflictError is a merge that stopped at conflicts.
+type mergeConflictError struct {
+	conflicts []quadstore.Conflict
+}
+
+func (e mergeConflictError) Error() string {
+	return fmt.Sprintf("%d conflict(s); merge locally and push, or update the source branch", len(e.conflicts))
+}
+
+func (e mergeConflictError) Unwrap() error { return quadstore.ErrConflict }
+
+// mergeProposal merges an open proposal's source into its target, as
+// identity, and marks it merged. The target is fast-forwarded if it can
+// be; otherwise a merge commit is made, which fails on any conflict.
+// Reviews of the source's current tip are recorded in the merge commit as
+// Approved-by trailers.
+func mergeProposal(id int, identity string) (*proposal, error) {
+	p, err := readProposal(id)
+	if err != nil {
+		return nil, err
+	}
+	if p.State != proposalOpen {
+		return nil, fmt.Errorf("proposal #%d is %s", id, p.State)
+	}
+	ref := "head:" + p.Target
+	oursHash, err := getReference(ref)
+	if err != nil {
+		return nil, fmt.Errorf("branch %s: %w", p.Target, err)
+	}
+	theirsHash, err := getReference("head:" + p.Source)
+	if err != nil {
+		return nil, fmt.Errorf("branch %s: %w", p.Source, err)
+	}
+	baseHash, err := findMergeBase(oursHash, theirsHash)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := oursHash
+	switch baseHash {
+	case theirsHash: // Already merged
+	case oursHash:
+		if required, err := requiresSignedCommits(p.Target); err != nil {
+			return nil, err
+		} else if required {
+			stop, err := ancestors(oursHash)
+			if err != nil {
+				return nil, err
+			}
+			if bad, err := verifyRange(theirsHash, stop); bad != "" {
+				return nil, fmt.Errorf("%w: commit %s is not verifiable: %v", quadstore.ErrPermissionDenied, shortHash(bad), err)
+			}
+		}
+		if err := authorizeWrite(quadstore.ActionMerge, identity, ref, oursHash, theirsHash); err != nil {
+			return nil, err
+		}
+		if err := swapReference(ref, oursHash, theirsHash); err != nil {
+			return nil, err
+		}
+		merged = theirsHash
+	default:
+		if merged, err = commitProposalMerge(p, ref, baseHash, oursHash, theirsHash, identity); err != nil {
+			return nil, err
+		}
+	}
+	syncAfterCommit(p.Target)
+	return updateProposal(id, func(p *proposal) error {
+		p.State, p.MergedAs = proposalMerged, merged
+		return nil
+	})
+}
+
+// commitProposalMerge makes the merge commit of a proposal and moves the
+// target to it.
+func commitProposalMerge(p *proposal, ref, baseHash, oursHash, theirsHash, identity string) (string, error) {
+	base, err := loadState(baseHash)
+	if err != nil {
+		return "", err
+	}
+	ours, err := loadState(oursHash)
+	if err != nil {
+		return "", err
+	}
+	theirs, err := loadState(theirsHash)
+	if err != nil {
+		return "", err
+	}
+	merged, conflicts := mergeStates(base, ours, theirs, nil, nil, false)
+	if len(conflicts) > 0 {
+		return "", mergeConflictError{conflicts}
+	}
+	treeHash, err := writeState(merged)
+	if err != nil {
+		return "", err
+	}
+
+	message := fmt.Sprintf("Merge proposal #%d from %s into %s\n\n%s", p.ID, p.Source, p.Target, p.Title)
+	trailers := []quadstore.Trailer{{Key: "Proposal", Value: "#" + strconv.Itoa(p.ID)}}
+	reviews, err := commitReviews(theirsHash)
+	if err != nil {
+		return "", err
+	}
+	for _, rv := range p.Reviews {
+		if rv.Commit == theirsHash && reviews[rv.Reviewer].State == reviewApproved {
+			trailers = append(trailers, quadstore.Trailer{Key: "Approved-by", Value: rv.Reviewer})
+			delete(reviews, rv.Reviewer) // Once each
+		}
+	}
+	commit := Commit{
+		Tree:      treeHash,
+		Parents:   []string{oursHash, theirsHash},
+		Author:    commitAuthor(),
+		Message:   quadstore.AppendTrailers(message, trailers...),
+		Timestamp: clock.Now(),
+	}
+	if commit.Stats, err = computeStats(ours, merged); err != nil {
+		return "", err
+	}
+	if err := enforceSignaturePolicy(p.Target, &commit); err != nil {
+		return "", err
+	}
+	hash, err := writeObject(commit)
+	if err != nil {
+		return "", err
+	}
+	if err := authorizeWrite(quadstore.ActionMerge, identity, ref, oursHash, hash); err != nil {
+		return "", err
+	}
+	if err := swapReference(ref, oursHash, hash); err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// approvalSummary describes the reviews of a proposal that count: those of
+// the source's current tip.
+func approvalSummary(p *proposal) string {
+	tip, _ := getReference("head:" + p.Source)
+	latest := make(map[string]string)
+	var order []string
+	for _, rv := range p.Reviews {
+		if rv.Commit != tip {
+			continue
+		}
+		if _, ok := latest[rv.Reviewer]; !ok {
+			order = append(order, rv.Reviewer)
+		}
+		latest[rv.Reviewer] = rv.State
+	}
+	var parts []string
+	for _, reviewer := range order {
+		parts = append(parts, reviewer+" "+latest[reviewer])
+	}
+	if len(parts) == 0 {
+		return "no reviews of " + shortHash(tip)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// --- Serving ---
+
+// proposalStatus maps a failed proposal operation to a response status.
+func proposalStatus(err error) int {
+	switch {
+	case errors.Is(err, errNoProposal):
+		return http.StatusNotFound
+	case errors.Is(err, quadstore.ErrConflict), errors.Is(err, quadstore.ErrStaleParent):
+		return http.StatusConflict
+	case errors.Is(err, quadstore.ErrPermissionDenied):
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
+// handleProposals lists proposals (GET /proposals[?state=<state>]) or
+// creates one (POST /proposals?source=<branch>&into=<branch>&title=<t>,
+// with the description as the body).
+func handleProposals(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if r.Method == http.MethodGet {
+		proposals, err := listProposals(q.Get("state"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if proposals == nil {
+			proposals = []*proposal{}
+		}
+		writeAdminJSON(w, http.StatusOK, proposals)
+		return
+	}
+	description, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p := &proposal{Title: q.Get("title"), Description: strings.TrimSpace(string(description)), Source: q.Get("source"), Target: q.Get("into"), Author: requestIdentity(r)}
+	if p.Target == "" {
+		p.Target = currentBranch()
+	}
+	if err := createProposal(p); err != nil {
+		http.Error(w, err.Error(), proposalStatus(err))
+		return
+	}
+	writeAdminJSON(w, http.StatusCreated, p)
+}
+
+// handleProposal reads a proposal (GET /proposal?id=<n>) or acts on it
+// (POST /proposal?id=<n>&action=comment|approve|request-changes|merge|close,
+// with a comment as the body).
+func handleProposal(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Query().Get("id"), "#"))
+	if err != nil {
+		http.Error(w, "invalid proposal id", http.StatusBadRequest)
+		return
+	}
+	var p *proposal
+	if r.Method == http.MethodGet {
+		p, err = readProposal(id)
+	} else {
+		text, readErr := io.ReadAll(io.LimitReader(r.Body, 64*1024))
+		if readErr != nil {
+			http.Error(w, readErr.Error(), http.StatusBadRequest)
+			return
+		}
+		identity := requestIdentity(r)
+		switch action := r.URL.Query().Get("action"); action {
+		case "comment":
+			p, err = commentOnProposal(id, identity, string(text))
+		case "approve":
+			p, err = reviewProposal(id, identity, reviewApproved, string(text))
+		case "request-changes":
+			p, err = reviewProposal(id, identity, reviewChangesRequested, string(text))
+		case "merge":
+			p, err = mergeProposal(id, identity)
+		case "close":
+			p, err = closeProposal(id)
+		default:
+			http.Error(w, fmt.Sprintf("unknown action %q", action), http.StatusBadRequest)
+			return
+		}
+	}
+	var conflict mergeConflictError
+	if errors.As(err, &conflict) {
+		writeAdminJSON(w, http.StatusConflict, map[string]interface{}{"error": err.Error(), "conflicts": conflict.conflicts})
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), proposalStatus(err))
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, p)
+}
+
+// --- CLI ---
+
+// proposalArg parses a proposal number, with or without its "#".
+func proposalArg(arg string) int {
+	id, err := strconv.Atoi(strings.TrimPrefix(arg, "#"))
+	if err != nil {
+		log.Fatalf("Invalid proposal number %q", arg)
+	}
+	return id
+}
+
+var proposalCmd = &cobra.Command{
+	Use:   "proposal",
+	Short: "Propose, discuss, approve and merge changes between branches",
+	Long: `Proposals are the repository's pull requests: each asks for a source
+branch to be merged into a target branch, and collects comments and
+reviews until it is merged or closed. Approving a proposal approves the
+source's tip as 'review approve' does, so the owners of changed graphs
+can clear a merge into a protected branch through it; a review of an
+older tip no longer counts once the source moves.
+
+Over HTTP, 'quad-db serve' offers the same: GET /proposals[?state=<s>]
+lists them, POST /proposals?source=<b>&into=<b>&title=<t> creates one,
+GET /proposal?id=<n> reads one, and POST /proposal?id=<n>&action=<a>,
+with comment, approve, request-changes, merge or close as the action,
+acts on it as the authenticated user, with the body as the comment.`,
+}
+
+var proposalCreateCmd = &cobra.Command{
+	Use:   "create <source> [--into <target>] --title <title>",
+	Short: "Propose merging a branch into another",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		title, _ := cmd.Flags().GetString("title")
+		description, _ := cmd.Flags().GetString("message")
+		target, _ := cmd.Flags().GetString("into")
+		if target == "" {
+			target = currentBranch()
+		}
+		p := &proposal{Title: title, Description: description, Source: args[0], Target: target, Author: actingIdentity()}
+		if err := createProposal(p); err != nil {
+			log.Fatalf("Failed to create the proposal: %v", err)
+		}
+		fmt.Printf("Created proposal #%d: merge %s into %s\n", p.ID, p.Source, p.Target)
+	},
+}
+
+var proposalListCmd = &cobra.Command{
+	Use:   "list [--state open|merged|closed|all]",
+	Short: "List proposals",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		state, _ := cmd.Flags().GetString("state")
+		if state == "all" {
+			state = ""
+		}
+		proposals, err := listProposals(state)
+		if err != nil {
+			log.Fatalf("Failed to list proposals: %v", err)
+		}
+		for _, p := range proposals {
+			fmt.Printf("#%-4d %-6s %s -> %s  %s (%s)\n", p.ID, p.State, p.Source, p.Target, p.Title, approvalSummary(p))
+		}
+	},
+}
+
+var proposalShowCmd = &cobra.Command{
+	Use:   "show <n>",
+	Short: "Show a proposal and its discussion",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		p, err := readProposal(proposalArg(args[0]))
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("#%d %s\n", p.ID, p.Title)
+		fmt.Printf("%s: merge %s into %s, proposed by %s on %s\n", p.State, p.Source, p.Target, p.Author, p.Created.Format("2006-01-02 15:04"))
+		if p.MergedAs != "" {
+			fmt.Printf("Merged as %s\n", shortHash(p.MergedAs))
+		} else {
+			fmt.Printf("Reviews: %s\n", approvalSummary(p))
+		}
+		if p.Description != "" {
+			fmt.Printf("\n%s\n", p.Description)
+		}
+		type entry struct {
+			time time.Time
+			text string
+		}
+		var entries []entry
+		for _, c := range p.Comments {
+			entries = append(entries, entry{c.Time, fmt.Sprintf("%s commented:\n    %s", c.Author, strings.ReplaceAll(c.Text, "\n", "\n    "))})
+		}
+		for _, rv := range p.Reviews {
+			text := fmt.Sprintf("%s %s %s", rv.Reviewer, rv.State, shortHash(rv.Commit))
+			if rv.Comment != "" {
+				text += ":\n    " + strings.ReplaceAll(rv.Comment, "\n", "\n    ")
+			}
+			entries = append(entries, entry{rv.Time, text})
+		}
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].time.Before(entries[j].time) })
+		for _, e := range entries {
+			fmt.Printf("\n%s %s\n", e.time.Format("2006-01-02 15:04"), e.text)
+		}
+	},
+}
+
+var proposalCommentCmd = &cobra.Command{
+	Use:   "comment <n> -m <text>",
+	Short: "Comment on a proposal",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		text, _ := cmd.Flags().GetString("message")
+		p, err := commentOnProposal(proposalArg(args[0]), actingIdentity(), text)
+		if err != nil {
+			log.Fatalf("Failed to comment: %v", err)
+		}
+		fmt.Printf("Commented on proposal #%d\n", p.ID)
+	},
+}
+
+// proposalReviewCommand returns a subcommand reviewing a proposal in state.
+func proposalReviewCommand(use, short, state string) *cobra.Command {
+	return &cobra.Command{
+		Use:   use + " <n>",
+		Short: short,
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			comment, _ := cmd.Flags().GetString("message")
+			p, err := reviewProposal(proposalArg(args[0]), actingIdentity(), state, comment)
+			if err != nil {
+				log.Fatalf("Failed to review: %v", err)
+			}
+			fmt.Printf("Proposal #%d: %s\n", p.ID, approvalSummary(p))
+		},
+	}
+}
+
+var proposalApproveCmd = proposalReviewCommand("approve", "Approve a proposal's current changes", reviewApproved)
+
+var proposalRequestChangesCmd = proposalReviewCommand("request-changes", "Ask for changes before a proposal is merged", reviewChangesRequested)
+
+var proposalMergeCmd = &cobra.Command{
+	Use:   "merge <n>",
+	Short: "Merge a proposal's source into its target",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		p, err := mergeProposal(proposalArg(args[0]), actingIdentity())
+		var conflict mergeConflictError
+		if errors.As(err, &conflict) {
+			for _, c := range conflict.conflicts {
+				fmt.Printf("CONFLICT (%s): %s\n", c.Type, c.Description)
+			}
+			fmt.Fprintf(os.Stderr, "Proposal not merged: %v\n", err)
+			os.Exit(1)
+		} else if err != nil {
+			log.Fatalf("Proposal not merged: %v", err)
+		}
+		fmt.Printf("Merged proposal #%d into %s as %s\n", p.ID, p.Target, shortHash(p.MergedAs))
+	},
+}
+
+var proposalCloseCmd = &cobra.Command{
+	Use:   "close <n>",
+	Short: "Close a proposal without merging it",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		p, err := closeProposal(proposalArg(args[0]))
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Closed proposal #%d\n", p.ID)
+	},
+}
@@ -0,0 +1,657 @@
+// query.go
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/mannyrivera2010/go-quadgit/internal/sparql"
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+	"github.com/spf13/cobra"
+)
+
+// queryStatsPrefix keys the planner statistics of a graph blob. Blobs never
+// change, so statistics computed once stay valid.
+const queryStatsPrefix = "qstats:"
+
+// graphStats are the planner statistics of one graph: how many quads it
+// holds, and how they spread over subjects, predicates and objects.
+type graphStats struct {
+	Quads      int                        `json:"quads"`
+	Subjects   int                        `json:"subjects"`
+	Objects    int                        `json:"objects"`
+	Predicates map[string]*predicateStats `json:"predicates"`
+}
+
+type predicateStats struct {
+	Quads    int `json:"quads"`
+	Subjects int `json:"subjects"`
+	Objects  int `json:"objects"`
+}
+
+// graphIndex holds a graph's quads in memory with an index on each
+// position, built when a query first reads the graph.
+type graphIndex struct {
+	quads       []quadstore.Quad
+	bySubject   map[string][]int
+	byPredicate map[string][]int
+	byObject    map[string][]int
+}
+
+// snapshotSource lets queries read a snapshot. Graph names are those of
+// the query language: "" for the default graph, IRIs otherwise.
+type snapshotSource struct {
+	snap *snapshot
+
+	mu       sync.Mutex
+	indexes  map[string]*graphIndex
+	stats    map[string]*graphStats
+	history  map[string]*graphIndex // History graphs and blobs, by name
+	versions map[string][]graphVersion
+}
+
+func newSnapshotSource(snap *snapshot) *snapshotSource {
+	return &snapshotSource{
+		snap:     snap,
+		indexes:  make(map[string]*graphIndex),
+		stats:    make(map[string]*graphStats),
+		history:  make(map[string]*graphIndex),
+		versions: make(map[string][]graphVersion),
+	}
+}
+
+// queryGraphName converts a stored graph name to the query language's.
+func queryGraphName(stored string) string {
+	if stored == defaultGraph {
+		return ""
+	}
+	return stored
+}
+
+// dataGraphs returns the stored names of the snapshot's graphs, leaving
+// out saved queries, which are not data.
+func (s *snapshotSource) dataGraphs() []string {
+	var names []string
+	for _, g := range s.snap.graphNames() {
+		if !isSavedQueryGraph(g) {
+			names = append(names, g)
+		}
+	}
+	return names
+}
+
+// storedGraphs returns the stored names of the graphs a query graph
+// position selects: all data graphs for "".
+func (s *snapshotSource) storedGraphs(graph string) []string {
+	if graph == "" {
+		return s.dataGraphs()
+	}
+	if _, ok := s.snap.graphs[graph]; ok {
+		return []string{graph}
+	}
+	return nil
+}
+
+// newGraphIndex indexes quads on each position.
+func newGraphIndex(quads []quadstore.Quad) *graphIndex {
+	idx := &graphIndex{quads: quads, bySubject: map[string][]int{}, byPredicate: map[string][]int{}, byObject: map[string][]int{}}
+	for i, q := range quads {
+		idx.bySubject[q.Subject] = append(idx.bySubject[q.Subject], i)
+		idx.byPredicate[q.Predicate] = append(idx.byPredicate[q.Predicate], i)
+		idx.byObject[q.Object] = append(idx.byObject[q.Object], i)
+	}
+	return idx
+}
+
+// readGraphIndex loads a graph blob, naming its quads' graph as given.
+func readGraphIndex(blobHash, graph string) (*graphIndex, error) {
+	blob, err := readBlob(blobHash)
+	if err != nil {
+		return nil, err
+	}
+	quads := make([]quadstore.Quad, 0, len(blob))
+	for _, line := range blob {
+		q, err := parseQuad(line)
+		if err != nil {
+			continue
+		}
+		q.Graph = graph
+		quads = append(quads, q)
+	}
+	return newGraphIndex(quads), nil
+}
+
+// index returns the in-memory index of a stored graph, loading it once.
+func (s *snapshotSource) index(stored string) (*graphIndex, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if idx, ok := s.indexes[stored]; ok {
+		return idx, nil
+	}
+	idx, err := readGraphIndex(s.snap.graphs[stored], queryGraphName(stored))
+	if err != nil {
+		return nil, err
+	}
+	s.indexes[stored] = idx
+	return idx, nil
+}
+
+func (s *snapshotSource) Match(ctx context.Context, subj, pred, obj, graph string, fn func(q quadstore.Quad) error) error {
+	if isHistoryGraph(graph) {
+		idx, err := s.historyIndex(graph)
+		if err != nil {
+			return err
+		}
+		return idx.match(ctx, subj, pred, obj, fn)
+	}
+	for _, stored := range s.storedGraphs(graph) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		idx, err := s.index(stored)
+		if err != nil {
+			return err
+		}
+		if err := idx.match(ctx, subj, pred, obj, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// match calls fn for each quad matching the bound positions, reading
+// through the narrowest index they allow.
+func (idx *graphIndex) match(ctx context.Context, subj, pred, obj string, fn func(q quadstore.Quad) error) error {
+	var candidates []int
+	scan := true
+	for _, pos := range []struct {
+		term  string
+		index map[string][]int
+	}{{subj, idx.bySubject}, {obj, idx.byObject}, {pred, idx.byPredicate}} {
+		if pos.term == "" {
+			continue
+		}
+		if list := pos.index[pos.term]; scan || len(list) < len(candidates) {
+			candidates, scan = list, false
+		}
+	}
+	match := func(q quadstore.Quad) error {
+		if subj != "" && q.Subject != subj || pred != "" && q.Predicate != pred || obj != "" && q.Object != obj {
+			return nil
+		}
+		return fn(q)
+	}
+	if scan {
+		for i, q := range idx.quads {
+			if i%4096 == 4095 {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+			}
+			if err := match(q); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, i := range candidates {
+		if err := match(idx.quads[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// contains reports whether the index holds a triple.
+func (idx *graphIndex) contains(subj, pred, obj string) bool {
+	for _, i := range idx.bySubject[subj] {
+		if q := idx.quads[i]; q.Predicate == pred && q.Object == obj {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *snapshotSource) Estimate(subj, pred, obj, graph string) sparql.Access {
+	if isHistoryGraph(graph) {
+		return s.estimateHistory(subj, pred, obj, graph)
+	}
+	var stored []string
+	switch graph {
+	case "", sparql.Bound:
+		stored = s.dataGraphs()
+	default:
+		stored = s.storedGraphs(graph)
+	}
+	rows := 0.0
+	for _, g := range stored {
+		st, err := s.graphStats(g)
+		if err != nil {
+			continue
+		}
+		rows += estimateRows(st, subj, pred, obj)
+	}
+	if graph == sparql.Bound && len(stored) > 0 {
+		rows /= float64(len(stored))
+	}
+
+	method := "scan"
+	switch {
+	case subj != "":
+		method = "subject index"
+	case obj != "":
+		method = "object index"
+	case pred != "":
+		method = "predicate index"
+	}
+	switch {
+	case graph == "":
+		method += fmt.Sprintf(" over %d graph(s)", len(stored))
+	case graph == sparql.Bound:
+		method += " in the bound graph"
+	default:
+		method += " in " + graph
+	}
+	return sparql.Access{Method: method, Rows: rows}
+}
+
+// estimateRows estimates how many of a graph's quads match a pattern,
+// assuming values are spread evenly.
+func estimateRows(st *graphStats, subj, pred, obj string) float64 {
+	rows, subjects, objects := float64(st.Quads), st.Subjects, st.Objects
+	switch pred {
+	case "":
+	case sparql.Bound:
+		if n := len(st.Predicates); n > 0 {
+			rows /= float64(n)
+		}
+	default:
+		ps, ok := st.Predicates[pred]
+		if !ok {
+			return 0
+		}
+		rows, subjects, objects = float64(ps.Quads), ps.Subjects, ps.Objects
+	}
+	if subj != "" && subjects > 0 {
+		rows /= float64(subjects)
+	}
+	if obj != "" && objects > 0 {
+		rows /= float64(objects)
+	}
+	return rows
+}
+
+// graphStats returns the planner statistics of a stored graph, computing
+// and saving them the first time its blob is queried.
+func (s *snapshotSource) graphStats(stored string) (*graphStats, error) {
+	s.mu.Lock()
+	st, ok := s.stats[stored]
+	s.mu.Unlock()
+	if ok {
+		return st, nil
+	}
+	blobHash := s.snap.graphs[stored]
+	st, err := loadGraphStats(blobHash)
+	if err != nil {
+		return nil, err
+	}
+	if st == nil {
+		idx, err := s.index(stored)
+		if err != nil {
+			return nil, err
+		}
+		st = computeGraphStats(idx.quads)
+		if data, err := json.Marshal(st); err == nil {
+			repo.db.Update(func(txn *badger.Txn) error { // Only a cache; failures are harmless
+				return txn.Set([]byte(queryStatsPrefix+blobHash), data)
+			})
+		}
+	}
+	s.mu.Lock()
+	s.stats[stored] = st
+	s.mu.Unlock()
+	return st, nil
+}
+
+// loadGraphStats reads saved statistics, returning nil if there are none.
+func loadGraphStats(blobHash string) (*graphStats, error) {
+	var st *graphStats
+	err := repo.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(queryStatsPrefix + blobHash))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			st = new(graphStats)
+			return json.Unmarshal(val, st)
+		})
+	})
+	return st, err
+}
+
+func computeGraphStats(quads []quadstore.Quad) *graphStats {
+	st := &graphStats{Quads: len(quads), Predicates: make(map[string]*predicateStats)}
+	subjects, objects := make(map[string]bool), make(map[string]bool)
+	predSubjects, predObjects := make(map[string]map[string]bool), make(map[string]map[string]bool)
+	for _, q := range quads {
+		subjects[q.Subject], objects[q.Object] = true, true
+		ps := st.Predicates[q.Predicate]
+		if ps == nil {
+			ps = &predicateStats{}
+			st.Predicates[q.Predicate] = ps
+			predSubjects[q.Predicate], predObjects[q.Predicate] = make(map[string]bool), make(map[string]bool)
+		}
+		ps.Quads++
+		predSubjects[q.Predicate][q.Subject] = true
+		predObjects[q.Predicate][q.Object] = true
+	}
+	st.Subjects, st.Objects = len(subjects), len(objects)
+	for p, ps := range st.Predicates {
+		ps.Subjects, ps.Objects = len(predSubjects[p]), len(predObjects[p])
+	}
+	return st
+}
+
+// readQueryArg returns the query given as an argument, with --file, or on
+// standard input for "-".
+func readQueryArg(cmd *cobra.Command, args []string) (string, error) {
+	file, _ := cmd.Flags().GetString("file")
+	switch {
+	case file != "" && len(args) > 0:
+		return "", fmt.Errorf("give the query as an argument or with --file, not both")
+	case file == "-" || file == "" && len(args) == 0:
+		data, err := io.ReadAll(os.Stdin)
+		return string(data), err
+	case file != "":
+		data, err := os.ReadFile(file)
+		return string(data), err
+	}
+	return args[0], nil
+}
+
+// allStats returns the statistics of every graph in the snapshot.
+func (s *snapshotSource) allStats() map[string]*graphStats {
+	all := make(map[string]*graphStats)
+	for _, g := range s.dataGraphs() {
+		if st, err := s.graphStats(g); err == nil {
+			all[g] = st
+		}
+	}
+	return all
+}
+
+// describeStats summarizes a snapshot's graphs with their statistics, for explain.
+func (s *snapshotSource) describeStats() []string {
+	var lines []string
+	for _, g := range s.dataGraphs() {
+		st, err := s.graphStats(g)
+		if err != nil {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s: %d quads, %d subjects, %d objects, %d predicates", g, st.Quads, st.Subjects, st.Objects, len(st.Predicates)))
+	}
+	return lines
+}
+
+// queryParam returns the query of a SPARQL protocol request: the query
+// parameter of a GET or form POST, or the body of an
+// application/sparql-query POST.
+func queryParam(r *http.Request) (string, error) {
+	if r.Method == http.MethodPost && strings.HasPrefix(r.Header.Get("Content-Type"), "application/sparql-query") {
+		data, err := io.ReadAll(r.Body)
+		return string(data), err
+	}
+	if err := r.ParseForm(); err != nil {
+		return "", err
+	}
+	if q := r.Form.Get("query"); q != "" {
+		return q, nil
+	}
+	return "", fmt.Errorf("missing query parameter")
+}
+
+// handleSPARQL answers SPARQL protocol queries against a commit (HEAD by
+// default): GET or POST /sparql?query=<q>[&rev=<commit>], or saved=<name>
+// for a query saved at that commit, each param=name=value binding a
+// variable. SELECT and ASK results are negotiated among the SPARQL JSON,
+// XML, CSV and TSV formats, CONSTRUCT results like /graph; a format
+// parameter (json, xml, csv, tsv, or nquads, turtle and the like) picks
+// one outright. With explain=true the plan is returned as JSON instead.
+func handleSPARQL(w http.ResponseWriter, r *http.Request) {
+	defer recoverQuery(w)
+	snap, err := openSnapshot(revParam(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	var text string
+	if name := r.URL.Query().Get("saved"); name != "" {
+		sq, err := readSavedQuery(snap, name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		text = sq.Text
+	} else if text, err = queryParam(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q, err := sparql.Parse(text)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	bindings, err := parseQueryParams(q, r.URL.Query()["param"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if notModified(w, r, snap.hash) {
+		return
+	}
+	src := newSnapshotSource(snap)
+
+	if r.URL.Query().Get("explain") == "true" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Commit string                 `json:"commit"`
+			Plan   *sparql.PlanNode       `json:"plan"`
+			Stats  map[string]*graphStats `json:"statistics"`
+		}{snap.hash, sparql.Explain(q, src), src.allStats()})
+		return
+	}
+	limits, err := requestQueryLimits(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	service, err := configServiceFunc()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res, err := sparql.Evaluate(r.Context(), q, src, sparql.Options{Functions: src.historyFunctions(), Bindings: bindings, Service: service, Limits: limits})
+	if err != nil {
+		queryError(w, err)
+		return
+	}
+	serveQueryResult(w, r, res)
+}
+
+var queryCmd = &cobra.Command{
+	Use:   "query [<sparql>]",
+	Short: "Run a SPARQL query against a commit",
+	Long: `Run a SPARQL SELECT, CONSTRUCT or ASK query against a commit (HEAD by
+default), given as an argument, with --file, or on standard input.
+
+The supported language covers basic graph patterns, GRAPH, OPTIONAL, UNION,
+FILTER, BIND and SERVICE, GROUP BY with COUNT, SUM, MIN, MAX, AVG, SAMPLE and
+GROUP_CONCAT, ORDER BY, LIMIT and OFFSET, and the common string, type and
+comparison functions. Patterns outside GRAPH match every graph.
+
+SERVICE <endpoint> { ... } sends its group to a remote SPARQL endpoint and
+joins the answers with the local solutions. Only the endpoints listed in
+query.serviceAllow may be called: a comma-separated list of URLs, where one
+ending in "/" allows every endpoint under it. Each call is bounded by
+query.serviceTimeout (10s by default). SERVICE SILENT ignores an endpoint
+that fails.
+
+Queries can also read history. GRAPH <urn:quadgit:at:REV> holds every
+triple of another revision, and GRAPH <urn:quadgit:added:A..B> and
+<urn:quadgit:removed:A..B> the triples added and removed between two. The
+functions <urn:quadgit:fn:assertedIn>(?s, ?p, ?o [, ?g]) bind the commit
+that added a triple, as <urn:quadgit:commit:HASH>, and
+<urn:quadgit:fn:commitTime>, commitAuthor and commitMessage describe it:
+
+  PREFIX qg: <urn:quadgit:fn:>
+  SELECT ?s ?name ?c ?when WHERE {
+    GRAPH <urn:quadgit:added:v1..v2> { ?s <http://schema.org/name> ?name }
+    BIND(qg:assertedIn(?s, <http://schema.org/name>, ?name) AS ?c)
+    BIND(qg:commitTime(?c) AS ?when)
+  }
+
+--format picks how results are written: for SELECT and ASK, tsv (the
+default; terms in N-Triples syntax), csv (plain values), json or xml (the
+SPARQL results formats); for CONSTRUCT, nquads (the default), ntriples,
+turtle, trig or jsonld.
+
+--explain prints the plan instead of running the query: the order in which
+the triple patterns are joined, the index each one is read through, and the
+estimated number of solutions after each step. Estimates come from per-graph
+statistics, computed when a graph is first queried and kept for later
+queries.
+
+Queries can be versioned with the data. --save <name> stages the query
+(with --comment describing it) as the graph <quadgit://queries/<name>>,
+recorded by the next commit; --saved <name> runs the query saved at --at
+against that same commit, and --list-saved lists them. Saved queries are
+not data: patterns outside GRAPH do not see them. --param name=value binds
+a variable before the query runs; the value is an N-Triples term such as
+<http://ex/C> or "2"^^<http://www.w3.org/2001/XMLSchema#integer>, or else a
+plain string:
+
+  quad-db query --save top-classes --comment "Most used classes" \
+    'SELECT ?c (COUNT(?s) AS ?n) { ?s a ?c } GROUP BY ?c ORDER BY DESC(?n) LIMIT 10'
+  quad-db commit -m "Add the top-classes query"
+  quad-db query --saved top-classes --at v2.0
+
+A query stops with an error, printing nothing, when it runs longer than
+--timeout, produces more than --max-rows intermediate solutions, or holds
+more than about --max-memory bytes of them (e.g. 256M). The limits default
+to the query.timeout, query.maxRows and query.maxMemory options, and are
+unbounded when those are unset. Interrupting the command cancels the query.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if name, _ := cmd.Flags().GetString("save"); name != "" {
+			if !validQueryName(name) {
+				log.Fatalf("Invalid query name %q.", name)
+			}
+			text, err := readQueryArg(cmd, args)
+			if err != nil {
+				log.Fatalf("Failed to read query: %v", err)
+			}
+			comment, _ := cmd.Flags().GetString("comment")
+			if err := stageSavedQuery(name, text, comment); err != nil {
+				log.Fatalf("Failed to save query: %v", err)
+			}
+			fmt.Printf("Staged saved query %s as %s\n", name, savedQueryGraph(name))
+			return
+		}
+
+		at, _ := cmd.Flags().GetString("at")
+		snap, err := openSnapshot(at)
+		if err != nil {
+			log.Fatalf("Could not resolve commit: %v", err)
+		}
+		if list, _ := cmd.Flags().GetBool("list-saved"); list {
+			for _, name := range savedQueryNames(snap) {
+				if sq, err := readSavedQuery(snap, name); err == nil && sq.Comment != "" {
+					fmt.Printf("%s\t%s\n", name, sq.Comment)
+				} else {
+					fmt.Println(name)
+				}
+			}
+			return
+		}
+		var text string
+		if name, _ := cmd.Flags().GetString("saved"); name != "" {
+			if len(args) > 0 || cmd.Flags().Changed("file") {
+				log.Fatal("Give either a query or --saved, not both.")
+			}
+			sq, err := readSavedQuery(snap, name)
+			if err != nil {
+				log.Fatal(err)
+			}
+			text = sq.Text
+		} else if text, err = readQueryArg(cmd, args); err != nil {
+			log.Fatalf("Failed to read query: %v", err)
+		}
+		q, err := sparql.Parse(text)
+		if err != nil {
+			log.Fatalf("Invalid query: %v", err)
+		}
+		format, _ := cmd.Flags().GetString("format")
+		if format == "" {
+			format = "tsv"
+			if q.Form == "CONSTRUCT" {
+				format = "nquads"
+			}
+		}
+		if !validQueryFormat(q.Form, format) {
+			log.Fatalf("Unknown format %q for %s (use %s).", format, q.Form, queryFormatNames(q.Form))
+		}
+		params, _ := cmd.Flags().GetStringArray("param")
+		bindings, err := parseQueryParams(q, params)
+		if err != nil {
+			log.Fatal(err)
+		}
+		src := newSnapshotSource(snap)
+
+		if explain, _ := cmd.Flags().GetBool("explain"); explain {
+			fmt.Printf("Plan at %s:\n%s", shortHash(snap.hash), sparql.Explain(q, src))
+			fmt.Println("Statistics:")
+			for _, line := range src.describeStats() {
+				fmt.Println("  " + line)
+			}
+			return
+		}
+		limits, err := configQueryLimits(sparql.Limits{})
+		if err != nil {
+			log.Fatalf("Failed to load config: %v", err)
+		}
+		timeout, _ := cmd.Flags().GetString("timeout")
+		maxRows, _ := cmd.Flags().GetString("max-rows")
+		maxMemory, _ := cmd.Flags().GetString("max-memory")
+		if limits, err = applyQueryLimits(limits, timeout, maxRows, maxMemory, false); err != nil {
+			log.Fatal(err)
+		}
+		service, err := configServiceFunc()
+		if err != nil {
+			log.Fatalf("Failed to load config: %v", err)
+		}
+		ctx := commandContext(cmd)
+		res, err := sparql.Evaluate(ctx, q, src, sparql.Options{Functions: src.historyFunctions(), Bindings: bindings, Service: service, Limits: limits})
+		exitIfInterrupted(ctx, "the query was stopped")
+		if err != nil {
+			log.Fatalf("Query failed: %v", err)
+		}
+		out := bufio.NewWriter(os.Stdout)
+		if err := writeQueryResult(out, res, format); err != nil {
+			log.Fatal(err)
+		}
+		if err := out.Flush(); err != nil {
+			log.Fatalf("Failed to write results: %v", err)
+		}
+	},
+}
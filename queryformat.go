@@ -0,0 +1,308 @@
+// queryformat.go
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/mannyrivera2010/go-quadgit/internal/sparql"
+	"github.com/mannyrivera2010/go-quadgit/pkg/rdfio"
+)
+
+// A resultFormat is a serialization of SELECT and ASK results.
+type resultFormat struct {
+	name      string
+	mediaType string
+	write     func(w io.Writer, res *sparql.Result) error
+}
+
+// resultFormats are listed in order of preference, which breaks ties
+// between equally acceptable types.
+var resultFormats = []resultFormat{
+	{"json", "application/sparql-results+json", writeResultsJSON},
+	{"xml", "application/sparql-results+xml", writeResultsXML},
+	{"csv", "text/csv", writeResultsCSV},
+	{"tsv", "text/tab-separated-values", writeResultsTSV},
+}
+
+// resultAliases are other media types clients send for the formats above.
+var resultAliases = map[string]string{
+	"application/json": "application/sparql-results+json",
+	"application/xml":  "application/sparql-results+xml",
+	"text/xml":         "application/sparql-results+xml",
+}
+
+// constructFormats name the graph formats for CONSTRUCT results.
+var constructFormats = map[string]string{
+	"nquads":   "application/n-quads",
+	"ntriples": "application/n-triples",
+	"turtle":   "text/turtle",
+	"trig":     "application/trig",
+	"jsonld":   "application/ld+json",
+}
+
+// lookupResultFormat returns the result format with a name, or nil.
+func lookupResultFormat(name string) *resultFormat {
+	for i := range resultFormats {
+		if resultFormats[i].name == name {
+			return &resultFormats[i]
+		}
+	}
+	return nil
+}
+
+// negotiateResultFormat picks the most acceptable result format for an
+// Accept header, or nil if none is.
+func negotiateResultFormat(accept string) *resultFormat {
+	if strings.TrimSpace(accept) == "" {
+		accept = "*/*"
+	}
+	var best *resultFormat
+	bestQ := 0.0
+	for i := range resultFormats {
+		f := &resultFormats[i]
+		if q := acceptQualityWith(accept, f.mediaType, resultAliases); q > bestQ {
+			best, bestQ = f, q
+		}
+	}
+	return best
+}
+
+// queryFormatNames lists the --format values for a query form.
+func queryFormatNames(form string) string {
+	if form == "CONSTRUCT" {
+		return "nquads, ntriples, turtle, trig, jsonld"
+	}
+	names := make([]string, len(resultFormats))
+	for i, f := range resultFormats {
+		names[i] = f.name
+	}
+	return strings.Join(names, ", ")
+}
+
+// validQueryFormat reports whether a --format value suits a query form.
+func validQueryFormat(form, name string) bool {
+	if form == "CONSTRUCT" {
+		return lookupFormat(constructFormats[name]) != nil
+	}
+	return lookupResultFormat(name) != nil
+}
+
+// writeQueryResult writes a result in the named format: one of
+// resultFormats for SELECT and ASK, or of constructFormats for CONSTRUCT.
+func writeQueryResult(w io.Writer, res *sparql.Result, format string) error {
+	if res.Form == "CONSTRUCT" {
+		f := lookupFormat(constructFormats[format])
+		if f == nil {
+			return fmt.Errorf("unknown format %q for CONSTRUCT (use %s)", format, queryFormatNames(res.Form))
+		}
+		return f.write(w, res.Quads)
+	}
+	f := lookupResultFormat(format)
+	if f == nil {
+		return fmt.Errorf("unknown format %q for %s (use %s)", format, res.Form, queryFormatNames(res.Form))
+	}
+	return f.write(w, res)
+}
+
+// serveQueryResult answers with a result in the format the request's
+// format parameter names, or else the most acceptable one.
+func serveQueryResult(w http.ResponseWriter, r *http.Request, res *sparql.Result) {
+	name := r.URL.Query().Get("format")
+	if res.Form == "CONSTRUCT" {
+		if name == "" {
+			serveQuads(w, r, res.Quads, anyFormat)
+			return
+		}
+		f := lookupFormat(constructFormats[name])
+		if f == nil {
+			http.Error(w, fmt.Sprintf("unknown format %q; available: %s", name, queryFormatNames(res.Form)), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", f.mediaType)
+		f.write(w, res.Quads)
+		return
+	}
+	f := lookupResultFormat(name)
+	if name == "" {
+		w.Header().Add("Vary", "Accept")
+		if f = negotiateResultFormat(r.Header.Get("Accept")); f == nil {
+			types := make([]string, len(resultFormats))
+			for i, rf := range resultFormats {
+				types[i] = rf.mediaType
+			}
+			http.Error(w, "not acceptable; available: "+strings.Join(types, ", "), http.StatusNotAcceptable)
+			return
+		}
+	} else if f == nil {
+		http.Error(w, fmt.Sprintf("unknown format %q; available: %s", name, queryFormatNames(res.Form)), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", f.mediaType)
+	f.write(w, res)
+}
+
+// sparqlTerm is a term in the SPARQL JSON results format.
+type sparqlTerm struct {
+	Type     string `json:"type"`
+	Value    string `json:"value"`
+	Lang     string `json:"xml:lang,omitempty"`
+	Datatype string `json:"datatype,omitempty"`
+}
+
+func toSPARQLTerm(term string) sparqlTerm {
+	switch {
+	case strings.HasPrefix(term, "<"):
+		return sparqlTerm{Type: "uri", Value: strings.Trim(term, "<>")}
+	case strings.HasPrefix(term, "_:"):
+		return sparqlTerm{Type: "bnode", Value: term[2:]}
+	}
+	lexical, lang, datatype, err := rdfio.LiteralParts(term)
+	if err != nil {
+		return sparqlTerm{Type: "literal", Value: term}
+	}
+	return sparqlTerm{Type: "literal", Value: lexical, Lang: lang, Datatype: datatype}
+}
+
+func writeResultsJSON(w io.Writer, res *sparql.Result) error {
+	doc := map[string]interface{}{"head": map[string]interface{}{}}
+	if res.Form == "ASK" {
+		doc["boolean"] = res.Boolean
+	} else {
+		doc["head"] = map[string]interface{}{"vars": res.Vars}
+		bindings := make([]map[string]sparqlTerm, 0, len(res.Solutions))
+		for _, sol := range res.Solutions {
+			b := make(map[string]sparqlTerm, len(sol))
+			for _, v := range res.Vars {
+				if t, ok := sol[v]; ok {
+					b[v] = toSPARQLTerm(t)
+				}
+			}
+			bindings = append(bindings, b)
+		}
+		doc["results"] = map[string]interface{}{"bindings": bindings}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(doc)
+}
+
+func writeResultsXML(w io.Writer, res *sparql.Result) error {
+	var b strings.Builder
+	text := func(s string) {
+		xml.EscapeText(&b, []byte(s))
+	}
+	attr := func(name, value string) {
+		b.WriteString(" " + name + `="`)
+		text(value)
+		b.WriteString(`"`)
+	}
+	b.WriteString("<?xml version=\"1.0\"?>\n<sparql xmlns=\"http://www.w3.org/2005/sparql-results#\">\n  <head>\n")
+	for _, v := range res.Vars {
+		b.WriteString("    <variable")
+		attr("name", v)
+		b.WriteString("/>\n")
+	}
+	b.WriteString("  </head>\n")
+	if res.Form == "ASK" {
+		fmt.Fprintf(&b, "  <boolean>%t</boolean>\n</sparql>\n", res.Boolean)
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+	b.WriteString("  <results>\n")
+	for _, sol := range res.Solutions {
+		b.WriteString("    <result>\n")
+		for _, v := range res.Vars {
+			term, ok := sol[v]
+			if !ok {
+				continue
+			}
+			b.WriteString("      <binding")
+			attr("name", v)
+			b.WriteString(">")
+			switch t := toSPARQLTerm(term); t.Type {
+			case "uri", "bnode":
+				b.WriteString("<" + t.Type + ">")
+				text(t.Value)
+				b.WriteString("</" + t.Type + ">")
+			default:
+				b.WriteString("<literal")
+				if t.Lang != "" {
+					attr("xml:lang", t.Lang)
+				}
+				if t.Datatype != "" {
+					attr("datatype", t.Datatype)
+				}
+				b.WriteString(">")
+				text(t.Value)
+				b.WriteString("</literal>")
+			}
+			b.WriteString("</binding>\n")
+		}
+		b.WriteString("    </result>\n")
+	}
+	b.WriteString("  </results>\n</sparql>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeResultsCSV writes plain values: IRIs without brackets and literals
+// as their lexical form, so language tags and datatypes are lost. An ASK
+// answer is written as true or false.
+func writeResultsCSV(w io.Writer, res *sparql.Result) error {
+	if res.Form == "ASK" {
+		_, err := fmt.Fprintf(w, "%t\r\n", res.Boolean)
+		return err
+	}
+	out := csv.NewWriter(w)
+	out.UseCRLF = true
+	out.Write(res.Vars)
+	for _, sol := range res.Solutions {
+		row := make([]string, len(res.Vars))
+		for i, v := range res.Vars {
+			if term, ok := sol[v]; ok {
+				t := toSPARQLTerm(term)
+				row[i] = t.Value
+				if t.Type == "bnode" {
+					row[i] = "_:" + t.Value
+				}
+			}
+		}
+		out.Write(row)
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// writeResultsTSV writes terms in N-Triples syntax under a header of
+// variable names. An ASK answer is written as true or false.
+func writeResultsTSV(w io.Writer, res *sparql.Result) error {
+	if res.Form == "ASK" {
+		_, err := fmt.Fprintf(w, "%t\n", res.Boolean)
+		return err
+	}
+	var b strings.Builder
+	for i, v := range res.Vars {
+		if i > 0 {
+			b.WriteByte('\t')
+		}
+		b.WriteString("?" + v)
+	}
+	b.WriteByte('\n')
+	for _, sol := range res.Solutions {
+		for i, v := range res.Vars {
+			if i > 0 {
+				b.WriteByte('\t')
+			}
+			b.WriteString(strings.ReplaceAll(sol[v], "\t", `\t`))
+		}
+		b.WriteByte('\n')
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
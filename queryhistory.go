@@ -0,0 +1,267 @@
+// queryhistory.go
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mannyrivera2010/go-quadgit/internal/sparql"
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+	"github.com/mannyrivera2010/go-quadgit/pkg/rdfio"
+)
+
+// Queries reach into history through names under historyNS. The graphs
+//
+//	<urn:quadgit:at:REV>          every triple of REV
+//	<urn:quadgit:added:A..B>      the triples B has that A does not
+//	<urn:quadgit:removed:A..B>    the triples A has that B does not
+//
+// can be named in GRAPH like stored graphs, though GRAPH ?g never ranges
+// over them. Each merges all of a commit's graphs. The functions
+//
+//	<urn:quadgit:fn:assertedIn>(?s, ?p, ?o [, ?g])
+//	<urn:quadgit:fn:commitTime>(?c)
+//	<urn:quadgit:fn:commitAuthor>(?c)
+//	<urn:quadgit:fn:commitMessage>(?c)
+//
+// bind the commit that asserted a triple of the queried commit, as
+// <urn:quadgit:commit:HASH>, and describe a commit.
+const (
+	historyNS     = "urn:quadgit:"
+	commitIRIBase = historyNS + "commit:"
+	historyFnBase = historyNS + "fn:"
+)
+
+// isHistoryGraph reports whether a query graph names a history graph.
+func isHistoryGraph(graph string) bool {
+	return strings.HasPrefix(graph, "<"+historyNS) && !strings.HasPrefix(graph, "<"+historyFnBase)
+}
+
+// commitTerm names a commit in query results.
+func commitTerm(hash string) string {
+	return "<" + commitIRIBase + hash + ">"
+}
+
+// historyIndex returns the triples of a history graph, computing them the
+// first time a query reads the graph.
+func (s *snapshotSource) historyIndex(graph string) (*graphIndex, error) {
+	s.mu.Lock()
+	idx, ok := s.history[graph]
+	s.mu.Unlock()
+	if ok {
+		return idx, nil
+	}
+	name := strings.TrimSuffix(strings.TrimPrefix(graph, "<"+historyNS), ">")
+	kind, rev, _ := strings.Cut(name, ":")
+	var quads []quadstore.Quad
+	var err error
+	switch kind {
+	case "at":
+		quads, err = historyDiff("", rev, graph)
+	case "added", "removed":
+		from, to, ok := strings.Cut(rev, "..")
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("history graph %s: expected %s:A..B", graph, kind)
+		}
+		if kind == "removed" {
+			from, to = to, from
+		}
+		quads, err = historyDiff(from, to, graph)
+	case "commit":
+		return nil, fmt.Errorf("history graph %s: use <%sat:REV> to read a commit", graph, historyNS)
+	default:
+		return nil, fmt.Errorf("unknown history graph %s", graph)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("history graph %s: %w", graph, err)
+	}
+	idx = newGraphIndex(quads)
+	s.mu.Lock()
+	s.history[graph] = idx
+	s.mu.Unlock()
+	return idx, nil
+}
+
+// historyDiff returns the triples in some graph of revision to that are
+// not in the same graph of revision from, or all of to's triples if from
+// is empty, once each and named as graph.
+func historyDiff(from, to, graph string) ([]quadstore.Quad, error) {
+	newer, err := openSnapshot(to)
+	if err != nil {
+		return nil, err
+	}
+	var older *snapshot
+	if from != "" {
+		if older, err = openSnapshot(from); err != nil {
+			return nil, err
+		}
+	}
+	var quads []quadstore.Quad
+	seen := make(map[quadstore.Quad]bool)
+	for _, name := range newer.graphNames() {
+		blobHash := newer.graphs[name]
+		var before *graphIndex
+		if older != nil {
+			if old, ok := older.graphs[name]; ok {
+				if old == blobHash {
+					continue
+				}
+				if before, err = readGraphIndex(old, ""); err != nil {
+					return nil, err
+				}
+			}
+		}
+		after, err := readGraphIndex(blobHash, graph)
+		if err != nil {
+			return nil, err
+		}
+		for _, q := range after.quads {
+			if !seen[q] && (before == nil || !before.contains(q.Subject, q.Predicate, q.Object)) {
+				seen[q] = true
+				quads = append(quads, q)
+			}
+		}
+	}
+	return quads, nil
+}
+
+// estimateHistory estimates a pattern over a history graph. The graph is
+// computed to count it, which the query would do anyway.
+func (s *snapshotSource) estimateHistory(subj, pred, obj, graph string) sparql.Access {
+	access := sparql.Access{Method: "history graph " + graph}
+	s.mu.Lock()
+	st, ok := s.stats[graph]
+	s.mu.Unlock()
+	if !ok {
+		idx, err := s.historyIndex(graph)
+		if err != nil {
+			return access
+		}
+		st = computeGraphStats(idx.quads)
+		s.mu.Lock()
+		s.stats[graph] = st
+		s.mu.Unlock()
+	}
+	access.Rows = estimateRows(st, subj, pred, obj)
+	return access
+}
+
+// historyFunctions returns the query functions over the history of the
+// source's commit.
+func (s *snapshotSource) historyFunctions() map[string]sparql.Function {
+	fn := func(name string) string { return "<" + historyFnBase + name + ">" }
+	return map[string]sparql.Function{
+		fn("assertedIn"): s.assertedIn,
+		fn("commitTime"): commitFunction(func(c *Commit) string {
+			return rdfio.QuoteString(c.Timestamp.UTC().Format(time.RFC3339)) + "^^<http://www.w3.org/2001/XMLSchema#dateTime>"
+		}),
+		fn("commitAuthor"):  commitFunction(func(c *Commit) string { return rdfio.QuoteString(c.Author) }),
+		fn("commitMessage"): commitFunction(func(c *Commit) string { return rdfio.QuoteString(c.Message) }),
+	}
+}
+
+// commitFunction makes a function of one commit term.
+func commitFunction(describe func(*Commit) string) sparql.Function {
+	return func(args []string) (string, error) {
+		if len(args) != 1 {
+			return "", fmt.Errorf("expected one commit")
+		}
+		hash, ok := strings.CutPrefix(strings.Trim(args[0], "<>"), commitIRIBase)
+		if !ok || !strings.HasPrefix(args[0], "<") {
+			return "", fmt.Errorf("not a commit: %s", args[0])
+		}
+		commit, err := readCommit(hash)
+		if err != nil {
+			return "", err
+		}
+		return describe(commit), nil
+	}
+}
+
+// assertedIn returns the commit that last added a triple of the source's
+// commit, following first-parent history like blame: the oldest commit of
+// the run of versions holding it that ends at the queried commit. With a
+// graph argument only that graph is considered, otherwise the first graph
+// holding the triple.
+func (s *snapshotSource) assertedIn(args []string) (string, error) {
+	if len(args) != 3 && len(args) != 4 {
+		return "", fmt.Errorf("assertedIn expects a subject, predicate, object and optional graph")
+	}
+	subj, pred, obj := args[0], args[1], args[2]
+	graphs := s.snap.graphNames()
+	if len(args) == 4 {
+		graphs = []string{args[3]}
+	}
+	for _, stored := range graphs {
+		if _, ok := s.snap.graphs[stored]; !ok {
+			continue
+		}
+		idx, err := s.index(stored)
+		if err != nil {
+			return "", err
+		}
+		if !idx.contains(subj, pred, obj) {
+			continue
+		}
+		versions, err := s.graphHistory(stored)
+		if err != nil {
+			return "", err
+		}
+		for i, v := range versions {
+			if i+1 == len(versions) {
+				return commitTerm(v.Commit), nil
+			}
+			older := versions[i+1]
+			if older.Blob == "" {
+				return commitTerm(v.Commit), nil
+			}
+			prev, err := s.blobIndex(older.Blob)
+			if err != nil {
+				return "", err
+			}
+			if !prev.contains(subj, pred, obj) {
+				return commitTerm(v.Commit), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("triple not asserted at %s", shortHash(s.snap.hash))
+}
+
+// graphHistory returns the versions of a stored graph along first-parent
+// history, newest first, following renames.
+func (s *snapshotSource) graphHistory(stored string) ([]graphVersion, error) {
+	s.mu.Lock()
+	versions, ok := s.versions[stored]
+	s.mu.Unlock()
+	if ok {
+		return versions, nil
+	}
+	versions, err := followGraph(s.snap.hash, stored)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.versions[stored] = versions
+	s.mu.Unlock()
+	return versions, nil
+}
+
+// blobIndex returns the index of a historical graph blob, loading it once.
+func (s *snapshotSource) blobIndex(blobHash string) (*graphIndex, error) {
+	key := "blob:" + blobHash
+	s.mu.Lock()
+	idx, ok := s.history[key]
+	s.mu.Unlock()
+	if ok {
+		return idx, nil
+	}
+	idx, err := readGraphIndex(blobHash, "")
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.history[key] = idx
+	s.mu.Unlock()
+	return idx, nil
+}
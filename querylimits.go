@@ -0,0 +1,128 @@
+// querylimits.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mannyrivera2010/go-quadgit/internal/sparql"
+)
+
+// serveQueryLimits apply to queries served over HTTP when the repository
+// configures none, so that one bad query cannot exhaust the server.
+var serveQueryLimits = sparql.Limits{Timeout: 30 * time.Second, MaxRows: 1000000, MaxMemory: 512 << 20}
+
+// configQueryLimits reads the query.timeout, query.maxRows and
+// query.maxMemory options over defaults.
+func configQueryLimits(defaults sparql.Limits) (sparql.Limits, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return defaults, err
+	}
+	return applyQueryLimits(defaults, cfg.Get("query.timeout"), cfg.Get("query.maxRows"), cfg.Get("query.maxMemory"), false)
+}
+
+// applyQueryLimits sets the limits given as strings, leaving those that
+// are empty. With tighten, a limit may only be lowered: a request cannot
+// lift the limits its server enforces.
+func applyQueryLimits(l sparql.Limits, timeout, maxRows, maxMemory string, tighten bool) (sparql.Limits, error) {
+	lower := func(old, new int64) bool { return !tighten || old == 0 || new > 0 && new < old }
+	if timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil || d < 0 {
+			return l, fmt.Errorf("invalid timeout %q", timeout)
+		}
+		if lower(int64(l.Timeout), int64(d)) {
+			l.Timeout = d
+		}
+	}
+	if maxRows != "" {
+		n, err := strconv.Atoi(maxRows)
+		if err != nil || n < 0 {
+			return l, fmt.Errorf("invalid row limit %q", maxRows)
+		}
+		if lower(int64(l.MaxRows), int64(n)) {
+			l.MaxRows = n
+		}
+	}
+	if maxMemory != "" {
+		n, err := parseByteSize(maxMemory)
+		if err != nil {
+			return l, err
+		}
+		if lower(l.MaxMemory, n) {
+			l.MaxMemory = n
+		}
+	}
+	return l, nil
+}
+
+// parseByteSize reads a byte count with an optional binary unit: 1048576,
+// 1024K, 1M and 1MiB are the same.
+func parseByteSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		shift  uint
+	}{{"KIB", 10}, {"MIB", 20}, {"GIB", 30}, {"K", 10}, {"M", 20}, {"G", 30}, {"B", 0}}
+	num, shift := strings.ToUpper(strings.TrimSpace(s)), uint(0)
+	for _, u := range units {
+		if strings.HasSuffix(num, u.suffix) {
+			num, shift = strings.TrimSpace(strings.TrimSuffix(num, u.suffix)), u.shift
+			break
+		}
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n << shift, nil
+}
+
+// requestQueryLimits returns the limits of a query request: the
+// configured ones, tightened by its timeout, maxRows and maxMemory
+// parameters.
+func requestQueryLimits(r *http.Request) (sparql.Limits, error) {
+	l, err := configQueryLimits(serveQueryLimits)
+	if err != nil {
+		return l, err
+	}
+	p := r.URL.Query()
+	return applyQueryLimits(l, p.Get("timeout"), p.Get("maxRows"), p.Get("maxMemory"), true)
+}
+
+// queryError answers a failed query. A query stopped by a limit gets 503
+// and a JSON description of how far it got, so that clients can tell an
+// incomplete evaluation from an empty answer and retry with a narrower
+// query.
+func queryError(w http.ResponseWriter, err error) {
+	var limit *sparql.LimitError
+	if !errors.As(err, &limit) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":     limit.Error(),
+		"limit":     limit.Limit,
+		"rows":      limit.Rows,
+		"memory":    limit.Memory,
+		"elapsedMs": limit.Elapsed.Milliseconds(),
+		"partial":   true,
+	})
+}
+
+// recoverQuery turns a panic in a query handler into a 500, logging it,
+// rather than letting it end the server.
+func recoverQuery(w http.ResponseWriter) {
+	if v := recover(); v != nil {
+		log.Printf("query panicked: %v", v)
+		http.Error(w, "internal error evaluating query", http.StatusInternalServerError)
+	}
+}
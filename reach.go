@@ -0,0 +1,439 @@
+// reach.go
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/mannyrivera2010/go-quadgit/internal/bitmap"
+	"github.com/spf13/cobra"
+)
+
+// The reachability index numbers commits densely, parents before children,
+// and stores for some commits a bitmap of the numbers of every commit
+// reachable from them. Walks stop at the first commit with a bitmap, so
+// reachability questions about long histories read a few keys instead of
+// every commit. Commits are immutable, so the index is never wrong, only
+// incomplete: commits made since it was last updated (by optimize) are
+// walked as usual.
+const (
+	reachIDPrefix     = "reach:id:"     // <hash> to 4-byte big-endian number
+	reachHashPrefix   = "reach:hash:"   // 4-byte big-endian number to <hash>
+	reachBitmapPrefix = "reach:bitmap:" // <hash> to bitmap.Bitmap encoding
+)
+
+// bitmapInterval is how many newly numbered commits go without a bitmap;
+// ref tips always get one.
+const bitmapInterval = 256
+
+func reachIDKey(hash string) []byte { return []byte(reachIDPrefix + hash) }
+
+func reachHashKey(id uint32) []byte {
+	return binary.BigEndian.AppendUint32([]byte(reachHashPrefix), id)
+}
+
+// hasBitmaps caches whether any bitmap has been written.
+var hasBitmaps *bool
+
+// reachIndexed reports whether the repository has a reachability index that
+// can be used: replace references change ancestry, so it is ignored while
+// they are honored.
+func reachIndexed() bool {
+	if replacementsActive() {
+		return false
+	}
+	if hasBitmaps == nil {
+		found := false
+		repo.db.View(func(txn *badger.Txn) error {
+			opts := badger.DefaultIteratorOptions
+			opts.PrefetchValues = false
+			opts.Prefix = []byte(reachBitmapPrefix)
+			it := txn.NewIterator(opts)
+			defer it.Close()
+			it.Rewind()
+			found = it.Valid()
+			return nil
+		})
+		hasBitmaps = &found
+	}
+	return *hasBitmaps
+}
+
+// reachBitmap returns the numbers of the indexed commits reachable from tips,
+// and separately the hashes of reachable commits that are not numbered yet.
+func reachBitmap(tips []string) (*bitmap.Bitmap, map[string]bool, error) {
+	reach := &bitmap.Bitmap{}
+	unindexed := make(map[string]bool)
+	err := repo.db.View(func(txn *badger.Txn) error {
+		seen := make(map[string]bool)
+		queue := append([]string{}, tips...)
+		for len(queue) > 0 {
+			h := queue[0]
+			queue = queue[1:]
+			if seen[h] {
+				continue
+			}
+			seen[h] = true
+			if item, err := txn.Get([]byte(reachBitmapPrefix + h)); err == nil {
+				var bm bitmap.Bitmap
+				if err := item.Value(bm.UnmarshalBinary); err != nil {
+					return err
+				}
+				reach.Or(&bm)
+				continue
+			} else if err != badger.ErrKeyNotFound {
+				return err
+			}
+			if item, err := txn.Get(reachIDKey(h)); err == nil {
+				var id uint32
+				err := item.Value(func(val []byte) error {
+					id = binary.BigEndian.Uint32(val)
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+				if reach.Has(id) {
+					continue
+				}
+				reach.Set(id)
+			} else if err == badger.ErrKeyNotFound {
+				unindexed[h] = true
+			} else {
+				return err
+			}
+			commit, err := readOriginalCommit(h)
+			if err != nil {
+				return err
+			}
+			queue = append(queue, commit.Parents...)
+		}
+		return nil
+	})
+	return reach, unindexed, err
+}
+
+// reachHashes returns the hashes of the commits numbered in reach.
+func reachHashes(reach *bitmap.Bitmap) (map[string]bool, error) {
+	hashes := make(map[string]bool, reach.Count())
+	err := repo.db.View(func(txn *badger.Txn) error {
+		var err error
+		reach.ForEach(func(id uint32) {
+			if err != nil {
+				return
+			}
+			var item *badger.Item
+			if item, err = txn.Get(reachHashKey(id)); err == nil {
+				err = item.Value(func(val []byte) error {
+					hashes[string(val)] = true
+					return nil
+				})
+			}
+		})
+		return err
+	})
+	return hashes, err
+}
+
+// updateReachabilityIndex numbers every commit reachable from a reference
+// that is not numbered yet and writes bitmaps for reference tips and every
+// bitmapInterval-th new commit. It returns how many commits and bitmaps it
+// added.
+func updateReachabilityIndex() (commits, bitmaps int, err error) {
+	defer suspendReplacements()()
+	refs, err := listReferences("")
+	if err != nil {
+		return 0, 0, err
+	}
+	tips := make(map[string]bool)
+	for _, value := range refs {
+		if strings.HasPrefix(value, "ref:") {
+			continue
+		}
+		if commit, err := peelTag(value); err == nil {
+			tips[commit] = true
+		}
+	}
+
+	var next uint32
+	numbered := func(hash string) (bool, error) {
+		found := false
+		err := repo.db.View(func(txn *badger.Txn) error {
+			_, err := txn.Get(reachIDKey(hash))
+			if err == nil {
+				found = true
+				return nil
+			} else if err == badger.ErrKeyNotFound {
+				return nil
+			}
+			return err
+		})
+		return found, err
+	}
+	err = repo.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = []byte(reachHashPrefix)
+		opts.Reverse = true
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		it.Seek(append([]byte(reachHashPrefix), 0xff, 0xff, 0xff, 0xff))
+		if it.Valid() {
+			next = binary.BigEndian.Uint32(it.Item().Key()[len(reachHashPrefix):]) + 1
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Number unnumbered commits in post-order, so parents come first.
+	var order []string
+	done := make(map[string]bool)
+	sortedTips := make([]string, 0, len(tips))
+	for tip := range tips {
+		sortedTips = append(sortedTips, tip)
+	}
+	sort.Strings(sortedTips)
+	for _, tip := range sortedTips {
+		type frame struct {
+			hash    string
+			parents []string
+		}
+		var stack []frame
+		push := func(hash string) error {
+			if done[hash] {
+				return nil
+			}
+			done[hash] = true
+			if ok, err := numbered(hash); err != nil || ok {
+				return err
+			}
+			commit, err := readCommit(hash)
+			if err != nil {
+				return err
+			}
+			stack = append(stack, frame{hash, append([]string{}, commit.Parents...)})
+			return nil
+		}
+		if err := push(tip); err != nil {
+			return 0, 0, err
+		}
+		for len(stack) > 0 {
+			top := &stack[len(stack)-1]
+			if len(top.parents) == 0 {
+				order = append(order, top.hash)
+				stack = stack[:len(stack)-1]
+				continue
+			}
+			parent := top.parents[0]
+			top.parents = top.parents[1:]
+			if err := push(parent); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+
+	wb := repo.db.NewWriteBatch()
+	defer wb.Cancel()
+	for i, hash := range order {
+		id := binary.BigEndian.AppendUint32(nil, next+uint32(i))
+		if err := wb.Set(reachIDKey(hash), id); err != nil {
+			return 0, 0, err
+		}
+		if err := wb.Set(reachHashKey(next+uint32(i)), []byte(hash)); err != nil {
+			return 0, 0, err
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		return 0, 0, err
+	}
+
+	// Bitmaps are written oldest first, so that each walk stops at the
+	// bitmap written before it.
+	for i, hash := range order {
+		if !tips[hash] && (i+1)%bitmapInterval != 0 {
+			continue
+		}
+		if err := writeReachBitmap(hash); err != nil {
+			return 0, 0, err
+		}
+		bitmaps++
+	}
+	for _, tip := range sortedTips {
+		if err := repo.db.View(func(txn *badger.Txn) error {
+			_, err := txn.Get([]byte(reachBitmapPrefix + tip))
+			return err
+		}); err == badger.ErrKeyNotFound {
+			if err := writeReachBitmap(tip); err != nil {
+				return 0, 0, err
+			}
+			bitmaps++
+		} else if err != nil {
+			return 0, 0, err
+		}
+	}
+	return len(order), bitmaps, nil
+}
+
+// writeReachBitmap stores the bitmap of a numbered commit whose ancestors
+// are all numbered.
+func writeReachBitmap(hash string) error {
+	reach, unindexed, err := reachBitmap([]string{hash})
+	if err != nil {
+		return err
+	}
+	if len(unindexed) > 0 {
+		return fmt.Errorf("commit %s has unnumbered ancestors", shortHash(hash))
+	}
+	data, err := reach.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	hasBitmaps = nil
+	return repo.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(reachBitmapPrefix+hash), data)
+	})
+}
+
+// revListSets resolves rev-list arguments into the commits to include and
+// exclude: "^<rev>" excludes, "<a>..<b>" includes b and excludes a.
+func revListSets(args []string) (include, exclude []string, err error) {
+	resolve := func(rev string) (string, error) {
+		hash, err := resolveCommitish(rev)
+		if err != nil {
+			return "", fmt.Errorf("could not resolve %s: %v", rev, err)
+		}
+		return hash, nil
+	}
+	for _, arg := range args {
+		if from, to, ok := strings.Cut(arg, ".."); ok {
+			if from == "" {
+				from = "HEAD"
+			}
+			if to == "" {
+				to = "HEAD"
+			}
+			a, err := resolve(from)
+			if err != nil {
+				return nil, nil, err
+			}
+			b, err := resolve(to)
+			if err != nil {
+				return nil, nil, err
+			}
+			exclude, include = append(exclude, a), append(include, b)
+			continue
+		}
+		if rev, ok := strings.CutPrefix(arg, "^"); ok {
+			hash, err := resolve(rev)
+			if err != nil {
+				return nil, nil, err
+			}
+			exclude = append(exclude, hash)
+			continue
+		}
+		hash, err := resolve(arg)
+		if err != nil {
+			return nil, nil, err
+		}
+		include = append(include, hash)
+	}
+	return include, exclude, nil
+}
+
+var revListCmd = &cobra.Command{
+	Use:   "rev-list <rev>... [^<rev>...]",
+	Short: "List or count the commits reachable from some commits but not others",
+	Long: `List the commits reachable from the given revisions, excluding those
+reachable from revisions prefixed with '^'; <a>..<b> is short for ^<a> <b>.
+With --count only their number is printed. The reachability index written
+by optimize makes this fast on long histories.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		include, exclude, err := revListSets(args)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var commits map[string]bool
+		count, _ := cmd.Flags().GetBool("count")
+		if reachIndexed() {
+			in, inExtra, err := reachBitmap(include)
+			if err != nil {
+				log.Fatalf("Failed to walk history: %v", err)
+			}
+			out, outExtra, err := reachBitmap(exclude)
+			if err != nil {
+				log.Fatalf("Failed to walk history: %v", err)
+			}
+			in.AndNot(out)
+			for h := range outExtra {
+				delete(inExtra, h)
+			}
+			if count {
+				fmt.Println(in.Count() + len(inExtra))
+				return
+			}
+			if commits, err = reachHashes(in); err != nil {
+				log.Fatalf("Failed to read reachability index: %v", err)
+			}
+			for h := range inExtra {
+				commits[h] = true
+			}
+		} else {
+			commits = make(map[string]bool)
+			excluded := make(map[string]bool)
+			for _, h := range exclude {
+				a, err := ancestors(h)
+				if err != nil {
+					log.Fatalf("Failed to walk history: %v", err)
+				}
+				for c := range a {
+					excluded[c] = true
+				}
+			}
+			for _, h := range include {
+				a, err := ancestors(h)
+				if err != nil {
+					log.Fatalf("Failed to walk history: %v", err)
+				}
+				for c := range a {
+					if !excluded[c] {
+						commits[c] = true
+					}
+				}
+			}
+			if count {
+				fmt.Println(len(commits))
+				return
+			}
+		}
+
+		// Newest first
+		type dated struct {
+			hash string
+			unix int64
+		}
+		list := make([]dated, 0, len(commits))
+		for h := range commits {
+			c, err := readCommit(h)
+			if err != nil {
+				log.Fatalf("Failed to read commit %s: %v", shortHash(h), err)
+			}
+			list = append(list, dated{h, c.Timestamp.UnixNano()})
+		}
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].unix != list[j].unix {
+				return list[i].unix > list[j].unix
+			}
+			return list[i].hash < list[j].hash
+		})
+		for _, d := range list {
+			fmt.Println(d.hash)
+		}
+	},
+}
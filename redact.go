@@ -0,0 +1,425 @@
+// redact.go
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+	"github.com/spf13/cobra"
+)
+
+// redactedDatatype marks a literal whose value 'redact' replaced by its
+// keyed hash. Such literals are no longer sensitive, and the same value
+// always hashes the same, so joins and counts over them still work.
+const redactedDatatype = "<urn:quadgit:redacted>"
+
+// sensitivePredicates returns the predicates the pii.predicates option
+// lists, a comma- or space-separated list of IRIs, plus extra.
+func sensitivePredicates(extra ...string) (map[string]bool, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	preds := make(map[string]bool)
+	fields := strings.FieldsFunc(cfg.Get("pii.predicates"), func(r rune) bool { return r == ',' || r == ' ' })
+	for _, p := range append(fields, extra...) {
+		preds["<"+strings.Trim(p, "<>")+">"] = true
+	}
+	return preds, nil
+}
+
+// sensitiveValue reports whether a quad line holds a value of a sensitive
+// predicate that has not been redacted yet.
+func sensitiveValue(line string, preds map[string]bool) (quadstore.Quad, bool) {
+	q, err := parseQuad(line)
+	if err != nil || !preds[q.Predicate] || !strings.HasPrefix(q.Object, `"`) {
+		return q, false
+	}
+	return q, !strings.HasSuffix(q.Object, "^^"+redactedDatatype)
+}
+
+// A sensitiveGuard stops an export from writing values of the sensitive
+// predicates. A nil guard allows everything.
+type sensitiveGuard struct {
+	preds map[string]bool
+}
+
+// exportGuard returns the guard an export command runs under: none with
+// --include-sensitive or when no predicate is sensitive.
+func exportGuard(cmd *cobra.Command) (*sensitiveGuard, error) {
+	if include, _ := cmd.Flags().GetBool("include-sensitive"); include {
+		return nil, nil
+	}
+	preds, err := sensitivePredicates()
+	if err != nil || len(preds) == 0 {
+		return nil, err
+	}
+	return &sensitiveGuard{preds}, nil
+}
+
+// checkLines fails if the lines of a graph hold sensitive values.
+func (g *sensitiveGuard) checkLines(graph string, lines []string) error {
+	if g == nil {
+		return nil
+	}
+	for _, line := range lines {
+		if q, ok := sensitiveValue(line, g.preds); ok {
+			return fmt.Errorf("graph %s holds values of the sensitive predicate %s; redact them with 'quad-db redact', or pass --include-sensitive to export them anyway", graph, q.Predicate)
+		}
+	}
+	return nil
+}
+
+// checkBlob is checkLines for a stored blob, read a line at a time.
+func (g *sensitiveGuard) checkBlob(graph, hash string) error {
+	if g == nil {
+		return nil
+	}
+	return streamBlobLines(hash, func(line string) error {
+		return g.checkLines(graph, []string{line})
+	})
+}
+
+// checkState fails if any graph of a state holds sensitive values.
+func (g *sensitiveGuard) checkState(state map[string]quadSet) error {
+	if g == nil {
+		return nil
+	}
+	for graph, set := range state {
+		lines := make([]string, 0, len(set))
+		for line := range set {
+			lines = append(lines, line)
+		}
+		if err := g.checkLines(graph, lines); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A redactor rewrites blobs so that no sensitive value survives: in hash
+// mode each value becomes an HMAC-SHA256 of it typed redactedDatatype, in
+// remove mode the quad is dropped. Everything else is kept as it was.
+type redactor struct {
+	preds  map[string]bool
+	remove bool
+	key    []byte
+
+	blobs     map[string]string // Original blob to rewritten blob
+	values    int               // Values redacted
+	rewritten []string          // Original blobs that held sensitive values
+	dirty     map[string]bool   // Original blobs and commits that change
+}
+
+// redactionKey returns the pii.hashKey option, generating and saving one
+// on first use. Keyed hashes cannot be reversed by hashing guesses, such
+// as every plausible email address, without the key.
+func redactionKey() ([]byte, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if k := cfg.Get("pii.hashKey"); k != "" {
+		return []byte(k), nil
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	k := hex.EncodeToString(buf)
+	cfg.Set("pii.hashKey", k)
+	if err := cfg.Save(); err != nil {
+		return nil, err
+	}
+	return []byte(k), nil
+}
+
+func (r *redactor) redactLine(line string) (string, bool) {
+	q, ok := sensitiveValue(line, r.preds)
+	if !ok {
+		return line, true
+	}
+	r.values++
+	if r.remove {
+		return "", false
+	}
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(literalValue(q.Object)))
+	q.Object = `"` + hex.EncodeToString(mac.Sum(nil)) + `"^^` + redactedDatatype
+	return formatQuad(q), true
+}
+
+// blob returns the redacted version of a blob, writing it unless dryRun.
+func (r *redactor) blob(hash string, dryRun bool) (string, error) {
+	if redacted, ok := r.blobs[hash]; ok {
+		return redacted, nil
+	}
+	blob, err := readBlob(hash)
+	if err != nil {
+		return "", err
+	}
+	out := make(Blob, 0, len(blob))
+	changed := false
+	for _, line := range blob {
+		redacted, keep := r.redactLine(line)
+		changed = changed || !keep || redacted != line
+		if keep {
+			out = append(out, redacted)
+		}
+	}
+	redacted := hash
+	if changed {
+		r.dirty[hash] = true
+		r.rewritten = append(r.rewritten, hash)
+		sort.Strings(out)
+		if len(out) == 0 {
+			redacted = "" // The graph goes away, as empty graphs do
+		} else if !dryRun {
+			if redacted, err = writeObject(out); err != nil {
+				return "", err
+			}
+		}
+	}
+	r.blobs[hash] = redacted
+	return redacted, nil
+}
+
+// redactRefs rewrites the history of refs, the tips of which are given by
+// name, and returns the rewritten tip of each that changed. Commits whose
+// tree and parents stay the same keep their hash; the others lose their
+// signature, which no longer matches. An annotated tag of a rewritten
+// commit is replaced by a new, unsigned one of the rewritten commit.
+func (r *redactor) redactRefs(refs map[string]string, dryRun bool) (map[string]string, int, error) {
+	commits := make(map[string]string)
+	rewrittenCommits := 0
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		order, err := topoOrder(refs[name])
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s: %w", name, err)
+		}
+		for _, hash := range order {
+			if _, ok := commits[hash]; ok {
+				continue
+			}
+			commit, err := readCommit(hash)
+			if err != nil {
+				return nil, 0, err
+			}
+			graphs, err := readGraphs(commit.Tree)
+			if err != nil {
+				return nil, 0, err
+			}
+			changed := false
+			for graph, blob := range graphs {
+				if graphs[graph], err = r.blob(blob, dryRun); err != nil {
+					return nil, 0, err
+				}
+				if graphs[graph] == "" {
+					delete(graphs, graph)
+				}
+				changed = changed || r.dirty[blob]
+			}
+			parents := make([]string, len(commit.Parents))
+			for i, p := range commit.Parents {
+				parents[i] = commits[p]
+				changed = changed || r.dirty[p]
+			}
+			if changed {
+				r.dirty[hash] = true
+				rewrittenCommits++
+			}
+			if !changed || dryRun {
+				commits[hash] = hash
+				continue
+			}
+			rewritten := *commit
+			rewritten.Parents = parents
+			rewritten.Signature = ""
+			if rewritten.Tree, err = writeTree(graphs); err != nil {
+				return nil, 0, err
+			}
+			if r.remove && commit.Stats != nil {
+				before := map[string]quadSet{}
+				if len(parents) > 0 {
+					if before, err = loadState(parents[0]); err != nil {
+						return nil, 0, err
+					}
+				}
+				after, err := loadTreeState(context.Background(), rewritten.Tree, "")
+				if err != nil {
+					return nil, 0, err
+				}
+				if rewritten.Stats, err = computeStats(before, after); err != nil {
+					return nil, 0, err
+				}
+			}
+			if commits[hash], err = writeObject(rewritten); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	moved := make(map[string]string)
+	for name, tip := range refs {
+		target, changed, err := r.retarget(tip, commits, dryRun)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s: %w", name, err)
+		}
+		if changed {
+			moved[name] = target
+		}
+	}
+	return moved, rewrittenCommits, nil
+}
+
+// retarget returns what a ref pointing at hash must point at once commits
+// are rewritten as commits maps them, and whether that changed: the
+// rewritten commit, or for an annotated tag a copy of the tag, without its
+// signature, retargeted in turn.
+func (r *redactor) retarget(hash string, commits map[string]string, dryRun bool) (string, bool, error) {
+	tag, ok, err := readTag(hash)
+	if err != nil {
+		return "", false, err
+	}
+	if !ok {
+		return commits[hash], r.dirty[hash], nil
+	}
+	target, changed, err := r.retarget(tag.Object, commits, dryRun)
+	if err != nil || !changed || dryRun {
+		return hash, changed, err
+	}
+	rewritten := *tag
+	rewritten.Object, rewritten.Signature = target, ""
+	retagged, err := writeObject(rewritten)
+	return retagged, true, err
+}
+
+// purgeBlobs deletes blobs from the object store.
+func purgeBlobs(hashes []string) error {
+	wb := repo.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, hash := range hashes {
+		if err := wb.Delete([]byte("obj:" + hash)); err != nil {
+			return err
+		}
+		if err := wb.Delete([]byte(queryStatsPrefix + hash)); err != nil {
+			return err
+		}
+		if err := wb.Delete([]byte(graphSummaryPrefix + hash)); err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+var redactCmd = &cobra.Command{
+	Use:   "redact [--mode hash|remove] [--predicate <iri>]...",
+	Short: "Rewrite history to remove the values of sensitive predicates",
+	Long: `Rewrite the history of every branch, tag and remote-tracking branch so that
+no literal value of a sensitive predicate survives in it. The sensitive
+predicates are those listed in the pii.predicates option, as a comma- or
+space-separated list of IRIs, and any given with --predicate.
+
+With --mode hash, the default, each value is replaced by a keyed
+HMAC-SHA256 of it, typed <urn:quadgit:redacted>: the statements and the
+graph structure stay, and equal values still hash alike, but the values
+cannot be read back. The key is the pii.hashKey option, generated on first
+use; keep it secret. With --mode remove, the statements are dropped.
+
+Commits that change are rewritten, along with every commit after them, so
+their hashes change and their signatures are dropped; everyone else must
+fetch the rewritten branches, and remotes must be pushed to with
+care. The original blobs are deleted; 'quad-db optimize' then reclaims
+their space. Review what would change with --dry-run first.
+
+Exports ('export', 'export-git', 'graph-bundle export') refuse to write
+unredacted values of sensitive predicates unless given
+--include-sensitive.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		mode, _ := cmd.Flags().GetString("mode")
+		if mode != "hash" && mode != "remove" {
+			log.Fatalf("Unknown mode %q (want hash or remove)", mode)
+		}
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		extra, _ := cmd.Flags().GetStringArray("predicate")
+		preds, err := sensitivePredicates(extra...)
+		if err != nil {
+			log.Fatalf("Failed to load config: %v", err)
+		}
+		if len(preds) == 0 {
+			log.Fatal("No sensitive predicates; list them in pii.predicates or give --predicate.")
+		}
+		if staged, err := stagedChanges(); err != nil {
+			log.Fatalf("Failed to read the staging area: %v", err)
+		} else if staged {
+			log.Fatal("Commit or reset the staged changes before rewriting history.")
+		}
+		r := &redactor{preds: preds, remove: mode == "remove", blobs: make(map[string]string), dirty: make(map[string]bool)}
+		if !r.remove && !dryRun {
+			if r.key, err = redactionKey(); err != nil {
+				log.Fatalf("Failed to set up pii.hashKey: %v", err)
+			}
+		}
+
+		refs := make(map[string]string)
+		for _, prefix := range []string{"head:", "tag:", "remote:"} {
+			found, err := listReferences(prefix)
+			if err != nil {
+				log.Fatalf("Failed to list references: %v", err)
+			}
+			for name, hash := range found {
+				refs[name] = hash
+			}
+		}
+		moved, commits, err := r.redactRefs(refs, dryRun)
+		if err != nil {
+			log.Fatalf("Failed to rewrite history: %v", err)
+		}
+		if dryRun {
+			fmt.Printf("Would redact %d value(s) in %d blob(s), rewriting %d commit(s) and %d reference(s)\n", r.values, len(r.rewritten), commits, len(moved))
+			return
+		}
+		names := make([]string, 0, len(moved))
+		for name := range moved {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if err := swapReference(name, refs[name], moved[name]); err != nil {
+				log.Fatalf("Failed to update %s: %v", publicRefName(name), err)
+			}
+			fmt.Printf("%s: %s -> %s\n", publicRefName(name), shortHash(refs[name]), shortHash(moved[name]))
+		}
+		if err := purgeBlobs(r.rewritten); err != nil {
+			log.Fatalf("Failed to delete the original blobs: %v", err)
+		}
+		fmt.Printf("Redacted %d value(s) in %d blob(s), rewriting %d commit(s)\n", r.values, len(r.rewritten), commits)
+	},
+}
+
+// stagedChanges reports whether anything is staged.
+func stagedChanges() (bool, error) {
+	renames, err := readStagedRenames()
+	if err != nil || len(renames) > 0 {
+		return len(renames) > 0, err
+	}
+	info, err := os.Stat(indexPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil && info.Size() > 0, err
+}
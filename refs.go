@@ -0,0 +1,195 @@
+// refs.go
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/spf13/cobra"
+)
+
+// refsHeader starts every refs export, identifying the format version.
+const refsHeader = "# quad-db refs v1"
+
+// writeRefs writes every reference as a "<value> <name>" line, sorted by
+// name. Values are written as stored: a commit hash, or "ref:<target>" for
+// a symbolic reference such as HEAD.
+func writeRefs(w io.Writer) error {
+	refs, err := listReferences("")
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if _, err := fmt.Fprintln(w, refsHeader); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if _, err := fmt.Fprintf(w, "%s %s\n", refs[name], name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readRefs parses a refs export into name -> value.
+func readRefs(r io.Reader) (map[string]string, error) {
+	refs := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if n == 1 && line != refsHeader {
+			return nil, fmt.Errorf("not a refs export (missing %q header)", refsHeader)
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		value, name, ok := strings.Cut(line, " ")
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("line %d: expected \"<value> <name>\"", n)
+		}
+		if _, dup := refs[name]; dup {
+			return nil, fmt.Errorf("line %d: %s listed twice", n, name)
+		}
+		refs[name] = value
+	}
+	return refs, scanner.Err()
+}
+
+// displayRef abbreviates a reference value for messages.
+func displayRef(value string) string {
+	if strings.HasPrefix(value, "ref:") {
+		return value
+	}
+	return shortHash(value)
+}
+
+var refsCmd = &cobra.Command{
+	Use:   "refs",
+	Short: "Export or import all references",
+	Long: `Dump all references (branches, HEAD, sync checkpoints, ...) with their
+values in a stable text format, or load such a dump, for scripted
+mirroring and recovery of ref state independently of full backups.`,
+}
+
+var refsExportCmd = &cobra.Command{
+	Use:   "export [<file>]",
+	Short: "Write all references to a file or stdout",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		w := io.Writer(os.Stdout)
+		if len(args) == 1 && args[0] != "-" {
+			f, err := os.Create(args[0])
+			if err != nil {
+				log.Fatalf("Failed to create %s: %v", args[0], err)
+			}
+			defer f.Close()
+			w = f
+		}
+		if err := writeRefs(w); err != nil {
+			log.Fatalf("Failed to export refs: %v", err)
+		}
+	},
+}
+
+var refsImportCmd = &cobra.Command{
+	Use:   "import [<file>]",
+	Short: "Load references from a file or stdin",
+	Long: `Create or update the references listed in a refs export, all in one
+transaction. Commits a reference points to must already be present unless
+--allow-missing is given (e.g. when objects are restored separately). With
+--prune, local references missing from the export are deleted.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		r := io.Reader(os.Stdin)
+		if len(args) == 1 && args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				log.Fatalf("Failed to open %s: %v", args[0], err)
+			}
+			defer f.Close()
+			r = f
+		}
+		imported, err := readRefs(r)
+		if err != nil {
+			log.Fatalf("Failed to read refs: %v", err)
+		}
+		allowMissing, _ := cmd.Flags().GetBool("allow-missing")
+		prune, _ := cmd.Flags().GetBool("prune")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		for name, value := range imported {
+			if target, symbolic := strings.CutPrefix(value, "ref:"); symbolic {
+				if _, ok := imported[target]; !ok {
+					if _, err := getReference(target); err != nil && !allowMissing {
+						log.Fatalf("%s points to %s, which does not exist.", name, target)
+					}
+				}
+				continue
+			}
+			if _, err := peelTag(value); err != nil && !allowMissing {
+				log.Fatalf("%s points to %s, which is not in this repository (use --allow-missing).", name, value)
+			}
+		}
+		current, err := listReferences("")
+		if err != nil {
+			log.Fatalf("Failed to read refs: %v", err)
+		}
+
+		var changes []string
+		err = repo.db.Update(func(txn *badger.Txn) error {
+			for name, value := range imported {
+				old, exists := current[name]
+				switch {
+				case !exists:
+					changes = append(changes, fmt.Sprintf("create %s %s", name, displayRef(value)))
+				case old != value:
+					changes = append(changes, fmt.Sprintf("update %s %s..%s", name, displayRef(old), displayRef(value)))
+				default:
+					continue
+				}
+				if !dryRun {
+					if err := txn.Set([]byte("ref:"+name), []byte(value)); err != nil {
+						return err
+					}
+				}
+			}
+			if !prune {
+				return nil
+			}
+			for name, old := range current {
+				if _, keep := imported[name]; keep {
+					continue
+				}
+				changes = append(changes, fmt.Sprintf("delete %s %s", name, displayRef(old)))
+				if !dryRun {
+					if err := txn.Delete([]byte("ref:" + name)); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.Fatalf("Failed to import refs: %v", err)
+		}
+		sort.Slice(changes, func(i, j int) bool {
+			return strings.SplitN(changes[i], " ", 3)[1] < strings.SplitN(changes[j], " ", 3)[1]
+		})
+		for _, c := range changes {
+			fmt.Println(c)
+		}
+		if len(changes) == 0 {
+			fmt.Println("Refs already up to date.")
+		}
+	},
+}
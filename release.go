@@ -0,0 +1,338 @@
+// release.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Releases are annotated tags named by a semantic version, e.g. v1.4.0.
+// release.major and release.minor list rules, separated by commas, for
+// changes that call for at least that bump; any other change calls for a
+// patch. A rule is a kind of change and a graph scope, as in
+//
+//	release.major = delete:http://example.org/schema/*
+//	release.minor = add:http://example.org/schema/*, change:<http://example.org/vocab>
+//
+// where delete matches quads deleted from (or a rename away from) a graph in
+// scope, add quads added to one, and change either.
+
+// bumpLevels are the parts of a version, in increasing significance.
+var bumpLevels = []string{"patch", "minor", "major"}
+
+func bumpRank(level string) int {
+	for i, l := range bumpLevels {
+		if l == level {
+			return i
+		}
+	}
+	return -1
+}
+
+// A semver is a MAJOR.MINOR.PATCH version.
+type semver struct{ major, minor, patch int }
+
+// parseSemver parses a tag name as prefix followed by a version, reporting
+// false for names that are not release tags.
+func parseSemver(name, prefix string) (semver, bool) {
+	if !strings.HasPrefix(name, prefix) {
+		return semver{}, false
+	}
+	parts := strings.Split(strings.TrimPrefix(name, prefix), ".")
+	if len(parts) != 3 {
+		return semver{}, false
+	}
+	var n [3]int
+	for i, part := range parts {
+		v, err := strconv.Atoi(part)
+		if err != nil || v < 0 || strconv.Itoa(v) != part {
+			return semver{}, false
+		}
+		n[i] = v
+	}
+	return semver{n[0], n[1], n[2]}, true
+}
+
+func (v semver) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.major, v.minor, v.patch)
+}
+
+func (v semver) less(w semver) bool {
+	if v.major != w.major {
+		return v.major < w.major
+	}
+	if v.minor != w.minor {
+		return v.minor < w.minor
+	}
+	return v.patch < w.patch
+}
+
+// bump returns the next version at level.
+func (v semver) bump(level string) semver {
+	switch level {
+	case "major":
+		return semver{v.major + 1, 0, 0}
+	case "minor":
+		return semver{v.major, v.minor + 1, 0}
+	}
+	return semver{v.major, v.minor, v.patch + 1}
+}
+
+// releaseTagPrefix is what release tag names start with: release.tagPrefix,
+// or "v".
+func releaseTagPrefix() (string, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return "", err
+	}
+	if prefix, ok := cfg.Lookup("release.tagPrefix"); ok {
+		return prefix, nil
+	}
+	return "v", nil
+}
+
+// lastRelease finds the highest release tag on the history of head,
+// returning its name, version and commit; the name is "" if there is none.
+func lastRelease(head, prefix string) (name string, version semver, commit string, err error) {
+	tags, err := listReferences("tag:")
+	if err != nil {
+		return "", semver{}, "", err
+	}
+	reachable, err := ancestors(head)
+	if err != nil {
+		return "", semver{}, "", err
+	}
+	for ref, hash := range tags {
+		tagName := strings.TrimPrefix(ref, "tag:")
+		v, ok := parseSemver(tagName, prefix)
+		if !ok {
+			continue
+		}
+		target, err := peelTag(hash)
+		if err != nil || !reachable[target] {
+			continue
+		}
+		if name == "" || version.less(v) {
+			name, version, commit = tagName, v, target
+		}
+	}
+	return name, version, commit, nil
+}
+
+// A releaseRule calls for a bump when a kind of change touches a graph in
+// scope.
+type releaseRule struct {
+	level, kind, scope string
+}
+
+func (r releaseRule) String() string { return r.kind + ":" + r.scope }
+
+// loadReleaseRules reads release.major and release.minor.
+func loadReleaseRules() ([]releaseRule, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	var rules []releaseRule
+	for _, level := range []string{"major", "minor"} {
+		for _, spec := range strings.Split(cfg.Get("release."+level), ",") {
+			spec = strings.TrimSpace(spec)
+			if spec == "" {
+				continue
+			}
+			kind, scope, ok := strings.Cut(spec, ":")
+			if !ok || scope == "" || kind != "add" && kind != "delete" && kind != "change" {
+				return nil, fmt.Errorf("invalid release.%s rule %q (expected add:, delete: or change:<graph scope>)", level, spec)
+			}
+			rules = append(rules, releaseRule{level, kind, strings.Trim(scope, "<>")})
+		}
+	}
+	return rules, nil
+}
+
+// matches reports whether a diff of one graph breaks the rule.
+func (r releaseRule) matches(d graphDiff) bool {
+	inScope := func(graph string) bool {
+		return graph != "" && graphInScope(graph, r.scope)
+	}
+	added := len(d.Added) > 0 && inScope(d.Graph)
+	deleted := len(d.Deleted) > 0 && inScope(d.Graph) || d.RenamedFrom != "" && inScope(d.RenamedFrom)
+	switch r.kind {
+	case "add":
+		return added
+	case "delete":
+		return deleted
+	}
+	return added || deleted
+}
+
+// requiredBump returns the least level the diffs call for, with the rules
+// and graphs that call for it, or "" if nothing changed.
+func requiredBump(diffs []graphDiff, rules []releaseRule) (level string, reasons []string) {
+	if len(diffs) == 0 {
+		return "", nil
+	}
+	level = "patch"
+	for _, d := range diffs {
+		for _, r := range rules {
+			if !r.matches(d) {
+				continue
+			}
+			if bumpRank(r.level) > bumpRank(level) {
+				level, reasons = r.level, nil
+			}
+			if r.level == level {
+				reasons = append(reasons, fmt.Sprintf("%s (%s)", d.Graph, r))
+			}
+		}
+	}
+	return level, reasons
+}
+
+// releaseNotes renders the subjects of the commits since the last release,
+// newest first, and the change counts of each graph.
+func releaseNotes(name, previous, head, since string, diffs []graphDiff) (string, error) {
+	var released map[string]bool
+	if since != "" {
+		var err error
+		if released, err = ancestors(since); err != nil {
+			return "", err
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Release %s\n\n", name)
+	if previous != "" {
+		fmt.Fprintf(&b, "Changes since %s:\n\n", previous)
+	} else {
+		b.WriteString("Changes:\n\n")
+	}
+	err := walkCommits(head, func(hash string, commit *Commit) error {
+		if released[hash] {
+			return errStopWalk
+		}
+		subject := strings.SplitN(commit.Message, "\n", 2)[0]
+		if subject != "" {
+			fmt.Fprintf(&b, "- %s (%s)\n", subject, shortHash(hash))
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(diffs) > 0 {
+		b.WriteString("\nGraphs:\n\n")
+		for _, d := range diffs {
+			line := fmt.Sprintf("  +%d / -%d quads in %s", len(d.Added), len(d.Deleted), d.Graph)
+			if d.RenamedFrom != "" {
+				line += " (renamed from " + d.RenamedFrom + ")"
+			}
+			b.WriteString(line + "\n")
+		}
+	}
+	return b.String(), nil
+}
+
+var releaseCmd = &cobra.Command{
+	Use:   "release [major|minor|patch]",
+	Short: "Tag HEAD as the next semantic version of the dataset",
+	Long: `Tag HEAD as the next release: an annotated tag named release.tagPrefix
+(default "v") followed by a MAJOR.MINOR.PATCH version, one bump above the
+highest release tag on HEAD's history (or 0.0.0 if there is none).
+
+The changes since that release decide the least bump: release.major and
+release.minor list rules such as delete:http://example.org/schema/* (quads
+deleted from a graph in that scope), add:<scope> and change:<scope>, and
+any other change calls for a patch. Without an argument, that bump is
+made; asking for a smaller one fails unless --force is given.
+
+The tag's message, which is also printed, holds release notes: the
+subjects of the commits since the last release and each graph's change
+counts. --dry-run prints them and the version without tagging.`,
+	Args:      cobra.MaximumNArgs(1),
+	ValidArgs: bumpLevels,
+	Run: func(cmd *cobra.Command, args []string) {
+		head, err := resolveHead()
+		if err != nil {
+			log.Fatalf("Failed to resolve HEAD: %v", err)
+		}
+		prefix, err := releaseTagPrefix()
+		if err != nil {
+			log.Fatalf("Failed to load config: %v", err)
+		}
+		rules, err := loadReleaseRules()
+		if err != nil {
+			log.Fatal(err)
+		}
+		previous, version, since, err := lastRelease(head, prefix)
+		if err != nil {
+			log.Fatalf("Failed to find the last release: %v", err)
+		}
+
+		before := map[string]quadSet{}
+		if since != "" {
+			if before, err = loadState(since); err != nil {
+				log.Fatalf("Failed to read %s: %v", previous, err)
+			}
+		}
+		after, err := loadState(head)
+		if err != nil {
+			log.Fatalf("Failed to read HEAD: %v", err)
+		}
+		diffs, err := diffStates(context.Background(), before, after)
+		if err != nil {
+			log.Fatalf("Failed to compare with %s: %v", previous, err)
+		}
+		required, reasons := requiredBump(diffs, rules)
+		if required == "" {
+			log.Fatalf("Nothing changed since %s.", orInitial(previous))
+		}
+
+		level := required
+		if len(args) == 1 {
+			if level = args[0]; bumpRank(level) < 0 {
+				log.Fatalf("Unknown bump %q (want major, minor or patch).", level)
+			}
+			if force, _ := cmd.Flags().GetBool("force"); bumpRank(level) < bumpRank(required) && !force {
+				log.Fatalf("The changes since %s call for a %s release:\n  %s\nUse --force to release them as a %s one.", orInitial(previous), required, strings.Join(reasons, "\n  "), level)
+			}
+		}
+		name := prefix + version.bump(level).String()
+		if _, err := getReference("tag:" + name); err == nil {
+			log.Fatalf("Tag %s already exists.", name)
+		}
+		notes, err := releaseNotes(name, previous, head, since, diffs)
+		if err != nil {
+			log.Fatalf("Failed to write release notes: %v", err)
+		}
+		if extra, _ := cmd.Flags().GetString("message"); extra != "" {
+			notes = strings.TrimSpace(extra) + "\n\n" + notes
+		}
+
+		if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+			fmt.Printf("Would tag %s as %s (%s release).\n\n%s", shortHash(head), name, level, notes)
+			return
+		}
+		tag := Tag{Object: head, Type: "commit", Name: name, Tagger: commitAuthor(), Message: notes, Timestamp: clock.Now()}
+		hash, err := writeObject(tag)
+		if err != nil {
+			log.Fatalf("Failed to write tag: %v", err)
+		}
+		if err := swapReference("tag:"+name, "", hash); err != nil {
+			log.Fatalf("Failed to create tag %s: %v", name, err)
+		}
+		fmt.Printf("Tagged %s as %s (%s release).\n\n%s", shortHash(head), name, level, notes)
+	},
+}
+
+// orInitial names the last release in messages, or the start of history.
+func orInitial(previous string) string {
+	if previous == "" {
+		return "the start of history"
+	}
+	return previous
+}
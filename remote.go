@@ -0,0 +1,708 @@
+// remote.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mannyrivera2010/go-quadgit/internal/rpc"
+	"github.com/mannyrivera2010/go-quadgit/internal/transport"
+	"github.com/spf13/cobra"
+)
+
+// remoteURL resolves a configured remote name (remote.<name>.url) or a
+// literal URL to the URL of the remote repository.
+func remoteURL(name string) (string, error) {
+	if strings.Contains(name, "://") {
+		return strings.TrimSuffix(name, "/"), nil
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return "", err
+	}
+	url := cfg.Get("remote." + name + ".url")
+	if url == "" {
+		return "", fmt.Errorf("no remote named %s (add one with 'quad-db remote add')", name)
+	}
+	return strings.TrimSuffix(url, "/"), nil
+}
+
+// treeObjects maps every tree and blob under a root tree to its hash, keyed
+// by kind and graph path so that versions of one object in different
+// commits share a key.
+func treeObjects(root string) (map[string]string, error) {
+	objects := map[string]string{"tree:": root}
+	err := walkTree(root, func(path []string, entry TreeEntry) error {
+		key := strings.Join(path, "\x00")
+		if entry.Blob != "" {
+			objects["blob:"+key] = entry.Blob
+		}
+		if entry.Tree != "" {
+			objects["tree:"+key] = entry.Tree
+		}
+		return nil
+	})
+	return objects, err
+}
+
+// defaultRetries is how often a transient transfer failure is retried when
+// neither --retries nor transfer.retries says otherwise.
+const defaultRetries = 3
+
+// addTransferFlags adds the network tuning flags shared by clone, fetch and
+// push.
+func addTransferFlags(cmd *cobra.Command) {
+	cmd.Flags().Int("retries", defaultRetries, "Retry transient failures this many times, with backoff (config: transfer.retries)")
+	cmd.Flags().Duration("timeout", 0, "Give up on a single operation after this long, e.g. 5m (config: transfer.timeout; 0: never)")
+	cmd.Flags().String("limit-rate", "", "Cap bandwidth in bytes per second, e.g. 500k or 2m (config: transfer.limitRate)")
+}
+
+// transferOptions combines the transfer flags of cmd with the transfer.*
+// config keys, flags taking precedence. cmd may be nil.
+func transferOptions(cmd *cobra.Command) (transport.Options, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return transport.Options{}, err
+	}
+	setting := func(flag, key string) string {
+		if cmd != nil && cmd.Flags().Changed(flag) {
+			return cmd.Flags().Lookup(flag).Value.String()
+		}
+		return cfg.Get(key)
+	}
+	opts := transport.Options{
+		Retries: defaultRetries,
+		Notify:  func(msg string) { fmt.Fprintf(os.Stderr, "warning: %s\n", msg) },
+	}
+	if cmd != nil {
+		opts.Context = cmd.Context()
+	}
+	if v := setting("retries", "transfer.retries"); v != "" {
+		if opts.Retries, err = strconv.Atoi(v); err != nil || opts.Retries < 0 {
+			return opts, fmt.Errorf("invalid retry count %q", v)
+		}
+	}
+	if v := setting("timeout", "transfer.timeout"); v != "" {
+		if opts.Timeout, err = time.ParseDuration(v); err != nil {
+			return opts, fmt.Errorf("invalid timeout %q", v)
+		}
+	}
+	if v := setting("limit-rate", "transfer.limitRate"); v != "" {
+		if opts.LimitRate, err = transport.ParseRate(v); err != nil {
+			return opts, err
+		}
+	}
+	// The token is a secret, so the environment takes precedence over
+	// the config, which may be shared
+	if opts.Token = os.Getenv("QUAD_DB_TOKEN"); opts.Token == "" {
+		opts.Token = cfg.Get("transfer.token")
+	}
+	return opts, nil
+}
+
+// reachableFrom returns every commit reachable from any of hashes, which
+// may be annotated tags, ignoring hashes that are not in this repository.
+func reachableFrom(hashes []string) (map[string]bool, error) {
+	defer suspendReplacements()()
+	reachable := make(map[string]bool)
+	for _, hash := range hashes {
+		hash, err := peelTag(hash)
+		if err != nil || reachable[hash] {
+			continue
+		}
+		commits, err := ancestors(hash)
+		if err != nil {
+			return nil, err
+		}
+		for c := range commits {
+			reachable[c] = true
+		}
+	}
+	return reachable, nil
+}
+
+// packSummary describes the size of a pack for progress messages.
+func packSummary(objects []rpc.Object) string {
+	deltas, size := 0, 0
+	for _, obj := range objects {
+		if obj.Base != "" {
+			deltas++
+		}
+		size += len(obj.Data)
+	}
+	return fmt.Sprintf("%d objects (%d deltas), %.1f KiB", len(objects), deltas, float64(size)/1024)
+}
+
+// collectObjects gathers every commit reachable from tips but not in stop,
+// along with the trees and, unless omitBlobs, blobs those commits
+// reference. stop holds
+// commits the receiver has, so the objects of any stop commit that is a
+// parent of a collected one are left out, and a changed tree or blob is
+// sent as a delta against its version in the first parent when that is
+// much smaller. Bases are always objects the receiver has or that come
+// later in the pack, which rules out cycles.
+func collectObjects(stop map[string]bool, omitBlobs bool, tips ...string) ([]rpc.Object, error) {
+	defer suspendReplacements()() // Transfer the real history
+	var objects []rpc.Object
+	added := make(map[string]bool)
+	known := make(map[string]bool) // objects the receiver already has
+	add := func(hash, base string) error {
+		if added[hash] || known[hash] {
+			return nil
+		}
+		data, err := readRawObject(hash)
+		if err != nil {
+			return err
+		}
+		added[hash] = true
+		obj := rpc.Object{Hash: hash, Data: data}
+		if base != "" && base != hash && !added[base] {
+			if baseData, err := readRawObject(base); err == nil {
+				if delta := rpc.MakeDelta(baseData, data); len(delta) < len(data)/2 {
+					obj.Base, obj.Data = base, delta
+				}
+			}
+		}
+		objects = append(objects, obj)
+		return nil
+	}
+
+	// Tree listings are cached from when a commit is seen as a parent until
+	// it is collected itself.
+	listings := make(map[string]map[string]string)
+	listing := func(commit string) (map[string]string, error) {
+		if l, ok := listings[commit]; ok {
+			return l, nil
+		}
+		c, err := readCommit(commit)
+		if err != nil {
+			return nil, err
+		}
+		l, err := treeObjects(c.Tree)
+		if err != nil {
+			return nil, err
+		}
+		listings[commit] = l
+		return l, nil
+	}
+
+	queue := append([]string(nil), tips...)
+	for len(queue) > 0 {
+		h := queue[0]
+		queue = queue[1:]
+		if added[h] || stop[h] {
+			continue
+		}
+		commit, err := readCommit(h)
+		if err != nil {
+			return nil, err
+		}
+		own, err := listing(h)
+		if err != nil {
+			return nil, err
+		}
+		delete(listings, h)
+		var previous map[string]string
+		for i, parent := range commit.Parents {
+			l, err := listing(parent)
+			if err != nil {
+				return nil, err
+			}
+			if i == 0 {
+				previous = l
+			}
+			if stop[parent] {
+				for _, hash := range l {
+					known[hash] = true
+				}
+				delete(listings, parent)
+			}
+		}
+		keys := make([]string, 0, len(own))
+		for key := range own {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if omitBlobs && strings.HasPrefix(key, "blob:") {
+				continue
+			}
+			if err := add(own[key], previous[key]); err != nil {
+				return nil, err
+			}
+		}
+		if err := add(h, ""); err != nil {
+			return nil, err
+		}
+		queue = append(queue, commit.Parents...)
+	}
+	return objects, nil
+}
+
+// fetchRemote downloads the branches of the remote at url that are missing
+// locally and records them as remote-tracking refs remote:<name>/<branch>.
+// It returns the advertised branches, keyed by branch name.
+func fetchRemote(name, url string, opts transport.Options) (map[string]string, error) {
+	t, err := transport.Open(url, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer t.Close()
+	defer shareAlternate(name, t)()
+	adv, err := t.List()
+	if err != nil {
+		return nil, err
+	}
+
+	branches := make(map[string]string)
+	var wants []string
+	for ref, hash := range adv.Refs {
+		branch, ok := strings.CutPrefix(ref, "head:")
+		if !ok {
+			continue
+		}
+		branches[branch] = hash
+		if _, err := readCommit(hash); err != nil {
+			wants = append(wants, hash)
+		}
+	}
+	if len(wants) > 0 {
+		local, err := listReferences("head:")
+		if err != nil {
+			return nil, err
+		}
+		tracking, err := listReferences("remote:" + name + "/")
+		if err != nil {
+			return nil, err
+		}
+		seen := make(map[string]bool)
+		var haves []string
+		for _, refs := range []map[string]string{local, tracking} {
+			for _, hash := range refs {
+				if !seen[hash] {
+					seen[hash] = true
+					haves = append(haves, hash)
+				}
+			}
+		}
+		cfg, err := loadConfig()
+		if err != nil {
+			return nil, err
+		}
+		pack, err := t.Fetch(rpc.FetchRequest{Wants: wants, Haves: haves, Filter: cfg.Get("remote." + name + ".filter")})
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("Received %s\n", packSummary(pack.Objects))
+		if err := pack.Resolve(readRawObject); err != nil {
+			return nil, err
+		}
+		if err := pack.Verify(); err != nil {
+			return nil, err
+		}
+		if err := verifyPackGraphs(pack.Objects); err != nil {
+			return nil, err
+		}
+		for _, obj := range pack.Objects {
+			if err := writeRawObject(obj.Hash, obj.Data); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	names := make([]string, 0, len(branches))
+	for branch := range branches {
+		names = append(names, branch)
+	}
+	sort.Strings(names)
+	for _, branch := range names {
+		ref := "remote:" + name + "/" + branch
+		old, _ := getReference(ref)
+		tip := branches[branch]
+		if old == tip {
+			continue
+		}
+		if err := setReference(ref, tip); err != nil {
+			return nil, err
+		}
+		if old == "" {
+			fmt.Printf(" * [new branch]      %s -> %s/%s\n", branch, name, branch)
+		} else {
+			fmt.Printf("   %s..%s  %s -> %s/%s\n", shortHash(old), shortHash(tip), branch, name, branch)
+		}
+	}
+	return branches, nil
+}
+
+var remoteCmd = &cobra.Command{
+	Use:   "remote [add <name> <url>]",
+	Short: "List or add remote repositories",
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := loadConfig()
+		if err != nil {
+			log.Fatalf("Failed to load config: %v", err)
+		}
+		if len(args) == 0 {
+			for _, key := range cfg.Keys() {
+				if strings.HasPrefix(key, "remote.") && strings.HasSuffix(key, ".url") {
+					name := strings.TrimSuffix(strings.TrimPrefix(key, "remote."), ".url")
+					fmt.Printf("%s\t%s\n", name, cfg.Get(key))
+				}
+			}
+			return
+		}
+		if len(args) != 3 || args[0] != "add" {
+			log.Fatal("Usage: quad-db remote add <name> <url>")
+		}
+		cfg.Set("remote."+args[1]+".url", args[2])
+		if err := cfg.Save(); err != nil {
+			log.Fatalf("Failed to save config: %v", err)
+		}
+	},
+}
+
+var pushCmd = &cobra.Command{
+	Use:   "push [<remote> [<branch>]]",
+	Short: "Send a branch and its history to a remote repository",
+	Long: `Send a branch, the current one by default, and the history it needs to a
+remote. Without a remote, the branch is pushed to its upstream (see
+'branch --set-upstream-to'); -u records the remote as the upstream.`,
+	Args: cobra.MaximumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		branch := currentBranch()
+		if len(args) == 2 {
+			branch = args[1]
+		}
+		if branch == "" {
+			log.Fatal("HEAD is not on a branch; name the branch to push.")
+		}
+		remote, remoteBranch := "", branch
+		if len(args) > 0 {
+			remote = args[0]
+		} else {
+			var err error
+			if remote, remoteBranch, err = upstream(branch); err != nil {
+				log.Fatalf("Failed to load config: %v", err)
+			} else if remote == "" {
+				log.Fatalf("The branch %s has no upstream; use 'quad-db push -u <remote>'.", branch)
+			}
+		}
+		url, err := remoteURL(remote)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tip, err := getReference("head:" + branch)
+		if err != nil {
+			log.Fatalf("Could not resolve branch %s: %v", branch, err)
+		}
+		ref := "head:" + remoteBranch
+
+		opts, err := transferOptions(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		t, err := transport.Open(url, opts)
+		if err != nil {
+			log.Fatalf("Failed to contact remote: %v", err)
+		}
+		defer t.Close()
+		defer shareAlternate(remote, t)()
+		adv, err := t.List()
+		if err != nil {
+			log.Fatalf("Failed to contact remote: %v", err)
+		}
+		// track records the upstream once the remote branch matches, if -u
+		// was given
+		track := func() {
+			if set, _ := cmd.Flags().GetBool("set-upstream"); !set || strings.Contains(remote, "://") {
+				return
+			}
+			if err := setUpstream(branch, remote, remoteBranch); err != nil {
+				log.Fatalf("Failed to save config: %v", err)
+			}
+			fmt.Printf("Branch '%s' set up to track '%s/%s'.\n", branch, remote, remoteBranch)
+		}
+		old := adv.Refs[ref]
+		if old == tip {
+			fmt.Println("Everything up-to-date")
+			track()
+			return
+		}
+		if old != "" {
+			if _, err := readCommit(old); err != nil {
+				log.Fatalf("Remote %s contains commits you do not have; pull first.", remoteBranch)
+			}
+		}
+		// Every advertised commit we also have bounds what must be sent.
+		var haves []string
+		for _, hash := range adv.Refs {
+			haves = append(haves, hash)
+		}
+		stop, err := reachableFrom(haves)
+		if err != nil {
+			log.Fatalf("Failed to walk history: %v", err)
+		}
+		objects, err := collectObjects(stop, false, tip)
+		if err != nil {
+			log.Fatalf("Failed to collect objects: %v", err)
+		}
+		fmt.Printf("Sending %s\n", packSummary(objects))
+
+		req := rpc.PushRequest{
+			Updates: []rpc.RefUpdate{{Ref: ref, Old: old, New: tip}},
+			Pack:    rpc.Packfile{Objects: objects},
+		}
+		if signed, _ := cmd.Flags().GetBool("signed"); signed {
+			cfg, err := loadConfig()
+			if err != nil {
+				log.Fatalf("Failed to load config: %v", err)
+			}
+			// The pusher is who the server will find made the signature:
+			// the user, for a PGP key, or else the KMS key.
+			pusher := cfg.Get("user.email")
+			if backend := cfg.Get("user.signingBackend"); backend != "" && backend != "gpg" {
+				pusher = cfg.Get("user.signingKey")
+			}
+			cert := &rpc.PushCertificate{
+				Pusher:    pusher,
+				Pushee:    url,
+				Timestamp: clock.Now(),
+				Updates:   req.Updates,
+			}
+			payload, err := cert.Payload()
+			if err != nil {
+				log.Fatalf("Failed to encode push certificate: %v", err)
+			}
+			if cert.Signature, err = signPayload(payload); err != nil {
+				log.Fatalf("Failed to sign push certificate: %v", err)
+			}
+			req.Certificate = cert
+		}
+
+		if err := t.Push(req); err != nil {
+			log.Fatalf("Push rejected: %v", err)
+		}
+		if !strings.Contains(remote, "://") {
+			if err := setReference(trackingRef(remote, remoteBranch), tip); err != nil {
+				fmt.Printf("warning: failed to update remote-tracking ref: %v\n", err)
+			}
+		}
+		fmt.Printf("To %s\n   %s..%s  %s -> %s\n", url, shortHash(old), shortHash(tip), branch, remoteBranch)
+		track()
+	},
+}
+
+// defaultRemote returns the remote named in args, or else the current
+// branch's upstream remote, or else origin.
+func defaultRemote(args []string) (string, error) {
+	if len(args) > 0 {
+		return args[0], nil
+	}
+	if branch := currentBranch(); branch != "" {
+		remote, _, err := upstream(branch)
+		if err != nil || remote != "" {
+			return remote, err
+		}
+	}
+	return "origin", nil
+}
+
+var fetchCmd = &cobra.Command{
+	Use:   "fetch [<remote>]",
+	Short: "Download branches from a remote repository",
+	Long: `Download the commits, trees and blobs of every branch of a remote that
+are missing locally, and point the remote-tracking refs remote:<remote>/<branch>
+at them. Local branches are not changed. The remote defaults to the current
+branch's upstream remote, or origin.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name, err := defaultRemote(args)
+		if err != nil {
+			log.Fatal(err)
+		}
+		url, err := remoteURL(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if strings.Contains(name, "://") {
+			name = "origin"
+		}
+		ctx := commandContext(cmd)
+		opts, err := transferOptions(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if _, err := fetchRemote(name, url, opts); err != nil {
+			exitIfInterrupted(ctx, "no remote-tracking branch was updated")
+			log.Fatalf("Fetch failed: %v", err)
+		}
+	},
+}
+
+var pullCmd = &cobra.Command{
+	Use:   "pull [<remote> [<branch>]]",
+	Short: "Fetch from a remote and merge one of its branches",
+	Long: `Fetch from a remote, then merge its branch into the current branch. The
+branch defaults to the one of the same name; without arguments, the current
+branch's upstream is used.`,
+	Args: cobra.MaximumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		branch := currentBranch()
+		if branch == "" {
+			log.Fatal("HEAD is not on a branch; check one out before pulling.")
+		}
+		strategy, _ := cmd.Flags().GetString("strategy")
+		if strategy != "three-way" && strategy != "crdt" {
+			log.Fatalf("Unknown merge strategy %q (expected three-way or crdt).", strategy)
+		}
+		remote, merge, err := upstream(branch)
+		if err != nil {
+			log.Fatalf("Failed to load config: %v", err)
+		}
+		switch {
+		case len(args) == 2:
+			remote, merge = args[0], args[1]
+		case len(args) == 1 && args[0] != remote:
+			remote, merge = args[0], branch
+		case remote == "":
+			log.Fatalf("The branch %s has no upstream; use 'quad-db pull <remote> <branch>' or 'quad-db branch --set-upstream-to'.", branch)
+		}
+		url, err := remoteURL(remote)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if strings.Contains(remote, "://") {
+			remote = "origin"
+		}
+		opts, err := transferOptions(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		branches, err := fetchRemote(remote, url, opts)
+		if err != nil {
+			log.Fatalf("Fetch failed: %v", err)
+		}
+		if _, ok := branches[merge]; !ok {
+			log.Fatalf("The remote has no branch %s.", merge)
+		}
+		sign, _ := cmd.Flags().GetBool("gpg-sign")
+		mergeBranches([]string{remote + "/" + merge}, strategy == "crdt", sign)
+	},
+}
+
+var cloneCmd = &cobra.Command{
+	Use:   "clone <url> [<dir>]",
+	Short: "Copy a remote repository into a new directory",
+	Long: `Create <dir>, initialize a repository in it with the remote configured as
+"origin", fetch every branch and create a local branch for each. HEAD points
+to main, or to the first branch if the remote has no main.
+
+With --filter=blob:none, only commits and trees are fetched: the graphs
+themselves are fetched from origin the first time they are read, e.g. by
+export, diff or query, so that cloning a huge dataset is quick and only the
+graphs actually used take up space.
+
+With --thin, nothing but the references is fetched: origin becomes the
+repository's alternate (core.alternate), and every commit, tree and graph
+is fetched from it the first time it is read and kept from then on. This
+gives a working repository backed by a central server that holds only the
+history actually used.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		url := strings.TrimSuffix(args[0], "/")
+		dir := ""
+		if len(args) == 2 {
+			dir = args[1]
+		} else if _, path, _ := strings.Cut(url, "://"); strings.Contains(path, "/") {
+			dir = path[strings.LastIndex(path, "/")+1:]
+		}
+		if dir == "" {
+			log.Fatal("Cannot derive a directory name from the URL; please specify one.")
+		}
+		if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+			log.Fatalf("Destination %s already exists and is not empty.", dir)
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Fatalf("Failed to create %s: %v", dir, err)
+		}
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// fail removes the half-created clone before exiting, as does an
+		// interrupt.
+		ctx := commandContext(cmd)
+		fail := func(format string, v ...interface{}) {
+			closeDB()
+			os.RemoveAll(abs)
+			exitIfInterrupted(ctx, "removed "+dir)
+			log.Fatalf(format, v...)
+		}
+		if err := os.Chdir(dir); err != nil {
+			fail("Failed to enter %s: %v", dir, err)
+		}
+		if err := os.Mkdir(dbPath, 0755); err != nil {
+			fail("Failed to create repository: %v", err)
+		}
+		if _, err := openDB(); err != nil {
+			fail("Failed to open database: %v", err)
+		}
+		cfg, err := loadConfig()
+		if err != nil {
+			fail("Failed to load config: %v", err)
+		}
+		cfg.Set("remote.origin.url", url)
+		if filter, _ := cmd.Flags().GetString("filter"); filter != "" {
+			if filter != rpc.FilterBlobNone {
+				fail("Unsupported filter %q (only %s is supported).", filter, rpc.FilterBlobNone)
+			}
+			cfg.Set("remote.origin.filter", filter)
+			cfg.Set("core.promisorRemote", "origin")
+		}
+		if thin, _ := cmd.Flags().GetBool("thin"); thin {
+			cfg.Set("core.alternate", "origin")
+		}
+		if err := cfg.Save(); err != nil {
+			fail("Failed to save config: %v", err)
+		}
+
+		opts, err := transferOptions(cmd)
+		if err != nil {
+			fail("%v", err)
+		}
+		fmt.Printf("Cloning into '%s'...\n", dir)
+		branches, err := fetchRemote("origin", url, opts)
+		if err != nil {
+			fail("Clone failed: %v", err)
+		}
+		if len(branches) == 0 {
+			fail("The remote repository has no branches.")
+		}
+		names := make([]string, 0, len(branches))
+		for branch, hash := range branches {
+			if err := setReference("head:"+branch, hash); err != nil {
+				fail("Failed to create branch %s: %v", branch, err)
+			}
+			cfg.Set("branch."+branch+".remote", "origin")
+			cfg.Set("branch."+branch+".merge", branch)
+			names = append(names, branch)
+		}
+		if err := cfg.Save(); err != nil {
+			fail("Failed to save config: %v", err)
+		}
+		sort.Strings(names)
+		head := names[0]
+		if _, ok := branches["main"]; ok {
+			head = "main"
+		}
+		if err := setReference("HEAD", "ref:head:"+head); err != nil {
+			fail("Failed to set HEAD: %v", err)
+		}
+	},
+}
@@ -0,0 +1,316 @@
+// renames.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// normalizeGraphName turns a user-supplied graph argument into the name it
+// is stored under, accepting IRIs with or without angle brackets.
+func normalizeGraphName(arg string) string {
+	if arg == defaultGraph || strings.HasPrefix(arg, "<") || strings.HasPrefix(arg, "_:") {
+		return arg
+	}
+	return "<" + arg + ">"
+}
+
+// readStagedRenames returns the graph renames staged by mv, old name to new.
+func readStagedRenames() (map[string]string, error) {
+	renames := make(map[string]string)
+	data, err := os.ReadFile(renamesPath)
+	if os.IsNotExist(err) || len(data) == 0 {
+		return renames, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &renames)
+	return renames, err
+}
+
+// applyRenames moves graphs in a state according to renames, old name to new.
+func applyRenames(state map[string]quadSet, renames map[string]string) map[string]quadSet {
+	moved := make(map[string]quadSet, len(state))
+	for graph, set := range state {
+		moved[graph] = set
+	}
+	for oldName, newName := range renames {
+		moved[newName] = state[oldName]
+		delete(moved, oldName)
+	}
+	return moved
+}
+
+// chainRenames combines the renames of a commit with renames made on top of
+// it, so that a graph renamed twice maps from its first name to its last.
+func chainRenames(first, second map[string]string) map[string]string {
+	if len(first) == 0 {
+		return second
+	}
+	chained := make(map[string]string, len(first)+len(second))
+	for oldName, newName := range first {
+		chained[oldName] = newName
+	}
+	for oldName, newName := range second {
+		moved := false
+		for origin, name := range chained {
+			if name == oldName {
+				chained[origin] = newName
+				moved = true
+			}
+		}
+		if !moved {
+			chained[oldName] = newName
+		}
+	}
+	for oldName, newName := range chained {
+		if oldName == newName {
+			delete(chained, oldName)
+		}
+	}
+	return chained
+}
+
+// detectRenames pairs graphs that disappeared with graphs that appeared
+// holding the identical blob, returning old name to new name.
+func detectRenames(before, after map[string]string) map[string]string {
+	removed := make(map[string][]string)
+	for name, blob := range before {
+		if _, ok := after[name]; !ok {
+			removed[blob] = append(removed[blob], name)
+		}
+	}
+	renames := make(map[string]string)
+	var added []string
+	for name := range after {
+		if _, ok := before[name]; !ok {
+			added = append(added, name)
+		}
+	}
+	sort.Strings(added)
+	for _, name := range added {
+		candidates := removed[after[name]]
+		if len(candidates) == 0 {
+			continue
+		}
+		sort.Strings(candidates)
+		renames[candidates[0]] = name
+		removed[after[name]] = candidates[1:]
+	}
+	return renames
+}
+
+// commitRenames returns the graph renames a commit performed relative to its
+// first parent: those recorded by mv plus any detected from unchanged blobs.
+func commitRenames(commit *Commit) (map[string]string, error) {
+	renames := make(map[string]string)
+	if len(commit.Parents) == 0 {
+		return renames, nil
+	}
+	parent, err := readCommit(commit.Parents[0])
+	if err != nil {
+		return nil, err
+	}
+	before, err := readGraphs(parent.Tree)
+	if err != nil {
+		return nil, err
+	}
+	after, err := readGraphs(commit.Tree)
+	if err != nil {
+		return nil, err
+	}
+	for oldName, newName := range detectRenames(before, after) {
+		renames[oldName] = newName
+	}
+	for oldName, newName := range commit.Renames {
+		renames[oldName] = newName
+	}
+	return renames, nil
+}
+
+// previousName returns the name a graph had in a commit's first parent.
+func previousName(commit *Commit, graph string) (string, error) {
+	renames, err := commitRenames(commit)
+	if err != nil {
+		return "", err
+	}
+	for oldName, newName := range renames {
+		if newName == graph {
+			return oldName, nil
+		}
+	}
+	return graph, nil
+}
+
+// graphBlob returns the blob hash of a graph in a commit, or "" if absent.
+func graphBlob(commit *Commit, graph string) (string, error) {
+	graphs, err := readGraphsUnder(commit.Tree, graph)
+	if err != nil {
+		return "", err
+	}
+	return graphs[graph], nil
+}
+
+// graphVersion is a commit that changed a graph, with the graph's name and
+// blob as of that commit; Blob is empty if the commit deleted the graph.
+type graphVersion struct {
+	Commit, Name, Blob string
+}
+
+// followGraph walks first-parent history from start and returns the commits
+// that changed a graph, newest first, following it back across renames.
+func followGraph(start, graph string) ([]graphVersion, error) {
+	var touched []graphVersion
+	hash, name := start, graph
+	for hash != "" {
+		commit, err := readCommit(hash)
+		if err != nil {
+			return nil, err
+		}
+		blob, err := graphBlob(commit, name)
+		if err != nil {
+			return nil, err
+		}
+		var parentHash, parentName, parentBlob string
+		if len(commit.Parents) > 0 {
+			parentHash = commit.Parents[0]
+			if parentName, err = previousName(commit, name); err != nil {
+				return nil, err
+			}
+			parent, err := readCommit(parentHash)
+			if err != nil {
+				return nil, err
+			}
+			if parentBlob, err = graphBlob(parent, parentName); err != nil {
+				return nil, err
+			}
+		}
+		if blob != parentBlob || (parentHash != "" && parentName != name) {
+			touched = append(touched, graphVersion{hash, name, blob})
+		}
+		hash, name = parentHash, parentName
+	}
+	return touched, nil
+}
+
+var mvCmd = &cobra.Command{
+	Use:   "mv <old-graph-iri> <new-graph-iri>",
+	Short: "Stage the rename of a named graph",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		oldName, newName := normalizeGraphName(args[0]), normalizeGraphName(args[1])
+		head, err := resolveHead()
+		if err != nil {
+			log.Fatalf("Could not resolve HEAD: %v", err)
+		}
+		commit, err := readCommit(head)
+		if err != nil {
+			log.Fatalf("Failed to read HEAD: %v", err)
+		}
+		renames, err := readStagedRenames()
+		if err != nil {
+			log.Fatalf("Failed to read staged renames: %v", err)
+		}
+
+		// Renaming an already-renamed graph updates the pending rename
+		source := oldName
+		for from, to := range renames {
+			if to == oldName {
+				source = from
+			}
+		}
+		if blob, err := graphBlob(commit, source); err != nil {
+			log.Fatalf("Failed to read HEAD tree: %v", err)
+		} else if blob == "" {
+			log.Fatalf("Graph %s does not exist in HEAD.", oldName)
+		}
+		if blob, _ := graphBlob(commit, newName); blob != "" {
+			log.Fatalf("Graph %s already exists.", newName)
+		}
+		renames[source] = newName
+
+		data, err := json.Marshal(renames)
+		if err != nil {
+			log.Fatalf("Failed to encode renames: %v", err)
+		}
+		if err := os.WriteFile(renamesPath, data, 0644); err != nil {
+			log.Fatalf("Failed to stage rename: %v", err)
+		}
+		fmt.Printf("Staged rename of %s to %s\n", oldName, newName)
+	},
+}
+
+var blameCmd = &cobra.Command{
+	Use:   "blame <graph-iri> [<commit>]",
+	Short: "Show the commit that introduced each quad of a graph",
+	Args:  cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		rev := "HEAD"
+		if len(args) == 2 {
+			rev = args[1]
+		}
+		hash, err := resolveCommitish(rev)
+		if err != nil {
+			log.Fatalf("Could not resolve %s: %v", rev, err)
+		}
+		name := normalizeGraphName(args[0])
+		state, err := loadStateUnder(context.Background(), hash, name)
+		if err != nil {
+			log.Fatalf("Failed to read graph: %v", err)
+		}
+		remaining := state[name]
+		if len(remaining) == 0 {
+			log.Fatalf("Graph %s does not exist at %s.", name, rev)
+		}
+
+		origin := make(map[string]string, len(remaining))
+		pending := make(quadSet, len(remaining))
+		for line := range remaining {
+			pending[line] = true
+		}
+		for len(pending) > 0 {
+			commit, err := readCommit(hash)
+			if err != nil {
+				log.Fatalf("Failed to read commit history: %v", err)
+			}
+			if len(commit.Parents) == 0 {
+				for line := range pending {
+					origin[line] = hash
+				}
+				break
+			}
+			parentName, err := previousName(commit, name)
+			if err != nil {
+				log.Fatalf("Failed to follow renames: %v", err)
+			}
+			parentState, err := loadStateUnder(context.Background(), commit.Parents[0], parentName)
+			if err != nil {
+				log.Fatalf("Failed to read commit history: %v", err)
+			}
+			for line := range pending {
+				if !parentState[parentName][line] {
+					origin[line] = hash
+					delete(pending, line)
+				}
+			}
+			hash, name = commit.Parents[0], parentName
+		}
+
+		lines := make([]string, 0, len(origin))
+		for line := range origin {
+			lines = append(lines, line)
+		}
+		sort.Strings(lines)
+		for _, line := range lines {
+			fmt.Printf("%s %s\n", origin[line][:7], line)
+		}
+	},
+}
@@ -0,0 +1,115 @@
+// repack.go
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"runtime"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/spf13/cobra"
+)
+
+// repackStats reports what recompressObjects did.
+type repackStats struct {
+	Objects, Rewritten, Corrupt int
+	Before, After               int64 // Stored bytes
+}
+
+// recompressObjects rewrites every stored object compressed at level (0
+// stores it uncompressed). Objects already stored that way are left alone,
+// and objects that do not match their hash are skipped, so that the
+// corruption stays visible to fsck.
+func recompressObjects(level int) (repackStats, error) {
+	var stats repackStats
+	wb := repo.db.NewWriteBatch()
+	defer wb.Cancel()
+	err := repo.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte("obj:")
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			key := it.Item().KeyCopy(nil)
+			hash := string(key[len("obj:"):])
+			stats.Objects++
+			err := it.Item().Value(func(val []byte) error {
+				stats.Before += int64(len(val))
+				data, err := objectEncoding(val)
+				if sum := sha1.Sum(data); err != nil || hex.EncodeToString(sum[:]) != hash {
+					stats.Corrupt++
+					stats.After += int64(len(val))
+					return nil
+				}
+				stored := compressObject(data, level)
+				stats.After += int64(len(stored))
+				if string(stored) == string(val) {
+					return nil
+				}
+				stats.Rewritten++
+				return wb.Set(key, append([]byte(nil), stored...))
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	return stats, wb.Flush()
+}
+
+var repackCmd = &cobra.Command{
+	Use:   "repack --recompress [--codec <codec>]",
+	Short: "Rewrite stored objects with a different compression codec",
+	Long: `With --recompress, rewrite every stored object with the codec given by
+--codec, or core.objectCodec: none, zstd (level 3) or zstd-<level> up to
+zstd-22, the slowest and smallest. --codec also becomes core.objectCodec,
+which new objects are stored with. The database is compacted afterwards, as
+optimize does, so the space is reclaimed.
+
+Object hashes cover the uncompressed canonical encoding, so repacking
+changes no hash and other repositories are unaffected. It reads and writes
+every object: run it in a maintenance window.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if recompress, _ := cmd.Flags().GetBool("recompress"); !recompress {
+			log.Fatal("Nothing to do; use --recompress to rewrite objects.")
+		}
+		level := repo.codecLevel
+		if codec, _ := cmd.Flags().GetString("codec"); codec != "" {
+			var err error
+			if level, err = parseObjectCodec(codec); err != nil {
+				log.Fatal(err)
+			}
+			cfg, err := loadConfig()
+			if err != nil {
+				log.Fatalf("Failed to load config: %v", err)
+			}
+			cfg.Set("core.objectCodec", codec)
+			if err := cfg.Save(); err != nil {
+				log.Fatalf("Failed to save config: %v", err)
+			}
+			repo.codecLevel = level
+		}
+
+		stats, err := recompressObjects(level)
+		if err != nil {
+			log.Fatalf("Repack failed: %v", err)
+		}
+		if err := repo.db.Flatten(runtime.NumCPU()); err != nil {
+			log.Fatalf("Compaction failed: %v", err)
+		}
+		if _, err := runValueLogGC(defaultDiscardRatio); err != nil {
+			log.Fatalf("Value-log GC failed: %v", err)
+		}
+		fmt.Printf("Rewrote %d of %d object(s); they now take %s (were %s)\n", stats.Rewritten, stats.Objects, formatBytes(stats.After), formatBytes(stats.Before))
+		if stats.Corrupt > 0 {
+			fmt.Printf("Skipped %d corrupt object(s); run 'quad-db fsck' for details.\n", stats.Corrupt)
+		}
+	},
+}
@@ -0,0 +1,177 @@
+// replace.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// A replace reference "replace:<old>" names a commit to read in place of
+// commit old. History commands (log, blame, diff, merge-base computation,
+// ...) see the replacement under the original hash, so a broken or imported
+// history can be repaired virtually, without rewriting the commits that
+// follow. Object transfer ignores replacements, so that pushes and fetches
+// always carry the real history; replace references themselves are not
+// transferred by push.
+const replacePrefix = "replace:"
+
+// replaceObjects is false when replacements are ignored: with
+// --no-replace-objects or QUAD_DB_NO_REPLACE_OBJECTS set, while serving
+// remote clients, and while collecting objects to transfer.
+var replaceObjects = os.Getenv("QUAD_DB_NO_REPLACE_OBJECTS") == ""
+
+// replacementFor returns the commit to read instead of hash, or "".
+func replacementFor(hash string) string {
+	if !replaceObjects || repo == nil {
+		return ""
+	}
+	return repo.replacementFor(hash)
+}
+
+// replacementFor returns the commit r's replace references name in place
+// of hash, or "".
+func (r *Repository) replacementFor(hash string) string {
+	if r.replacements == nil {
+		refs, err := r.listReferences(replacePrefix)
+		if err != nil {
+			return ""
+		}
+		r.replacements = make(map[string]string, len(refs))
+		for name, value := range refs {
+			r.replacements[strings.TrimPrefix(name, replacePrefix)] = value
+		}
+	}
+	return r.replacements[hash]
+}
+
+// replacementsActive reports whether any replacement is being honored.
+func replacementsActive() bool {
+	replacementFor("")
+	return replaceObjects && repo != nil && len(repo.replacements) > 0
+}
+
+// suspendReplacements makes readCommit return real commits until the
+// returned function is called.
+func suspendReplacements() (restore func()) {
+	saved := replaceObjects
+	replaceObjects = false
+	return func() { replaceObjects = saved }
+}
+
+// readOriginalCommit reads a commit, ignoring any replacement.
+func readOriginalCommit(hash string) (*Commit, error) {
+	defer suspendReplacements()()
+	return readCommit(hash)
+}
+
+// graftCommit stores a copy of commit with different parents and returns
+// its hash. The copy is unsigned, since the signature covered the original
+// parents.
+func graftCommit(hash string, parents []string) (string, error) {
+	commit, err := readOriginalCommit(hash)
+	if err != nil {
+		return "", err
+	}
+	graft := *commit
+	graft.Parents = append([]string{}, parents...)
+	graft.Signature = ""
+	graft.Stats = nil // Counted against the original first parent
+	return writeObject(graft)
+}
+
+var replaceCmd = &cobra.Command{
+	Use:   "replace [<old-commit> <new-commit> | -d <old-commit>... | --graft <commit> [<parent>...]]",
+	Short: "Read one commit in place of another, without rewriting history",
+	Long: `Create a replace reference so that commands reading history see
+<new-commit> wherever <old-commit> is reached, e.g. to fix a wrong message
+or to splice imported history onto older history. --graft <commit>
+[<parent>...] creates a copy of <commit> with the given parents (none makes
+it a root) and replaces <commit> with it. -d deletes replacements; without
+arguments they are listed.
+
+Replacements are local: object transfer ignores them, and
+--no-replace-objects (or QUAD_DB_NO_REPLACE_OBJECTS=1) shows the real
+history.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		resolve := func(rev string) string {
+			defer suspendReplacements()()
+			hash, err := resolveCommitish(rev)
+			if err != nil {
+				log.Fatalf("Could not resolve %s: %v", rev, err)
+			}
+			return hash
+		}
+
+		if del, _ := cmd.Flags().GetBool("delete"); del {
+			if len(args) == 0 {
+				log.Fatal("Name the commits whose replacements to delete.")
+			}
+			for _, arg := range args {
+				old := resolve(arg)
+				if _, err := getReference(replacePrefix + old); err != nil {
+					log.Fatalf("%s is not replaced.", shortHash(old))
+				}
+				if err := deleteReference(replacePrefix + old); err != nil {
+					log.Fatalf("Failed to delete replacement: %v", err)
+				}
+				fmt.Printf("Deleted replacement of %s\n", shortHash(old))
+			}
+			return
+		}
+
+		if graft, _ := cmd.Flags().GetBool("graft"); graft {
+			if len(args) == 0 {
+				log.Fatal("Name the commit to graft.")
+			}
+			old := resolve(args[0])
+			var parents []string
+			for _, arg := range args[1:] {
+				parents = append(parents, resolve(arg))
+			}
+			replacement, err := graftCommit(old, parents)
+			if err != nil {
+				log.Fatalf("Failed to graft %s: %v", shortHash(old), err)
+			}
+			if err := setReference(replacePrefix+old, replacement); err != nil {
+				log.Fatalf("Failed to create replacement: %v", err)
+			}
+			fmt.Printf("Grafted %s onto %d parent(s) as %s\n", shortHash(old), len(parents), shortHash(replacement))
+			return
+		}
+
+		switch len(args) {
+		case 0:
+			refs, err := listReferences(replacePrefix)
+			if err != nil {
+				log.Fatalf("Failed to list replacements: %v", err)
+			}
+			names := make([]string, 0, len(refs))
+			for name := range refs {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				fmt.Printf("%s -> %s\n", strings.TrimPrefix(name, replacePrefix), refs[name])
+			}
+		case 2:
+			old, replacement := resolve(args[0]), resolve(args[1])
+			if old == replacement {
+				log.Fatal("A commit cannot replace itself.")
+			}
+			if _, err := getReference(replacePrefix + replacement); err == nil {
+				log.Fatalf("%s is itself replaced; replacements do not chain.", shortHash(replacement))
+			}
+			if err := setReference(replacePrefix+old, replacement); err != nil {
+				log.Fatalf("Failed to create replacement: %v", err)
+			}
+			fmt.Printf("Replaced %s with %s\n", shortHash(old), shortHash(replacement))
+		default:
+			log.Fatal("Usage: replace <old-commit> <new-commit>")
+		}
+	},
+}
@@ -0,0 +1,156 @@
+// replica.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A read replica is a 'quad-db serve' that follows a primary repository:
+// it fetches from the primary at an interval and moves its own branches to
+// match. Clients choose per request how stale a replica's answer may be.
+type replicaState struct {
+	remote  string // Name or URL of the primary
+	primary string // URL of the primary
+	mu      sync.Mutex
+	synced  time.Time // When the last successful sync started
+}
+
+// replica is set when serve runs with --replica-of.
+var replica *replicaState
+
+// lastSync returns when the replica was last known to match the primary.
+func (r *replicaState) lastSync() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.synced
+}
+
+// sync fetches from the primary and moves every local branch to the
+// primary's tip.
+func (r *replicaState) sync() error {
+	started := clock.Now()
+	opts, err := transferOptions(nil)
+	if err != nil {
+		return err
+	}
+	branches, err := fetchRemote(r.remote, r.primary, opts)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(branches))
+	for name := range branches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := setReference("head:"+name, branches[name]); err != nil {
+			return err
+		}
+	}
+	r.mu.Lock()
+	r.synced = started
+	r.mu.Unlock()
+	return nil
+}
+
+// follow keeps the replica in sync until the process exits.
+func (r *replicaState) follow(interval time.Duration) {
+	for {
+		if err := r.sync(); err != nil {
+			log.Printf("Replica sync from %s failed: %v", r.primary, err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// A consistency level says how fresh a read must be.
+type consistency struct {
+	level string        // "strong", "bounded" or "eventual"
+	bound time.Duration // For "bounded": the most staleness allowed
+}
+
+var boundedLevel = regexp.MustCompile(`^bounded\((.+)\)$`)
+
+// parseConsistency parses strong, bounded(<duration>) or eventual. An
+// empty level is eventual: a replica answers from what it has.
+func parseConsistency(s string) (consistency, error) {
+	switch s {
+	case "", "eventual":
+		return consistency{level: "eventual"}, nil
+	case "strong":
+		return consistency{level: "strong"}, nil
+	}
+	if m := boundedLevel.FindStringSubmatch(s); m != nil {
+		d, err := time.ParseDuration(m[1])
+		if err != nil || d < 0 {
+			return consistency{}, fmt.Errorf("invalid bound %q", m[1])
+		}
+		return consistency{level: "bounded", bound: d}, nil
+	}
+	return consistency{}, fmt.Errorf("unknown consistency %q; use strong, bounded(<duration>) or eventual", s)
+}
+
+// satisfiedBy reports whether a replica last synced at synced may answer.
+func (c consistency) satisfiedBy(synced time.Time) bool {
+	switch c.level {
+	case "eventual":
+		return true
+	case "bounded":
+		return !synced.IsZero() && clock.Now().Sub(synced) <= c.bound
+	}
+	return false
+}
+
+// toPrimary redirects a request to the same resource on the primary, or
+// answers 503 if the primary cannot be reached over HTTP.
+func (r *replicaState) toPrimary(w http.ResponseWriter, req *http.Request, why string) {
+	if !strings.HasPrefix(r.primary, "http://") && !strings.HasPrefix(r.primary, "https://") {
+		http.Error(w, fmt.Sprintf("%s, but the primary %s is not served over HTTP", why, r.primary), http.StatusServiceUnavailable)
+		return
+	}
+	http.Redirect(w, req, r.primary+req.URL.RequestURI(), http.StatusTemporaryRedirect)
+}
+
+// withConsistency routes a read according to its consistency parameter.
+// A primary satisfies every level itself; a replica answers when it is
+// fresh enough and otherwise redirects to the primary.
+func withConsistency(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, err := parseConsistency(r.URL.Query().Get("consistency"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if replica == nil {
+			h(w, r)
+			return
+		}
+		synced := replica.lastSync()
+		if !c.satisfiedBy(synced) {
+			replica.toPrimary(w, r, fmt.Sprintf("this replica cannot give %s consistency", c.level))
+			return
+		}
+		if !synced.IsZero() {
+			w.Header().Set("Replica-Synced", synced.UTC().Format(http.TimeFormat))
+		}
+		h(w, r)
+	}
+}
+
+// primaryOnly sends writes made to a replica on to the primary.
+func primaryOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if replica != nil {
+			replica.toPrimary(w, r, "this is a read replica")
+			return
+		}
+		h(w, r)
+	}
+}
@@ -0,0 +1,220 @@
+// report.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// builtinVocabularies are the namespaces whose terms count as declared
+// without an ontology graph declaring them.
+var builtinVocabularies = []string{
+	"http://www.w3.org/1999/02/22-rdf-syntax-ns#",
+	"http://www.w3.org/2000/01/rdf-schema#",
+	"http://www.w3.org/2002/07/owl#",
+	"http://www.w3.org/2001/XMLSchema#",
+	"http://www.w3.org/ns/shacl#",
+}
+
+// ontologyScopes returns the graph scopes in ontology.graphs, e.g.
+// "http://example.org/schema/*, <http://example.org/vocab>".
+func ontologyScopes() ([]string, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	var scopes []string
+	for _, scope := range strings.Split(cfg.Get("ontology.graphs"), ",") {
+		if scope = strings.Trim(strings.TrimSpace(scope), "<>"); scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
+
+// A termUse is a predicate or class and the number of statements using
+// it: as the predicate, or as the type of an instance.
+type termUse struct {
+	Term       string
+	Count      int
+	Undeclared bool
+	Suggestion string // The declared term it is likeliest a typo of
+}
+
+// vocabularyUsage counts the predicates and classes the data graphs of
+// state use. With ontology scopes, graphs in scope are the ontology rather
+// than data, and terms none of them describe are flagged undeclared.
+func vocabularyUsage(state map[string]quadSet, scopes []string) (predicates, classes []termUse) {
+	inOntology := func(graph string) bool {
+		for _, scope := range scopes {
+			if graphInScope(graph, scope) {
+				return true
+			}
+		}
+		return false
+	}
+	declared := make(map[string]bool)
+	predicateCounts := make(map[string]int)
+	classCounts := make(map[string]int)
+	for graph, set := range state {
+		ontology := inOntology(graph)
+		for line := range set {
+			q, err := parseQuad(line)
+			if err != nil {
+				continue
+			}
+			if ontology {
+				declared[q.Subject] = true
+				continue
+			}
+			predicateCounts[q.Predicate]++
+			if q.Predicate == rdfType && strings.HasPrefix(q.Object, "<") {
+				classCounts[q.Object]++
+			}
+		}
+	}
+	uses := func(counts map[string]int) []termUse {
+		var out []termUse
+		for term, n := range counts {
+			use := termUse{Term: term, Count: n}
+			if len(scopes) > 0 && !declared[term] && !builtinTerm(term) {
+				use.Undeclared = true
+				use.Suggestion = closestTerm(term, declared)
+			}
+			out = append(out, use)
+		}
+		sort.Slice(out, func(i, j int) bool {
+			if out[i].Count != out[j].Count {
+				return out[i].Count > out[j].Count
+			}
+			return out[i].Term < out[j].Term
+		})
+		return out
+	}
+	return uses(predicateCounts), uses(classCounts)
+}
+
+func builtinTerm(term string) bool {
+	iri := strings.Trim(term, "<>")
+	for _, ns := range builtinVocabularies {
+		if strings.HasPrefix(iri, ns) {
+			return true
+		}
+	}
+	return false
+}
+
+// closestTerm returns the declared term nearest to term, if one is at most
+// two edits away, or "".
+func closestTerm(term string, declared map[string]bool) string {
+	best, bestDistance := "", 3
+	for candidate := range declared {
+		d := editDistance(term, candidate, bestDistance+1)
+		if d < bestDistance || d == bestDistance && candidate < best {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b, or limit
+// if it is at least limit.
+func editDistance(a, b string, limit int) int {
+	if d := len(a) - len(b); d >= limit || -d >= limit {
+		return limit
+	}
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin >= limit {
+			return limit
+		}
+		prev, cur = cur, prev
+	}
+	return min(prev[len(b)], limit)
+}
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Report on the repository's data",
+}
+
+var reportVocabularyCmd = &cobra.Command{
+	Use:   "vocabulary [--at <ref>]",
+	Short: "List the predicates and classes in use",
+	Long: `List every predicate in use with the number of statements using it, and
+every class with the number of its instances (rdf:type statements), most
+used first, at a commit (HEAD by default).
+
+ontology.graphs names the graphs holding the ontology, as graph scopes
+separated by commas, e.g. http://example.org/schema/*. Their statements are
+left out of the counts, and a term none of them has statements about is
+flagged UNDECLARED, with the declared term it is likely a typo of when one
+is close; such terms silently split data that should share one. Terms of
+the RDF, RDFS, OWL, XSD and SHACL vocabularies count as declared.
+--undeclared lists only the flagged terms.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		at, _ := cmd.Flags().GetString("at")
+		hash, err := resolveCommitish(at)
+		if err != nil {
+			log.Fatalf("Could not resolve %s: %v", at, err)
+		}
+		scopes, err := ontologyScopes()
+		if err != nil {
+			log.Fatalf("Failed to load config: %v", err)
+		}
+		state, err := loadState(hash)
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", at, err)
+		}
+		onlyUndeclared, _ := cmd.Flags().GetBool("undeclared")
+		if onlyUndeclared && len(scopes) == 0 {
+			log.Fatal("No ontology graphs are configured; set ontology.graphs.")
+		}
+
+		predicates, classes := vocabularyUsage(state, scopes)
+		undeclared := 0
+		for _, section := range []struct {
+			title string
+			uses  []termUse
+		}{{"Predicates", predicates}, {"Classes", classes}} {
+			fmt.Printf("%s:\n", section.title)
+			for _, use := range section.uses {
+				if onlyUndeclared && !use.Undeclared {
+					continue
+				}
+				line := fmt.Sprintf("%8d  %s", use.Count, use.Term)
+				if use.Undeclared {
+					undeclared++
+					line += "  UNDECLARED"
+					if use.Suggestion != "" {
+						line += " (did you mean " + use.Suggestion + "?)"
+					}
+				}
+				fmt.Println(line)
+			}
+			fmt.Println()
+		}
+		if len(scopes) > 0 {
+			fmt.Printf("%d undeclared term(s).\n", undeclared)
+		}
+	},
+}
@@ -0,0 +1,447 @@
+// repository.go
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/mannyrivera2010/go-quadgit/internal/config"
+	"github.com/mannyrivera2010/go-quadgit/internal/lru"
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+)
+
+// A Repository is an open object store: the Badger instance of one
+// namespace, the settings of its config that govern how its objects are
+// written and read, and its object caches. Its methods touch nothing else,
+// so several repositories can be open in one process at once, as long as
+// they are used through their methods.
+//
+// Everything above the object store is still process-wide. Commands act on
+// repo, which openDB opens from the working directory, through the
+// package-level storage functions, which are shorthands for its methods
+// that also read missing objects through to the alternate or promisor
+// remote. The namespace, system database (sysDB), authorizer, clock and
+// change batcher are globals too, so a process runs commands against one
+// repository at a time, and tests of commands (see newTestRepo) cannot run
+// in parallel.
+type Repository struct {
+	db *badger.DB
+	// path is the directory of the Badger instance.
+	path string
+
+	// paranoid makes writes of objects that are already stored verify the
+	// stored bytes instead of trusting the hash (core.paranoid).
+	paranoid bool
+	// verifyObjects makes every object read from disk be re-hashed, so
+	// that corruption is reported instead of returned (core.verifyObjects).
+	verifyObjects bool
+	// codecLevel is the zstd level new objects are compressed with, from
+	// core.objectCodec; 0 stores them uncompressed.
+	codecLevel int
+
+	// commits and trees cache decoded objects read from this repository;
+	// see objcache.go.
+	commits *lru.Cache[string, Commit]
+	trees   *lru.Cache[string, Tree]
+
+	// replacements caches the replace references, old hash to new; nil
+	// until first needed (see replace.go).
+	replacements map[string]string
+
+	// staged holds the objects of a push being checked, hash to encoding:
+	// readable, but not stored; see stageObjects.
+	stagedMu sync.RWMutex
+	staged   map[string][]byte
+}
+
+// repo is the repository the running command acts on.
+var repo *Repository
+
+// openRepository opens the Badger instance at path with the object
+// settings of the config of the repository it belongs to. Invalid settings
+// are reported as warnings rather than errors, or 'quad-db config' could
+// not repair them.
+func openRepository(path string) (*Repository, error) {
+	r := &Repository{path: path}
+	r.newObjectCaches(defaultObjectCacheSize)
+	if cfg, err := config.Load(repositoryDir(path)); err == nil {
+		if size, err := objectCacheSize(cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+		} else {
+			r.newObjectCaches(size)
+		}
+		if v := cfg.Get("core.paranoid"); v != "" {
+			if r.paranoid, err = strconv.ParseBool(v); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: invalid core.paranoid %q (use true or false)\n", v)
+			}
+		}
+		if r.codecLevel, err = parseObjectCodec(cfg.Get("core.objectCodec")); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: invalid core.objectCodec: %v\n", err)
+		}
+		if v := cfg.Get("core.verifyObjects"); v != "" {
+			if r.verifyObjects, err = strconv.ParseBool(v); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: invalid core.verifyObjects %q (use true or false)\n", v)
+			}
+		}
+	}
+	opts := badger.DefaultOptions(path).WithLogger(nil) // Suppress Badger logger
+	db, err := badger.Open(opts)
+	if err != nil && strings.Contains(err.Error(), "Cannot acquire directory lock") {
+		// Badger has no sentinel for this; it is the one failure users hit
+		// routinely, e.g. while 'quad-db serve' is running.
+		return nil, fmt.Errorf("%w (is 'quad-db serve' running?)", quadstore.ErrRepoLocked)
+	} else if err != nil {
+		return nil, err
+	}
+	r.db = db
+	return r, nil
+}
+
+// repositoryDir returns the directory holding the config of the repository
+// whose Badger instance is at path: path itself, or for a namespace the
+// directory its namespaces directory is in.
+func repositoryDir(path string) string {
+	if parent := filepath.Dir(path); filepath.Base(parent) == filepath.Base(namespacesPath) {
+		return filepath.Dir(parent)
+	}
+	return path
+}
+
+// Close closes the repository's database, releasing its lock.
+func (r *Repository) Close() error {
+	return r.db.Close()
+}
+
+// storedObject returns the stored form of a new object's encoding.
+func (r *Repository) storedObject(data []byte) []byte {
+	return compressObject(data, r.codecLevel)
+}
+
+// writeObject serializes an object (Commit, Tree, Blob) in its canonical
+// encoding (see encoding.go), computes its hash, and saves it.
+func (r *Repository) writeObject(obj interface{}) (string, error) {
+	data, err := encodeObject(obj)
+	if err != nil {
+		return "", err
+	}
+
+	hashBytes := sha1.Sum(data)
+	hash := hex.EncodeToString(hashBytes[:])
+	key := []byte("obj:" + hash)
+
+	err = r.db.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get(key)
+		if err == nil {
+			return r.checkExisting(item, hash, data) // Object already exists
+		}
+		if err != badger.ErrKeyNotFound {
+			return err
+		}
+		var metadata map[string]string
+		switch c := obj.(type) {
+		case Commit:
+			metadata = c.Metadata
+		case *Commit:
+			metadata = c.Metadata
+		}
+		if err := indexMetadata(txn, hash, metadata); err != nil {
+			return err
+		}
+		return txn.Set(key, r.storedObject(data))
+	})
+	return hash, err
+}
+
+// writeRawObject stores an already-encoded object under its hash, e.g. one
+// received from a remote. The caller is responsible for verifying the hash.
+func (r *Repository) writeRawObject(hash string, data []byte) error {
+	key := []byte("obj:" + hash)
+	return r.db.Update(func(txn *badger.Txn) error {
+		if item, err := txn.Get(key); err == nil {
+			return r.checkExisting(item, hash, data) // Object already exists
+		} else if err != badger.ErrKeyNotFound {
+			return err
+		}
+		if err := indexRawMetadata(txn, hash, data); err != nil {
+			return err
+		}
+		return txn.Set(key, r.storedObject(data))
+	})
+}
+
+// checkExisting is called when an object about to be written is already
+// stored. Normally the hash is trusted; in paranoid mode the stored bytes
+// must equal data, and a difference is reported as corruption or, if the
+// stored bytes do hash correctly, as a hash collision.
+func (r *Repository) checkExisting(item *badger.Item, hash string, data []byte) error {
+	if !r.paranoid {
+		return nil
+	}
+	return item.Value(func(val []byte) error {
+		stored, err := objectEncoding(val)
+		if err != nil {
+			return fmt.Errorf("object %s: %w (%v)", hash, quadstore.ErrObjectMismatch, err)
+		}
+		if bytes.Equal(stored, data) {
+			return nil
+		}
+		if sum := sha1.Sum(stored); hex.EncodeToString(sum[:]) == hash {
+			return fmt.Errorf("object %s: %w (SHA-1 collision)", hash, quadstore.ErrObjectMismatch)
+		}
+		return fmt.Errorf("object %s: %w (stored copy is corrupt)", hash, quadstore.ErrObjectMismatch)
+	})
+}
+
+// verifyObject checks, when verifying on read, that data read from disk
+// still hashes to hash.
+func (r *Repository) verifyObject(hash string, data []byte) error {
+	if !r.verifyObjects {
+		return nil
+	}
+	if sum := sha1.Sum(data); hex.EncodeToString(sum[:]) != hash {
+		return fmt.Errorf("object %s: %w (its bytes hash to %s; run 'quad-db fsck' to find other damaged objects)", hash, quadstore.ErrCorruptObject, hex.EncodeToString(sum[:]))
+	}
+	return nil
+}
+
+// stageObjects makes objects, hash to encoding, readable from r as if they
+// were stored, until release is called. A staged object is not cached, as
+// it may never be stored.
+func (r *Repository) stageObjects(objects map[string][]byte) (release func()) {
+	r.stagedMu.Lock()
+	defer r.stagedMu.Unlock()
+	if r.staged == nil {
+		r.staged = make(map[string][]byte)
+	}
+	for hash, data := range objects {
+		r.staged[hash] = data
+	}
+	return func() {
+		r.stagedMu.Lock()
+		defer r.stagedMu.Unlock()
+		for hash := range objects {
+			delete(r.staged, hash)
+		}
+	}
+}
+
+// stagedObject returns the encoding of a staged object.
+func (r *Repository) stagedObject(hash string) ([]byte, bool) {
+	r.stagedMu.RLock()
+	defer r.stagedMu.RUnlock()
+	data, ok := r.staged[hash]
+	return data, ok
+}
+
+// viewObject calls fn with the encoding of the object stored or staged
+// under hash, which is only valid during the call. what names the kind of
+// object expected, for the error if there is none.
+func (r *Repository) viewObject(hash, what string, fn func(data []byte) error) error {
+	if data, ok := r.stagedObject(hash); ok {
+		return fn(data)
+	}
+	return r.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte("obj:" + hash))
+		if err == badger.ErrKeyNotFound {
+			return fmt.Errorf("%s with hash %s %w", what, hash, quadstore.ErrNotFound)
+		} else if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			data, err := objectEncoding(val)
+			if err != nil {
+				return err
+			}
+			if err := r.verifyObject(hash, data); err != nil {
+				return err
+			}
+			return fn(data)
+		})
+	})
+}
+
+// readObject reads and deserializes any stored object by its hash.
+func (r *Repository) readObject(hash string, v interface{}) error {
+	return r.viewObject(hash, "object", func(data []byte) error {
+		return json.Unmarshal(data, v)
+	})
+}
+
+// readCommit reads and deserializes the commit stored under hash, ignoring
+// replacements; any other kind of object, such as an annotated tag, is an
+// error. The commit may be shared with other callers and must not be
+// modified.
+func (r *Repository) readCommit(hash string) (*Commit, error) {
+	if commit, ok := r.commits.Get(hash); ok {
+		return &commit, nil
+	}
+	var commit Commit
+	err := r.viewObject(hash, "commit", func(data []byte) error {
+		if err := json.Unmarshal(data, &commit); err != nil {
+			return err
+		}
+		if commit.Tree == "" {
+			return fmt.Errorf("object %s is not a commit", hash)
+		}
+		if _, staged := r.stagedObject(hash); !staged {
+			r.commits.Add(hash, commit, int64(len(data)))
+		}
+		return nil
+	})
+	return &commit, err
+}
+
+// readTree reads and deserializes the tree stored under hash. The tree may
+// be shared with other callers and must not be modified.
+func (r *Repository) readTree(hash string) (Tree, error) {
+	if tree, ok := r.trees.Get(hash); ok {
+		return tree, nil
+	}
+	tree := make(Tree)
+	err := r.viewObject(hash, "tree", func(data []byte) error {
+		if err := json.Unmarshal(data, &tree); err != nil {
+			return err
+		}
+		if _, staged := r.stagedObject(hash); !staged {
+			r.trees.Add(hash, tree, int64(len(data)))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// readRawObject returns the stored encoding of an object.
+func (r *Repository) readRawObject(hash string) ([]byte, error) {
+	var data []byte
+	err := r.viewObject(hash, "object", func(encoded []byte) error {
+		data = append([]byte(nil), encoded...)
+		return nil
+	})
+	return data, err
+}
+
+// setReference points a reference (like a branch or HEAD) to a commit hash.
+func (r *Repository) setReference(ref, hash string) error {
+	return r.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte("ref:"+ref), []byte(hash))
+	})
+}
+
+// deleteReference removes a reference.
+func (r *Repository) deleteReference(ref string) error {
+	return r.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte("ref:" + ref))
+	})
+}
+
+// getReference resolves a reference to a commit hash.
+func (r *Repository) getReference(ref string) (string, error) {
+	var hash string
+	err := r.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte("ref:" + ref))
+		if err == badger.ErrKeyNotFound {
+			return fmt.Errorf("reference %s %w", ref, quadstore.ErrRefNotFound)
+		} else if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			hash = string(val)
+			return nil
+		})
+	})
+	return hash, err
+}
+
+// listReferences returns every reference whose name starts with prefix,
+// keyed by name (without the "ref:" key prefix).
+func (r *Repository) listReferences(prefix string) (map[string]string, error) {
+	refs := make(map[string]string)
+	err := r.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		keyPrefix := []byte("ref:" + prefix)
+		for it.Seek(keyPrefix); it.ValidForPrefix(keyPrefix); it.Next() {
+			item := it.Item()
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			refs[strings.TrimPrefix(string(item.Key()), "ref:")] = string(val)
+		}
+		return nil
+	})
+	return refs, err
+}
+
+// renameReference moves the hash of ref old to a new ref, which must not
+// exist yet, and points HEAD at the new ref if it pointed at the old one.
+func (r *Repository) renameReference(old, new string) error {
+	return r.db.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte("ref:" + old))
+		if err == badger.ErrKeyNotFound {
+			return fmt.Errorf("reference %s %w", old, quadstore.ErrRefNotFound)
+		} else if err != nil {
+			return err
+		}
+		hash, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if _, err := txn.Get([]byte("ref:" + new)); err == nil {
+			return fmt.Errorf("reference %s already exists", new)
+		} else if err != badger.ErrKeyNotFound {
+			return err
+		}
+		if err := txn.Set([]byte("ref:"+new), hash); err != nil {
+			return err
+		}
+		if err := txn.Delete([]byte("ref:" + old)); err != nil {
+			return err
+		}
+		if item, err := txn.Get([]byte("ref:HEAD")); err == nil {
+			head, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if string(head) == "ref:"+old {
+				return txn.Set([]byte("ref:HEAD"), []byte("ref:"+new))
+			}
+		}
+		return nil
+	})
+}
+
+// swapReference moves ref from old to new, failing with
+// quadstore.ErrStaleParent if it no longer points at old, e.g. because a
+// commit was built on a branch head that has since moved.
+func (r *Repository) swapReference(ref, old, new string) error {
+	return r.db.Update(func(txn *badger.Txn) error {
+		key := []byte("ref:" + ref)
+		var current string
+		if item, err := txn.Get(key); err == nil {
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			current = string(val)
+		} else if err != badger.ErrKeyNotFound {
+			return err
+		}
+		if current != old {
+			return fmt.Errorf("%s: %w", ref, quadstore.ErrStaleParent)
+		}
+		return txn.Set(key, []byte(new))
+	})
+}
@@ -0,0 +1,170 @@
+// repository_test.go
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+)
+
+// openTestRepository opens a repository of its own at dir/.quad-db (or at
+// the namespace ns in it), with config, JSON or "", written first. It is
+// used only through its methods, so tests using it can run in parallel.
+func openTestRepository(t *testing.T, dir, ns, config string) *Repository {
+	t.Helper()
+	root := filepath.Join(dir, dbPath)
+	path := root
+	if ns != "" {
+		path = filepath.Join(root, filepath.Base(namespacesPath), ns)
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if config != "" {
+		if err := os.WriteFile(filepath.Join(root, "config"), []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := openRepository(path)
+	if err != nil {
+		t.Fatalf("open %s: %v", path, err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func TestOpenRepositoryConfig(t *testing.T) {
+	t.Parallel()
+	for _, tt := range []struct {
+		name          string
+		ns            string
+		config        string
+		paranoid      bool
+		verifyObjects bool
+		codecLevel    int
+		cacheSize     int64
+	}{
+		{name: "defaults", cacheSize: defaultObjectCacheSize},
+		{
+			name:          "settings",
+			config:        `{"core.paranoid": "true", "core.verifyObjects": "true", "core.objectCodec": "zstd-3", "core.objectCacheSize": "1m"}`,
+			paranoid:      true,
+			verifyObjects: true,
+			codecLevel:    3,
+			cacheSize:     1 << 20,
+		},
+		{name: "namespace shares the config", ns: "other", config: `{"core.verifyObjects": "true"}`, verifyObjects: true, cacheSize: defaultObjectCacheSize},
+		{name: "cache disabled", config: `{"core.objectCacheSize": "0"}`},
+		{name: "invalid settings are ignored", config: `{"core.paranoid": "maybe", "core.objectCacheSize": "lots"}`, cacheSize: defaultObjectCacheSize},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			r := openTestRepository(t, t.TempDir(), tt.ns, tt.config)
+			if r.paranoid != tt.paranoid || r.verifyObjects != tt.verifyObjects || r.codecLevel != tt.codecLevel {
+				t.Errorf("paranoid, verifyObjects, codecLevel = %v, %v, %d; want %v, %v, %d",
+					r.paranoid, r.verifyObjects, r.codecLevel, tt.paranoid, tt.verifyObjects, tt.codecLevel)
+			}
+			if got := r.cacheStats().MaxCost; got != tt.cacheSize {
+				t.Errorf("object cache size = %d, want %d", got, tt.cacheSize)
+			}
+		})
+	}
+}
+
+func TestRepositoriesAreIndependent(t *testing.T) {
+	t.Parallel()
+	a := openTestRepository(t, t.TempDir(), "", "")
+	b := openTestRepository(t, t.TempDir(), "", "")
+
+	tree, err := a.writeObject(Tree{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := Commit{Tree: tree, Author: "Test", Message: "only in a", Timestamp: time.Unix(0, 0).UTC()}
+	hash, err := a.writeObject(commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.setReference("main", hash); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		read func(r *Repository) error
+	}{
+		{"commit", func(r *Repository) error { _, err := r.readCommit(hash); return err }},
+		{"tree", func(r *Repository) error { _, err := r.readTree(tree); return err }},
+		{"reference", func(r *Repository) error { _, err := r.getReference("main"); return err }},
+	} {
+		// Reading from a first fills its caches; b must not see them.
+		if err := tt.read(a); err != nil {
+			t.Errorf("%s in a: %v", tt.name, err)
+		}
+		if err := tt.read(b); err == nil {
+			t.Errorf("%s of a found in b", tt.name)
+		}
+	}
+	if got := b.cacheStats().Entries; got != 0 {
+		t.Errorf("b caches %d object(s) it does not have", got)
+	}
+	if _, err := b.readCommit(hash); !errors.Is(err, quadstore.ErrNotFound) {
+		t.Errorf("commit of a read from b: %v, want ErrNotFound", err)
+	}
+}
+
+func TestRepositoryVerifiesItsOwnObjects(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	trusting := openTestRepository(t, dir, "", "")
+	tree, err := trusting.writeObject(Tree{"http://example.org/g": {Blob: "0000000000000000000000000000000000000000"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := trusting.readTree(tree); err != nil {
+		t.Fatal(err)
+	}
+
+	// A second repository verifying on read must check the bytes it
+	// stores, even though the first has the tree cached.
+	verifying := openTestRepository(t, t.TempDir(), "", `{"core.verifyObjects": "true"}`)
+	err = verifying.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte("obj:"+tree), []byte(`{"http://example.org/g":"corrupt"}`))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifying.readTree(tree); !errors.Is(err, quadstore.ErrCorruptObject) {
+		t.Errorf("reading a corrupt tree: %v, want ErrCorruptObject", err)
+	}
+	if _, err := trusting.readTree(tree); err != nil {
+		t.Errorf("reading the intact tree: %v", err)
+	}
+}
+
+func TestStagedObjectsAreNotKept(t *testing.T) {
+	t.Parallel()
+	r := openTestRepository(t, t.TempDir(), "", "")
+	data, err := encodeObject(Commit{Tree: "0000000000000000000000000000000000000000", Author: "Test", Message: "staged", Timestamp: time.Unix(0, 0).UTC()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha1.Sum(data)
+	hash := hex.EncodeToString(sum[:])
+
+	release := r.stageObjects(map[string][]byte{hash: data})
+	if commit, err := r.readCommit(hash); err != nil || commit.Message != "staged" {
+		t.Fatalf("reading a staged commit: %v, %v", commit, err)
+	}
+	release()
+	if _, err := r.readCommit(hash); !errors.Is(err, quadstore.ErrNotFound) {
+		t.Errorf("reading a released commit: %v, want ErrNotFound", err)
+	}
+}
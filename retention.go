@@ -0,0 +1,397 @@
+// retention.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// retentionPeriods are the spans old history is rolled up by, each with
+// the key that names a commit's span.
+var retentionPeriods = map[string]func(t time.Time) string{
+	"day":   func(t time.Time) string { return t.Format("2006-01-02") },
+	"week":  func(t time.Time) string { y, w := t.ISOWeek(); return fmt.Sprintf("%d-W%02d", y, w) },
+	"month": func(t time.Time) string { return t.Format("2006-01") },
+	"year":  func(t time.Time) string { return t.Format("2006") },
+}
+
+// A retentionPolicy says which history of a branch to squash: the
+// first-parent commits made before cutoff, one rollup per period, keeping
+// every commit in keep.
+type retentionPolicy struct {
+	cutoff time.Time
+	period func(t time.Time) string
+	keep   map[string]bool
+}
+
+// loadRetentionPolicy builds the policy from --days and --period, or the
+// retention.days and retention.period options; the period defaults to a
+// month.
+func loadRetentionPolicy(cmd *cobra.Command) (*retentionPolicy, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	days, _ := cmd.Flags().GetInt("days")
+	if !cmd.Flags().Changed("days") {
+		v := cfg.Get("retention.days")
+		if v == "" {
+			return nil, fmt.Errorf("give --days or set retention.days")
+		}
+		if days, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid retention.days %q (use a number of days)", v)
+		}
+	}
+	if days < 1 {
+		return nil, fmt.Errorf("invalid retention of %d day(s) (use at least 1)", days)
+	}
+	name, _ := cmd.Flags().GetString("period")
+	if !cmd.Flags().Changed("period") {
+		if v := cfg.Get("retention.period"); v != "" {
+			name = v
+		}
+	}
+	period, ok := retentionPeriods[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown period %q (want day, week, month or year)", name)
+	}
+	keep, err := taggedCommits()
+	if err != nil {
+		return nil, err
+	}
+	return &retentionPolicy{cutoff: clock.Now().AddDate(0, 0, -days), period: period, keep: keep}, nil
+}
+
+// taggedCommits returns the commits tags point at, through annotated tag
+// objects.
+func taggedCommits() (map[string]bool, error) {
+	tags, err := listReferences("tag:")
+	if err != nil {
+		return nil, err
+	}
+	tagged := make(map[string]bool, len(tags))
+	for _, hash := range tags {
+		commit, err := peelTag(hash)
+		if err != nil {
+			continue // Tags of other objects constrain nothing
+		}
+		tagged[commit] = true
+	}
+	return tagged, nil
+}
+
+// A retentionRewrite squashes history under a policy. Rewritten commits
+// are shared between branches, which share their history.
+type retentionRewrite struct {
+	policy  *retentionPolicy
+	dryRun  bool
+	commits map[string]string // Original commit to the one replacing it
+
+	rollups, squashed, tagged int
+}
+
+// squash rewrites the first-parent history of tip and returns its new tip.
+// Commits made before the cutoff are grouped by period, and each group of
+// two or more becomes a rollup commit holding the group's last tree.
+// Tagged commits end the group they fall in and are kept as they were,
+// only on a new parent, so that releases stay checkable. Later commits are
+// kept too, and lose their signatures only if their parents change.
+func (rw *retentionRewrite) squash(tip string) (string, error) {
+	var chain []string
+	var commits []*Commit
+	err := walkCommits(tip, func(hash string, commit *Commit) error {
+		chain = append(chain, hash)
+		commits = append(commits, commit)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	// History is squashed up to the newest commit before the cutoff, even
+	// if commits before it carry later times.
+	old := len(chain)
+	for i, commit := range commits {
+		if commit.Timestamp.Before(rw.policy.cutoff) {
+			old = i
+			break
+		}
+	}
+
+	parent := "" // The rewritten commit the next one goes on
+	var group []int
+	flush := func() error {
+		switch len(group) {
+		case 0:
+			return nil
+		case 1:
+			hash, err := rw.keep(chain[group[0]], commits[group[0]], parent)
+			parent, group = hash, nil
+			return err
+		}
+		hash, err := rw.rollup(chain, commits, group, parent)
+		parent, group = hash, nil
+		return err
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		hash, commit := chain[i], commits[i]
+		if i < old || rw.policy.keep[hash] {
+			if i >= old {
+				rw.tagged++
+			}
+			if err := flush(); err != nil {
+				return "", err
+			}
+			if parent, err = rw.keep(hash, commit, parent); err != nil {
+				return "", err
+			}
+			continue
+		}
+		if len(group) > 0 && rw.policy.period(commits[group[0]].Timestamp.UTC()) != rw.policy.period(commit.Timestamp.UTC()) {
+			if err := flush(); err != nil {
+				return "", err
+			}
+		}
+		group = append(group, i)
+	}
+	if err := flush(); err != nil {
+		return "", err
+	}
+	return parent, nil
+}
+
+// keep rewrites a commit onto parent, the rewritten version of its first
+// parent. Its other parents are rewritten if they were, and kept if not.
+func (rw *retentionRewrite) keep(hash string, commit *Commit, parent string) (string, error) {
+	if rewritten, ok := rw.commits[hash]; ok {
+		return rewritten, nil
+	}
+	parents := append([]string(nil), commit.Parents...)
+	if len(parents) > 0 {
+		parents[0] = parent
+	}
+	for i := 1; i < len(parents); i++ {
+		if rewritten, ok := rw.commits[parents[i]]; ok {
+			parents[i] = rewritten
+		}
+	}
+	changed := false
+	for i := range parents {
+		changed = changed || parents[i] != commit.Parents[i]
+	}
+	if !changed || rw.dryRun {
+		rw.commits[hash] = hash
+		return hash, nil
+	}
+	rewritten := *commit
+	rewritten.Parents = parents
+	rewritten.Signature = ""
+	newHash, err := writeObject(rewritten)
+	if err != nil {
+		return "", err
+	}
+	rw.commits[hash] = newHash
+	return newHash, nil
+}
+
+// rollup replaces the commits of a group, given by their index in chain
+// from the newest, with one commit on parent.
+func (rw *retentionRewrite) rollup(chain []string, commits []*Commit, group []int, parent string) (string, error) {
+	first, last := commits[group[0]], commits[group[len(group)-1]]
+	firstHash, lastHash := chain[group[0]], chain[group[len(group)-1]]
+	rw.rollups++
+	rw.squashed += len(group)
+	if rw.dryRun {
+		for _, i := range group {
+			rw.commits[chain[i]] = chain[i]
+		}
+		return lastHash, nil
+	}
+
+	rollup := Commit{
+		Tree:   last.Tree,
+		Author: last.Author,
+		Message: fmt.Sprintf("Roll up %d commits of %s\n\nSquashed %s..%s, %s to %s, by retention policy.",
+			len(group), rw.policy.period(first.Timestamp.UTC()), shortHash(firstHash), shortHash(lastHash),
+			first.Timestamp.UTC().Format("2006-01-02"), last.Timestamp.UTC().Format("2006-01-02")),
+		Timestamp: last.Timestamp,
+		Metadata: map[string]string{
+			"rollup":         rw.policy.period(first.Timestamp.UTC()),
+			"rollup-commits": strconv.Itoa(len(group)),
+			"rollup-first":   firstHash,
+			"rollup-last":    lastHash,
+		},
+	}
+	before := map[string]quadSet{}
+	if parent != "" {
+		rollup.Parents = []string{parent}
+		var err error
+		if before, err = loadState(parent); err != nil {
+			return "", err
+		}
+	}
+	after, err := loadTreeState(context.Background(), rollup.Tree, "")
+	if err != nil {
+		return "", err
+	}
+	if rollup.Stats, err = computeStats(before, after); err != nil {
+		return "", err
+	}
+	hash, err := writeObject(rollup)
+	if err != nil {
+		return "", err
+	}
+	for _, i := range group {
+		rw.commits[chain[i]] = hash
+	}
+	return hash, nil
+}
+
+// retagRewritten moves tags whose commit was rewritten. Annotated tag
+// objects are rewritten to name the new commit, without their signature.
+func retagRewritten(commits map[string]string) ([]string, error) {
+	tags, err := listReferences("tag:")
+	if err != nil {
+		return nil, err
+	}
+	var moved []string
+	for name, hash := range tags {
+		target, err := peelTag(hash)
+		if err != nil || commits[target] == "" || commits[target] == target {
+			continue
+		}
+		newHash := commits[target]
+		if target != hash {
+			if newHash, err = retargetTagObject(hash, commits); err != nil {
+				return nil, fmt.Errorf("%s: %v", publicRefName(name), err)
+			}
+		}
+		if err := swapReference(name, hash, newHash); err != nil {
+			return nil, err
+		}
+		moved = append(moved, publicRefName(name))
+	}
+	sort.Strings(moved)
+	return moved, nil
+}
+
+// retargetTagObject rewrites an annotated tag object, and any it tags in
+// turn, to point at the rewritten commit.
+func retargetTagObject(hash string, commits map[string]string) (string, error) {
+	if rewritten, ok := commits[hash]; ok {
+		return rewritten, nil
+	}
+	data, err := readRawObject(hash)
+	if err != nil {
+		return "", err
+	}
+	var tag map[string]interface{}
+	if err := json.Unmarshal(data, &tag); err != nil {
+		return "", err
+	}
+	object, _ := tag["object"].(string)
+	if object == "" {
+		return hash, nil
+	}
+	if tag["object"], err = retargetTagObject(object, commits); err != nil {
+		return "", err
+	}
+	delete(tag, "signature")
+	return writeObject(tag)
+}
+
+var retentionCmd = &cobra.Command{
+	Use:   "retention",
+	Short: "Bound the history of append-heavy branches",
+}
+
+var retentionApplyCmd = &cobra.Command{
+	Use:   "apply [--days <n>] [--period day|week|month|year] [--branch <name>]...",
+	Short: "Squash old history into periodic rollup commits",
+	Long: `Rewrite the history of every branch, or those given with --branch, so that
+commits older than --days (retention.days) are squashed into one rollup
+commit per --period (retention.period, month by default). A rollup holds
+the dataset as the last commit of its period left it, and its metadata
+names the period and the commits it replaced. Only first-parent history
+is squashed; the other parents of merges after the cutoff are kept.
+
+Tagged commits are never squashed: each ends the rollup of its period and
+is kept as it was, on a rolled-up parent, and its tags are moved to it, so
+that every release can still be checked out and verified. Commits newer
+than the cutoff are kept too.
+
+Rewritten commits change hash and lose their signatures, as with
+'redact': everyone else must fetch the rewritten branches, and remotes
+must be pushed to with care. The squashed commits are no longer reachable
+from the branches. Review what would change with --dry-run first.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		policy, err := loadRetentionPolicy(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if staged, err := stagedChanges(); err != nil {
+			log.Fatalf("Failed to read the staging area: %v", err)
+		} else if staged {
+			log.Fatal("Commit or reset the staged changes before rewriting history.")
+		}
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		heads, err := listReferences("head:")
+		if err != nil {
+			log.Fatalf("Failed to list branches: %v", err)
+		}
+		names, _ := cmd.Flags().GetStringArray("branch")
+		if len(names) == 0 {
+			for name := range heads {
+				names = append(names, name[len("head:"):])
+			}
+		}
+		sort.Strings(names)
+
+		rw := &retentionRewrite{policy: policy, dryRun: dryRun, commits: make(map[string]string)}
+		moved := make(map[string]string)
+		for _, branch := range names {
+			tip, ok := heads["head:"+branch]
+			if !ok {
+				log.Fatalf("No branch named %s", branch)
+			}
+			newTip, err := rw.squash(tip)
+			if err != nil {
+				log.Fatalf("Failed to rewrite %s: %v", branch, err)
+			}
+			if newTip != tip {
+				moved[branch] = newTip
+			}
+		}
+		if dryRun {
+			fmt.Printf("Would squash %d commit(s) older than %s into %d rollup(s), keeping %d tagged commit(s)\n",
+				rw.squashed, policy.cutoff.Format("2006-01-02"), rw.rollups, rw.tagged)
+			return
+		}
+		for _, branch := range names {
+			newTip, ok := moved[branch]
+			if !ok {
+				continue
+			}
+			if err := swapReference("head:"+branch, heads["head:"+branch], newTip); err != nil {
+				log.Fatalf("Failed to update %s: %v", branch, err)
+			}
+			fmt.Printf("refs/heads/%s: %s -> %s\n", branch, shortHash(heads["head:"+branch]), shortHash(newTip))
+		}
+		tags, err := retagRewritten(rw.commits)
+		if err != nil {
+			log.Fatalf("Failed to move tags: %v", err)
+		}
+		for _, tag := range tags {
+			fmt.Printf("%s: moved to its rewritten commit\n", tag)
+		}
+		fmt.Printf("Squashed %d commit(s) older than %s into %d rollup(s)\n", rw.squashed, policy.cutoff.Format("2006-01-02"), rw.rollups)
+	},
+}
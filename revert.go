@@ -0,0 +1,202 @@
+// revert.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+	"github.com/spf13/cobra"
+)
+
+// revertChanges returns the graphs of head that undo diffs, the changes a
+// commit made, and the conflicts doing so meets: quads the commit added
+// that head no longer has, values the commit set that a later commit has
+// changed again, and renames later changes have made impossible to undo.
+// A graph mapped to an empty set is deleted.
+func revertChanges(head map[string]quadSet, diffs []graphDiff) (map[string]quadSet, []quadstore.Conflict) {
+	graphs := make(map[string]quadSet)
+	var conflicts []quadstore.Conflict
+	for _, d := range diffs {
+		current, ok := head[d.Graph]
+		if !ok && len(d.Added) > 0 || d.RenamedFrom != "" && (!ok || len(head[d.RenamedFrom]) > 0) {
+			conflicts = append(conflicts, quadstore.Conflict{
+				Type:        "GRAPH_CHANGED",
+				Description: fmt.Sprintf("%s has been deleted or renamed since", d.Graph),
+				Graph:       d.Graph,
+			})
+			continue
+		}
+		reverted := make(quadSet, len(current))
+		for line := range current {
+			reverted[line] = true
+		}
+
+		var missing []string
+		for _, line := range d.Added {
+			if !reverted[line] {
+				missing = append(missing, line)
+			}
+			delete(reverted, line)
+		}
+		if len(missing) > 0 {
+			conflicts = append(conflicts, quadstore.Conflict{
+				Type:        "ALREADY_REMOVED",
+				Description: fmt.Sprintf("%d quad(s) the commit added to %s have been removed since", len(missing), d.Graph),
+				Graph:       d.Graph,
+				Conflicting: missing,
+			})
+		}
+
+		// A value the commit changed, i.e. the subject and predicate it
+		// both deleted and added, must not have changed again since, or
+		// restoring the old value would leave two.
+		added := make(map[string]bool)
+		for _, line := range d.Added {
+			if q, err := parseQuad(line); err == nil {
+				added[q.Subject+" "+q.Predicate] = true
+			}
+		}
+		changed := make(map[string]bool)
+		for _, line := range d.Deleted {
+			reverted[line] = true
+			if q, err := parseQuad(line); err == nil && added[q.Subject+" "+q.Predicate] {
+				changed[q.Subject+" "+q.Predicate] = true
+			}
+		}
+		if len(changed) > 0 {
+			var later []string
+			for line := range current {
+				q, err := parseQuad(line)
+				if err == nil && changed[q.Subject+" "+q.Predicate] && reverted[line] && !containsLine(d.Deleted, line) {
+					later = append(later, line)
+				}
+			}
+			if len(later) > 0 {
+				sort.Strings(later)
+				conflicts = append(conflicts, quadstore.Conflict{
+					Type:        "CHANGED_SINCE",
+					Description: fmt.Sprintf("values the commit set in %s have been changed again since", d.Graph),
+					Graph:       d.Graph,
+					Conflicting: later,
+				})
+			}
+		}
+
+		if d.RenamedFrom != "" {
+			graphs[d.Graph] = quadSet{}
+			graphs[d.RenamedFrom] = reverted
+		} else {
+			graphs[d.Graph] = reverted
+		}
+	}
+	return graphs, conflicts
+}
+
+func containsLine(lines []string, line string) bool {
+	for _, l := range lines {
+		if l == line {
+			return true
+		}
+	}
+	return false
+}
+
+var revertCmd = &cobra.Command{
+	Use:   "revert <commit>",
+	Short: "Commit the inverse of an earlier commit",
+	Long: `Undo a commit with a new one on the current branch: the quads it added
+are deleted, the quads it deleted are added back, and graphs it renamed get
+their old names back. The index is left alone.
+
+Revert refuses if later commits conflict with undoing it: if quads it added
+have been removed since, or values it set have been changed again, so that
+restoring the old ones would leave both, or graphs it touched have been
+deleted or renamed. The conflicts are listed; --no-verify reverts anyway,
+as far as it can.
+
+A merge commit is reverted against the parent --mainline names (1 for the
+branch merged into), and is refused without it.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if mergeInProgress() {
+			log.Fatal("A merge stopped at conflicts; finish it with 'quad-db merge --continue' or 'quad-db merge --abort' first.")
+		}
+		branch := currentBranch()
+		if branch == "" {
+			log.Fatal("HEAD is detached; check out a branch to revert on.")
+		}
+		hash, err := resolveCommitish(args[0])
+		if err != nil {
+			log.Fatalf("Could not resolve %s: %v", args[0], err)
+		}
+		commit, err := readCommit(hash)
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", args[0], err)
+		}
+		mainline, _ := cmd.Flags().GetInt("mainline")
+		var parent string
+		switch {
+		case len(commit.Parents) > 1 && mainline == 0:
+			log.Fatalf("Commit %s is a merge; use --mainline to say which parent to revert against.", shortHash(hash))
+		case mainline > len(commit.Parents) || mainline < 0:
+			log.Fatalf("Commit %s has no parent %d.", shortHash(hash), mainline)
+		case mainline > 0:
+			parent = commit.Parents[mainline-1]
+		case len(commit.Parents) == 1:
+			parent = commit.Parents[0]
+		}
+
+		before := map[string]quadSet{}
+		if parent != "" {
+			if before, err = loadState(parent); err != nil {
+				log.Fatalf("Failed to read the parent of %s: %v", args[0], err)
+			}
+		}
+		after, err := loadState(hash)
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", args[0], err)
+		}
+		diffs, err := diffStates(context.Background(), before, after)
+		if err != nil {
+			log.Fatalf("Failed to compute the changes of %s: %v", args[0], err)
+		}
+		headHash, err := resolveHead()
+		if err != nil {
+			log.Fatalf("Failed to resolve HEAD: %v", err)
+		}
+		head, err := loadState(headHash)
+		if err != nil {
+			log.Fatalf("Failed to read HEAD: %v", err)
+		}
+
+		graphs, conflicts := revertChanges(head, diffs)
+		printConflicts(os.Stdout, conflicts)
+		if noVerify, _ := cmd.Flags().GetBool("no-verify"); len(conflicts) > 0 && !noVerify {
+			log.Fatalf("Reverting %s conflicts with later changes; nothing was committed. Use --no-verify to revert anyway.", shortHash(hash))
+		}
+
+		message, _ := cmd.Flags().GetString("message")
+		if message == "" {
+			subject := strings.SplitN(commit.Message, "\n", 2)[0]
+			message = fmt.Sprintf("Revert \"%s\"\n\nThis reverts commit %s.", subject, hash)
+		}
+		reverted, err := commitReplacingGraphs(branch, headHash, graphs, message, map[string]string{"revert": hash}, actingIdentity())
+		if errors.Is(err, quadstore.ErrStaleParent) {
+			log.Fatalf("Branch %s moved while the revert was prepared; try again.", branch)
+		}
+		if err != nil {
+			log.Fatalf("Failed to commit the revert: %v", err)
+		}
+		if reverted == "" {
+			fmt.Printf("Nothing to revert: HEAD already undoes %s.\n", shortHash(hash))
+			return
+		}
+		fmt.Printf("[%s] %s\n", shortHash(reverted), strings.SplitN(message, "\n", 2)[0])
+	},
+}
@@ -0,0 +1,187 @@
+// revparse.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+)
+
+// minHashPrefix is the shortest abbreviated hash resolveCommitish accepts.
+const minHashPrefix = 4
+
+var hexPrefix = regexp.MustCompile(`^[0-9a-f]+$`)
+
+// resolveCommitish resolves a revision to a commit hash. A revision is a
+// name followed by any number of ancestry operators:
+//
+//	HEAD, main, v1.0, origin/main   HEAD, a branch, a tag or a remote-tracking branch
+//	a1b2c3d                         a full or unique abbreviated commit hash
+//	<rev>~N                         the Nth first-parent ancestor (~ alone is ~1)
+//	<rev>^N                         the Nth parent (^ alone is ^1, ^0 the commit itself)
+//	<rev>^{commit}, <rev>^{}        the commit an annotated tag points to
+//
+// so HEAD~2^2 is the second parent of the grandparent of HEAD.
+func resolveCommitish(name string) (string, error) {
+	base, ops := name, ""
+	if i := strings.IndexAny(name, "~^"); i >= 0 {
+		base, ops = name[:i], name[i:]
+	}
+	if base == "" {
+		return "", fmt.Errorf("invalid revision %q", name)
+	}
+	hash, err := resolveRevisionName(base)
+	if err != nil {
+		return "", err
+	}
+	if hash, err = peelTag(hash); err != nil {
+		return "", err
+	}
+
+	for ops != "" {
+		op := ops[0]
+		ops = ops[1:]
+		if op == '^' && strings.HasPrefix(ops, "{") {
+			end := strings.IndexByte(ops, '}')
+			if end < 0 {
+				return "", fmt.Errorf("invalid revision %q: unterminated ^{", name)
+			}
+			if kind := ops[1:end]; kind != "" && kind != "commit" {
+				return "", fmt.Errorf("invalid revision %q: ^{%s} is not supported", name, kind)
+			}
+			ops = ops[end+1:]
+			continue // Tags were peeled above
+		}
+		digits := len(ops) - len(strings.TrimLeft(ops, "0123456789"))
+		n := 1
+		if digits > 0 {
+			if n, err = strconv.Atoi(ops[:digits]); err != nil {
+				return "", fmt.Errorf("invalid revision %q", name)
+			}
+			ops = ops[digits:]
+		}
+		if op == '~' {
+			for i := 0; i < n; i++ {
+				if hash, err = nthParent(hash, 1, name); err != nil {
+					return "", err
+				}
+			}
+		} else if hash, err = nthParent(hash, n, name); err != nil {
+			return "", err
+		}
+	}
+	return hash, nil
+}
+
+// nthParent returns the nth parent of a commit; n == 0 is the commit itself.
+func nthParent(hash string, n int, rev string) (string, error) {
+	commit, err := readCommit(hash)
+	if err != nil {
+		return "", err
+	}
+	if n == 0 {
+		return hash, nil
+	}
+	if n > len(commit.Parents) {
+		return "", fmt.Errorf("%s: commit %s has %d parent(s)", rev, shortHash(hash), len(commit.Parents))
+	}
+	return commit.Parents[n-1], nil
+}
+
+// resolveRevisionName resolves a revision without ancestry operators to a
+// commit or tag object hash. Branches win over tags, which win over
+// remote-tracking branches and hashes.
+func resolveRevisionName(name string) (string, error) {
+	if name == "HEAD" {
+		return resolveHead()
+	}
+	for _, prefix := range []string{"head:", "tag:", "remote:"} {
+		if hash, err := getReference(prefix + name); err == nil {
+			return hash, nil
+		}
+	}
+	if !hexPrefix.MatchString(name) || len(name) < minHashPrefix {
+		return "", fmt.Errorf("revision %s %w: no branch, tag or commit has that name", name, quadstore.ErrRefNotFound)
+	}
+	matches, err := objectsWithPrefix(name)
+	if err != nil {
+		return "", err
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("revision %s %w: no branch, tag or commit has that name", name, quadstore.ErrRefNotFound)
+	case 1:
+		return matches[0], nil
+	}
+	return "", fmt.Errorf("abbreviated hash %s is ambiguous: it matches %d commits", name, len(matches))
+}
+
+// objectsWithPrefix returns the hashes of the commits and tag objects whose
+// hashes start with prefix; trees and blobs are skipped.
+func objectsWithPrefix(prefix string) ([]string, error) {
+	var hashes []string
+	key := []byte("obj:" + prefix)
+	err := repo.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = key
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			var obj struct {
+				Tree   string `json:"tree"`
+				Object string `json:"object"`
+			}
+			err := it.Item().Value(func(val []byte) error {
+				data, err := objectEncoding(val)
+				if err != nil {
+					return err
+				}
+				if len(data) > 0 && data[0] == '{' {
+					json.Unmarshal(data, &obj) // Trees do not decode; they are skipped
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if obj.Tree != "" || obj.Object != "" {
+				hashes = append(hashes, strings.TrimPrefix(string(it.Item().Key()), "obj:"))
+			}
+		}
+		return nil
+	})
+	return hashes, err
+}
+
+// peelTag follows annotated tag objects, which record the hash of the
+// object they tag in an "object" field, until it reaches a commit.
+func peelTag(hash string) (string, error) {
+	for depth := 0; ; depth++ {
+		if _, err := readCommit(hash); err == nil {
+			return hash, nil
+		}
+		data, err := readRawObject(hash)
+		if err != nil {
+			return "", err
+		}
+		var obj struct {
+			Tree   string `json:"tree"`
+			Object string `json:"object"`
+		}
+		if len(data) == 0 || data[0] != '{' || json.Unmarshal(data, &obj) != nil || (obj.Tree == "" && obj.Object == "") {
+			return "", fmt.Errorf("object %s is not a commit", shortHash(hash))
+		}
+		if obj.Object == "" {
+			return hash, nil
+		}
+		if depth > 10 {
+			return "", fmt.Errorf("tag %s is nested too deeply", shortHash(hash))
+		}
+		hash = obj.Object
+	}
+}
@@ -0,0 +1,153 @@
+// savedquery.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mannyrivera2010/go-quadgit/internal/sparql"
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+	"github.com/mannyrivera2010/go-quadgit/pkg/rdfio"
+)
+
+// Saved queries are versioned with the data: each is a graph under
+// savedQueryBase, which the tree stores in one queries/ subtree, holding
+// the query text as a SHACL SPARQL executable (sh:select, sh:construct or
+// sh:ask) and optionally an rdfs:comment.
+const (
+	savedQueryBase = "quadgit://queries/"
+	shaclNS        = "http://www.w3.org/ns/shacl#"
+	rdfsComment    = "<http://www.w3.org/2000/01/rdf-schema#comment>"
+)
+
+// savedQueryGraph returns the graph a saved query is stored in.
+func savedQueryGraph(name string) string {
+	return "<" + savedQueryBase + name + ">"
+}
+
+// isSavedQueryGraph reports whether a stored graph holds a saved query.
+func isSavedQueryGraph(graph string) bool {
+	return strings.HasPrefix(graph, "<"+savedQueryBase)
+}
+
+// validQueryName reports whether a name can name a saved query: path
+// segments of letters, digits, '-', '_' and '.', separated by '/'.
+func validQueryName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, seg := range strings.Split(name, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return false
+		}
+		for _, r := range seg {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_.", r)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// savedQuery is a saved query as read from a snapshot.
+type savedQuery struct {
+	Name    string
+	Text    string
+	Comment string
+}
+
+// readSavedQuery reads a saved query from a snapshot.
+func readSavedQuery(snap *snapshot, name string) (*savedQuery, error) {
+	graph := savedQueryGraph(name)
+	blobHash, ok := snap.graphs[graph]
+	if !ok {
+		return nil, fmt.Errorf("no saved query %s at %s", name, shortHash(snap.hash))
+	}
+	blob, err := readBlob(blobHash)
+	if err != nil {
+		return nil, err
+	}
+	sq := &savedQuery{Name: name}
+	for _, line := range blob {
+		q, err := parseQuad(line)
+		if err != nil || q.Subject != graph {
+			continue
+		}
+		lexical, _, _, err := rdfio.LiteralParts(q.Object)
+		if err != nil {
+			continue
+		}
+		switch q.Predicate {
+		case "<" + shaclNS + "select>", "<" + shaclNS + "construct>", "<" + shaclNS + "ask>":
+			sq.Text = lexical
+		case rdfsComment:
+			sq.Comment = lexical
+		}
+	}
+	if sq.Text == "" {
+		return nil, fmt.Errorf("saved query %s holds no sh:select, sh:construct or sh:ask", name)
+	}
+	return sq, nil
+}
+
+// savedQueryNames lists the saved queries of a snapshot.
+func savedQueryNames(snap *snapshot) []string {
+	var names []string
+	for _, graph := range snap.graphNames() {
+		if isSavedQueryGraph(graph) {
+			names = append(names, strings.TrimSuffix(strings.TrimPrefix(graph, "<"+savedQueryBase), ">"))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// stageSavedQuery stages a query under name, replacing any saved query of
+// that name when committed.
+func stageSavedQuery(name, text, comment string) error {
+	q, err := sparql.Parse(text)
+	if err != nil {
+		return err
+	}
+	graph := savedQueryGraph(name)
+	lines := []string{formatQuad(quadstore.Quad{
+		Subject:   graph,
+		Predicate: "<" + shaclNS + strings.ToLower(q.Form) + ">",
+		Object:    rdfio.QuoteString(text),
+		Graph:     graph,
+	})}
+	if comment != "" {
+		lines = append(lines, formatQuad(quadstore.Quad{Subject: graph, Predicate: rdfsComment, Object: rdfio.QuoteString(comment), Graph: graph}))
+	}
+	_, err = stageLines(context.Background(), lines)
+	return err
+}
+
+// parseQueryParams turns name=value parameters into initial bindings for
+// q. A value in N-Triples syntax (<iri>, "literal", "x"@en, "1"^^<type>)
+// is used as is; any other value is a plain string literal.
+func parseQueryParams(q *sparql.Query, params []string) (sparql.Binding, error) {
+	known := make(map[string]bool)
+	for _, v := range q.PatternVars() {
+		known[v] = true
+	}
+	b := make(sparql.Binding, len(params))
+	for _, param := range params {
+		name, value, ok := strings.Cut(param, "=")
+		name = strings.TrimLeft(name, "?$")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid parameter %q: expected name=value", param)
+		}
+		if !known[name] {
+			return nil, fmt.Errorf("parameter %s is not a variable of the query", name)
+		}
+		if term, n, err := scanTerm(value); err == nil && n == len(value) && !strings.HasPrefix(term, "_:") {
+			b[name] = term
+		} else {
+			b[name] = rdfio.QuoteString(value)
+		}
+	}
+	return b, nil
+}
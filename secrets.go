@@ -0,0 +1,186 @@
+// secrets.go
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A secretRule flags literals that look like a kind of credential.
+type secretRule struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+// builtinSecretRules recognize credentials by their well-known shapes, so
+// that they are caught with few false positives.
+var builtinSecretRules = []secretRule{
+	{"aws-access-key", regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"github-token", regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})`)},
+	{"gitlab-token", regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20,}`)},
+	{"slack-token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`)},
+	{"google-api-key", regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}`)},
+	{"stripe-key", regexp.MustCompile(`\b[rs]k_live_[0-9A-Za-z]{16,}`)},
+	{"jwt", regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}`)},
+	{"private-key", regexp.MustCompile(`-----BEGIN ([A-Z]+ )?PRIVATE KEY( BLOCK)?-----`)},
+	{"quad-db-token", regexp.MustCompile(`\bqdb_[0-9a-f]{48}\b`)},
+}
+
+// emailRule flags email addresses, which are personal data rather than
+// credentials, so only with secrets.emails.
+var emailRule = secretRule{"email", regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)}
+
+// A secretScanner holds the rules and allowlist the repository's options
+// configure:
+//
+//	secrets.scan            false turns scanning off
+//	secrets.emails          true also flags email addresses
+//	secrets.pattern.<name>  an extra rule, as a regular expression
+//	secrets.allow.<name>    a regular expression; literals it matches,
+//	                        e.g. documented example keys, are never flagged
+type secretScanner struct {
+	rules []secretRule
+	allow []*regexp.Regexp
+}
+
+// loadSecretScanner returns the configured scanner, or nil if scanning is
+// turned off.
+func loadSecretScanner() (*secretScanner, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	enabled := func(key string, def bool) (bool, error) {
+		v := cfg.Get(key)
+		if v == "" {
+			return def, nil
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, fmt.Errorf("invalid %s %q (use true or false)", key, v)
+		}
+		return b, nil
+	}
+	if on, err := enabled("secrets.scan", true); err != nil || !on {
+		return nil, err
+	}
+	s := &secretScanner{rules: append([]secretRule(nil), builtinSecretRules...)}
+	if on, err := enabled("secrets.emails", false); err != nil {
+		return nil, err
+	} else if on {
+		s.rules = append(s.rules, emailRule)
+	}
+	for _, key := range cfg.Keys() {
+		if name, ok := strings.CutPrefix(key, "secrets.pattern."); ok {
+			re, err := regexp.Compile(cfg.Get(key))
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", key, err)
+			}
+			s.rules = append(s.rules, secretRule{name, re})
+		} else if strings.HasPrefix(key, "secrets.allow.") {
+			re, err := regexp.Compile(cfg.Get(key))
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", key, err)
+			}
+			s.allow = append(s.allow, re)
+		}
+	}
+	return s, nil
+}
+
+// A secretFinding is a literal a rule flagged.
+type secretFinding struct {
+	Rule      string
+	Graph     string
+	Subject   string
+	Predicate string
+	Value     string
+}
+
+// scan returns the findings in one literal's lexical form.
+func (s *secretScanner) scan(value string) []string {
+	for _, re := range s.allow {
+		if re.MatchString(value) {
+			return nil
+		}
+	}
+	var rules []string
+	for _, r := range s.rules {
+		if r.pattern.MatchString(value) {
+			rules = append(rules, r.name)
+		}
+	}
+	return rules
+}
+
+// scanAdded checks the literals of the quads after adds to before, the
+// only ones a commit can bring into history.
+func (s *secretScanner) scanAdded(before, after map[string]quadSet) []secretFinding {
+	var findings []secretFinding
+	for graph, set := range after {
+		for line := range set {
+			if before[graph][line] {
+				continue
+			}
+			q, err := parseQuad(line)
+			if err != nil || !strings.HasPrefix(q.Object, `"`) {
+				continue
+			}
+			value := literalValue(q.Object)
+			for _, rule := range s.scan(value) {
+				findings = append(findings, secretFinding{Rule: rule, Graph: graph, Subject: q.Subject, Predicate: q.Predicate, Value: value})
+			}
+		}
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if a.Graph != b.Graph {
+			return a.Graph < b.Graph
+		}
+		if a.Subject != b.Subject {
+			return a.Subject < b.Subject
+		}
+		return a.Predicate+a.Rule < b.Predicate+b.Rule
+	})
+	return findings
+}
+
+// redactSecret shows enough of a value to find it again, but not enough
+// to use it.
+func redactSecret(value string) string {
+	value = strings.SplitN(value, "\n", 2)[0]
+	if len(value) <= 8 {
+		return strings.Repeat("*", len(value))
+	}
+	return value[:4] + strings.Repeat("*", min(len(value)-4, 12))
+}
+
+// secretsError rejects a commit that would add flagged literals.
+type secretsError []secretFinding
+
+func (e secretsError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d literal(s) look like secrets and would enter history:", len(e))
+	for _, f := range e {
+		fmt.Fprintf(&b, "\n  %s: %s %s %q in %s", f.Rule, f.Subject, f.Predicate, redactSecret(f.Value), f.Graph)
+	}
+	b.WriteString("\nRemove them, or allow a false positive with 'quad-db config secrets.allow.<name> <regexp>'")
+	return b.String()
+}
+
+// checkSecrets is the built-in pre-commit scan: it fails with a
+// secretsError if the change from before to after adds literals that look
+// like credentials.
+func checkSecrets(before, after map[string]quadSet) error {
+	s, err := loadSecretScanner()
+	if err != nil || s == nil {
+		return err
+	}
+	if findings := s.scanAdded(before, after); len(findings) > 0 {
+		return secretsError(findings)
+	}
+	return nil
+}
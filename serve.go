@@ -0,0 +1,452 @@
+// serve.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/mannyrivera2010/go-quadgit/internal/rpc"
+	"github.com/mannyrivera2010/go-quadgit/internal/transport"
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+	"github.com/spf13/cobra"
+)
+
+// rpcRepository adapts the open database to the rpc.Repository interface.
+type rpcRepository struct{}
+
+func (rpcRepository) ListRefs() (map[string]string, error) {
+	refs, err := listReferences("")
+	if err != nil {
+		return nil, err
+	}
+	delete(refs, "HEAD") // HEAD is symbolic and local to each repository
+	return refs, nil
+}
+
+func (rpcRepository) ReadObject(hash string) ([]byte, error) {
+	return readRawObject(hash)
+}
+
+func (rpcRepository) StageObjects(objects []rpc.Object) func() {
+	staged := make(map[string][]byte, len(objects))
+	for _, obj := range objects {
+		staged[obj.Hash] = obj.Data
+	}
+	return repo.stageObjects(staged)
+}
+
+func (rpcRepository) WriteObject(obj rpc.Object) error {
+	return writeRawObject(obj.Hash, obj.Data)
+}
+
+func (rpcRepository) CollectObjects(wants, haves []string, omitBlobs bool) ([]rpc.Object, error) {
+	stop, err := reachableFrom(haves)
+	if err != nil {
+		return nil, err
+	}
+	return collectObjects(stop, omitBlobs, wants...)
+}
+
+func (rpcRepository) IsAncestor(ancestor, descendant string) (bool, error) {
+	reachable, err := ancestors(descendant)
+	if err != nil {
+		return false, err
+	}
+	return reachable[ancestor], nil
+}
+
+func (rpcRepository) UpdateRefs(updates []rpc.RefUpdate) error {
+	return repo.db.Update(func(txn *badger.Txn) error {
+		for _, u := range updates {
+			key := []byte("ref:" + u.Ref)
+			var current string
+			item, err := txn.Get(key)
+			if err == nil {
+				val, err := item.ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				current = string(val)
+			} else if err != badger.ErrKeyNotFound {
+				return err
+			}
+			if current != u.Old {
+				return fmt.Errorf("%w: %s", rpc.ErrStaleRef, u.Ref)
+			}
+			if u.New == "" {
+				err = txn.Delete(key)
+			} else {
+				err = txn.Set(key, []byte(u.New))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (rpcRepository) AppendAudit(entry rpc.AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	// Entries are keyed by time, with a sequence number telling apart
+	// those made in the same nanosecond; a concurrent append taking the
+	// same key makes this one conflict and try the next.
+	for {
+		err := repo.db.Update(func(txn *badger.Txn) error {
+			for seq := 0; ; seq++ {
+				key := []byte(fmt.Sprintf("audit:%020d-%04d", entry.Timestamp.UnixNano(), seq))
+				if _, err := txn.Get(key); err == badger.ErrKeyNotFound {
+					return txn.Set(key, data)
+				} else if err != nil {
+					return err
+				}
+			}
+		})
+		if err != badger.ErrConflict {
+			return err
+		}
+	}
+}
+
+// pushURLs returns the serve.urls option: the URLs, comma-separated,
+// clients push to this repository by, which push certificates must name.
+func pushURLs() ([]string, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	var urls []string
+	for _, url := range strings.Split(cfg.Get("serve.urls"), ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			urls = append(urls, url)
+		}
+	}
+	return urls, nil
+}
+
+func (rpcRepository) ReadAudit() ([]rpc.AuditEntry, error) {
+	var entries []rpc.AuditEntry
+	err := repo.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		prefix := []byte("audit:")
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			var entry rpc.AuditEntry
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &entry)
+			})
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// authorizePush consults the authorizer about one ref update of a push, and
+// holds it to the signature policy of the branch it moves.
+func authorizePush(identity string, u rpc.RefUpdate) error {
+	if err := authorizeWrite(quadstore.ActionPush, identity, u.Ref, u.Old, u.New); err != nil {
+		return err
+	}
+	return checkPushSignatures(u)
+}
+
+// checkPushSignatures refuses to move a branch that requires signed commits
+// (policy.requireSigned) to a commit whose history since the old one is
+// not all verifiable, as fastForward does for local merges.
+func checkPushSignatures(u rpc.RefUpdate) error {
+	branch, ok := strings.CutPrefix(u.Ref, "head:")
+	if !ok || u.New == "" {
+		return nil
+	}
+	if required, err := requiresSignedCommits(branch); err != nil || !required {
+		return err
+	}
+	stop := map[string]bool{}
+	if u.Old != "" {
+		var err error
+		if stop, err = ancestors(u.Old); err != nil {
+			return err
+		}
+	}
+	if bad, err := verifyRange(u.New, stop); bad != "" {
+		return fmt.Errorf("%s requires signed commits, and commit %s is not verifiable: %v", branch, shortHash(bad), err)
+	}
+	return nil
+}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the repository to remote clients over HTTP",
+	Long: `Serve the repository to remote clients over HTTP. Besides the
+synchronization protocol, GET /metrics reports the storage engine's health
+in the Prometheus text format, as 'quad-db stats --storage' shows it.
+
+Graphs are read and written over plain HTTP:
+
+  GET /graph?graph=<iri>[&rev=<rev>]     one graph, of HEAD by default
+  GET /dataset[?rev=<rev>]               every graph
+  PUT /graph?graph=<iri>[&branch=<name>] replace a graph and commit
+  GET /branch?name=<name>                the commit a branch points at
+  PUT /branch?name=<name>                fast-forward a branch to a commit
+  POST /changes[?branch=<name>]          add and delete statements and
+                                         commit, from '+ <quad>' and
+                                         '- <quad>' lines
+  POST /commit[?branch=<name>]           add and delete statements in
+                                         several graphs as one commit on
+                                         a given parent, from JSON:
+                                         {"parent": ..., "message": ...,
+                                         "graphs": {<iri>: {"add": [...],
+                                         "delete": [...]}}}
+  GET /reviews?rev=<rev>[&into=<name>]   which graph owners approved
+                                         merging a commit (see 'review')
+  POST /reviews?rev=<rev>&state=<s>      approve a commit, or request
+                                         changes to it
+  GET|POST /proposals, /proposal?id=<n>  list, create, discuss, approve
+                                         and merge proposals (see
+                                         'proposal')
+  GET /timegate?graph=<iri>[&branch=]    redirect to the version current
+                                         at the Accept-Datetime
+  GET /timemap?graph=<iri>[&branch=]     list a graph's versions
+  GET|POST /sparql?query=<q>[&rev=<rev>] run a SPARQL query; with
+                                         explain=true, return its plan
+  GET /sparql?saved=<name>[&param=n=v]   run a saved query
+
+Reads honor the Accept header, and writes the Content-Type header, among
+N-Quads (the default), TriG, JSON-LD, Turtle and N-Triples. Turtle and
+N-Triples cannot name graphs, so a dataset with named graphs is not
+offered in them.
+
+Every response carries the commit it was read from or wrote as its ETag.
+Reads honor If-None-Match. Writes honor If-Match, and fail with 412
+Precondition Failed if the branch has moved since, so that concurrent
+clients do not overwrite each other's changes.
+
+Query results honor the Accept header among the SPARQL JSON (the
+default), XML, CSV and TSV formats, and CONSTRUCT results among the graph
+formats above; a format parameter such as format=csv overrides it.
+
+Queries are bounded by the query.timeout, query.maxRows and
+query.maxMemory options, or by default to 30s, a million intermediate
+solutions and 512MiB. A request can tighten them with its timeout,
+maxRows and maxMemory parameters, but not lift them. A query that hits a
+limit fails with 503 and a JSON account of how far it got. SERVICE
+clauses may only call the endpoints listed in query.serviceAllow.
+
+High-frequency writers, such as sensors, should send /changes rather than
+replace graphs. With --batch-window, the change sets a branch receives
+within the window of the first are committed together, once it ends or
+--batch-max arrive, rather than one commit each. The commit lists each in
+a 'Change-Set: <id> +<added> -<deleted> at <time> by <user>' trailer; the
+id is the request's id parameter or Change-Id header, or a random one.
+Each request is answered with the hash of the commit holding it, so a
+change set not answered before the server stops was not committed.
+
+The TimeGate and TimeMap implement the Memento protocol (RFC 7089) over the
+first-parent history of a branch, the current one by default: a graph read
+at a revision is a Memento and carries its commit's time as
+Memento-Datetime.
+
+With --replica-of, the server is a read replica of another repository: it
+fetches from it every --replica-interval and moves its branches to match.
+Reads then take a consistency parameter: eventual (the default) answers
+from the replica as it is, bounded(<duration>), e.g. bounded(30s), answers
+if the replica synced that recently, and strong always goes to the
+primary. Requests the replica cannot answer, and all writes, are
+redirected to the primary with 307 Temporary Redirect. Answers from the
+replica carry the time of its last sync as Replica-Synced.
+
+Once 'quad-db admin user add' has created a user, every request must
+carry one of their tokens as 'Authorization: Bearer <token>', and the
+user's role must allow it: reader to fetch, read and query, writer to push
+and commit, maintainer for the /admin API (see 'quad-db admin'). Until
+then the /admin API is refused and, unless --addr says otherwise, the
+server listens on localhost:8080 only. Writes to a namespace over its
+quota fail with 507 Insufficient Storage.
+
+A signed push certificate is recorded as verified only if its signer is
+the pusher it names and it was made for a push to one of the URLs in
+serve.urls (comma-separated), so that it cannot be replayed here from a
+push to another server.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		replaceObjects = false // Clients receive the real history
+		addr, _ := cmd.Flags().GetString("addr")
+		if interval, _ := cmd.Flags().GetDuration("gc-interval"); interval > 0 {
+			go backgroundGC(interval)
+		}
+		batcher.window, _ = cmd.Flags().GetDuration("batch-window")
+		batcher.max, _ = cmd.Flags().GetInt("batch-max")
+		if remote, _ := cmd.Flags().GetString("replica-of"); remote != "" {
+			url, err := remoteURL(remote)
+			if err != nil {
+				log.Fatal(err)
+			}
+			interval, _ := cmd.Flags().GetDuration("replica-interval")
+			replica = &replicaState{remote: remote, primary: url}
+			go replica.follow(interval)
+		}
+		mux := http.NewServeMux()
+		urls, err := pushURLs()
+		if err != nil {
+			log.Fatalf("Failed to load config: %v", err)
+		}
+		handler := &rpc.Handler{Repo: rpcRepository{}, VerifySignature: payloadSigner, URLs: urls, Identify: requestIdentity, Authorize: authorizePush, CheckPack: verifyPackGraphs}
+		handler.Register(mux)
+		mux.HandleFunc("GET /metrics", handleMetrics)
+		registerGraphHandlers(mux)
+		registerAdminHandlers(mux)
+		if _, err := openSystemDB(); err != nil {
+			log.Fatalf("Failed to open the system store: %v", err)
+		}
+		guarded, err := hasUsers()
+		if err != nil {
+			log.Fatalf("Failed to read the system store: %v", err)
+		}
+		if !guarded {
+			if !cmd.Flags().Changed("addr") {
+				addr = "localhost" + addr
+			}
+			fmt.Fprintln(os.Stderr, "Warning: no users exist, so requests are not authenticated; see 'quad-db admin'.")
+		}
+
+		fmt.Printf("Serving %s on %s\n", dbPath, addr)
+		if err := http.ListenAndServe(addr, withAuth(mux)); err != nil {
+			log.Fatalf("Server stopped: %v", err)
+		}
+	},
+}
+
+// enterRepository opens the repository at the directory named by the first
+// argument, for commands run by a remote client rather than from inside a
+// working directory.
+func enterRepository(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	replaceObjects = false // Clients receive the real history
+	if err := os.Chdir(args[0]); err != nil {
+		return err
+	}
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		return fmt.Errorf("%s is not a quad-db repository", args[0])
+	}
+	_, err := openDB()
+	return err
+}
+
+// sshPusher returns who a push over ssh is made as: --user, which a forced
+// command in authorized_keys sets for each key of a shared account, or else
+// the account sshd authenticated the client as.
+func sshPusher(cmd *cobra.Command) (string, error) {
+	if name, _ := cmd.Flags().GetString("user"); name != "" {
+		return name, nil
+	}
+	u, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	return u.Username, nil
+}
+
+// sshClient identifies the client of an SSH session for the audit log.
+func sshClient() string {
+	if client := strings.Fields(os.Getenv("SSH_CLIENT")); len(client) > 0 {
+		return "ssh:" + client[0]
+	}
+	return "ssh"
+}
+
+var uploadPackCmd = &cobra.Command{
+	Use:               "upload-pack <dir>",
+	Short:             "Send refs and objects to a client over stdin/stdout (run by ssh remotes)",
+	Args:              cobra.ExactArgs(1),
+	PersistentPreRunE: enterRepository,
+	Run: func(cmd *cobra.Command, args []string) {
+		t := transport.Local(&rpc.Handler{Repo: rpcRepository{}}, "", sshClient())
+		if err := transport.ServeHelper(os.Stdin, os.Stdout, t, "list", "fetch"); err != nil {
+			log.Fatalf("upload-pack: %v", err)
+		}
+	},
+}
+
+var receivePackCmd = &cobra.Command{
+	Use:   "receive-pack <dir>",
+	Short: "Accept a push from a client over stdin/stdout (run by ssh remotes)",
+	Long: `Accept a push from a client over stdin/stdout, as ssh remotes run it.
+The push is authorized (see access.* and policy.protected), and held to
+policy.requireSigned, as the account sshd authenticated the client as.
+When several people share one account, give each key a forced command in
+authorized_keys that names its owner, e.g.
+
+  command="quad-db receive-pack --user alice@example.org /srv/data" ssh-ed25519 ...
+
+A push certificate the client signs is recorded in the audit log, but does
+not change who the push is authorized as. It is recorded as verified only
+if its signer is the pusher it names and it was made for a push to one of
+the URLs in serve.urls (comma-separated), such as ssh://host/srv/data.`,
+	Args:              cobra.ExactArgs(1),
+	PersistentPreRunE: enterRepository,
+	Run: func(cmd *cobra.Command, args []string) {
+		pusher, err := sshPusher(cmd)
+		if err != nil {
+			log.Fatalf("receive-pack: could not tell who is pushing: %v", err)
+		}
+		urls, err := pushURLs()
+		if err != nil {
+			log.Fatalf("receive-pack: failed to load config: %v", err)
+		}
+		handler := &rpc.Handler{Repo: rpcRepository{}, VerifySignature: payloadSigner, URLs: urls, Authorize: authorizePush, CheckPack: verifyPackGraphs}
+		t := transport.Local(handler, pusher, sshClient())
+		if err := transport.ServeHelper(os.Stdin, os.Stdout, t, "list", "push"); err != nil {
+			log.Fatalf("receive-pack: %v", err)
+		}
+	},
+}
+
+var auditLogCmd = &cobra.Command{
+	Use:   "audit-log",
+	Short: "Show the pushes this repository has accepted",
+	Run: func(cmd *cobra.Command, args []string) {
+		entries, err := rpcRepository{}.ReadAudit()
+		if err != nil {
+			log.Fatalf("Failed to read audit log: %v", err)
+		}
+		for _, e := range entries {
+			fmt.Printf("push from %s\n", e.RemoteAddr)
+			fmt.Printf("Date:   %s\n", e.Timestamp.Format(time.RFC1123Z))
+			if e.Pusher != "" {
+				fmt.Printf("Pusher: %s\n", e.Pusher)
+			}
+			if e.Certificate != nil {
+				fmt.Printf("Signed: %s (certificate %s)\n", e.Certificate.Pusher, e.CertificateStatus)
+			}
+			for _, u := range e.Updates {
+				fmt.Printf("\t%s %s -> %s\n", u.Ref, shortHash(u.Old), shortHash(u.New))
+			}
+			fmt.Println()
+		}
+	},
+}
+
+// shortHash abbreviates a hash for display, rendering an absent hash as
+// "(none)".
+func shortHash(hash string) string {
+	if hash == "" {
+		return "(none)"
+	}
+	if len(hash) > 7 {
+		return hash[:7]
+	}
+	return hash
+}
@@ -0,0 +1,152 @@
+// service.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mannyrivera2010/go-quadgit/internal/sparql"
+	"github.com/mannyrivera2010/go-quadgit/pkg/rdfio"
+)
+
+// defaultServiceTimeout bounds each SERVICE request when
+// query.serviceTimeout is unset.
+const defaultServiceTimeout = 10 * time.Second
+
+// maxServiceResponse bounds the size of a SERVICE endpoint's answer.
+const maxServiceResponse = 64 << 20
+
+// serviceAllowed reports whether an endpoint is on an allowlist: a comma
+// separated list of endpoint URLs, where one ending in "/" also allows
+// every endpoint under it.
+func serviceAllowed(allow, endpoint string) bool {
+	for _, entry := range strings.Split(allow, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if endpoint == entry || strings.HasSuffix(entry, "/") && strings.HasPrefix(endpoint, entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// configServiceFunc returns the SERVICE handler the repository's options
+// allow: query.serviceAllow lists the endpoints queries may call, none by
+// default, and query.serviceTimeout bounds each call.
+func configServiceFunc() (sparql.ServiceFunc, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	timeout := defaultServiceTimeout
+	if s := cfg.Get("query.serviceTimeout"); s != "" {
+		if timeout, err = time.ParseDuration(s); err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid query.serviceTimeout %q", s)
+		}
+	}
+	allow := cfg.Get("query.serviceAllow")
+	client := &http.Client{
+		Timeout: timeout,
+		// A redirect must not lead a query to an endpoint it may not call
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return fmt.Errorf("stopped after 10 redirects")
+			}
+			return checkServiceEndpoint(allow, req.URL.String())
+		},
+	}
+	return func(ctx context.Context, endpoint, query string) ([]sparql.Binding, error) {
+		u := strings.Trim(endpoint, "<>")
+		if err := checkServiceEndpoint(allow, u); err != nil {
+			return nil, err
+		}
+		return callService(ctx, client, u, query)
+	}, nil
+}
+
+// checkServiceEndpoint fails unless u is an http(s) URL that allow, the
+// query.serviceAllow list, permits queries to call.
+func checkServiceEndpoint(allow, u string) error {
+	if !serviceAllowed(allow, u) {
+		return fmt.Errorf("endpoint %s not allowed; add it to query.serviceAllow", u)
+	}
+	if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+		return fmt.Errorf("endpoint %s is not an http(s) URL", u)
+	}
+	return nil
+}
+
+// callService sends a query to an endpoint with the SPARQL protocol and
+// decodes its JSON results.
+func callService(ctx context.Context, client *http.Client, endpoint, query string) ([]sparql.Binding, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(url.Values{"query": {query}}.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/sparql-results+json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var doc struct {
+		Results struct {
+			Bindings []map[string]sparqlTerm `json:"bindings"`
+		} `json:"results"`
+	}
+	body := io.LimitReader(resp.Body, maxServiceResponse+1)
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxServiceResponse {
+		return nil, fmt.Errorf("response larger than %s", formatBytes(maxServiceResponse))
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid results: %v", err)
+	}
+	sols := make([]sparql.Binding, len(doc.Results.Bindings))
+	for i, row := range doc.Results.Bindings {
+		b := make(sparql.Binding, len(row))
+		for v, t := range row {
+			if b[v], err = fromSPARQLTerm(t); err != nil {
+				return nil, err
+			}
+		}
+		sols[i] = b
+	}
+	return sols, nil
+}
+
+// fromSPARQLTerm converts a SPARQL JSON results term to an N-Quads term.
+func fromSPARQLTerm(t sparqlTerm) (string, error) {
+	switch t.Type {
+	case "uri":
+		return "<" + t.Value + ">", nil
+	case "bnode":
+		return "_:" + t.Value, nil
+	case "literal", "typed-literal":
+		term := rdfio.QuoteString(t.Value)
+		if t.Lang != "" {
+			return term + "@" + t.Lang, nil
+		}
+		if t.Datatype != "" && t.Datatype != "http://www.w3.org/2001/XMLSchema#string" {
+			return term + "^^<" + t.Datatype + ">", nil
+		}
+		return term, nil
+	}
+	return "", fmt.Errorf("invalid results: unknown term type %q", t.Type)
+}
@@ -0,0 +1,66 @@
+// service_test.go
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestServiceRedirects(t *testing.T) {
+	newTestRepo(t)
+	var outsideHits atomic.Int32
+	outside := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		outsideHits.Add(1)
+		w.Write([]byte(`{"results": {"bindings": []}}`))
+	}))
+	defer outside.Close()
+	allowed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sparql":
+			w.Write([]byte(`{"results": {"bindings": []}}`))
+		case "/moved":
+			http.Redirect(w, r, "/sparql", http.StatusTemporaryRedirect)
+		case "/away":
+			http.Redirect(w, r, outside.URL+"/sparql", http.StatusTemporaryRedirect)
+		}
+	}))
+	defer allowed.Close()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Set("query.serviceAllow", allowed.URL+"/")
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+	service, err := configServiceFunc()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		endpoint string
+		wantErr  bool
+	}{
+		{"allowed", allowed.URL + "/sparql", false},
+		{"redirect within the allowed endpoints", allowed.URL + "/moved", false},
+		{"redirect to another endpoint", allowed.URL + "/away", true},
+		{"not allowed", outside.URL + "/sparql", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service(context.Background(), "<"+tt.endpoint+">", "SELECT * WHERE { ?s ?p ?o }")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("calling %s: err = %v, want error %v", tt.endpoint, err, tt.wantErr)
+			}
+		})
+	}
+	if n := outsideHits.Load(); n > 0 {
+		t.Errorf("the endpoint outside query.serviceAllow was called %d time(s)", n)
+	}
+}
@@ -0,0 +1,264 @@
+// shacl.go
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+)
+
+const sh = "http://www.w3.org/ns/shacl#"
+
+// shTerm returns the N-Quads form of a term in the SHACL namespace.
+func shTerm(local string) string {
+	return "<" + sh + local + ">"
+}
+
+// tripleIndex maps subject to predicate to objects.
+type tripleIndex map[string]map[string][]string
+
+func indexTriples(quads []quadstore.Quad) tripleIndex {
+	idx := make(tripleIndex)
+	for _, q := range quads {
+		if idx[q.Subject] == nil {
+			idx[q.Subject] = make(map[string][]string)
+		}
+		idx[q.Subject][q.Predicate] = append(idx[q.Subject][q.Predicate], q.Object)
+	}
+	return idx
+}
+
+// propertyShape is one sh:property of a node shape, restricted to the
+// constraints validateShapes understands.
+type propertyShape struct {
+	path               string
+	minCount, maxCount int // -1 when not set
+	datatype, class    string
+	nodeKind           string
+	pattern            *regexp.Regexp
+}
+
+// nodeShape is a sh:NodeShape with its targets and property shapes.
+type nodeShape struct {
+	name             string
+	targetClass      []string
+	targetNode       []string
+	targetSubjectsOf []string
+	targetObjectsOf  []string
+	properties       []propertyShape
+}
+
+// shapeViolation is one failed constraint.
+type shapeViolation struct {
+	Focus, Path, Shape, Message string
+}
+
+func (v shapeViolation) String() string {
+	if v.Path == "" {
+		return fmt.Sprintf("%s: %s (shape %s)", v.Focus, v.Message, v.Shape)
+	}
+	return fmt.Sprintf("%s %s: %s (shape %s)", v.Focus, v.Path, v.Message, v.Shape)
+}
+
+// parseShapes reads the node shapes in a shapes graph. Only SHACL Core's
+// most common constraints are supported: targets by class, node, subjects
+// of and objects of, and property shapes with a predicate path and
+// sh:minCount, sh:maxCount, sh:datatype, sh:class, sh:nodeKind and
+// sh:pattern. Anything else is reported as an error rather than ignored,
+// so that a passing check can be trusted.
+func parseShapes(quads []quadstore.Quad) ([]nodeShape, error) {
+	idx := indexTriples(quads)
+	var names []string
+	for subject, props := range idx {
+		for _, t := range props[rdfType] {
+			if t == shTerm("NodeShape") {
+				names = append(names, subject)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	count := func(values []string, what string) (int, error) {
+		if len(values) == 0 {
+			return -1, nil
+		}
+		n, err := strconv.Atoi(literalValue(values[0]))
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("%s must be a non-negative integer, got %s", what, values[0])
+		}
+		return n, nil
+	}
+	var shapes []nodeShape
+	for _, name := range names {
+		props := idx[name]
+		s := nodeShape{
+			name:             name,
+			targetClass:      props[shTerm("targetClass")],
+			targetNode:       props[shTerm("targetNode")],
+			targetSubjectsOf: props[shTerm("targetSubjectsOf")],
+			targetObjectsOf:  props[shTerm("targetObjectsOf")],
+		}
+		for _, ref := range props[shTerm("property")] {
+			p := idx[ref]
+			paths := p[shTerm("path")]
+			if len(paths) != 1 || !strings.HasPrefix(paths[0], "<") {
+				return nil, fmt.Errorf("shape %s: only a single predicate sh:path is supported", name)
+			}
+			ps := propertyShape{path: paths[0]}
+			var err error
+			if ps.minCount, err = count(p[shTerm("minCount")], "sh:minCount"); err != nil {
+				return nil, fmt.Errorf("shape %s: %v", name, err)
+			}
+			if ps.maxCount, err = count(p[shTerm("maxCount")], "sh:maxCount"); err != nil {
+				return nil, fmt.Errorf("shape %s: %v", name, err)
+			}
+			if v := p[shTerm("datatype")]; len(v) > 0 {
+				ps.datatype = strings.Trim(v[0], "<>")
+			}
+			if v := p[shTerm("class")]; len(v) > 0 {
+				ps.class = v[0]
+			}
+			if v := p[shTerm("nodeKind")]; len(v) > 0 {
+				ps.nodeKind = strings.TrimPrefix(strings.Trim(v[0], "<>"), sh)
+			}
+			if v := p[shTerm("pattern")]; len(v) > 0 {
+				if ps.pattern, err = regexp.Compile(literalValue(v[0])); err != nil {
+					return nil, fmt.Errorf("shape %s: invalid sh:pattern: %v", name, err)
+				}
+			}
+			for predicate := range p {
+				switch strings.TrimPrefix(strings.Trim(predicate, "<>"), sh) {
+				case "path", "minCount", "maxCount", "datatype", "class", "nodeKind", "pattern", "name", "description", "message", "order", "group":
+				default:
+					if strings.HasPrefix(predicate, "<"+sh) {
+						return nil, fmt.Errorf("shape %s: %s is not supported", name, predicate)
+					}
+				}
+			}
+			s.properties = append(s.properties, ps)
+		}
+		shapes = append(shapes, s)
+	}
+	return shapes, nil
+}
+
+// matchesNodeKind reports whether term is of a sh:nodeKind.
+func matchesNodeKind(term, kind string) bool {
+	iri, blank, literal := strings.HasPrefix(term, "<"), strings.HasPrefix(term, "_:"), strings.HasPrefix(term, `"`)
+	switch kind {
+	case "IRI":
+		return iri
+	case "BlankNode":
+		return blank
+	case "Literal":
+		return literal
+	case "BlankNodeOrIRI":
+		return blank || iri
+	case "BlankNodeOrLiteral":
+		return blank || literal
+	case "IRIOrLiteral":
+		return iri || literal
+	}
+	return false
+}
+
+// validateShapes checks the data graph against shapes and returns every
+// violation, ordered by focus node.
+func validateShapes(shapes []nodeShape, data []quadstore.Quad) []shapeViolation {
+	idx := indexTriples(data)
+	instances := make(map[string]map[string]bool) // class to instances
+	objectsOf := make(map[string]map[string]bool) // predicate to objects
+	for _, q := range data {
+		if q.Predicate == rdfType {
+			if instances[q.Object] == nil {
+				instances[q.Object] = make(map[string]bool)
+			}
+			instances[q.Object][q.Subject] = true
+		}
+		if objectsOf[q.Predicate] == nil {
+			objectsOf[q.Predicate] = make(map[string]bool)
+		}
+		objectsOf[q.Predicate][q.Object] = true
+	}
+
+	var violations []shapeViolation
+	for _, s := range shapes {
+		focus := make(map[string]bool)
+		for _, c := range s.targetClass {
+			for n := range instances[c] {
+				focus[n] = true
+			}
+		}
+		for _, n := range s.targetNode {
+			focus[n] = true
+		}
+		for _, p := range s.targetSubjectsOf {
+			for subject, props := range idx {
+				if len(props[p]) > 0 {
+					focus[subject] = true
+				}
+			}
+		}
+		for _, p := range s.targetObjectsOf {
+			for n := range objectsOf[p] {
+				focus[n] = true
+			}
+		}
+
+		for node := range focus {
+			for _, p := range s.properties {
+				fail := func(format string, v ...interface{}) {
+					violations = append(violations, shapeViolation{node, p.path, s.name, fmt.Sprintf(format, v...)})
+				}
+				values := idx[node][p.path]
+				if p.minCount >= 0 && len(values) < p.minCount {
+					fail("expected at least %d value(s), found %d (sh:minCount)", p.minCount, len(values))
+				}
+				if p.maxCount >= 0 && len(values) > p.maxCount {
+					fail("expected at most %d value(s), found %d (sh:maxCount)", p.maxCount, len(values))
+				}
+				for _, v := range values {
+					if p.datatype != "" {
+						if _, _, dt := literalParts(v); !strings.HasPrefix(v, `"`) || literalDatatype(v, dt) != p.datatype {
+							fail("%s is not a literal of type <%s> (sh:datatype)", v, p.datatype)
+						}
+					}
+					if p.nodeKind != "" && !matchesNodeKind(v, p.nodeKind) {
+						fail("%s is not of node kind sh:%s (sh:nodeKind)", v, p.nodeKind)
+					}
+					if p.class != "" && !instances[p.class][v] {
+						fail("%s is not an instance of %s (sh:class)", v, p.class)
+					}
+					if p.pattern != nil {
+						text := strings.Trim(v, "<>")
+						if strings.HasPrefix(v, `"`) {
+							text = literalValue(v)
+						}
+						if strings.HasPrefix(v, "_:") || !p.pattern.MatchString(text) {
+							fail("%s does not match %q (sh:pattern)", v, p.pattern)
+						}
+					}
+				}
+			}
+		}
+	}
+	sort.SliceStable(violations, func(i, j int) bool { return violations[i].Focus < violations[j].Focus })
+	return violations
+}
+
+// literalDatatype returns the datatype IRI of a literal given the datatype
+// literalParts found: rdf:langString for language-tagged literals and
+// xsd:string for plain ones.
+func literalDatatype(term, datatype string) string {
+	if datatype != "" {
+		return datatype
+	}
+	if _, lang, _ := literalParts(term); lang != "" {
+		return "http://www.w3.org/1999/02/22-rdf-syntax-ns#langString"
+	}
+	return xsd + "string"
+}
@@ -0,0 +1,100 @@
+// shortlog.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+	"github.com/spf13/cobra"
+)
+
+// authorSummary is one author's share of a history.
+type authorSummary struct {
+	Author   string
+	Subjects []string // Oldest first
+	Added    int
+	Deleted  int
+}
+
+// summarizeAuthors groups the first-parent history of tip by author. With
+// withStats, the quads each author added and deleted are counted too,
+// computing stats for commits recorded without them in parallel.
+func summarizeAuthors(tip string, withStats bool) ([]*authorSummary, error) {
+	byAuthor := make(map[string]*authorSummary)
+	var authors []*authorSummary
+	err := processCommits(firstParentWalk(tip), func(hash string, commit *Commit) (*quadstore.CommitStats, error) {
+		if !withStats {
+			return nil, nil
+		}
+		return commitStats(commit)
+	}, func(hash string, commit *Commit, stats *quadstore.CommitStats) error {
+		a := byAuthor[commit.Author]
+		if a == nil {
+			a = &authorSummary{Author: commit.Author}
+			byAuthor[commit.Author] = a
+			authors = append(authors, a)
+		}
+		a.Subjects = append(a.Subjects, strings.SplitN(commit.Message, "\n", 2)[0])
+		if stats != nil {
+			a.Added += stats.Added
+			a.Deleted += stats.Deleted
+		}
+		return nil
+	})
+	for _, a := range authors {
+		slices.Reverse(a.Subjects)
+	}
+	return authors, err
+}
+
+var shortlogCmd = &cobra.Command{
+	Use:   "shortlog [<commit>]",
+	Short: "Summarize history by author",
+	Long: `Group the first-parent history of a commit (HEAD by default) by author,
+listing the subject of each of their commits, oldest first. -s prints only
+the number of commits per author, -n sorts authors by that number instead
+of by name, and --stat adds the quads each author added and deleted.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		tip, err := resolveHead()
+		if len(args) == 1 {
+			tip, err = resolveCommitish(args[0])
+		}
+		if err != nil {
+			log.Fatalf("Could not resolve commit: %v", err)
+		}
+		summary, _ := cmd.Flags().GetBool("summary")
+		numbered, _ := cmd.Flags().GetBool("numbered")
+		stat, _ := cmd.Flags().GetBool("stat")
+
+		authors, err := summarizeAuthors(tip, stat)
+		if err != nil {
+			log.Fatalf("Failed to read commit history: %v", err)
+		}
+		sort.SliceStable(authors, func(i, j int) bool {
+			if numbered && len(authors[i].Subjects) != len(authors[j].Subjects) {
+				return len(authors[i].Subjects) > len(authors[j].Subjects)
+			}
+			return authors[i].Author < authors[j].Author
+		})
+		for _, a := range authors {
+			line := a.Author
+			if stat {
+				line += fmt.Sprintf(" (+%d / -%d quads)", a.Added, a.Deleted)
+			}
+			if summary {
+				fmt.Printf("%6d\t%s\n", len(a.Subjects), line)
+				continue
+			}
+			fmt.Printf("%s (%d):\n", line, len(a.Subjects))
+			for _, subject := range a.Subjects {
+				fmt.Printf("      %s\n", subject)
+			}
+			fmt.Println()
+		}
+	},
+}
@@ -0,0 +1,343 @@
+// signing.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/mannyrivera2010/go-quadgit/internal/config"
+	"github.com/mannyrivera2010/go-quadgit/pkg/kmssign"
+	"github.com/spf13/cobra"
+)
+
+// commitPayload returns the bytes covered by a commit signature: the JSON
+// encoding of the commit with its Signature field cleared.
+func commitPayload(c Commit) ([]byte, error) {
+	c.Signature = ""
+	return json.Marshal(c)
+}
+
+// signCommit attaches a detached, ASCII-armored signature to a commit.
+func signCommit(c *Commit) error {
+	payload, err := commitPayload(*c)
+	if err != nil {
+		return err
+	}
+	c.Signature, err = signPayload(payload)
+	return err
+}
+
+// verifyCommit checks a commit's signature.
+func verifyCommit(c *Commit) error {
+	if c.Signature == "" {
+		return fmt.Errorf("commit is not signed")
+	}
+	payload, err := commitPayload(*c)
+	if err != nil {
+		return err
+	}
+	return verifyPayload(payload, c.Signature)
+}
+
+// tagPayload returns the bytes covered by a tag signature: the JSON
+// encoding of the tag with its Signature field cleared.
+func tagPayload(t Tag) ([]byte, error) {
+	t.Signature = ""
+	return json.Marshal(t)
+}
+
+// signTag attaches a detached, ASCII-armored signature to a tag.
+func signTag(t *Tag) error {
+	payload, err := tagPayload(*t)
+	if err != nil {
+		return err
+	}
+	t.Signature, err = signPayload(payload)
+	return err
+}
+
+// verifyTag checks a tag's signature.
+func verifyTag(t *Tag) error {
+	if t.Signature == "" {
+		return fmt.Errorf("tag is not signed")
+	}
+	payload, err := tagPayload(*t)
+	if err != nil {
+		return err
+	}
+	return verifyPayload(payload, t.Signature)
+}
+
+// signPayload signs payload with the user.signingBackend: gpg, the
+// default, or a key-management system (aws-kms, gcp-kms or vault), which
+// signs with the key user.signingKey names without it ever leaving the
+// service. The kms.* options locate the service:
+//
+//	kms.aws.region     AWS region (default $AWS_REGION); credentials come
+//	                   from $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY
+//	kms.aws.algorithm  signing algorithm (default ECDSA_SHA_256)
+//	kms.vault.addr     Vault address (default $VAULT_ADDR); the token
+//	                   comes from $VAULT_TOKEN
+//	kms.vault.mount    transit engine mount (default transit)
+//
+// Cloud KMS keys are named by key version, and the token comes from
+// $GOOGLE_OAUTH_ACCESS_TOKEN or gcloud.
+func signPayload(payload []byte) (string, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return "", err
+	}
+	backend := cfg.Get("user.signingBackend")
+	if backend == "" || backend == "gpg" {
+		return gpgSign(payload)
+	}
+	key := cfg.Get("user.signingKey")
+	if key == "" {
+		return "", fmt.Errorf("user.signingBackend is %s, but no user.signingKey names the key", backend)
+	}
+	signer, err := kmsSigner(cfg, backend, key)
+	if err != nil {
+		return "", err
+	}
+	return signer.Sign(payload)
+}
+
+// verifyPayload checks a signature over payload: a PGP signature with
+// gpg, and a KMS signature with the service that made it. A KMS key is
+// trusted if it is the user's own signing key or listed in kms.trustedKeys
+// (comma-separated), as gpg trusts the keys in its keyring.
+func verifyPayload(payload []byte, signature string) error {
+	_, err := payloadSigner(payload, signature)
+	return err
+}
+
+// payloadSigner checks a signature over payload as verifyPayload does, and
+// returns who made it: the email address of the PGP key's user ID, or the
+// KMS key.
+func payloadSigner(payload []byte, signature string) (string, error) {
+	if !kmssign.IsArmored(signature) {
+		return gpgVerify(payload, signature)
+	}
+	sig, err := kmssign.Parse(signature)
+	if err != nil {
+		return "", fmt.Errorf("bad signature: %v", err)
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return "", err
+	}
+	trusted := cfg.Get("user.signingBackend") == sig.Provider && cfg.Get("user.signingKey") == sig.Key
+	for _, key := range strings.Split(cfg.Get("kms.trustedKeys"), ",") {
+		trusted = trusted || strings.TrimSpace(key) == sig.Key
+	}
+	if !trusted {
+		return "", fmt.Errorf("bad signature: %s key %s is not in kms.trustedKeys", sig.Provider, sig.Key)
+	}
+	signer, err := kmsSigner(cfg, sig.Provider, sig.Key)
+	if err != nil {
+		return "", err
+	}
+	if err := signer.Verify(payload, signature); err != nil {
+		return "", fmt.Errorf("bad signature: %v", err)
+	}
+	return sig.Key, nil
+}
+
+// kmsSigner returns the signer for a key of a key-management system.
+func kmsSigner(cfg *config.Config, backend, key string) (kmssign.Signer, error) {
+	switch backend {
+	case kmssign.ProviderAWS:
+		region := cfg.Get("kms.aws.region")
+		if region == "" {
+			region = os.Getenv("AWS_REGION")
+		}
+		if region == "" {
+			region = os.Getenv("AWS_DEFAULT_REGION")
+		}
+		return &kmssign.AWS{
+			KeyID:       key,
+			Region:      region,
+			Algorithm:   cfg.Get("kms.aws.algorithm"),
+			Credentials: kmssign.AWSCredentialsFromEnv(),
+			Endpoint:    cfg.Get("kms.aws.endpoint"),
+		}, nil
+	case kmssign.ProviderGCP:
+		return &kmssign.GCP{KeyVersion: key, Endpoint: cfg.Get("kms.gcp.endpoint")}, nil
+	case kmssign.ProviderVault:
+		return &kmssign.Vault{
+			Addr:      cfg.Get("kms.vault.addr"),
+			Mount:     cfg.Get("kms.vault.mount"),
+			Namespace: cfg.Get("kms.vault.namespace"),
+			Key:       key,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown signing backend %q (want gpg, %s, %s or %s)", backend, kmssign.ProviderAWS, kmssign.ProviderGCP, kmssign.ProviderVault)
+}
+
+// gpgSign returns a detached, ASCII-armored gpg signature of payload. The
+// key is taken from user.signingKey, or gpg's default key if unset.
+func gpgSign(payload []byte) (string, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return "", err
+	}
+	args := []string{"--batch", "--detach-sign", "--armor"}
+	if key := cfg.Get("user.signingKey"); key != "" {
+		args = append(args, "--local-user", key)
+	}
+	var out, stderr bytes.Buffer
+	gpg := exec.Command("gpg", args...)
+	gpg.Stdin = bytes.NewReader(payload)
+	gpg.Stdout, gpg.Stderr = &out, &stderr
+	if err := gpg.Run(); err != nil {
+		return "", fmt.Errorf("gpg failed to sign: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out.String(), nil
+}
+
+// gpgVerify checks a detached signature over payload against the local
+// gpg keyring, and returns the email address of the signing key's user ID,
+// or the whole user ID if it has none.
+func gpgVerify(payload []byte, signature string) (string, error) {
+	sigFile, err := os.CreateTemp("", "quad-db-sig-*.asc")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(sigFile.Name())
+	if _, err := sigFile.WriteString(signature); err != nil {
+		sigFile.Close()
+		return "", err
+	}
+	sigFile.Close()
+
+	var status, stderr bytes.Buffer
+	gpg := exec.Command("gpg", "--batch", "--status-fd", "1", "--verify", sigFile.Name(), "-")
+	gpg.Stdin = bytes.NewReader(payload)
+	gpg.Stdout, gpg.Stderr = &status, &stderr
+	if err := gpg.Run(); err != nil {
+		return "", fmt.Errorf("bad signature: %s", strings.TrimSpace(stderr.String()))
+	}
+	for _, line := range strings.Split(status.String(), "\n") {
+		// [GNUPG:] GOODSIG <key id> <user id>
+		fields := strings.SplitN(line, " ", 4)
+		if len(fields) < 4 || fields[0] != "[GNUPG:]" || fields[1] != "GOODSIG" {
+			continue
+		}
+		uid := strings.TrimSpace(fields[3])
+		if start := strings.LastIndex(uid, "<"); start >= 0 && strings.HasSuffix(uid, ">") {
+			return uid[start+1 : len(uid)-1], nil
+		}
+		return uid, nil
+	}
+	return "", errors.New("bad signature: gpg reported no good signature")
+}
+
+// requiresSignedCommits reports whether the policy.requireSigned setting (a
+// comma-separated list of branch name patterns) covers a branch.
+func requiresSignedCommits(branch string) (bool, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return false, err
+	}
+	for _, pattern := range strings.Split(cfg.Get("policy.requireSigned"), ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if ok, err := path.Match(pattern, branch); err != nil {
+			return false, fmt.Errorf("invalid policy.requireSigned pattern %q: %v", pattern, err)
+		} else if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// enforceSignaturePolicy rejects an unsigned commit destined for a branch
+// that requires signed commits.
+func enforceSignaturePolicy(branch string, c *Commit) error {
+	required, err := requiresSignedCommits(branch)
+	if err != nil || !required {
+		return err
+	}
+	if c.Signature == "" {
+		return fmt.Errorf("branch %s requires signed commits (use -S)", branch)
+	}
+	return nil
+}
+
+// emptyRoot reports whether c is a root commit like the one 'init' writes:
+// no parents and an empty tree. It records no data for a signature to vouch
+// for, so verifyRange accepts it unsigned.
+func emptyRoot(c *Commit) bool {
+	if len(c.Parents) > 0 {
+		return false
+	}
+	empty, err := hashObject(Tree{})
+	return err == nil && c.Tree == empty
+}
+
+// verifyRange verifies every commit reachable from tip but not from any
+// commit in stop, newest first, except an unsigned empty root. It returns
+// the hash of the first commit that fails verification along with the
+// reason, or an empty hash if all pass.
+func verifyRange(tip string, stop map[string]bool) (string, error) {
+	seen := make(map[string]bool)
+	queue := []string{tip}
+	for len(queue) > 0 {
+		h := queue[0]
+		queue = queue[1:]
+		if seen[h] || stop[h] {
+			continue
+		}
+		seen[h] = true
+		commit, err := readCommit(h)
+		if err != nil {
+			return h, err
+		}
+		if commit.Signature == "" && emptyRoot(commit) {
+			continue
+		}
+		if err := verifyCommit(commit); err != nil {
+			return h, err
+		}
+		queue = append(queue, commit.Parents...)
+	}
+	return "", nil
+}
+
+var verifyHistoryCmd = &cobra.Command{
+	Use:   "verify-history <ref>",
+	Short: "Verify the signature of every commit in a branch's history",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		tip, err := resolveCommitish(args[0])
+		if err != nil {
+			log.Fatalf("Could not resolve %s: %v", args[0], err)
+		}
+		stop := make(map[string]bool)
+		if since, _ := cmd.Flags().GetString("since"); since != "" {
+			sinceHash, err := resolveCommitish(since)
+			if err != nil {
+				log.Fatalf("Could not resolve %s: %v", since, err)
+			}
+			if stop, err = ancestors(sinceHash); err != nil {
+				log.Fatalf("Failed to walk history: %v", err)
+			}
+		}
+
+		bad, err := verifyRange(tip, stop)
+		if bad != "" {
+			fmt.Printf("First unverifiable commit: %s\n", bad)
+			log.Fatalf("Verification failed: %v", err)
+		}
+		fmt.Printf("All commits reachable from %s carry valid signatures.\n", args[0])
+	},
+}
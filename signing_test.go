@@ -0,0 +1,100 @@
+// signing_test.go
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mannyrivera2010/go-quadgit/internal/rpc"
+)
+
+func TestVerifyRangeEmptyRoot(t *testing.T) {
+	newTestRepo(t)
+	root, err := resolveCommitish("main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := writeTestCommit(t, map[string][]string{
+		"http://example.org/g": {`<http://example.org/s> <http://example.org/p> "o" <http://example.org/g> .`},
+	}, root)
+	dataRoot := writeTestCommit(t, map[string][]string{
+		"http://example.org/g": {`<http://example.org/s> <http://example.org/p> "o" <http://example.org/g> .`},
+	})
+
+	tests := []struct {
+		name    string
+		tip     string
+		stop    map[string]bool
+		wantBad string
+	}{
+		{"unsigned init commit", root, nil, ""},
+		{"unsigned commit on init", data, nil, data},
+		{"unsigned commit excluded", data, map[string]bool{data: true}, ""},
+		{"unsigned root with data", dataRoot, nil, dataRoot},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bad, err := verifyRange(tt.tip, tt.stop)
+			if bad != tt.wantBad {
+				t.Errorf("verifyRange(%s) = %q, %v; want %q", shortHash(tt.tip), bad, err, tt.wantBad)
+			}
+		})
+	}
+}
+
+func TestCheckPushSignatures(t *testing.T) {
+	newTestRepo(t)
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Set("policy.requireSigned", "main, release/*")
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+	root, err := resolveCommitish("main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned := writeTestCommit(t, map[string][]string{
+		"http://example.org/g": {`<http://example.org/s> <http://example.org/p> "o" <http://example.org/g> .`},
+	}, root)
+
+	tests := []struct {
+		name    string
+		update  rpc.RefUpdate
+		wantErr bool
+	}{
+		{"unsigned commit on a signed branch", rpc.RefUpdate{Ref: "head:main", Old: root, New: unsigned}, true},
+		{"new signed branch with an unsigned commit", rpc.RefUpdate{Ref: "head:release/1", New: unsigned}, true},
+		{"new signed branch at the init commit", rpc.RefUpdate{Ref: "head:release/1", New: root}, false},
+		{"unsigned commit already in the branch", rpc.RefUpdate{Ref: "head:main", Old: unsigned, New: unsigned}, false},
+		{"unsigned commit on an unsigned branch", rpc.RefUpdate{Ref: "head:topic", Old: root, New: unsigned}, false},
+		{"deleting a signed branch", rpc.RefUpdate{Ref: "head:main", Old: root}, false},
+		{"tag", rpc.RefUpdate{Ref: "tag:main", New: unsigned}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkPushSignatures(tt.update); (err != nil) != tt.wantErr {
+				t.Errorf("checkPushSignatures(%+v) = %v, want error: %v", tt.update, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAppendAuditSameTime(t *testing.T) {
+	newTestRepo(t)
+	now := time.Now()
+	for _, pusher := range []string{"alice", "bob"} {
+		if err := (rpcRepository{}).AppendAudit(rpc.AuditEntry{Timestamp: now, Pusher: pusher}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := rpcRepository{}.ReadAudit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Pusher != "alice" || entries[1].Pusher != "bob" {
+		t.Errorf("audit log %+v, want entries for alice and bob", entries)
+	}
+}
@@ -0,0 +1,115 @@
+// snapshot.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+	"github.com/spf13/cobra"
+)
+
+// A snapshot is a read-only view of the repository pinned to one commit.
+// Stored objects never change, so once the revision is resolved every read
+// through the snapshot sees the same state, however branches move while a
+// multi-step read is in progress.
+type snapshot struct {
+	hash   string
+	commit *Commit
+	graphs map[string]string // Graph name to blob hash
+}
+
+// openSnapshot pins a snapshot to the commit rev resolves to now.
+func openSnapshot(rev string) (*snapshot, error) {
+	hash, err := resolveCommitish(rev)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := readCommit(hash)
+	if err != nil {
+		return nil, err
+	}
+	graphs, err := readGraphs(commit.Tree)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot{hash: hash, commit: commit, graphs: graphs}, nil
+}
+
+// graphNames returns the names of the snapshot's graphs, sorted.
+func (s *snapshot) graphNames() []string {
+	names := make([]string, 0, len(s.graphs))
+	for name := range s.graphs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// match calls fn for each quad of the snapshot that matches p, graph by
+// graph in name order, stopping at the first error from fn.
+func (s *snapshot) match(p quadPattern, fn func(q quadstore.Quad, graph string) error) error {
+	for _, graph := range s.graphNames() {
+		if p.Graph != "" && p.Graph != graph {
+			continue
+		}
+		blob, err := readBlob(s.graphs[graph])
+		if err != nil {
+			return err
+		}
+		for _, line := range blob {
+			q, err := parseQuad(line)
+			if err != nil || !p.matches(q, graph) {
+				continue
+			}
+			if err := fn(q, graph); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var matchCmd = &cobra.Command{
+	Use:   "match <pattern> [<commit>]",
+	Short: "Print the quads that match a pattern",
+	Long: `Print the quads of a commit (HEAD by default) that match a pattern such
+as '<s> ?p ?o' or '* <p> "o" <g>', where variables and * match any term.
+The commit is resolved once, so the output is consistent even while other
+commits land on the branch.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		p, err := parsePattern(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		rev := "HEAD"
+		if len(args) == 2 {
+			rev = args[1]
+		}
+		snap, err := openSnapshot(rev)
+		if err != nil {
+			log.Fatalf("Could not resolve commit: %v", err)
+		}
+		count, _ := cmd.Flags().GetBool("count")
+		n := 0
+		err = snap.match(p, func(q quadstore.Quad, graph string) error {
+			n++
+			if !count {
+				q.Graph = ""
+				if graph != defaultGraph {
+					q.Graph = graph
+				}
+				fmt.Println(formatQuad(q))
+			}
+			return nil
+		})
+		if err != nil {
+			log.Fatalf("Failed to read graphs: %v", err)
+		}
+		if count {
+			fmt.Println(n)
+		}
+	},
+}
@@ -0,0 +1,273 @@
+// stats.go
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+	"github.com/spf13/cobra"
+)
+
+// computeStats counts the changes from one state to another, per graph and
+// in total.
+func computeStats(before, after map[string]quadSet) (*quadstore.CommitStats, error) {
+	diffs, err := diffStates(context.Background(), before, after)
+	if err != nil {
+		return nil, err
+	}
+	return statsFromDiffs(diffs, after), nil
+}
+
+// statsFromDiffs tallies already computed diffs; after is the resulting state.
+func statsFromDiffs(diffs []graphDiff, after map[string]quadSet) *quadstore.CommitStats {
+	stats := &quadstore.CommitStats{Graphs: make(map[string]quadstore.GraphStats)}
+	for _, set := range after {
+		stats.TotalQuads += int64(len(set))
+	}
+	for _, d := range diffs {
+		stats.Added += len(d.Added)
+		stats.Deleted += len(d.Deleted)
+		stats.Graphs[d.Graph] = quadstore.GraphStats{Added: len(d.Added), Deleted: len(d.Deleted)}
+	}
+	return stats
+}
+
+// commitStats returns the stats stored on a commit, computing them against
+// its first parent for commits recorded without stats.
+func commitStats(commit *Commit) (*quadstore.CommitStats, error) {
+	if commit.Stats != nil && commit.Stats.Graphs != nil {
+		return commit.Stats, nil
+	}
+	before := make(map[string]quadSet)
+	if len(commit.Parents) > 0 {
+		var err error
+		if before, err = loadState(commit.Parents[0]); err != nil {
+			return nil, err
+		}
+	}
+	after, err := loadTreeState(context.Background(), commit.Tree, "")
+	if err != nil {
+		return nil, err
+	}
+	return computeStats(before, after)
+}
+
+// printStats writes per-graph change counts followed by a total line.
+func printStats(stats *quadstore.CommitStats) {
+	graphs := make([]string, 0, len(stats.Graphs))
+	for graph := range stats.Graphs {
+		graphs = append(graphs, graph)
+	}
+	sort.Strings(graphs)
+	for _, graph := range graphs {
+		g := stats.Graphs[graph]
+		fmt.Printf(" +%d / -%d quads in %s\n", g.Added, g.Deleted, graph)
+	}
+	noun := "graphs"
+	if len(graphs) == 1 {
+		noun = "graph"
+	}
+	fmt.Printf(" %d %s changed, +%d / -%d quads\n", len(graphs), noun, stats.Added, stats.Deleted)
+}
+
+// statsPoint is one commit of a stats --history time series.
+type statsPoint struct {
+	Commit     string           `json:"commit"`
+	Timestamp  time.Time        `json:"timestamp"`
+	Author     string           `json:"author"`
+	TotalQuads int64            `json:"total_quads"`
+	Added      int              `json:"added"`
+	Deleted    int              `json:"deleted"`
+	Churn      int              `json:"churn"` // Added + Deleted
+	Graphs     map[string]int64 `json:"graphs"`
+}
+
+// graphCounts returns the number of quads in each graph of a tree, caching
+// blob sizes (blob hash to int64) across calls since most blobs are shared
+// between commits.
+func graphCounts(treeHash string, sizes *sync.Map) (map[string]int64, error) {
+	graphs, err := readGraphs(treeHash)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(graphs))
+	for graph, blobHash := range graphs {
+		if size, ok := sizes.Load(blobHash); ok {
+			counts[graph] = size.(int64)
+			continue
+		}
+		blob, err := readBlob(blobHash)
+		if err != nil {
+			return nil, err
+		}
+		sizes.Store(blobHash, int64(len(blob)))
+		counts[graph] = int64(len(blob))
+	}
+	return counts, nil
+}
+
+// statsHistory returns the time series of the first-parent history of tip,
+// oldest commit first. Commits are counted in parallel.
+func statsHistory(tip string) ([]statsPoint, error) {
+	var sizes sync.Map
+	var points []statsPoint
+	err := processCommits(firstParentWalk(tip), func(hash string, commit *Commit) (statsPoint, error) {
+		p := statsPoint{Commit: hash, Timestamp: commit.Timestamp, Author: commit.Author}
+		var err error
+		if p.Graphs, err = graphCounts(commit.Tree, &sizes); err != nil {
+			return p, err
+		}
+		for _, n := range p.Graphs {
+			p.TotalQuads += n
+		}
+		stats, err := commitStats(commit)
+		if err != nil {
+			return p, err
+		}
+		p.Added, p.Deleted, p.Churn = stats.Added, stats.Deleted, stats.Added+stats.Deleted
+		return p, nil
+	}, func(hash string, commit *Commit, p statsPoint) error {
+		points = append(points, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(points)
+	return points, nil
+}
+
+// writeStatsCSV writes points with one column per graph that appears
+// anywhere in the history; graphs absent from a commit count as 0.
+func writeStatsCSV(points []statsPoint) error {
+	seen := make(map[string]bool)
+	var graphs []string
+	for _, p := range points {
+		for graph := range p.Graphs {
+			if !seen[graph] {
+				seen[graph] = true
+				graphs = append(graphs, graph)
+			}
+		}
+	}
+	sort.Strings(graphs)
+	w := csv.NewWriter(os.Stdout)
+	header := append([]string{"commit", "timestamp", "author", "total_quads", "added", "deleted", "churn"}, graphs...)
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for _, p := range points {
+		row := []string{
+			p.Commit,
+			p.Timestamp.UTC().Format(time.RFC3339),
+			p.Author,
+			strconv.FormatInt(p.TotalQuads, 10),
+			strconv.Itoa(p.Added),
+			strconv.Itoa(p.Deleted),
+			strconv.Itoa(p.Churn),
+		}
+		for _, graph := range graphs {
+			row = append(row, strconv.FormatInt(p.Graphs[graph], 10))
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+var statsCmd = &cobra.Command{
+	Use:   "stats [<branch>]",
+	Short: "Show quad counts per graph, or their history",
+	Long: `Show the number of quads in each graph of a branch (HEAD by default).
+
+With --history, walk the branch's first-parent history and emit a time
+series with one row per commit, oldest first: the total quad count, the
+quads added and deleted (churn) and the count of every graph. --format
+selects csv (one column per graph) or json, for plotting dataset growth in
+external dashboards.
+
+With --storage, show the health of the storage engine instead: the size of
+the LSM tree and value log, the tables in each LSM level and how far over
+its target size it is, pending compactions and cache hit rates. Writes
+stall when level 0 fills up faster than compaction empties it, so a growing
+level 0 warns of slow commits ahead. --format json prints the same as JSON;
+'quad-db serve' exposes it at /metrics.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		format, _ := cmd.Flags().GetString("format")
+		if format != "csv" && format != "json" {
+			log.Fatalf("Unknown format %q (expected csv or json).", format)
+		}
+		if storage, _ := cmd.Flags().GetBool("storage"); storage {
+			stats := readStorageStats()
+			if format == "json" {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(stats); err != nil {
+					log.Fatal(err)
+				}
+			} else {
+				writeStorageReport(os.Stdout, stats)
+			}
+			return
+		}
+
+		tip, err := resolveHead()
+		if len(args) == 1 {
+			tip, err = resolveCommitish(args[0])
+		}
+		if err != nil {
+			log.Fatalf("Could not resolve commit: %v", err)
+		}
+
+		if history, _ := cmd.Flags().GetBool("history"); history {
+			points, err := statsHistory(tip)
+			if err != nil {
+				log.Fatalf("Failed to read history: %v", err)
+			}
+			if format == "json" {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				err = enc.Encode(points)
+			} else {
+				err = writeStatsCSV(points)
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+
+		commit, err := readCommit(tip)
+		if err != nil {
+			log.Fatal(err)
+		}
+		counts, err := graphCounts(commit.Tree, new(sync.Map))
+		if err != nil {
+			log.Fatalf("Failed to read graphs: %v", err)
+		}
+		graphs := make([]string, 0, len(counts))
+		var total int64
+		for graph, n := range counts {
+			graphs = append(graphs, graph)
+			total += n
+		}
+		sort.Strings(graphs)
+		for _, graph := range graphs {
+			fmt.Printf("%10d  %s\n", counts[graph], graph)
+		}
+		fmt.Printf("%10d  total in %d graph(s)\n", total, len(graphs))
+	},
+}
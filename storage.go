@@ -0,0 +1,147 @@
+// storage.go
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/mannyrivera2010/go-quadgit/internal/lru"
+)
+
+// levelStats describes one level of Badger's LSM tree.
+type levelStats struct {
+	Level  int   `json:"level"`
+	Tables int   `json:"tables"`
+	Size   int64 `json:"size"`
+	Target int64 `json:"target_size"`
+	// Score is how far the level is over its target; at 1 or more it is
+	// due for compaction.
+	Score float64 `json:"score"`
+}
+
+// cacheCounters are the hit and miss counts of one cache.
+type cacheCounters struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+// storageStats is a snapshot of the storage engine's health.
+type storageStats struct {
+	LSMSize  int64        `json:"lsm_size"`
+	VlogSize int64        `json:"vlog_size"`
+	Levels   []levelStats `json:"levels"`
+	// PendingCompactions counts the levels due for compaction, and
+	// Compacting the tables being compacted right now.
+	PendingCompactions int   `json:"pending_compactions"`
+	Compacting         int64 `json:"compacting_tables"`
+	// L0Tables is the number of tables in level 0; writes stall when it
+	// reaches L0StallTables, until compaction catches up.
+	L0Tables      int           `json:"l0_tables"`
+	L0StallTables int           `json:"l0_stall_tables"`
+	BlockCache    cacheCounters `json:"block_cache"`
+	IndexCache    cacheCounters `json:"index_cache"`
+	ObjectCache   lru.Stats     `json:"object_cache"`
+}
+
+// readStorageStats takes a snapshot of the open database's storage stats.
+func readStorageStats() storageStats {
+	var s storageStats
+	s.LSMSize, s.VlogSize = repo.db.Size()
+	for _, l := range repo.db.Levels() {
+		s.Levels = append(s.Levels, levelStats{Level: l.Level, Tables: l.NumTables, Size: l.Size, Target: l.TargetSize, Score: l.Adjusted})
+		if l.Adjusted >= 1 {
+			s.PendingCompactions++
+		}
+		if l.Level == 0 {
+			s.L0Tables = l.NumTables
+		}
+	}
+	s.L0StallTables = repo.db.Opts().NumLevelZeroTablesStall
+	// Badger publishes the rest of its counters only as expvars.
+	if v, ok := expvar.Get("badger_v4_compaction_current_num_lsm").(*expvar.Int); ok {
+		s.Compacting = v.Value()
+	}
+	if m := repo.db.BlockCacheMetrics(); m != nil {
+		s.BlockCache = cacheCounters{m.Hits(), m.Misses()}
+	}
+	if m := repo.db.IndexCacheMetrics(); m != nil {
+		s.IndexCache = cacheCounters{m.Hits(), m.Misses()}
+	}
+	s.ObjectCache = repo.cacheStats()
+	return s
+}
+
+// hitRate is the fraction of lookups that hit, or 0 before any lookup.
+func (c cacheCounters) hitRate() float64 {
+	if c.Hits+c.Misses == 0 {
+		return 0
+	}
+	return float64(c.Hits) / float64(c.Hits+c.Misses)
+}
+
+// writeStorageReport writes the snapshot for people.
+func writeStorageReport(w io.Writer, s storageStats) {
+	fmt.Fprintf(w, "LSM tree:   %s\n", formatBytes(s.LSMSize))
+	fmt.Fprintf(w, "Value log:  %s\n", formatBytes(s.VlogSize))
+	fmt.Fprintf(w, "\n%-6s %7s %12s %12s %6s\n", "Level", "Tables", "Size", "Target", "Score")
+	for _, l := range s.Levels {
+		fmt.Fprintf(w, "L%-5d %7d %12s %12s %6.2f\n", l.Level, l.Tables, formatBytes(l.Size), formatBytes(l.Target), l.Score)
+	}
+	fmt.Fprintf(w, "\nCompactions:  %d level(s) pending, %d table(s) compacting\n", s.PendingCompactions, s.Compacting)
+	fmt.Fprintf(w, "Level 0:      %d table(s); writes stall at %d\n", s.L0Tables, s.L0StallTables)
+	object := cacheCounters{s.ObjectCache.Hits, s.ObjectCache.Misses}
+	for _, c := range []struct {
+		name string
+		cacheCounters
+	}{{"Block cache", s.BlockCache}, {"Index cache", s.IndexCache}, {"Object cache", object}} {
+		fmt.Fprintf(w, "%-13s %5.1f%% hits (%d of %d lookups)\n", c.name+":", 100*c.hitRate(), c.Hits, c.Hits+c.Misses)
+	}
+	if s.L0Tables >= s.L0StallTables/2 {
+		fmt.Fprintf(w, "\nwarning: level 0 is filling up; commits will stall if compaction does not catch up.\n")
+	}
+}
+
+// writeMetrics writes the snapshot in the Prometheus text format.
+func writeMetrics(w io.Writer, s storageStats) {
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(w, "# HELP quad_db_%s %s\n# TYPE quad_db_%s %s\n", name, help, name, kind)
+	}
+	metric("storage_bytes", "gauge", "Disk space taken by the LSM tree and the value log.")
+	fmt.Fprintf(w, "quad_db_storage_bytes{part=\"lsm\"} %d\n", s.LSMSize)
+	fmt.Fprintf(w, "quad_db_storage_bytes{part=\"vlog\"} %d\n", s.VlogSize)
+	metric("lsm_level_tables", "gauge", "Tables in each LSM level.")
+	for _, l := range s.Levels {
+		fmt.Fprintf(w, "quad_db_lsm_level_tables{level=\"%d\"} %d\n", l.Level, l.Tables)
+	}
+	metric("lsm_level_bytes", "gauge", "Size of each LSM level.")
+	for _, l := range s.Levels {
+		fmt.Fprintf(w, "quad_db_lsm_level_bytes{level=\"%d\"} %d\n", l.Level, l.Size)
+	}
+	metric("lsm_level_score", "gauge", "Size of each LSM level relative to its target; 1 or more is due for compaction.")
+	for _, l := range s.Levels {
+		fmt.Fprintf(w, "quad_db_lsm_level_score{level=\"%d\"} %g\n", l.Level, l.Score)
+	}
+	metric("compactions_pending", "gauge", "LSM levels due for compaction.")
+	fmt.Fprintf(w, "quad_db_compactions_pending %d\n", s.PendingCompactions)
+	metric("compacting_tables", "gauge", "Tables being compacted.")
+	fmt.Fprintf(w, "quad_db_compacting_tables %d\n", s.Compacting)
+	metric("l0_stall_tables", "gauge", "Level-0 table count at which writes stall.")
+	fmt.Fprintf(w, "quad_db_l0_stall_tables %d\n", s.L0StallTables)
+	for _, c := range []struct {
+		name string
+		cacheCounters
+	}{{"block", s.BlockCache}, {"index", s.IndexCache}, {"object", cacheCounters{s.ObjectCache.Hits, s.ObjectCache.Misses}}} {
+		name := c.name + "_cache_lookups_total"
+		metric(name, "counter", fmt.Sprintf("Lookups in the %s cache.", c.name))
+		fmt.Fprintf(w, "quad_db_%s{result=\"hit\"} %d\n", name, c.Hits)
+		fmt.Fprintf(w, "quad_db_%s{result=\"miss\"} %d\n", name, c.Misses)
+	}
+}
+
+// handleMetrics serves the storage stats for Prometheus to scrape.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetrics(w, readStorageStats())
+}
@@ -0,0 +1,321 @@
+// sync.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+	"github.com/spf13/cobra"
+)
+
+// A sync target mirrors one branch into an external SPARQL store. It is
+// configured with sync.<name>.url (the SPARQL UPDATE endpoint) and
+// sync.<name>.branch, and its checkpoint -- the last commit the store is
+// known to hold -- is kept in the ref sync:<name>.
+
+// syncTargets returns the configured sync target names, sorted.
+func syncTargets() ([]string, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, key := range cfg.Keys() {
+		if strings.HasPrefix(key, "sync.") && strings.HasSuffix(key, ".url") {
+			names = append(names, strings.TrimSuffix(strings.TrimPrefix(key, "sync."), ".url"))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// sparqlUpdate renders the changes between two states as a SPARQL UPDATE
+// request, or "" if there are none. Triples in the default graph are written
+// without a GRAPH block.
+//
+// A blank node label means nothing to the store, which gave the node its
+// own identity when it was inserted, so deleted triples with blank nodes
+// cannot be sent as DELETE DATA. They are deleted by pattern instead, one
+// request per graph, with a variable for each blank node that only matches
+// blank nodes; that removes every group of triples shaped like them.
+func sparqlUpdate(diffs []graphDiff) string {
+	block := func(op string, pick func(graphDiff) []string) string {
+		var b strings.Builder
+		for _, d := range diffs {
+			lines := pick(d)
+			if len(lines) == 0 {
+				continue
+			}
+			writeGraphBlock(&b, d.Graph, lines)
+		}
+		if b.Len() == 0 {
+			return ""
+		}
+		return op + " {\n" + b.String() + "}"
+	}
+	var parts []string
+	for _, d := range diffs {
+		if del := blankNodeDelete(d.Graph, d.Deleted); del != "" {
+			parts = append(parts, del)
+		}
+	}
+	if del := block("DELETE DATA", func(d graphDiff) []string { return groundLines(d.Deleted) }); del != "" {
+		parts = append(parts, del)
+	}
+	if ins := block("INSERT DATA", func(d graphDiff) []string { return d.Added }); ins != "" {
+		parts = append(parts, ins)
+	}
+	return strings.Join(parts, " ;\n")
+}
+
+// writeGraphBlock writes lines to b, in a GRAPH block unless graph is the
+// default graph.
+func writeGraphBlock(b *strings.Builder, graph string, lines []string) {
+	if graph != defaultGraph {
+		fmt.Fprintf(b, "  GRAPH %s {\n", graph)
+	}
+	for _, line := range lines {
+		fmt.Fprintf(b, "    %s\n", line)
+	}
+	if graph != defaultGraph {
+		b.WriteString("  }\n")
+	}
+}
+
+// hasBlankNode reports whether a statement has a blank node subject or
+// object.
+func hasBlankNode(q quadstore.Quad) bool {
+	return strings.HasPrefix(q.Subject, "_:") || strings.HasPrefix(q.Object, "_:")
+}
+
+// groundLines returns the lines without blank nodes.
+func groundLines(lines []string) []string {
+	var ground []string
+	for _, line := range lines {
+		if q, err := parseQuad(line); err != nil || !hasBlankNode(q) {
+			ground = append(ground, line)
+		}
+	}
+	return ground
+}
+
+// blankNodeDelete renders the deletion of the lines of a graph that have
+// blank nodes as a DELETE ... WHERE request, or "" if there are none.
+func blankNodeDelete(graph string, lines []string) string {
+	vars := make(map[string]string) // Blank node label -> variable
+	var filters []string
+	variable := func(term string) string {
+		if !strings.HasPrefix(term, "_:") {
+			return term
+		}
+		if _, ok := vars[term]; !ok {
+			vars[term] = fmt.Sprintf("?b%d", len(vars))
+			filters = append(filters, "FILTER(isBlank("+vars[term]+"))")
+		}
+		return vars[term]
+	}
+	var patterns []string
+	for _, line := range lines {
+		q, err := parseQuad(line)
+		if err != nil || !hasBlankNode(q) {
+			continue
+		}
+		patterns = append(patterns, variable(q.Subject)+" "+q.Predicate+" "+variable(q.Object)+" .")
+	}
+	if len(patterns) == 0 {
+		return ""
+	}
+	var template, where strings.Builder
+	writeGraphBlock(&template, graph, patterns)
+	writeGraphBlock(&where, graph, append(patterns, filters...))
+	return "DELETE {\n" + template.String() + "} WHERE {\n" + where.String() + "}"
+}
+
+// postUpdate sends a SPARQL UPDATE request to an endpoint.
+func postUpdate(url, update string) error {
+	resp, err := http.Post(url, "application/sparql-update", strings.NewReader(update))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("endpoint returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// syncPending returns the commits a target still has to receive: the
+// first-parent history of tip after the checkpoint, oldest first. If the
+// checkpoint is not on that history, tip alone is returned, which replays
+// everything since the checkpoint as a single delta.
+func syncPending(checkpoint, tip string) ([]string, error) {
+	var pending []string
+	for hash := tip; hash != checkpoint; {
+		pending = append(pending, hash)
+		commit, err := readCommit(hash)
+		if err != nil {
+			return nil, err
+		}
+		if len(commit.Parents) == 0 {
+			if checkpoint == "" {
+				break
+			}
+			return []string{tip}, nil
+		}
+		hash = commit.Parents[0]
+	}
+	for i, j := 0, len(pending)-1; i < j; i, j = i+1, j-1 {
+		pending[i], pending[j] = pending[j], pending[i]
+	}
+	if checkpoint == "" && len(pending) > 0 {
+		// A target without a checkpoint is loaded with a snapshot of tip
+		return pending[len(pending)-1:], nil
+	}
+	return pending, nil
+}
+
+// syncTarget sends every commit the named target has not yet received, one
+// SPARQL UPDATE per commit, advancing its checkpoint after each success so
+// that a failed sync resumes where it stopped. It returns the number of
+// commits sent.
+func syncTarget(name string) (int, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return 0, err
+	}
+	url := cfg.Get("sync." + name + ".url")
+	if url == "" {
+		return 0, fmt.Errorf("no sync target named %s (add one with 'quad-db sync add')", name)
+	}
+	branch := cfg.Get("sync." + name + ".branch")
+	if branch == "" {
+		branch = "main"
+	}
+	tip, err := getReference("head:" + branch)
+	if err != nil {
+		return 0, fmt.Errorf("could not resolve branch %s: %v", branch, err)
+	}
+	checkpoint, _ := getReference("sync:" + name)
+	pending, err := syncPending(checkpoint, tip)
+	if err != nil {
+		return 0, err
+	}
+
+	before := map[string]quadSet{}
+	if checkpoint != "" {
+		if before, err = loadState(checkpoint); err != nil {
+			return 0, err
+		}
+	}
+	for i, hash := range pending {
+		after, err := loadState(hash)
+		if err != nil {
+			return i, err
+		}
+		diffs, err := diffStates(context.Background(), before, after)
+		if err != nil {
+			return i, err
+		}
+		if update := sparqlUpdate(diffs); update != "" {
+			if err := postUpdate(url, update); err != nil {
+				return i, fmt.Errorf("syncing %s: %v", hash[:7], err)
+			}
+		}
+		if err := setReference("sync:"+name, hash); err != nil {
+			return i, err
+		}
+		before = after
+	}
+	return len(pending), nil
+}
+
+// syncAfterCommit syncs every target that follows branch. Failures are
+// reported but do not undo the commit; the next sync replays from the
+// target's checkpoint.
+func syncAfterCommit(branch string) {
+	names, err := syncTargets()
+	if err != nil {
+		return
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return
+	}
+	for _, name := range names {
+		target := cfg.Get("sync." + name + ".branch")
+		if target == "" {
+			target = "main"
+		}
+		if target != branch {
+			continue
+		}
+		if _, err := syncTarget(name); err != nil {
+			fmt.Printf("warning: sync to %s failed: %v (retry with 'quad-db sync %s')\n", name, err, name)
+		}
+	}
+}
+
+var syncCmd = &cobra.Command{
+	Use:   "sync [add <name> <url> | <name>]",
+	Short: "Mirror a branch into an external SPARQL store",
+	Long: `Push each new commit on a branch to an external store (Fuseki, Blazegraph,
+GraphDB, ...) as a SPARQL UPDATE delta.
+
+  sync                      list targets and their checkpoints
+  sync add <name> <url>     add a target following --branch
+  sync <name>               send the commits the target has not received
+
+Targets are also synced after every commit or merge on their branch. A
+failed update leaves the checkpoint at the last commit the store accepted,
+so the next sync replays from there.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		switch {
+		case len(args) == 0:
+			names, err := syncTargets()
+			if err != nil {
+				log.Fatalf("Failed to load config: %v", err)
+			}
+			cfg, err := loadConfig()
+			if err != nil {
+				log.Fatalf("Failed to load config: %v", err)
+			}
+			for _, name := range names {
+				checkpoint, _ := getReference("sync:" + name)
+				fmt.Printf("%s\t%s\t%s\t%s\n", name, cfg.Get("sync."+name+".branch"), cfg.Get("sync."+name+".url"), shortHash(checkpoint))
+			}
+		case len(args) == 3 && args[0] == "add":
+			cfg, err := loadConfig()
+			if err != nil {
+				log.Fatalf("Failed to load config: %v", err)
+			}
+			branch, _ := cmd.Flags().GetString("branch")
+			if branch == "" {
+				branch = currentBranch()
+			}
+			cfg.Set("sync."+args[1]+".url", args[2])
+			cfg.Set("sync."+args[1]+".branch", branch)
+			if err := cfg.Save(); err != nil {
+				log.Fatalf("Failed to save config: %v", err)
+			}
+		case len(args) == 1:
+			n, err := syncTarget(args[0])
+			if err != nil {
+				log.Fatalf("Sync failed after %d commit(s): %v", n, err)
+			}
+			if n == 0 {
+				fmt.Println("Everything up-to-date")
+				return
+			}
+			fmt.Printf("Synced %d commit(s) to %s\n", n, args[0])
+		default:
+			log.Fatal("Usage: quad-db sync [add <name> <url> | <name>]")
+		}
+	},
+}
@@ -0,0 +1,44 @@
+// sync_test.go
+package main
+
+import "testing"
+
+func TestSparqlUpdate(t *testing.T) {
+	const g = "<http://example.org/g>"
+	tests := []struct {
+		name  string
+		diffs []graphDiff
+		want  string
+	}{
+		{"no changes", []graphDiff{{Graph: g}}, ""},
+		{
+			"ground triples",
+			[]graphDiff{{Graph: g, Added: []string{`<http://example.org/s> <http://example.org/p> "new" .`}, Deleted: []string{`<http://example.org/s> <http://example.org/p> "old" .`}}},
+			"DELETE DATA {\n  GRAPH <http://example.org/g> {\n    <http://example.org/s> <http://example.org/p> \"old\" .\n  }\n} ;\n" +
+				"INSERT DATA {\n  GRAPH <http://example.org/g> {\n    <http://example.org/s> <http://example.org/p> \"new\" .\n  }\n}",
+		},
+		{
+			"default graph",
+			[]graphDiff{{Graph: defaultGraph, Added: []string{`<http://example.org/s> <http://example.org/p> "o" .`}}},
+			"INSERT DATA {\n    <http://example.org/s> <http://example.org/p> \"o\" .\n}",
+		},
+		{
+			"blank nodes deleted by pattern",
+			[]graphDiff{{Graph: g, Deleted: []string{
+				`<http://example.org/s> <http://example.org/address> _:a .`,
+				`_:a <http://example.org/city> "Lyon" .`,
+				`<http://example.org/s> <http://example.org/p> "old" .`,
+			}}},
+			"DELETE {\n  GRAPH <http://example.org/g> {\n    <http://example.org/s> <http://example.org/address> ?b0 .\n    ?b0 <http://example.org/city> \"Lyon\" .\n  }\n} WHERE {\n" +
+				"  GRAPH <http://example.org/g> {\n    <http://example.org/s> <http://example.org/address> ?b0 .\n    ?b0 <http://example.org/city> \"Lyon\" .\n    FILTER(isBlank(?b0))\n  }\n} ;\n" +
+				"DELETE DATA {\n  GRAPH <http://example.org/g> {\n    <http://example.org/s> <http://example.org/p> \"old\" .\n  }\n}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sparqlUpdate(tt.diffs); got != tt.want {
+				t.Errorf("sparqlUpdate() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+// tag.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Tags are references under "tag:" (refs/tags/ to remotes). A lightweight
+// tag points straight at a commit; an annotated one points at a Tag object,
+// which names the commit and records who tagged it, when and why, and
+// optionally a signature.
+
+// readTag reads the annotated tag object hash, reporting false if hash is
+// some other object, such as the commit of a lightweight tag.
+func readTag(hash string) (*Tag, bool, error) {
+	var tag Tag
+	if err := readObject(hash, &tag); err != nil {
+		return nil, false, err
+	}
+	return &tag, tag.Object != "", nil
+}
+
+// createTag points the tag name at target, refusing to move an existing
+// tag unless force is set.
+func createTag(name, target string, force bool) error {
+	if err := validBranchName(name); err != nil {
+		return fmt.Errorf("%q is not a valid tag name", name)
+	}
+	old, err := getReference("tag:" + name)
+	if err == nil && !force {
+		return fmt.Errorf("tag %s already exists", name)
+	}
+	if err != nil {
+		old = ""
+	}
+	return swapReference("tag:"+name, old, target)
+}
+
+// tagSubject returns the first line of an annotated tag's message, or of
+// the subject of the commit a lightweight tag points at.
+func tagSubject(hash string) string {
+	if tag, ok, err := readTag(hash); err == nil && ok {
+		return strings.SplitN(tag.Message, "\n", 2)[0]
+	}
+	if commit, err := readCommit(hash); err == nil {
+		return strings.SplitN(commit.Message, "\n", 2)[0]
+	}
+	return ""
+}
+
+var tagCmd = &cobra.Command{
+	Use:   "tag [<name> [<commit>]]",
+	Short: "Create, list, delete or verify tags",
+	Long: `Without a name, list the tags, in name order; -l <pattern> lists those
+matching a shell pattern such as 'v1.*', and -n adds the first line of each
+tag's message (or of its commit's, for a lightweight tag).
+
+With a name, tag a commit (HEAD by default). A plain tag is lightweight: a
+reference to the commit. With -a, -m or -s it is annotated instead: the
+reference points at a tag object of its own recording the commit, the
+tagger, the time and the message given with -m, and with -s a signature
+made as for commits (see user.signingBackend). An existing tag is only
+moved with -f.
+
+-d deletes tags, and -v verifies the signatures of annotated ones.`,
+	Args: cobra.MaximumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		del, _ := cmd.Flags().GetBool("delete")
+		verify, _ := cmd.Flags().GetBool("verify")
+		switch {
+		case del:
+			if len(args) == 0 {
+				log.Fatal("Usage: quad-db tag -d <name>...")
+			}
+			for _, name := range args {
+				hash, err := getReference("tag:" + name)
+				if err != nil {
+					log.Fatalf("Tag %s not found.", name)
+				}
+				if err := deleteReference("tag:" + name); err != nil {
+					log.Fatalf("Failed to delete tag %s: %v", name, err)
+				}
+				fmt.Printf("Deleted tag %s (was %s)\n", name, shortHash(hash))
+			}
+			return
+		case verify:
+			if len(args) == 0 {
+				log.Fatal("Usage: quad-db tag -v <name>...")
+			}
+			for _, name := range args {
+				hash, err := getReference("tag:" + name)
+				if err != nil {
+					log.Fatalf("Tag %s not found.", name)
+				}
+				tag, ok, err := readTag(hash)
+				if err != nil {
+					log.Fatalf("Failed to read tag %s: %v", name, err)
+				}
+				if !ok {
+					log.Fatalf("Tag %s is lightweight and cannot be verified.", name)
+				}
+				if err := verifyTag(tag); err != nil {
+					log.Fatalf("Tag %s does not verify: %v", name, err)
+				}
+				fmt.Printf("Tag %s: good signature by %s\n", name, tag.Tagger)
+			}
+			return
+		case len(args) == 0:
+			listTags(cmd)
+			return
+		}
+
+		name := args[0]
+		target, err := resolveHead()
+		if len(args) == 2 {
+			target, err = resolveCommitish(args[1])
+		}
+		if err != nil {
+			log.Fatalf("Could not resolve commit: %v", err)
+		}
+		message, _ := cmd.Flags().GetString("message")
+		annotate, _ := cmd.Flags().GetBool("annotate")
+		sign, _ := cmd.Flags().GetBool("sign")
+		if annotate || sign || message != "" {
+			if strings.TrimSpace(message) == "" {
+				log.Fatal("An annotated tag needs a message (-m).")
+			}
+			tag := Tag{Object: target, Type: "commit", Name: name, Tagger: commitAuthor(), Message: message, Timestamp: clock.Now()}
+			if sign {
+				if err := signTag(&tag); err != nil {
+					log.Fatalf("Failed to sign tag: %v", err)
+				}
+			}
+			if target, err = writeObject(tag); err != nil {
+				log.Fatalf("Failed to write tag: %v", err)
+			}
+		}
+		force, _ := cmd.Flags().GetBool("force")
+		if err := createTag(name, target, force); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// listTags prints the tags as 'tag' without a name does.
+func listTags(cmd *cobra.Command) {
+	pattern, _ := cmd.Flags().GetString("list")
+	withSubjects, _ := cmd.Flags().GetBool("subjects")
+	tags, err := listReferences("tag:")
+	if err != nil {
+		log.Fatalf("Failed to list tags: %v", err)
+	}
+	names := make([]string, 0, len(tags))
+	for ref := range tags {
+		name := strings.TrimPrefix(ref, "tag:")
+		if pattern != "" {
+			if ok, err := path.Match(pattern, name); err != nil {
+				log.Fatalf("Invalid pattern %q: %v", pattern, err)
+			} else if !ok {
+				continue
+			}
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if withSubjects {
+			fmt.Printf("%-15s %s\n", name, tagSubject(tags["tag:"+name]))
+		} else {
+			fmt.Println(name)
+		}
+	}
+}
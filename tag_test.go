@@ -0,0 +1,85 @@
+// tag_test.go
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// annotatedTag tags target as name with an annotated tag and returns the
+// tag object's hash.
+func annotatedTag(t *testing.T, name, target string) string {
+	t.Helper()
+	hash, err := writeObject(Tag{Object: target, Type: "commit", Name: name, Tagger: "Test", Message: "release", Timestamp: clock.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := createTag(name, hash, false); err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func TestAnnotatedTagIsNotACommit(t *testing.T) {
+	newTestRepo(t)
+	head, err := resolveHead()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag := annotatedTag(t, "v1.0", head)
+	if _, err := readCommit(tag); err == nil {
+		t.Error("readCommit read a tag object as a commit")
+	}
+	if peeled, err := peelTag(tag); err != nil || peeled != head {
+		t.Errorf("peelTag = %s, %v, want %s", peeled, err, head)
+	}
+	if reachable, err := reachableFrom([]string{tag}); err != nil || !reachable[head] || reachable[tag] {
+		t.Errorf("reachableFrom the tag = %v, %v, want its commit", reachable, err)
+	}
+	if mismatches, err := checksumMismatches(); err != nil || len(mismatches) > 0 {
+		t.Errorf("fsck checksums: %v, %v", mismatches, err)
+	}
+}
+
+func TestRedactAnnotatedTag(t *testing.T) {
+	newTestRepo(t)
+	head, err := resolveHead()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const secret = `<http://example.org/alice> <http://example.org/ssn> "123-45-6789" <http://example.org/g> .`
+	commit := writeTestCommit(t, map[string][]string{"<http://example.org/g>": {secret}}, head)
+	if err := setReference("head:main", commit); err != nil {
+		t.Fatal(err)
+	}
+	oldTag := annotatedTag(t, "v1.0", commit)
+
+	r := &redactor{preds: map[string]bool{"<http://example.org/ssn>": true}, remove: true, blobs: make(map[string]string), dirty: make(map[string]bool)}
+	refs := map[string]string{"head:main": commit, "tag:v1.0": oldTag}
+	moved, _, err := r.redactRefs(refs, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newTag, ok := moved["tag:v1.0"]
+	if !ok {
+		t.Fatalf("the tag was not moved: %v", moved)
+	}
+	tag, annotated, err := readTag(newTag)
+	if err != nil || !annotated {
+		t.Fatalf("v1.0 no longer points at an annotated tag: %v", err)
+	}
+	if tag.Object != moved["head:main"] || tag.Name != "v1.0" || tag.Message != "release" {
+		t.Errorf("rewritten tag %+v, want one of %s", tag, moved["head:main"])
+	}
+	state, err := loadState(tag.Object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for graph, set := range state {
+		for line := range set {
+			if strings.Contains(line, "123-45-6789") {
+				t.Errorf("the tagged commit still holds %s in %s", line, graph)
+			}
+		}
+	}
+}
@@ -0,0 +1,303 @@
+// template.go
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mannyrivera2010/go-quadgit/pkg/quadstore"
+	"github.com/mannyrivera2010/go-quadgit/pkg/rdfio"
+	"gopkg.in/yaml.v2"
+)
+
+// A repository template standardizes how new dataset repositories start:
+// 'init --template' seeds the first commit with its graphs (SHACL shapes,
+// ontologies) and its prefix registry, applies its settings, copies its
+// working files such as .quadignore and .quadowners, and lays out its
+// branches. A template is a directory holding template.yaml and the files
+// it names, or the URL of a template.yaml, whose files are then fetched
+// relative to it. A bare name is looked up in the template directory.
+
+const templateFile = "template.yaml"
+
+// prefixesGraph holds the prefix registry: one sh:declare per prefix, as
+// SHACL-SPARQL declares the prefixes its queries use.
+const prefixesGraph = "<quadgit://prefixes>"
+
+// A repoTemplate is a parsed template.yaml.
+type repoTemplate struct {
+	Description string `yaml:"description"`
+	// Message is the seed commit's message.
+	Message  string            `yaml:"message"`
+	Prefixes map[string]string `yaml:"prefixes"`
+	Graphs   []templateGraph   `yaml:"graphs"`
+	// Config is applied after the seed commit, so that policies such as
+	// policy.requireSigned govern what follows but not the seed itself.
+	Config map[string]string `yaml:"config"`
+	// Files are copied into the working directory, keeping their names.
+	Files []string `yaml:"files"`
+	// DefaultBranch replaces main as the branch HEAD starts on; Branches
+	// are created alongside it at the seed commit.
+	DefaultBranch string   `yaml:"default_branch"`
+	Branches      []string `yaml:"branches"`
+
+	name string
+	base string // The directory or URL the template's files are relative to
+}
+
+// A templateGraph is a graph the seed commit holds: N-Quads, N-Triples or
+// (for .ttl files) Turtle, with statements without a graph term put in
+// Graph.
+type templateGraph struct {
+	Graph string `yaml:"graph"`
+	File  string `yaml:"file"`
+}
+
+// templatesDir is where templates named without a path are looked up:
+// $QUAD_DB_TEMPLATES, or ~/.quad-db/templates.
+func templatesDir() string {
+	if dir := os.Getenv("QUAD_DB_TEMPLATES"); dir != "" {
+		return dir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".quad-db", "templates")
+}
+
+func isURL(ref string) bool {
+	return strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://")
+}
+
+// loadTemplate finds a template by URL, path or name and checks it.
+func loadTemplate(ref string) (*repoTemplate, error) {
+	t := &repoTemplate{name: ref}
+	switch {
+	case isURL(ref):
+		t.base = ref
+		if !strings.HasSuffix(ref, ".yaml") && !strings.HasSuffix(ref, ".yml") {
+			t.base = strings.TrimSuffix(ref, "/") + "/" + templateFile
+		}
+	case strings.ContainsRune(ref, filepath.Separator) || strings.HasPrefix(ref, "."):
+		t.base = ref
+	default:
+		t.base = filepath.Join(templatesDir(), ref)
+	}
+	if !isURL(t.base) {
+		if info, err := os.Stat(t.base); err != nil {
+			return nil, fmt.Errorf("no template %s: %v", ref, err)
+		} else if info.IsDir() {
+			t.base = filepath.Join(t.base, templateFile)
+		}
+	}
+
+	r, err := t.open(t.base)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.UnmarshalStrict(data, t); err != nil {
+		return nil, fmt.Errorf("%s: %v", t.base, err)
+	}
+	for prefix, ns := range t.Prefixes {
+		if strings.ContainsAny(prefix, ": ") || ns == "" {
+			return nil, fmt.Errorf("%s: invalid prefix %q: %q", t.base, prefix, ns)
+		}
+	}
+	for i, g := range t.Graphs {
+		if g.Graph == "" || g.File == "" {
+			return nil, fmt.Errorf("%s: graph %d needs both graph and file", t.base, i+1)
+		}
+	}
+	for _, name := range append([]string{t.DefaultBranch}, t.Branches...) {
+		if name == "" {
+			continue
+		}
+		if err := validBranchName(name); err != nil {
+			return nil, fmt.Errorf("%s: %v", t.base, err)
+		}
+	}
+	for _, name := range t.Files {
+		if filepath.IsAbs(name) || strings.HasPrefix(filepath.Clean(name), "..") {
+			return nil, fmt.Errorf("%s: file %s is outside the working directory", t.base, name)
+		}
+	}
+	return t, nil
+}
+
+// open opens a file of the template, named relative to template.yaml.
+func (t *repoTemplate) open(name string) (io.ReadCloser, error) {
+	if !isURL(t.base) {
+		if name != t.base && !filepath.IsAbs(name) {
+			name = filepath.Join(filepath.Dir(t.base), name)
+		}
+		return os.Open(name)
+	}
+	base, err := url.Parse(t.base)
+	if err != nil {
+		return nil, err
+	}
+	ref, err := url.Parse(name)
+	if err != nil {
+		return nil, err
+	}
+	u := base.ResolveReference(ref).String()
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: server returned %s", u, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// state reads the template's graphs, and its prefix registry, into the
+// state the seed commit records.
+func (t *repoTemplate) state() (map[string]quadSet, error) {
+	state := make(map[string]quadSet)
+	for _, g := range t.Graphs {
+		graph := normalizeGraphName(g.Graph)
+		if graph == defaultGraph {
+			graph = ""
+		}
+		r, err := t.open(g.File)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasSuffix(g.File, ".ttl") {
+			quads, err := rdfio.ParseTurtle(r, "")
+			r.Close()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", g.File, err)
+			}
+			for _, q := range quads {
+				addQuad(state, q, graph)
+			}
+			continue
+		}
+		lines, err := readQuadLines(r, g.File, graph)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range lines {
+			if err := addQuadLine(state, line, ""); err != nil {
+				return nil, fmt.Errorf("%s: %v", g.File, err)
+			}
+		}
+	}
+	for _, q := range prefixDeclarations(t.Prefixes) {
+		addQuad(state, q, prefixesGraph)
+	}
+	return state, nil
+}
+
+// prefixDeclarations returns the statements registering prefixes, each an
+// sh:declare of the registry with an sh:prefix and sh:namespace.
+func prefixDeclarations(prefixes map[string]string) []quadstore.Quad {
+	names := make([]string, 0, len(prefixes))
+	for prefix := range prefixes {
+		names = append(names, prefix)
+	}
+	sort.Strings(names)
+	registry := strings.Trim(prefixesGraph, "<>")
+	var quads []quadstore.Quad
+	for _, prefix := range names {
+		decl := "<" + registry + "#" + prefix + ">"
+		quads = append(quads,
+			quadstore.Quad{Subject: "<" + registry + ">", Predicate: shTerm("declare"), Object: decl},
+			quadstore.Quad{Subject: decl, Predicate: shTerm("prefix"), Object: rdfio.QuoteString(prefix)},
+			quadstore.Quad{Subject: decl, Predicate: shTerm("namespace"), Object: rdfio.QuoteString(prefixes[prefix]) + "^^<" + xsd + "anyURI>"},
+		)
+	}
+	return quads
+}
+
+// apply seeds the freshly initialized repository from the template: the
+// seed commit on main, then the settings, working files and branches.
+func (t *repoTemplate) apply() error {
+	state, err := t.state()
+	if err != nil {
+		return err
+	}
+	message := t.Message
+	if message == "" {
+		message = "Initialize from template " + t.name
+	}
+	seed, err := commitReplacingGraphs("main", "", state, message, map[string]string{"template": t.name}, actingIdentity())
+	if err != nil {
+		return fmt.Errorf("failed to commit the template's graphs: %v", err)
+	}
+	if seed == "" {
+		if seed, err = getReference("head:main"); err != nil {
+			return err
+		}
+	}
+
+	if len(t.Config) > 0 {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		for key, value := range t.Config {
+			cfg.Set(key, value)
+		}
+		if err := cfg.Save(); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range t.Files {
+		if _, err := os.Stat(name); err == nil {
+			fmt.Printf("Kept the existing %s\n", name)
+			continue
+		}
+		r, err := t.open(name)
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		if dir := filepath.Dir(name); dir != "." {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+		}
+		if err := os.WriteFile(name, data, 0644); err != nil {
+			return err
+		}
+	}
+
+	if t.DefaultBranch != "" && t.DefaultBranch != "main" {
+		if err := renameReference("head:main", "head:"+t.DefaultBranch); err != nil {
+			return err
+		}
+	}
+	for _, name := range t.Branches {
+		if name == t.DefaultBranch || name == "main" && t.DefaultBranch == "" {
+			continue
+		}
+		if err := createBranch(name, seed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
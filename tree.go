@@ -0,0 +1,227 @@
+// tree.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// graphPath splits a graph name into the segments it is stored under.
+// Hierarchical IRIs such as <http://example.org/datasets/foo> become
+// ["http://example.org", "datasets", "foo"]; any other name (the default
+// graph, blank nodes, URNs) is a single segment.
+func graphPath(graph string) []string {
+	iri := strings.TrimSuffix(strings.TrimPrefix(graph, "<"), ">")
+	i := strings.Index(iri, "://")
+	if !strings.HasPrefix(graph, "<") || i < 0 {
+		return []string{graph}
+	}
+	segments := strings.Split(iri[i+3:], "/")
+	segments[0] = iri[:i+3] + segments[0]
+	return segments
+}
+
+// graphName is the inverse of graphPath.
+func graphName(segments []string) string {
+	if strings.HasPrefix(segments[0], "<") || !strings.Contains(segments[0], "://") {
+		return segments[0]
+	}
+	return "<" + strings.Join(segments, "/") + ">"
+}
+
+// graphPrefixPath turns a graph scope such as "http://example.org/datasets/*"
+// or "<http://example.org/datasets/foo>" into the segments of the subtree it
+// names.
+func graphPrefixPath(prefix string) []string {
+	prefix = strings.TrimSuffix(strings.TrimSuffix(prefix, "*"), "/")
+	if !strings.HasPrefix(prefix, "<") && strings.Contains(prefix, "://") {
+		prefix = "<" + prefix
+	}
+	if strings.HasPrefix(prefix, "<") && !strings.HasSuffix(prefix, ">") {
+		prefix += ">"
+	}
+	return graphPath(prefix)
+}
+
+// graphInScope reports whether a graph is selected by a scope: an exact graph
+// name, or a prefix ending in "*" that selects a whole subtree.
+func graphInScope(graph, scope string) bool {
+	if !strings.HasSuffix(scope, "*") {
+		return graph == normalizeGraphName(scope)
+	}
+	path, prefix := graphPath(graph), graphPrefixPath(scope)
+	if len(path) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if path[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// treeNode is the in-memory form of a tree while it is being built.
+type treeNode struct {
+	blob     string
+	summary  graphSummary
+	children map[string]*treeNode
+}
+
+// writeTree stores a graph-name to blob-hash mapping as a hierarchy of tree
+// objects and returns the hash of the root. Identical subtrees hash
+// identically, so unchanged dataset branches are shared between commits.
+func writeTree(graphs map[string]string) (string, error) {
+	return buildTree(graphs, writeObject)
+}
+
+// buildTree is writeTree with the tree objects passed to put, which returns
+// their hashes, instead of being stored.
+func buildTree(graphs map[string]string, put func(obj interface{}) (string, error)) (string, error) {
+	root := &treeNode{children: make(map[string]*treeNode)}
+	for graph, blobHash := range graphs {
+		node := root
+		for _, segment := range graphPath(graph) {
+			child, ok := node.children[segment]
+			if !ok {
+				child = &treeNode{children: make(map[string]*treeNode)}
+				node.children[segment] = child
+			}
+			node = child
+		}
+		sum, err := blobSummary(blobHash)
+		if err != nil {
+			return "", err
+		}
+		node.blob, node.summary = blobHash, sum
+	}
+	return writeTreeNode(root, put)
+}
+
+func writeTreeNode(node *treeNode, put func(obj interface{}) (string, error)) (string, error) {
+	tree := make(Tree, len(node.children))
+	for segment, child := range node.children {
+		entry := TreeEntry{Blob: child.blob}
+		if child.blob != "" {
+			entry.Checksum, entry.Quads = child.summary.Checksum, child.summary.Quads
+		}
+		if len(child.children) > 0 {
+			hash, err := writeTreeNode(child, put)
+			if err != nil {
+				return "", err
+			}
+			entry.Tree = hash
+		}
+		tree[segment] = entry
+	}
+	return put(tree)
+}
+
+// walkTree calls fn for every entry reachable from the tree at hash, passing
+// the segments leading to it.
+func walkTree(hash string, fn func(path []string, entry TreeEntry) error) error {
+	return walkTreeFrom(hash, nil, fn)
+}
+
+func walkTreeFrom(hash string, path []string, fn func(path []string, entry TreeEntry) error) error {
+	tree, err := readTree(hash)
+	if err != nil {
+		return err
+	}
+	for segment, entry := range tree {
+		entryPath := append(append([]string{}, path...), segment)
+		if err := fn(entryPath, entry); err != nil {
+			return err
+		}
+		if entry.Tree != "" {
+			if err := walkTreeFrom(entry.Tree, entryPath, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readGraphs returns the graph-name to blob-hash mapping stored in a tree.
+func readGraphs(treeHash string) (map[string]string, error) {
+	return readGraphsUnder(treeHash, "")
+}
+
+// readGraphsUnder returns only the graphs stored at or below a graph prefix
+// (see graphPrefixPath), reading nothing outside that subtree. An empty
+// prefix selects every graph.
+func readGraphsUnder(treeHash, prefix string) (map[string]string, error) {
+	graphs := make(map[string]string)
+	var path []string
+	if prefix != "" {
+		path = graphPrefixPath(prefix)
+	}
+
+	hash := treeHash
+	var entry TreeEntry
+	for i, segment := range path {
+		tree, err := readTree(hash)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if entry, ok = tree[segment]; !ok {
+			return graphs, nil
+		}
+		if i < len(path)-1 && entry.Tree == "" {
+			return graphs, nil
+		}
+		hash = entry.Tree
+	}
+	if entry.Blob != "" {
+		graphs[graphName(path)] = entry.Blob
+	}
+	if hash == "" {
+		return graphs, nil
+	}
+	err := walkTreeFrom(hash, path, func(p []string, e TreeEntry) error {
+		if e.Blob != "" {
+			graphs[graphName(p)] = e.Blob
+		}
+		return nil
+	})
+	return graphs, err
+}
+
+var lsTreeCmd = &cobra.Command{
+	Use:   "ls-tree [<commit>]",
+	Short: "List the graphs stored in a commit",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		rev := "HEAD"
+		if len(args) == 1 {
+			rev = args[0]
+		}
+		hash, err := resolveCommitish(rev)
+		if err != nil {
+			log.Fatalf("Could not resolve %s: %v", rev, err)
+		}
+		commit, err := readCommit(hash)
+		if err != nil {
+			log.Fatalf("Failed to read commit: %v", err)
+		}
+		prefix, _ := cmd.Flags().GetString("prefix")
+		graphs, err := readGraphsUnder(commit.Tree, prefix)
+		if err != nil {
+			log.Fatalf("Failed to read tree: %v", err)
+		}
+
+		names := make([]string, 0, len(graphs))
+		for name := range graphs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("%s\t%s\n", graphs[name], name)
+		}
+	},
+}
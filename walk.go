@@ -0,0 +1,135 @@
+// walk.go
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// historyWorkers returns how many commits history analyses process at once:
+// core.historyWorkers, or one per core.
+func historyWorkers() int {
+	if cfg, err := loadConfig(); err == nil {
+		if v := cfg.Get("core.historyWorkers"); v != "" {
+			if n, err := strconv.Atoi(v); err == nil && n > 0 {
+				return n
+			}
+			fmt.Fprintf(os.Stderr, "warning: invalid core.historyWorkers %q (use a positive number)\n", v)
+		}
+	}
+	return runtime.NumCPU()
+}
+
+// processCommits runs work on every commit that walk visits, on
+// historyWorkers goroutines, and passes each result to emit in the order
+// walk visited the commits. work must only read the repository. If emit
+// returns errStopWalk, the walk stops without error; any other error from
+// walk, work or emit stops it and is returned. At most a few results per
+// worker are held waiting for their turn, so long histories are processed
+// in bounded memory.
+func processCommits[T any](
+	walk func(visit func(hash string, commit *Commit) error) error,
+	work func(hash string, commit *Commit) (T, error),
+	emit func(hash string, commit *Commit, result T) error,
+) error {
+	type job struct {
+		seq    int
+		hash   string
+		commit *Commit
+	}
+	type result struct {
+		job
+		value T
+		err   error
+	}
+
+	// Load lazily initialized state here rather than in racing workers
+	replacementFor("")
+
+	workers := historyWorkers()
+	jobs := make(chan job)
+	results := make(chan result, workers)
+	slots := make(chan struct{}, 4*workers) // Jobs in flight or awaiting emit
+	done := make(chan struct{})
+	defer close(done)
+
+	// 1. Walk history, handing out commits in order
+	walkErr := make(chan error, 1)
+	go func() {
+		defer close(jobs)
+		seq := 0
+		walkErr <- walk(func(hash string, commit *Commit) error {
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return errStopWalk
+			}
+			select {
+			case jobs <- job{seq, hash, commit}:
+			case <-done:
+				return errStopWalk
+			}
+			seq++
+			return nil
+		})
+	}()
+
+	// 2. Process commits in parallel
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				value, err := work(j.hash, j.commit)
+				select {
+				case results <- result{j, value, err}:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// 3. Emit results in walk order
+	pending := make(map[int]result)
+	next := 0
+	for r := range results {
+		pending[r.seq] = r
+		for {
+			r, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			<-slots
+			if r.err != nil {
+				return r.err
+			}
+			if err := emit(r.hash, r.commit, r.value); err == errStopWalk {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+	if err := <-walkErr; err != nil && err != errStopWalk {
+		return err
+	}
+	return nil
+}
+
+// firstParentWalk walks the first-parent history of tip, for processCommits.
+func firstParentWalk(tip string) func(visit func(hash string, commit *Commit) error) error {
+	return func(visit func(hash string, commit *Commit) error) error {
+		return walkCommits(tip, visit)
+	}
+}